@@ -0,0 +1,342 @@
+package catalog
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrPrincipalExists   = errors.New("catalog: user or role already exists")
+	ErrPrincipalNotFound = errors.New("catalog: user or role not found")
+	ErrNotRole           = errors.New("catalog: not a role")
+	ErrNotUser           = errors.New("catalog: not a user")
+	ErrAuthFailed        = errors.New("catalog: wrong user name or password")
+	ErrReservedName      = errors.New("catalog: name is reserved")
+)
+
+// KeyValue is the object name grants use for the key-value namespace of a
+// database. No table can take it.
+const KeyValue = "@kv"
+
+// Privilege is a set of operations a principal may run on a table.
+type Privilege uint8
+
+const (
+	PrivSelect Privilege = 1 << iota
+	PrivInsert
+	PrivUpdate
+	PrivDelete
+
+	PrivAll = PrivSelect | PrivInsert | PrivUpdate | PrivDelete
+)
+
+var privilegeNames = []string{"SELECT", "INSERT", "UPDATE", "DELETE"}
+
+func (p Privilege) String() string {
+	if p == 0 {
+		return "NONE"
+	}
+	var names []string
+	for i, name := range privilegeNames {
+		if p&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := p &^ PrivAll; rest != 0 {
+		names = append(names, fmt.Sprintf("Privilege(%d)", int(rest)))
+	}
+	return strings.Join(names, ", ")
+}
+
+// Principal is a user, who logs in with a password, or a role, which only
+// bundles grants for users to be given. A superuser may do anything,
+// including creating tables, which no grant allows.
+type Principal struct {
+	Name      string `json:"name"`
+	Role      bool   `json:"role,omitempty"`
+	Superuser bool   `json:"superuser,omitempty"`
+	// Salt, Iterations and Hash are the PBKDF2-SHA256 hash of the password
+	// of a user.
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Hash       []byte `json:"hash,omitempty"`
+	// Roles are the roles a user was granted.
+	Roles []string `json:"roles,omitempty"`
+	// Grants maps tables, and KeyValue, to the privileges granted on them.
+	Grants map[string]Privilege `json:"grants,omitempty"`
+}
+
+// hashIterations is the PBKDF2 work factor of new passwords. Hashes keep
+// their own, so it can be raised without breaking stored ones.
+var hashIterations = 600_000
+
+func hashPassword(password string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+}
+
+// dummySalt and dummyHash stand in for the password of a user that does
+// not exist, so turning one away costs as much as a wrong password and the
+// time taken does not tell which user names are taken.
+var (
+	dummySalt = make([]byte, 16)
+	dummyHash = make([]byte, sha256.Size)
+)
+
+// RejectPassword hashes password as CheckPassword would and returns
+// ErrAuthFailed whatever the outcome. Callers that look a user up
+// themselves run it for a user that does not exist.
+func RejectPassword(password string) error {
+	if hash, err := hashPassword(password, dummySalt, hashIterations); err == nil {
+		subtle.ConstantTimeCompare(hash, dummyHash)
+	}
+	return ErrAuthFailed
+}
+
+func principalKey(name string) []byte {
+	return []byte("principal:" + name)
+}
+
+func (m *Manager) putPrincipal(p *Principal) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(principalKey(p.Name), data)
+}
+
+// GetPrincipal returns the user or role with the given name.
+func (m *Manager) GetPrincipal(name string) (*Principal, error) {
+	data, found, err := m.store.Get(principalKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("catalog: failed to retrieve principal: %w", err)
+	}
+	if !found {
+		return nil, ErrPrincipalNotFound
+	}
+
+	var p Principal
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("catalog: failed to deserialize principal: %w", err)
+	}
+	return &p, nil
+}
+
+// Principals returns the names of all users and roles in sorted order.
+func (m *Manager) Principals() ([]string, error) {
+	return m.names("principal:")
+}
+
+// CreateUser adds a user that logs in with password.
+func (m *Manager) CreateUser(name, password string, superuser bool) error {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	hash, err := hashPassword(password, salt, hashIterations)
+	if err != nil {
+		return err
+	}
+	return m.createPrincipal(&Principal{
+		Name:       name,
+		Superuser:  superuser,
+		Salt:       salt,
+		Iterations: hashIterations,
+		Hash:       hash,
+	})
+}
+
+// CreateRole adds a role, which holds grants for the users given it.
+func (m *Manager) CreateRole(name string) error {
+	return m.createPrincipal(&Principal{Name: name, Role: true})
+}
+
+func (m *Manager) createPrincipal(p *Principal) error {
+	if p.Name == "" {
+		return ErrReservedName
+	}
+	if _, err := m.GetPrincipal(p.Name); err == nil {
+		return ErrPrincipalExists
+	} else if !errors.Is(err, ErrPrincipalNotFound) {
+		return err
+	}
+	return m.putPrincipal(p)
+}
+
+// DropPrincipal removes a user or role. A role is taken away from the
+// users that hold it first.
+func (m *Manager) DropPrincipal(name string) error {
+	p, err := m.GetPrincipal(name)
+	if err != nil {
+		return err
+	}
+	if p.Role {
+		names, err := m.Principals()
+		if err != nil {
+			return err
+		}
+		for _, user := range names {
+			if err := m.RevokeRole(user, name); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = m.store.Delete(principalKey(name))
+	return err
+}
+
+// SetPassword replaces the password of a user.
+func (m *Manager) SetPassword(name, password string) error {
+	p, err := m.GetPrincipal(name)
+	if err != nil {
+		return err
+	}
+	if p.Role {
+		return ErrNotUser
+	}
+	p.Salt = make([]byte, 16)
+	rand.Read(p.Salt)
+	p.Iterations = hashIterations
+	if p.Hash, err = hashPassword(password, p.Salt, p.Iterations); err != nil {
+		return err
+	}
+	return m.putPrincipal(p)
+}
+
+// Authenticate checks the password of a user and returns the user. Any
+// mismatch, including an unknown user or a role, is ErrAuthFailed.
+func (m *Manager) Authenticate(name, password string) (*Principal, error) {
+	p, err := m.GetPrincipal(name)
+	if errors.Is(err, ErrPrincipalNotFound) {
+		return nil, RejectPassword(password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.CheckPassword(password); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CheckPassword returns ErrAuthFailed unless p is a user with the given
+// password. It is slow on purpose and needs no access to the store, so a
+// server can run it without holding up other requests.
+func (p *Principal) CheckPassword(password string) error {
+	if p.Role || len(p.Hash) == 0 {
+		return RejectPassword(password)
+	}
+	hash, err := hashPassword(password, p.Salt, p.Iterations)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(hash, p.Hash) != 1 {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// checkObject returns an error unless object is a table or KeyValue.
+func (m *Manager) checkObject(object string) error {
+	if object == KeyValue {
+		return nil
+	}
+	_, err := m.GetTable(object)
+	return err
+}
+
+// Grant adds privileges on a table, or on KeyValue, to a user or role.
+func (m *Manager) Grant(name, object string, privileges Privilege) error {
+	p, err := m.GetPrincipal(name)
+	if err != nil {
+		return err
+	}
+	if err := m.checkObject(object); err != nil {
+		return err
+	}
+	if p.Grants == nil {
+		p.Grants = make(map[string]Privilege)
+	}
+	p.Grants[object] |= privileges
+	return m.putPrincipal(p)
+}
+
+// Revoke takes privileges on an object away from a user or role. It does
+// not touch those the roles of a user grant.
+func (m *Manager) Revoke(name, object string, privileges Privilege) error {
+	p, err := m.GetPrincipal(name)
+	if err != nil {
+		return err
+	}
+	if remaining := p.Grants[object] &^ privileges; remaining != 0 {
+		p.Grants[object] = remaining
+	} else {
+		delete(p.Grants, object)
+	}
+	return m.putPrincipal(p)
+}
+
+// GrantRole gives a role to a user. Roles do not nest.
+func (m *Manager) GrantRole(name, role string) error {
+	p, err := m.GetPrincipal(name)
+	if err != nil {
+		return err
+	}
+	if p.Role {
+		return ErrNotUser
+	}
+	r, err := m.GetPrincipal(role)
+	if err != nil {
+		return err
+	}
+	if !r.Role {
+		return ErrNotRole
+	}
+	if !slices.Contains(p.Roles, role) {
+		p.Roles = append(p.Roles, role)
+		slices.Sort(p.Roles)
+	}
+	return m.putPrincipal(p)
+}
+
+// RevokeRole takes a role away from a user.
+func (m *Manager) RevokeRole(name, role string) error {
+	p, err := m.GetPrincipal(name)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(p.Roles, role)
+	if i < 0 {
+		return nil
+	}
+	p.Roles = slices.Delete(p.Roles, i, i+1)
+	return m.putPrincipal(p)
+}
+
+// Privileges returns the privileges a user holds on an object, directly
+// and through its roles. A superuser holds all of them.
+func (m *Manager) Privileges(name, object string) (Privilege, error) {
+	p, err := m.GetPrincipal(name)
+	if err != nil {
+		return 0, err
+	}
+	if p.Superuser {
+		return PrivAll, nil
+	}
+	privileges := p.Grants[object]
+	for _, role := range p.Roles {
+		r, err := m.GetPrincipal(role)
+		if errors.Is(err, ErrPrincipalNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		privileges |= r.Grants[object]
+	}
+	return privileges, nil
+}
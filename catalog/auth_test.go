@@ -0,0 +1,128 @@
+package catalog
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	defer func(n int) { hashIterations = n }(hashIterations)
+	hashIterations = 1000
+
+	m := newTestManager(t)
+	defer m.Close()
+
+	columns := []Column{{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true}}
+	for _, name := range []string{"orders", "users"} {
+		if _, err := m.CreateTable(name, columns); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	if err := m.CreateUser("root", "secret", true); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := m.CreateUser("ann", "hunter2", false); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := m.CreateRole("readers"); err != nil {
+		t.Fatalf("failed to create role: %v", err)
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+		err  error
+	}{
+		{"duplicate user", func() error { return m.CreateUser("ann", "x", false) }, ErrPrincipalExists},
+		{"grant", func() error { return m.Grant("ann", "orders", PrivInsert|PrivUpdate) }, nil},
+		{"grant key-value", func() error { return m.Grant("ann", KeyValue, PrivSelect) }, nil},
+		{"grant role", func() error { return m.Grant("readers", "users", PrivSelect) }, nil},
+		{"grant missing table", func() error { return m.Grant("ann", "missing", PrivSelect) }, ErrTableNotFound},
+		{"grant missing user", func() error { return m.Grant("bob", "orders", PrivSelect) }, ErrPrincipalNotFound},
+		{"give role", func() error { return m.GrantRole("ann", "readers") }, nil},
+		{"give user as role", func() error { return m.GrantRole("ann", "root") }, ErrNotRole},
+		{"give role to role", func() error { return m.GrantRole("readers", "readers") }, ErrNotUser},
+		{"revoke", func() error { return m.Revoke("ann", "orders", PrivUpdate|PrivDelete) }, nil},
+		{"reserved table", func() error { _, err := m.CreateTable(KeyValue, columns); return err }, ErrReservedName},
+	}
+	for _, step := range steps {
+		if err := step.run(); !errors.Is(err, step.err) {
+			t.Errorf("%s: expected %v, got %v", step.name, step.err, err)
+		}
+	}
+
+	privileges := []struct {
+		user, object string
+		expected     Privilege
+	}{
+		{"ann", "orders", PrivInsert},
+		{"ann", "users", PrivSelect},
+		{"ann", KeyValue, PrivSelect},
+		{"root", "orders", PrivAll},
+		{"readers", "orders", 0},
+	}
+	for _, tt := range privileges {
+		got, err := m.Privileges(tt.user, tt.object)
+		if err != nil || got != tt.expected {
+			t.Errorf("%s on %s: expected %v, got %v, err %v", tt.user, tt.object, tt.expected, got, err)
+		}
+	}
+
+	logins := []struct {
+		user, password string
+		err            error
+	}{
+		{"ann", "hunter2", nil},
+		{"ann", "hunter3", ErrAuthFailed},
+		{"bob", "hunter2", ErrAuthFailed},
+		{"readers", "", ErrAuthFailed},
+	}
+	for _, tt := range logins {
+		if _, err := m.Authenticate(tt.user, tt.password); !errors.Is(err, tt.err) {
+			t.Errorf("%s/%s: expected %v, got %v", tt.user, tt.password, tt.err, err)
+		}
+	}
+	if err := m.SetPassword("ann", "correct horse"); err != nil {
+		t.Fatalf("failed to set password: %v", err)
+	}
+	if _, err := m.Authenticate("ann", "hunter2"); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("expected the old password to fail, got %v", err)
+	}
+	if _, err := m.Authenticate("ann", "correct horse"); err != nil {
+		t.Errorf("expected the new password to work, got %v", err)
+	}
+
+	// Dropping a role takes it away from its users.
+	if err := m.DropPrincipal("readers"); err != nil {
+		t.Fatalf("failed to drop role: %v", err)
+	}
+	if err := m.CreateRole("readers"); err != nil {
+		t.Fatalf("failed to create role: %v", err)
+	}
+	ann, err := m.GetPrincipal("ann")
+	if err != nil || len(ann.Roles) != 0 {
+		t.Errorf("expected ann to hold no roles, got %+v, err %v", ann, err)
+	}
+	names, err := m.Principals()
+	if err != nil || !slices.Equal(names, []string{"ann", "readers", "root"}) {
+		t.Errorf("expected ann, readers and root, got %v, err %v", names, err)
+	}
+}
+
+func TestPrivilegeString(t *testing.T) {
+	tests := []struct {
+		p        Privilege
+		expected string
+	}{
+		{0, "NONE"},
+		{PrivSelect, "SELECT"},
+		{PrivInsert | PrivDelete, "INSERT, DELETE"},
+		{PrivAll, "SELECT, INSERT, UPDATE, DELETE"},
+	}
+	for _, tt := range tests {
+		if got := tt.p.String(); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 // Package catalog
 package catalog
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 type DataType uint8
 
 const (
@@ -9,19 +15,199 @@ const (
 	TypeBoolean
 	TypeBlob
 	TypeFloat
+	TypeTimestamp
+)
+
+var typeNames = map[DataType]string{
+	TypeInt:       "INT",
+	TypeVarChar:   "VARCHAR",
+	TypeBoolean:   "BOOLEAN",
+	TypeBlob:      "BLOB",
+	TypeFloat:     "FLOAT",
+	TypeTimestamp: "TIMESTAMP",
+}
+
+// String returns the SQL name of the type.
+func (t DataType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("DataType(%d)", int(t))
+}
+
+type ReferentialAction uint8
+
+const (
+	OnDeleteRestrict ReferentialAction = iota
+	OnDeleteCascade
+)
+
+// ForeignKey declares that a column REFERENCES the primary key of another
+// table.
+type ForeignKey struct {
+	Table    string            `json:"table"`
+	Column   string            `json:"column"`
+	OnDelete ReferentialAction `json:"on_delete,omitempty"`
+}
+
+// Reference records a column of another table that points at this table.
+type Reference struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+}
+
+// KeyGenerator makes up a primary key for rows inserted without one.
+type KeyGenerator uint8
+
+const (
+	GeneratorNone KeyGenerator = iota
+	// GeneratorULID fills VARCHAR keys with ULIDs: 26 characters of
+	// Crockford base32 holding a millisecond timestamp and 80 random bits,
+	// which sort by the time they were made.
+	GeneratorULID
+	// GeneratorSnowflake fills INT keys with snowflake IDs: a millisecond
+	// timestamp, the node ID of the database and a sequence number, which
+	// are unique across nodes with distinct IDs and sort by time.
+	GeneratorSnowflake
 )
 
+func (g KeyGenerator) String() string {
+	switch g {
+	case GeneratorNone:
+		return "NONE"
+	case GeneratorULID:
+		return "ULID"
+	case GeneratorSnowflake:
+		return "SNOWFLAKE"
+	default:
+		return fmt.Sprintf("KeyGenerator(%d)", int(g))
+	}
+}
+
 type Column struct {
-	Name         string   `json:"name"`
-	Type         DataType `json:"type"`
-	IsPrimaryKey bool     `json:"is_primary_key,omitempty"`
-	IsNotNull    bool     `json:"is_not_null,omitempty"`
+	Name         string      `json:"name"`
+	Type         DataType    `json:"type"`
+	IsPrimaryKey bool        `json:"is_primary_key,omitempty"`
+	IsNotNull    bool        `json:"is_not_null,omitempty"`
+	DefaultValue any         `json:"default_value,omitempty"`
+	References   *ForeignKey `json:"references,omitempty"`
+	// Sketch keeps approximate distinct-count and frequency sketches of
+	// the column up to date on every write.
+	Sketch bool `json:"sketch,omitempty"`
+	// Generator makes up the key of rows inserted with a NULL primary key.
+	// It only applies to the primary key column.
+	Generator KeyGenerator `json:"generator,omitempty"`
+}
+
+// UnmarshalJSON decodes the default value back into the Go type used for
+// the column type, since plain JSON decoding turns every number into a
+// float64 and every blob into a string.
+func (c *Column) UnmarshalJSON(data []byte) error {
+	type column Column
+	aux := struct {
+		*column
+		DefaultValue json.RawMessage `json:"default_value,omitempty"`
+	}{column: (*column)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	value, err := decodeValue(c.Type, aux.DefaultValue)
+	if err != nil {
+		return err
+	}
+	c.DefaultValue = value
+	return nil
+}
+
+// decodeValue decodes a JSON encoded value of a column into the Go type
+// used for the column type. An empty or null value decodes to nil.
+func decodeValue(t DataType, data json.RawMessage) (any, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	switch t {
+	case TypeInt:
+		var v int64
+		err := json.Unmarshal(data, &v)
+		return v, err
+	case TypeVarChar:
+		var v string
+		err := json.Unmarshal(data, &v)
+		return v, err
+	case TypeBoolean:
+		var v bool
+		err := json.Unmarshal(data, &v)
+		return v, err
+	case TypeBlob:
+		var v []byte
+		err := json.Unmarshal(data, &v)
+		return v, err
+	case TypeFloat:
+		var v float64
+		err := json.Unmarshal(data, &v)
+		return v, err
+	case TypeTimestamp:
+		var v time.Time
+		err := json.Unmarshal(data, &v)
+		return v, err
+	default:
+		return nil, ErrInvalidDefault
+	}
+}
+
+// TableLayout decides how the rows of a table are stored.
+type TableLayout uint8
+
+const (
+	// LayoutHeap appends rows to the data log and indexes their offsets.
+	LayoutHeap TableLayout = iota
+	// LayoutIndexOrganized keeps rows in the leaves of the primary index,
+	// which makes key lookups and range scans avoid the data log.
+	LayoutIndexOrganized
+)
+
+type TableOptions struct {
+	Layout TableLayout
+}
+
+type IndexType uint8
+
+const (
+	// IndexBTree orders entries by the indexed columns, one after another.
+	IndexBTree IndexType = iota
+	// IndexZOrder interleaves the bits of two integer or timestamp columns
+	// into a Morton code, so that entries close in both columns stay close
+	// in the index and two-dimensional box queries need no full scan.
+	IndexZOrder
+)
+
+func (t IndexType) String() string {
+	switch t {
+	case IndexBTree:
+		return "BTREE"
+	case IndexZOrder:
+		return "ZORDER"
+	default:
+		return fmt.Sprintf("IndexType(%d)", int(t))
+	}
+}
+
+type IndexOptions struct {
+	Type IndexType
+	// Building creates the index marked as being built, so that queries
+	// leave it alone until FinishIndex clears the mark.
+	Building bool
 }
 
 type IndexInfo struct {
-	ID      uint32   `json:"id"`
-	Name    string   `json:"name"`
-	Columns []string `json:"columns"`
+	ID       uint32    `json:"id"`
+	Name     string    `json:"name"`
+	Columns  []string  `json:"columns"`
+	Type     IndexType `json:"type,omitempty"`
+	Building bool      `json:"building,omitempty"`
 }
 
 type Schema struct {
@@ -30,4 +216,60 @@ type Schema struct {
 	Columns         []Column     `json:"columns"`
 	PrimaryKeyIndex int          `json:"pk_index"`
 	Indexes         []*IndexInfo `json:"indexes"`
+	ReferencedBy    []Reference  `json:"referenced_by,omitempty"`
+	Layout          TableLayout  `json:"layout,omitempty"`
+	Stats           *TableStats  `json:"stats,omitempty"`
+	// Retention and FirstLSN only apply to streams. FirstLSN is the oldest
+	// row a stream keeps; earlier ones were truncated.
+	Retention *Retention `json:"retention,omitempty"`
+	FirstLSN  uint64     `json:"first_lsn,omitempty"`
+}
+
+// Retention limits how much of a stream is kept. Rows beyond the newest
+// MaxRows, or appended longer than MaxAge ago, are truncated. A zero limit
+// does not apply.
+type Retention struct {
+	MaxRows uint64        `json:"max_rows,omitempty"`
+	MaxAge  time.Duration `json:"max_age,omitempty"`
+}
+
+// TableStats describes the rows of a table as of the last analyze, for the
+// query planner to estimate how many rows a predicate selects. Everything
+// but RowCount is computed from a sample of SampleSize rows.
+type TableStats struct {
+	RowCount   int64         `json:"row_count"`
+	SampleSize int64         `json:"sample_size"`
+	Columns    []ColumnStats `json:"columns"`
+	AnalyzedAt time.Time     `json:"analyzed_at"`
+}
+
+type ColumnStats struct {
+	Name      string   `json:"name"`
+	Type      DataType `json:"type"`
+	NullCount int64    `json:"null_count"`
+	Distinct  int64    `json:"distinct"`
+	Min       any      `json:"min,omitempty"`
+	Max       any      `json:"max,omitempty"`
+}
+
+// UnmarshalJSON decodes Min and Max back into the Go type used for the
+// column type, like Column.UnmarshalJSON does for default values.
+func (c *ColumnStats) UnmarshalJSON(data []byte) error {
+	type columnStats ColumnStats
+	aux := struct {
+		*columnStats
+		Min json.RawMessage `json:"min,omitempty"`
+		Max json.RawMessage `json:"max,omitempty"`
+	}{columnStats: (*columnStats)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if c.Min, err = decodeValue(c.Type, aux.Min); err != nil {
+		return err
+	}
+	c.Max, err = decodeValue(c.Type, aux.Max)
+	return err
 }
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"time"
+
+	"github.com/rizalta/toydb/storage"
 )
 
 var (
@@ -16,6 +19,15 @@ var (
 	ErrDuplicateColumnName   = errors.New("catalog: duplicate column name")
 	ErrIndexAlreadyExists    = errors.New("catalog: index already exists")
 	ErrIndexColumnNotFound   = errors.New("catalog: column not found to create index")
+	ErrInvalidDefault        = errors.New("catalog: default value does not match column type")
+	ErrInvalidReference      = errors.New("catalog: foreign key must reference a primary key of the same type")
+	ErrInvalidLayout         = errors.New("catalog: unknown table layout")
+	ErrUnsupportedIndex      = errors.New("catalog: columns not supported by index type")
+	ErrIndexNotFound         = errors.New("catalog: index not found")
+	ErrStreamColumn          = errors.New("catalog: stream columns cannot be primary or foreign keys")
+	ErrDropPrimaryIndex      = errors.New("catalog: the primary key index cannot be dropped")
+	ErrInvalidGenerator      = errors.New("catalog: key generator does not match column")
+	ErrTableNotFound         = errors.New("catalog: table not found")
 )
 
 var (
@@ -26,6 +38,8 @@ var (
 type Store interface {
 	Get(key []byte) ([]byte, bool, error)
 	Put(key []byte, value []byte) error
+	Delete(key []byte) (bool, error)
+	NewKeyIterator(startKey, endKey []byte) (*storage.KeyIterator, error)
 	Close() error
 }
 
@@ -40,24 +54,31 @@ type ManagerMeta struct {
 }
 
 func NewManager(store Store) (*Manager, error) {
-	m := &Manager{
-		store: store,
-		meta:  &ManagerMeta{NextID: 1, NextIndexID: 1},
+	m := &Manager{store: store}
+	if err := m.Reload(); err != nil {
+		return nil, err
 	}
+	return m, nil
+}
 
-	var meta ManagerMeta
+// Reload reads the metadata the manager keeps in memory from the store
+// again, for a store that was written to behind its back, as a follower
+// is by its leader.
+func (m *Manager) Reload() error {
 	metaBytes, found, err := m.store.Get(metaKey)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if found {
-		if err := json.Unmarshal(metaBytes, &meta); err != nil {
-			return nil, err
-		}
-		m.meta = &meta
+	if !found {
+		m.meta = &ManagerMeta{NextID: 1, NextIndexID: 1}
+		return nil
 	}
-
-	return m, nil
+	var meta ManagerMeta
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return err
+	}
+	m.meta = &meta
+	return nil
 }
 
 func (m *Manager) updateMeta() error {
@@ -79,15 +100,20 @@ func (m *Manager) updateSchema(schema *Schema) error {
 	return m.store.Put(schemaKey, schemaBytes)
 }
 
-func (m *Manager) CreateTable(name string, columns []Column) (*Schema, error) {
-	schemaKey := []byte("table:" + name)
-
+func validateColumns(columns []Column) (int, error) {
 	columnNames := make(map[string]struct{})
 	for _, c := range columns {
 		if _, exists := columnNames[c.Name]; exists {
-			return nil, ErrDuplicateColumnName
+			return 0, ErrDuplicateColumnName
 		}
 		columnNames[c.Name] = struct{}{}
+
+		if !isValidDefault(c.Type, c.DefaultValue) {
+			return 0, ErrInvalidDefault
+		}
+		if !isValidGenerator(c) {
+			return 0, ErrInvalidGenerator
+		}
 	}
 
 	primaryKeyCols := slices.Collect(func(yield func(i int) bool) {
@@ -101,28 +127,60 @@ func (m *Manager) CreateTable(name string, columns []Column) (*Schema, error) {
 	})
 
 	if len(primaryKeyCols) == 0 {
-		return nil, ErrNoPrimaryKey
+		return 0, ErrNoPrimaryKey
 	} else if len(primaryKeyCols) > 1 {
-		return nil, ErrMultiplePrimaryKeys
+		return 0, ErrMultiplePrimaryKeys
 	}
 
 	primaryKeyIndex := primaryKeyCols[0]
 	primaryKeyColumn := columns[primaryKeyIndex]
 
 	switch primaryKeyColumn.Type {
-	case TypeInt, TypeVarChar, TypeFloat:
+	case TypeInt, TypeVarChar, TypeFloat, TypeTimestamp:
 	default:
-		return nil, ErrUnsupportedPrimaryKey
+		return 0, ErrUnsupportedPrimaryKey
 	}
 
 	if !primaryKeyColumn.IsNotNull {
-		return nil, ErrPrimaryKeyNotNull
+		return 0, ErrPrimaryKeyNotNull
+	}
+
+	return primaryKeyIndex, nil
+}
+
+// isValidGenerator reports whether the key generator of c, if any, can
+// make up keys of its type.
+func isValidGenerator(c Column) bool {
+	switch c.Generator {
+	case GeneratorNone:
+		return true
+	case GeneratorULID:
+		return c.IsPrimaryKey && c.Type == TypeVarChar
+	case GeneratorSnowflake:
+		return c.IsPrimaryKey && c.Type == TypeInt
+	default:
+		return false
+	}
+}
+
+func (m *Manager) CreateTable(name string, columns []Column) (*Schema, error) {
+	return m.CreateTableWithOptions(name, columns, TableOptions{})
+}
+
+func (m *Manager) CreateTableWithOptions(name string, columns []Column, opts TableOptions) (*Schema, error) {
+	primaryKeyIndex, err := validateColumns(columns)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, found, err := m.store.Get(schemaKey); err != nil {
+	switch opts.Layout {
+	case LayoutHeap, LayoutIndexOrganized:
+	default:
+		return nil, ErrInvalidLayout
+	}
+
+	if err := m.checkNameFree(name); err != nil {
 		return nil, err
-	} else if found {
-		return nil, ErrAlreadyExists
 	}
 
 	schema := &Schema{
@@ -130,6 +188,12 @@ func (m *Manager) CreateTable(name string, columns []Column) (*Schema, error) {
 		Name:            name,
 		Columns:         columns,
 		PrimaryKeyIndex: primaryKeyIndex,
+		Layout:          opts.Layout,
+	}
+
+	referenced, err := m.resolveReferences(schema)
+	if err != nil {
+		return nil, err
 	}
 	primaryKeyColName := schema.Columns[primaryKeyIndex].Name
 	schema.Indexes = []*IndexInfo{
@@ -144,6 +208,259 @@ func (m *Manager) CreateTable(name string, columns []Column) (*Schema, error) {
 		return nil, err
 	}
 
+	for _, parent := range referenced {
+		if err := m.updateSchema(parent); err != nil {
+			return nil, err
+		}
+	}
+
+	m.meta.NextID++
+	if err := m.updateMeta(); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// PrepareRewrite validates new columns for an existing table and returns
+// the schema the table will have after a rewrite, under a freshly
+// allocated table ID. Nothing changes for readers of the table until the
+// schema is installed with SwapTable, so the rows can be copied to the new
+// ID in the meantime.
+func (m *Manager) PrepareRewrite(name string, columns []Column) (*Schema, error) {
+	old, err := m.GetTable(name)
+	if err != nil {
+		return nil, err
+	}
+
+	primaryKeyIndex, err := validateColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	referencedBy := slices.DeleteFunc(slices.Clone(old.ReferencedBy), func(r Reference) bool {
+		return r.Table == name
+	})
+	oldKey := old.Columns[old.PrimaryKeyIndex]
+	newKey := columns[primaryKeyIndex]
+	if len(referencedBy) > 0 && (oldKey.Name != newKey.Name || oldKey.Type != newKey.Type) {
+		return nil, ErrInvalidReference
+	}
+
+	columnNames := make(map[string]struct{})
+	for _, c := range columns {
+		columnNames[c.Name] = struct{}{}
+	}
+	var indexes []*IndexInfo
+	for _, info := range old.Indexes {
+		// The indexes are copied, so that the new schema shares nothing
+		// with the old one, which stays live until the swap.
+		idx := &IndexInfo{}
+		*idx = *info
+		idx.Columns = slices.Clone(info.Columns)
+		if idx.Name == primaryIndexName {
+			idx.Columns = []string{newKey.Name}
+		}
+		if !slices.ContainsFunc(idx.Columns, func(c string) bool {
+			_, found := columnNames[c]
+			return !found
+		}) {
+			indexes = append(indexes, idx)
+		}
+	}
+
+	schema := &Schema{
+		ID:              m.meta.NextID,
+		Name:            name,
+		Columns:         columns,
+		PrimaryKeyIndex: primaryKeyIndex,
+		Indexes:         indexes,
+		ReferencedBy:    referencedBy,
+		Layout:          old.Layout,
+	}
+
+	if _, err := m.resolveReferences(schema); err != nil {
+		return nil, err
+	}
+
+	m.meta.NextID++
+	if err := m.updateMeta(); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// SwapTable installs a schema returned by PrepareRewrite in place of the
+// current one with a single write of the table entry, and moves the
+// table's foreign keys over on the referenced tables.
+func (m *Manager) SwapTable(schema *Schema) error {
+	old, err := m.GetTable(schema.Name)
+	if err != nil {
+		return err
+	}
+
+	referenced, err := m.resolveReferences(schema)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range old.Columns {
+		if c.References == nil || c.References.Table == schema.Name {
+			continue
+		}
+		if slices.ContainsFunc(referenced, func(s *Schema) bool {
+			return s.Name == c.References.Table
+		}) {
+			continue
+		}
+
+		parent, err := m.GetTable(c.References.Table)
+		if err != nil {
+			return err
+		}
+		parent.ReferencedBy = slices.DeleteFunc(parent.ReferencedBy, func(r Reference) bool {
+			return r.Table == schema.Name
+		})
+		referenced = append(referenced, parent)
+	}
+
+	if err := m.updateSchema(schema); err != nil {
+		return err
+	}
+
+	for _, parent := range referenced {
+		if err := m.updateSchema(parent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveReferences validates the foreign keys of a table and records them
+// on the referenced tables, replacing whatever was recorded for the table
+// before. A table may reference its own primary key. It returns the other
+// referenced schemas, which need to be persisted.
+func (m *Manager) resolveReferences(schema *Schema) ([]*Schema, error) {
+	parents := make(map[string]*Schema)
+	for _, c := range schema.Columns {
+		if c.References == nil {
+			continue
+		}
+
+		parent, found := parents[c.References.Table]
+		if !found {
+			if c.References.Table == schema.Name {
+				parent = schema
+			} else {
+				var err error
+				parent, err = m.GetTable(c.References.Table)
+				if err != nil {
+					return nil, err
+				}
+			}
+			parent.ReferencedBy = slices.DeleteFunc(parent.ReferencedBy, func(r Reference) bool {
+				return r.Table == schema.Name
+			})
+			parents[parent.Name] = parent
+		}
+
+		parentKey := parent.Columns[parent.PrimaryKeyIndex]
+		if parentKey.Name != c.References.Column || parentKey.Type != c.Type {
+			return nil, ErrInvalidReference
+		}
+
+		parent.ReferencedBy = append(parent.ReferencedBy, Reference{
+			Table:  schema.Name,
+			Column: c.Name,
+		})
+	}
+
+	referenced := make([]*Schema, 0, len(parents))
+	for _, parent := range parents {
+		if parent != schema {
+			referenced = append(referenced, parent)
+		}
+	}
+
+	return referenced, nil
+}
+
+func isValidDefault(colType DataType, value any) bool {
+	if value == nil {
+		return true
+	}
+
+	var ok bool
+	switch colType {
+	case TypeInt:
+		_, ok = value.(int64)
+	case TypeVarChar:
+		_, ok = value.(string)
+	case TypeBoolean:
+		_, ok = value.(bool)
+	case TypeBlob:
+		_, ok = value.([]byte)
+	case TypeFloat:
+		_, ok = value.(float64)
+	case TypeTimestamp:
+		_, ok = value.(time.Time)
+	}
+
+	return ok
+}
+
+// checkNameFree returns ErrAlreadyExists if a table or stream has the name,
+// and ErrReservedName if none may take it.
+func (m *Manager) checkNameFree(name string) error {
+	if name == KeyValue {
+		return ErrReservedName
+	}
+	for _, key := range []string{"table:" + name, "stream:" + name} {
+		if _, found, err := m.store.Get([]byte(key)); err != nil {
+			return err
+		} else if found {
+			return ErrAlreadyExists
+		}
+	}
+	return nil
+}
+
+// CreateStream creates an append-only stream. Its rows are keyed by their
+// position in the stream rather than by a column, so the schema has no
+// primary key and a PrimaryKeyIndex of -1. Streams share the namespace of
+// tables but are not returned by GetTable.
+func (m *Manager) CreateStream(name string, columns []Column) (*Schema, error) {
+	columnNames := make(map[string]struct{})
+	for _, c := range columns {
+		if _, exists := columnNames[c.Name]; exists {
+			return nil, ErrDuplicateColumnName
+		}
+		columnNames[c.Name] = struct{}{}
+
+		if c.IsPrimaryKey || c.References != nil || c.Generator != GeneratorNone {
+			return nil, ErrStreamColumn
+		}
+		if !isValidDefault(c.Type, c.DefaultValue) {
+			return nil, ErrInvalidDefault
+		}
+	}
+
+	if err := m.checkNameFree(name); err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{
+		ID:              m.meta.NextID,
+		Name:            name,
+		Columns:         columns,
+		PrimaryKeyIndex: -1,
+	}
+	if err := m.updateStream(schema); err != nil {
+		return nil, err
+	}
+
 	m.meta.NextID++
 	if err := m.updateMeta(); err != nil {
 		return nil, err
@@ -152,6 +469,68 @@ func (m *Manager) CreateTable(name string, columns []Column) (*Schema, error) {
 	return schema, nil
 }
 
+func (m *Manager) updateStream(schema *Schema) error {
+	schemaBytes, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+
+	return m.store.Put([]byte("stream:"+schema.Name), schemaBytes)
+}
+
+// SetRetention sets the retention policy of a stream, or removes it if
+// retention is nil.
+func (m *Manager) SetRetention(name string, retention *Retention) (*Schema, error) {
+	schema, err := m.GetStream(name)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.Retention = retention
+	return schema, m.updateStream(schema)
+}
+
+// TruncateStream records that the rows of a stream before firstLSN are
+// gone. The first LSN never moves back.
+func (m *Manager) TruncateStream(name string, firstLSN uint64) (*Schema, error) {
+	schema, err := m.GetStream(name)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.FirstLSN = max(schema.FirstLSN, firstLSN)
+	return schema, m.updateStream(schema)
+}
+
+func (m *Manager) GetStream(name string) (*Schema, error) {
+	schemaBytes, found, err := m.store.Get([]byte("stream:" + name))
+	if err != nil {
+		return nil, fmt.Errorf("catalog: failed to retrieve schema: %w", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("catalog: stream %s not found", name)
+	}
+
+	var schema Schema
+	if err := json.Unmarshal(schemaBytes, &schema); err != nil {
+		return nil, fmt.Errorf("catalog: failed to deserialize schema: %w", err)
+	}
+
+	return &schema, nil
+}
+
+// tableNotFound names the missing table in its message and matches
+// ErrTableNotFound.
+type tableNotFound string
+
+func (e tableNotFound) Error() string {
+	return fmt.Sprintf("catalog: table %s not found", string(e))
+}
+
+func (e tableNotFound) Is(target error) bool {
+	return target == ErrTableNotFound
+}
+
 func (m *Manager) GetTable(name string) (*Schema, error) {
 	schemaKey := []byte("table:" + name)
 
@@ -160,7 +539,7 @@ func (m *Manager) GetTable(name string) (*Schema, error) {
 		return nil, fmt.Errorf("catalog: failed to retrieve schema: %w", err)
 	}
 	if !found {
-		return nil, fmt.Errorf("catalog: table %s not found", name)
+		return nil, tableNotFound(name)
 	}
 
 	var schema Schema
@@ -171,7 +550,53 @@ func (m *Manager) GetTable(name string) (*Schema, error) {
 	return &schema, nil
 }
 
+// Tables returns the names of all tables in sorted order.
+func (m *Manager) Tables() ([]string, error) {
+	return m.names("table:")
+}
+
+// Streams returns the names of all streams in sorted order.
+func (m *Manager) Streams() ([]string, error) {
+	return m.names("stream:")
+}
+
+func (m *Manager) names(prefix string) ([]string, error) {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	iterator, err := m.store.NewKeyIterator([]byte(prefix), end)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for {
+		key, err := iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return names, nil
+		}
+		names = append(names, string(key[len(prefix):]))
+	}
+}
+
+// SetStats records the statistics of a table, replacing earlier ones.
+func (m *Manager) SetStats(name string, stats *TableStats) error {
+	schema, err := m.GetTable(name)
+	if err != nil {
+		return err
+	}
+
+	schema.Stats = stats
+	return m.updateSchema(schema)
+}
+
 func (m *Manager) CreateIndex(tableName, indexName string, columnNames []string) (*IndexInfo, error) {
+	return m.CreateIndexWithOptions(tableName, indexName, columnNames, IndexOptions{})
+}
+
+func (m *Manager) CreateIndexWithOptions(tableName, indexName string, columnNames []string, opts IndexOptions) (*IndexInfo, error) {
 	schema, err := m.GetTable(tableName)
 	if err != nil {
 		return nil, err
@@ -183,20 +608,37 @@ func (m *Manager) CreateIndex(tableName, indexName string, columnNames []string)
 		}
 	}
 
-	schemaColNames := make(map[string]struct{})
+	schemaColTypes := make(map[string]DataType)
 	for _, c := range schema.Columns {
-		schemaColNames[c.Name] = struct{}{}
+		schemaColTypes[c.Name] = c.Type
 	}
 	for _, c := range columnNames {
-		if _, exists := schemaColNames[c]; !exists {
+		if _, exists := schemaColTypes[c]; !exists {
 			return nil, ErrIndexColumnNotFound
 		}
 	}
 
+	switch opts.Type {
+	case IndexBTree:
+	case IndexZOrder:
+		if len(columnNames) != 2 {
+			return nil, ErrUnsupportedIndex
+		}
+		for _, c := range columnNames {
+			if t := schemaColTypes[c]; t != TypeInt && t != TypeTimestamp {
+				return nil, ErrUnsupportedIndex
+			}
+		}
+	default:
+		return nil, ErrUnsupportedIndex
+	}
+
 	newIndex := &IndexInfo{
-		ID:      m.meta.NextIndexID,
-		Name:    indexName,
-		Columns: columnNames,
+		ID:       m.meta.NextIndexID,
+		Name:     indexName,
+		Columns:  columnNames,
+		Type:     opts.Type,
+		Building: opts.Building,
 	}
 	schema.Indexes = append(schema.Indexes, newIndex)
 
@@ -212,6 +654,43 @@ func (m *Manager) CreateIndex(tableName, indexName string, columnNames []string)
 	return newIndex, nil
 }
 
+// FinishIndex clears the building mark of an index.
+func (m *Manager) FinishIndex(tableName, indexName string) error {
+	schema, err := m.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range schema.Indexes {
+		if info.Name == indexName {
+			info.Building = false
+			return m.updateSchema(schema)
+		}
+	}
+	return ErrIndexNotFound
+}
+
+// DropIndex removes a secondary index from a table and returns it. Its
+// entries are left for the caller to delete.
+func (m *Manager) DropIndex(tableName, indexName string) (*IndexInfo, error) {
+	schema, err := m.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, info := range schema.Indexes {
+		if info.Name != indexName {
+			continue
+		}
+		if info.ID == 0 {
+			return nil, ErrDropPrimaryIndex
+		}
+		schema.Indexes = slices.Delete(schema.Indexes, i, i+1)
+		return info, m.updateSchema(schema)
+	}
+	return nil, ErrIndexNotFound
+}
+
 func (m *Manager) Close() error {
 	return m.store.Close()
 }
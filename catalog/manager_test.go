@@ -2,6 +2,8 @@ package catalog
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
 	"slices"
 	"testing"
 
@@ -291,3 +293,371 @@ func TestCreateTable_PrimaryKeyNotNull(t *testing.T) {
 		t.Errorf("expected error %v, but got %v", ErrNoPrimaryKey, err)
 	}
 }
+
+func TestCreateTable_DefaultValues(t *testing.T) {
+	tempDir := t.TempDir()
+	store1, _ := storage.NewStore(tempDir)
+	manager1, err := NewManager(store1)
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	columns := []Column{
+		{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: TypeVarChar, DefaultValue: "anonymous"},
+		{Name: "age", Type: TypeInt, DefaultValue: int64(18)},
+		{Name: "score", Type: TypeFloat, DefaultValue: 1.5},
+		{Name: "is_active", Type: TypeBoolean, DefaultValue: true},
+	}
+
+	if _, err := manager1.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	t.Run("Invalid_default_type", func(t *testing.T) {
+		invalid := []Column{
+			{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+			{Name: "age", Type: TypeInt, DefaultValue: "eighteen"},
+		}
+		_, err := manager1.CreateTable("invalid", invalid)
+		if !errors.Is(err, ErrInvalidDefault) {
+			t.Errorf("expected error %v, but got %v", ErrInvalidDefault, err)
+		}
+	})
+
+	store1.Close()
+
+	store2, _ := storage.NewStore(tempDir)
+	defer store2.Close()
+
+	manager2, err := NewManager(store2)
+	if err != nil {
+		t.Fatalf("failed to initialize manager: %v", err)
+	}
+
+	schema, err := manager2.GetTable("users")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+
+	if !reflect.DeepEqual(schema.Columns, columns) {
+		t.Errorf("expected columns %v, but got %v", columns, schema.Columns)
+	}
+}
+
+func TestCreateTable_Generator(t *testing.T) {
+	manager := newTestManager(t)
+	defer manager.Close()
+
+	tests := []struct {
+		name    string
+		columns []Column
+		wantErr error
+	}{
+		{
+			name: "ulid",
+			columns: []Column{
+				{Name: "id", Type: TypeVarChar, IsPrimaryKey: true, IsNotNull: true, Generator: GeneratorULID},
+			},
+		},
+		{
+			name: "snowflake",
+			columns: []Column{
+				{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true, Generator: GeneratorSnowflake},
+			},
+		},
+		{
+			name: "wrong type",
+			columns: []Column{
+				{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true, Generator: GeneratorULID},
+			},
+			wantErr: ErrInvalidGenerator,
+		},
+		{
+			name: "not primary key",
+			columns: []Column{
+				{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+				{Name: "ref", Type: TypeInt, Generator: GeneratorSnowflake},
+			},
+			wantErr: ErrInvalidGenerator,
+		},
+		{
+			name: "unknown",
+			columns: []Column{
+				{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true, Generator: 9},
+			},
+			wantErr: ErrInvalidGenerator,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manager.CreateTable(tt.name, tt.columns)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			schema, err := manager.GetTable(tt.name)
+			if err != nil {
+				t.Fatalf("failed to get table: %v", err)
+			}
+			if got := schema.Columns[0].Generator; got != tt.columns[0].Generator {
+				t.Errorf("expected generator %v, got %v", tt.columns[0].Generator, got)
+			}
+		})
+	}
+}
+
+func TestCreateTable_References(t *testing.T) {
+	manager := newTestManager(t)
+	defer manager.store.Close()
+
+	parentColumns := []Column{
+		{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: TypeVarChar},
+	}
+	if _, err := manager.CreateTable("parent", parentColumns); err != nil {
+		t.Fatalf("failed to create parent table: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ref  *ForeignKey
+		typ  DataType
+		err  error
+	}{
+		{
+			name: "missing table",
+			ref:  &ForeignKey{Table: "missing", Column: "id"},
+			typ:  TypeInt,
+			err:  errors.New("catalog: table missing not found"),
+		},
+		{
+			name: "not primary key",
+			ref:  &ForeignKey{Table: "parent", Column: "name"},
+			typ:  TypeVarChar,
+			err:  ErrInvalidReference,
+		},
+		{
+			name: "type mismatch",
+			ref:  &ForeignKey{Table: "parent", Column: "id"},
+			typ:  TypeVarChar,
+			err:  ErrInvalidReference,
+		},
+		{
+			name: "valid reference",
+			ref:  &ForeignKey{Table: "parent", Column: "id", OnDelete: OnDeleteCascade},
+			typ:  TypeInt,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns := []Column{
+				{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+				{Name: "parent_id", Type: tt.typ, References: tt.ref},
+			}
+			_, err := manager.CreateTable(fmt.Sprintf("child_%d", i), columns)
+			if tt.err == nil && err != nil {
+				t.Fatalf("failed to create child table: %v", err)
+			}
+			if tt.err != nil && (err == nil || err.Error() != tt.err.Error()) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	parent, err := manager.GetTable("parent")
+	if err != nil {
+		t.Fatalf("failed to get parent table: %v", err)
+	}
+	expected := []Reference{{Table: "child_3", Column: "parent_id"}}
+	if !slices.Equal(parent.ReferencedBy, expected) {
+		t.Errorf("expected references %v, got %v", expected, parent.ReferencedBy)
+	}
+}
+
+func TestCreateTableWithOptions(t *testing.T) {
+	m := newTestManager(t)
+	defer m.Close()
+
+	columns := []Column{
+		{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+	}
+
+	if _, err := m.CreateTableWithOptions("bad", columns, TableOptions{Layout: TableLayout(9)}); !errors.Is(err, ErrInvalidLayout) {
+		t.Errorf("expected %v, got %v", ErrInvalidLayout, err)
+	}
+
+	if _, err := m.CreateTableWithOptions("clustered", columns, TableOptions{Layout: LayoutIndexOrganized}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	schema, err := m.GetTable("clustered")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+	if schema.Layout != LayoutIndexOrganized {
+		t.Errorf("expected layout %d, got %d", LayoutIndexOrganized, schema.Layout)
+	}
+}
+
+func TestCreateIndexWithOptions(t *testing.T) {
+	m := newTestManager(t)
+	defer m.Close()
+
+	columns := []Column{
+		{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "x", Type: TypeInt},
+		{Name: "at", Type: TypeTimestamp},
+		{Name: "name", Type: TypeVarChar},
+	}
+	if _, err := m.CreateTable("points", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		columns []string
+		opts    IndexOptions
+		err     error
+	}{
+		{"z-order on one column", []string{"x"}, IndexOptions{Type: IndexZOrder}, ErrUnsupportedIndex},
+		{"z-order on varchar", []string{"x", "name"}, IndexOptions{Type: IndexZOrder}, ErrUnsupportedIndex},
+		{"unknown type", []string{"name"}, IndexOptions{Type: IndexType(9)}, ErrUnsupportedIndex},
+		{"z-order on int and timestamp", []string{"x", "at"}, IndexOptions{Type: IndexZOrder}, nil},
+		{"btree on varchar", []string{"name"}, IndexOptions{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := m.CreateIndexWithOptions("points", tt.name, tt.columns, tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil && info.Type != tt.opts.Type {
+				t.Errorf("expected index type %d, got %d", tt.opts.Type, info.Type)
+			}
+		})
+	}
+}
+
+func TestCreateStream(t *testing.T) {
+	manager := newTestManager(t)
+	defer manager.store.Close()
+
+	columns := []Column{{Name: "event", Type: TypeVarChar, IsNotNull: true}}
+	if _, err := manager.CreateTable("users", []Column{{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true}}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		stream  string
+		columns []Column
+		err     error
+	}{
+		{"Valid stream", "events", columns, nil},
+		{"Name of a stream", "events", columns, ErrAlreadyExists},
+		{"Name of a table", "users", columns, ErrAlreadyExists},
+		{"Primary key", "keyed", []Column{{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true}}, ErrStreamColumn},
+		{"Duplicate column", "twice", []Column{columns[0], columns[0]}, ErrDuplicateColumnName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := manager.CreateStream(tt.stream, tt.columns); !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+
+	schema, err := manager.GetStream("events")
+	if err != nil {
+		t.Fatalf("failed to get stream: %v", err)
+	}
+	if schema.ID != 2 || schema.PrimaryKeyIndex != -1 {
+		t.Errorf("expected stream with ID 2 and no primary key, got ID %d and primary key %d", schema.ID, schema.PrimaryKeyIndex)
+	}
+	if _, err := manager.GetTable("events"); err == nil {
+		t.Errorf("expected GetTable to not return a stream")
+	}
+	if _, err := manager.CreateTable("events", []Column{{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true}}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected %v creating a table named like a stream, got %v", ErrAlreadyExists, err)
+	}
+}
+
+func TestDropIndex(t *testing.T) {
+	m := newTestManager(t)
+	defer m.Close()
+
+	columns := []Column{
+		{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: TypeVarChar},
+	}
+	if _, err := m.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	created, err := m.CreateIndex("users", "by_name", []string{"name"})
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	dropped, err := m.DropIndex("users", "by_name")
+	if err != nil {
+		t.Fatalf("failed to drop index: %v", err)
+	}
+	if dropped.ID != created.ID {
+		t.Errorf("expected the dropped index to have ID %d, got %d", created.ID, dropped.ID)
+	}
+
+	schema, err := m.GetTable("users")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+	if len(schema.Indexes) != 1 || schema.Indexes[0].Name != primaryIndexName {
+		t.Errorf("expected only the primary index to be left, got %d indexes", len(schema.Indexes))
+	}
+
+	if _, err := m.DropIndex("users", "by_name"); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("expected %v, got %v", ErrIndexNotFound, err)
+	}
+	if _, err := m.DropIndex("users", primaryIndexName); !errors.Is(err, ErrDropPrimaryIndex) {
+		t.Errorf("expected %v, got %v", ErrDropPrimaryIndex, err)
+	}
+}
+
+func TestTablesAndStreams(t *testing.T) {
+	m := newTestManager(t)
+	defer m.Close()
+
+	columns := []Column{{Name: "id", Type: TypeInt, IsPrimaryKey: true, IsNotNull: true}}
+	for _, name := range []string{"orders", "accounts", "users"} {
+		if _, err := m.CreateTable(name, columns); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	if _, err := m.CreateStream("events", []Column{{Name: "message", Type: TypeVarChar}}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	tables, err := m.Tables()
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+	if expected := []string{"accounts", "orders", "users"}; !slices.Equal(tables, expected) {
+		t.Errorf("expected tables %v, got %v", expected, tables)
+	}
+
+	streams, err := m.Streams()
+	if err != nil {
+		t.Fatalf("failed to list streams: %v", err)
+	}
+	if expected := []string{"events"}; !slices.Equal(streams, expected) {
+		t.Errorf("expected streams %v, got %v", expected, streams)
+	}
+
+	if _, err := m.GetTable("missing"); !errors.Is(err, ErrTableNotFound) || err.Error() != "catalog: table missing not found" {
+		t.Errorf("expected %v naming the table, got %v", ErrTableNotFound, err)
+	}
+}
@@ -0,0 +1,63 @@
+// Package checksum computes the checksums that guard pages and records
+// against corruption, with the algorithm chosen when a file is created.
+package checksum
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+var ErrUnknownAlgorithm = errors.New("checksum: unknown algorithm")
+
+// Algorithm selects how checksums are computed. It is stored in files, so
+// the values must not change.
+type Algorithm uint8
+
+const (
+	// CRC32IEEE is the CRC-32 of zlib and Ethernet, which every file was
+	// written with before the algorithm could be chosen.
+	CRC32IEEE Algorithm = iota
+	// CRC32C is the Castagnoli CRC-32, which x86 and arm64 CPUs compute
+	// with a dedicated instruction. Which of the two CRCs is faster
+	// depends on the CPU; BenchmarkSum compares them.
+	CRC32C
+	// XXH32 is the 32-bit xxHash, a fast hash that is not a CRC and needs
+	// no hardware support.
+	XXH32
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var algorithmNames = map[Algorithm]string{
+	CRC32IEEE: "crc32",
+	CRC32C:    "crc32c",
+	XXH32:     "xxh32",
+}
+
+func (a Algorithm) String() string {
+	if name, ok := algorithmNames[a]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Valid reports whether a is a known algorithm.
+func (a Algorithm) Valid() bool {
+	_, ok := algorithmNames[a]
+	return ok
+}
+
+// Sum returns the checksum of data. It panics on an unknown algorithm,
+// which callers reject with Valid when reading it from a file.
+func (a Algorithm) Sum(data []byte) uint32 {
+	switch a {
+	case CRC32IEEE:
+		return crc32.ChecksumIEEE(data)
+	case CRC32C:
+		return crc32.Checksum(data, castagnoli)
+	case XXH32:
+		return xxh32(data)
+	default:
+		panic(ErrUnknownAlgorithm)
+	}
+}
@@ -0,0 +1,58 @@
+package checksum
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		algorithm Algorithm
+		data      string
+		want      uint32
+	}{
+		{CRC32IEEE, "", 0},
+		{CRC32IEEE, "123456789", 0xcbf43926},
+		{CRC32C, "", 0},
+		{CRC32C, "123456789", 0xe3069283},
+		{XXH32, "", 0x02cc5d05},
+		{XXH32, "a", 0x550d7456},
+		{XXH32, "abc", 0x32d153ff},
+		{XXH32, "Nobody inspects the spammish repetition", 0xe2293b2f},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %q", tt.algorithm, tt.data), func(t *testing.T) {
+			if got := tt.algorithm.Sum([]byte(tt.data)); got != tt.want {
+				t.Errorf("expected %#08x, got %#08x", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, a := range []Algorithm{CRC32IEEE, CRC32C, XXH32} {
+		if !a.Valid() {
+			t.Errorf("expected %s to be valid", a)
+		}
+	}
+	if Algorithm(200).Valid() {
+		t.Errorf("expected algorithm 200 to be invalid")
+	}
+}
+
+func BenchmarkSum(b *testing.B) {
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	for _, a := range []Algorithm{CRC32IEEE, CRC32C, XXH32} {
+		b.Run(a.String(), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				a.Sum(data)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime32x1 uint32 = 2654435761
+	prime32x2 uint32 = 2246822519
+	prime32x3 uint32 = 3266489917
+	prime32x4 uint32 = 668265263
+	prime32x5 uint32 = 374761393
+)
+
+// xxh32 returns the xxHash32 of data with a seed of 0.
+func xxh32(data []byte) uint32 {
+	n := len(data)
+	var h uint32
+
+	if n >= 16 {
+		// The lanes start from a seed of 0 and wrap around like the
+		// reference implementation.
+		p1, p2 := prime32x1, prime32x2
+		v1 := p1 + p2
+		v2 := p2
+		v3 := uint32(0)
+		v4 := -p1
+		for len(data) >= 16 {
+			v1 = xxh32Round(v1, binary.LittleEndian.Uint32(data[0:]))
+			v2 = xxh32Round(v2, binary.LittleEndian.Uint32(data[4:]))
+			v3 = xxh32Round(v3, binary.LittleEndian.Uint32(data[8:]))
+			v4 = xxh32Round(v4, binary.LittleEndian.Uint32(data[12:]))
+			data = data[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) +
+			bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = prime32x5
+	}
+	h += uint32(n)
+
+	for len(data) >= 4 {
+		h += binary.LittleEndian.Uint32(data) * prime32x3
+		h = bits.RotateLeft32(h, 17) * prime32x4
+		data = data[4:]
+	}
+	for _, b := range data {
+		h += uint32(b) * prime32x5
+		h = bits.RotateLeft32(h, 11) * prime32x1
+	}
+
+	h ^= h >> 15
+	h *= prime32x2
+	h ^= h >> 13
+	h *= prime32x3
+	h ^= h >> 16
+	return h
+}
+
+func xxh32Round(acc, input uint32) uint32 {
+	acc += input * prime32x2
+	return bits.RotateLeft32(acc, 13) * prime32x1
+}
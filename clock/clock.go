@@ -0,0 +1,191 @@
+// Package clock abstracts the passing of time, so that tests of features
+// that depend on it can advance a virtual clock instead of sleeping.
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer sends the time on its channel once, like a time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	// Stop prevents the timer from firing and reports whether it had not
+	// fired yet.
+	Stop() bool
+}
+
+// Ticker sends the time on its channel every period, dropping ticks for a
+// slow receiver, like a time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the time package.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) Sleep(d time.Duration)            { time.Sleep(d) }
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// OrReal returns c, or Real if c is nil, for options that leave the clock
+// unset.
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// Virtual is a clock whose time only moves when Advance is called. Timers,
+// tickers and sleeps wait for it to pass their deadline.
+type Virtual struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at time.Time
+	// period is the period of a ticker, or zero for a timer.
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewVirtual returns a virtual clock set to start.
+func NewVirtual(start time.Time) *Virtual {
+	v := &Virtual{now: start}
+	v.cond = sync.NewCond(&v.mu)
+	return v
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker
+// whose deadline it passes in the order of their deadlines. A negative d
+// sets the clock back, as can happen to a wall clock, and fires nothing.
+func (v *Virtual) Advance(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	end := v.now.Add(d)
+	for {
+		i := -1
+		for j, w := range v.waiters {
+			if !w.at.After(end) && (i < 0 || w.at.Before(v.waiters[i].at)) {
+				i = j
+			}
+		}
+		if i < 0 {
+			break
+		}
+
+		w := v.waiters[i]
+		v.now = w.at
+		select {
+		case w.c <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			v.waiters = slices.Delete(v.waiters, i, i+1)
+		}
+	}
+	v.now = end
+}
+
+// BlockUntil waits until n timers, tickers and sleeps are waiting on the
+// clock, so that a test knows a goroutine got to them before it advances
+// the clock.
+func (v *Virtual) BlockUntil(n int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for len(v.waiters) < n {
+		v.cond.Wait()
+	}
+}
+
+func (v *Virtual) Sleep(d time.Duration) {
+	<-v.NewTimer(d).C()
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	return &virtualTimer{v: v, w: v.add(d, 0)}
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &virtualTicker{v: v, w: v.add(d, d)}
+}
+
+func (v *Virtual) add(d, period time.Duration) *waiter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	w := &waiter{at: v.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- v.now
+		return w
+	}
+	v.waiters = append(v.waiters, w)
+	v.cond.Broadcast()
+	return w
+}
+
+// remove stops w and reports whether it was waiting.
+func (v *Virtual) remove(w *waiter) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	i := slices.Index(v.waiters, w)
+	if i < 0 {
+		return false
+	}
+	v.waiters = slices.Delete(v.waiters, i, i+1)
+	return true
+}
+
+type virtualTimer struct {
+	v *Virtual
+	w *waiter
+}
+
+func (t *virtualTimer) C() <-chan time.Time { return t.w.c }
+func (t *virtualTimer) Stop() bool          { return t.v.remove(t.w) }
+
+type virtualTicker struct {
+	v *Virtual
+	w *waiter
+}
+
+func (t *virtualTicker) C() <-chan time.Time { return t.w.c }
+func (t *virtualTicker) Stop()               { t.v.remove(t.w) }
@@ -0,0 +1,59 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtual(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtual(start)
+
+	timer := v.NewTimer(time.Second)
+	ticker := v.NewTicker(300 * time.Millisecond)
+	stopped := v.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Errorf("expected Stop to report a pending timer")
+	}
+
+	v.Advance(999 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatalf("expected the timer not to fire before its deadline")
+	default:
+	}
+	if tick := <-ticker.C(); !tick.Equal(start.Add(300 * time.Millisecond)) {
+		t.Errorf("expected the first tick at 300ms, got %v", tick.Sub(start))
+	}
+	select {
+	case <-ticker.C():
+		t.Errorf("expected ticks to be dropped for a slow receiver")
+	default:
+	}
+
+	v.Advance(time.Millisecond)
+	if fired := <-timer.C(); !fired.Equal(start.Add(time.Second)) {
+		t.Errorf("expected the timer to fire at 1s, got %v", fired.Sub(start))
+	}
+	if timer.Stop() {
+		t.Errorf("expected Stop to report a fired timer")
+	}
+	select {
+	case <-stopped.C():
+		t.Errorf("expected a stopped timer not to fire")
+	default:
+	}
+	if now := v.Now(); !now.Equal(start.Add(time.Second)) {
+		t.Errorf("expected the clock at 1s, got %v", now.Sub(start))
+	}
+	ticker.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		v.Sleep(time.Minute)
+		close(done)
+	}()
+	v.BlockUntil(1)
+	v.Advance(time.Minute)
+	<-done
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Results maps a benchmark name to its samples, one per -count run.
+type Results map[string]*Samples
+
+type Samples struct {
+	NsPerOp     []float64 `json:"ns_per_op"`
+	BytesPerOp  []float64 `json:"bytes_per_op,omitempty"`
+	AllocsPerOp []float64 `json:"allocs_per_op,omitempty"`
+}
+
+type Comparison struct {
+	Name       string
+	OldMean    float64
+	NewMean    float64
+	Delta      float64
+	P          float64
+	Regression bool
+}
+
+var benchLine = regexp.MustCompile(`^(Benchmark\S+?)(?:-\d+)?\s+\d+\s+(.*)$`)
+
+// parseOutput collects the results of `go test -bench` output. Benchmark
+// names are qualified with the package printed in the "pkg:" header lines
+// so that identically named benchmarks in different packages stay apart.
+func parseOutput(r io.Reader) (Results, error) {
+	results := make(Results)
+	pkg := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if after, ok := strings.CutPrefix(line, "pkg: "); ok {
+			pkg = strings.TrimSpace(after)
+			continue
+		}
+
+		m := benchLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		name := m[1]
+		if pkg != "" {
+			name = pkg + "." + name
+		}
+		samples, found := results[name]
+		if !found {
+			samples = &Samples{}
+			results[name] = samples
+		}
+
+		fields := strings.Fields(m[2])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				samples.NsPerOp = append(samples.NsPerOp, value)
+			case "B/op":
+				samples.BytesPerOp = append(samples.BytesPerOp, value)
+			case "allocs/op":
+				samples.AllocsPerOp = append(samples.AllocsPerOp, value)
+			}
+		}
+	}
+
+	return results, scanner.Err()
+}
+
+// compare reports every benchmark present in both result sets. A
+// benchmark regressed when its mean time per operation grew by more than
+// threshold (a fraction) and Welch's t-test gives p < alpha.
+func compare(old, new Results, threshold, alpha float64) []Comparison {
+	var comparisons []Comparison
+	for name, oldSamples := range old {
+		newSamples, found := new[name]
+		if !found {
+			continue
+		}
+
+		oldMean, newMean := mean(oldSamples.NsPerOp), mean(newSamples.NsPerOp)
+		if oldMean == 0 {
+			continue
+		}
+		delta := (newMean - oldMean) / oldMean
+		p := welchTTest(oldSamples.NsPerOp, newSamples.NsPerOp)
+
+		comparisons = append(comparisons, Comparison{
+			Name:       name,
+			OldMean:    oldMean,
+			NewMean:    newMean,
+			Delta:      delta,
+			P:          p,
+			Regression: delta > threshold && p < alpha,
+		})
+	}
+
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Name < comparisons[j].Name
+	})
+	return comparisons
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func variance(xs []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	m := mean(xs)
+	sum := 0.0
+	for _, x := range xs {
+		sum += (x - m) * (x - m)
+	}
+	return sum / float64(len(xs)-1)
+}
+
+// welchTTest returns the two-sided p-value of Welch's t-test. It returns 1
+// when there are too few samples to tell anything apart.
+func welchTTest(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+
+	na, nb := float64(len(a)), float64(len(b))
+	va, vb := variance(a)/na, variance(b)/nb
+	if va+vb == 0 {
+		if mean(a) == mean(b) {
+			return 1
+		}
+		return 0
+	}
+
+	t := (mean(a) - mean(b)) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/(na-1) + vb*vb/(nb-1))
+
+	return regularizedBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// regularizedBeta computes the regularized incomplete beta function
+// I_x(a, b) with the continued fraction from Numerical Recipes.
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+
+	lbeta, _ := math.Lgamma(a + b)
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	front := math.Exp(lbeta - la - lb + a*math.Log(x) + b*math.Log(1-x))
+
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(x, a, b) / a
+	}
+	return 1 - front*betaContinuedFraction(1-x, b, a)/b
+}
+
+func betaContinuedFraction(x, a, b float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-12
+		tiny          = 1e-300
+	)
+
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+
+	return h
+}
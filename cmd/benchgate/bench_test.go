@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOutput(t *testing.T) {
+	output := `goos: linux
+goarch: amd64
+pkg: github.com/rizalta/toydb/storage
+cpu: Some CPU
+BenchmarkPut-8   	  200000	      5123 ns/op	     120 B/op	       3 allocs/op
+BenchmarkPut-8   	  200000	      5200 ns/op	     120 B/op	       3 allocs/op
+BenchmarkGet     	 1000000	      1100 ns/op
+PASS
+pkg: github.com/rizalta/toydb/index
+BenchmarkPut-8   	  100000	      9000 ns/op
+ok  	github.com/rizalta/toydb/storage	3.1s
+`
+
+	results, err := parseOutput(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("failed to parse output: %v", err)
+	}
+
+	expected := Results{
+		"github.com/rizalta/toydb/storage.BenchmarkPut": {
+			NsPerOp:     []float64{5123, 5200},
+			BytesPerOp:  []float64{120, 120},
+			AllocsPerOp: []float64{3, 3},
+		},
+		"github.com/rizalta/toydb/storage.BenchmarkGet": {NsPerOp: []float64{1100}},
+		"github.com/rizalta/toydb/index.BenchmarkPut":   {NsPerOp: []float64{9000}},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %+v, got %+v", expected, results)
+	}
+}
+
+func TestWelchTTest(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     []float64
+		expected float64
+	}{
+		{
+			name:     "identical samples",
+			a:        []float64{10, 11, 12},
+			b:        []float64{10, 11, 12},
+			expected: 1,
+		},
+		{
+			name:     "different means",
+			a:        []float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4},
+			b:        []float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4},
+			expected: 0.0210,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := welchTTest(tt.a, tt.b)
+			if math.Abs(p-tt.expected) > 0.001 {
+				t.Errorf("expected p %.4f, got %.4f", tt.expected, p)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old := Results{
+		"BenchmarkStable": {NsPerOp: []float64{100, 101, 99, 100, 100}},
+		"BenchmarkSlower": {NsPerOp: []float64{100, 101, 99, 100, 100}},
+		"BenchmarkNoisy":  {NsPerOp: []float64{100, 200, 50, 150, 100}},
+		"BenchmarkGone":   {NsPerOp: []float64{100}},
+	}
+	new := Results{
+		"BenchmarkStable": {NsPerOp: []float64{100, 100, 101, 99, 100}},
+		"BenchmarkSlower": {NsPerOp: []float64{130, 131, 129, 130, 130}},
+		"BenchmarkNoisy":  {NsPerOp: []float64{120, 240, 60, 180, 120}},
+	}
+
+	comparisons := compare(old, new, 0.05, 0.05)
+	if len(comparisons) != 3 {
+		t.Fatalf("expected 3 comparisons, got %d", len(comparisons))
+	}
+
+	regressions := map[string]bool{}
+	for _, c := range comparisons {
+		regressions[c.Name] = c.Regression
+	}
+	expected := map[string]bool{
+		"BenchmarkStable": false,
+		"BenchmarkSlower": true,
+		"BenchmarkNoisy":  false,
+	}
+	if !reflect.DeepEqual(regressions, expected) {
+		t.Errorf("expected regressions %v, got %v", expected, regressions)
+	}
+}
@@ -0,0 +1,135 @@
+// Command benchgate runs the toydb benchmark suite, stores the results as
+// JSON and compares two result files, failing when a benchmark regressed
+// by a statistically significant amount.
+//
+//	benchgate run -o before.json ./...
+//	# apply the change
+//	benchgate run -o after.json ./...
+//	benchgate compare before.json after.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"text/tabwriter"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = run(os.Args[2:])
+	case "compare":
+		var regressed bool
+		regressed, err = compareFiles(os.Args[2:])
+		if err == nil && regressed {
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "benchgate:", err)
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: benchgate run [-o file] [-bench regexp] [-count n] [packages]")
+	fmt.Fprintln(os.Stderr, "       benchgate compare [-threshold f] [-alpha f] old.json new.json")
+	os.Exit(2)
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	output := fs.String("o", "bench.json", "file to write the results to")
+	bench := fs.String("bench", ".", "benchmarks to run")
+	count := fs.Int("count", 10, "number of samples per benchmark")
+	fs.Parse(args)
+
+	packages := fs.Args()
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+
+	goArgs := []string{"test", "-run", "^$", "-bench", *bench, "-benchmem", "-count", fmt.Sprint(*count)}
+	cmd := exec.Command("go", append(goArgs, packages...)...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	results, err := parseOutput(io.TeeReader(stdout, os.Stdout))
+	if err != nil {
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*output, data, 0o644)
+}
+
+func readResults(path string) (Results, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var results Results
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return results, nil
+}
+
+func compareFiles(args []string) (bool, error) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	threshold := fs.Float64("threshold", 0.05, "minimum relative slowdown to report")
+	alpha := fs.Float64("alpha", 0.05, "significance level")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	old, err := readResults(fs.Arg(0))
+	if err != nil {
+		return false, err
+	}
+	new, err := readResults(fs.Arg(1))
+	if err != nil {
+		return false, err
+	}
+
+	regressed := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "benchmark\told ns/op\tnew ns/op\tdelta\tp\t")
+	for _, c := range compare(old, new, *threshold, *alpha) {
+		mark := ""
+		if c.Regression {
+			mark = "REGRESSION"
+			regressed = true
+		}
+		fmt.Fprintf(w, "%s\t%.0f\t%.0f\t%+.2f%%\t%.3f\t%s\n", c.Name, c.OldMean, c.NewMean, c.Delta*100, c.P, mark)
+	}
+
+	return regressed, w.Flush()
+}
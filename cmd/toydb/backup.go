@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/rizalta/toydb/db"
+)
+
+// backup writes a backup of the database in dir to a file, or to standard
+// output if there is none or it is -. It opens the database read-only, so
+// it can run next to other readers.
+func backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		usage()
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	if fs.NArg() == 1 || fs.Arg(1) == "-" {
+		return database.Backup(os.Stdout)
+	}
+	f, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if err := database.Backup(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// restore writes the backup in a file, or on standard input if it is -, to
+// dir, which must be empty or not exist yet, and opens the database there
+// once to rebuild its index, so that it can be opened read-only.
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := db.Restore(r, fs.Arg(1)); err != nil {
+		return err
+	}
+	database, err := db.Open(fs.Arg(1), db.Options{})
+	if err != nil {
+		return err
+	}
+	return database.Close()
+}
+
+// compact writes a copy of the database in dir to copy without the dead
+// records of its data log. The copy takes the place of the database once
+// the database is closed and the directories are swapped.
+func compact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	return errors.Join(database.CompactTo(fs.Arg(1)), database.Close())
+}
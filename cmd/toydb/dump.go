@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/rizalta/toydb/db"
+)
+
+// dump writes the tables of the database in dir to standard output as SQL
+// statements. It opens the database read-only, so it can run next to
+// other readers.
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	return errors.Join(database.DumpSQL(os.Stdout), database.Close())
+}
+
+// load runs the statements of a dump, read from a file or from standard
+// input, against the database in dir, creating it if need be.
+func load(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		usage()
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() == 2 {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{})
+	if err != nil {
+		return err
+	}
+	return errors.Join(database.LoadSQL(r), database.Close())
+}
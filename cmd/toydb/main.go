@@ -0,0 +1,128 @@
+// Command toydb runs maintenance tasks on toydb data directories, so that
+// they need no Go code of their own.
+//
+//	toydb shell dir
+//	toydb query [-f table|csv|json] [-order columns] [-offset n] [-limit n] dir table
+//	toydb dump dir > dump.sql
+//	toydb load dir [dump.sql]
+//	toydb backup dir [file]
+//	toydb restore file dir
+//	toydb compact dir copy
+//	toydb verify [-engine btree|lsm] [-repair reindex|purge] dir
+//	toydb scrub [-rate bytes] [-reference backup] dir
+//	toydb stats dir
+//	toydb index-viz [-depth n] [-start key] [-end key] dir | dot -Tsvg > tree.svg
+//	toydb wal inspect [-q] dir
+//	toydb wal replay [-lsn offset] dir copy
+//	toydb wal restore -time t copy source...
+//
+// The data log of a store is its write-ahead log, a series of segment
+// files whose record positions serve as log sequence numbers.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/pager"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "shell":
+		err = shell(os.Args[2:])
+	case "query":
+		err = query(os.Args[2:])
+	case "dump":
+		err = dump(os.Args[2:])
+	case "load":
+		err = load(os.Args[2:])
+	case "backup":
+		err = backup(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	case "compact":
+		err = compact(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2:])
+	case "scrub":
+		err = scrub(os.Args[2:])
+	case "stats":
+		err = stats(os.Args[2:])
+	case "index-viz":
+		err = indexViz(os.Args[2:])
+	case "wal":
+		err = wal(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "toydb:", err)
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: toydb shell dir")
+	fmt.Fprintln(os.Stderr, "       toydb query [-f table|csv|json] [-order columns] [-offset n] [-limit n] dir table")
+	fmt.Fprintln(os.Stderr, "       toydb dump dir")
+	fmt.Fprintln(os.Stderr, "       toydb load dir [file]")
+	fmt.Fprintln(os.Stderr, "       toydb backup dir [file]")
+	fmt.Fprintln(os.Stderr, "       toydb restore file dir")
+	fmt.Fprintln(os.Stderr, "       toydb compact dir copy")
+	fmt.Fprintln(os.Stderr, "       toydb verify [-engine btree|lsm] [-repair reindex|purge] dir")
+	fmt.Fprintln(os.Stderr, "       toydb scrub [-rate bytes] [-reference backup] dir")
+	fmt.Fprintln(os.Stderr, "       toydb stats dir")
+	fmt.Fprintln(os.Stderr, "       toydb index-viz [-depth n] [-start key] [-end key] dir")
+	fmt.Fprintln(os.Stderr, "       toydb wal inspect [-q] dir")
+	fmt.Fprintln(os.Stderr, "       toydb wal replay [-lsn offset] dir copy")
+	fmt.Fprintln(os.Stderr, "       toydb wal restore -time t copy source...")
+	os.Exit(2)
+}
+
+// indexViz writes the index of a data directory that no store has open as
+// a Graphviz digraph to standard output.
+func indexViz(args []string) error {
+	fs := flag.NewFlagSet("index-viz", flag.ExitOnError)
+	depth := fs.Int("depth", 0, "deepest level to draw, 0 for all")
+	start := fs.String("start", "", "first key of the range to draw")
+	end := fs.String("end", "", "end of the range to draw, exclusive")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	path := filepath.Join(fs.Arg(0), "index.db")
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	p, err := pager.NewPager(path)
+	if err != nil {
+		return err
+	}
+	idx, err := index.NewIndex(p)
+	if err != nil {
+		p.Close()
+		return err
+	}
+
+	opts := index.DOTOptions{MaxDepth: *depth}
+	if *start != "" {
+		opts.Start = []byte(*start)
+	}
+	if *end != "" {
+		opts.End = []byte(*end)
+	}
+	return errors.Join(idx.DumpDOT(os.Stdout, opts), idx.Close())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/format"
+)
+
+// query writes the rows of a table in the database in dir to standard
+// output in the format named by -f, for use from scripts.
+func query(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	name := fs.String("f", "table", "output format: table, csv or json")
+	order := fs.String("order", "", "columns to sort by, comma-separated, each optionally followed by desc")
+	offset := fs.Int("offset", 0, "rows to skip")
+	limit := fs.Int("limit", 0, "most rows to write, 0 for all")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		usage()
+	}
+	f, err := format.Parse(*name)
+	if err != nil {
+		return fmt.Errorf("%w %q", err, *name)
+	}
+	orderBy, err := parseOrder(*order)
+	if err != nil {
+		return err
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	opts := db.QueryOptions{OrderBy: orderBy, Offset: *offset, Limit: *limit}
+	_, err = writeQuery(database, fs.Arg(1), opts, f)
+	return errors.Join(err, database.Close())
+}
+
+// writeQuery runs a query and writes its rows to standard output in format
+// f, returning the number of rows written.
+func writeQuery(database *db.Database, table string, opts db.QueryOptions, f format.Format) (int, error) {
+	schema, err := database.Schema(table)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := database.Query(table, opts)
+	if err != nil {
+		return 0, err
+	}
+	n, err := format.Write(os.Stdout, f, schema, rows)
+	return n, errors.Join(err, rows.Close())
+}
+
+// parseOrder parses an ORDER BY list such as "name, id desc".
+func parseOrder(s string) ([]db.OrderBy, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var orderBy []db.OrderBy
+	for item := range strings.SplitSeq(s, ",") {
+		fields := strings.Fields(item)
+		switch {
+		case len(fields) == 1:
+			orderBy = append(orderBy, db.OrderBy{Column: fields[0]})
+		case len(fields) == 2 && strings.EqualFold(fields[1], "asc"):
+			orderBy = append(orderBy, db.OrderBy{Column: fields[0]})
+		case len(fields) == 2 && strings.EqualFold(fields[1], "desc"):
+			orderBy = append(orderBy, db.OrderBy{Column: fields[0], Desc: true})
+		default:
+			return nil, fmt.Errorf("invalid order %q", strings.TrimSpace(item))
+		}
+	}
+	return orderBy, nil
+}
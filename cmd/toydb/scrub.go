@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/storage"
+)
+
+// scrub reads the data log of the database in dir, checking every record
+// against its checksum and against a second read of it, or of the backup
+// in the reference directory. It fails if any record rotted.
+func scrub(args []string) error {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	rate := fs.Int64("rate", 0, "bytes to read per second, 0 for no limit")
+	reference := fs.String("reference", "", "data directory of a backup to compare with")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	report, err := database.Scrub(storage.ScrubOptions{BytesPerSecond: *rate, Reference: *reference})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("data log: %d records, %d bytes, %d corrupt, %d mismatched, %d unreadable\n",
+		report.Records, report.Bytes, len(report.Corrupt), len(report.Mismatches), len(report.Unreadable))
+	for _, offset := range report.Corrupt {
+		fmt.Printf("  corrupt at %d\n", offset)
+	}
+	for _, m := range report.Mismatches {
+		fmt.Printf("  mismatch at %d: checksum %08x, then %08x\n", m.Offset, m.Expected, m.Actual)
+	}
+	for _, offset := range report.Unreadable {
+		fmt.Printf("  unreadable at %d\n", offset)
+	}
+
+	if !report.OK() {
+		return fmt.Errorf("found problems")
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/format"
+	"github.com/rizalta/toydb/tuple"
+)
+
+const shellHelp = `Statements end with a semicolon and run as toydb load runs a dump:
+  CREATE TABLE, CREATE INDEX and INSERT INTO ... VALUES.
+Commands:
+  .tables               list the tables
+  .schema table         show the columns and indexes of a table
+  .scan table [limit]   show the rows of a table, 100 by default, with the
+                        time and plan of the scan; Ctrl-C cancels it
+  .format [name]        show or set the output of .scan: table, csv or json
+  .dump                 write the tables as SQL statements
+  .help                 show this help
+  .quit                 leave the shell
+`
+
+// shell reads statements and commands from standard input and runs them
+// against the database in dir, creating it if need be.
+func shell(args []string) error {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{})
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	output := format.Table
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 16<<20)
+	var statement strings.Builder
+	for {
+		if statement.Len() == 0 {
+			fmt.Print("toydb> ")
+		} else {
+			fmt.Print("   ...> ")
+		}
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+		line := scanner.Text()
+
+		if statement.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			fields := strings.Fields(line)
+			if fields[0] == ".quit" {
+				return nil
+			}
+			if err := shellCommand(database, fields, &output); err != nil {
+				fmt.Println("error:", err)
+			}
+			continue
+		}
+
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if !strings.HasSuffix(strings.TrimSpace(line), ";") {
+			continue
+		}
+		start := time.Now()
+		if err := database.LoadSQL(strings.NewReader(statement.String())); err != nil {
+			fmt.Println("error:", err)
+		} else {
+			fmt.Printf("time: %s\n", time.Since(start).Round(time.Microsecond))
+		}
+		statement.Reset()
+	}
+}
+
+func shellCommand(database *db.Database, fields []string, output *format.Format) error {
+	switch {
+	case fields[0] == ".help":
+		fmt.Print(shellHelp)
+		return nil
+	case fields[0] == ".tables" && len(fields) == 1:
+		tables, err := database.Tables()
+		for _, name := range tables {
+			fmt.Println(name)
+		}
+		return err
+	case fields[0] == ".schema" && len(fields) == 2:
+		return shellSchema(database, fields[1])
+	case fields[0] == ".scan" && (len(fields) == 2 || len(fields) == 3):
+		limit := 100
+		if len(fields) == 3 {
+			var err error
+			if limit, err = strconv.Atoi(fields[2]); err != nil || limit <= 0 {
+				return fmt.Errorf("invalid limit %q", fields[2])
+			}
+		}
+		return shellScan(database, fields[1], limit, *output)
+	case fields[0] == ".format" && len(fields) == 1:
+		fmt.Println(*output)
+		return nil
+	case fields[0] == ".format" && len(fields) == 2:
+		f, err := format.Parse(fields[1])
+		if err != nil {
+			return fmt.Errorf("unknown format %q", fields[1])
+		}
+		*output = f
+		return nil
+	case fields[0] == ".dump" && len(fields) == 1:
+		return database.DumpSQL(os.Stdout)
+	default:
+		return fmt.Errorf("unknown command %q, see .help", strings.Join(fields, " "))
+	}
+}
+
+func shellSchema(database *db.Database, table string) error {
+	schema, err := database.Schema(table)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "COLUMN\tTYPE\tKEY\tNULL\tDEFAULT\tREFERENCES")
+	for _, c := range schema.Columns {
+		key, null, def, ref := "", "YES", "", ""
+		if c.IsPrimaryKey {
+			key = "PRIMARY"
+		}
+		if c.IsNotNull {
+			null = "NO"
+		}
+		if c.DefaultValue != nil {
+			def = formatValue(c.DefaultValue)
+		}
+		if c.References != nil {
+			ref = c.References.Table + "(" + c.References.Column + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, c.Type, key, null, def, ref)
+	}
+	for _, info := range schema.Indexes[1:] {
+		fmt.Fprintf(w, "index %s\t%s\t(%s)\n", info.Name, info.Type, strings.Join(info.Columns, ", "))
+	}
+	return w.Flush()
+}
+
+// shellScan writes the rows of a table and then the time the scan took and
+// its plan. Ctrl-C cancels the scan and returns to the prompt.
+func shellScan(database *db.Database, table string, limit int, output format.Format) error {
+	schema, err := database.Schema(table)
+	if err != nil {
+		return err
+	}
+	opts := db.QueryOptions{Limit: limit}
+	plan, err := database.Explain(table, opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	start := time.Now()
+	rows, err := database.QueryContext(ctx, table, opts)
+	if err != nil {
+		return err
+	}
+	progress := &progressRows{input: rows, last: start}
+	var input format.Rows = rows
+	if output == format.Table {
+		// A table is aligned once all of its rows are in, so count them on
+		// standard error meanwhile. CSV and JSON rows show as they come.
+		input = progress
+	}
+	n, err := format.Write(os.Stdout, output, schema, input)
+	progress.clear()
+	err = errors.Join(err, rows.Close())
+	elapsed := time.Since(start).Round(time.Microsecond)
+
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("canceled after %d rows, %s\n", n, elapsed)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("time: %s, plan: %s\n", elapsed, planSummary(plan))
+	return nil
+}
+
+// planSummary renders a plan on one line, outermost step first.
+func planSummary(plan *db.Plan) string {
+	var steps []string
+	for step := plan; step != nil; step = step.Input {
+		if step.Detail == "" {
+			steps = append(steps, step.Kind.String())
+		} else {
+			steps = append(steps, step.Kind.String()+" "+step.Detail)
+		}
+	}
+	return strings.Join(steps, " <- ")
+}
+
+// progressRows counts the rows read through it on standard error, at most
+// every progressInterval.
+type progressRows struct {
+	input format.Rows
+	n     int
+	last  time.Time
+	shown bool
+}
+
+const progressInterval = 200 * time.Millisecond
+
+func (p *progressRows) Next() (tuple.Tuple, error) {
+	row, err := p.input.Next()
+	if row != nil {
+		p.n++
+		if now := time.Now(); now.Sub(p.last) >= progressInterval {
+			fmt.Fprintf(os.Stderr, "\r%d rows", p.n)
+			p.last, p.shown = now, true
+		}
+	}
+	return row, err
+}
+
+// clear erases the counter, if it was shown.
+func (p *progressRows) clear() {
+	if p.shown {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+	}
+}
+
+func formatValue(v tuple.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return strconv.Quote(v)
+	case []byte:
+		return "x'" + hex.EncodeToString(v) + "'"
+	case time.Time:
+		return tuple.FormatTimestamp(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rizalta/toydb/db"
+)
+
+// stats prints the tables of the database in dir with their row counts
+// and the shape of its index.
+func stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	database, err := db.Open(fs.Arg(0), db.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	info, err := database.Info()
+	if err != nil {
+		return err
+	}
+	tables, err := database.Tables()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tROWS\tINDEXES")
+	for _, name := range tables {
+		schema, err := database.Schema(name)
+		if err != nil {
+			return err
+		}
+		rows, err := database.Count(name, nil, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", name, rows, len(schema.Indexes)-1)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d tables, %d streams\n", info.Tables, info.Streams)
+	if index := info.Index; index != nil {
+		fmt.Printf("index: height %d, %d pages, %d free, %.0f%% full, leaves %.0f%% full\n",
+			index.Height, index.Pages, index.FreePages, 100*index.AvgFill, 100*index.LeafFill)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/rizalta/toydb/storage"
+)
+
+// verify checks a data directory that no store has open: the checksums of
+// the data log, the structure and pages of the index, and that the index
+// and the log agree on every key. With -repair it drops the index entries
+// that dangle and reindexes or purges the orphans. It fails if anything
+// was wrong.
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	engine := fs.String("engine", "btree", "engine of the store, btree or lsm")
+	repair := fs.String("repair", "", "what to do about orphans, reindex or purge")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	var opts storage.VerifyOptions
+	switch *engine {
+	case "btree":
+		opts.Engine = storage.EngineBTree
+	case "lsm":
+		opts.Engine = storage.EngineLSM
+	default:
+		return fmt.Errorf("unknown engine %q", *engine)
+	}
+	switch *repair {
+	case "":
+		opts.Repair = storage.OrphanReport
+	case "reindex":
+		opts.Repair = storage.OrphanReindex
+	case "purge":
+		opts.Repair = storage.OrphanPurge
+	default:
+		return fmt.Errorf("unknown repair %q", *repair)
+	}
+
+	report, err := storage.Verify(fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("data log: %d records, %d of %d bytes readable, %d corrupt\n",
+		report.Log.Records, report.Log.End, report.Log.Size, len(report.Log.Corrupt))
+	for _, offset := range report.Log.Corrupt {
+		fmt.Printf("  corrupt at %d\n", offset)
+	}
+	if report.Index != nil {
+		fmt.Printf("index: %d of %d pages in the tree, %d free, %d leaked, %d leaves, %d keys, depth %d, %d problems\n",
+			report.Index.Pages, report.IndexPages, report.FreePages, report.LeakedPages,
+			report.Index.Leaves, report.Index.Keys, report.Index.Depth, len(report.Index.Problems))
+		for _, problem := range report.Index.Problems {
+			fmt.Printf("  page %d: %s: %s\n", problem.PageID, problem.Kind, problem.Detail)
+		}
+		if report.PartialPage {
+			fmt.Println("  file ends in the middle of a page")
+		}
+	}
+	fmt.Printf("dangling index entries: %d\n", len(report.Dangling))
+	for _, d := range report.Dangling {
+		fmt.Printf("  %q at %d: %s\n", d.Key, d.Offset, d.Reason)
+	}
+	fmt.Printf("orphan records: %d\n", len(report.Orphans))
+	for _, orphan := range report.Orphans {
+		kind := "missing"
+		if orphan.Kind == storage.OrphanStale {
+			kind = "stale"
+		}
+		fmt.Printf("  %q at %d: %s\n", orphan.Key, orphan.Offset, kind)
+	}
+
+	if !report.OK() {
+		if opts.Repair != storage.OrphanReport {
+			return fmt.Errorf("found problems; repaired the index entries and orphans")
+		}
+		return fmt.Errorf("found problems")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rizalta/toydb/storage"
+)
+
+func wal(args []string) error {
+	if len(args) < 1 {
+		usage()
+	}
+	switch args[0] {
+	case "inspect":
+		return walInspect(args[1:])
+	case "replay":
+		return walReplay(args[1:])
+	case "restore":
+		return walRestore(args[1:])
+	default:
+		usage()
+	}
+	return nil
+}
+
+// walInspect lists the records of the data log of a data directory that no
+// store has open and verifies the checksums of its batches. It fails if a
+// batch is corrupt.
+func walInspect(args []string) error {
+	fs := flag.NewFlagSet("wal inspect", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "only print the summary")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if !*quiet {
+		fmt.Fprintln(w, "LSN\tSIZE\tOP\tKEY\tBATCH")
+	}
+	report, err := storage.InspectLog(fs.Arg(0), func(r storage.LogRecord) error {
+		if *quiet {
+			return nil
+		}
+		batch := ""
+		switch {
+		case r.Type == storage.RecordTypeBatch && r.Intact:
+			batch = r.Checksum.String() + " ok"
+		case r.Type == storage.RecordTypeBatch:
+			batch = r.Checksum.String() + " MISMATCH"
+		case r.Batched:
+			batch = fmt.Sprint(r.Batch)
+		}
+		op := r.Type.String()
+		if r.Compressed {
+			op += " (compressed)"
+		}
+		key := fmt.Sprintf("%q", r.Key)
+		switch {
+		case r.Type == storage.RecordTypeTime:
+			key = r.Time.UTC().Format(time.RFC3339Nano)
+		case r.Encrypted:
+			key = "(encrypted)"
+		}
+		_, err := fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", r.Offset, r.Size, op, key, batch)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("data log: %d records, %d of %d bytes readable, %d corrupt\n",
+		report.Records, report.End, report.Size, len(report.Corrupt))
+	if len(report.Corrupt) > 0 {
+		return fmt.Errorf("corrupt records or batches at %v", report.Corrupt)
+	}
+	return nil
+}
+
+// walReplay builds a copy of a data directory as it was after the record
+// at an offset of its data log.
+func walReplay(args []string) error {
+	fs := flag.NewFlagSet("wal replay", flag.ExitOnError)
+	lsn := fs.Uint64("lsn", math.MaxUint64, "log position of the last record to replay, all by default")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		usage()
+	}
+	return storage.ReplayLog(fs.Arg(0), fs.Arg(1), *lsn)
+}
+
+// walRestore builds a copy of a database as it was at a point in time from
+// a base backup restored to the copy, if any, and the segments of the data
+// log in the source directories: an archive and the data directory.
+func walRestore(args []string) error {
+	fs := flag.NewFlagSet("wal restore", flag.ExitOnError)
+	at := fs.String("time", "", "RFC 3339 time to restore to")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 || *at == "" {
+		usage()
+	}
+	t, err := time.Parse(time.RFC3339Nano, *at)
+	if err != nil {
+		return err
+	}
+	return storage.RestoreToTime(fs.Arg(0), t, fs.Args()[1:]...)
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/rizalta/toydb/catalog"
+)
+
+// IndexSuggestion is a secondary index that filters seen since the
+// database was opened would have used.
+type IndexSuggestion struct {
+	Table   string
+	Name    string
+	Columns []string
+	// Queries is the number of scans that filtered on the column, and
+	// RowsScanned and RowsReturned the rows they read and returned.
+	Queries      int
+	RowsScanned  int64
+	RowsReturned int64
+	// Benefit estimates the rows those scans would not have read with the
+	// index, counting a row read through it as four as the planner does.
+	// It is an upper bound, as the index only narrows the scan to the rows
+	// matching its own column.
+	Benefit int64
+}
+
+type filterUsage struct {
+	queries  int
+	scanned  int64
+	returned int64
+}
+
+type usageID struct {
+	table  string
+	column string
+}
+
+// filterTelemetry records the columns that scans reading a whole table or
+// primary key range filtered on, with what those scans cost.
+type filterTelemetry struct {
+	mu      sync.Mutex
+	columns map[usageID]*filterUsage
+}
+
+func newFilterTelemetry() *filterTelemetry {
+	return &filterTelemetry{columns: make(map[usageID]*filterUsage)}
+}
+
+// trackScan returns a function for a Scanner to call when a scan of schema
+// with filter ends, which records the filtered columns that no index
+// serves, or nil if there are none.
+func (db *Database) trackScan(schema *catalog.Schema, filter Filter) func(scanned, returned int) {
+	var columns []string
+	for colIdx := range columnRanges(schema, filter) {
+		if colIdx != schema.PrimaryKeyIndex && !hasLeadingIndex(schema, schema.Columns[colIdx].Name) {
+			columns = append(columns, schema.Columns[colIdx].Name)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	return func(scanned, returned int) {
+		db.filters.mu.Lock()
+		defer db.filters.mu.Unlock()
+		for _, column := range columns {
+			id := usageID{table: schema.Name, column: column}
+			usage := db.filters.columns[id]
+			if usage == nil {
+				usage = &filterUsage{}
+				db.filters.columns[id] = usage
+			}
+			usage.queries++
+			usage.scanned += int64(scanned)
+			usage.returned += int64(returned)
+		}
+	}
+}
+
+// hasLeadingIndex reports whether a secondary index the planner can use
+// starts with column, or is being built to.
+func hasLeadingIndex(schema *catalog.Schema, column string) bool {
+	return slices.ContainsFunc(schema.Indexes, func(info *catalog.IndexInfo) bool {
+		return info.ID != 0 && info.Type == catalog.IndexBTree && info.Columns[0] == column
+	})
+}
+
+// IndexAdvisor suggests secondary indexes on the columns that queries and
+// scans filtered on without an index to read through, most beneficial
+// first. Only filters that the planner can turn into a range are counted:
+// comparisons joined by And. Columns indexed since are left out. The
+// telemetry is kept in memory and starts over when the database is opened.
+func (db *Database) IndexAdvisor() ([]IndexSuggestion, error) {
+	db.filters.mu.Lock()
+	usages := make(map[usageID]filterUsage, len(db.filters.columns))
+	for id, usage := range db.filters.columns {
+		usages[id] = *usage
+	}
+	db.filters.mu.Unlock()
+
+	var suggestions []IndexSuggestion
+	for id, usage := range usages {
+		schema, err := db.catalog.GetTable(id.table)
+		if err != nil {
+			return nil, err
+		}
+		if hasLeadingIndex(schema, id.column) {
+			continue
+		}
+		benefit := usage.scanned - 4*usage.returned
+		if benefit <= 0 {
+			continue
+		}
+		suggestions = append(suggestions, IndexSuggestion{
+			Table:        id.table,
+			Name:         fmt.Sprintf("%s_%s_idx", id.table, id.column),
+			Columns:      []string{id.column},
+			Queries:      usage.queries,
+			RowsScanned:  usage.scanned,
+			RowsReturned: usage.returned,
+			Benefit:      benefit,
+		})
+	}
+
+	slices.SortFunc(suggestions, func(a, b IndexSuggestion) int {
+		if a.Benefit != b.Benefit {
+			if a.Benefit > b.Benefit {
+				return -1
+			}
+			return 1
+		}
+		if a.Table != b.Table {
+			if a.Table < b.Table {
+				return -1
+			}
+			return 1
+		}
+		if a.Columns[0] < b.Columns[0] {
+			return -1
+		}
+		return 1
+	})
+	return suggestions, nil
+}
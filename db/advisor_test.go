@@ -0,0 +1,84 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestIndexAdvisor(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "customer", Type: catalog.TypeVarChar},
+		{Name: "status", Type: catalog.TypeInt},
+		{Name: "total", Type: catalog.TypeFloat},
+	}
+	if _, err := db.CreateTable("orders", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 1000 {
+		row := tuple.Tuple{int64(i), fmt.Sprintf("customer_%d", i%100), int64(i % 5), float64(i)}
+		if err := db.Insert("orders", row); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	query := func(filter Filter) {
+		t.Helper()
+		rows, err := db.Query("orders", QueryOptions{Filter: filter})
+		if err != nil {
+			t.Fatalf("failed to query: %v", err)
+		}
+		collectRows(t, rows)
+	}
+
+	for range 3 {
+		query(Where("customer", OpEqual, "customer_7"))
+	}
+	query(And(Where("status", OpEqual, int64(1)), Where("id", OpLess, int64(1000))))
+	// Neither primary key ranges, Or filters nor filters that keep most
+	// rows make a case for an index.
+	query(Where("id", OpLess, int64(10)))
+	query(Or(Where("total", OpLess, 1.0), Where("total", OpGreater, 998.0)))
+	scanner, err := db.Scan("orders", nil, nil, Where("total", OpGreaterEqual, 100.0))
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	collectRows(t, scanner)
+
+	suggestions, err := db.IndexAdvisor()
+	if err != nil {
+		t.Fatalf("failed to advise: %v", err)
+	}
+	expected := []IndexSuggestion{
+		{
+			Table: "orders", Name: "orders_customer_idx", Columns: []string{"customer"},
+			Queries: 3, RowsScanned: 3000, RowsReturned: 30, Benefit: 2880,
+		},
+		{
+			Table: "orders", Name: "orders_status_idx", Columns: []string{"status"},
+			Queries: 1, RowsScanned: 1000, RowsReturned: 200, Benefit: 200,
+		},
+	}
+	if !reflect.DeepEqual(suggestions, expected) {
+		t.Errorf("expected %+v, got %+v", expected, suggestions)
+	}
+
+	if _, err := db.CreateIndex("orders", "by_customer", []string{"customer"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	query(Where("customer", OpEqual, "customer_7"))
+	suggestions, err = db.IndexAdvisor()
+	if err != nil {
+		t.Fatalf("failed to advise: %v", err)
+	}
+	if !reflect.DeepEqual(suggestions, expected[1:]) {
+		t.Errorf("expected only %+v once customer is indexed, got %+v", expected[1:], suggestions)
+	}
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidAggregate = errors.New("db: aggregate does not apply to column type")
+
+type AggregateFunc int
+
+const (
+	AggCount AggregateFunc = iota
+	AggSum
+	AggMin
+	AggMax
+	AggAvg
+)
+
+// Aggregation computes Func over Column. Following SQL, NULLs are ignored,
+// and an empty Column with AggCount counts rows.
+type Aggregation struct {
+	Func   AggregateFunc
+	Column string
+}
+
+// Aggregate groups the rows of a table matching filter by the groupBy
+// columns and computes aggs for every group. Each result row holds the
+// group values followed by the aggregates, and groups come out ordered by
+// their values. Without groupBy there is exactly one result row, even for
+// an empty table.
+//
+// COUNT returns an int64 and AVG a float64. SUM keeps the column type and,
+// like MIN, MAX and AVG, is NULL for a group without non-NULL values.
+func (db *Database) Aggregate(tableName string, groupBy []string, aggs []Aggregation, filter Filter) (Rows, error) {
+	scanner, err := db.Scan(tableName, nil, nil, filter)
+	if err != nil {
+		return nil, err
+	}
+	schema := scanner.schema
+
+	groupColumns := make([]int, len(groupBy))
+	groupSchema := &catalog.Schema{Columns: make([]catalog.Column, len(groupBy))}
+	for i, name := range groupBy {
+		groupColumns[i] = columnIndex(schema, name)
+		if groupColumns[i] == -1 {
+			return nil, ErrColumnNotFound
+		}
+		groupSchema.Columns[i] = schema.Columns[groupColumns[i]]
+	}
+
+	aggColumns := make([]int, len(aggs))
+	for i, agg := range aggs {
+		aggColumns[i] = -1
+		if agg.Func == AggCount && agg.Column == "" {
+			continue
+		}
+
+		aggColumns[i] = columnIndex(schema, agg.Column)
+		if aggColumns[i] == -1 {
+			return nil, ErrColumnNotFound
+		}
+		if !isAggregateType(agg.Func, schema.Columns[aggColumns[i]].Type) {
+			return nil, ErrInvalidAggregate
+		}
+	}
+
+	type group struct {
+		values tuple.Tuple
+		states []aggState
+	}
+	groups := make(map[string]*group)
+
+	for {
+		row, err := scanner.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+
+		values := make(tuple.Tuple, len(groupColumns))
+		for i, col := range groupColumns {
+			values[i] = row[col]
+		}
+		groupKey, err := tuple.Serialize(values, groupSchema)
+		if err != nil {
+			return nil, err
+		}
+
+		g, found := groups[string(groupKey)]
+		if !found {
+			g = &group{values: values, states: make([]aggState, len(aggs))}
+			groups[string(groupKey)] = g
+		}
+
+		for i, col := range aggColumns {
+			if col == -1 {
+				g.states[i].count++
+				continue
+			}
+			g.states[i].add(aggs[i].Func, row[col])
+		}
+	}
+
+	if len(groupBy) == 0 && len(groups) == 0 {
+		groups[""] = &group{states: make([]aggState, len(aggs))}
+	}
+
+	results := make([]tuple.Tuple, 0, len(groups))
+	for _, g := range groups {
+		result := slices.Clone(g.values)
+		for i, state := range g.states {
+			result = append(result, state.result(aggs[i].Func))
+		}
+		results = append(results, result)
+	}
+	slices.SortFunc(results, func(a, b tuple.Tuple) int {
+		for i := range groupColumns {
+			if c := tuple.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+
+	return &tupleRows{rows: results}, nil
+}
+
+func columnIndex(schema *catalog.Schema, name string) int {
+	return slices.IndexFunc(schema.Columns, func(c catalog.Column) bool {
+		return c.Name == name
+	})
+}
+
+func isAggregateType(fn AggregateFunc, colType catalog.DataType) bool {
+	switch fn {
+	case AggCount, AggMin, AggMax:
+		return true
+	case AggSum, AggAvg:
+		return colType == catalog.TypeInt || colType == catalog.TypeFloat
+	default:
+		return false
+	}
+}
+
+// aggState accumulates one aggregate of one group.
+type aggState struct {
+	count int64
+	sum   tuple.Value
+	best  tuple.Value
+}
+
+func (s *aggState) add(fn AggregateFunc, value tuple.Value) {
+	if value == nil {
+		return
+	}
+	s.count++
+
+	switch fn {
+	case AggSum, AggAvg:
+		switch v := value.(type) {
+		case int64:
+			sum, _ := s.sum.(int64)
+			s.sum = sum + v
+		case float64:
+			sum, _ := s.sum.(float64)
+			s.sum = sum + v
+		}
+	case AggMin:
+		if s.best == nil || tuple.Compare(value, s.best) < 0 {
+			s.best = value
+		}
+	case AggMax:
+		if s.best == nil || tuple.Compare(value, s.best) > 0 {
+			s.best = value
+		}
+	}
+}
+
+func (s *aggState) result(fn AggregateFunc) tuple.Value {
+	switch fn {
+	case AggCount:
+		return s.count
+	case AggSum:
+		return s.sum
+	case AggAvg:
+		switch sum := s.sum.(type) {
+		case int64:
+			return float64(sum) / float64(s.count)
+		case float64:
+			return sum / float64(s.count)
+		}
+		return nil
+	default:
+		return s.best
+	}
+}
+
+// tupleRows serves rows that are already in memory.
+type tupleRows struct {
+	rows []tuple.Tuple
+}
+
+func (r *tupleRows) Next() (tuple.Tuple, error) {
+	if len(r.rows) == 0 {
+		return nil, nil
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func (r *tupleRows) Close() error {
+	r.rows = nil
+	return nil
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestAggregate(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "dept", Type: catalog.TypeVarChar},
+		{Name: "salary", Type: catalog.TypeInt},
+		{Name: "rating", Type: catalog.TypeFloat},
+	}
+	if _, err := db.CreateTable("staff", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateTable("empty", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	rows := []tuple.Tuple{
+		{int64(1), "eng", int64(100), float64(4)},
+		{int64(2), "eng", int64(300), float64(5)},
+		{int64(3), "ops", int64(200), nil},
+		{int64(4), "eng", nil, float64(3)},
+		{int64(5), nil, int64(50), float64(2)},
+		{int64(6), "ops", int64(250), float64(4.5)},
+	}
+	for _, row := range rows {
+		if err := db.Insert("staff", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		table    string
+		groupBy  []string
+		aggs     []Aggregation
+		filter   Filter
+		expected []tuple.Tuple
+	}{
+		{
+			name:    "group by department",
+			table:   "staff",
+			groupBy: []string{"dept"},
+			aggs: []Aggregation{
+				{Func: AggCount},
+				{Func: AggCount, Column: "salary"},
+				{Func: AggSum, Column: "salary"},
+				{Func: AggAvg, Column: "rating"},
+			},
+			expected: []tuple.Tuple{
+				{nil, int64(1), int64(1), int64(50), float64(2)},
+				{"eng", int64(3), int64(2), int64(400), float64(4)},
+				{"ops", int64(2), int64(2), int64(450), float64(4.5)},
+			},
+		},
+		{
+			name:  "whole table",
+			table: "staff",
+			aggs: []Aggregation{
+				{Func: AggMin, Column: "salary"},
+				{Func: AggMax, Column: "salary"},
+				{Func: AggMax, Column: "dept"},
+				{Func: AggAvg, Column: "salary"},
+				{Func: AggSum, Column: "rating"},
+			},
+			expected: []tuple.Tuple{
+				{int64(50), int64(300), "ops", float64(180), float64(18.5)},
+			},
+		},
+		{
+			name:     "with filter",
+			table:    "staff",
+			groupBy:  []string{"dept"},
+			aggs:     []Aggregation{{Func: AggMax, Column: "rating"}},
+			filter:   Where("salary", OpGreaterEqual, int64(200)),
+			expected: []tuple.Tuple{{"eng", float64(5)}, {"ops", float64(4.5)}},
+		},
+		{
+			name:     "empty table without groups",
+			table:    "empty",
+			aggs:     []Aggregation{{Func: AggCount}, {Func: AggSum, Column: "salary"}},
+			expected: []tuple.Tuple{{int64(0), nil}},
+		},
+		{
+			name:     "empty table with groups",
+			table:    "empty",
+			groupBy:  []string{"dept"},
+			aggs:     []Aggregation{{Func: AggCount}},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := db.Aggregate(tt.table, tt.groupBy, tt.aggs, tt.filter)
+			if err != nil {
+				t.Fatalf("failed to aggregate: %v", err)
+			}
+			if got := collectRows(t, result); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name     string
+		groupBy  []string
+		aggs     []Aggregation
+		expected error
+	}{
+		{"sum of strings", nil, []Aggregation{{Func: AggSum, Column: "dept"}}, ErrInvalidAggregate},
+		{"unknown group column", []string{"team"}, nil, ErrColumnNotFound},
+		{"unknown aggregate column", nil, []Aggregation{{Func: AggMin, Column: "age"}}, ErrColumnNotFound},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := db.Aggregate("staff", tt.groupBy, tt.aggs, nil); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+const defaultAnalyzeSample = 10000
+
+// AnalyzeTable collects statistics on a table and stores them in the
+// catalog, where the planner uses them to estimate how selective a
+// predicate is. Every row is counted, but the other statistics come from
+// a uniform sample of at most sampleSize rows, so memory use does not grow
+// with the table. A zero sampleSize uses defaultAnalyzeSample. Statistics
+// are not maintained on writes; analyze again after large changes.
+func (db *Database) AnalyzeTable(tableName string, sampleSize int) (*catalog.TableStats, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if sampleSize < 0 {
+		return nil, ErrInvalidQuery
+	}
+	if sampleSize == 0 {
+		sampleSize = defaultAnalyzeSample
+	}
+
+	// Reservoir sampling, seeded so that analyzing the same rows gives the
+	// same statistics.
+	r := rand.New(rand.NewSource(1))
+	var sample []tuple.Tuple
+	rowCount := 0
+	if err := db.forEachRow(schema, func(_ []byte, row tuple.Tuple) error {
+		rowCount++
+		if len(sample) < sampleSize {
+			sample = append(sample, row)
+		} else if i := r.Intn(rowCount); i < sampleSize {
+			sample[i] = row
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	stats := &catalog.TableStats{
+		RowCount:   int64(rowCount),
+		SampleSize: int64(len(sample)),
+		Columns:    make([]catalog.ColumnStats, len(schema.Columns)),
+		AnalyzedAt: db.clock.Now().UTC(),
+	}
+	for i, col := range schema.Columns {
+		stats.Columns[i] = analyzeColumn(col, i, sample, rowCount)
+	}
+
+	if err := db.catalog.SetStats(tableName, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func analyzeColumn(col catalog.Column, colIdx int, sample []tuple.Tuple, rowCount int) catalog.ColumnStats {
+	stats := catalog.ColumnStats{Name: col.Name, Type: col.Type}
+
+	counts := make(map[string]int)
+	nulls := 0
+	for _, row := range sample {
+		v := row[colIdx]
+		if v == nil {
+			nulls++
+			continue
+		}
+		counts[string(appendIndexValue(nil, v))]++
+		if stats.Min == nil || tuple.Compare(v, stats.Min) < 0 {
+			stats.Min = v
+		}
+		if stats.Max == nil || tuple.Compare(v, stats.Max) > 0 {
+			stats.Max = v
+		}
+	}
+	if len(sample) == 0 {
+		return stats
+	}
+
+	scale := float64(rowCount) / float64(len(sample))
+	stats.NullCount = int64(math.Round(float64(nulls) * scale))
+
+	// The sample sees every value if it is the whole table. Otherwise the
+	// number of distinct values is estimated with the GEE estimator: values
+	// seen more than once are likely common and fully counted, while each
+	// value seen once stands for sqrt(N/n) values.
+	nonNull := len(sample) - nulls
+	if scale == 1 || nonNull == 0 {
+		stats.Distinct = int64(len(counts))
+		return stats
+	}
+	singletons := 0
+	for _, n := range counts {
+		if n == 1 {
+			singletons++
+		}
+	}
+	distinct := math.Sqrt(scale)*float64(singletons) + float64(len(counts)-singletons)
+	stats.Distinct = int64(math.Round(min(distinct, float64(nonNull)*scale)))
+
+	return stats
+}
+
+// estimateRows estimates how many rows of a table have a column value in r,
+// or returns false if the table has not been analyzed.
+func estimateRows(schema *catalog.Schema, colIdx int, r *valueRange) (float64, bool) {
+	stats := schema.Stats
+	if stats == nil || colIdx >= len(stats.Columns) || stats.Columns[colIdx].Name != schema.Columns[colIdx].Name {
+		return 0, false
+	}
+	col := stats.Columns[colIdx]
+
+	nonNull := float64(stats.RowCount - col.NullCount)
+	if nonNull <= 0 {
+		return 0, true
+	}
+	if r.isPoint() {
+		return nonNull / float64(max(col.Distinct, 1)), true
+	}
+
+	low, high := numericValue(col.Min), numericValue(col.Max)
+	if math.IsNaN(low) || math.IsNaN(high) {
+		// Without a way to measure the range, assume a third of the rows.
+		return nonNull / 3, true
+	}
+	from, to := low, high
+	if r.low != nil {
+		from = max(from, numericValue(r.low.value))
+	}
+	if r.high != nil {
+		to = min(to, numericValue(r.high.value))
+	}
+	if to < from {
+		return 0, true
+	}
+	if high == low {
+		return nonNull, true
+	}
+	return nonNull * (to - from) / (high - low), true
+}
+
+// numericValue places a value on a line for interpolating ranges, or
+// returns NaN for values that do not have a useful position.
+func numericValue(v tuple.Value) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case time.Time:
+		return float64(v.UnixNano())
+	default:
+		return math.NaN()
+	}
+}
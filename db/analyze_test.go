@@ -0,0 +1,113 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestAnalyzeTable(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "status", Type: catalog.TypeVarChar},
+		{Name: "score", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("tickets", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for _, idx := range []string{"status", "score"} {
+		if _, err := db.CreateIndex("tickets", "by_"+idx, []string{idx}); err != nil {
+			t.Fatalf("failed to create index: %v", err)
+		}
+	}
+
+	statuses := []string{"open", "closed"}
+	for i := range 2000 {
+		row := tuple.Tuple{int64(i), statuses[i%2], int64((i * 7) % 1000)}
+		switch {
+		case i%100 == 0:
+			row[1] = "escalated"
+		case i%10 == 1:
+			row[1] = nil
+		}
+		if err := db.Insert("tickets", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+	}
+
+	explain := func(filter Filter) string {
+		t.Helper()
+		plan, err := db.Explain("tickets", QueryOptions{Filter: filter})
+		if err != nil {
+			t.Fatalf("failed to explain: %v", err)
+		}
+		for plan.Input != nil {
+			plan = plan.Input
+		}
+		return fmt.Sprintf("%s %s", plan.Kind, plan.Detail)
+	}
+
+	if got := explain(Where("status", OpEqual, "open")); got != "IndexScan tickets using by_status (status = \"open\")" {
+		t.Errorf("expected an index scan before analyzing, got %s", got)
+	}
+
+	sampled, err := db.AnalyzeTable("tickets", 500)
+	if err != nil {
+		t.Fatalf("failed to analyze: %v", err)
+	}
+	if sampled.RowCount != 2000 || sampled.SampleSize != 500 {
+		t.Errorf("expected 2000 rows with a sample of 500, got %d and %d", sampled.RowCount, sampled.SampleSize)
+	}
+	if nulls := sampled.Columns[1].NullCount; nulls < 140 || nulls > 260 {
+		t.Errorf("expected about 200 NULL statuses, got %d", nulls)
+	}
+	if distinct := sampled.Columns[1].Distinct; distinct < 2 || distinct > 3 {
+		t.Errorf("expected 2 or 3 distinct statuses, got %d", distinct)
+	}
+
+	stats, err := db.AnalyzeTable("tickets", 0)
+	if err != nil {
+		t.Fatalf("failed to analyze: %v", err)
+	}
+	expected := []catalog.ColumnStats{
+		{Name: "id", Type: catalog.TypeInt, Distinct: 2000, Min: int64(0), Max: int64(1999)},
+		{Name: "status", Type: catalog.TypeVarChar, NullCount: 200, Distinct: 3, Min: "closed", Max: "open"},
+		{Name: "score", Type: catalog.TypeInt, Distinct: 1000, Min: int64(0), Max: int64(999)},
+	}
+	if !reflect.DeepEqual(stats.Columns, expected) {
+		t.Errorf("expected column stats %v, got %v", expected, stats.Columns)
+	}
+
+	schema, err := db.catalog.GetTable("tickets")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+	if schema.Stats == nil || !reflect.DeepEqual(schema.Stats.Columns, expected) {
+		t.Errorf("expected stats to be persisted in the catalog, got %v", schema.Stats)
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected string
+	}{
+		{"common value scans the table", Where("status", OpEqual, "open"), "FullScan tickets ~2000 rows"},
+		{"narrow range uses the index", Where("score", OpGreater, int64(989)), "IndexScan tickets using by_score (score > 989) ~20 rows"},
+		{"wide range scans the table", Where("score", OpGreater, int64(100)), "FullScan tickets ~2000 rows"},
+		{"key range beats index", And(Where("id", OpLess, int64(50)), Where("id", OpGreaterEqual, int64(0)), Where("score", OpLess, int64(500))), "KeyRange tickets (id >= 0 AND id < 50) ~50 rows"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := explain(tt.filter); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package db
+
+import "github.com/rizalta/toydb/catalog"
+
+// The users and roles of a database live in its catalog. The database
+// itself does not check them; servers do, for the clients that log in to
+// them. Grants name tables, or catalog.KeyValue for the key-value
+// namespace.
+
+// CreateUser adds a user that logs in with password. A superuser may do
+// anything, including creating tables.
+func (db *Database) CreateUser(name, password string, superuser bool) error {
+	return db.catalog.CreateUser(name, password, superuser)
+}
+
+// CreateRole adds a role, which holds grants for the users given it.
+func (db *Database) CreateRole(name string) error {
+	return db.catalog.CreateRole(name)
+}
+
+// DropPrincipal removes a user or role.
+func (db *Database) DropPrincipal(name string) error {
+	return db.catalog.DropPrincipal(name)
+}
+
+func (db *Database) SetPassword(name, password string) error {
+	return db.catalog.SetPassword(name, password)
+}
+
+// Principal returns the user or role with the given name.
+func (db *Database) Principal(name string) (*catalog.Principal, error) {
+	return db.catalog.GetPrincipal(name)
+}
+
+// Principals returns the names of the users and roles in sorted order.
+func (db *Database) Principals() ([]string, error) {
+	return db.catalog.Principals()
+}
+
+// Authenticate checks the password of a user and returns the user, or
+// catalog.ErrAuthFailed.
+func (db *Database) Authenticate(name, password string) (*catalog.Principal, error) {
+	return db.catalog.Authenticate(name, password)
+}
+
+// Grant adds privileges on a table to a user or role.
+func (db *Database) Grant(name, object string, privileges catalog.Privilege) error {
+	return db.catalog.Grant(name, object, privileges)
+}
+
+// Revoke takes privileges on a table away from a user or role.
+func (db *Database) Revoke(name, object string, privileges catalog.Privilege) error {
+	return db.catalog.Revoke(name, object, privileges)
+}
+
+// GrantRole gives a role to a user.
+func (db *Database) GrantRole(name, role string) error {
+	return db.catalog.GrantRole(name, role)
+}
+
+// RevokeRole takes a role away from a user.
+func (db *Database) RevokeRole(name, role string) error {
+	return db.catalog.RevokeRole(name, role)
+}
+
+// Privileges returns the privileges a user holds on a table, directly and
+// through its roles.
+func (db *Database) Privileges(name, object string) (catalog.Privilege, error) {
+	return db.catalog.Privileges(name, object)
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"bytes"
+
+	"github.com/rizalta/toydb/catalog"
+)
+
+const defaultBackfillBatch = 1000
+
+// IndexBuild indexes the rows that were in a table before one of its
+// secondary indexes was created.
+type IndexBuild struct {
+	db        *Database
+	tableName string
+	info      *catalog.IndexInfo
+	// tableID and nextKey are where the scan goes on. A rewrite moves the
+	// table to a new ID, and the scan then starts over.
+	tableID uint32
+	nextKey []byte
+	done    bool
+	// Rows counts the rows scanned so far.
+	Rows int
+}
+
+// BuildIndex adds a secondary index to a table without blocking writes to
+// it for the whole backfill. The index is created marked as building, so
+// queries do not use it yet, while every write from then on maintains its
+// entries. Step then indexes the existing rows a batch at a time, and other
+// writes may run between the steps.
+//
+// A write behind the scan position keeps the entries of its row right by
+// itself, and a row written ahead of it is indexed again when the scan gets
+// there, so the entries it has are kept. The last step catches up with any
+// rows inserted during the build before it finishes the index.
+func (db *Database) BuildIndex(tableName, indexName string, columns []string, opts catalog.IndexOptions) (*IndexBuild, error) {
+	opts.Building = true
+	info, err := db.catalog.CreateIndexWithOptions(tableName, indexName, columns, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IndexBuild{db: db, tableName: tableName, info: info}, nil
+}
+
+// Step indexes up to batchSize more rows, 1000 if it is not positive, and
+// reports whether the build is done. Once the scan has reached the end of
+// the table, the index is marked as built and queries may use it.
+func (b *IndexBuild) Step(batchSize int) (bool, error) {
+	if b.done {
+		return true, nil
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBackfillBatch
+	}
+
+	schema, err := b.db.catalog.GetTable(b.tableName)
+	if err != nil {
+		return false, err
+	}
+	var info *catalog.IndexInfo
+	for _, candidate := range schema.Indexes {
+		if candidate.ID == b.info.ID {
+			info = candidate
+		}
+	}
+	if info == nil {
+		return false, ErrIndexNotFound
+	}
+
+	startKey, endKey := tableKeyRange(schema.ID)
+	if b.nextKey != nil && b.tableID == schema.ID {
+		startKey = b.nextKey
+	}
+	b.tableID = schema.ID
+
+	iterator, err := b.db.store.NewIterator(startKey, endKey)
+	if err != nil {
+		return false, err
+	}
+	for range batchSize {
+		key, value, err := iterator.Next()
+		if err != nil {
+			return false, err
+		}
+		if key == nil {
+			return b.finish()
+		}
+
+		row, err := b.db.decodeRow(schema, value)
+		if err != nil {
+			return false, err
+		}
+		entry, err := indexEntryKey(schema, info, row, key)
+		if err != nil {
+			return false, err
+		}
+		if entry != nil {
+			if found, err := b.db.store.Has(entry); err != nil {
+				return false, err
+			} else if !found {
+				if err := b.db.store.Put(entry, referenceMarker); err != nil {
+					return false, err
+				}
+			}
+		}
+
+		b.nextKey = append(bytes.Clone(key), 0)
+		b.Rows++
+	}
+	return false, nil
+}
+
+func (b *IndexBuild) finish() (bool, error) {
+	if err := b.db.catalog.FinishIndex(b.tableName, b.info.Name); err != nil {
+		return false, err
+	}
+	b.info.Building = false
+	b.done = true
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"io"
+	"time"
+
+	"github.com/rizalta/toydb/storage"
+)
+
+// Backup writes a consistent copy of the database, as it is when Backup is
+// called, to w as a tar archive that Restore reads.
+func (db *Database) Backup(w io.Writer) error {
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		return err
+	}
+	_, err = snapshot.WriteTo(w)
+	return err
+}
+
+// Snapshot marks the current state of the database for a backup. Unlike
+// Backup it returns at once: the snapshot can be written out from another
+// goroutine while the database takes further calls, which it does not
+// see.
+func (db *Database) Snapshot() (*storage.Snapshot, error) {
+	return db.store.Snapshot()
+}
+
+// Restore writes the backup r holds to dir, which must be empty or not
+// exist yet, for Open to open. The first open rebuilds the indexes of the
+// store, as backups leave them out.
+func Restore(r io.Reader, dir string) error {
+	return storage.Restore(r, dir)
+}
+
+// RestoreToTime rebuilds in dir the database as it was at time t, for a
+// database opened with Options.ArchiveDir. dir holds a base backup written
+// there by Restore, or nothing; the log after it comes from sources, which
+// are the archive and the directory of the database itself, for the writes
+// not archived yet. See storage.RestoreToTime.
+func RestoreToTime(dir string, t time.Time, sources ...string) error {
+	return storage.RestoreToTime(dir, t, sources...)
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestBackup(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 50 {
+		if err := db.Insert("users", tuple.Tuple{int64(i), "user"}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	var want bytes.Buffer
+	if err := db.Dump(&want); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+
+	snapshot, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+	if err := db.Delete("users", int64(0)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, err := db.CreateTable("later", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	var backup bytes.Buffer
+	if _, err := snapshot.WriteTo(&backup); err != nil {
+		t.Fatalf("failed to write backup: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(&backup, dir); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	restored, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	var got bytes.Buffer
+	if err := restored.Dump(&got); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("expected the restored database to dump as\n%s\ngot\n%s", want.String(), got.String())
+	}
+
+	// Backup takes and writes a snapshot in one go.
+	backup.Reset()
+	if err := db.Backup(&backup); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	if err := Restore(&backup, filepath.Join(t.TempDir(), "again")); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"encoding/binary"
+	"iter"
+
+	"github.com/rizalta/toydb/catalog"
+)
+
+// TableChange is a committed write to a row of a table, as ChangeFeed
+// reads it from the data log.
+type TableChange struct {
+	// LSN is the log position of the write. ChangeFeed(LSN+1) goes on
+	// after it.
+	LSN   uint64
+	Table string
+	RowChange
+}
+
+// ChangeFeed returns the writes to the rows of tables that the data log
+// holds from position fromLSN on, up to where it ends when ChangeFeed is
+// called, for a consumer to index or mirror the tables. A consumer that
+// stores the LSN of the last change it handled picks up from there.
+//
+// Rows are decoded with the schema their table has now. A table that was
+// rewritten has a new ID, under which its rows are written again, and the
+// changes from before, like those of dropped tables, are left out. So are
+// streams, indexes and the key-value namespace. The database must not be
+// written to while the changes are read.
+func (db *Database) ChangeFeed(fromLSN uint64) iter.Seq2[TableChange, error] {
+	return func(yield func(TableChange, error) bool) {
+		tables, err := db.tablesByID()
+		if err != nil {
+			yield(TableChange{}, err)
+			return
+		}
+
+		for change, err := range db.store.Changes(fromLSN) {
+			if err != nil {
+				yield(TableChange{}, err)
+				return
+			}
+			if len(change.Key) <= 4 {
+				continue
+			}
+			schema, ok := tables[binary.BigEndian.Uint32(change.Key)]
+			if !ok {
+				continue
+			}
+
+			tableChange := TableChange{LSN: change.Pos, Table: schema.Name, RowChange: RowChange{Op: change.Op}}
+			if change.OldValue != nil {
+				tableChange.Old, err = db.decodeRow(schema, change.OldValue)
+			}
+			if err == nil && change.NewValue != nil {
+				tableChange.New, err = db.decodeRow(schema, change.NewValue)
+			}
+			if err != nil {
+				yield(TableChange{}, err)
+				return
+			}
+			if !yield(tableChange, nil) {
+				return
+			}
+		}
+	}
+}
+
+// tablesByID returns the schema of every table by its ID.
+func (db *Database) tablesByID() (map[uint32]*catalog.Schema, error) {
+	names, err := db.catalog.Tables()
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[uint32]*catalog.Schema, len(names))
+	for _, name := range names {
+		schema, err := db.catalog.GetTable(name)
+		if err != nil {
+			return nil, err
+		}
+		tables[schema.ID] = schema
+	}
+	return tables, nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestChangeFeed(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	for _, table := range []string{"users", "teams"} {
+		if _, err := db.CreateTable(table, columns); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	if _, err := db.CreateIndex("users", "by_name", []string{"name"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	steps := []func() error{
+		func() error { return db.Insert("users", tuple.Tuple{int64(1), "alice"}) },
+		func() error { return db.KV().Put([]byte("left"), []byte("out")) },
+		func() error { return db.Insert("teams", tuple.Tuple{int64(7), "core"}) },
+		func() error { return db.Update("users", tuple.Tuple{int64(1), "alicia"}) },
+		func() error { return db.Delete("users", int64(1)) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	expected := []TableChange{
+		{Table: "users", RowChange: RowChange{Op: storage.ChangePut, New: tuple.Tuple{int64(1), "alice"}}},
+		{Table: "teams", RowChange: RowChange{Op: storage.ChangePut, New: tuple.Tuple{int64(7), "core"}}},
+		{Table: "users", RowChange: RowChange{Op: storage.ChangePut, Old: tuple.Tuple{int64(1), "alice"}, New: tuple.Tuple{int64(1), "alicia"}}},
+		{Table: "users", RowChange: RowChange{Op: storage.ChangeDelete, Old: tuple.Tuple{int64(1), "alicia"}}},
+	}
+	feed := func(from uint64) []TableChange {
+		t.Helper()
+		var changes []TableChange
+		for change, err := range db.ChangeFeed(from) {
+			if err != nil {
+				t.Fatalf("failed to read the feed: %v", err)
+			}
+			changes = append(changes, change)
+		}
+		return changes
+	}
+
+	changes := feed(0)
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, change := range changes {
+		change.LSN = 0
+		if !reflect.DeepEqual(change, expected[i]) {
+			t.Errorf("expected %+v, got %+v", expected[i], change)
+		}
+	}
+
+	// A consumer resumes after the last change it handled.
+	rest := feed(changes[1].LSN + 1)
+	if len(rest) != 2 || !reflect.DeepEqual(rest[0], changes[2]) || !reflect.DeepEqual(rest[1], changes[3]) {
+		t.Errorf("expected %+v, got %+v", changes[2:], rest)
+	}
+	if rest := feed(db.LogEnd()); len(rest) != 0 {
+		t.Errorf("expected nothing past the end of the log, got %+v", rest)
+	}
+}
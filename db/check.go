@@ -0,0 +1,86 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/expr"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrCheckViolation = errors.New("db: check constraint violation")
+
+type exprFilter struct {
+	e expr.Expr
+}
+
+// WhereExpr matches rows for which a boolean expression is true. Rows for
+// which it is NULL or fails to evaluate, say on a division by zero, do not
+// match.
+func WhereExpr(e expr.Expr) Filter {
+	return exprFilter{e: e}
+}
+
+func (f exprFilter) String() string {
+	return f.e.String()
+}
+
+func (f exprFilter) bind(schema *catalog.Schema) (predicate, error) {
+	p, err := compileCondition(f.e, schema)
+	if err != nil {
+		return nil, err
+	}
+	return func(row tuple.Tuple) bool {
+		ok, err := p.Match(row)
+		return err == nil && ok
+	}, nil
+}
+
+func compileCondition(e expr.Expr, schema *catalog.Schema) (*expr.Program, error) {
+	p, err := expr.Compile(e, schema)
+	if err != nil {
+		return nil, err
+	}
+	if p.Type() != catalog.TypeBoolean {
+		return nil, ErrInvalidFilter
+	}
+	return p, nil
+}
+
+// RegisterCheck adds a CHECK constraint to a table: rows written to it by
+// Insert, InsertNamed, Update and RewriteTable must not make the boolean
+// expression false. As in SQL, a NULL result passes. Like validators,
+// checks are kept in memory only and have to be registered again after the
+// database is reopened.
+func (db *Database) RegisterCheck(tableName string, e expr.Expr) error {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	if _, err := compileCondition(e, schema); err != nil {
+		return err
+	}
+
+	db.checks[tableName] = append(db.checks[tableName], e)
+	return nil
+}
+
+// checkConstraints evaluates the CHECK constraints of a table on a row.
+// They are compiled against the schema the row is written with, so they
+// follow columns that move in a rewrite.
+func (db *Database) checkConstraints(schema *catalog.Schema, row tuple.Tuple) error {
+	for _, e := range db.checks[schema.Name] {
+		p, err := compileCondition(e, schema)
+		if err != nil {
+			return err
+		}
+		v, err := p.Eval(row)
+		if err != nil {
+			return err
+		}
+		if v == false {
+			return ErrCheckViolation
+		}
+	}
+	return nil
+}
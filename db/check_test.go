@@ -0,0 +1,123 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/expr"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestWhereExpr(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	if _, err := db.CreateTable("items", []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+		{Name: "price", Type: catalog.TypeFloat},
+		{Name: "qty", Type: catalog.TypeInt},
+	}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for _, row := range []tuple.Tuple{
+		{int64(1), "Bolt", 0.25, int64(100)},
+		{int64(2), "bracket", 4.0, int64(3)},
+		{int64(3), "Nut", 0.1, int64(0)},
+		{int64(4), nil, 12.0, int64(2)},
+	} {
+		if err := db.Insert("items", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []int64
+	}{
+		{"computed value", WhereExpr(expr.Gt(expr.Mul(expr.Col("price"), expr.Col("qty")), expr.Lit(int64(20)))), []int64{1, 4}},
+		{"string function", WhereExpr(expr.Eq(expr.Func("substr", expr.Func("lower", expr.Col("name")), expr.Lit(int64(1)), expr.Lit(int64(1))), expr.Lit("b"))), []int64{1, 2}},
+		{"evaluation error does not match", WhereExpr(expr.Lt(expr.Div(expr.Col("price"), expr.Col("qty")), expr.Lit(1.0))), []int64{1}},
+		{"combined with where", And(Where("id", OpGreater, int64(1)), WhereExpr(expr.IsNotNull(expr.Col("name")))), []int64{2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.Query("items", QueryOptions{Filter: tt.filter})
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+			var got []int64
+			for _, row := range collectRows(t, rows) {
+				got = append(got, row[0].(int64))
+			}
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("expected ids %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := db.Query("items", QueryOptions{Filter: WhereExpr(expr.Add(expr.Col("qty"), expr.Lit(int64(1))))}); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected %v for a non-boolean expression, got %v", ErrInvalidFilter, err)
+	}
+	if _, err := db.Query("items", QueryOptions{Filter: WhereExpr(expr.Eq(expr.Col("name"), expr.Lit(int64(1))))}); !errors.Is(err, expr.ErrTypeMismatch) {
+		t.Errorf("expected %v, got %v", expr.ErrTypeMismatch, err)
+	}
+}
+
+func TestRegisterCheck(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	if _, err := db.CreateTable("accounts", []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "balance", Type: catalog.TypeInt},
+		{Name: "code", Type: catalog.TypeVarChar},
+	}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	if err := db.RegisterCheck("accounts", expr.Ge(expr.Col("balance"), expr.Lit(int64(0)))); err != nil {
+		t.Fatalf("failed to register check: %v", err)
+	}
+	if err := db.RegisterCheck("accounts", expr.Eq(expr.Func("length", expr.Col("code")), expr.Lit(int64(3)))); err != nil {
+		t.Fatalf("failed to register check: %v", err)
+	}
+	if err := db.RegisterCheck("accounts", expr.Col("balance")); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected %v for a non-boolean check, got %v", ErrInvalidFilter, err)
+	}
+	if err := db.RegisterCheck("accounts", expr.IsNull(expr.Col("missing"))); !errors.Is(err, expr.ErrColumnNotFound) {
+		t.Errorf("expected %v, got %v", expr.ErrColumnNotFound, err)
+	}
+
+	tests := []struct {
+		name     string
+		write    func() error
+		expected error
+	}{
+		{"valid", func() error { return db.Insert("accounts", tuple.Tuple{int64(1), int64(10), "abc"}) }, nil},
+		{"null passes", func() error { return db.Insert("accounts", tuple.Tuple{int64(2), nil, nil}) }, nil},
+		{"negative balance", func() error { return db.Insert("accounts", tuple.Tuple{int64(3), int64(-1), "abc"}) }, ErrCheckViolation},
+		{"second check", func() error { return db.Insert("accounts", tuple.Tuple{int64(3), int64(1), "abcd"}) }, ErrCheckViolation},
+		{"update", func() error { return db.Update("accounts", tuple.Tuple{int64(1), int64(-5), "abc"}) }, ErrCheckViolation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.write(); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	row, _, err := db.Get("accounts", int64(1))
+	if err != nil {
+		t.Fatalf("failed to get row: %v", err)
+	}
+	if row[1] != int64(10) {
+		t.Errorf("expected rejected update to leave the row alone, got %v", row)
+	}
+}
@@ -0,0 +1,8 @@
+package db
+
+// CompactTo writes to dir, which must be empty or not exist yet, a copy of
+// the database without the dead records its data log holds, for Open to
+// open in its place. See storage.Store.CompactTo.
+func (db *Database) CompactTo(dir string) error {
+	return db.store.CompactTo(dir)
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestCompactTo(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("users", "by_name", []string{"name"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	for _, name := range []string{"first", "second", "third"} {
+		for i := range 20 {
+			if _, err := db.Upsert("users", tuple.Tuple{int64(i), name}); err != nil {
+				t.Fatalf("failed to upsert: %v", err)
+			}
+		}
+	}
+	for i := range 10 {
+		if err := db.Delete("users", int64(i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	var want bytes.Buffer
+	if err := db.Dump(&want); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "compacted")
+	if err := db.CompactTo(dir); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	compacted, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("failed to open compacted database: %v", err)
+	}
+	defer compacted.Close()
+	if compacted.LogEnd() >= db.LogEnd() {
+		t.Errorf("expected a shorter log than %d bytes, got %d", db.LogEnd(), compacted.LogEnd())
+	}
+	var got bytes.Buffer
+	if err := compacted.Dump(&got); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("expected:\n%s\ngot:\n%s", want.String(), got.String())
+	}
+
+	// The copy answers queries and takes writes.
+	n := 0
+	for _, err := range compacted.Rows("users", QueryOptions{Filter: Where("name", OpEqual, "third")}) {
+		if err != nil {
+			t.Fatalf("failed to query: %v", err)
+		}
+		n++
+	}
+	if n != 10 {
+		t.Errorf("expected 10 rows named third, got %d", n)
+	}
+	if err := compacted.Insert("users", tuple.Tuple{int64(0), "new"}); err != nil {
+		t.Errorf("failed to insert into the copy: %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/tuple"
+)
+
+// compatFixtures are database directories written by earlier releases
+// with testdata/compat/generate.go, each named after the on-disk format it
+// captures, oldest first. Capture a new one whenever a release changes the
+// format; every release has to open all of them.
+var compatFixtures = []string{
+	"baseline",
+	"dedup",
+	"prefix-nodes",
+	"meta-page",
+	"page-checksums",
+	"record-checksums",
+	"segments",
+	"ttl",
+	"merge",
+	"time-records",
+	"compressed-pages",
+	"encrypted",
+}
+
+// compatKey is the key of the encrypted fixture.
+var compatKey = []byte("toydb compatibility fixture key!")
+
+// compatOptions are the options the fixtures that need any are opened with.
+var compatOptions = map[string]Options{
+	"encrypted": {EncryptionKey: compatKey},
+}
+
+// compatPairs are the keys of the key-value namespace that the
+// generate_<name>.go file of a fixture writes, with the values they are
+// read back with; a nil value is a key that reads as missing.
+var compatPairs = map[string]map[string][]byte{
+	"dedup": {
+		"dedup-a": bytes.Repeat([]byte("shared "), 100),
+		"dedup-b": bytes.Repeat([]byte("shared "), 100),
+		"dedup-c": nil,
+	},
+	"ttl": {
+		"ttl-live": []byte("live"),
+		"ttl-gone": nil,
+	},
+	"merge": {
+		"counter": binary.BigEndian.AppendUint64(nil, 15),
+	},
+}
+
+// compatRow is row i of the fixtures as generate.go writes it, with price
+// as last written.
+func compatRow(i int, price float64) tuple.Tuple {
+	var data tuple.Value
+	switch {
+	case i%50 == 0:
+		data = bytes.Repeat(fmt.Appendf(nil, "blob_%03d ", i), 400)
+	case i%3 == 0:
+	default:
+		data = fmt.Appendf(nil, "data_%03d", i)
+	}
+	return tuple.Tuple{int64(i), fmt.Sprintf("item_%03d", i), price, i%2 == 0, data}
+}
+
+// compatRows returns the rows of the items table of the fixtures by id.
+func compatRows() map[int64]tuple.Tuple {
+	rows := make(map[int64]tuple.Tuple)
+	for i := range 300 {
+		price := float64(i) / 4
+		if i%7 == 0 {
+			price = float64(i) / 2
+		}
+		if i%10 != 5 {
+			rows[int64(i)] = compatRow(i, price)
+		}
+	}
+	return rows
+}
+
+// openFixture opens a copy of a fixture, leaving the fixture as it is.
+// Without clean, the copy is opened as after a crash.
+func openFixture(t *testing.T, name string, clean bool) (*Database, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(filepath.Join("testdata", "compat", name))); err != nil {
+		t.Fatalf("failed to copy fixture: %v", err)
+	}
+	if !clean {
+		if err := os.Remove(filepath.Join(dir, "clean.lock")); err != nil {
+			t.Fatalf("failed to remove lock file: %v", err)
+		}
+	}
+	db, err := Open(dir, compatOptions[name])
+	if err != nil {
+		t.Fatalf("failed to open fixture: %v", err)
+	}
+	return db, dir
+}
+
+func checkCompatPairs(t *testing.T, db *Database, pairs map[string][]byte) {
+	t.Helper()
+	for key, expected := range pairs {
+		value, found, err := db.KV().Get([]byte(key))
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", key, err)
+		}
+		if found != (expected != nil) || !bytes.Equal(value, expected) {
+			t.Fatalf("expected %s to be %q, got %q, found %v", key, expected, value, found)
+		}
+	}
+}
+
+func checkCompatRows(t *testing.T, db *Database, expected map[int64]tuple.Tuple) {
+	t.Helper()
+	for id := range int64(310) {
+		row, found, err := db.Get("items", id)
+		if err != nil {
+			t.Fatalf("failed to get %d: %v", id, err)
+		}
+		if !reflect.DeepEqual(row, expected[id]) {
+			t.Fatalf("expected row %d to be %v, got %v, found %v", id, expected[id], row, found)
+		}
+	}
+
+	rows, err := db.Query("items", QueryOptions{})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	scanned := collectRows(t, rows)
+	if len(scanned) != len(expected) {
+		t.Fatalf("expected a scan to return %d rows, got %d", len(expected), len(scanned))
+	}
+	for i, row := range scanned {
+		if i > 0 && row[0].(int64) <= scanned[i-1][0].(int64) {
+			t.Fatalf("expected rows in primary key order, got %v after %v", row[0], scanned[i-1][0])
+		}
+		if !reflect.DeepEqual(row, expected[row[0].(int64)]) {
+			t.Fatalf("expected scanned row %v, got %v", expected[row[0].(int64)], row)
+		}
+	}
+}
+
+func TestCompatibility(t *testing.T) {
+	for _, name := range compatFixtures {
+		for _, clean := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/clean=%v", name, clean), func(t *testing.T) {
+				db, dir := openFixture(t, name, clean)
+				expected := compatRows()
+				checkCompatRows(t, db, expected)
+				checkCompatPairs(t, db, compatPairs[name])
+
+				// Writes go on from where the old release stopped, and
+				// newer features work on its tables.
+				expected[300] = compatRow(300, 1)
+				expected[1] = compatRow(1, 100)
+				delete(expected, 2)
+				if err := db.Insert("items", expected[300]); err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+				if err := db.Update("items", expected[1]); err != nil {
+					t.Fatalf("failed to update: %v", err)
+				}
+				if err := db.Delete("items", int64(2)); err != nil {
+					t.Fatalf("failed to delete: %v", err)
+				}
+				if _, err := db.CreateIndex("items", "by_name", []string{"name"}); err != nil {
+					t.Fatalf("failed to create index: %v", err)
+				}
+				rows, err := db.Query("items", QueryOptions{Filter: Where("name", OpEqual, "item_042")})
+				if err != nil {
+					t.Fatalf("failed to query: %v", err)
+				}
+				if found := collectRows(t, rows); len(found) != 1 || !reflect.DeepEqual(found[0], expected[42]) {
+					t.Errorf("expected the index to find %v, got %v", expected[42], found)
+				}
+				checkCompatRows(t, db, expected)
+				if err := db.Close(); err != nil {
+					t.Fatalf("failed to close: %v", err)
+				}
+
+				// The upgraded directory opens as one of this release, with
+				// a single data.db taken over as the first log segment.
+				if _, err := os.Stat(filepath.Join(dir, "data.db")); !os.IsNotExist(err) {
+					t.Errorf("expected data.db to be upgraded to a segment, got %v", err)
+				}
+				db, err = Open(dir, compatOptions[name])
+				if err != nil {
+					t.Fatalf("failed to reopen: %v", err)
+				}
+				defer db.Close()
+				checkCompatRows(t, db, expected)
+				checkCompatPairs(t, db, compatPairs[name])
+			})
+		}
+	}
+}
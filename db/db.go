@@ -2,13 +2,20 @@
 package db
 
 import (
-	"encoding/binary"
 	"errors"
-	"math"
+	"iter"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/expr"
 	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/metrics"
+	"github.com/rizalta/toydb/pager"
 	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/trace"
 	"github.com/rizalta/toydb/tuple"
 )
 
@@ -16,31 +23,193 @@ var (
 	ErrInvalidPrimaryKey   = errors.New("db: invalid primary key")
 	ErrColumnCountMismatch = errors.New("db: number of values mismatch with schema column count")
 	ErrNotNULL             = errors.New("db: value cannot be NULL")
+	ErrColumnNotFound      = errors.New("db: column not found")
+	ErrForeignKeyViolation = errors.New("db: foreign key constraint violation")
+	ErrInvalidValue        = errors.New("db: value does not match column type")
 )
 
 type Store interface {
 	Add(key []byte, value []byte) error
+	BeginBatch() error
+	CommitBatch() error
+	AbortBatch() error
 	Close() error
 	Delete(key []byte) (bool, error)
 	Get(key []byte) ([]byte, bool, error)
+	MultiGet(keys [][]byte) ([][]byte, []bool, error)
 	NewIterator(startKey []byte, endKey []byte) (*storage.Iterator, error)
+	NewKeyIterator(startKey []byte, endKey []byte) (*storage.KeyIterator, error)
+	Has(key []byte) (bool, error)
+	Count(startKey []byte, endKey []byte) (int, error)
+	LastKey(startKey []byte, endKey []byte) ([]byte, error)
+	Forget(startKey []byte, endKey []byte) (int, error)
+	DropDeadSegments() (int, error)
 	Put(key []byte, value []byte) error
+	PutWithTTL(key []byte, value []byte, ttl time.Duration) error
 	Update(key []byte, value []byte) error
+	Write(key []byte, value []byte, mode index.InsertMode, layout storage.Layout) error
+	IndexStats() (*index.Stats, error)
+	Watch(prefix []byte) *storage.Watcher
+	Snapshot() (*storage.Snapshot, error)
+	LogEnd() uint64
+	ReadLog(from uint64, maxBytes int) (uint64, []byte, error)
+	ApplyLog(pos uint64, data []byte) error
+	Promote() error
+	Changes(from uint64) iter.Seq2[storage.LogChange, error]
+	CompactTo(dir string) error
+	Scrub(opts storage.ScrubOptions) (*storage.ScrubReport, error)
 }
 
 type CatalogManager interface {
 	CreateTable(name string, columns []catalog.Column) (*catalog.Schema, error)
+	CreateTableWithOptions(name string, columns []catalog.Column, opts catalog.TableOptions) (*catalog.Schema, error)
+	CreateIndexWithOptions(tableName, indexName string, columnNames []string, opts catalog.IndexOptions) (*catalog.IndexInfo, error)
+	FinishIndex(tableName, indexName string) error
+	DropIndex(tableName, indexName string) (*catalog.IndexInfo, error)
 	GetTable(name string) (*catalog.Schema, error)
+	Tables() ([]string, error)
+	Streams() ([]string, error)
+	CreateStream(name string, columns []catalog.Column) (*catalog.Schema, error)
+	GetStream(name string) (*catalog.Schema, error)
+	SetRetention(name string, retention *catalog.Retention) (*catalog.Schema, error)
+	TruncateStream(name string, firstLSN uint64) (*catalog.Schema, error)
+	PrepareRewrite(name string, columns []catalog.Column) (*catalog.Schema, error)
+	SwapTable(schema *catalog.Schema) error
+	SetStats(name string, stats *catalog.TableStats) error
+	CreateUser(name, password string, superuser bool) error
+	CreateRole(name string) error
+	DropPrincipal(name string) error
+	SetPassword(name, password string) error
+	GetPrincipal(name string) (*catalog.Principal, error)
+	Principals() ([]string, error)
+	Authenticate(name, password string) (*catalog.Principal, error)
+	Grant(name, object string, privileges catalog.Privilege) error
+	Revoke(name, object string, privileges catalog.Privilege) error
+	GrantRole(name, role string) error
+	RevokeRole(name, role string) error
+	Privileges(name, object string) (catalog.Privilege, error)
+	Reload() error
 	Close() error
 }
 
 type Database struct {
-	store   Store
-	catalog CatalogManager
+	store        Store
+	catalog      CatalogManager
+	sketches     map[sketchID]*ColumnSketch
+	validators   []Validator
+	transformers map[string][]columnTransformer
+	checks       map[string][]expr.Expr
+	streamMu     sync.Mutex
+	streams      map[string]*stream
+	keys         *keyGenerator
+	clock        clock.Clock
+	hot          *hotKeySampler
+	filters      *filterTelemetry
+	metrics      *metrics.Registry
+	// serveMu is held by the servers of the database around their calls to
+	// it; see ServeLock.
+	serveMu sync.Mutex
+}
+
+// Memory is the path of a database kept in memory. It starts empty and is
+// gone once closed.
+const Memory = ":memory:"
+
+// SyncMode sets when writes become durable.
+type SyncMode uint8
+
+const (
+	// SyncNormal makes writes durable with the periodic sync of the store,
+	// and always before index pages that point at them, so a crash loses
+	// recent writes but never leaves the index pointing at missing ones.
+	SyncNormal SyncMode = iota
+	// SyncFull makes every write durable before it returns.
+	SyncFull
+	// SyncOff leaves index pages free to reach the disk before the writes
+	// they point at, which recovery then has to find by walking the whole
+	// index.
+	SyncOff
+)
+
+// Options configures a database opened with Open. The zero value is the
+// configuration of NewDatabase.
+type Options struct {
+	// Engine selects how the store indexes keys.
+	Engine storage.Engine
+	// CacheSize is the number of index pages kept in memory. Zero means
+	// pager.MaxCacheSize.
+	CacheSize int
+	// Eviction is how the index cache picks the page to evict.
+	Eviction pager.EvictionPolicy
+	// Mmap reads index pages from a memory mapping of the index file, as
+	// pager.Options.Mmap describes.
+	Mmap bool
+	// CompressPages stores the pages of a new index file compressed, as
+	// pager.Options.Compress describes.
+	CompressPages bool
+	// EncryptionKey, if set, encrypts the data log and the index file of a
+	// new database, as storage.Options.EncryptionKey describes. A database
+	// that is encrypted has to be opened with the same key.
+	EncryptionKey []byte
+	// Sync sets when writes become durable.
+	Sync SyncMode
+	// ReadOnly opens an existing database without changing its files, so
+	// that several processes can read it. Writes fail with
+	// storage.ErrReadOnly, and a database that was not closed cleanly has
+	// to be opened writable once first.
+	ReadOnly bool
+	// Logger receives the errors of background writes. Nil means
+	// slog.Default().
+	Logger trace.Logger
+	// Trace is called as the store reads and writes index pages, splits
+	// and merges index nodes and flushes the index.
+	Trace *trace.Hooks
+	// Clock is the clock of SetClock and also paces the store. Nil means
+	// the real clock.
+	Clock clock.Clock
+	// Metrics is the registry the database counts its work in, which
+	// Stats reads. Nil means a new one.
+	Metrics *metrics.Registry
+	// SegmentSize is the size the segments of the data log grow to, as
+	// storage.Options.SegmentSize describes. Zero means
+	// storage.DefaultSegmentSize.
+	SegmentSize int64
+	// ArchiveDir, if set, receives the sealed segments of the data log,
+	// which RestoreToTime replays.
+	ArchiveDir string
+	// Follower opens the database as the follower of another, which
+	// applies the data log of its leader with ApplyLog and fails other
+	// writes with storage.ErrReadOnly until it is promoted.
+	Follower bool
 }
 
 func NewDatabase(dirPath string) (*Database, error) {
-	store, err := storage.NewStore(dirPath)
+	return Open(dirPath, Options{})
+}
+
+// Open opens the database in dir, creating it unless opts.ReadOnly is
+// set, or a new one in memory if dir is Memory.
+func Open(dir string, opts Options) (*Database, error) {
+	opts.Metrics = metrics.OrNew(opts.Metrics)
+	store, err := storage.NewStoreWithOptions(dir, storage.Options{
+		Engine:        opts.Engine,
+		CacheSize:     opts.CacheSize,
+		Eviction:      opts.Eviction,
+		Mmap:          opts.Mmap,
+		CompressPages: opts.CompressPages,
+		EncryptionKey: opts.EncryptionKey,
+		SyncWrites:    opts.Sync == SyncFull,
+		NoSyncBarrier: opts.Sync == SyncOff,
+		ReadOnly:      opts.ReadOnly,
+		InMemory:      dir == Memory,
+		Logger:        opts.Logger,
+		Trace:         opts.Trace,
+		Metrics:       opts.Metrics,
+		Clock:         opts.Clock,
+		SegmentSize:   opts.SegmentSize,
+		ArchiveDir:    opts.ArchiveDir,
+		Follower:      opts.Follower,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -51,33 +220,31 @@ func NewDatabase(dirPath string) (*Database, error) {
 	}
 
 	db := &Database{
-		store:   store,
-		catalog: catalog,
+		store:        store,
+		catalog:      catalog,
+		sketches:     make(map[sketchID]*ColumnSketch),
+		transformers: make(map[string][]columnTransformer),
+		checks:       make(map[string][]expr.Expr),
+		streams:      make(map[string]*stream),
+		keys:         newKeyGenerator(clock.OrReal(opts.Clock)),
+		clock:        clock.OrReal(opts.Clock),
+		hot:          newHotKeySampler(DefaultReadSampling),
+		filters:      newFilterTelemetry(),
+		metrics:      opts.Metrics,
 	}
 
 	return db, nil
 }
 
-func createKey(tableID uint32, primaryKey tuple.Value) ([]byte, error) {
-	keyPrefix := make([]byte, 4)
-	binary.BigEndian.PutUint32(keyPrefix, tableID)
-
-	var keySuffix []byte
-	switch pk := primaryKey.(type) {
-	case int64:
-		keySuffix = make([]byte, 8)
-		binary.BigEndian.PutUint64(keySuffix, uint64(pk))
-	case float64:
-		keySuffix = make([]byte, 8)
-		binary.BigEndian.PutUint64(keySuffix, math.Float64bits(pk))
-	case string:
-		keySuffix = []byte(pk)
-	default:
-		return nil, ErrInvalidPrimaryKey
-	}
-
-	key := append(keyPrefix, keySuffix...)
-	return key, nil
+// SetClock sets the clock that stamps stream rows and table statistics,
+// ages rows out of streams and times generated keys, so that tests can
+// move time by hand. It is meant to be called right after the database is
+// opened.
+func (db *Database) SetClock(c clock.Clock) {
+	db.keys.mu.Lock()
+	defer db.keys.mu.Unlock()
+	db.keys.clock = c
+	db.clock = c
 }
 
 func isTypeMatch(schemaType catalog.DataType, value tuple.Value) bool {
@@ -91,17 +258,80 @@ func isTypeMatch(schemaType catalog.DataType, value tuple.Value) bool {
 	case catalog.TypeFloat:
 		_, ok := value.(float64)
 		return ok
+	case catalog.TypeTimestamp:
+		_, ok := value.(time.Time)
+		return ok
+	case catalog.TypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case catalog.TypeBlob:
+		_, ok := value.([]byte)
+		return ok
 	default:
 		return false
 	}
 }
 
+func fillDefaults(schema *catalog.Schema, row tuple.Tuple) tuple.Tuple {
+	filled := make(tuple.Tuple, len(row))
+	for i, value := range row {
+		if value == nil {
+			value = schema.Columns[i].DefaultValue
+		}
+		filled[i] = value
+	}
+
+	return filled
+}
+
 func (db *Database) Insert(tableName string, row tuple.Tuple) error {
 	schema, err := db.catalog.GetTable(tableName)
 	if err != nil {
 		return err
 	}
 
+	_, err = db.insert(schema, row)
+	return err
+}
+
+// InsertKey inserts a row like Insert and returns its primary key, which
+// the key generator of the table made up if the row has none.
+func (db *Database) InsertKey(tableName string, row tuple.Tuple) (tuple.Value, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.insert(schema, row)
+}
+
+// InsertNamed inserts a row given only some of its columns. Columns that
+// are left out take their default value, or NULL if they have none, and
+// naming a column twice fails with ErrDuplicateColumns.
+func (db *Database) InsertNamed(tableName string, columns []string, values tuple.Tuple) error {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	if len(columns) != len(values) {
+		return ErrColumnCountMismatch
+	}
+
+	colIdxs, err := importColumns(schema, columns)
+	if err != nil {
+		return err
+	}
+	row := make(tuple.Tuple, len(schema.Columns))
+	for i, colIdx := range colIdxs {
+		row[colIdx] = values[i]
+	}
+
+	_, err = db.insert(schema, row)
+	return err
+}
+
+func validateRow(schema *catalog.Schema, row tuple.Tuple) error {
 	if len(row) != len(schema.Columns) {
 		return ErrColumnCountMismatch
 	}
@@ -115,17 +345,77 @@ func (db *Database) Insert(tableName string, row tuple.Tuple) error {
 		}
 	}
 
-	data, err := tuple.Serialize(row, schema)
+	return nil
+}
+
+func (db *Database) insert(schema *catalog.Schema, row tuple.Tuple) (tuple.Value, error) {
+	if len(row) != len(schema.Columns) {
+		return nil, ErrColumnCountMismatch
+	}
+
+	row = fillDefaults(schema, row)
+	if generator := schema.Columns[schema.PrimaryKeyIndex].Generator; row[schema.PrimaryKeyIndex] == nil && generator != catalog.GeneratorNone {
+		primaryKey, err := db.keys.generate(generator)
+		if err != nil {
+			return nil, err
+		}
+		row[schema.PrimaryKeyIndex] = primaryKey
+	}
+	if err := db.checkRow(schema, row); err != nil {
+		return nil, err
+	}
+
+	data, err := db.encodeRow(schema, row)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	key, err := createKey(schema.ID, row[schema.PrimaryKeyIndex])
+	key, err := EncodeKey(schema.ID, row[schema.PrimaryKeyIndex])
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := db.checkReferences(schema, row, key); err != nil {
+		return nil, err
+	}
+	if err := db.loadSketches(schema); err != nil {
+		return nil, err
 	}
 
-	return db.store.Add(key, data)
+	err = db.atomically(hasDerivedEntries(schema), func() error {
+		if err := db.store.Write(key, data, index.InsertOnly, storeLayout(schema)); err != nil {
+			return err
+		}
+		return db.updateDerived(schema, nil, row, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return row[schema.PrimaryKeyIndex], nil
+}
+
+// atomically runs fn, which writes rows and the entries derived from them,
+// in a batch of the store if batch is set, so that after a crash or a
+// failed write the rows and their entries are all there or none are. A
+// batch that is open already, as in a WriteGroup, covers fn instead.
+func (db *Database) atomically(batch bool, fn func() error) error {
+	if !batch {
+		return fn()
+	}
+
+	err := db.store.BeginBatch()
+	if errors.Is(err, storage.ErrBatchOpen) {
+		return fn()
+	} else if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if abortErr := db.store.AbortBatch(); abortErr != nil {
+			return abortErr
+		}
+		return err
+	}
+	return db.store.CommitBatch()
 }
 
 func (db *Database) Get(tableName string, primaryKey tuple.Value) (tuple.Tuple, bool, error) {
@@ -134,16 +424,21 @@ func (db *Database) Get(tableName string, primaryKey tuple.Value) (tuple.Tuple,
 		return nil, false, err
 	}
 
+	return db.get(schema, primaryKey)
+}
+
+func (db *Database) get(schema *catalog.Schema, primaryKey tuple.Value) (tuple.Tuple, bool, error) {
 	primaryKeyType := schema.Columns[schema.PrimaryKeyIndex].Type
 	if !isTypeMatch(primaryKeyType, primaryKey) {
 		return nil, false, ErrInvalidPrimaryKey
 	}
 
-	key, err := createKey(schema.ID, primaryKey)
+	key, err := EncodeKey(schema.ID, primaryKey)
 	if err != nil {
 		return nil, false, err
 	}
 
+	db.hot.sample(schema, key, primaryKey)
 	valueBytes, found, err := db.store.Get(key)
 	if err != nil {
 		return nil, false, err
@@ -152,7 +447,7 @@ func (db *Database) Get(tableName string, primaryKey tuple.Value) (tuple.Tuple,
 		return nil, found, nil
 	}
 
-	row, err := tuple.Deserialize(valueBytes, schema)
+	row, err := db.decodeRow(schema, valueBytes)
 	if err != nil {
 		return nil, false, err
 	}
@@ -160,36 +455,171 @@ func (db *Database) Get(tableName string, primaryKey tuple.Value) (tuple.Tuple,
 	return row, true, nil
 }
 
+// GetMany looks up several rows by primary key. The rows are returned in
+// the order of primaryKeys, with nil for keys that have no row. It reads
+// fewer pages than calling Get for each key, most of all when the keys lie
+// close together.
+func (db *Database) GetMany(tableName string, primaryKeys []tuple.Value) ([]tuple.Tuple, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	primaryKeyType := schema.Columns[schema.PrimaryKeyIndex].Type
+	keys := make([][]byte, len(primaryKeys))
+	for i, primaryKey := range primaryKeys {
+		if !isTypeMatch(primaryKeyType, primaryKey) {
+			return nil, ErrInvalidPrimaryKey
+		}
+		if keys[i], err = EncodeKey(schema.ID, primaryKey); err != nil {
+			return nil, err
+		}
+		db.hot.sample(schema, keys[i], primaryKey)
+	}
+
+	values, found, err := db.store.MultiGet(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]tuple.Tuple, len(keys))
+	for i, value := range values {
+		if !found[i] {
+			continue
+		}
+		if rows[i], err = db.decodeRow(schema, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
+
 func (db *Database) Update(tableName string, row tuple.Tuple) error {
 	schema, err := db.catalog.GetTable(tableName)
 	if err != nil {
 		return err
 	}
 
+	return db.update(schema, row)
+}
+
+// Upsert inserts a row, or replaces the row with the same primary key if
+// there is one, and reports whether it inserted. Default values are only
+// filled in on insert, as with Insert and Update. A row without a primary
+// key is inserted under one from the key generator of the table.
+func (db *Database) Upsert(tableName string, row tuple.Tuple) (bool, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return false, err
+	}
 	if len(row) != len(schema.Columns) {
-		return ErrColumnCountMismatch
+		return false, ErrColumnCountMismatch
 	}
 
-	for i, column := range schema.Columns {
-		if column.IsNotNull && row[i] == nil {
-			if column.IsPrimaryKey {
-				return ErrInvalidPrimaryKey
-			}
-			return ErrNotNULL
+	primaryKey := fillDefaults(schema, row)[schema.PrimaryKeyIndex]
+	if primaryKey == nil {
+		// A row without a key is new, and insert has the key generator of
+		// the table make one up, or fails if it has none.
+		_, err := db.insert(schema, row)
+		return err == nil, err
+	}
+	key, err := EncodeKey(schema.ID, primaryKey)
+	if err != nil {
+		return false, err
+	}
+	_, found, err := db.store.Get(key)
+	if err != nil {
+		return false, err
+	}
+
+	if found {
+		row = slices.Clone(row)
+		row[schema.PrimaryKeyIndex] = primaryKey
+		return false, db.update(schema, row)
+	}
+	_, err = db.insert(schema, row)
+	return true, err
+}
+
+// UpdateColumns changes some columns of the row with the given primary key
+// and leaves the others as they are. The primary key itself cannot be
+// changed. Values must have the column type or be nil for NULL.
+func (db *Database) UpdateColumns(tableName string, primaryKey tuple.Value, changes map[string]tuple.Value) error {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	row, found, err := db.get(schema, primaryKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return index.ErrKeyNotFound
+	}
+
+	for name, value := range changes {
+		colIdx := columnIndex(schema, name)
+		if colIdx == -1 {
+			return ErrColumnNotFound
+		}
+		if colIdx == schema.PrimaryKeyIndex {
+			return ErrInvalidPrimaryKey
 		}
+		if value != nil && !isTypeMatch(schema.Columns[colIdx].Type, value) {
+			return ErrInvalidValue
+		}
+		row[colIdx] = value
 	}
 
-	valueBytes, err := tuple.Serialize(row, schema)
+	return db.update(schema, row)
+}
+
+func (db *Database) update(schema *catalog.Schema, row tuple.Tuple) error {
+	if err := db.checkRow(schema, row); err != nil {
+		return err
+	}
+
+	valueBytes, err := db.encodeRow(schema, row)
 	if err != nil {
 		return err
 	}
 
-	key, err := createKey(schema.ID, row[schema.PrimaryKeyIndex])
+	key, err := EncodeKey(schema.ID, row[schema.PrimaryKeyIndex])
+	if err != nil {
+		return err
+	}
+
+	if !needsOldRow(schema) {
+		return db.store.Write(key, valueBytes, index.UpdateOnly, storeLayout(schema))
+	}
+
+	if err := db.checkReferences(schema, row, key); err != nil {
+		return err
+	}
+
+	oldBytes, found, err := db.store.Get(key)
 	if err != nil {
 		return err
 	}
+	if !found {
+		return index.ErrKeyNotFound
+	}
+	oldRow, err := db.decodeRow(schema, oldBytes)
+	if err != nil {
+		return err
+	}
+	if err := db.loadSketches(schema); err != nil {
+		return err
+	}
 
-	return db.store.Update(key, valueBytes)
+	return db.atomically(hasDerivedEntries(schema), func() error {
+		if err := db.store.Write(key, valueBytes, index.UpdateOnly, storeLayout(schema)); err != nil {
+			return err
+		}
+		return db.updateDerived(schema, oldRow, row, key)
+	})
 }
 
 func (db *Database) Delete(tableName string, primaryKey tuple.Value) error {
@@ -203,24 +633,83 @@ func (db *Database) Delete(tableName string, primaryKey tuple.Value) error {
 		return ErrInvalidPrimaryKey
 	}
 
-	key, err := createKey(schema.ID, primaryKey)
+	key, err := EncodeKey(schema.ID, primaryKey)
 	if err != nil {
 		return err
 	}
 
-	if _, err := db.store.Delete(key); !errors.Is(err, index.ErrKeyNotFound) {
+	if len(schema.ReferencedBy) == 0 && !needsOldRow(schema) {
+		if _, err := db.store.Delete(key); !errors.Is(err, index.ErrKeyNotFound) {
+			return err
+		}
+		return nil
+	}
+
+	var pending []pendingDelete
+	if err := db.collectDeletes(schema, key, make(map[string]struct{}), &pending); err != nil {
 		return err
 	}
-	return nil
+
+	for _, p := range pending {
+		if err := db.loadSketches(p.schema); err != nil {
+			return err
+		}
+	}
+
+	// The cascade is deleted as a whole, with the derived entries of every
+	// row in it.
+	return db.atomically(len(pending) > 1 || hasDerivedEntries(schema), func() error {
+		for _, p := range pending {
+			if _, err := db.store.Delete(p.key); err != nil && !errors.Is(err, index.ErrKeyNotFound) {
+				return err
+			}
+			if err := db.updateDerived(p.schema, p.row, nil, p.key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Tables returns the names of the tables in sorted order.
+func (db *Database) Tables() ([]string, error) {
+	return db.catalog.Tables()
+}
+
+// Schema returns the schema of a table, or an error matching
+// catalog.ErrTableNotFound if there is none.
+func (db *Database) Schema(tableName string) (*catalog.Schema, error) {
+	return db.catalog.GetTable(tableName)
 }
 
 func (db *Database) CreateTable(tableName string, columns []catalog.Column) (*catalog.Schema, error) {
 	return db.catalog.CreateTable(tableName, columns)
 }
 
+// CreateTableWithOptions creates a table like CreateTable, letting the
+// caller choose how its rows are stored. The layout only affects
+// performance; reads and writes go through the same methods either way.
+func (db *Database) CreateTableWithOptions(tableName string, columns []catalog.Column, opts catalog.TableOptions) (*catalog.Schema, error) {
+	return db.catalog.CreateTableWithOptions(tableName, columns, opts)
+}
+
+func storeLayout(schema *catalog.Schema) storage.Layout {
+	if schema.Layout == catalog.LayoutIndexOrganized {
+		return storage.LayoutInline
+	}
+	return storage.LayoutHeap
+}
+
 func (db *Database) Close() error {
-	if err := db.store.Close(); err != nil {
+	if err := db.saveSketches(); err != nil {
 		return err
 	}
+	// The catalog keeps its tables in the store and closes it.
 	return db.catalog.Close()
 }
+
+// ServeLock returns the lock that servers sharing the database hold around
+// their calls to it, as a Database takes one call at a time.
+func (db *Database) ServeLock() *sync.Mutex {
+	return &db.serveMu
+}
@@ -2,11 +2,17 @@ package db
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/clock"
 	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/storage"
 	"github.com/rizalta/toydb/tuple"
 )
 
@@ -104,6 +110,88 @@ func TestDBPutGet(t *testing.T) {
 	})
 }
 
+func TestMemoryDatabase(t *testing.T) {
+	t.Chdir(t.TempDir())
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+
+	db, err := NewDatabase(Memory)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := db.catalog.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	row := tuple.Tuple{int64(1), "alice"}
+	if err := db.Insert("users", row); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if got, found, err := db.Get("users", int64(1)); err != nil || !found || !reflect.DeepEqual(got, row) {
+		t.Errorf("expected %v, got %v, found %v, err %v", row, got, found, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+	if _, err := os.Stat(Memory); !os.IsNotExist(err) {
+		t.Errorf("expected no directory for a database in memory, got %v", err)
+	}
+
+	db, err = NewDatabase(Memory)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.catalog.GetTable("users"); err == nil {
+		t.Errorf("expected a new database in memory to be empty")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	clk := clock.NewVirtual(time.Unix(1_700_000_000, 0))
+
+	db, err := Open(dir, Options{CacheSize: 8, Sync: SyncFull, Clock: clk})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if db.clock != clk {
+		t.Errorf("expected the database to use the clock of its options")
+	}
+	if _, err := db.catalog.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 100 {
+		if err := db.Insert("users", tuple.Tuple{int64(i), fmt.Sprintf("user%d", i)}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	db, err = Open(dir, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open database read-only: %v", err)
+	}
+	defer db.Close()
+	if row, found, err := db.Get("users", int64(42)); err != nil || !found || row[1] != "user42" {
+		t.Errorf("expected user42, got %v, found %v, err %v", row, found, err)
+	}
+	if err := db.Insert("users", tuple.Tuple{int64(100), "user100"}); !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("expected %v, got %v", storage.ErrReadOnly, err)
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "missing"), Options{ReadOnly: true}); err == nil {
+		t.Errorf("expected opening a missing database read-only to fail")
+	}
+}
+
 func TestUpdate(t *testing.T) {
 	db := newTestDB(t)
 	defer db.Close()
@@ -150,6 +238,191 @@ func TestUpdate(t *testing.T) {
 	})
 }
 
+func TestUpsert(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "role", Type: catalog.TypeVarChar, DefaultValue: "member"},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("users", "by_name", []string{"name"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		row      tuple.Tuple
+		inserted bool
+		err      error
+		expected tuple.Tuple
+	}{
+		{"insert fills defaults", tuple.Tuple{int64(1), "alice", nil}, true, nil, tuple.Tuple{int64(1), "alice", "member"}},
+		{"update replaces row", tuple.Tuple{int64(1), "alicia", nil}, false, nil, tuple.Tuple{int64(1), "alicia", nil}},
+		{"second insert", tuple.Tuple{int64(2), "bob", "admin"}, true, nil, tuple.Tuple{int64(2), "bob", "admin"}},
+		{"constraint checked on update", tuple.Tuple{int64(2), nil, nil}, false, ErrNotNULL, tuple.Tuple{int64(2), "bob", "admin"}},
+		{"missing primary key", tuple.Tuple{nil, "carol", nil}, false, ErrInvalidPrimaryKey, nil},
+		{"wrong column count", tuple.Tuple{int64(3)}, false, ErrColumnCountMismatch, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserted, err := db.Upsert("users", tt.row)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if inserted != tt.inserted {
+				t.Errorf("expected inserted=%v, got %v", tt.inserted, inserted)
+			}
+			if tt.expected == nil {
+				return
+			}
+			row, found, err := db.Get("users", tt.expected[0])
+			if err != nil || !found {
+				t.Fatalf("expected row, got found=%v err=%v", found, err)
+			}
+			if !reflect.DeepEqual(row, tt.expected) {
+				t.Errorf("expected row %v, got %v", tt.expected, row)
+			}
+		})
+	}
+
+	rows, err := db.Query("users", QueryOptions{Filter: Where("name", OpEqual, "alice")})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if got := collectRows(t, rows); len(got) != 0 {
+		t.Errorf("expected the index to drop the old name, got %v", got)
+	}
+}
+
+func TestUpsertGeneratedKey(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	_, err := db.CreateTable("events", []catalog.Column{
+		{Name: "id", Type: catalog.TypeVarChar, IsPrimaryKey: true, IsNotNull: true, Generator: catalog.GeneratorULID},
+		{Name: "name", Type: catalog.TypeVarChar},
+	})
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	for _, name := range []string{"first", "second"} {
+		inserted, err := db.Upsert("events", tuple.Tuple{nil, name})
+		if err != nil {
+			t.Fatalf("failed to upsert: %v", err)
+		}
+		if !inserted {
+			t.Errorf("expected a row without a key to be inserted")
+		}
+	}
+
+	rows, err := db.Query("events", QueryOptions{})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if got := collectRows(t, rows); len(got) != 2 {
+		t.Errorf("expected 2 rows with generated keys, got %v", got)
+	}
+}
+
+func TestUpdateColumns(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "email", Type: catalog.TypeVarChar},
+		{Name: "age", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if err := db.Insert("users", tuple.Tuple{int64(1), "alice", "a@example.com", int64(30)}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		pk       tuple.Value
+		changes  map[string]tuple.Value
+		err      error
+		expected tuple.Tuple
+	}{
+		{"single column", int64(1), map[string]tuple.Value{"age": int64(31)}, nil, tuple.Tuple{int64(1), "alice", "a@example.com", int64(31)}},
+		{"set null", int64(1), map[string]tuple.Value{"email": nil, "name": "alicia"}, nil, tuple.Tuple{int64(1), "alicia", nil, int64(31)}},
+		{"no changes", int64(1), nil, nil, tuple.Tuple{int64(1), "alicia", nil, int64(31)}},
+		{"not null", int64(1), map[string]tuple.Value{"name": nil}, ErrNotNULL, tuple.Tuple{int64(1), "alicia", nil, int64(31)}},
+		{"wrong type", int64(1), map[string]tuple.Value{"age": "old"}, ErrInvalidValue, tuple.Tuple{int64(1), "alicia", nil, int64(31)}},
+		{"unknown column", int64(1), map[string]tuple.Value{"phone": "555"}, ErrColumnNotFound, tuple.Tuple{int64(1), "alicia", nil, int64(31)}},
+		{"primary key", int64(1), map[string]tuple.Value{"id": int64(2)}, ErrInvalidPrimaryKey, tuple.Tuple{int64(1), "alicia", nil, int64(31)}},
+		{"missing row", int64(2), map[string]tuple.Value{"age": int64(1)}, index.ErrKeyNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.UpdateColumns("users", tt.pk, tt.changes); !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			row, _, err := db.Get("users", tt.pk)
+			if err != nil {
+				t.Fatalf("failed to get row: %v", err)
+			}
+			if !reflect.DeepEqual(row, tt.expected) {
+				t.Errorf("expected row %v, got %v", tt.expected, row)
+			}
+		})
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 500 {
+		if err := db.Insert("users", tuple.Tuple{int64(i), fmt.Sprintf("user_%d", i)}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := db.Delete("users", int64(7)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	pks := []tuple.Value{int64(499), int64(7), int64(0), int64(1000), int64(250), int64(0)}
+	rows, err := db.GetMany("users", pks)
+	if err != nil {
+		t.Fatalf("failed to get rows: %v", err)
+	}
+	expected := []tuple.Tuple{
+		{int64(499), "user_499"},
+		nil,
+		{int64(0), "user_0"},
+		nil,
+		{int64(250), "user_250"},
+		{int64(0), "user_0"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected rows %v, got %v", expected, rows)
+	}
+
+	if _, err := db.GetMany("users", []tuple.Value{int64(1), "two"}); !errors.Is(err, ErrInvalidPrimaryKey) {
+		t.Errorf("expected %v, got %v", ErrInvalidPrimaryKey, err)
+	}
+}
+
 func TestPutGetStringPrimaryKey(t *testing.T) {
 	db := newTestDB(t)
 	defer db.Close()
@@ -287,3 +560,201 @@ func TestDBDelete(t *testing.T) {
 		}
 	})
 }
+
+func TestInsertDefaults(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	tableName := "users"
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "role", Type: catalog.TypeVarChar, IsNotNull: true, DefaultValue: "member"},
+		{Name: "is_active", Type: catalog.TypeBoolean, DefaultValue: true},
+		{Name: "note", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable(tableName, columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		insert   func() error
+		pk       int64
+		expected tuple.Tuple
+	}{
+		{
+			name:     "nil values take defaults",
+			insert:   func() error { return db.Insert(tableName, tuple.Tuple{int64(1), nil, nil, nil}) },
+			pk:       1,
+			expected: tuple.Tuple{int64(1), "member", true, nil},
+		},
+		{
+			name:     "explicit values override defaults",
+			insert:   func() error { return db.Insert(tableName, tuple.Tuple{int64(2), "admin", false, "x"}) },
+			pk:       2,
+			expected: tuple.Tuple{int64(2), "admin", false, "x"},
+		},
+		{
+			name: "named insert fills omitted columns",
+			insert: func() error {
+				return db.InsertNamed(tableName, []string{"note", "id"}, tuple.Tuple{"hello", int64(3)})
+			},
+			pk:       3,
+			expected: tuple.Tuple{int64(3), "member", true, "hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.insert(); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+
+			row, found, err := db.Get(tableName, tt.pk)
+			if err != nil || !found {
+				t.Fatalf("expected no error and found true, got %v, %v", err, found)
+			}
+			if !reflect.DeepEqual(row, tt.expected) {
+				t.Errorf("expected row %v, got %v", tt.expected, row)
+			}
+		})
+	}
+
+	t.Run("named insert with unknown column", func(t *testing.T) {
+		err := db.InsertNamed(tableName, []string{"id", "missing"}, tuple.Tuple{int64(4), "x"})
+		if !errors.Is(err, ErrColumnNotFound) {
+			t.Errorf("expected error %v, got %v", ErrColumnNotFound, err)
+		}
+	})
+
+	t.Run("named insert with duplicate column", func(t *testing.T) {
+		err := db.InsertNamed(tableName, []string{"id", "id"}, tuple.Tuple{int64(5), int64(6)})
+		if !errors.Is(err, ErrDuplicateColumns) {
+			t.Errorf("expected error %v, got %v", ErrDuplicateColumns, err)
+		}
+	})
+}
+
+func TestIndexOrganizedTable(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "body", Type: catalog.TypeBlob},
+	}
+	opts := catalog.TableOptions{Layout: catalog.LayoutIndexOrganized}
+	if _, err := db.CreateTableWithOptions("notes", columns, opts); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	var rows []tuple.Tuple
+	for i := range 200 {
+		body := make([]byte, 10+i%50)
+		if i == 7 {
+			body = make([]byte, index.MaxInlineSize*2)
+		}
+		row := tuple.Tuple{int64(i), body}
+		if err := db.Insert("notes", row); err != nil {
+			t.Fatalf("failed to insert row %d: %v", i, err)
+		}
+		rows = append(rows, row)
+	}
+
+	rows[3] = tuple.Tuple{int64(3), []byte("updated")}
+	if err := db.Update("notes", rows[3]); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if err := db.Delete("notes", int64(4)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	rows = append(rows[:4], rows[5:]...)
+
+	row, found, err := db.Get("notes", int64(3))
+	if err != nil || !found {
+		t.Fatalf("failed to get row: found %v, err %v", found, err)
+	}
+	if !reflect.DeepEqual(row, rows[3]) {
+		t.Errorf("expected row %v, got %v", rows[3], row)
+	}
+
+	if got := scanAll(t, db, "notes"); !reflect.DeepEqual(got, rows) {
+		t.Errorf("expected scan to return every row in key order")
+	}
+
+	schema, err := db.RewriteTable("notes", append(columns, catalog.Column{Name: "pinned", Type: catalog.TypeBoolean}), func(row tuple.Tuple) (tuple.Tuple, error) {
+		return append(row, nil), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to rewrite table: %v", err)
+	}
+	if schema.Layout != catalog.LayoutIndexOrganized {
+		t.Errorf("expected rewrite to keep the table layout")
+	}
+	if got := scanAll(t, db, "notes"); len(got) != len(rows) {
+		t.Errorf("expected %d rows after rewrite, got %d", len(rows), len(got))
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	db, err := NewDatabase(b.TempDir())
+	if err != nil {
+		b.Fatalf("failed to initialize db: %v", err)
+	}
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "score", Type: catalog.TypeFloat},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		b.Fatalf("failed to create table: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := range b.N {
+		row := tuple.Tuple{int64(i), "some user name", float64(i)}
+		if err := db.Insert("users", row); err != nil {
+			b.Fatalf("failed to insert: %v", err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	db, err := NewDatabase(b.TempDir())
+	if err != nil {
+		b.Fatalf("failed to initialize db: %v", err)
+	}
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		b.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 10000 {
+		if err := db.Insert("users", tuple.Tuple{int64(i), "some user name"}); err != nil {
+			b.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		scanner, err := db.Scan("users", nil, nil, nil)
+		if err != nil {
+			b.Fatalf("failed to scan: %v", err)
+		}
+		for {
+			row, err := scanner.Next()
+			if err != nil {
+				b.Fatalf("error while scanning: %v", err)
+			}
+			if row == nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// Dump writes the schema and rows of every table and stream as text that
+// depends only on their contents, for diffing against golden files in
+// tests. Tables come in name order, then streams; each starts with its
+// definition and indexes, by name, followed by its rows in primary key or
+// LSN order. Table and index IDs, statistics and append times are left
+// out, as they change with the history of a database and not its state.
+//
+// Values are written as SQL literals. Floats use the shortest text that
+// reads back the same, with a single 0 for zero and NaN, +Inf and -Inf for
+// the special values, and timestamps are in UTC.
+func (db *Database) Dump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	tables, err := db.catalog.Tables()
+	if err != nil {
+		return err
+	}
+	for i, name := range tables {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		if err := db.dumpTable(bw, name); err != nil {
+			return err
+		}
+	}
+
+	streams, err := db.catalog.Streams()
+	if err != nil {
+		return err
+	}
+	for i, name := range streams {
+		if i > 0 || len(tables) > 0 {
+			bw.WriteString("\n")
+		}
+		if err := db.dumpStream(bw, name); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (db *Database) dumpTable(w *bufio.Writer, name string) error {
+	schema, err := db.catalog.GetTable(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "TABLE %s (%s)\n", name, dumpColumns(schema.Columns))
+	indexes := slices.Clone(schema.Indexes)
+	slices.SortFunc(indexes, func(a, b *catalog.IndexInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, info := range indexes {
+		if info.ID == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "INDEX %s %s (%s)", info.Name, info.Type, strings.Join(info.Columns, ", "))
+		if info.Building {
+			w.WriteString(" BUILDING")
+		}
+		w.WriteString("\n")
+	}
+
+	scanner, err := db.Scan(name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := scanner.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return nil
+		}
+		fmt.Fprintf(w, "(%s)\n", dumpRow(row))
+	}
+}
+
+func (db *Database) dumpStream(w *bufio.Writer, name string) error {
+	schema, err := db.catalog.GetStream(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "STREAM %s (%s)\n", name, dumpColumns(schema.Columns))
+
+	iterator, err := db.store.NewIterator(streamKey(schema.ID, 0), streamKey(schema.ID+1, 0))
+	if err != nil {
+		return err
+	}
+	for {
+		key, data, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+		row, err := tuple.Deserialize(data[8:], schema)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d (%s)\n", binary.BigEndian.Uint64(key[4:]), dumpRow(row))
+	}
+}
+
+func dumpColumns(columns []catalog.Column) string {
+	definitions := make([]string, len(columns))
+	for i, c := range columns {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s", c.Name, c.Type)
+		if c.IsPrimaryKey {
+			b.WriteString(" PRIMARY KEY")
+		}
+		if c.IsNotNull {
+			b.WriteString(" NOT NULL")
+		}
+		if c.DefaultValue != nil {
+			fmt.Fprintf(&b, " DEFAULT %s", dumpValue(c.DefaultValue))
+		}
+		if c.Generator != catalog.GeneratorNone {
+			fmt.Fprintf(&b, " GENERATED %s", c.Generator)
+		}
+		if ref := c.References; ref != nil {
+			fmt.Fprintf(&b, " REFERENCES %s(%s)", ref.Table, ref.Column)
+			if ref.OnDelete == catalog.OnDeleteCascade {
+				b.WriteString(" ON DELETE CASCADE")
+			}
+		}
+		definitions[i] = b.String()
+	}
+	return strings.Join(definitions, ", ")
+}
+
+func dumpRow(row tuple.Tuple) string {
+	values := make([]string, len(row))
+	for i, v := range row {
+		values[i] = dumpValue(v)
+	}
+	return strings.Join(values, ", ")
+}
+
+func dumpValue(v tuple.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "+Inf"
+		case math.IsInf(v, -1):
+			return "-Inf"
+		case v == 0:
+			return "0"
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case []byte:
+		return "x'" + hex.EncodeToString(v) + "'"
+	case time.Time:
+		return strconv.Quote(tuple.FormatTimestamp(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
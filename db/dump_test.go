@@ -0,0 +1,125 @@
+package db
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestDump(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	columns := map[string][]catalog.Column{
+		"items": {
+			{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+			{Name: "price", Type: catalog.TypeFloat},
+			{Name: "data", Type: catalog.TypeBlob},
+			{Name: "seen", Type: catalog.TypeTimestamp},
+		},
+		"tags": {
+			{Name: "name", Type: catalog.TypeVarChar, IsPrimaryKey: true, IsNotNull: true},
+			{Name: "item", Type: catalog.TypeInt, References: &catalog.ForeignKey{Table: "items", Column: "id"}},
+			{Name: "active", Type: catalog.TypeBoolean, DefaultValue: true},
+		},
+	}
+
+	// Both databases end up with the same contents, reached in a different
+	// order and with a different history.
+	first := newTestDB(t)
+	defer first.Close()
+	for _, name := range []string{"items", "tags"} {
+		if _, err := first.CreateTable(name, columns[name]); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	if _, err := first.CreateIndex("tags", "by_item", []string{"item"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if err := first.CreateStream("log", []catalog.Column{{Name: "message", Type: catalog.TypeVarChar}}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	for _, row := range []tuple.Tuple{
+		{int64(1), 1.5, []byte{0xca, 0xfe}, at},
+		{int64(2), math.Copysign(0, -1), nil, nil},
+		{int64(3), math.NaN(), nil, nil},
+	} {
+		if err := first.Insert("items", row); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := first.Insert("tags", tuple.Tuple{"b", int64(1), nil}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := first.Insert("tags", tuple.Tuple{"a\"quoted\"", nil, false}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := first.Append("log", tuple.Tuple{"hello"}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	second := newTestDB(t)
+	defer second.Close()
+	if err := second.CreateStream("log", []catalog.Column{{Name: "message", Type: catalog.TypeVarChar}}); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	for _, name := range []string{"items", "tags"} {
+		if _, err := second.CreateTable(name, columns[name]); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	for _, row := range []tuple.Tuple{
+		{int64(3), math.NaN(), nil, nil},
+		{int64(2), 0.0, nil, nil},
+		{int64(1), 9.0, nil, nil},
+		{int64(4), 1.0, nil, nil},
+	} {
+		if err := second.Insert("items", row); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := second.Update("items", tuple.Tuple{int64(1), 1.5, []byte{0xca, 0xfe}, at.UTC()}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if err := second.Delete("items", int64(4)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := second.Insert("tags", tuple.Tuple{"a\"quoted\"", nil, false}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := second.Insert("tags", tuple.Tuple{"b", int64(1), nil}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := second.CreateIndex("tags", "by_item", []string{"item"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if _, err := second.Append("log", tuple.Tuple{"hello"}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	expected := `TABLE items (id INT PRIMARY KEY NOT NULL, price FLOAT, data BLOB, seen TIMESTAMP)
+(1, 1.5, x'cafe', "2024-05-01T10:00:00Z")
+(2, 0, NULL, NULL)
+(3, NaN, NULL, NULL)
+
+TABLE tags (name VARCHAR PRIMARY KEY NOT NULL, item INT REFERENCES items(id), active BOOLEAN DEFAULT TRUE)
+INDEX by_item BTREE (item)
+("a\"quoted\"", NULL, FALSE)
+("b", 1, TRUE)
+
+STREAM log (message VARCHAR)
+1 ("hello")
+`
+
+	for name, db := range map[string]*Database{"first": first, "second": second} {
+		var buf bytes.Buffer
+		if err := db.Dump(&buf); err != nil {
+			t.Fatalf("failed to dump %s database: %v", name, err)
+		}
+		if buf.String() != expected {
+			t.Errorf("unexpected dump of %s database:\n%s\nexpected:\n%s", name, buf.String(), expected)
+		}
+	}
+}
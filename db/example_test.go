@@ -0,0 +1,111 @@
+package db_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func ExampleDatabase() {
+	dir, err := os.MkdirTemp("", "toydb-db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	database, err := db.NewDatabase(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer database.Close()
+
+	_, err = database.CreateTable("users", []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "role", Type: catalog.TypeVarChar, DefaultValue: "member"},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := database.Insert("users", tuple.Tuple{int64(1), "alice", "admin"}); err != nil {
+		log.Fatal(err)
+	}
+	if err := database.InsertNamed("users", []string{"id", "name"}, tuple.Tuple{int64(2), "bob"}); err != nil {
+		log.Fatal(err)
+	}
+	if err := database.Update("users", tuple.Tuple{int64(1), "alice", "owner"}); err != nil {
+		log.Fatal(err)
+	}
+
+	row, found, err := database.Get("users", int64(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(row, found)
+
+	if err := database.Delete("users", int64(1)); err != nil {
+		log.Fatal(err)
+	}
+	_, found, _ = database.Get("users", int64(1))
+	fmt.Println(found)
+
+	row, _, _ = database.Get("users", int64(2))
+	fmt.Println(row)
+
+	// Output:
+	// [1 alice owner] true
+	// false
+	// [2 bob member]
+}
+
+func ExampleDatabase_Scan() {
+	dir, err := os.MkdirTemp("", "toydb-db")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	database, err := db.NewDatabase(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer database.Close()
+
+	_, err = database.CreateTable("tasks", []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "title", Type: catalog.TypeVarChar, IsNotNull: true},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i, title := range []string{"write", "test", "review", "ship"} {
+		if err := database.Insert("tasks", tuple.Tuple{int64(i), title}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	scanner, err := database.Scan("tasks", int64(1), int64(3), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		row, err := scanner.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if row == nil {
+			break
+		}
+		fmt.Println(row)
+	}
+
+	// Output:
+	// [1 test]
+	// [2 review]
+}
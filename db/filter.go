@@ -0,0 +1,187 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidFilter = errors.New("db: invalid filter")
+
+type Operator int
+
+const (
+	OpEqual Operator = iota
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+	OpIsNull
+	OpIsNotNull
+)
+
+var operatorSymbols = map[Operator]string{
+	OpEqual:        "=",
+	OpNotEqual:     "!=",
+	OpLess:         "<",
+	OpLessEqual:    "<=",
+	OpGreater:      ">",
+	OpGreaterEqual: ">=",
+	OpIsNull:       "IS NULL",
+	OpIsNotNull:    "IS NOT NULL",
+}
+
+func (op Operator) String() string {
+	if symbol, ok := operatorSymbols[op]; ok {
+		return symbol
+	}
+	return fmt.Sprintf("Operator(%d)", int(op))
+}
+
+// Filter is a condition on the rows of a table. Filters are built with
+// Where, And and Or, and are checked against the table schema when a scan
+// starts.
+type Filter interface {
+	bind(schema *catalog.Schema) (predicate, error)
+}
+
+type predicate func(row tuple.Tuple) bool
+
+type comparison struct {
+	column string
+	op     Operator
+	value  tuple.Value
+}
+
+// Where matches rows whose column compares to value with op. As in SQL, a
+// NULL column never matches a comparison; use OpIsNull and OpIsNotNull,
+// which ignore value, to test for it.
+func Where(column string, op Operator, value tuple.Value) Filter {
+	return comparison{column: column, op: op, value: value}
+}
+
+func (c comparison) String() string {
+	if c.op == OpIsNull || c.op == OpIsNotNull {
+		return c.column + " " + c.op.String()
+	}
+	return fmt.Sprintf("%s %s %s", c.column, c.op, formatValue(c.value))
+}
+
+func formatValue(v tuple.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return fmt.Sprintf("x'%x'", v)
+	case time.Time:
+		return tuple.FormatTimestamp(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (c comparison) bind(schema *catalog.Schema) (predicate, error) {
+	colIdx := columnIndex(schema, c.column)
+	if colIdx == -1 {
+		return nil, ErrColumnNotFound
+	}
+
+	switch c.op {
+	case OpIsNull:
+		return func(row tuple.Tuple) bool { return row[colIdx] == nil }, nil
+	case OpIsNotNull:
+		return func(row tuple.Tuple) bool { return row[colIdx] != nil }, nil
+	case OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+	default:
+		return nil, ErrInvalidFilter
+	}
+
+	if !isTypeMatch(schema.Columns[colIdx].Type, c.value) {
+		return nil, ErrInvalidFilter
+	}
+	if t, ok := c.value.(time.Time); ok {
+		if _, err := tuple.TimestampNanos(t); err != nil {
+			return nil, err
+		}
+	}
+
+	op, value := c.op, c.value
+	return func(row tuple.Tuple) bool {
+		if row[colIdx] == nil {
+			return false
+		}
+
+		result := tuple.Compare(row[colIdx], value)
+		switch op {
+		case OpEqual:
+			return result == 0
+		case OpNotEqual:
+			return result != 0
+		case OpLess:
+			return result < 0
+		case OpLessEqual:
+			return result <= 0
+		case OpGreater:
+			return result > 0
+		default:
+			return result >= 0
+		}
+	}, nil
+}
+
+type junction struct {
+	filters []Filter
+	any     bool
+}
+
+// And matches rows that match every filter.
+func And(filters ...Filter) Filter {
+	return junction{filters: filters}
+}
+
+// Or matches rows that match at least one filter.
+func Or(filters ...Filter) Filter {
+	return junction{filters: filters, any: true}
+}
+
+func (j junction) String() string {
+	parts := make([]string, len(j.filters))
+	for i, f := range j.filters {
+		parts[i] = fmt.Sprint(f)
+	}
+	if j.any {
+		return "(" + strings.Join(parts, " OR ") + ")"
+	}
+	return "(" + strings.Join(parts, " AND ") + ")"
+}
+
+func (j junction) bind(schema *catalog.Schema) (predicate, error) {
+	predicates := make([]predicate, len(j.filters))
+	for i, f := range j.filters {
+		if f == nil {
+			return nil, ErrInvalidFilter
+		}
+		p, err := f.bind(schema)
+		if err != nil {
+			return nil, err
+		}
+		predicates[i] = p
+	}
+
+	matchAny := j.any
+	return func(row tuple.Tuple) bool {
+		for _, p := range predicates {
+			if p(row) == matchAny {
+				return matchAny
+			}
+		}
+		return !matchAny
+	}, nil
+}
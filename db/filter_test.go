@@ -0,0 +1,144 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestScanFilter(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "score", Type: catalog.TypeFloat},
+		{Name: "active", Type: catalog.TypeBoolean},
+	}
+	if _, err := db.CreateTable("players", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	var rows []tuple.Tuple
+	for i := range 20 {
+		row := tuple.Tuple{int64(i), fmt.Sprintf("player_%02d", i), float64(i * 10), i%2 == 0}
+		if i%5 == 0 {
+			row[2] = nil
+		}
+		if err := db.Insert("players", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+		rows = append(rows, row)
+	}
+
+	pick := func(ids ...int) []tuple.Tuple {
+		var picked []tuple.Tuple
+		for _, id := range ids {
+			picked = append(picked, rows[id])
+		}
+		return picked
+	}
+
+	tests := []struct {
+		name       string
+		start, end tuple.Value
+		filter     Filter
+		expected   []tuple.Tuple
+	}{
+		{
+			name:     "equal",
+			filter:   Where("name", OpEqual, "player_07"),
+			expected: pick(7),
+		},
+		{
+			name:     "greater skips nulls",
+			filter:   Where("score", OpGreaterEqual, float64(150)),
+			expected: pick(16, 17, 18, 19),
+		},
+		{
+			name:     "is null",
+			filter:   Where("score", OpIsNull, nil),
+			expected: pick(0, 5, 10, 15),
+		},
+		{
+			name:     "and",
+			filter:   And(Where("active", OpEqual, true), Where("score", OpLess, float64(50))),
+			expected: pick(2, 4),
+		},
+		{
+			name: "or with nested and",
+			filter: Or(
+				Where("id", OpEqual, int64(3)),
+				And(Where("id", OpGreater, int64(17)), Where("active", OpNotEqual, true)),
+			),
+			expected: pick(3, 19),
+		},
+		{
+			name:     "filter within key range",
+			start:    int64(10),
+			end:      int64(14),
+			filter:   Where("score", OpIsNotNull, nil),
+			expected: pick(11, 12, 13),
+		},
+		{
+			name:     "empty and matches all",
+			start:    int64(18),
+			filter:   And(),
+			expected: pick(18, 19),
+		},
+		{
+			name:     "empty or matches none",
+			filter:   Or(),
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner, err := db.Scan("players", tt.start, tt.end, tt.filter)
+			if err != nil {
+				t.Fatalf("failed to scan: %v", err)
+			}
+
+			var scanned []tuple.Tuple
+			for {
+				row, err := scanner.Next()
+				if err != nil {
+					t.Fatalf("error while scanning: %v", err)
+				}
+				if row == nil {
+					break
+				}
+				scanned = append(scanned, row)
+			}
+
+			if !reflect.DeepEqual(scanned, tt.expected) {
+				t.Errorf("expected rows %v, got %v", tt.expected, scanned)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name     string
+		filter   Filter
+		expected error
+	}{
+		{"unknown column", Where("rank", OpEqual, int64(1)), ErrColumnNotFound},
+		{"type mismatch", Where("score", OpLess, int64(1)), ErrInvalidFilter},
+		{"unknown operator", Where("id", Operator(99), int64(1)), ErrInvalidFilter},
+		{"nested error", And(Where("id", OpEqual, int64(1)), Where("rank", OpIsNull, nil)), ErrColumnNotFound},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := db.Scan("players", nil, nil, tt.filter); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
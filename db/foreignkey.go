@@ -0,0 +1,234 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// Every foreign key value is mirrored by an entry in a reverse index so
+// that deleting a referenced row can find the rows pointing at it without
+// scanning the referencing tables. The entry key is
+//
+//	"fk:" | parent table ID | parent key length | parent key | column | child key
+//
+// where the child key is the full storage key of the referencing row.
+var referencePrefix = []byte("fk:")
+
+var referenceMarker = []byte{1}
+
+type pendingDelete struct {
+	schema *catalog.Schema
+	key    []byte
+	row    tuple.Tuple
+}
+
+func referenceTablePrefix(parentID uint32) []byte {
+	key := make([]byte, 0, len(referencePrefix)+4)
+	key = append(key, referencePrefix...)
+	return binary.BigEndian.AppendUint32(key, parentID)
+}
+
+func referenceKeyPrefix(parentID uint32, parentPK []byte) []byte {
+	key := referenceTablePrefix(parentID)
+	key = binary.BigEndian.AppendUint16(key, uint16(len(parentPK)))
+	key = append(key, parentPK...)
+	return key
+}
+
+func referenceKey(parentID uint32, parentPK []byte, column int, childKey []byte) []byte {
+	key := referenceKeyPrefix(parentID, parentPK)
+	key = binary.BigEndian.AppendUint16(key, uint16(column))
+	return append(key, childKey...)
+}
+
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func hasReferences(schema *catalog.Schema) bool {
+	for _, c := range schema.Columns {
+		if c.References != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *Database) checkReferences(schema *catalog.Schema, row tuple.Tuple, key []byte) error {
+	for i, c := range schema.Columns {
+		if c.References == nil || row[i] == nil {
+			continue
+		}
+
+		parent, err := db.catalog.GetTable(c.References.Table)
+		if err != nil {
+			return err
+		}
+		parentKey, err := EncodeKey(parent.ID, row[i])
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(parentKey, key) {
+			continue
+		}
+
+		_, found, err := db.store.Get(parentKey)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrForeignKeyViolation
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) addReference(schema *catalog.Schema, column int, value tuple.Value, key []byte) error {
+	parent, err := db.catalog.GetTable(schema.Columns[column].References.Table)
+	if err != nil {
+		return err
+	}
+	parentKey, err := EncodeKey(parent.ID, value)
+	if err != nil {
+		return err
+	}
+
+	return db.store.Put(referenceKey(parent.ID, parentKey[4:], column, key), referenceMarker)
+}
+
+func (db *Database) removeReference(schema *catalog.Schema, column int, value tuple.Value, key []byte) error {
+	parent, err := db.catalog.GetTable(schema.Columns[column].References.Table)
+	if err != nil {
+		return err
+	}
+	parentKey, err := EncodeKey(parent.ID, value)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.store.Delete(referenceKey(parent.ID, parentKey[4:], column, key))
+	return err
+}
+
+// updateReferences moves the reverse index entries of a row from oldRow to
+// newRow. A nil oldRow adds every entry and a nil newRow removes them.
+func (db *Database) updateReferences(schema *catalog.Schema, oldRow, newRow tuple.Tuple, key []byte) error {
+	for i, c := range schema.Columns {
+		if c.References == nil {
+			continue
+		}
+
+		var oldValue, newValue tuple.Value
+		if oldRow != nil {
+			oldValue = oldRow[i]
+		}
+		if newRow != nil {
+			newValue = newRow[i]
+		}
+		if tuple.Compare(oldValue, newValue) == 0 {
+			continue
+		}
+
+		if oldValue != nil {
+			if err := db.removeReference(schema, i, oldValue, key); err != nil {
+				return err
+			}
+		}
+		if newValue != nil {
+			if err := db.addReference(schema, i, newValue, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// collectDeletes gathers the row at key and every row that has to be
+// removed with it through ON DELETE CASCADE. It fails before anything is
+// deleted if some referencing row is protected by ON DELETE RESTRICT.
+func (db *Database) collectDeletes(schema *catalog.Schema, key []byte, visited map[string]struct{}, pending *[]pendingDelete) error {
+	if _, seen := visited[string(key)]; seen {
+		return nil
+	}
+	visited[string(key)] = struct{}{}
+
+	valueBytes, found, err := db.store.Get(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	row, err := db.decodeRow(schema, valueBytes)
+	if err != nil {
+		return err
+	}
+	*pending = append(*pending, pendingDelete{schema: schema, key: key, row: row})
+
+	if len(schema.ReferencedBy) == 0 {
+		return nil
+	}
+
+	children := make(map[uint32]*catalog.Schema)
+	for _, ref := range schema.ReferencedBy {
+		child, err := db.catalog.GetTable(ref.Table)
+		if err != nil {
+			return err
+		}
+		children[child.ID] = child
+	}
+
+	prefix := referenceKeyPrefix(schema.ID, key[4:])
+	iterator, err := db.store.NewIterator(prefix, prefixEnd(prefix))
+	if err != nil {
+		return err
+	}
+
+	var cascades []pendingDelete
+	for {
+		refKey, _, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		if refKey == nil {
+			break
+		}
+
+		entry := refKey[len(prefix):]
+		column := int(binary.BigEndian.Uint16(entry))
+		childKey := bytes.Clone(entry[2:])
+		child, found := children[binary.BigEndian.Uint32(childKey)]
+		if !found {
+			continue
+		}
+
+		if child.Columns[column].References.OnDelete != catalog.OnDeleteCascade {
+			if bytes.Equal(childKey, key) {
+				continue
+			}
+			return ErrForeignKeyViolation
+		}
+		cascades = append(cascades, pendingDelete{schema: child, key: childKey})
+	}
+
+	for _, c := range cascades {
+		if err := db.collectDeletes(c.schema, c.key, visited, pending); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
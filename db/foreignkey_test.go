@@ -0,0 +1,239 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func createForeignKeyTables(t *testing.T, db *Database, onDelete catalog.ReferentialAction) {
+	t.Helper()
+
+	tables := []struct {
+		name    string
+		columns []catalog.Column
+	}{
+		{
+			name: "authors",
+			columns: []catalog.Column{
+				{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+				{Name: "name", Type: catalog.TypeVarChar},
+			},
+		},
+		{
+			name: "books",
+			columns: []catalog.Column{
+				{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+				{Name: "author_id", Type: catalog.TypeInt, References: &catalog.ForeignKey{
+					Table: "authors", Column: "id", OnDelete: onDelete,
+				}},
+			},
+		},
+		{
+			name: "reviews",
+			columns: []catalog.Column{
+				{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+				{Name: "book_id", Type: catalog.TypeInt, References: &catalog.ForeignKey{
+					Table: "books", Column: "id", OnDelete: catalog.OnDeleteCascade,
+				}},
+			},
+		},
+	}
+
+	for _, table := range tables {
+		if _, err := db.CreateTable(table.name, table.columns); err != nil {
+			t.Fatalf("failed to create table %s: %v", table.name, err)
+		}
+	}
+}
+
+func TestForeignKeyInsertUpdate(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	createForeignKeyTables(t, db, catalog.OnDeleteRestrict)
+
+	if err := db.Insert("authors", tuple.Tuple{int64(1), "ann"}); err != nil {
+		t.Fatalf("failed to insert author: %v", err)
+	}
+
+	t.Run("Insert_existing_reference", func(t *testing.T) {
+		if err := db.Insert("books", tuple.Tuple{int64(10), int64(1)}); err != nil {
+			t.Errorf("failed to insert book: %v", err)
+		}
+	})
+
+	t.Run("Insert_missing_reference", func(t *testing.T) {
+		err := db.Insert("books", tuple.Tuple{int64(11), int64(2)})
+		if !errors.Is(err, ErrForeignKeyViolation) {
+			t.Errorf("expected error %v, got %v", ErrForeignKeyViolation, err)
+		}
+	})
+
+	t.Run("Insert_null_reference", func(t *testing.T) {
+		if err := db.Insert("books", tuple.Tuple{int64(12), nil}); err != nil {
+			t.Errorf("failed to insert book without author: %v", err)
+		}
+	})
+
+	t.Run("Update_to_missing_reference", func(t *testing.T) {
+		err := db.Update("books", tuple.Tuple{int64(10), int64(3)})
+		if !errors.Is(err, ErrForeignKeyViolation) {
+			t.Errorf("expected error %v, got %v", ErrForeignKeyViolation, err)
+		}
+	})
+
+	t.Run("Update_moves_reference", func(t *testing.T) {
+		if err := db.Insert("authors", tuple.Tuple{int64(2), "bo"}); err != nil {
+			t.Fatalf("failed to insert author: %v", err)
+		}
+		if err := db.Update("books", tuple.Tuple{int64(10), int64(2)}); err != nil {
+			t.Fatalf("failed to update book: %v", err)
+		}
+
+		if err := db.Delete("authors", int64(1)); err != nil {
+			t.Errorf("author 1 is no longer referenced, got %v", err)
+		}
+		if err := db.Delete("authors", int64(2)); !errors.Is(err, ErrForeignKeyViolation) {
+			t.Errorf("expected error %v, got %v", ErrForeignKeyViolation, err)
+		}
+	})
+}
+
+func TestForeignKeyDeleteRestrict(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	createForeignKeyTables(t, db, catalog.OnDeleteRestrict)
+
+	db.Insert("authors", tuple.Tuple{int64(1), "ann"})
+	db.Insert("books", tuple.Tuple{int64(10), int64(1)})
+
+	if err := db.Delete("authors", int64(1)); !errors.Is(err, ErrForeignKeyViolation) {
+		t.Fatalf("expected error %v, got %v", ErrForeignKeyViolation, err)
+	}
+	if _, found, _ := db.Get("authors", int64(1)); !found {
+		t.Errorf("restricted author should not be deleted")
+	}
+
+	if err := db.Delete("books", int64(10)); err != nil {
+		t.Fatalf("failed to delete book: %v", err)
+	}
+	if err := db.Delete("authors", int64(1)); err != nil {
+		t.Errorf("failed to delete unreferenced author: %v", err)
+	}
+}
+
+func TestForeignKeyDeleteCascade(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	createForeignKeyTables(t, db, catalog.OnDeleteCascade)
+
+	db.Insert("authors", tuple.Tuple{int64(1), "ann"})
+	db.Insert("authors", tuple.Tuple{int64(2), "bo"})
+	db.Insert("books", tuple.Tuple{int64(10), int64(1)})
+	db.Insert("books", tuple.Tuple{int64(11), int64(1)})
+	db.Insert("books", tuple.Tuple{int64(12), int64(2)})
+	db.Insert("reviews", tuple.Tuple{int64(100), int64(10)})
+	db.Insert("reviews", tuple.Tuple{int64(101), int64(12)})
+
+	if err := db.Delete("authors", int64(1)); err != nil {
+		t.Fatalf("failed to delete author: %v", err)
+	}
+
+	expected := []struct {
+		table string
+		pk    int64
+		found bool
+	}{
+		{"authors", 1, false},
+		{"authors", 2, true},
+		{"books", 10, false},
+		{"books", 11, false},
+		{"books", 12, true},
+		{"reviews", 100, false},
+		{"reviews", 101, true},
+	}
+
+	for _, e := range expected {
+		_, found, err := db.Get(e.table, e.pk)
+		if err != nil {
+			t.Fatalf("failed to get %d from %s: %v", e.pk, e.table, err)
+		}
+		if found != e.found {
+			t.Errorf("expected found %v for %d in %s, got %v", e.found, e.pk, e.table, found)
+		}
+	}
+}
+
+func TestForeignKeySelfReference(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "manager_id", Type: catalog.TypeInt, References: &catalog.ForeignKey{
+			Table: "employees", Column: "id", OnDelete: catalog.OnDeleteCascade,
+		}},
+	}
+	if _, err := db.CreateTable("employees", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	db.Insert("employees", tuple.Tuple{int64(1), int64(1)})
+	db.Insert("employees", tuple.Tuple{int64(2), int64(1)})
+	db.Insert("employees", tuple.Tuple{int64(3), int64(2)})
+
+	if err := db.Delete("employees", int64(1)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	scanner, err := db.Scan("employees", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	row, err := scanner.Next()
+	if err != nil || row != nil {
+		t.Errorf("expected all employees to be deleted, got %v, %v", row, err)
+	}
+}
+
+// failingPuts fails the writes of reverse and secondary index entries,
+// which go through Put, while the rows themselves go through Write.
+type failingPuts struct {
+	Store
+}
+
+var errPutFailed = errors.New("put failed")
+
+func (s failingPuts) Put(key []byte, value []byte) error {
+	return errPutFailed
+}
+
+func TestForeignKeyWritesAreAtomic(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	createForeignKeyTables(t, db, catalog.OnDeleteRestrict)
+	if err := db.Insert("authors", tuple.Tuple{int64(1), "ann"}); err != nil {
+		t.Fatalf("failed to insert author: %v", err)
+	}
+
+	store := db.store
+	db.store = failingPuts{store}
+	err := db.Insert("books", tuple.Tuple{int64(10), int64(1)})
+	db.store = store
+	if !errors.Is(err, errPutFailed) {
+		t.Fatalf("expected error %v, got %v", errPutFailed, err)
+	}
+
+	if _, found, err := db.Get("books", int64(10)); err != nil || found {
+		t.Errorf("expected no book after its reverse entry failed, got found %v, err %v", found, err)
+	}
+	if err := db.Delete("authors", int64(1)); err != nil {
+		t.Errorf("expected author 1 to be unreferenced, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package db
+
+import "github.com/rizalta/toydb/tuple"
+
+// GroupWriter stages the writes of a WriteGroup. Its methods work like the
+// Database methods of the same name, and later writes and reads in the
+// group see the earlier ones.
+type GroupWriter struct {
+	db *Database
+	// err is the first write that failed; the group is rolled back even if
+	// the function passed to WriteGroup goes on.
+	err error
+}
+
+// WriteGroup runs fn and commits the writes it makes through the
+// GroupWriter, to any number of tables, as one batch in the data log: after
+// a crash either all of them are there or none. If fn or any of its writes
+// fails, none of the writes are kept and the error is returned.
+//
+// The in-memory column sketches are not rolled back and may count rows
+// written by a group that failed. Other writes to the database must not
+// run while a group is open.
+func (db *Database) WriteGroup(fn func(w *GroupWriter) error) error {
+	if err := db.store.BeginBatch(); err != nil {
+		return err
+	}
+
+	w := &GroupWriter{db: db}
+	err := fn(w)
+	if err == nil {
+		err = w.err
+	}
+	if err != nil {
+		if abortErr := db.store.AbortBatch(); abortErr != nil {
+			return abortErr
+		}
+		return err
+	}
+	return db.store.CommitBatch()
+}
+
+func (w *GroupWriter) Insert(tableName string, row tuple.Tuple) error {
+	return w.record(w.db.Insert(tableName, row))
+}
+
+func (w *GroupWriter) Update(tableName string, row tuple.Tuple) error {
+	return w.record(w.db.Update(tableName, row))
+}
+
+func (w *GroupWriter) Upsert(tableName string, row tuple.Tuple) (bool, error) {
+	inserted, err := w.db.Upsert(tableName, row)
+	return inserted, w.record(err)
+}
+
+func (w *GroupWriter) Delete(tableName string, primaryKey tuple.Value) error {
+	return w.record(w.db.Delete(tableName, primaryKey))
+}
+
+// Get reads a row as the group sees it, with its own writes applied.
+func (w *GroupWriter) Get(tableName string, primaryKey tuple.Value) (tuple.Tuple, bool, error) {
+	return w.db.Get(tableName, primaryKey)
+}
+
+func (w *GroupWriter) record(err error) error {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
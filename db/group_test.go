@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestWriteGroup(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	createForeignKeyTables(t, db, catalog.OnDeleteRestrict)
+	if err := db.Insert("authors", tuple.Tuple{int64(1), "ann"}); err != nil {
+		t.Fatalf("failed to insert author: %v", err)
+	}
+
+	errStop := errors.New("stop")
+	tests := []struct {
+		name    string
+		fn      func(w *GroupWriter) error
+		wantErr error
+		// authors and books are the primary keys expected to exist after
+		// the group.
+		authors []int64
+		books   []int64
+	}{
+		{
+			name: "commit",
+			fn: func(w *GroupWriter) error {
+				if err := w.Insert("authors", tuple.Tuple{int64(2), "bob"}); err != nil {
+					return err
+				}
+				return w.Insert("books", tuple.Tuple{int64(10), int64(2)})
+			},
+			authors: []int64{1, 2},
+			books:   []int64{10},
+		},
+		{
+			name: "failed write",
+			fn: func(w *GroupWriter) error {
+				if err := w.Insert("authors", tuple.Tuple{int64(3), "cy"}); err != nil {
+					return err
+				}
+				return w.Insert("books", tuple.Tuple{int64(11), int64(99)})
+			},
+			wantErr: ErrForeignKeyViolation,
+			authors: []int64{1, 2},
+			books:   []int64{10},
+		},
+		{
+			name: "ignored failed write",
+			fn: func(w *GroupWriter) error {
+				if err := w.Delete("books", int64(10)); err != nil {
+					return err
+				}
+				w.Insert("authors", tuple.Tuple{int64(1), "ann"})
+				return nil
+			},
+			wantErr: index.ErrKeyAlreadyExists,
+			authors: []int64{1, 2},
+			books:   []int64{10},
+		},
+		{
+			name: "function error",
+			fn: func(w *GroupWriter) error {
+				if err := w.Delete("books", int64(10)); err != nil {
+					return err
+				}
+				if _, found, err := w.Get("books", int64(10)); err != nil || found {
+					t.Errorf("expected the group to see its delete, got found %v, err %v", found, err)
+				}
+				if err := w.Delete("authors", int64(2)); err != nil {
+					return err
+				}
+				return errStop
+			},
+			wantErr: errStop,
+			authors: []int64{1, 2},
+			books:   []int64{10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.WriteGroup(tt.fn); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			for table, keys := range map[string][]int64{"authors": tt.authors, "books": tt.books} {
+				count, err := db.Count(table, nil, nil)
+				if err != nil {
+					t.Fatalf("failed to count %s: %v", table, err)
+				}
+				if count != len(keys) {
+					t.Errorf("expected %d rows in %s, got %d", len(keys), table, count)
+				}
+				for _, key := range keys {
+					if _, found, err := db.Get(table, key); err != nil || !found {
+						t.Errorf("expected %s row %d, got found %v, err %v", table, key, found, err)
+					}
+				}
+			}
+		})
+	}
+}
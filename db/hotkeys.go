@@ -0,0 +1,134 @@
+package db
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+const (
+	// DefaultReadSampling is the rate reads are sampled at by default, one
+	// read in DefaultReadSampling.
+	DefaultReadSampling = 64
+
+	// hotKeyCapacity is the number of keys tracked at once, which bounds
+	// the N HotKeys can answer for.
+	hotKeyCapacity = 256
+)
+
+// HotKey is a frequently read row with its estimated number of reads since
+// the database was opened.
+type HotKey struct {
+	Table string
+	Key   tuple.Value
+	Reads uint64
+	// Error bounds how much Reads may overcount, which happens when the
+	// key took the place of another one in the tracked set.
+	Error uint64
+}
+
+type hotKey struct {
+	table string
+	key   tuple.Value
+	count uint64
+	error uint64
+}
+
+// hotKeySampler counts sampled reads of the most read keys with the Space
+// Saving algorithm: a key that is not tracked takes the place of the
+// least counted one and inherits its count, so no key is undercounted.
+type hotKeySampler struct {
+	mu   sync.Mutex
+	rate int
+	keys map[string]*hotKey
+}
+
+func newHotKeySampler(rate int) *hotKeySampler {
+	return &hotKeySampler{rate: rate, keys: make(map[string]*hotKey)}
+}
+
+// SetReadSampling sets how many reads go by for each one that is counted
+// towards HotKeys, and forgets the counts so far. A rate of 0 turns
+// sampling off.
+func (db *Database) SetReadSampling(rate int) {
+	db.hot.mu.Lock()
+	defer db.hot.mu.Unlock()
+	db.hot.rate = max(rate, 0)
+	clear(db.hot.keys)
+}
+
+// sample counts a read of key, a key of a row of schema, if it is picked.
+func (h *hotKeySampler) sample(schema *catalog.Schema, key []byte, primaryKey tuple.Value) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.rate == 0 || (h.rate > 1 && rand.IntN(h.rate) != 0) {
+		return
+	}
+
+	if k, found := h.keys[string(key)]; found {
+		k.count++
+		return
+	}
+	if len(h.keys) < hotKeyCapacity {
+		h.keys[string(key)] = &hotKey{table: schema.Name, key: primaryKey, count: 1}
+		return
+	}
+
+	var weakest string
+	var weakestKey *hotKey
+	for encoded, k := range h.keys {
+		if weakestKey == nil || k.count < weakestKey.count {
+			weakest, weakestKey = encoded, k
+		}
+	}
+	delete(h.keys, weakest)
+	h.keys[string(key)] = &hotKey{
+		table: schema.Name,
+		key:   primaryKey,
+		count: weakestKey.count + 1,
+		error: weakestKey.count,
+	}
+}
+
+// HotKeys returns up to n of the most read rows, most read first, to find
+// skewed access worth caching or a different schema. Reads are sampled,
+// so counts are estimates scaled by the sampling rate and keys read only
+// a few times may be missing. n is capped at the number of tracked keys.
+func (db *Database) HotKeys(n int) []HotKey {
+	db.hot.mu.Lock()
+	defer db.hot.mu.Unlock()
+
+	rate := uint64(max(db.hot.rate, 1))
+	hot := make([]HotKey, 0, len(db.hot.keys))
+	for _, k := range db.hot.keys {
+		hot = append(hot, HotKey{
+			Table: k.table,
+			Key:   k.key,
+			Reads: k.count * rate,
+			Error: k.error * rate,
+		})
+	}
+	slices.SortFunc(hot, func(a, b HotKey) int {
+		if a.Reads != b.Reads {
+			if a.Reads > b.Reads {
+				return -1
+			}
+			return 1
+		}
+		if a.Table != b.Table {
+			if a.Table < b.Table {
+				return -1
+			}
+			return 1
+		}
+		return tuple.Compare(a.Key, b.Key)
+	})
+
+	if len(hot) > n {
+		hot = hot[:max(n, 0)]
+	}
+	return hot
+}
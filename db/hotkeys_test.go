@@ -0,0 +1,96 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestHotKeys(t *testing.T) {
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	for _, table := range []string{"users", "items"} {
+		if _, err := db.CreateTable(table, columns); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+		for i := range 10 {
+			if err := db.Insert(table, tuple.Tuple{int64(i), fmt.Sprintf("%s_%d", table, i)}); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+
+	get := func(table string, id int64, times int) {
+		t.Helper()
+		for range times {
+			if _, _, err := db.Get(table, id); err != nil {
+				t.Fatalf("failed to get: %v", err)
+			}
+		}
+	}
+
+	db.SetReadSampling(1)
+	get("users", 3, 50)
+	get("items", 7, 30)
+	if _, err := db.GetMany("users", []tuple.Value{int64(1), int64(3)}); err != nil {
+		t.Fatalf("failed to get many: %v", err)
+	}
+	get("items", 2, 5)
+
+	expected := []HotKey{
+		{Table: "users", Key: int64(3), Reads: 51},
+		{Table: "items", Key: int64(7), Reads: 30},
+		{Table: "items", Key: int64(2), Reads: 5},
+	}
+	hot := db.HotKeys(3)
+	if len(hot) != len(expected) {
+		t.Fatalf("expected %d hot keys, got %v", len(expected), hot)
+	}
+	for i, want := range expected {
+		if hot[i] != want {
+			t.Errorf("hot key %d: expected %+v, got %+v", i, want, hot[i])
+		}
+	}
+
+	// Past the tracked capacity, a new key takes over the count of the
+	// least read one, but the hottest keys stay on top.
+	for i := range 2 * hotKeyCapacity {
+		if _, _, err := db.Get("users", int64(100+i)); err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+	}
+	hot = db.HotKeys(2)
+	if len(hot) != 2 || hot[0] != expected[0] || hot[1] != expected[1] {
+		t.Errorf("expected %v on top, got %v", expected[:2], hot)
+	}
+
+	db.SetReadSampling(8)
+	if hot := db.HotKeys(10); len(hot) != 0 {
+		t.Errorf("expected counts to be reset, got %v", hot)
+	}
+	get("users", 3, 4000)
+	get("items", 7, 400)
+	hot = db.HotKeys(10)
+	if len(hot) != 2 || hot[0].Key != int64(3) || hot[1].Key != int64(7) {
+		t.Fatalf("expected users 3 and items 7, got %v", hot)
+	}
+	if hot[0].Reads < 3000 || hot[0].Reads > 5000 || hot[0].Reads%8 != 0 {
+		t.Errorf("expected about 4000 reads in multiples of 8, got %d", hot[0].Reads)
+	}
+
+	db.SetReadSampling(0)
+	get("users", 3, 100)
+	if hot := db.HotKeys(10); len(hot) != 0 {
+		t.Errorf("expected no sampling, got %v", hot)
+	}
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var (
+	ErrTooManyRejects   = errors.New("db: too many rejected rows")
+	ErrDuplicateColumns = errors.New("db: column listed more than once")
+)
+
+const defaultImportBatch = 1000
+
+type ImportOptions struct {
+	// Columns names the columns the fields of each record go to. If it is
+	// empty, the first record is a header that names them. Columns that are
+	// left out take their default value.
+	Columns []string
+	// BatchSize is the number of rows between savepoints, 1000 if zero.
+	BatchSize int
+	// MaxRejects stops the import once more rows than this were rejected.
+	// Zero allows any number.
+	MaxRejects int
+	// Errors receives every rejected record as a CSV line holding its line
+	// number, the reason and then the original fields.
+	Errors io.Writer
+}
+
+type ImportSummary struct {
+	Records  int
+	Imported int
+	Rejected int
+	Batches  int
+}
+
+func (s ImportSummary) String() string {
+	return fmt.Sprintf("imported %d of %d rows in %d batches, %d rejected", s.Imported, s.Records, s.Batches, s.Rejected)
+}
+
+// ImportCSV inserts the records of a CSV file into a table. Empty fields
+// are NULL; other fields are parsed as the column type, with blobs in hex
+// and timestamps in any form ParseTimestamp accepts.
+//
+// A record that cannot be parsed or inserted is rejected and written to
+// opts.Errors, and the import goes on with the next one. Rows are inserted
+// in batches that end in a savepoint: when the import stops early, because
+// of MaxRejects or an error reading the input, the rows of the unfinished
+// batch are deleted again, so the table holds exactly the rows of the
+// completed batches, which the summary counts.
+func (db *Database) ImportCSV(tableName string, r io.Reader, opts ImportOptions) (*ImportSummary, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BatchSize < 0 || opts.MaxRejects < 0 {
+		return nil, ErrInvalidQuery
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultImportBatch
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	var rejects *csv.Writer
+	if opts.Errors != nil {
+		rejects = csv.NewWriter(opts.Errors)
+	}
+
+	names := opts.Columns
+	if len(names) == 0 {
+		if names, err = reader.Read(); err != nil {
+			if err == io.EOF {
+				return &ImportSummary{}, nil
+			}
+			return nil, err
+		}
+	}
+	columns, err := importColumns(schema, names)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ImportSummary{}
+	var batch []tuple.Value
+
+	// abort rolls back to the last savepoint.
+	abort := func(cause error) (*ImportSummary, error) {
+		for i := len(batch) - 1; i >= 0; i-- {
+			if err := db.Delete(tableName, batch[i]); err != nil {
+				return summary, err
+			}
+		}
+		summary.Imported -= len(batch)
+		if rejects != nil {
+			rejects.Flush()
+		}
+		return summary, cause
+	}
+
+	reject := func(line int, reason error, fields []string) error {
+		summary.Rejected++
+		if rejects != nil {
+			record := append([]string{strconv.Itoa(line), reason.Error()}, fields...)
+			if err := rejects.Write(record); err != nil {
+				return err
+			}
+		}
+		if opts.MaxRejects > 0 && summary.Rejected > opts.MaxRejects {
+			return ErrTooManyRejects
+		}
+		return nil
+	}
+
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			summary.Records++
+			if err := reject(parseErr.StartLine, parseErr.Err, fields); err != nil {
+				return abort(err)
+			}
+			continue
+		}
+		if err != nil {
+			return abort(err)
+		}
+		summary.Records++
+		line, _ := reader.FieldPos(0)
+
+		row, err := parseRecord(schema, columns, fields)
+		if err == nil {
+			_, err = db.insert(schema, row)
+		}
+		if err != nil {
+			if err := reject(line, err, fields); err != nil {
+				return abort(err)
+			}
+			continue
+		}
+
+		summary.Imported++
+		batch = append(batch, fillDefaults(schema, row)[schema.PrimaryKeyIndex])
+		if len(batch) == opts.BatchSize {
+			summary.Batches++
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		summary.Batches++
+	}
+	if rejects != nil {
+		rejects.Flush()
+		if err := rejects.Error(); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+func importColumns(schema *catalog.Schema, names []string) ([]int, error) {
+	columns := make([]int, len(names))
+	seen := make(map[int]bool)
+	for i, name := range names {
+		colIdx := columnIndex(schema, name)
+		if colIdx == -1 {
+			return nil, ErrColumnNotFound
+		}
+		if seen[colIdx] {
+			return nil, ErrDuplicateColumns
+		}
+		seen[colIdx] = true
+		columns[i] = colIdx
+	}
+	return columns, nil
+}
+
+func parseRecord(schema *catalog.Schema, columns []int, fields []string) (tuple.Tuple, error) {
+	if len(fields) != len(columns) {
+		return nil, ErrColumnCountMismatch
+	}
+
+	row := make(tuple.Tuple, len(schema.Columns))
+	for i, field := range fields {
+		col := schema.Columns[columns[i]]
+		v, err := parseValue(col.Type, field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", col.Name, err)
+		}
+		row[columns[i]] = v
+	}
+	return row, nil
+}
+
+func parseValue(t catalog.DataType, s string) (tuple.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var v tuple.Value
+	var err error
+	switch t {
+	case catalog.TypeInt:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case catalog.TypeFloat:
+		v, err = strconv.ParseFloat(s, 64)
+	case catalog.TypeVarChar:
+		v = s
+	case catalog.TypeBoolean:
+		v, err = strconv.ParseBool(s)
+	case catalog.TypeBlob:
+		v, err = hex.DecodeString(s)
+	case catalog.TypeTimestamp:
+		v, err = tuple.ParseTimestamp(s)
+	default:
+		err = ErrInvalidValue
+	}
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	return v, nil
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestImportCSV(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "score", Type: catalog.TypeFloat},
+		{Name: "active", Type: catalog.TypeBoolean, DefaultValue: true},
+	}
+	if _, err := db.CreateTable("players", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	input := strings.Join([]string{
+		"name,id,score",
+		"alice,1,9.5",
+		"bob,2,",
+		"carol,x,1",
+		",3,2",
+		"dave,2,3",
+		"erin,4,7,extra",
+		"frank,5,\"1.5\"",
+	}, "\n")
+	var errs bytes.Buffer
+	summary, err := db.ImportCSV("players", strings.NewReader(input), ImportOptions{BatchSize: 2, Errors: &errs})
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+	expected := ImportSummary{Records: 7, Imported: 3, Rejected: 4, Batches: 2}
+	if *summary != expected {
+		t.Errorf("expected summary %v, got %v", expected, *summary)
+	}
+
+	expectedErrs := strings.Join([]string{
+		"4,id: db: value does not match column type,carol,x,1",
+		"5,db: value cannot be NULL,,3,2",
+		"6,index: key already exists,dave,2,3",
+		"7,db: number of values mismatch with schema column count,erin,4,7,extra",
+		"",
+	}, "\n")
+	if errs.String() != expectedErrs {
+		t.Errorf("expected errors\n%s\ngot\n%s", expectedErrs, errs.String())
+	}
+
+	row, found, err := db.Get("players", int64(2))
+	if err != nil || !found {
+		t.Fatalf("expected row 2, got found=%v err=%v", found, err)
+	}
+	if expected := (tuple.Tuple{int64(2), "bob", nil, true}); !reflect.DeepEqual(row, expected) {
+		t.Errorf("expected %v, got %v", expected, row)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		opts     ImportOptions
+		expected ImportSummary
+		err      error
+		ids      []int64
+	}{
+		{
+			name:     "too many rejects rolls back the open batch",
+			input:    "10,a\n11,b\n12,c\nx,d\ny,f\n13,e",
+			opts:     ImportOptions{Columns: []string{"id", "name"}, BatchSize: 2, MaxRejects: 1},
+			expected: ImportSummary{Records: 5, Imported: 2, Rejected: 2, Batches: 1},
+			err:      ErrTooManyRejects,
+			ids:      []int64{10, 11},
+		},
+		{
+			name:  "unknown column",
+			input: "id,nickname\n20,x",
+			err:   ErrColumnNotFound,
+			ids:   []int64{10, 11},
+		},
+		{
+			name:  "duplicate column",
+			input: "id,name,id\n20,x,20",
+			err:   ErrDuplicateColumns,
+			ids:   []int64{10, 11},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, err := db.ImportCSV("players", strings.NewReader(tt.input), tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if summary != nil && *summary != tt.expected {
+				t.Errorf("expected summary %v, got %v", tt.expected, *summary)
+			}
+			for id := int64(10); id < 15; id++ {
+				_, found, err := db.Get("players", id)
+				if err != nil {
+					t.Fatalf("failed to get row: %v", err)
+				}
+				want := false
+				for _, expected := range tt.ids {
+					want = want || expected == id
+				}
+				if found != want {
+					t.Errorf("expected row %d found=%v, got %v", id, want, found)
+				}
+			}
+		})
+	}
+}
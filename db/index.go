@@ -0,0 +1,287 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var (
+	ErrIndexNotFound = errors.New("db: index not found")
+	ErrIndexType     = errors.New("db: operation not supported by index type")
+	ErrIndexBuilding = errors.New("db: index is still being built")
+)
+
+// Secondary indexes are kept as entries in the store, one per row, keyed
+//
+//	"ix:" | index ID | encoded column values | row key
+//
+// so that a range of entries maps to the rows holding a range of values.
+// The primary index, which has ID 0, is the table itself and has no
+// entries.
+var indexPrefix = []byte("ix:")
+
+func indexEntryPrefix(indexID uint32) []byte {
+	key := make([]byte, 0, len(indexPrefix)+4)
+	key = append(key, indexPrefix...)
+	return binary.BigEndian.AppendUint32(key, indexID)
+}
+
+// indexEntryKey returns the entry of a row in an index, or nil if the row
+// is not indexed.
+func indexEntryKey(schema *catalog.Schema, info *catalog.IndexInfo, row tuple.Tuple, key []byte) ([]byte, error) {
+	entry := indexEntryPrefix(info.ID)
+
+	switch info.Type {
+	case catalog.IndexZOrder:
+		x, y := row[columnIndex(schema, info.Columns[0])], row[columnIndex(schema, info.Columns[1])]
+		if x == nil || y == nil {
+			return nil, nil
+		}
+		xCoord, err := zOrderCoordinate(x)
+		if err != nil {
+			return nil, err
+		}
+		yCoord, err := zOrderCoordinate(y)
+		if err != nil {
+			return nil, err
+		}
+		entry = appendZOrder(entry, mortonEncode(xCoord, yCoord))
+	default:
+		for _, name := range info.Columns {
+			entry = appendIndexValue(entry, row[columnIndex(schema, name)])
+		}
+	}
+
+	return append(entry, key...), nil
+}
+
+// appendIndexValue appends an encoding of v whose byte order matches the
+// value order of tuple.Compare within a column type. Every value starts
+// with a marker byte so that NULL sorts first, and strings and blobs are
+// escaped and terminated so that a shorter value sorts before a longer one
+// it prefixes, whatever follows it in the entry.
+func appendIndexValue(buf []byte, v tuple.Value) []byte {
+	if v == nil {
+		return append(buf, 0)
+	}
+	buf = append(buf, 1)
+
+	switch v := v.(type) {
+	case int64:
+		return binary.BigEndian.AppendUint64(buf, uint64(v)^signBit)
+	case float64:
+		bits := math.Float64bits(v)
+		if bits&signBit != 0 {
+			bits = ^bits
+		} else {
+			bits ^= signBit
+		}
+		return binary.BigEndian.AppendUint64(buf, bits)
+	case time.Time:
+		return binary.BigEndian.AppendUint64(buf, uint64(v.UnixNano())^signBit)
+	case bool:
+		if v {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case string:
+		return appendEscaped(buf, []byte(v))
+	case []byte:
+		return appendEscaped(buf, v)
+	default:
+		return buf
+	}
+}
+
+// indexEntryRowKey returns the row key at the end of a B-tree index entry,
+// skipping over the encoded column values in front of it.
+func indexEntryRowKey(schema *catalog.Schema, info *catalog.IndexInfo, entry []byte) []byte {
+	rest := entry[len(indexPrefix)+4:]
+	for _, name := range info.Columns {
+		rest = rest[indexValueSize(schema.Columns[columnIndex(schema, name)].Type, rest):]
+	}
+	return rest
+}
+
+// indexValueSize returns the length of the value encoded by
+// appendIndexValue at the start of buf.
+func indexValueSize(colType catalog.DataType, buf []byte) int {
+	if buf[0] == 0 {
+		return 1
+	}
+
+	switch colType {
+	case catalog.TypeInt, catalog.TypeFloat, catalog.TypeTimestamp:
+		return 9
+	case catalog.TypeBoolean:
+		return 2
+	default:
+		i := 1
+		for buf[i] != 0 || buf[i+1] != 1 {
+			if buf[i] == 0 {
+				i++
+			}
+			i++
+		}
+		return i + 2
+	}
+}
+
+func appendEscaped(buf, data []byte) []byte {
+	for _, b := range data {
+		if b == 0 {
+			buf = append(buf, 0, 0xff)
+		} else {
+			buf = append(buf, b)
+		}
+	}
+	return append(buf, 0, 1)
+}
+
+func hasSecondaryIndexes(schema *catalog.Schema) bool {
+	for _, info := range schema.Indexes {
+		if info.ID != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDerivedEntries reports whether writing a row of the table writes
+// entries derived from it to the store as well.
+func hasDerivedEntries(schema *catalog.Schema) bool {
+	return hasReferences(schema) || hasSecondaryIndexes(schema)
+}
+
+// needsOldRow reports whether writing a row of the table has to update
+// entries derived from the row it replaces.
+func needsOldRow(schema *catalog.Schema) bool {
+	return hasReferences(schema) || hasSecondaryIndexes(schema) || hasSketches(schema)
+}
+
+// updateIndexes moves the secondary index entries of a row from oldRow to
+// newRow. A nil oldRow adds every entry and a nil newRow removes them.
+func (db *Database) updateIndexes(schema *catalog.Schema, oldRow, newRow tuple.Tuple, key []byte) error {
+	for _, info := range schema.Indexes {
+		if info.ID == 0 {
+			continue
+		}
+
+		var oldEntry, newEntry []byte
+		var err error
+		if oldRow != nil {
+			if oldEntry, err = indexEntryKey(schema, info, oldRow, key); err != nil {
+				return err
+			}
+		}
+		if newRow != nil {
+			if newEntry, err = indexEntryKey(schema, info, newRow, key); err != nil {
+				return err
+			}
+		}
+		if bytes.Equal(oldEntry, newEntry) {
+			continue
+		}
+
+		if oldEntry != nil {
+			if _, err := db.store.Delete(oldEntry); err != nil {
+				return err
+			}
+		}
+		if newEntry != nil {
+			if err := db.store.Put(newEntry, referenceMarker); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// updateDerived keeps everything derived from a row, foreign key reverse
+// entries, secondary index entries and column sketches, in step with a
+// change of the row.
+func (db *Database) updateDerived(schema *catalog.Schema, oldRow, newRow tuple.Tuple, key []byte) error {
+	if err := db.updateReferences(schema, oldRow, newRow, key); err != nil {
+		return err
+	}
+	db.updateSketches(schema, oldRow, newRow)
+	return db.updateIndexes(schema, oldRow, newRow, key)
+}
+
+func (db *Database) CreateIndex(tableName, indexName string, columns []string) (*catalog.IndexInfo, error) {
+	return db.CreateIndexWithOptions(tableName, indexName, columns, catalog.IndexOptions{})
+}
+
+// CreateIndexWithOptions adds a secondary index to a table and indexes the
+// rows already in it, as BuildIndex does, before it returns.
+func (db *Database) CreateIndexWithOptions(tableName, indexName string, columns []string, opts catalog.IndexOptions) (*catalog.IndexInfo, error) {
+	build, err := db.BuildIndex(tableName, indexName, columns, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		done, err := build.Step(defaultBackfillBatch)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return build.info, nil
+		}
+	}
+}
+
+// DropIndex removes a secondary index from a table. Writes stop
+// maintaining it at once, and its entries are then deleted, which frees
+// the index pages they took up. Entries left by a crash part way through
+// belong to no index and are never read again.
+func (db *Database) DropIndex(tableName, indexName string) error {
+	info, err := db.catalog.DropIndex(tableName, indexName)
+	if err != nil {
+		return err
+	}
+
+	prefix := indexEntryPrefix(info.ID)
+	iterator, err := db.store.NewKeyIterator(prefix, prefixEnd(prefix))
+	if err != nil {
+		return err
+	}
+	var entries [][]byte
+	for {
+		entry, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, entry := range entries {
+		if _, err := db.store.Delete(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findIndex returns a secondary index that queries may use.
+func findIndex(schema *catalog.Schema, indexName string) (*catalog.IndexInfo, error) {
+	for _, info := range schema.Indexes {
+		if info.Name == indexName {
+			if info.Building {
+				return nil, ErrIndexBuilding
+			}
+			return info, nil
+		}
+	}
+	return nil, ErrIndexNotFound
+}
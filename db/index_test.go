@@ -0,0 +1,308 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func indexEntries(t *testing.T, db *Database, info *catalog.IndexInfo) [][]byte {
+	t.Helper()
+
+	prefix := indexEntryPrefix(info.ID)
+	iterator, err := db.store.NewIterator(prefix, prefixEnd(prefix))
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+
+	var entries [][]byte
+	for {
+		key, _, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("next call failed: %v", err)
+		}
+		if key == nil {
+			return entries
+		}
+		entries = append(entries, bytes.Clone(key))
+	}
+}
+
+func TestSecondaryIndexMaintenance(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "city", Type: catalog.TypeVarChar},
+		{Name: "age", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("people", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	info, err := db.CreateIndex("people", "by_city_age", []string{"city", "age"})
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	rows := []tuple.Tuple{
+		{int64(1), "oslo", int64(40)},
+		{int64(2), "bergen", int64(25)},
+		{int64(3), "oslo", int64(-3)},
+		{int64(4), nil, int64(30)},
+	}
+	for _, row := range rows {
+		if err := db.Insert("people", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+	}
+	if err := db.Update("people", tuple.Tuple{int64(2), "oslo", int64(25)}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if err := db.Delete("people", int64(1)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	schema, err := db.catalog.GetTable("people")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+	expectedIDs := []int64{4, 3, 2}
+	entries := indexEntries(t, db, info)
+	if len(entries) != len(expectedIDs) {
+		t.Fatalf("expected %d index entries, got %d", len(expectedIDs), len(entries))
+	}
+	for i, entry := range entries {
+		_, pk, err := DecodeKey(entry[len(entry)-12:], catalog.TypeInt)
+		if err != nil {
+			t.Fatalf("failed to decode row key of entry: %v", err)
+		}
+		if pk != expectedIDs[i] {
+			t.Errorf("expected entry %d to point at row %d, got %v", i, expectedIDs[i], pk)
+		}
+	}
+
+	newColumns := append(slices.Clone(columns), catalog.Column{Name: "email", Type: catalog.TypeVarChar})
+	newSchema, err := db.RewriteTable("people", newColumns, func(row tuple.Tuple) (tuple.Tuple, error) {
+		return append(row, nil), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to rewrite table: %v", err)
+	}
+	entries = indexEntries(t, db, info)
+	if len(entries) != len(expectedIDs) {
+		t.Fatalf("expected %d index entries after rewrite, got %d", len(expectedIDs), len(entries))
+	}
+	for _, entry := range entries {
+		if tableID, _, _ := DecodeKey(entry[len(entry)-12:], catalog.TypeInt); tableID != newSchema.ID {
+			t.Errorf("expected entries to point at table %d after rewrite, got %d", newSchema.ID, tableID)
+		}
+	}
+	if schema.ID == newSchema.ID {
+		t.Fatalf("expected rewrite to move the table")
+	}
+
+	byAge, err := db.CreateIndex("people", "by_age", []string{"age"})
+	if err != nil {
+		t.Fatalf("failed to create index on a table with rows: %v", err)
+	}
+	if byAge.Building {
+		t.Errorf("expected the index to be built when CreateIndex returns")
+	}
+	if entries := indexEntries(t, db, byAge); len(entries) != len(expectedIDs) {
+		t.Errorf("expected %d backfilled entries, got %d", len(expectedIDs), len(entries))
+	}
+}
+
+func TestIndexValueOrder(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	groups := [][]tuple.Value{
+		{nil, int64(math.MinInt64), int64(-1), int64(0), int64(1), int64(math.MaxInt64)},
+		{nil, math.Inf(-1), float64(-2.5), float64(-0.5), float64(0), float64(1e-9), float64(3), math.Inf(1)},
+		{nil, "", "a", "a\x00", "a\x00b", "ab", "b"},
+		{nil, []byte{}, []byte{0}, []byte{0, 0}, []byte{1}, []byte{0xff}},
+		{nil, false, true},
+		{nil, base.Add(-time.Hour), base, base.Add(time.Nanosecond)},
+	}
+
+	for _, values := range groups {
+		for i := range values {
+			for j := range values {
+				a := appendIndexValue(nil, values[i])
+				b := appendIndexValue(nil, values[j])
+				a = append(a, 0xff)
+				b = append(b, 0)
+				want := tuple.Compare(values[i], values[j])
+				got := bytes.Compare(a, b)
+				if want != 0 && got != want {
+					t.Errorf("expected encoding of %#v and %#v to compare %d, got %d", values[i], values[j], want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "age", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("people", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	ages := make(map[int64]tuple.Value)
+	for i := range int64(100) {
+		if err := db.Insert("people", tuple.Tuple{i, i % 7}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		ages[i] = i % 7
+	}
+
+	build, err := db.BuildIndex("people", "by_age", []string{"age"}, catalog.IndexOptions{})
+	if err != nil {
+		t.Fatalf("failed to start index build: %v", err)
+	}
+	if done, err := build.Step(40); err != nil || done {
+		t.Fatalf("expected the first step to leave rows, done %v, err %v", done, err)
+	}
+
+	schema, err := db.catalog.GetTable("people")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+	if _, err := findIndex(schema, "by_age"); !errors.Is(err, ErrIndexBuilding) {
+		t.Errorf("expected %v while building, got %v", ErrIndexBuilding, err)
+	}
+
+	// Write behind and ahead of the scan position between the steps.
+	changes := []struct {
+		id  int64
+		age tuple.Value
+	}{
+		{5, int64(50)},
+		{80, int64(80)},
+		{10, nil},
+		{200, int64(3)},
+	}
+	for _, c := range changes {
+		if _, err := db.Upsert("people", tuple.Tuple{c.id, c.age}); err != nil {
+			t.Fatalf("failed to upsert: %v", err)
+		}
+		ages[c.id] = c.age
+	}
+	for _, id := range []int64{20, 90} {
+		if err := db.Delete("people", id); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		delete(ages, id)
+	}
+
+	for {
+		done, err := build.Step(40)
+		if err != nil {
+			t.Fatalf("failed to step: %v", err)
+		}
+		if done {
+			break
+		}
+	}
+	if build.Rows != 100 {
+		t.Errorf("expected 100 rows scanned, got %d", build.Rows)
+	}
+
+	schema, err = db.catalog.GetTable("people")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+	info, err := findIndex(schema, "by_age")
+	if err != nil {
+		t.Fatalf("expected the index to be usable after the build: %v", err)
+	}
+
+	var expected [][]byte
+	for id, age := range ages {
+		key, _ := EncodeKey(schema.ID, id)
+		entry, err := indexEntryKey(schema, info, tuple.Tuple{id, age}, key)
+		if err != nil {
+			t.Fatalf("failed to encode entry: %v", err)
+		}
+		expected = append(expected, entry)
+	}
+	slices.SortFunc(expected, bytes.Compare)
+	if entries := indexEntries(t, db, info); !slices.EqualFunc(entries, expected, bytes.Equal) {
+		t.Errorf("expected %d entries matching the rows, got %d", len(expected), len(entries))
+	}
+}
+
+func TestDropIndex(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "age", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("people", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range int64(500) {
+		if err := db.Insert("people", tuple.Tuple{i, i % 7}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	info, err := db.CreateIndex("people", "by_age", []string{"age"})
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if entries := indexEntries(t, db, info); len(entries) != 500 {
+		t.Fatalf("expected 500 entries before the drop, got %d", len(entries))
+	}
+
+	if err := db.DropIndex("people", "by_age"); err != nil {
+		t.Fatalf("failed to drop index: %v", err)
+	}
+	if err := db.Insert("people", tuple.Tuple{int64(500), int64(1)}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Update("people", tuple.Tuple{int64(1), int64(9)}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if entries := indexEntries(t, db, info); len(entries) != 0 {
+		t.Errorf("expected no entries after the drop, got %d", len(entries))
+	}
+
+	schema, err := db.catalog.GetTable("people")
+	if err != nil {
+		t.Fatalf("failed to get table: %v", err)
+	}
+	if _, err := findIndex(schema, "by_age"); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("expected %v after the drop, got %v", ErrIndexNotFound, err)
+	}
+
+	tests := []struct {
+		name  string
+		index string
+		err   error
+	}{
+		{"dropped index", "by_age", catalog.ErrIndexNotFound},
+		{"primary index", "PRIMARY", catalog.ErrDropPrimaryIndex},
+	}
+	for _, tt := range tests {
+		if err := db.DropIndex("people", tt.index); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, err := db.CreateIndex("people", "by_age", []string{"age"}); err != nil {
+		t.Errorf("expected the name to be free again, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/metrics"
+)
+
+// Info describes the contents of a database and how it uses its files.
+type Info struct {
+	Tables  int
+	Streams int
+	// Index is the shape and fill of the primary index tree, which shows
+	// how much of its file is wasted on half-empty pages. It is nil for a
+	// store on the LSM engine.
+	Index *index.Stats
+}
+
+// Info reads the catalog and the whole index tree, so it costs about as
+// much as a scan of the index.
+func (db *Database) Info() (*Info, error) {
+	tables, err := db.catalog.Tables()
+	if err != nil {
+		return nil, err
+	}
+	streams, err := db.catalog.Streams()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := db.store.IndexStats()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Info{
+		Tables:  len(tables),
+		Streams: len(streams),
+		Index:   stats,
+	}, nil
+}
+
+// Stats returns the counters of the work the database did since it was
+// opened, or since its Metrics registry was created. Unlike Info it is
+// cheap enough to poll.
+func (db *Database) Stats() metrics.Stats {
+	return db.metrics.Snapshot()
+}
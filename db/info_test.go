@@ -0,0 +1,75 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestInfo(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 2000 {
+		if err := db.Insert("users", tuple.Tuple{int64(i), "user"}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	info, err := db.Info()
+	if err != nil {
+		t.Fatalf("failed to get info: %v", err)
+	}
+	if info.Tables != 1 || info.Streams != 0 {
+		t.Errorf("expected 1 table and no streams, got %d and %d", info.Tables, info.Streams)
+	}
+	if info.Index == nil || info.Index.Height < 2 {
+		t.Fatalf("expected an index of at least two levels, got %+v", info.Index)
+	}
+	if info.Index.Levels[0] != 1 {
+		t.Errorf("expected a single root, got %d nodes", info.Index.Levels[0])
+	}
+}
+
+func TestStats(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	before := db.Stats()
+	for i := range 2000 {
+		if err := db.Insert("users", tuple.Tuple{int64(i), "user"}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	for i := range 2000 {
+		if _, _, err := db.Get("users", int64(i)); err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+	}
+
+	stats := db.Stats()
+	if written := stats.RecordsWritten - before.RecordsWritten; written < 2000 {
+		t.Errorf("expected at least a record per row, got %d", written)
+	}
+	if stats.RecordBytes <= before.RecordBytes || stats.Splits == 0 || stats.CacheHits == 0 {
+		t.Errorf("expected record bytes, splits and cache hits to be counted, got %+v", stats)
+	}
+	if ratio := stats.CacheHitRatio(); ratio <= 0 || ratio > 1 {
+		t.Errorf("expected a cache hit ratio in (0, 1], got %f", ratio)
+	}
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidJoin = errors.New("db: join columns have different types")
+
+// JoinOn names the columns whose values have to be equal for a row of the
+// left table and a row of the right table to be joined.
+type JoinOn struct {
+	Left, Right string
+}
+
+// Join returns the inner equi-join of two tables. Each result row holds
+// the columns of the left row followed by those of the right row, in the
+// key order of the left table. Rows with a NULL join column match nothing.
+//
+// The right rows matching a left row are found with a primary key lookup
+// when the right column is the primary key, through a B-tree index when
+// one is led by the right column, and by scanning the right table
+// otherwise.
+func (db *Database) Join(leftTable, rightTable string, on JoinOn) (Rows, error) {
+	left, err := db.Scan(leftTable, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	rightSchema, err := db.catalog.GetTable(rightTable)
+	if err != nil {
+		return nil, err
+	}
+
+	leftCol := columnIndex(left.schema, on.Left)
+	rightCol := columnIndex(rightSchema, on.Right)
+	if leftCol == -1 || rightCol == -1 {
+		return nil, ErrColumnNotFound
+	}
+	if left.schema.Columns[leftCol].Type != rightSchema.Columns[rightCol].Type {
+		return nil, ErrInvalidJoin
+	}
+
+	join := &joinRows{
+		left:    left,
+		leftCol: leftCol,
+	}
+
+	switch info := joinIndex(rightSchema, on.Right); {
+	case rightCol == rightSchema.PrimaryKeyIndex:
+		join.lookup = func(v tuple.Value) (Rows, error) {
+			return db.lookupKey(rightSchema, v)
+		}
+	case info != nil:
+		join.lookup = func(v tuple.Value) (Rows, error) {
+			return db.lookupIndex(rightSchema, info, v)
+		}
+	default:
+		join.lookup = func(v tuple.Value) (Rows, error) {
+			return db.Scan(rightTable, nil, nil, Where(on.Right, OpEqual, v))
+		}
+	}
+
+	return join, nil
+}
+
+// joinIndex returns a B-tree index led by the given column.
+func joinIndex(schema *catalog.Schema, column string) *catalog.IndexInfo {
+	for _, info := range schema.Indexes {
+		if info.ID != 0 && !info.Building && info.Type == catalog.IndexBTree && info.Columns[0] == column {
+			return info
+		}
+	}
+	return nil
+}
+
+func (db *Database) lookupKey(schema *catalog.Schema, primaryKey tuple.Value) (Rows, error) {
+	key, err := EncodeKey(schema.ID, primaryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	value, found, err := db.store.Get(key)
+	if err != nil || !found {
+		return &tupleRows{}, err
+	}
+	row, err := db.decodeRow(schema, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tupleRows{rows: []tuple.Tuple{row}}, nil
+}
+
+func (db *Database) lookupIndex(schema *catalog.Schema, info *catalog.IndexInfo, v tuple.Value) (Rows, error) {
+	prefix := appendIndexValue(indexEntryPrefix(info.ID), v)
+	return db.scanIndex(schema, info, prefix, prefixEnd(prefix), nil)
+}
+
+func (db *Database) scanIndex(schema *catalog.Schema, info *catalog.IndexInfo, startKey, endKey []byte, filter predicate) (*indexRows, error) {
+	iterator, err := db.store.NewIterator(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &indexRows{db: db, schema: schema, info: info, iterator: iterator, filter: filter}, nil
+}
+
+// indexRows reads the rows that a range of index entries points at, in
+// index order.
+type indexRows struct {
+	db       *Database
+	schema   *catalog.Schema
+	info     *catalog.IndexInfo
+	iterator *storage.Iterator
+	filter   predicate
+}
+
+func (r *indexRows) Next() (tuple.Tuple, error) {
+	for {
+		entry, _, err := r.iterator.Next()
+		if err != nil || entry == nil {
+			return nil, err
+		}
+
+		key := bytes.Clone(indexEntryRowKey(r.schema, r.info, entry))
+		value, found, err := r.db.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		row, err := r.db.decodeRow(r.schema, value)
+		if err != nil {
+			return nil, err
+		}
+		if r.filter == nil || r.filter(row) {
+			return row, nil
+		}
+	}
+}
+
+func (r *indexRows) Close() error {
+	return nil
+}
+
+type joinRows struct {
+	left    *Scanner
+	leftCol int
+	lookup  func(v tuple.Value) (Rows, error)
+
+	leftRow tuple.Tuple
+	matches Rows
+}
+
+func (j *joinRows) Next() (tuple.Tuple, error) {
+	for {
+		if j.matches != nil {
+			right, err := j.matches.Next()
+			if err != nil {
+				return nil, err
+			}
+			if right != nil {
+				return append(slices.Clone(j.leftRow), right...), nil
+			}
+			j.matches.Close()
+			j.matches = nil
+		}
+
+		row, err := j.left.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		if row[j.leftCol] == nil {
+			continue
+		}
+
+		matches, err := j.lookup(row[j.leftCol])
+		if err != nil {
+			return nil, err
+		}
+		j.leftRow, j.matches = row, matches
+	}
+}
+
+func (j *joinRows) Close() error {
+	if j.matches != nil {
+		j.matches.Close()
+		j.matches = nil
+	}
+	return j.left.Close()
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestJoin(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	customerColumns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+		{Name: "region", Type: catalog.TypeVarChar},
+	}
+	orderColumns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "customer_id", Type: catalog.TypeInt},
+		{Name: "region", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable("customers", customerColumns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateTable("orders", orderColumns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("orders", "by_customer", []string{"customer_id"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	var customers, orders []tuple.Tuple
+	for i := range 20 {
+		row := tuple.Tuple{int64(i), fmt.Sprintf("customer_%d", i), fmt.Sprintf("region_%d", i%3)}
+		if err := db.Insert("customers", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+		customers = append(customers, row)
+	}
+	for i := range 60 {
+		row := tuple.Tuple{int64(i), int64((i * 7) % 25), fmt.Sprintf("region_%d", i%4)}
+		if i%10 == 0 {
+			row[1] = nil
+		}
+		if err := db.Insert("orders", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+		orders = append(orders, row)
+	}
+
+	nestedLoop := func(left, right []tuple.Tuple, leftCol, rightCol int) []tuple.Tuple {
+		var joined []tuple.Tuple
+		for _, l := range left {
+			for _, r := range right {
+				if l[leftCol] != nil && tuple.Compare(l[leftCol], r[rightCol]) == 0 {
+					joined = append(joined, append(slices.Clone(l), r...))
+				}
+			}
+		}
+		return joined
+	}
+
+	tests := []struct {
+		name        string
+		left, right string
+		on          JoinOn
+		expected    []tuple.Tuple
+	}{
+		{"primary key lookup", "orders", "customers", JoinOn{Left: "customer_id", Right: "id"}, nestedLoop(orders, customers, 1, 0)},
+		{"index lookup", "customers", "orders", JoinOn{Left: "id", Right: "customer_id"}, nestedLoop(customers, orders, 0, 1)},
+		{"nested loop", "customers", "orders", JoinOn{Left: "region", Right: "region"}, nestedLoop(customers, orders, 2, 2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.Join(tt.left, tt.right, tt.on)
+			if err != nil {
+				t.Fatalf("failed to join: %v", err)
+			}
+
+			got := collectRows(t, rows)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %d rows %v, got %d rows %v", len(tt.expected), tt.expected, len(got), got)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name     string
+		on       JoinOn
+		expected error
+	}{
+		{"unknown column", JoinOn{Left: "customer_id", Right: "missing"}, ErrColumnNotFound},
+		{"type mismatch", JoinOn{Left: "customer_id", Right: "name"}, ErrInvalidJoin},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := db.Join("orders", "customers", tt.on); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
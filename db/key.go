@@ -0,0 +1,94 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidKey = errors.New("db: invalid table key")
+
+const signBit = 1 << 63
+
+// EncodeKey builds the storage key of a row: the table ID as 4 big-endian
+// bytes followed by the encoded primary key. Integers and floats are
+// written as 8 big-endian bytes (floats by their IEEE 754 bits) and strings
+// as their raw bytes. Timestamps are written as big-endian nanoseconds since
+// the epoch with the sign bit flipped, so that keys sort in time order even
+// across the epoch.
+func EncodeKey(tableID uint32, primaryKey tuple.Value) ([]byte, error) {
+	keyPrefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(keyPrefix, tableID)
+
+	var keySuffix []byte
+	switch pk := primaryKey.(type) {
+	case int64:
+		keySuffix = make([]byte, 8)
+		binary.BigEndian.PutUint64(keySuffix, uint64(pk))
+	case float64:
+		keySuffix = make([]byte, 8)
+		binary.BigEndian.PutUint64(keySuffix, math.Float64bits(pk))
+	case string:
+		keySuffix = []byte(pk)
+	case time.Time:
+		nanos, err := tuple.TimestampNanos(pk)
+		if err != nil {
+			return nil, err
+		}
+		keySuffix = make([]byte, 8)
+		binary.BigEndian.PutUint64(keySuffix, uint64(nanos)^signBit)
+	default:
+		return nil, ErrInvalidPrimaryKey
+	}
+
+	key := append(keyPrefix, keySuffix...)
+	return key, nil
+}
+
+// DecodeKey reverses EncodeKey. The primary key type is not stored in the
+// key, so it has to come from the table schema.
+func DecodeKey(key []byte, primaryKeyType catalog.DataType) (uint32, tuple.Value, error) {
+	if len(key) < 4 {
+		return 0, nil, ErrInvalidKey
+	}
+
+	tableID := binary.BigEndian.Uint32(key)
+	keySuffix := key[4:]
+
+	switch primaryKeyType {
+	case catalog.TypeInt:
+		if len(keySuffix) != 8 {
+			return 0, nil, ErrInvalidKey
+		}
+		return tableID, int64(binary.BigEndian.Uint64(keySuffix)), nil
+	case catalog.TypeFloat:
+		if len(keySuffix) != 8 {
+			return 0, nil, ErrInvalidKey
+		}
+		return tableID, math.Float64frombits(binary.BigEndian.Uint64(keySuffix)), nil
+	case catalog.TypeVarChar:
+		return tableID, string(keySuffix), nil
+	case catalog.TypeTimestamp:
+		if len(keySuffix) != 8 {
+			return 0, nil, ErrInvalidKey
+		}
+		nanos := int64(binary.BigEndian.Uint64(keySuffix) ^ signBit)
+		return tableID, time.Unix(0, nanos).UTC(), nil
+	default:
+		return 0, nil, ErrInvalidPrimaryKey
+	}
+}
+
+func tablePrefix(tableID uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, tableID)
+}
+
+// tableKeyRange returns the start and end keys covering every row of a
+// table.
+func tableKeyRange(tableID uint32) ([]byte, []byte) {
+	return tablePrefix(tableID), tablePrefix(tableID + 1)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestEncodeDecodeKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		tableID  uint32
+		pk       tuple.Value
+		pkType   catalog.DataType
+		expected []byte
+	}{
+		{
+			name:     "int key",
+			tableID:  1,
+			pk:       int64(258),
+			pkType:   catalog.TypeInt,
+			expected: []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 1, 2},
+		},
+		{
+			name:     "float key",
+			tableID:  2,
+			pk:       1.5,
+			pkType:   catalog.TypeFloat,
+			expected: []byte{0, 0, 0, 2, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:     "string key",
+			tableID:  3,
+			pk:       "abc",
+			pkType:   catalog.TypeVarChar,
+			expected: []byte{0, 0, 0, 3, 'a', 'b', 'c'},
+		},
+		{
+			name:     "timestamp key",
+			tableID:  4,
+			pk:       time.Unix(0, 1).UTC(),
+			pkType:   catalog.TypeTimestamp,
+			expected: []byte{0, 0, 0, 4, 0x80, 0, 0, 0, 0, 0, 0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := EncodeKey(tt.tableID, tt.pk)
+			if err != nil {
+				t.Fatalf("failed to encode key: %v", err)
+			}
+			if !bytes.Equal(key, tt.expected) {
+				t.Errorf("expected key %x, got %x", tt.expected, key)
+			}
+
+			tableID, pk, err := DecodeKey(key, tt.pkType)
+			if err != nil {
+				t.Fatalf("failed to decode key: %v", err)
+			}
+			if tableID != tt.tableID || pk != tt.pk {
+				t.Errorf("expected (%d, %v), got (%d, %v)", tt.tableID, tt.pk, tableID, pk)
+			}
+		})
+	}
+
+	t.Run("invalid keys", func(t *testing.T) {
+		if _, _, err := DecodeKey([]byte{0, 1}, catalog.TypeInt); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected error %v, got %v", ErrInvalidKey, err)
+		}
+		if _, _, err := DecodeKey([]byte{0, 0, 0, 1, 2}, catalog.TypeInt); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected error %v, got %v", ErrInvalidKey, err)
+		}
+		if _, err := EncodeKey(1, true); !errors.Is(err, ErrInvalidPrimaryKey) {
+			t.Errorf("expected error %v, got %v", ErrInvalidPrimaryKey, err)
+		}
+	})
+}
+
+func TestTimestampKeyOrder(t *testing.T) {
+	times := []time.Time{
+		time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Unix(0, 0).UTC(),
+		time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 0, 0, 0, 1, time.UTC),
+	}
+
+	var prev []byte
+	for _, ts := range times {
+		key, err := EncodeKey(1, ts)
+		if err != nil {
+			t.Fatalf("failed to encode %v: %v", ts, err)
+		}
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Errorf("key for %v does not sort after the previous one", ts)
+		}
+		prev = key
+	}
+}
+
+func TestTimestampKeyOutOfRange(t *testing.T) {
+	for _, ts := range []time.Time{{}, time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)} {
+		if _, err := EncodeKey(1, ts); !errors.Is(err, tuple.ErrInvalidTimestamp) {
+			t.Errorf("expected error %v for %v, got %v", tuple.ErrInvalidTimestamp, ts, err)
+		}
+	}
+}
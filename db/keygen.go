@@ -0,0 +1,133 @@
+package db
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidNodeID = errors.New("db: node ID out of range")
+
+const (
+	// MaxNodeID is the largest node ID a snowflake ID can hold.
+	MaxNodeID = 1<<snowflakeNodeBits - 1
+
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	ulidEncoding          = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+// snowflakeEpoch is the time snowflake timestamps count from, which leaves
+// their 41 bits about 69 years.
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// keyGenerator makes up primary keys. Both kinds of key stay increasing
+// when the clock goes back, by reusing the last timestamp.
+type keyGenerator struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	nodeID uint16
+
+	snowflakeMillis int64
+	sequence        uint16
+
+	ulidMillis int64
+	entropy    [10]byte
+}
+
+func newKeyGenerator(c clock.Clock) *keyGenerator {
+	return &keyGenerator{clock: c}
+}
+
+// SetNodeID sets the node ID that goes into snowflake IDs. Databases that
+// make up keys for the same table must have distinct node IDs.
+func (db *Database) SetNodeID(id int) error {
+	if id < 0 || id > MaxNodeID {
+		return ErrInvalidNodeID
+	}
+	db.keys.mu.Lock()
+	defer db.keys.mu.Unlock()
+	db.keys.nodeID = uint16(id)
+	return nil
+}
+
+func (g *keyGenerator) generate(generator catalog.KeyGenerator) (tuple.Value, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch generator {
+	case catalog.GeneratorULID:
+		return g.ulid()
+	case catalog.GeneratorSnowflake:
+		return g.snowflake(), nil
+	default:
+		return nil, ErrInvalidPrimaryKey
+	}
+}
+
+// snowflake returns a 41-bit millisecond timestamp, the node ID and a 12-bit
+// sequence number packed into an int64. When the sequence of a millisecond
+// runs out, the next ID waits for the next millisecond.
+func (g *keyGenerator) snowflake() int64 {
+	millis := max(g.clock.Now().Sub(snowflakeEpoch).Milliseconds(), g.snowflakeMillis)
+	if millis == g.snowflakeMillis {
+		g.sequence = (g.sequence + 1) & (1<<snowflakeSequenceBits - 1)
+		if g.sequence == 0 {
+			for millis <= g.snowflakeMillis {
+				g.clock.Sleep(time.Millisecond)
+				millis = g.clock.Now().Sub(snowflakeEpoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.snowflakeMillis = millis
+
+	return millis<<(snowflakeNodeBits+snowflakeSequenceBits) |
+		int64(g.nodeID)<<snowflakeSequenceBits |
+		int64(g.sequence)
+}
+
+// ulid returns a 48-bit millisecond timestamp followed by 80 random bits,
+// in Crockford base32. Within a millisecond the random bits of the first
+// ULID are incremented, so that later ones still sort after it.
+func (g *keyGenerator) ulid() (string, error) {
+	millis := max(g.clock.Now().UnixMilli(), g.ulidMillis)
+	if millis == g.ulidMillis {
+		i := len(g.entropy) - 1
+		for ; i >= 0; i-- {
+			g.entropy[i]++
+			if g.entropy[i] != 0 {
+				break
+			}
+		}
+		if i < 0 {
+			millis++
+		}
+	}
+	if millis != g.ulidMillis {
+		if _, err := rand.Read(g.entropy[:]); err != nil {
+			return "", err
+		}
+	}
+	g.ulidMillis = millis
+
+	hi := uint64(millis)<<16 | uint64(g.entropy[0])<<8 | uint64(g.entropy[1])
+	var lo uint64
+	for _, b := range g.entropy[2:] {
+		lo = lo<<8 | uint64(b)
+	}
+
+	var encoded [26]byte
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = ulidEncoding[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(encoded[:]), nil
+}
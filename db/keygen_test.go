@@ -0,0 +1,138 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestGeneratedKeys(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	if err := db.SetNodeID(MaxNodeID + 1); !errors.Is(err, ErrInvalidNodeID) {
+		t.Errorf("expected error %v, got %v", ErrInvalidNodeID, err)
+	}
+	if err := db.SetNodeID(7); err != nil {
+		t.Fatalf("failed to set node ID: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		keyType   catalog.DataType
+		generator catalog.KeyGenerator
+		// check validates a single generated key.
+		check func(t *testing.T, key tuple.Value)
+	}{
+		{
+			name:      "ulid",
+			keyType:   catalog.TypeVarChar,
+			generator: catalog.GeneratorULID,
+			check: func(t *testing.T, key tuple.Value) {
+				s := key.(string)
+				if len(s) != 26 || strings.Trim(s, ulidEncoding) != "" {
+					t.Errorf("expected a ULID, got %q", s)
+				}
+			},
+		},
+		{
+			name:      "snowflake",
+			keyType:   catalog.TypeInt,
+			generator: catalog.GeneratorSnowflake,
+			check: func(t *testing.T, key tuple.Value) {
+				if node := key.(int64) >> snowflakeSequenceBits & MaxNodeID; node != 7 {
+					t.Errorf("expected node ID 7 in %d, got %d", key, node)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := db.CreateTable(tt.name, []catalog.Column{
+				{Name: "id", Type: tt.keyType, IsPrimaryKey: true, IsNotNull: true, Generator: tt.generator},
+				{Name: "n", Type: catalog.TypeInt},
+			})
+			if err != nil {
+				t.Fatalf("failed to create table: %v", err)
+			}
+
+			var keys []tuple.Value
+			for i := range 100 {
+				key, err := db.InsertKey(tt.name, tuple.Tuple{nil, int64(i)})
+				if err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+				tt.check(t, key)
+				keys = append(keys, key)
+			}
+			if err := db.Insert(tt.name, tuple.Tuple{nil, int64(100)}); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+
+			scanner, err := db.Scan(tt.name, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("failed to scan: %v", err)
+			}
+			for i := 0; ; i++ {
+				row, err := scanner.Next()
+				if err != nil {
+					t.Fatalf("failed to scan: %v", err)
+				}
+				if row == nil {
+					if i != 101 {
+						t.Errorf("expected 101 rows, got %d", i)
+					}
+					break
+				}
+				if row[1] != int64(i) {
+					t.Fatalf("expected rows in insert order, got %v at %d", row, i)
+				}
+				if i < len(keys) && row[0] != keys[i] {
+					t.Errorf("expected key %v at %d, got %v", keys[i], i, row[0])
+				}
+			}
+		})
+	}
+}
+
+func TestKeyGeneratorSameMillisecond(t *testing.T) {
+	now := clock.NewVirtual(time.Now())
+	g := newKeyGenerator(now)
+
+	var lastULID string
+	var lastSnowflake int64
+	for i := range 5000 {
+		ulid, err := g.ulid()
+		if err != nil {
+			t.Fatalf("failed to generate ULID: %v", err)
+		}
+		if ulid <= lastULID {
+			t.Fatalf("expected ULID %d %q after %q", i, ulid, lastULID)
+		}
+		lastULID = ulid
+
+		// The sequence runs out after 4096 IDs; let the clock move on.
+		if i == 1<<snowflakeSequenceBits {
+			now.Advance(time.Millisecond)
+		}
+		snowflake := g.snowflake()
+		if snowflake <= lastSnowflake {
+			t.Fatalf("expected snowflake %d %d after %d", i, snowflake, lastSnowflake)
+		}
+		lastSnowflake = snowflake
+
+		// A clock going back for a while must not break the order.
+		switch i {
+		case 100:
+			now.Advance(-time.Second)
+		case 200:
+			now.Advance(time.Second)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"iter"
+	"time"
+)
+
+var ErrEmptyKey = errors.New("db: empty key")
+
+// kvPrefix starts the storage keys of the key-value namespace. Catalog
+// entries start with other words and rows with their table ID, so none of
+// them fall under it.
+var kvPrefix = []byte("kv:")
+
+// KV is a namespace of plain keys and values kept next to the tables of a
+// database, for key-value use such as serving a network protocol.
+type KV struct {
+	db *Database
+}
+
+// Pair is a key and its value in the key-value namespace.
+type Pair struct {
+	Key   []byte
+	Value []byte
+}
+
+// KV returns the key-value namespace of the database.
+func (db *Database) KV() *KV {
+	return &KV{db: db}
+}
+
+func kvKey(key []byte) []byte {
+	return append(bytes.Clone(kvPrefix), key...)
+}
+
+// kvEnd is the storage key a scan up to end stops at, which for a nil end
+// is past the last key of the namespace.
+func kvEnd(end []byte) []byte {
+	if end == nil {
+		return []byte("kv;")
+	}
+	return kvKey(end)
+}
+
+func (kv *KV) Get(key []byte) ([]byte, bool, error) {
+	if len(key) == 0 {
+		return nil, false, ErrEmptyKey
+	}
+	return kv.db.store.Get(kvKey(key))
+}
+
+func (kv *KV) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	return kv.db.store.Put(kvKey(key), value)
+}
+
+// PutWithTTL stores a value that expires ttl from now on the clock of the
+// database, after which the key reads as missing.
+func (kv *KV) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	return kv.db.store.PutWithTTL(kvKey(key), value, ttl)
+}
+
+// Expire makes key expire ttl from now, or deletes it right away if ttl is
+// not positive, and reports whether it was there.
+func (kv *KV) Expire(key []byte, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return kv.Delete(key)
+	}
+	value, found, err := kv.Get(key)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, kv.PutWithTTL(key, value, ttl)
+}
+
+// Delete removes key and reports whether it was there.
+func (kv *KV) Delete(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrEmptyKey
+	}
+	return kv.db.store.Delete(kvKey(key))
+}
+
+// Scan returns the pairs with keys in [start, end) in key order for a
+// range-over-func loop, from the first key if start is nil and to the last
+// if end is nil. An error is yielded with a zero pair and ends the loop.
+func (kv *KV) Scan(start, end []byte) iter.Seq2[Pair, error] {
+	return func(yield func(Pair, error) bool) {
+		it, err := kv.db.store.NewIterator(kvKey(start), kvEnd(end))
+		if err != nil {
+			yield(Pair{}, err)
+			return
+		}
+		for {
+			key, value, err := it.Next()
+			if err != nil {
+				yield(Pair{}, err)
+				return
+			}
+			if key == nil {
+				return
+			}
+			if !yield(Pair{Key: bytes.Clone(key[len(kvPrefix):]), Value: value}, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestKV(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	// Rows and the catalog stay out of the namespace.
+	columns := []catalog.Column{{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true}}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if err := db.Insert("users", tuple.Tuple{int64(1)}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+
+	kv := db.KV()
+	for i := range 5 {
+		if err := kv.Put(fmt.Appendf(nil, "key%d", i), fmt.Appendf(nil, "value%d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	if value, found, err := kv.Get([]byte("key3")); err != nil || !found || string(value) != "value3" {
+		t.Errorf("expected value3, got %q, found %v, err %v", value, found, err)
+	}
+	if deleted, err := kv.Delete([]byte("key3")); err != nil || !deleted {
+		t.Errorf("expected key3 to be deleted, got %v, err %v", deleted, err)
+	}
+	if deleted, err := kv.Delete([]byte("key3")); err != nil || deleted {
+		t.Errorf("expected nothing to delete, got %v, err %v", deleted, err)
+	}
+	if err := kv.Put(nil, []byte("value")); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("expected %v, got %v", ErrEmptyKey, err)
+	}
+
+	tests := []struct {
+		start, end string
+		keys       []string
+	}{
+		{"", "", []string{"key0", "key1", "key2", "key4"}},
+		{"key1", "key4", []string{"key1", "key2"}},
+		{"key2", "", []string{"key2", "key4"}},
+	}
+	for _, tt := range tests {
+		var start, end []byte
+		if tt.start != "" {
+			start = []byte(tt.start)
+		}
+		if tt.end != "" {
+			end = []byte(tt.end)
+		}
+		var keys []string
+		for pair, err := range kv.Scan(start, end) {
+			if err != nil {
+				t.Fatalf("failed to scan: %v", err)
+			}
+			keys = append(keys, string(pair.Key))
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("scan [%q, %q): expected %v, got %v", tt.start, tt.end, tt.keys, keys)
+		}
+	}
+}
+
+func TestKVExpire(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(1000, 0))
+	db, err := Open(t.TempDir(), Options{Clock: clk})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	defer db.Close()
+
+	kv := db.KV()
+	if err := kv.PutWithTTL([]byte("session"), []byte("token"), time.Minute); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	for _, key := range []string{"user", "gone"} {
+		if err := kv.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	if expired, err := kv.Expire([]byte("user"), 2*time.Minute); err != nil || !expired {
+		t.Errorf("expected user to expire, got %v, err %v", expired, err)
+	}
+	if expired, err := kv.Expire([]byte("missing"), time.Minute); err != nil || expired {
+		t.Errorf("expected nothing to expire, got %v, err %v", expired, err)
+	}
+	if expired, err := kv.Expire([]byte("gone"), 0); err != nil || !expired {
+		t.Errorf("expected gone to be deleted, got %v, err %v", expired, err)
+	}
+
+	present := func(key string) bool {
+		t.Helper()
+		_, found, err := kv.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", key, err)
+		}
+		return found
+	}
+	if !present("session") || !present("user") || present("gone") {
+		t.Errorf("expected session and user only")
+	}
+	clk.Advance(90 * time.Second)
+	if present("session") || !present("user") {
+		t.Errorf("expected session to expire before user")
+	}
+	clk.Advance(time.Minute)
+	for pair, err := range kv.Scan(nil, nil) {
+		t.Errorf("expected no keys after expiry, got %q, err %v", pair.Key, err)
+	}
+}
@@ -0,0 +1,488 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+type PlanKind int
+
+const (
+	PlanFullScan PlanKind = iota
+	PlanKeyLookup
+	PlanKeyRange
+	PlanIndexScan
+	PlanFilter
+	PlanSort
+	PlanLimit
+)
+
+var planKindNames = map[PlanKind]string{
+	PlanFullScan:  "FullScan",
+	PlanKeyLookup: "KeyLookup",
+	PlanKeyRange:  "KeyRange",
+	PlanIndexScan: "IndexScan",
+	PlanFilter:    "Filter",
+	PlanSort:      "Sort",
+	PlanLimit:     "Limit",
+}
+
+func (k PlanKind) String() string {
+	if name, ok := planKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("PlanKind(%d)", int(k))
+}
+
+// Plan is a step of a query plan. The rows of a step come from its Input,
+// except for the scans at the bottom of the tree, which read the table.
+type Plan struct {
+	Kind   PlanKind
+	Detail string
+	Input  *Plan
+}
+
+// String renders the plan one step per line, each step indented below
+// the one it feeds.
+func (p *Plan) String() string {
+	var sb strings.Builder
+	for depth, step := 0, p; step != nil; depth, step = depth+1, step.Input {
+		if depth > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(strings.Repeat("  ", depth))
+		sb.WriteString(step.Kind.String())
+		if step.Detail != "" {
+			sb.WriteString(" " + step.Detail)
+		}
+	}
+	return sb.String()
+}
+
+// bound is one end of a range of column values. A nil bound is open.
+type bound struct {
+	value     tuple.Value
+	inclusive bool
+}
+
+type valueRange struct {
+	low, high *bound
+}
+
+func (r *valueRange) isPoint() bool {
+	return r.low != nil && r.high != nil && r.low.inclusive && r.high.inclusive &&
+		tuple.Compare(r.low.value, r.high.value) == 0
+}
+
+// restrict narrows the range to the values that also satisfy op value.
+func (r *valueRange) restrict(op Operator, value tuple.Value) {
+	switch op {
+	case OpEqual:
+		r.restrictLow(&bound{value: value, inclusive: true})
+		r.restrictHigh(&bound{value: value, inclusive: true})
+	case OpGreater:
+		r.restrictLow(&bound{value: value})
+	case OpGreaterEqual:
+		r.restrictLow(&bound{value: value, inclusive: true})
+	case OpLess:
+		r.restrictHigh(&bound{value: value})
+	case OpLessEqual:
+		r.restrictHigh(&bound{value: value, inclusive: true})
+	}
+}
+
+func (r *valueRange) restrictLow(b *bound) {
+	if r.low == nil {
+		r.low = b
+		return
+	}
+	if c := tuple.Compare(b.value, r.low.value); c > 0 || c == 0 && !b.inclusive {
+		r.low = b
+	}
+}
+
+func (r *valueRange) restrictHigh(b *bound) {
+	if r.high == nil {
+		r.high = b
+		return
+	}
+	if c := tuple.Compare(b.value, r.high.value); c < 0 || c == 0 && !b.inclusive {
+		r.high = b
+	}
+}
+
+func (r *valueRange) describe(column string) string {
+	if r.isPoint() {
+		return fmt.Sprintf("%s = %s", column, formatValue(r.low.value))
+	}
+
+	var parts []string
+	if r.low != nil {
+		op := OpGreater
+		if r.low.inclusive {
+			op = OpGreaterEqual
+		}
+		parts = append(parts, comparison{column: column, op: op, value: r.low.value}.String())
+	}
+	if r.high != nil {
+		op := OpLess
+		if r.high.inclusive {
+			op = OpLessEqual
+		}
+		parts = append(parts, comparison{column: column, op: op, value: r.high.value}.String())
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// columnRanges collects the ranges that the top-level conjuncts of a
+// filter put on each column. A row matching the filter has its values in
+// these ranges; the converse need not hold, so the filter still has to be
+// checked.
+func columnRanges(schema *catalog.Schema, filter Filter) map[int]*valueRange {
+	ranges := make(map[int]*valueRange)
+
+	var collect func(f Filter)
+	collect = func(f Filter) {
+		switch f := f.(type) {
+		case junction:
+			if !f.any {
+				for _, sub := range f.filters {
+					collect(sub)
+				}
+			}
+		case comparison:
+			colIdx := columnIndex(schema, f.column)
+			if colIdx == -1 || !isTypeMatch(schema.Columns[colIdx].Type, f.value) {
+				return
+			}
+			switch f.op {
+			case OpEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+			default:
+				return
+			}
+			if ranges[colIdx] == nil {
+				ranges[colIdx] = &valueRange{}
+			}
+			ranges[colIdx].restrict(f.op, f.value)
+		}
+	}
+	if filter != nil {
+		collect(filter)
+	}
+
+	return ranges
+}
+
+// keyRange returns the keys bounding the rows whose primary key is in r.
+// Negative integers and floats do not sort by value in their key
+// encoding, so it reports false for ranges that can not be covered by a
+// single key range.
+func keyRange(schema *catalog.Schema, r *valueRange) ([]byte, []byte, bool) {
+	switch schema.Columns[schema.PrimaryKeyIndex].Type {
+	case catalog.TypeInt:
+		low, high := int64(math.MinInt64), int64(math.MaxInt64)
+		if r.low != nil {
+			low = r.low.value.(int64)
+		}
+		if r.high != nil {
+			high = r.high.value.(int64)
+		}
+		if low < 0 && high >= 0 {
+			return nil, nil, false
+		}
+	case catalog.TypeFloat:
+		if !r.isPoint() && (r.low == nil || math.Signbit(r.low.value.(float64))) {
+			return nil, nil, false
+		}
+	}
+
+	startKey, endKey := tableKeyRange(schema.ID)
+	if r.low != nil {
+		key, err := EncodeKey(schema.ID, r.low.value)
+		if err != nil {
+			return nil, nil, false
+		}
+		if !r.low.inclusive {
+			key = append(key, 0)
+		}
+		startKey = key
+	}
+	if r.high != nil {
+		key, err := EncodeKey(schema.ID, r.high.value)
+		if err != nil {
+			return nil, nil, false
+		}
+		if r.high.inclusive {
+			key = append(key, 0)
+		}
+		endKey = key
+	}
+
+	return startKey, endKey, true
+}
+
+// indexRange returns the entries of an index whose leading column is in r.
+// Index values sort by value, and NULLs, which no range includes, come
+// first.
+func indexRange(info *catalog.IndexInfo, r *valueRange) ([]byte, []byte) {
+	prefix := indexEntryPrefix(info.ID)
+
+	startKey := append(bytes.Clone(prefix), 1)
+	if r.low != nil {
+		startKey = appendIndexValue(bytes.Clone(prefix), r.low.value)
+		if !r.low.inclusive {
+			startKey = prefixEnd(startKey)
+		}
+	}
+	endKey := prefixEnd(prefix)
+	if r.high != nil {
+		endKey = appendIndexValue(bytes.Clone(prefix), r.high.value)
+		if r.high.inclusive {
+			endKey = prefixEnd(endKey)
+		}
+	}
+
+	return startKey, endKey
+}
+
+// accessPath is the way a query reads rows from its table.
+type accessPath struct {
+	kind             PlanKind
+	startKey, endKey []byte
+	index            *catalog.IndexInfo
+	detail           string
+}
+
+// chooseAccessPath picks how to read the rows a query may return. In order
+// of preference it looks up a single primary key, looks up equal values in
+// a secondary index, scans a primary key range, scans a secondary index
+// range, and falls back to scanning the whole table. Once the table has
+// been analyzed, the path estimated to be cheapest wins instead. An
+// explicit Start or End always reads a primary key range.
+func chooseAccessPath(schema *catalog.Schema, opts QueryOptions) (accessPath, error) {
+	ranges := columnRanges(schema, opts.Filter)
+	pkName := schema.Columns[schema.PrimaryKeyIndex].Name
+	pkRange := ranges[schema.PrimaryKeyIndex]
+
+	if opts.Start != nil || opts.End != nil {
+		startKey, endKey, err := scanKeyRange(schema, opts.Start, opts.End)
+		if err != nil {
+			return accessPath{}, err
+		}
+		bounds := &valueRange{}
+		if opts.Start != nil {
+			bounds.low = &bound{value: opts.Start, inclusive: true}
+		}
+		if opts.End != nil {
+			bounds.high = &bound{value: opts.End}
+		}
+
+		if pkRange != nil {
+			if start, end, ok := keyRange(schema, pkRange); ok {
+				startKey = maxKey(startKey, start)
+				endKey = minKey(endKey, end)
+			}
+		}
+		return accessPath{
+			kind:     PlanKeyRange,
+			startKey: startKey,
+			endKey:   endKey,
+			detail:   fmt.Sprintf("%s (%s)", schema.Name, bounds.describe(pkName)),
+		}, nil
+	}
+
+	// Choices are listed in order of preference. Without statistics the
+	// first one is taken; with them the one expected to read the fewest
+	// rows, counting a row read through an index as four, since it is
+	// looked up on its own rather than read in key order.
+	type choice struct {
+		path accessPath
+		rows float64
+		cost float64
+	}
+	var choices []choice
+	analyzed := schema.Stats != nil
+	add := func(path accessPath, colIdx int, r *valueRange, costFactor float64) {
+		rows := float64(0)
+		if schema.Stats != nil {
+			rows = float64(schema.Stats.RowCount)
+		}
+		if r != nil {
+			var ok bool
+			if rows, ok = estimateRows(schema, colIdx, r); !ok {
+				analyzed = false
+			}
+		}
+		choices = append(choices, choice{path: path, rows: rows, cost: rows * costFactor})
+	}
+
+	if pkRange != nil && pkRange.isPoint() {
+		startKey, endKey, _ := keyRange(schema, pkRange)
+		add(accessPath{
+			kind:     PlanKeyLookup,
+			startKey: startKey,
+			endKey:   endKey,
+			detail:   fmt.Sprintf("%s (%s)", schema.Name, pkRange.describe(pkName)),
+		}, schema.PrimaryKeyIndex, pkRange, 1)
+	}
+
+	var indexed []*catalog.IndexInfo
+	for _, info := range schema.Indexes {
+		if info.ID != 0 && !info.Building && info.Type == catalog.IndexBTree && ranges[columnIndex(schema, info.Columns[0])] != nil {
+			indexed = append(indexed, info)
+		}
+	}
+	addIndex := func(info *catalog.IndexInfo) {
+		colIdx := columnIndex(schema, info.Columns[0])
+		r := ranges[colIdx]
+		startKey, endKey := indexRange(info, r)
+		add(accessPath{
+			kind:     PlanIndexScan,
+			startKey: startKey,
+			endKey:   endKey,
+			index:    info,
+			detail:   fmt.Sprintf("%s using %s (%s)", schema.Name, info.Name, r.describe(info.Columns[0])),
+		}, colIdx, r, 4)
+	}
+
+	for _, info := range indexed {
+		if ranges[columnIndex(schema, info.Columns[0])].isPoint() {
+			addIndex(info)
+		}
+	}
+	if pkRange != nil && !pkRange.isPoint() {
+		if startKey, endKey, ok := keyRange(schema, pkRange); ok {
+			add(accessPath{
+				kind:     PlanKeyRange,
+				startKey: startKey,
+				endKey:   endKey,
+				detail:   fmt.Sprintf("%s (%s)", schema.Name, pkRange.describe(pkName)),
+			}, schema.PrimaryKeyIndex, pkRange, 1)
+		}
+	}
+	for _, info := range indexed {
+		if !ranges[columnIndex(schema, info.Columns[0])].isPoint() {
+			addIndex(info)
+		}
+	}
+
+	startKey, endKey, err := scanKeyRange(schema, nil, nil)
+	if err != nil {
+		return accessPath{}, err
+	}
+	add(accessPath{
+		kind:     PlanFullScan,
+		startKey: startKey,
+		endKey:   endKey,
+		detail:   schema.Name,
+	}, 0, nil, 1)
+
+	best := choices[0]
+	if analyzed {
+		for _, c := range choices[1:] {
+			if c.cost < best.cost {
+				best = c
+			}
+		}
+		best.path.detail += fmt.Sprintf(" ~%d rows", int64(math.Round(best.rows)))
+	}
+	return best.path, nil
+}
+
+func maxKey(a, b []byte) []byte {
+	if bytes.Compare(a, b) >= 0 {
+		return a
+	}
+	return b
+}
+
+func minKey(a, b []byte) []byte {
+	if bytes.Compare(a, b) <= 0 {
+		return a
+	}
+	return b
+}
+
+// queryPlan is a query checked against its table and ready to run.
+type queryPlan struct {
+	schema  *catalog.Schema
+	opts    QueryOptions
+	filter  predicate
+	path    accessPath
+	orderBy []OrderBy
+	sorted  bool
+}
+
+func (db *Database) planQuery(tableName string, opts QueryOptions) (*queryPlan, error) {
+	if opts.Offset < 0 || opts.Limit < 0 || opts.SortBuffer < 0 {
+		return nil, ErrInvalidQuery
+	}
+
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &queryPlan{schema: schema, opts: opts, orderBy: opts.OrderBy}
+	if opts.Filter != nil {
+		if plan.filter, err = opts.Filter.bind(schema); err != nil {
+			return nil, err
+		}
+	}
+	if plan.path, err = chooseAccessPath(schema, opts); err != nil {
+		return nil, err
+	}
+
+	// Rows that tie on the requested order come in primary key order, which
+	// an index scan has to restore.
+	if plan.path.kind == PlanIndexScan {
+		pkName := schema.Columns[schema.PrimaryKeyIndex].Name
+		if !slices.ContainsFunc(plan.orderBy, func(o OrderBy) bool { return o.Column == pkName }) {
+			plan.orderBy = append(slices.Clone(plan.orderBy), OrderBy{Column: pkName})
+		}
+	}
+	plan.sorted = plan.path.kind == PlanIndexScan || !isKeyOrder(schema, plan.orderBy)
+	if plan.sorted {
+		if _, err := orderComparator(schema, plan.orderBy); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+func (p *queryPlan) explain() *Plan {
+	plan := &Plan{Kind: p.path.kind, Detail: p.path.detail}
+	if p.opts.Filter != nil {
+		plan = &Plan{Kind: PlanFilter, Detail: fmt.Sprint(p.opts.Filter), Input: plan}
+	}
+	if p.sorted {
+		columns := make([]string, len(p.orderBy))
+		for i, o := range p.orderBy {
+			columns[i] = o.Column
+			if o.Desc {
+				columns[i] += " DESC"
+			}
+		}
+		plan = &Plan{Kind: PlanSort, Detail: strings.Join(columns, ", "), Input: plan}
+	}
+	if p.opts.Offset > 0 || p.opts.Limit > 0 {
+		plan = &Plan{Kind: PlanLimit, Detail: fmt.Sprintf("offset %d limit %d", p.opts.Offset, p.opts.Limit), Input: plan}
+	}
+	return plan
+}
+
+// Explain returns the plan Query would run for the same arguments.
+func (db *Database) Explain(tableName string, opts QueryOptions) (*Plan, error) {
+	plan, err := db.planQuery(tableName, opts)
+	if err != nil {
+		return nil, err
+	}
+	return plan.explain(), nil
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestQueryPlan(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+		{Name: "age", Type: catalog.TypeInt},
+	}
+	schema, err := db.CreateTable("people", columns)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("people", "by_age_name", []string{"age", "name"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	var rows []tuple.Tuple
+	for i := -50; i < 150; i++ {
+		row := tuple.Tuple{int64(i), fmt.Sprintf("name_%d", (i+50)%17), int64((i * 37) % 60)}
+		if i%13 == 0 {
+			row[2] = nil
+		}
+		if err := db.Insert("people", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+		rows = append(rows, row)
+	}
+
+	tests := []struct {
+		name string
+		opts QueryOptions
+		plan string
+	}{
+		{
+			name: "no filter",
+			opts: QueryOptions{},
+			plan: "FullScan people",
+		},
+		{
+			name: "primary key lookup",
+			opts: QueryOptions{Filter: And(Where("id", OpEqual, int64(-7)), Where("age", OpEqual, int64(1)))},
+			plan: "Filter (id = -7 AND age = 1)\n  KeyLookup people (id = -7)",
+		},
+		{
+			name: "index lookup wins over key range",
+			opts: QueryOptions{Filter: And(Where("id", OpGreater, int64(10)), Where("age", OpEqual, int64(4)))},
+			plan: "Sort id\n  Filter (id > 10 AND age = 4)\n    IndexScan people using by_age_name (age = 4)",
+		},
+		{
+			name: "key range",
+			opts: QueryOptions{Filter: And(Where("id", OpGreater, int64(10)), Where("id", OpLessEqual, int64(40)), Where("id", OpLess, int64(90)))},
+			plan: "Filter (id > 10 AND id <= 40 AND id < 90)\n  KeyRange people (id > 10 AND id <= 40)",
+		},
+		{
+			name: "negative key range",
+			opts: QueryOptions{Filter: And(Where("id", OpGreaterEqual, int64(-20)), Where("id", OpLess, int64(-3)))},
+			plan: "Filter (id >= -20 AND id < -3)\n  KeyRange people (id >= -20 AND id < -3)",
+		},
+		{
+			name: "key range across zero falls back to scan",
+			opts: QueryOptions{Filter: Where("id", OpLess, int64(5))},
+			plan: "Filter id < 5\n  FullScan people",
+		},
+		{
+			name: "index range with order and limit",
+			opts: QueryOptions{
+				Filter:  And(Where("age", OpGreater, int64(10)), Where("age", OpLess, int64(20))),
+				OrderBy: []OrderBy{{Column: "name", Desc: true}},
+				Limit:   5,
+			},
+			plan: "Limit offset 0 limit 5\n  Sort name DESC, id\n    Filter (age > 10 AND age < 20)\n      IndexScan people using by_age_name (age > 10 AND age < 20)",
+		},
+		{
+			name: "or is not used for access",
+			opts: QueryOptions{Filter: Or(Where("age", OpEqual, int64(3)), Where("id", OpEqual, int64(3)))},
+			plan: "Filter (age = 3 OR id = 3)\n  FullScan people",
+		},
+		{
+			name: "explicit bounds narrowed by filter",
+			opts: QueryOptions{Start: int64(20), End: int64(100), Filter: Where("id", OpLessEqual, int64(50))},
+			plan: "Filter id <= 50\n  KeyRange people (id >= 20 AND id < 100)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := db.Explain("people", tt.opts)
+			if err != nil {
+				t.Fatalf("failed to explain: %v", err)
+			}
+			if plan.String() != tt.plan {
+				t.Errorf("expected plan\n%s\ngot\n%s", tt.plan, plan)
+			}
+
+			match := func(tuple.Tuple) bool { return true }
+			if tt.opts.Filter != nil {
+				if match, err = tt.opts.Filter.bind(schema); err != nil {
+					t.Fatalf("failed to bind filter: %v", err)
+				}
+			}
+			var expected []tuple.Tuple
+			for _, row := range rows {
+				if tt.opts.Start != nil && row[0].(int64) < tt.opts.Start.(int64) ||
+					tt.opts.End != nil && row[0].(int64) >= tt.opts.End.(int64) {
+					continue
+				}
+				if match(row) {
+					expected = append(expected, row)
+				}
+			}
+
+			plain, err := db.Query("people", QueryOptions{Filter: Where("id", OpIsNotNull, nil), OrderBy: tt.opts.OrderBy})
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+			order := make(map[int64]int)
+			for i, row := range collectRows(t, plain) {
+				order[row[0].(int64)] = i
+			}
+			sortByQueryOrder(expected, order)
+			if tt.opts.Limit > 0 && len(expected) > tt.opts.Limit {
+				expected = expected[:tt.opts.Limit]
+			}
+
+			result, err := db.Query("people", tt.opts)
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+			if got := collectRows(t, result); !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %d rows %v, got %d rows %v", len(expected), expected, len(got), got)
+			}
+		})
+	}
+}
+
+// sortByQueryOrder orders rows by the position of their primary key in an
+// unfiltered query with the same ordering.
+func sortByQueryOrder(rows []tuple.Tuple, order map[int64]int) {
+	for i := 1; i < len(rows); i++ {
+		for j := i; j > 0 && order[rows[j][0].(int64)] < order[rows[j-1][0].(int64)]; j-- {
+			rows[j], rows[j-1] = rows[j-1], rows[j]
+		}
+	}
+}
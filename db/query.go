@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"iter"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidQuery = errors.New("db: invalid query options")
+
+// Rows is a stream of rows produced by a query. Close releases whatever the
+// stream holds, such as spilled sort runs, and is safe to call before the
+// stream is exhausted.
+type Rows interface {
+	Next() (tuple.Tuple, error)
+	Close() error
+}
+
+type OrderBy struct {
+	Column string
+	Desc   bool
+}
+
+type QueryOptions struct {
+	// Start and End bound the primary key range as in Scan.
+	Start, End tuple.Value
+	Filter     Filter
+	OrderBy    []OrderBy
+	// Offset rows are skipped and at most Limit rows returned after them.
+	// A zero Limit returns all remaining rows.
+	Offset, Limit int
+	// SortBuffer is the number of rows sorted in memory before a run is
+	// spilled to a temporary file. Zero uses defaultSortBuffer.
+	SortBuffer int
+}
+
+const defaultSortBuffer = 10000
+
+// Query scans a table like Scan and applies ordering and pagination on top.
+// The planner reads the rows through the primary key or a secondary index
+// when the filter allows it; see Explain. Rows are sorted with a bounded
+// amount of memory, so large tables can be ordered by any column without
+// loading them whole.
+func (db *Database) Query(tableName string, opts QueryOptions) (Rows, error) {
+	return db.QueryContext(context.Background(), tableName, opts)
+}
+
+// QueryContext is Query with a context that cancels it. Once ctx is done,
+// Next returns ctx.Err(), also while a sort is still reading its input.
+func (db *Database) QueryContext(ctx context.Context, tableName string, opts QueryOptions) (Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	plan, err := db.planQuery(tableName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows Rows
+	if plan.path.kind == PlanIndexScan {
+		rows, err = db.scanIndex(plan.schema, plan.path.index, plan.path.startKey, plan.path.endKey, plan.filter)
+	} else {
+		var scanner *Scanner
+		scanner, err = db.scanKeys(plan.schema, plan.path.startKey, plan.path.endKey, plan.filter)
+		if err == nil && plan.path.kind != PlanKeyLookup && opts.Filter != nil {
+			scanner.done = db.trackScan(plan.schema, opts.Filter)
+		}
+		rows = scanner
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Done() != nil {
+		rows = &contextRows{ctx: ctx, input: rows}
+	}
+
+	if plan.sorted {
+		compare, err := orderComparator(plan.schema, plan.orderBy)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		bufferSize := opts.SortBuffer
+		if bufferSize == 0 {
+			bufferSize = defaultSortBuffer
+		}
+		keep := 0
+		if opts.Limit > 0 {
+			keep = opts.Offset + opts.Limit
+		}
+
+		rows = &sortRows{
+			input:      rows,
+			schema:     plan.schema,
+			compare:    compare,
+			bufferSize: bufferSize,
+			keep:       keep,
+		}
+		if ctx.Done() != nil {
+			rows = &contextRows{ctx: ctx, input: rows}
+		}
+	}
+
+	if opts.Offset > 0 || opts.Limit > 0 {
+		rows = &limitRows{input: rows, offset: opts.Offset, limit: opts.Limit}
+	}
+
+	return rows, nil
+}
+
+// Rows runs Query and returns its rows for a range-over-func loop. An
+// error is yielded with a nil row and ends the loop. The rows are closed
+// when the loop ends, also when it breaks early.
+func (db *Database) Rows(tableName string, opts QueryOptions) iter.Seq2[tuple.Tuple, error] {
+	return func(yield func(tuple.Tuple, error) bool) {
+		rows, err := db.Query(tableName, opts)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for {
+			row, err := rows.Next()
+			if err != nil {
+				rows.Close()
+				yield(nil, err)
+				return
+			}
+			if row == nil {
+				break
+			}
+			if !yield(row, nil) {
+				rows.Close()
+				return
+			}
+		}
+
+		if err := rows.Close(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// isKeyOrder reports whether rows already come out of a scan in the
+// requested order.
+func isKeyOrder(schema *catalog.Schema, orderBy []OrderBy) bool {
+	if len(orderBy) == 0 {
+		return true
+	}
+	return len(orderBy) == 1 && !orderBy[0].Desc &&
+		orderBy[0].Column == schema.Columns[schema.PrimaryKeyIndex].Name
+}
+
+func orderComparator(schema *catalog.Schema, orderBy []OrderBy) (func(a, b tuple.Tuple) int, error) {
+	columns := make([]int, len(orderBy))
+	for i, o := range orderBy {
+		columns[i] = columnIndex(schema, o.Column)
+		if columns[i] == -1 {
+			return nil, ErrColumnNotFound
+		}
+	}
+
+	return func(a, b tuple.Tuple) int {
+		for i, col := range columns {
+			if c := tuple.Compare(a[col], b[col]); c != 0 {
+				if orderBy[i].Desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	}, nil
+}
+
+type limitRows struct {
+	input  Rows
+	offset int
+	limit  int
+	read   int
+}
+
+func (l *limitRows) Next() (tuple.Tuple, error) {
+	for l.offset > 0 {
+		row, err := l.input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		l.offset--
+	}
+
+	if l.limit > 0 && l.read >= l.limit {
+		return nil, nil
+	}
+
+	row, err := l.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	l.read++
+
+	return row, nil
+}
+
+func (l *limitRows) Close() error {
+	return l.input.Close()
+}
+
+// contextRows stops a stream of rows once its context is done.
+type contextRows struct {
+	ctx   context.Context
+	input Rows
+}
+
+func (c *contextRows) Next() (tuple.Tuple, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.input.Next()
+}
+
+func (c *contextRows) Close() error {
+	return c.input.Close()
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func collectRows(t *testing.T, rows Rows) []tuple.Tuple {
+	t.Helper()
+	defer rows.Close()
+
+	var collected []tuple.Tuple
+	for {
+		row, err := rows.Next()
+		if err != nil {
+			t.Fatalf("error while reading rows: %v", err)
+		}
+		if row == nil {
+			return collected
+		}
+		collected = append(collected, row)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "team", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "score", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("players", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	var rows []tuple.Tuple
+	for i := range 500 {
+		row := tuple.Tuple{int64(i), fmt.Sprintf("team_%d", i%7), int64((i * 7919) % 1000)}
+		if i%50 == 0 {
+			row[2] = nil
+		}
+		if err := db.Insert("players", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+		rows = append(rows, row)
+	}
+
+	byScoreDesc := slices.Clone(rows)
+	slices.SortStableFunc(byScoreDesc, func(a, b tuple.Tuple) int {
+		return -tuple.Compare(a[2], b[2])
+	})
+	byTeamThenScore := slices.Clone(rows)
+	slices.SortStableFunc(byTeamThenScore, func(a, b tuple.Tuple) int {
+		if c := tuple.Compare(a[1], b[1]); c != 0 {
+			return c
+		}
+		return tuple.Compare(a[2], b[2])
+	})
+
+	tests := []struct {
+		name     string
+		opts     QueryOptions
+		expected []tuple.Tuple
+	}{
+		{
+			name:     "key order with offset and limit",
+			opts:     QueryOptions{Offset: 10, Limit: 5},
+			expected: rows[10:15],
+		},
+		{
+			name:     "in memory sort",
+			opts:     QueryOptions{OrderBy: []OrderBy{{Column: "score", Desc: true}}},
+			expected: byScoreDesc,
+		},
+		{
+			name: "external sort",
+			opts: QueryOptions{
+				OrderBy:    []OrderBy{{Column: "team"}, {Column: "score"}},
+				SortBuffer: 64,
+			},
+			expected: byTeamThenScore,
+		},
+		{
+			name: "external sort with pagination",
+			opts: QueryOptions{
+				OrderBy:    []OrderBy{{Column: "team"}, {Column: "score"}},
+				Offset:     95,
+				Limit:      30,
+				SortBuffer: 64,
+			},
+			expected: byTeamThenScore[95:125],
+		},
+		{
+			name: "top rows kept without spilling",
+			opts: QueryOptions{
+				OrderBy:    []OrderBy{{Column: "score", Desc: true}},
+				Limit:      10,
+				SortBuffer: 64,
+			},
+			expected: byScoreDesc[:10],
+		},
+		{
+			name: "descending primary key with filter",
+			opts: QueryOptions{
+				Filter:  Where("team", OpEqual, "team_3"),
+				OrderBy: []OrderBy{{Column: "id", Desc: true}},
+				Limit:   3,
+			},
+			expected: []tuple.Tuple{rows[493], rows[486], rows[479]},
+		},
+		{
+			name:     "offset past the end",
+			opts:     QueryOptions{Start: int64(490), Offset: 20},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := db.Query("players", tt.opts)
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+
+			got := collectRows(t, result)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %d rows %v, got %d rows %v", len(tt.expected), tt.expected, len(got), got)
+			}
+		})
+	}
+
+	t.Run("spilled runs are removed on close", func(t *testing.T) {
+		tmp := t.TempDir()
+		t.Setenv("TMPDIR", tmp)
+
+		result, err := db.Query("players", QueryOptions{
+			OrderBy:    []OrderBy{{Column: "score"}},
+			SortBuffer: 100,
+		})
+		if err != nil {
+			t.Fatalf("failed to query: %v", err)
+		}
+		if _, err := result.Next(); err != nil {
+			t.Fatalf("failed to read first row: %v", err)
+		}
+
+		spilled, _ := filepath.Glob(filepath.Join(tmp, "toydb-sort-*"))
+		if len(spilled) != 5 {
+			t.Errorf("expected 5 spilled runs, got %d", len(spilled))
+		}
+
+		if err := result.Close(); err != nil {
+			t.Fatalf("failed to close rows: %v", err)
+		}
+		entries, err := os.ReadDir(tmp)
+		if err != nil {
+			t.Fatalf("failed to read temp dir: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected spilled runs to be removed, found %d files", len(entries))
+		}
+	})
+
+	t.Run("range over rows", func(t *testing.T) {
+		var got []tuple.Tuple
+		for row, err := range db.Rows("players", QueryOptions{Start: int64(10), End: int64(20)}) {
+			if err != nil {
+				t.Fatalf("error while ranging over rows: %v", err)
+			}
+			got = append(got, row)
+		}
+		if !reflect.DeepEqual(got, rows[10:20]) {
+			t.Errorf("expected rows %v, got %v", rows[10:20], got)
+		}
+
+		for _, err := range db.Rows("players", QueryOptions{Limit: -1}) {
+			if !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("expected error %v, got %v", ErrInvalidQuery, err)
+			}
+		}
+	})
+
+	t.Run("breaking out of rows removes spilled runs", func(t *testing.T) {
+		tmp := t.TempDir()
+		t.Setenv("TMPDIR", tmp)
+
+		seen := 0
+		for _, err := range db.Rows("players", QueryOptions{OrderBy: []OrderBy{{Column: "score"}}, SortBuffer: 100}) {
+			if err != nil {
+				t.Fatalf("error while ranging over rows: %v", err)
+			}
+			if seen++; seen == 3 {
+				break
+			}
+		}
+
+		entries, err := os.ReadDir(tmp)
+		if err != nil {
+			t.Fatalf("failed to read temp dir: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("expected spilled runs to be removed, found %d files", len(entries))
+		}
+	})
+
+	invalid := []struct {
+		name     string
+		opts     QueryOptions
+		expected error
+	}{
+		{"negative limit", QueryOptions{Limit: -1}, ErrInvalidQuery},
+		{"unknown order column", QueryOptions{OrderBy: []OrderBy{{Column: "rank"}}}, ErrColumnNotFound},
+	}
+
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := db.Query("players", tt.opts); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "value", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("numbers", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 100 {
+		if err := db.Insert("numbers", tuple.Tuple{int64(i), int64(i % 7)}); err != nil {
+			t.Fatalf("failed to insert row: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts QueryOptions
+	}{
+		{"scan", QueryOptions{}},
+		{"sort", QueryOptions{OrderBy: []OrderBy{{Column: "value"}}, SortBuffer: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			rows, err := db.QueryContext(ctx, "numbers", tt.opts)
+			if err != nil {
+				t.Fatalf("failed to query: %v", err)
+			}
+			defer rows.Close()
+			for range 3 {
+				if row, err := rows.Next(); err != nil || row == nil {
+					t.Fatalf("expected a row, got %v, %v", row, err)
+				}
+			}
+
+			cancel()
+			if _, err := rows.Next(); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected %v after cancel, got %v", context.Canceled, err)
+			}
+			if _, err := db.QueryContext(ctx, "numbers", tt.opts); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected %v for a canceled context, got %v", context.Canceled, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package db
+
+// A database opened with Options.Follower copies the data log of a leader
+// database. See storage.Store.ApplyLog.
+
+// LogEnd returns the position in the data log where the next write goes,
+// from which a follower asks its leader for more.
+func (db *Database) LogEnd() uint64 {
+	return db.store.LogEnd()
+}
+
+// ReadLog returns about maxBytes of the data log of a leader from position
+// from on, for a follower to apply, and the position they start at.
+func (db *Database) ReadLog(from uint64, maxBytes int) (uint64, []byte, error) {
+	return db.store.ReadLog(from, maxBytes)
+}
+
+// ApplyLog applies what ReadLog of the leader returned to a follower. Stream
+// readers of the follower wake up to look for new rows.
+func (db *Database) ApplyLog(pos uint64, data []byte) error {
+	err := db.store.ApplyLog(pos, data)
+	db.forgetStreams()
+	return err
+}
+
+// Promote makes a follower a database of its own, which takes writes. Its
+// leader must have stopped writing.
+func (db *Database) Promote() error {
+	if err := db.store.Promote(); err != nil {
+		return err
+	}
+	db.forgetStreams()
+	return db.catalog.Reload()
+}
+
+// forgetStreams drops the state of the streams, which the leader changed
+// behind its back, and wakes their readers.
+func (db *Database) forgetStreams() {
+	db.streamMu.Lock()
+	defer db.streamMu.Unlock()
+	for name, s := range db.streams {
+		close(s.appended)
+		delete(db.streams, name)
+	}
+}
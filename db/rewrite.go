@@ -0,0 +1,314 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// ConvertFunc maps a row of the old schema to a row of the new one during
+// a table rewrite.
+type ConvertFunc func(row tuple.Tuple) (tuple.Tuple, error)
+
+// RewriteTable changes the columns of a table in ways the stored rows can
+// not absorb lazily, such as changing a column type. Every row is passed
+// through convert and written under a new table ID, then the catalog entry
+// is swapped to the new schema in one write and the old key range is
+// deleted. The table keeps serving the old rows until the swap. Writes to
+// the table while its rows are copied are read back from the data log and
+// applied to the copy before the swap, so none are lost. The swap is
+// written in one batch with the index and foreign key entries it moves
+// from the old rows to the new. If a row fails to convert, or the swap
+// fails, the copied rows are removed and the table is left as it was.
+func (db *Database) RewriteTable(tableName string, columns []catalog.Column, convert ConvertFunc) (*catalog.Schema, error) {
+	oldSchema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	newSchema, err := db.catalog.PrepareRewrite(tableName, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.loadSketches(newSchema); err != nil {
+		return nil, err
+	}
+
+	from := db.store.LogEnd()
+	copied, err := db.copyRows(oldSchema, newSchema, convert)
+	if err == nil {
+		err = db.catchUp(oldSchema, newSchema, convert, from, &copied)
+	}
+	if err == nil {
+		err = db.checkIncomingReferences(oldSchema, newSchema)
+	}
+	if err != nil {
+		db.removeCopies(newSchema, copied)
+		return nil, err
+	}
+
+	err = db.atomically(true, func() error {
+		if err := db.forEachRow(oldSchema, func(key []byte, row tuple.Tuple) error {
+			return db.updateDerived(oldSchema, row, nil, key)
+		}); err != nil {
+			return err
+		}
+		if err := db.catalog.SwapTable(newSchema); err != nil {
+			return err
+		}
+		if err := db.forEachRow(newSchema, func(key []byte, row tuple.Tuple) error {
+			return db.updateDerived(newSchema, nil, row, key)
+		}); err != nil {
+			return err
+		}
+		return db.moveIncomingReferences(oldSchema, newSchema)
+	})
+	if err != nil {
+		db.removeCopies(newSchema, copied)
+		return nil, err
+	}
+
+	if err := db.forEachRow(oldSchema, func(key []byte, _ tuple.Tuple) error {
+		_, err := db.store.Delete(key)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := db.dropSketches(oldSchema); err != nil {
+		return nil, err
+	}
+
+	return newSchema, nil
+}
+
+// removeCopies undoes a rewrite that failed before the swap, deleting the
+// rows copied to the new table.
+func (db *Database) removeCopies(newSchema *catalog.Schema, copied [][]byte) {
+	for _, key := range copied {
+		db.store.Delete(key)
+	}
+	db.dropSketches(newSchema)
+}
+
+func (db *Database) forEachRow(schema *catalog.Schema, fn func(key []byte, row tuple.Tuple) error) error {
+	startKey, endKey := tableKeyRange(schema.ID)
+	iterator, err := db.store.NewIterator(startKey, endKey)
+	if err != nil {
+		return err
+	}
+
+	for {
+		key, value, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+
+		row, err := db.decodeRow(schema, value)
+		if err != nil {
+			return err
+		}
+		if err := fn(bytes.Clone(key), row); err != nil {
+			return err
+		}
+	}
+}
+
+func (db *Database) copyRows(oldSchema, newSchema *catalog.Schema, convert ConvertFunc) ([][]byte, error) {
+	var copied [][]byte
+	err := db.forEachRow(oldSchema, func(_ []byte, row tuple.Tuple) error {
+		key, err := db.copyRow(newSchema, row, convert, index.InsertOnly)
+		if err != nil {
+			return err
+		}
+		copied = append(copied, key)
+		return nil
+	})
+
+	return copied, err
+}
+
+// copyRow converts a row of the old schema and writes it to the new table,
+// returning its key there.
+func (db *Database) copyRow(newSchema *catalog.Schema, row tuple.Tuple, convert ConvertFunc, mode index.InsertMode) ([]byte, error) {
+	newRow, err := convert(row)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.checkRow(newSchema, newRow); err != nil {
+		return nil, err
+	}
+
+	data, err := db.encodeRow(newSchema, newRow)
+	if err != nil {
+		return nil, err
+	}
+	key, err := EncodeKey(newSchema.ID, newRow[newSchema.PrimaryKeyIndex])
+	if err != nil {
+		return nil, err
+	}
+	if err := db.checkReferences(newSchema, newRow, key); err != nil {
+		return nil, err
+	}
+
+	if err := db.store.Write(key, data, mode, storeLayout(newSchema)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// newKey returns the key a row of the old schema has in the new table.
+func newKey(newSchema *catalog.Schema, row tuple.Tuple, convert ConvertFunc) ([]byte, error) {
+	newRow, err := convert(row)
+	if err != nil {
+		return nil, err
+	}
+	if len(newRow) != len(newSchema.Columns) {
+		return nil, ErrColumnCountMismatch
+	}
+	return EncodeKey(newSchema.ID, newRow[newSchema.PrimaryKeyIndex])
+}
+
+// catchUp applies the writes to the old table from log position from on,
+// made while its rows were copied, to the new table, until the log holds
+// no more of them.
+func (db *Database) catchUp(oldSchema, newSchema *catalog.Schema, convert ConvertFunc, from uint64, copied *[][]byte) error {
+	startKey, endKey := tableKeyRange(oldSchema.ID)
+	for {
+		// The store must not be written to while its changes are read, so
+		// they are gathered first.
+		end := db.store.LogEnd()
+		var changes []storage.Change
+		for change, err := range db.store.Changes(from) {
+			if err != nil {
+				return err
+			}
+			if bytes.Compare(change.Key, startKey) >= 0 && bytes.Compare(change.Key, endKey) < 0 {
+				changes = append(changes, change.Change)
+			}
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		from = end
+
+		for _, change := range changes {
+			if err := db.applyChange(oldSchema, newSchema, convert, change, copied); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// applyChange makes the change a write made to a row of the old table in
+// the new one.
+func (db *Database) applyChange(oldSchema, newSchema *catalog.Schema, convert ConvertFunc, change storage.Change, copied *[][]byte) error {
+	var oldKey []byte
+	if change.OldValue != nil {
+		row, err := db.decodeRow(oldSchema, change.OldValue)
+		if err != nil {
+			return err
+		}
+		if oldKey, err = newKey(newSchema, row, convert); err != nil {
+			return err
+		}
+	}
+
+	var key []byte
+	if change.Op == storage.ChangePut {
+		row, err := db.decodeRow(oldSchema, change.NewValue)
+		if err != nil {
+			return err
+		}
+		if key, err = db.copyRow(newSchema, row, convert, index.Upsert); err != nil {
+			return err
+		}
+		*copied = append(*copied, key)
+	}
+
+	if oldKey != nil && !bytes.Equal(oldKey, key) {
+		if _, err := db.store.Delete(oldKey); err != nil && !errors.Is(err, index.ErrKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkIncomingReferences makes sure that every row of another table that
+// references the rewritten table still finds its parent under the new ID.
+func (db *Database) checkIncomingReferences(oldSchema, newSchema *catalog.Schema) error {
+	return db.forEachIncomingReference(oldSchema, func(_, parentPK []byte) error {
+		_, found, err := db.store.Get(append(tablePrefix(newSchema.ID), parentPK...))
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrForeignKeyViolation
+		}
+		return nil
+	})
+}
+
+func (db *Database) moveIncomingReferences(oldSchema, newSchema *catalog.Schema) error {
+	oldPrefix := referenceTablePrefix(oldSchema.ID)
+	newPrefix := referenceTablePrefix(newSchema.ID)
+
+	var moved [][]byte
+	if err := db.forEachIncomingReference(oldSchema, func(refKey, _ []byte) error {
+		moved = append(moved, refKey)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, refKey := range moved {
+		newKey := append(bytes.Clone(newPrefix), refKey[len(oldPrefix):]...)
+		if err := db.store.Put(newKey, referenceMarker); err != nil {
+			return err
+		}
+		if _, err := db.store.Delete(refKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) forEachIncomingReference(schema *catalog.Schema, fn func(refKey, parentPK []byte) error) error {
+	prefix := referenceTablePrefix(schema.ID)
+	iterator, err := db.store.NewIterator(prefix, prefixEnd(prefix))
+	if err != nil {
+		return err
+	}
+
+	for {
+		refKey, _, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		if refKey == nil {
+			return nil
+		}
+
+		entry := refKey[len(prefix):]
+		pkLen := int(binary.BigEndian.Uint16(entry))
+		parentPK := entry[2 : 2+pkLen]
+		childKey := entry[2+pkLen+2:]
+		if bytes.HasPrefix(childKey, tablePrefix(schema.ID)) {
+			continue
+		}
+
+		if err := fn(bytes.Clone(refKey), bytes.Clone(parentPK)); err != nil {
+			return err
+		}
+	}
+}
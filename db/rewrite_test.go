@@ -0,0 +1,292 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func scanAll(t *testing.T, db *Database, tableName string) []tuple.Tuple {
+	t.Helper()
+
+	scanner, err := db.Scan(tableName, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to scan table %s: %v", tableName, err)
+	}
+
+	var rows []tuple.Tuple
+	for {
+		row, err := scanner.Next()
+		if err != nil {
+			t.Fatalf("error while scanning: %v", err)
+		}
+		if row == nil {
+			return rows
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestRewriteTable(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "zip", Type: catalog.TypeInt},
+	}
+	oldSchema, err := db.CreateTable("addresses", columns)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 200 {
+		if err := db.Insert("addresses", tuple.Tuple{int64(i), int64(10000 + i)}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	newColumns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "zip", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "country", Type: catalog.TypeVarChar, IsNotNull: true},
+	}
+
+	t.Run("Failed_conversion_keeps_table", func(t *testing.T) {
+		errBadRow := errors.New("bad row")
+		_, err := db.RewriteTable("addresses", newColumns, func(row tuple.Tuple) (tuple.Tuple, error) {
+			if row[0].(int64) == 150 {
+				return nil, errBadRow
+			}
+			return tuple.Tuple{row[0], fmt.Sprint(row[1]), "NL"}, nil
+		})
+		if !errors.Is(err, errBadRow) {
+			t.Fatalf("expected error %v, got %v", errBadRow, err)
+		}
+
+		schema, _ := db.catalog.GetTable("addresses")
+		if schema.ID != oldSchema.ID {
+			t.Errorf("expected table id %d, got %d", oldSchema.ID, schema.ID)
+		}
+		if rows := scanAll(t, db, "addresses"); len(rows) != 200 {
+			t.Errorf("expected 200 rows, got %d", len(rows))
+		}
+	})
+
+	t.Run("Rewrite_changes_type", func(t *testing.T) {
+		newSchema, err := db.RewriteTable("addresses", newColumns, func(row tuple.Tuple) (tuple.Tuple, error) {
+			return tuple.Tuple{row[0], strconv.FormatInt(row[1].(int64), 10), "NL"}, nil
+		})
+		if err != nil {
+			t.Fatalf("failed to rewrite table: %v", err)
+		}
+		if newSchema.ID == oldSchema.ID {
+			t.Errorf("expected a new table id")
+		}
+
+		rows := scanAll(t, db, "addresses")
+		if len(rows) != 200 {
+			t.Fatalf("expected 200 rows, got %d", len(rows))
+		}
+		expected := tuple.Tuple{int64(42), "10042", "NL"}
+		if !reflect.DeepEqual(rows[42], expected) {
+			t.Errorf("expected row %v, got %v", expected, rows[42])
+		}
+
+		startKey, endKey := tableKeyRange(oldSchema.ID)
+		iterator, _ := db.store.NewIterator(startKey, endKey)
+		if key, _, _ := iterator.Next(); key != nil {
+			t.Errorf("expected old key range to be empty, found %x", key)
+		}
+	})
+}
+
+// failingSwap fails the swap of a rewritten table into the catalog.
+type failingSwap struct {
+	CatalogManager
+}
+
+var errSwapFailed = errors.New("swap failed")
+
+func (c failingSwap) SwapTable(schema *catalog.Schema) error {
+	return errSwapFailed
+}
+
+func TestRewriteTableFailedSwap(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "zip", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("addresses", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("addresses", "by_zip", []string{"zip"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	for i := range 20 {
+		if err := db.Insert("addresses", tuple.Tuple{int64(i), int64(10000 + i)}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	newColumns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "zip", Type: catalog.TypeVarChar},
+	}
+	manager := db.catalog
+	db.catalog = failingSwap{manager}
+	_, err := db.RewriteTable("addresses", newColumns, func(row tuple.Tuple) (tuple.Tuple, error) {
+		return tuple.Tuple{row[0], fmt.Sprint(row[1])}, nil
+	})
+	db.catalog = manager
+	if !errors.Is(err, errSwapFailed) {
+		t.Fatalf("expected error %v, got %v", errSwapFailed, err)
+	}
+
+	plan, err := db.Explain("addresses", QueryOptions{Filter: Where("zip", OpEqual, int64(10007))})
+	if err != nil {
+		t.Fatalf("failed to explain: %v", err)
+	}
+	for plan.Input != nil {
+		plan = plan.Input
+	}
+	if plan.Kind != PlanIndexScan {
+		t.Fatalf("expected an index scan, got %s", plan)
+	}
+	rows, err := db.Query("addresses", QueryOptions{Filter: Where("zip", OpEqual, int64(10007))})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	expected := []tuple.Tuple{{int64(7), int64(10007)}}
+	if got := collectRows(t, rows); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the old index to find %v, got %v", expected, got)
+	}
+	if rows := scanAll(t, db, "addresses"); len(rows) != 20 {
+		t.Errorf("expected 20 rows, got %d", len(rows))
+	}
+}
+
+func TestRewriteReferencedTable(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	createForeignKeyTables(t, db, catalog.OnDeleteRestrict)
+
+	db.Insert("authors", tuple.Tuple{int64(1), "ann"})
+	db.Insert("authors", tuple.Tuple{int64(2), "bo"})
+	db.Insert("books", tuple.Tuple{int64(10), int64(1)})
+
+	newColumns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+		{Name: "rating", Type: catalog.TypeFloat, DefaultValue: 0.0},
+	}
+
+	t.Run("Dropping_referenced_row_fails", func(t *testing.T) {
+		_, err := db.RewriteTable("authors", newColumns, func(row tuple.Tuple) (tuple.Tuple, error) {
+			if row[0].(int64) == 1 {
+				return tuple.Tuple{int64(3), row[1], 0.0}, nil
+			}
+			return tuple.Tuple{row[0], row[1], 0.0}, nil
+		})
+		if !errors.Is(err, ErrForeignKeyViolation) {
+			t.Fatalf("expected error %v, got %v", ErrForeignKeyViolation, err)
+		}
+	})
+
+	t.Run("Changing_referenced_key_type_fails", func(t *testing.T) {
+		columns := []catalog.Column{
+			{Name: "id", Type: catalog.TypeVarChar, IsPrimaryKey: true, IsNotNull: true},
+		}
+		_, err := db.RewriteTable("authors", columns, func(row tuple.Tuple) (tuple.Tuple, error) {
+			return tuple.Tuple{fmt.Sprint(row[0])}, nil
+		})
+		if !errors.Is(err, catalog.ErrInvalidReference) {
+			t.Fatalf("expected error %v, got %v", catalog.ErrInvalidReference, err)
+		}
+	})
+
+	t.Run("References_follow_rewrite", func(t *testing.T) {
+		_, err := db.RewriteTable("authors", newColumns, func(row tuple.Tuple) (tuple.Tuple, error) {
+			return tuple.Tuple{row[0], row[1], 4.5}, nil
+		})
+		if err != nil {
+			t.Fatalf("failed to rewrite table: %v", err)
+		}
+
+		if err := db.Delete("authors", int64(1)); !errors.Is(err, ErrForeignKeyViolation) {
+			t.Errorf("expected error %v, got %v", ErrForeignKeyViolation, err)
+		}
+		if err := db.Delete("authors", int64(2)); err != nil {
+			t.Errorf("failed to delete unreferenced author: %v", err)
+		}
+		if err := db.Insert("books", tuple.Tuple{int64(11), int64(1)}); err != nil {
+			t.Errorf("failed to insert book referencing rewritten author: %v", err)
+		}
+	})
+}
+
+func TestRewriteTableCatchesUp(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "n", Type: catalog.TypeInt},
+	}
+	if _, err := db.CreateTable("counters", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 50 {
+		if err := db.Insert("counters", tuple.Tuple{int64(i), int64(i)}); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	newColumns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "n", Type: catalog.TypeVarChar},
+	}
+	// The writes made once the copy is past the last row stand in for those
+	// of other writers while the rows are copied.
+	written := false
+	_, err := db.RewriteTable("counters", newColumns, func(row tuple.Tuple) (tuple.Tuple, error) {
+		if row[0].(int64) == 49 && !written {
+			written = true
+			if err := db.Insert("counters", tuple.Tuple{int64(100), int64(100)}); err != nil {
+				return nil, err
+			}
+			if err := db.Update("counters", tuple.Tuple{int64(10), int64(-10)}); err != nil {
+				return nil, err
+			}
+			if err := db.Delete("counters", int64(20)); err != nil {
+				return nil, err
+			}
+		}
+		return tuple.Tuple{row[0], strconv.FormatInt(row[1].(int64), 10)}, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to rewrite table: %v", err)
+	}
+
+	rows := scanAll(t, db, "counters")
+	if len(rows) != 50 {
+		t.Fatalf("expected 50 rows, got %d", len(rows))
+	}
+	for _, expected := range []tuple.Tuple{{int64(10), "-10"}, {int64(100), "100"}} {
+		row, found, err := db.Get("counters", expected[0])
+		if err != nil || !found || !reflect.DeepEqual(row, expected) {
+			t.Errorf("expected row %v, got %v (found %v, err %v)", expected, row, found, err)
+		}
+	}
+	if _, found, _ := db.Get("counters", int64(20)); found {
+		t.Errorf("expected row 20 to stay deleted")
+	}
+}
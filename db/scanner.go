@@ -9,30 +9,65 @@ import (
 )
 
 type Scanner struct {
+	db       *Database
 	iterator *storage.Iterator
 	schema   *catalog.Schema
+	filter   predicate
+	// done, if set, is called once with the rows read and returned when
+	// the scan ends or is closed.
+	done              func(scanned, returned int)
+	scanned, returned int
 }
 
-func (db *Database) Scan(tableName string, start, end tuple.Value) (*Scanner, error) {
+// Scan returns the rows of a table with primary keys in [start, end), in
+// key order. A nil bound leaves that side of the range open. If filter is
+// not nil, only rows matching it are returned.
+func (db *Database) Scan(tableName string, start, end tuple.Value, filter Filter) (*Scanner, error) {
 	schema, err := db.catalog.GetTable(tableName)
 	if err != nil {
 		return nil, err
 	}
 
+	var match predicate
+	if filter != nil {
+		if match, err = filter.bind(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	startKey, endKey, err := scanKeyRange(schema, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner, err := db.scanKeys(schema, startKey, endKey, match)
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		scanner.done = db.trackScan(schema, filter)
+	}
+	return scanner, nil
+}
+
+// scanKeyRange returns the keys bounding the rows of a table with primary
+// keys in [start, end).
+func scanKeyRange(schema *catalog.Schema, start, end tuple.Value) ([]byte, []byte, error) {
 	primaryKeyType := schema.Columns[schema.PrimaryKeyIndex].Type
 
 	var startKey, endKey []byte
+	var err error
 
 	if start == nil {
 		startKey = make([]byte, 8)
 		binary.BigEndian.PutUint32(startKey, schema.ID)
 	} else {
 		if !isTypeMatch(primaryKeyType, start) {
-			return nil, ErrInvalidPrimaryKey
+			return nil, nil, ErrInvalidPrimaryKey
 		}
-		startKey, err = createKey(schema.ID, start)
+		startKey, err = EncodeKey(schema.ID, start)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -41,33 +76,178 @@ func (db *Database) Scan(tableName string, start, end tuple.Value) (*Scanner, er
 		binary.BigEndian.PutUint32(endKey, schema.ID+1)
 	} else {
 		if !isTypeMatch(primaryKeyType, end) {
-			return nil, ErrInvalidPrimaryKey
+			return nil, nil, ErrInvalidPrimaryKey
 		}
-		endKey, err = createKey(schema.ID, end)
+		endKey, err = EncodeKey(schema.ID, end)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
+	return startKey, endKey, nil
+}
+
+func (db *Database) scanKeys(schema *catalog.Schema, startKey, endKey []byte, filter predicate) (*Scanner, error) {
 	iterator, err := db.store.NewIterator(startKey, endKey)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Scanner{
+		db:       db,
 		iterator: iterator,
 		schema:   schema,
+		filter:   filter,
 	}, nil
 }
 
 func (s *Scanner) Next() (tuple.Tuple, error) {
-	_, value, err := s.iterator.Next()
+	for {
+		_, value, err := s.iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			s.finish()
+			return nil, nil
+		}
+
+		row, err := s.db.decodeRow(s.schema, value)
+		if err != nil {
+			return nil, err
+		}
+
+		s.scanned++
+		if s.filter == nil || s.filter(row) {
+			s.returned++
+			return row, nil
+		}
+	}
+}
+
+func (s *Scanner) finish() {
+	if s.done != nil {
+		s.done(s.scanned, s.returned)
+		s.done = nil
+	}
+}
+
+// Close lets a Scanner be used as Rows. A scanner holds nothing that needs
+// releasing.
+func (s *Scanner) Close() error {
+	s.finish()
+	return nil
+}
+
+// KeyScanner returns the primary keys of a table without reading its rows.
+type KeyScanner struct {
+	iterator       *storage.KeyIterator
+	primaryKeyType catalog.DataType
+}
+
+// ScanKeys returns the primary keys in [start, end) in order, like Scan
+// without a filter. The keys come from the index alone, which makes it
+// much cheaper than Scan for counting rows or checking which exist.
+func (db *Database) ScanKeys(tableName string, start, end tuple.Value) (*KeyScanner, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	startKey, endKey, err := scanKeyRange(schema, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, err := db.store.NewKeyIterator(startKey, endKey)
 	if err != nil {
 		return nil, err
 	}
-	if value == nil {
-		return nil, nil
+
+	return &KeyScanner{
+		iterator:       iterator,
+		primaryKeyType: schema.Columns[schema.PrimaryKeyIndex].Type,
+	}, nil
+}
+
+// Next returns the next primary key, or nil when there are no more.
+func (s *KeyScanner) Next() (tuple.Value, error) {
+	key, err := s.iterator.Next()
+	if err != nil || key == nil {
+		return nil, err
+	}
+
+	_, primaryKey, err := DecodeKey(key, s.primaryKeyType)
+	return primaryKey, err
+}
+
+// Count returns the number of rows with a primary key in [start, end),
+// from the index alone.
+func (db *Database) Count(tableName string, start, end tuple.Value) (int, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	startKey, endKey, err := scanKeyRange(schema, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	return db.store.Count(startKey, endKey)
+}
+
+// MinPK returns the smallest primary key of a table, and false if the table
+// is empty.
+func (db *Database) MinPK(tableName string) (tuple.Value, bool, error) {
+	scanner, err := db.ScanKeys(tableName, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	primaryKey, err := scanner.Next()
+	return primaryKey, primaryKey != nil, err
+}
+
+// MaxPK returns the largest primary key of a table, and false if the table
+// is empty.
+func (db *Database) MaxPK(tableName string) (tuple.Value, bool, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	startKey, endKey, err := scanKeyRange(schema, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := db.store.LastKey(startKey, endKey)
+	if err != nil || key == nil {
+		return nil, false, err
+	}
+
+	_, primaryKey, err := DecodeKey(key, schema.Columns[schema.PrimaryKeyIndex].Type)
+	if err != nil {
+		return nil, false, err
+	}
+	return primaryKey, true, nil
+}
+
+// Exists reports whether a table has a row with the given primary key,
+// without reading the row.
+func (db *Database) Exists(tableName string, primaryKey tuple.Value) (bool, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return false, err
+	}
+
+	if !isTypeMatch(schema.Columns[schema.PrimaryKeyIndex].Type, primaryKey) {
+		return false, ErrInvalidPrimaryKey
+	}
+	key, err := EncodeKey(schema.ID, primaryKey)
+	if err != nil {
+		return false, err
 	}
 
-	return tuple.Deserialize(value, s.schema)
+	return db.store.Has(key)
 }
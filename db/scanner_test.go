@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/rizalta/toydb/catalog"
 	"github.com/rizalta/toydb/tuple"
@@ -136,7 +137,7 @@ func TestDB_Scanner(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			scanner, err := db.Scan(tt.tableName, tt.startKey, tt.endKey)
+			scanner, err := db.Scan(tt.tableName, tt.startKey, tt.endKey, nil)
 			if err != nil {
 				t.Fatalf("failed to scan table %s from %v to %v: %v", tt.tableName, tt.startKey, tt.endKey, err)
 			}
@@ -159,3 +160,149 @@ func TestDB_Scanner(t *testing.T) {
 		})
 	}
 }
+
+func TestScanTimestampRange(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "at", Type: catalog.TypeTimestamp, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "reading", Type: catalog.TypeFloat},
+	}
+	if _, err := db.CreateTable("metrics", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var rows []tuple.Tuple
+	for i := range 48 {
+		row := tuple.Tuple{base.Add(time.Duration(i) * time.Hour), float64(i)}
+		if err := db.Insert("metrics", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+		rows = append(rows, row)
+	}
+
+	start, end := base.Add(6*time.Hour), base.Add(12*time.Hour)
+	scanner, err := db.Scan("metrics", start, end, nil)
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+
+	var scanned []tuple.Tuple
+	for {
+		row, err := scanner.Next()
+		if err != nil {
+			t.Fatalf("error while scanning: %v", err)
+		}
+		if row == nil {
+			break
+		}
+		scanned = append(scanned, row)
+	}
+
+	if !reflect.DeepEqual(scanned, rows[6:12]) {
+		t.Errorf("expected rows %v, got %v", rows[6:12], scanned)
+	}
+}
+
+func TestScanKeys(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	for i := range 100 {
+		if err := db.Insert("users", tuple.Tuple{int64(i), fmt.Sprintf("user_%d", i)}); err != nil {
+			t.Fatalf("failed to insert row %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 100; i += 10 {
+		if err := db.Delete("users", int64(i)); err != nil {
+			t.Fatalf("failed to delete row %d: %v", i, err)
+		}
+	}
+
+	scanner, err := db.ScanKeys("users", int64(5), int64(25))
+	if err != nil {
+		t.Fatalf("failed to scan keys: %v", err)
+	}
+	var keys []tuple.Value
+	for {
+		key, err := scanner.Next()
+		if err != nil {
+			t.Fatalf("error while scanning keys: %v", err)
+		}
+		if key == nil {
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	var expected []tuple.Value
+	for i := int64(5); i < 25; i++ {
+		if i%10 != 0 {
+			expected = append(expected, i)
+		}
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected keys %v, got %v", expected, keys)
+	}
+
+	counts := []struct {
+		start, end tuple.Value
+		expected   int
+	}{
+		{nil, nil, 90},
+		{int64(5), int64(25), len(expected)},
+		{int64(95), nil, 5},
+		{int64(200), nil, 0},
+	}
+	for _, tt := range counts {
+		count, err := db.Count("users", tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("failed to count: %v", err)
+		}
+		if count != tt.expected {
+			t.Errorf("Count(%v, %v): expected %d, got %d", tt.start, tt.end, tt.expected, count)
+		}
+	}
+
+	if minPK, found, err := db.MinPK("users"); err != nil || !found || minPK != int64(1) {
+		t.Errorf("expected min primary key 1, got %v, found %v, err %v", minPK, found, err)
+	}
+	if maxPK, found, err := db.MaxPK("users"); err != nil || !found || maxPK != int64(99) {
+		t.Errorf("expected max primary key 99, got %v, found %v, err %v", maxPK, found, err)
+	}
+	if _, err := db.CreateTable("empty", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, found, err := db.MaxPK("empty"); err != nil || found {
+		t.Errorf("expected no max primary key in an empty table, found %v, err %v", found, err)
+	}
+
+	tests := []struct {
+		key      tuple.Value
+		expected bool
+		err      error
+	}{
+		{int64(1), true, nil},
+		{int64(10), false, nil},
+		{int64(100), false, nil},
+		{"1", false, ErrInvalidPrimaryKey},
+	}
+	for _, tt := range tests {
+		exists, err := db.Exists("users", tt.key)
+		if err != tt.err {
+			t.Errorf("Exists(%v): expected error %v, got %v", tt.key, tt.err, err)
+		}
+		if exists != tt.expected {
+			t.Errorf("Exists(%v): expected %v, got %v", tt.key, tt.expected, exists)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package db
+
+import "github.com/rizalta/toydb/storage"
+
+// Scrub reads the data log of the database and reports the records that
+// rotted. See storage.Store.Scrub.
+func (db *Database) Scrub(opts storage.ScrubOptions) (*storage.ScrubReport, error) {
+	return db.store.Scrub(opts)
+}
@@ -0,0 +1,436 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var (
+	ErrNoSketch      = errors.New("db: column has no sketch")
+	ErrCorruptSketch = errors.New("db: sketch data is corrupt")
+)
+
+const (
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+
+	cmsDepth = 4
+	cmsWidth = 1024
+
+	// heavyHitterCandidates is the number of values tracked as possible
+	// heavy hitters, which bounds the K a sketch can answer TopK for.
+	heavyHitterCandidates = 32
+)
+
+// Sketches are kept in memory while the database is open and written under
+//
+//	"sk:" | table ID | column name
+//
+// on Close. A persisted sketch is removed when it is loaded, so a database
+// that was not closed cleanly rebuilds its sketches from the table rows.
+var sketchPrefix = []byte("sk:")
+
+type sketchID struct {
+	tableID uint32
+	column  string
+}
+
+func sketchKey(id sketchID) []byte {
+	key := make([]byte, 0, len(sketchPrefix)+4+len(id.column))
+	key = append(key, sketchPrefix...)
+	key = binary.BigEndian.AppendUint32(key, id.tableID)
+	return append(key, id.column...)
+}
+
+// HeavyHitter is a frequent value of a column with its estimated number
+// of occurrences.
+type HeavyHitter struct {
+	Value tuple.Value
+	Count uint64
+}
+
+type candidate struct {
+	value   tuple.Value
+	encoded []byte
+}
+
+// ColumnSketch summarizes the values of a column: a HyperLogLog for the
+// number of distinct values and a Count-Min sketch for the frequency of
+// each value. NULLs are not counted. Frequencies follow deletes and
+// updates, but a value stays in the distinct count until the sketch is
+// rebuilt.
+type ColumnSketch struct {
+	colType    catalog.DataType
+	count      uint64
+	registers  [hllRegisters]uint8
+	counters   [cmsDepth][cmsWidth]uint32
+	candidates []candidate
+}
+
+func newColumnSketch(colType catalog.DataType) *ColumnSketch {
+	return &ColumnSketch{colType: colType}
+}
+
+func sketchHash(encoded []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(encoded)
+	return mix64(h.Sum64())
+}
+
+// mix64 is the splitmix64 finalizer, which spreads the poorly mixed high
+// bits of FNV over the whole word.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func counterIndexes(hash uint64) [cmsDepth]int {
+	var indexes [cmsDepth]int
+	h1, h2 := hash, mix64(hash^0x9e3779b97f4a7c15)|1
+	for i := range indexes {
+		indexes[i] = int((h1 + uint64(i)*h2) % cmsWidth)
+	}
+	return indexes
+}
+
+func (s *ColumnSketch) add(v tuple.Value) {
+	if v == nil {
+		return
+	}
+	encoded := appendIndexValue(nil, v)
+	hash := sketchHash(encoded)
+
+	s.count++
+	register := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	s.registers[register] = max(s.registers[register], rank)
+
+	for i, j := range counterIndexes(hash) {
+		s.counters[i][j]++
+	}
+	s.track(v, encoded)
+}
+
+func (s *ColumnSketch) remove(v tuple.Value) {
+	if v == nil {
+		return
+	}
+	encoded := appendIndexValue(nil, v)
+
+	s.count--
+	for i, j := range counterIndexes(sketchHash(encoded)) {
+		if s.counters[i][j] > 0 {
+			s.counters[i][j]--
+		}
+	}
+}
+
+// track keeps the candidates as the values with the highest estimated
+// frequency seen so far, replacing the weakest one when a more frequent
+// value comes along.
+func (s *ColumnSketch) track(v tuple.Value, encoded []byte) {
+	for _, c := range s.candidates {
+		if string(c.encoded) == string(encoded) {
+			return
+		}
+	}
+	if len(s.candidates) < heavyHitterCandidates {
+		s.candidates = append(s.candidates, candidate{value: v, encoded: encoded})
+		return
+	}
+
+	weakest := 0
+	weakestCount := s.estimate(s.candidates[0].encoded)
+	for i, c := range s.candidates[1:] {
+		if count := s.estimate(c.encoded); count < weakestCount {
+			weakest, weakestCount = i+1, count
+		}
+	}
+	if s.estimate(encoded) > weakestCount {
+		s.candidates[weakest] = candidate{value: v, encoded: encoded}
+	}
+}
+
+func (s *ColumnSketch) estimate(encoded []byte) uint64 {
+	count := uint32(math.MaxUint32)
+	for i, j := range counterIndexes(sketchHash(encoded)) {
+		count = min(count, s.counters[i][j])
+	}
+	return uint64(count)
+}
+
+// Count returns the number of non-NULL values in the column.
+func (s *ColumnSketch) Count() uint64 {
+	return s.count
+}
+
+// Distinct estimates the number of distinct non-NULL values in the column,
+// with a typical error of about 1.6%.
+func (s *ColumnSketch) Distinct() uint64 {
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// Frequency estimates how many rows hold v in the column. The estimate
+// never undercounts.
+func (s *ColumnSketch) Frequency(v tuple.Value) uint64 {
+	if v == nil {
+		return 0
+	}
+	return s.estimate(appendIndexValue(nil, v))
+}
+
+// TopK returns up to k of the most frequent values, most frequent first.
+// k is capped at the number of tracked candidates.
+func (s *ColumnSketch) TopK(k int) []HeavyHitter {
+	var hitters []HeavyHitter
+	for _, c := range s.candidates {
+		if count := s.estimate(c.encoded); count > 0 {
+			hitters = append(hitters, HeavyHitter{Value: c.value, Count: count})
+		}
+	}
+	slices.SortStableFunc(hitters, func(a, b HeavyHitter) int {
+		if a.Count != b.Count {
+			if a.Count > b.Count {
+				return -1
+			}
+			return 1
+		}
+		return tuple.Compare(a.Value, b.Value)
+	})
+
+	if len(hitters) > k {
+		hitters = hitters[:k]
+	}
+	return hitters
+}
+
+func (s *ColumnSketch) clone() *ColumnSketch {
+	c := *s
+	c.candidates = slices.Clone(s.candidates)
+	return &c
+}
+
+func valueSchema(colType catalog.DataType) *catalog.Schema {
+	return &catalog.Schema{Columns: []catalog.Column{{Name: "value", Type: colType}}}
+}
+
+func (s *ColumnSketch) marshal() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint64(nil, s.count)
+	buf = append(buf, s.registers[:]...)
+	for i := range s.counters {
+		for _, c := range s.counters[i] {
+			buf = binary.LittleEndian.AppendUint32(buf, c)
+		}
+	}
+
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(s.candidates)))
+	for _, c := range s.candidates {
+		data, err := tuple.Serialize(tuple.Tuple{c.value}, valueSchema(s.colType))
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+
+	return buf, nil
+}
+
+func unmarshalColumnSketch(data []byte, colType catalog.DataType) (*ColumnSketch, error) {
+	const fixedSize = 8 + hllRegisters + cmsDepth*cmsWidth*4 + 2
+	if len(data) < fixedSize {
+		return nil, ErrCorruptSketch
+	}
+
+	s := newColumnSketch(colType)
+	s.count = binary.LittleEndian.Uint64(data)
+	data = data[8:]
+	copy(s.registers[:], data)
+	data = data[hllRegisters:]
+	for i := range s.counters {
+		for j := range s.counters[i] {
+			s.counters[i][j] = binary.LittleEndian.Uint32(data)
+			data = data[4:]
+		}
+	}
+
+	n := int(binary.LittleEndian.Uint16(data))
+	data = data[2:]
+	for range n {
+		if len(data) < 4 || len(data)-4 < int(binary.LittleEndian.Uint32(data)) {
+			return nil, ErrCorruptSketch
+		}
+		size := int(binary.LittleEndian.Uint32(data))
+		row, err := tuple.Deserialize(data[4:4+size], valueSchema(colType))
+		if err != nil {
+			return nil, err
+		}
+		data = data[4+size:]
+		s.candidates = append(s.candidates, candidate{value: row[0], encoded: appendIndexValue(nil, row[0])})
+	}
+	if len(data) != 0 {
+		return nil, ErrCorruptSketch
+	}
+
+	return s, nil
+}
+
+func hasSketches(schema *catalog.Schema) bool {
+	return slices.ContainsFunc(schema.Columns, func(c catalog.Column) bool {
+		return c.Sketch
+	})
+}
+
+// loadSketches makes the sketches of a table available in memory, reading
+// them from the store or rebuilding them from the rows. It has to run
+// before the rows of the table change, so that a rebuild does not count a
+// change that is applied to the sketch afterwards.
+func (db *Database) loadSketches(schema *catalog.Schema) error {
+	var missing []int
+	for i, col := range schema.Columns {
+		if !col.Sketch {
+			continue
+		}
+		id := sketchID{tableID: schema.ID, column: col.Name}
+		if _, loaded := db.sketches[id]; loaded {
+			continue
+		}
+
+		data, found, err := db.store.Get(sketchKey(id))
+		if err != nil {
+			return err
+		}
+		if !found {
+			missing = append(missing, i)
+			continue
+		}
+		sketch, err := unmarshalColumnSketch(data, col.Type)
+		if err != nil {
+			return err
+		}
+		if _, err := db.store.Delete(sketchKey(id)); err != nil {
+			return err
+		}
+		db.sketches[id] = sketch
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	rebuilt := make([]*ColumnSketch, len(missing))
+	for i, col := range missing {
+		rebuilt[i] = newColumnSketch(schema.Columns[col].Type)
+	}
+	if err := db.forEachRow(schema, func(_ []byte, row tuple.Tuple) error {
+		for i, col := range missing {
+			rebuilt[i].add(row[col])
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for i, col := range missing {
+		db.sketches[sketchID{tableID: schema.ID, column: schema.Columns[col].Name}] = rebuilt[i]
+	}
+
+	return nil
+}
+
+// updateSketches moves the values of a row in the loaded sketches of its
+// table from oldRow to newRow.
+func (db *Database) updateSketches(schema *catalog.Schema, oldRow, newRow tuple.Tuple) {
+	for i, col := range schema.Columns {
+		if !col.Sketch {
+			continue
+		}
+		sketch, loaded := db.sketches[sketchID{tableID: schema.ID, column: col.Name}]
+		if !loaded {
+			continue
+		}
+		if oldRow != nil && newRow != nil && tuple.Compare(oldRow[i], newRow[i]) == 0 {
+			continue
+		}
+		if oldRow != nil {
+			sketch.remove(oldRow[i])
+		}
+		if newRow != nil {
+			sketch.add(newRow[i])
+		}
+	}
+}
+
+// dropSketches forgets the sketches of a table that no longer exists.
+func (db *Database) dropSketches(schema *catalog.Schema) error {
+	for _, col := range schema.Columns {
+		if !col.Sketch {
+			continue
+		}
+		id := sketchID{tableID: schema.ID, column: col.Name}
+		delete(db.sketches, id)
+		if _, err := db.store.Delete(sketchKey(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Database) saveSketches() error {
+	for id, sketch := range db.sketches {
+		data, err := sketch.marshal()
+		if err != nil {
+			return err
+		}
+		if err := db.store.Put(sketchKey(id), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ColumnSketch returns a snapshot of the sketch of a column that was
+// created with Sketch set.
+func (db *Database) ColumnSketch(tableName, column string) (*ColumnSketch, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	colIdx := columnIndex(schema, column)
+	if colIdx == -1 {
+		return nil, ErrColumnNotFound
+	}
+	if !schema.Columns[colIdx].Sketch {
+		return nil, ErrNoSketch
+	}
+
+	if err := db.loadSketches(schema); err != nil {
+		return nil, err
+	}
+	return db.sketches[sketchID{tableID: schema.ID, column: column}].clone(), nil
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestColumnSketch(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "user", Type: catalog.TypeVarChar, Sketch: true},
+		{Name: "status", Type: catalog.TypeInt},
+	}
+	schema, err := db.CreateTable("events", columns)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	// user_0 appears in every 4th row, user_1 in every 8th and the rest
+	// of the rows each have a user of their own.
+	counts := make(map[string]uint64)
+	for i := range 8000 {
+		user := fmt.Sprintf("user_%d", i)
+		switch {
+		case i%4 == 0:
+			user = "user_0"
+		case i%8 == 1:
+			user = "user_1"
+		}
+		row := tuple.Tuple{int64(i), user, int64(i % 3)}
+		if i%1000 == 999 {
+			row[1] = nil
+		} else {
+			counts[user]++
+		}
+		if err := db.Insert("events", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+	}
+	for i := range 100 {
+		if err := db.Delete("events", int64(i*4)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		counts["user_0"]--
+	}
+	if err := db.Update("events", tuple.Tuple{int64(1), "user_0", int64(0)}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	counts["user_1"]--
+	counts["user_0"]++
+
+	check := func(t *testing.T, sketch *ColumnSketch) {
+		t.Helper()
+
+		total := uint64(0)
+		for _, c := range counts {
+			total += c
+		}
+		if sketch.Count() != total {
+			t.Errorf("expected count %d, got %d", total, sketch.Count())
+		}
+
+		distinct := float64(sketch.Distinct())
+		if expected := float64(len(counts)); distinct < expected*0.95 || distinct > expected*1.05 {
+			t.Errorf("expected about %.0f distinct values, got %.0f", expected, distinct)
+		}
+
+		for _, user := range []string{"user_0", "user_1", "user_2", "user_4001"} {
+			got := sketch.Frequency(user)
+			if got < counts[user] || got > counts[user]+total/100 {
+				t.Errorf("expected frequency of %s close to %d, got %d", user, counts[user], got)
+			}
+		}
+
+		top := sketch.TopK(2)
+		if len(top) != 2 || top[0].Value != "user_0" || top[1].Value != "user_1" {
+			t.Errorf("expected user_0 and user_1 as heavy hitters, got %v", top)
+		}
+	}
+
+	sketch, err := db.ColumnSketch("events", "user")
+	if err != nil {
+		t.Fatalf("failed to get sketch: %v", err)
+	}
+	check(t, sketch)
+
+	if _, err := db.ColumnSketch("events", "status"); !errors.Is(err, ErrNoSketch) {
+		t.Errorf("expected %v, got %v", ErrNoSketch, err)
+	}
+	if _, err := db.ColumnSketch("events", "missing"); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected %v, got %v", ErrColumnNotFound, err)
+	}
+
+	db.Close()
+
+	t.Run("persisted on close", func(t *testing.T) {
+		db, err := NewDatabase(dir)
+		if err != nil {
+			t.Fatalf("failed to reopen db: %v", err)
+		}
+		if _, found, err := db.store.Get(sketchKey(sketchID{tableID: schema.ID, column: "user"})); err != nil || !found {
+			t.Fatalf("expected persisted sketch, got found=%v err=%v", found, err)
+		}
+		sketch, err := db.ColumnSketch("events", "user")
+		if err != nil {
+			t.Fatalf("failed to get sketch: %v", err)
+		}
+		check(t, sketch)
+
+		// Leave without Close, as a crash would. The catalog shares the
+		// store, so closing the store releases everything.
+		db.store.Close()
+	})
+
+	t.Run("rebuilt after crash", func(t *testing.T) {
+		db, err := NewDatabase(dir)
+		if err != nil {
+			t.Fatalf("failed to reopen db: %v", err)
+		}
+		defer db.Close()
+
+		if _, found, err := db.store.Get(sketchKey(sketchID{tableID: schema.ID, column: "user"})); err != nil || found {
+			t.Fatalf("expected no persisted sketch, got found=%v err=%v", found, err)
+		}
+		sketch, err := db.ColumnSketch("events", "user")
+		if err != nil {
+			t.Fatalf("failed to get sketch: %v", err)
+		}
+		check(t, sketch)
+	})
+}
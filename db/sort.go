@@ -0,0 +1,237 @@
+package db
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// sortRows is an external merge sort. It reads its input in chunks of
+// bufferSize rows, sorts each chunk and spills it to a temporary file, then
+// merges the runs. If only the first keep rows are wanted, chunks are
+// trimmed to keep rows instead of being spilled while they fit in memory.
+type sortRows struct {
+	input      Rows
+	schema     *catalog.Schema
+	compare    func(a, b tuple.Tuple) int
+	bufferSize int
+	keep       int
+
+	sorted bool
+	buffer []tuple.Tuple
+	runs   []*sortRun
+	merge  mergeHeap
+}
+
+func (s *sortRows) Next() (tuple.Tuple, error) {
+	if !s.sorted {
+		if err := s.sort(); err != nil {
+			return nil, err
+		}
+		s.sorted = true
+	}
+
+	if len(s.merge.items) == 0 {
+		return nil, nil
+	}
+
+	item := s.merge.items[0]
+	row := item.row
+	next, err := item.run.next()
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		heap.Pop(&s.merge)
+	} else {
+		item.row = next
+		heap.Fix(&s.merge, 0)
+	}
+
+	return row, nil
+}
+
+func (s *sortRows) sort() error {
+	for {
+		row, err := s.input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+
+		s.buffer = append(s.buffer, row)
+		if len(s.buffer) >= s.bufferSize {
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	slices.SortStableFunc(s.buffer, s.compare)
+	s.runs = append(s.runs, &sortRun{rows: s.buffer})
+	s.buffer = nil
+
+	s.merge = mergeHeap{compare: s.compare}
+	for i, run := range s.runs {
+		row, err := run.next()
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			s.merge.items = append(s.merge.items, &mergeItem{row: row, run: run, order: i})
+		}
+	}
+	heap.Init(&s.merge)
+
+	return nil
+}
+
+func (s *sortRows) flush() error {
+	slices.SortStableFunc(s.buffer, s.compare)
+
+	if s.keep > 0 && s.keep < s.bufferSize {
+		s.buffer = s.buffer[:min(len(s.buffer), s.keep)]
+		return nil
+	}
+
+	run, err := spillRun(s.buffer, s.schema)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	s.buffer = s.buffer[:0]
+
+	return nil
+}
+
+func (s *sortRows) Close() error {
+	errs := []error{s.input.Close()}
+	for _, run := range s.runs {
+		errs = append(errs, run.close())
+	}
+	s.runs = nil
+	s.merge.items = nil
+	return errors.Join(errs...)
+}
+
+// sortRun is a sorted sequence of rows, held in memory or spilled to a
+// temporary file as length-prefixed serialized tuples.
+type sortRun struct {
+	rows []tuple.Tuple
+
+	file   *os.File
+	reader *bufio.Reader
+	schema *catalog.Schema
+}
+
+func spillRun(rows []tuple.Tuple, schema *catalog.Schema) (*sortRun, error) {
+	file, err := os.CreateTemp("", "toydb-sort-*")
+	if err != nil {
+		return nil, err
+	}
+	run := &sortRun{file: file, schema: schema}
+
+	w := bufio.NewWriter(file)
+	for _, row := range rows {
+		data, err := tuple.Serialize(row, schema)
+		if err != nil {
+			run.close()
+			return nil, err
+		}
+		w.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+		w.Write(data)
+	}
+	if err := w.Flush(); err != nil {
+		run.close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		run.close()
+		return nil, err
+	}
+	run.reader = bufio.NewReader(file)
+
+	return run, nil
+}
+
+func (r *sortRun) next() (tuple.Tuple, error) {
+	if r.file == nil {
+		if len(r.rows) == 0 {
+			return nil, nil
+		}
+		row := r.rows[0]
+		r.rows = r.rows[1:]
+		return row, nil
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(r.reader, size[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, err
+	}
+
+	return tuple.Deserialize(data, r.schema)
+}
+
+func (r *sortRun) close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	if removeErr := os.Remove(r.file.Name()); err == nil {
+		err = removeErr
+	}
+	r.file = nil
+
+	return err
+}
+
+type mergeItem struct {
+	row   tuple.Tuple
+	run   *sortRun
+	order int
+}
+
+// mergeHeap picks the smallest head row among the runs. Ties go to the
+// earlier run, which keeps the sort stable.
+type mergeHeap struct {
+	items   []*mergeItem
+	compare func(a, b tuple.Tuple) int
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	if c := h.compare(h.items[i].row, h.items[j].row); c != 0 {
+		return c < 0
+	}
+	return h.items[i].order < h.items[j].order
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x any) { h.items = append(h.items, x.(*mergeItem)) }
+
+func (h *mergeHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
@@ -0,0 +1,701 @@
+package db
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidStatement = errors.New("db: invalid statement")
+
+// DumpSQL writes the tables of the database as SQL statements that LoadSQL
+// replays: for each table a CREATE TABLE, an INSERT per row in primary key
+// order and a CREATE INDEX per secondary index, so that loading fills the
+// indexes once. Tables come after the tables they reference, and otherwise
+// in name order. Rows are read and written one at a time, so a dump of any
+// size takes little memory.
+//
+// Streams and checks registered with RegisterCheck are left out, and so
+// are statistics, which ANALYZE gathers again. Rows of a table that
+// references itself load only if every row comes after the row it points
+// at in primary key order.
+func (db *Database) DumpSQL(w io.Writer) error {
+	tables, err := db.dumpOrder()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("-- toydb dump\n")
+	for _, schema := range tables {
+		bw.WriteString("\n")
+		if err := db.dumpTableSQL(bw, schema); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// dumpOrder returns the schemas of the tables with every table after the
+// tables it references.
+func (db *Database) dumpOrder() ([]*catalog.Schema, error) {
+	names, err := db.catalog.Tables()
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]*catalog.Schema, len(names))
+	for _, name := range names {
+		if schemas[name], err = db.catalog.GetTable(name); err != nil {
+			return nil, err
+		}
+	}
+
+	var order []*catalog.Schema
+	done := make(map[string]bool)
+	var visit func(schema *catalog.Schema)
+	visit = func(schema *catalog.Schema) {
+		if done[schema.Name] {
+			return
+		}
+		done[schema.Name] = true
+		for _, c := range schema.Columns {
+			if c.References == nil {
+				continue
+			}
+			if parent, ok := schemas[c.References.Table]; ok {
+				visit(parent)
+			}
+		}
+		order = append(order, schema)
+	}
+	for _, name := range names {
+		visit(schemas[name])
+	}
+	return order, nil
+}
+
+func (db *Database) dumpTableSQL(w *bufio.Writer, schema *catalog.Schema) error {
+	fmt.Fprintf(w, "CREATE TABLE %s (\n", sqlIdent(schema.Name))
+	for i, c := range schema.Columns {
+		fmt.Fprintf(w, "  %s %s", sqlIdent(c.Name), c.Type)
+		if c.IsPrimaryKey {
+			w.WriteString(" PRIMARY KEY")
+		}
+		if c.IsNotNull {
+			w.WriteString(" NOT NULL")
+		}
+		if c.DefaultValue != nil {
+			fmt.Fprintf(w, " DEFAULT %s", sqlLiteral(c.DefaultValue))
+		}
+		if c.Generator != catalog.GeneratorNone {
+			fmt.Fprintf(w, " GENERATED %s", c.Generator)
+		}
+		if c.Sketch {
+			w.WriteString(" SKETCH")
+		}
+		if ref := c.References; ref != nil {
+			fmt.Fprintf(w, " REFERENCES %s (%s)", sqlIdent(ref.Table), sqlIdent(ref.Column))
+			if ref.OnDelete == catalog.OnDeleteCascade {
+				w.WriteString(" ON DELETE CASCADE")
+			}
+		}
+		if i < len(schema.Columns)-1 {
+			w.WriteString(",")
+		}
+		w.WriteString("\n")
+	}
+	w.WriteString(")")
+	if schema.Layout == catalog.LayoutIndexOrganized {
+		w.WriteString(" ORGANIZATION INDEX")
+	}
+	w.WriteString(";\n")
+
+	scanner, err := db.Scan(schema.Name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := scanner.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		values := make([]string, len(row))
+		for i, v := range row {
+			values[i] = sqlLiteral(v)
+		}
+		fmt.Fprintf(w, "INSERT INTO %s VALUES (%s);\n", sqlIdent(schema.Name), strings.Join(values, ", "))
+	}
+
+	for _, info := range schema.Indexes {
+		if info.ID == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "CREATE INDEX %s ON %s", sqlIdent(info.Name), sqlIdent(schema.Name))
+		if info.Type != catalog.IndexBTree {
+			fmt.Fprintf(w, " USING %s", info.Type)
+		}
+		columns := make([]string, len(info.Columns))
+		for i, name := range info.Columns {
+			columns[i] = sqlIdent(name)
+		}
+		fmt.Fprintf(w, " (%s);\n", strings.Join(columns, ", "))
+	}
+	return nil
+}
+
+// sqlIdent returns name as is if it is a plain identifier and in double
+// quotes otherwise.
+func sqlIdent(name string) string {
+	plain := name != ""
+	for i, c := range name {
+		if !(c == '_' || c < unicode.MaxASCII && unicode.IsLetter(c) || i > 0 && c < unicode.MaxASCII && unicode.IsDigit(c)) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlLiteral returns v as an SQL literal that loads back the same into a
+// column of its type. The special floats are strings, as SQL has no
+// literals for them.
+func sqlLiteral(v tuple.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "'NaN'"
+		case math.IsInf(v, 1):
+			return "'Infinity'"
+		case math.IsInf(v, -1):
+			return "'-Infinity'"
+		}
+		return dumpValue(v)
+	case string:
+		return sqlString(v)
+	case time.Time:
+		return sqlString(tuple.FormatTimestamp(v))
+	default:
+		return dumpValue(v)
+	}
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// LoadSQL runs the statements of a dump that DumpSQL wrote, one at a time
+// as it reads them, and stops at the first that fails. Statements are
+// CREATE TABLE, CREATE INDEX and INSERT INTO ... VALUES with one or more
+// rows, in the dialect DumpSQL writes, and -- starts a comment. Syntax
+// errors match ErrInvalidStatement and give the line they are on.
+func (db *Database) LoadSQL(r io.Reader) error {
+	p := &sqlParser{lex: sqlLexer{r: bufio.NewReader(r), line: 1}}
+	if err := p.advance(); err != nil {
+		return err
+	}
+	for p.tok.kind != tokenEOF {
+		if err := p.statement(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	// tokenWord is a keyword or a plain identifier, and tokenIdent an
+	// identifier in double quotes.
+	tokenWord
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenBlob
+	tokenPunct
+)
+
+type sqlToken struct {
+	kind tokenKind
+	text string
+	line int
+}
+
+func (t sqlToken) String() string {
+	if t.kind == tokenEOF {
+		return "end of input"
+	}
+	return strconv.Quote(t.text)
+}
+
+type sqlLexer struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (l *sqlLexer) peek() rune {
+	c, _, err := l.r.ReadRune()
+	if err != nil {
+		return 0
+	}
+	l.r.UnreadRune()
+	return c
+}
+
+func (l *sqlLexer) next() (sqlToken, error) {
+	for {
+		c, _, err := l.r.ReadRune()
+		if err == io.EOF {
+			return sqlToken{kind: tokenEOF, line: l.line}, nil
+		}
+		if err != nil {
+			return sqlToken{}, err
+		}
+		line := l.line
+		switch {
+		case c == '\n':
+			l.line++
+		case unicode.IsSpace(c):
+		case c == '-' && l.peek() == '-':
+			if _, err := l.r.ReadString('\n'); err != nil && err != io.EOF {
+				return sqlToken{}, err
+			}
+			l.line++
+		case strings.ContainsRune("(),;", c):
+			return sqlToken{kind: tokenPunct, text: string(c), line: line}, nil
+		case c == '\'':
+			text, err := l.quoted('\'')
+			return sqlToken{kind: tokenString, text: text, line: line}, err
+		case c == '"':
+			text, err := l.quoted('"')
+			return sqlToken{kind: tokenIdent, text: text, line: line}, err
+		case (c == 'x' || c == 'X') && l.peek() == '\'':
+			l.r.ReadRune()
+			text, err := l.quoted('\'')
+			return sqlToken{kind: tokenBlob, text: text, line: line}, err
+		case c == '-' || c == '+' || c == '.' || unicode.IsDigit(c):
+			return sqlToken{kind: tokenNumber, text: l.span(c, isNumberRune), line: line}, nil
+		case c == '_' || unicode.IsLetter(c):
+			return sqlToken{kind: tokenWord, text: l.span(c, isWordRune), line: line}, nil
+		default:
+			return sqlToken{}, fmt.Errorf("%w: line %d: unexpected %q", ErrInvalidStatement, line, c)
+		}
+	}
+}
+
+// quoted reads the rest of a quoted string or identifier, in which a
+// doubled quote stands for itself.
+func (l *sqlLexer) quoted(quote rune) (string, error) {
+	var b strings.Builder
+	start := l.line
+	for {
+		c, _, err := l.r.ReadRune()
+		if err == io.EOF {
+			return "", fmt.Errorf("%w: line %d: unterminated %c", ErrInvalidStatement, start, quote)
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == quote {
+			if l.peek() != quote {
+				return b.String(), nil
+			}
+			l.r.ReadRune()
+		}
+		if c == '\n' {
+			l.line++
+		}
+		b.WriteRune(c)
+	}
+}
+
+// span reads the runes from first on that in accepts.
+func (l *sqlLexer) span(first rune, in func(rune) bool) string {
+	var b strings.Builder
+	b.WriteRune(first)
+	for {
+		c, _, err := l.r.ReadRune()
+		if err != nil {
+			return b.String()
+		}
+		if !in(c) {
+			l.r.UnreadRune()
+			return b.String()
+		}
+		b.WriteRune(c)
+	}
+}
+
+func isNumberRune(c rune) bool {
+	return unicode.IsDigit(c) || strings.ContainsRune(".eE+-", c)
+}
+
+func isWordRune(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// sqlParser reads statements from its lexer, holding the next token in
+// tok.
+type sqlParser struct {
+	lex sqlLexer
+	tok sqlToken
+}
+
+func (p *sqlParser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *sqlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrInvalidStatement, p.tok.line, fmt.Sprintf(format, args...))
+}
+
+// is reports whether the next token is the keyword or punctuation word.
+func (p *sqlParser) is(word string) bool {
+	return (p.tok.kind == tokenWord || p.tok.kind == tokenPunct) && strings.EqualFold(p.tok.text, word)
+}
+
+// accept consumes the next token if it is word.
+func (p *sqlParser) accept(word string) (bool, error) {
+	if !p.is(word) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// expect consumes words, which the next tokens have to be.
+func (p *sqlParser) expect(words ...string) error {
+	for _, word := range words {
+		if !p.is(word) {
+			return p.errorf("expected %s, got %s", word, p.tok)
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *sqlParser) ident() (string, error) {
+	if p.tok.kind != tokenWord && p.tok.kind != tokenIdent {
+		return "", p.errorf("expected a name, got %s", p.tok)
+	}
+	name := p.tok.text
+	return name, p.advance()
+}
+
+// list parses a parenthesized, comma-separated list, calling item for each
+// element.
+func (p *sqlParser) list(item func() error) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		if ok, err := p.accept(","); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			return p.expect(")")
+		}
+	}
+}
+
+func (p *sqlParser) statement(db *Database) error {
+	switch {
+	case p.is("CREATE"):
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if ok, err := p.accept("TABLE"); err != nil || ok {
+			if err != nil {
+				return err
+			}
+			return p.createTable(db)
+		}
+		if err := p.expect("INDEX"); err != nil {
+			return err
+		}
+		return p.createIndex(db)
+	case p.is("INSERT"):
+		return p.insert(db)
+	default:
+		return p.errorf("expected a statement, got %s", p.tok)
+	}
+}
+
+func (p *sqlParser) createTable(db *Database) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	var columns []catalog.Column
+	err = p.list(func() error {
+		c, err := p.column()
+		columns = append(columns, c)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var opts catalog.TableOptions
+	if ok, err := p.accept("ORGANIZATION"); err != nil || ok {
+		if err != nil {
+			return err
+		}
+		if err := p.expect("INDEX"); err != nil {
+			return err
+		}
+		opts.Layout = catalog.LayoutIndexOrganized
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+	_, err = db.CreateTableWithOptions(name, columns, opts)
+	return err
+}
+
+func (p *sqlParser) column() (catalog.Column, error) {
+	var c catalog.Column
+	var err error
+	if c.Name, err = p.ident(); err != nil {
+		return c, err
+	}
+	typeName, err := p.ident()
+	if err != nil {
+		return c, err
+	}
+	if c.Type, err = parseDataType(typeName); err != nil {
+		return c, p.errorf("%v", err)
+	}
+
+	for !p.is(",") && !p.is(")") {
+		switch {
+		case p.is("PRIMARY"):
+			err = p.expect("PRIMARY", "KEY")
+			c.IsPrimaryKey = true
+		case p.is("NOT"):
+			err = p.expect("NOT", "NULL")
+			c.IsNotNull = true
+		case p.is("DEFAULT"):
+			if err = p.advance(); err == nil {
+				c.DefaultValue, err = p.literal(c.Type)
+			}
+		case p.is("GENERATED"):
+			if err = p.advance(); err == nil {
+				c.Generator, err = p.generator()
+			}
+		case p.is("SKETCH"):
+			err = p.advance()
+			c.Sketch = true
+		case p.is("REFERENCES"):
+			c.References, err = p.references()
+		default:
+			return c, p.errorf("unexpected %s in column %s", p.tok, c.Name)
+		}
+		if err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func parseDataType(name string) (catalog.DataType, error) {
+	for t := catalog.TypeInt; t <= catalog.TypeTimestamp; t++ {
+		if strings.EqualFold(name, t.String()) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown type %s", name)
+}
+
+func (p *sqlParser) generator() (catalog.KeyGenerator, error) {
+	for _, g := range []catalog.KeyGenerator{catalog.GeneratorULID, catalog.GeneratorSnowflake} {
+		if p.is(g.String()) {
+			return g, p.advance()
+		}
+	}
+	return 0, p.errorf("unknown key generator %s", p.tok)
+}
+
+func (p *sqlParser) references() (*catalog.ForeignKey, error) {
+	if err := p.expect("REFERENCES"); err != nil {
+		return nil, err
+	}
+	ref := &catalog.ForeignKey{}
+	var err error
+	if ref.Table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	err = p.list(func() error {
+		if ref.Column != "" {
+			return p.errorf("a reference takes one column")
+		}
+		ref.Column, err = p.ident()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.accept("ON"); err != nil || ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("DELETE", "CASCADE"); err != nil {
+			return nil, err
+		}
+		ref.OnDelete = catalog.OnDeleteCascade
+	}
+	return ref, nil
+}
+
+func (p *sqlParser) createIndex(db *Database) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect("ON"); err != nil {
+		return err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return err
+	}
+	var opts catalog.IndexOptions
+	if ok, err := p.accept("USING"); err != nil || ok {
+		if err != nil {
+			return err
+		}
+		if opts.Type, err = p.indexType(); err != nil {
+			return err
+		}
+	}
+	var columns []string
+	err = p.list(func() error {
+		column, err := p.ident()
+		columns = append(columns, column)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+	_, err = db.CreateIndexWithOptions(table, name, columns, opts)
+	return err
+}
+
+func (p *sqlParser) indexType() (catalog.IndexType, error) {
+	for _, t := range []catalog.IndexType{catalog.IndexBTree, catalog.IndexZOrder} {
+		if p.is(t.String()) {
+			return t, p.advance()
+		}
+	}
+	return 0, p.errorf("unknown index type %s", p.tok)
+}
+
+func (p *sqlParser) insert(db *Database) error {
+	if err := p.expect("INSERT", "INTO"); err != nil {
+		return err
+	}
+	table, err := p.ident()
+	if err != nil {
+		return err
+	}
+	schema, err := db.catalog.GetTable(table)
+	if err != nil {
+		return err
+	}
+	if err := p.expect("VALUES"); err != nil {
+		return err
+	}
+	for {
+		var row tuple.Tuple
+		err := p.list(func() error {
+			if len(row) == len(schema.Columns) {
+				return p.errorf("too many values for table %s", table)
+			}
+			v, err := p.literal(schema.Columns[len(row)].Type)
+			row = append(row, v)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := db.insert(schema, row); err != nil {
+			return err
+		}
+		if ok, err := p.accept(","); err != nil || !ok {
+			if err != nil {
+				return err
+			}
+			return p.expect(";")
+		}
+	}
+}
+
+// literal parses a literal for a column of type t.
+func (p *sqlParser) literal(t catalog.DataType) (tuple.Value, error) {
+	tok := p.tok
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var v tuple.Value
+	var err error
+	switch {
+	case tok.kind == tokenWord && strings.EqualFold(tok.text, "NULL"):
+		return nil, nil
+	case tok.kind == tokenWord && t == catalog.TypeBoolean:
+		switch {
+		case strings.EqualFold(tok.text, "TRUE"):
+			v = true
+		case strings.EqualFold(tok.text, "FALSE"):
+			v = false
+		default:
+			err = ErrInvalidValue
+		}
+	case tok.kind == tokenNumber && t == catalog.TypeInt:
+		v, err = strconv.ParseInt(tok.text, 10, 64)
+	case (tok.kind == tokenNumber || tok.kind == tokenString) && t == catalog.TypeFloat:
+		v, err = strconv.ParseFloat(tok.text, 64)
+	case tok.kind == tokenString && t == catalog.TypeVarChar:
+		v = tok.text
+	case tok.kind == tokenString && t == catalog.TypeTimestamp:
+		v, err = tuple.ParseTimestamp(tok.text)
+	case tok.kind == tokenBlob && t == catalog.TypeBlob:
+		v, err = hex.DecodeString(tok.text)
+	default:
+		err = ErrInvalidValue
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: line %d: %s is not a %s", ErrInvalidStatement, tok.line, tok, t)
+	}
+	return v, nil
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestDumpSQL(t *testing.T) {
+	source := newTestDB(t)
+	defer source.Close()
+
+	// Children are created first under names that sort first, so that the
+	// dump has to reorder them.
+	if _, err := source.CreateTable("points", []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true, Generator: catalog.GeneratorSnowflake},
+		{Name: "x", Type: catalog.TypeInt},
+		{Name: "y", Type: catalog.TypeInt},
+		{Name: "zone", Type: catalog.TypeVarChar, Sketch: true},
+	}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := source.CreateIndexWithOptions("points", "by_xy", []string{"x", "y"}, catalog.IndexOptions{Type: catalog.IndexZOrder}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if _, err := source.CreateTableWithOptions("users", []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "full name", Type: catalog.TypeVarChar, DefaultValue: "o'neil"},
+		{Name: "score", Type: catalog.TypeFloat},
+		{Name: "avatar", Type: catalog.TypeBlob},
+		{Name: "joined", Type: catalog.TypeTimestamp},
+		{Name: "active", Type: catalog.TypeBoolean, IsNotNull: true, DefaultValue: true},
+	}, catalog.TableOptions{Layout: catalog.LayoutIndexOrganized}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := source.CreateTable("a_posts", []catalog.Column{
+		{Name: "id", Type: catalog.TypeVarChar, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "author", Type: catalog.TypeInt, References: &catalog.ForeignKey{Table: "users", Column: "id", OnDelete: catalog.OnDeleteCascade}},
+		{Name: "reply_to", Type: catalog.TypeVarChar, References: &catalog.ForeignKey{Table: "a_posts", Column: "id"}},
+	}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := source.CreateIndex("a_posts", "by_author", []string{"author"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 123000000, time.UTC)
+	rows := []struct {
+		table string
+		row   tuple.Tuple
+	}{
+		{"users", tuple.Tuple{int64(1), "line\nbreak, 'quoted'", 1.5, []byte{0, 0xff}, at, true}},
+		{"users", tuple.Tuple{int64(-2), nil, math.Inf(-1), []byte{}, nil, false}},
+		{"users", tuple.Tuple{int64(3), "\"", math.NaN(), nil, nil, true}},
+		{"users", tuple.Tuple{int64(4), "-- not a comment", 1e300, nil, nil, true}},
+		{"a_posts", tuple.Tuple{"p1", int64(1), nil}},
+		{"a_posts", tuple.Tuple{"p2", int64(-2), "p1"}},
+		{"points", tuple.Tuple{int64(10), int64(3), int64(-4), "north"}},
+	}
+	for _, r := range rows {
+		if err := source.Insert(r.table, r.row); err != nil {
+			t.Fatalf("failed to insert into %s: %v", r.table, err)
+		}
+	}
+
+	var dump bytes.Buffer
+	if err := source.DumpSQL(&dump); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	if users, posts := strings.Index(dump.String(), "CREATE TABLE users"), strings.Index(dump.String(), "CREATE TABLE a_posts"); users > posts {
+		t.Errorf("expected users before a_posts, which references it:\n%s", dump.String())
+	}
+
+	target := newTestDB(t)
+	defer target.Close()
+	if err := target.LoadSQL(bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatalf("failed to load:\n%s\n%v", dump.String(), err)
+	}
+
+	var expected, got bytes.Buffer
+	if err := source.Dump(&expected); err != nil {
+		t.Fatalf("failed to dump source: %v", err)
+	}
+	if err := target.Dump(&got); err != nil {
+		t.Fatalf("failed to dump target: %v", err)
+	}
+	if got.String() != expected.String() {
+		t.Errorf("expected the loaded database to match:\n%s\ngot:\n%s", expected.String(), got.String())
+	}
+	schema, err := target.Schema("users")
+	if err != nil {
+		t.Fatalf("failed to get schema: %v", err)
+	}
+	if schema.Layout != catalog.LayoutIndexOrganized {
+		t.Errorf("expected the layout to be kept")
+	}
+}
+
+func TestLoadSQLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  string
+		err   error
+	}{
+		{"unknown statement", "DROP TABLE t;", "line 1", ErrInvalidStatement},
+		{"unknown type", "CREATE TABLE t (id TEXT PRIMARY KEY);", "line 1", ErrInvalidStatement},
+		{"missing semicolon", "CREATE TABLE t (id INT PRIMARY KEY NOT NULL)\nINSERT INTO t VALUES (1);", "line 2", ErrInvalidStatement},
+		{"wrong literal", "-- header\nCREATE TABLE t (id INT PRIMARY KEY NOT NULL);\nINSERT INTO t VALUES ('one');", "line 3", ErrInvalidStatement},
+		{"unterminated string", "CREATE TABLE t (id VARCHAR PRIMARY KEY NOT NULL);\nINSERT INTO t VALUES ('a\n);", "line 2", ErrInvalidStatement},
+		{"too many values", "CREATE TABLE t (id INT PRIMARY KEY NOT NULL);\nINSERT INTO t VALUES (1, 2);", "line 2", ErrInvalidStatement},
+		{"missing table", "INSERT INTO t VALUES (1);", "", catalog.ErrTableNotFound},
+		{"constraint", "CREATE TABLE t (id INT PRIMARY KEY NOT NULL);\nINSERT INTO t VALUES (1), (1);", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			defer db.Close()
+
+			err := db.LoadSQL(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+			if !strings.Contains(err.Error(), tt.line) {
+				t.Errorf("expected the error to name %s, got %v", tt.line, err)
+			}
+		})
+	}
+}
+
+func FuzzLoadSQL(f *testing.F) {
+	f.Add("CREATE TABLE t (id INT PRIMARY KEY NOT NULL, name VARCHAR DEFAULT 'x');\nINSERT INTO t VALUES (1, 'a'), (2, NULL);\nCREATE INDEX by_name ON t (name);")
+	f.Add("CREATE TABLE \"a b\" (id VARCHAR PRIMARY KEY NOT NULL, f FLOAT, b BLOB, at TIMESTAMP) ORGANIZATION INDEX;\nINSERT INTO \"a b\" VALUES ('it''s', 'NaN', x'00ff', '2024-05-01 12:00:00.123');")
+	f.Add("CREATE TABLE p (id INT PRIMARY KEY NOT NULL GENERATED SNOWFLAKE, x INT, y INT);\nCREATE INDEX xy ON p USING ZORDER (x, y);\nINSERT INTO p VALUES (1, -2, 3);")
+	f.Fuzz(func(t *testing.T, input string) {
+		source := newTestDB(t)
+		defer source.Close()
+		if err := source.LoadSQL(strings.NewReader(input)); err != nil {
+			return
+		}
+
+		// Whatever loads has to come back the same from its own dump.
+		var dump bytes.Buffer
+		if err := source.DumpSQL(&dump); err != nil {
+			t.Fatalf("failed to dump: %v", err)
+		}
+		target := newTestDB(t)
+		defer target.Close()
+		if err := target.LoadSQL(bytes.NewReader(dump.Bytes())); err != nil {
+			t.Fatalf("failed to load the dump:\n%s\n%v", dump.String(), err)
+		}
+		var expected, got bytes.Buffer
+		if err := source.Dump(&expected); err != nil {
+			t.Fatalf("failed to dump source: %v", err)
+		}
+		if err := target.Dump(&got); err != nil {
+			t.Fatalf("failed to dump target: %v", err)
+		}
+		if got.String() != expected.String() {
+			t.Fatalf("expected the reloaded database to match:\n%s\ngot:\n%s", expected.String(), got.String())
+		}
+	})
+}
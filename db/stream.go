@@ -0,0 +1,297 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrInvalidLSN = errors.New("db: invalid stream position")
+
+// stream is the in-memory state of a stream table: the LSN the next row
+// gets and a channel that is closed, and replaced, on every append to wake
+// up waiting readers.
+type stream struct {
+	schema   *catalog.Schema
+	nextLSN  uint64
+	appended chan struct{}
+}
+
+// streamKey is the storage key of the row at lsn: the stream ID as 4
+// big-endian bytes and the LSN as 8, so rows sort in the order they were
+// appended.
+func streamKey(streamID uint32, lsn uint64) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint32(key, streamID)
+	binary.BigEndian.PutUint64(key[4:], lsn)
+	return key
+}
+
+// CreateStream creates a stream table: an append-only table without a
+// primary key whose rows are numbered by a log sequence number (LSN) in
+// the order they are appended, starting at 1. Streams are written with
+// Append and read with NewStreamReader.
+func (db *Database) CreateStream(name string, columns []catalog.Column) error {
+	_, err := db.catalog.CreateStream(name, columns)
+	return err
+}
+
+// loadStream returns the state of a stream, finding its next LSN from the
+// last row on first use. The caller holds streamMu.
+func (db *Database) loadStream(name string) (*stream, error) {
+	if s, ok := db.streams[name]; ok {
+		return s, nil
+	}
+
+	schema, err := db.catalog.GetStream(name)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := db.store.LastKey(streamKey(schema.ID, 0), streamKey(schema.ID+1, 0))
+	if err != nil {
+		return nil, err
+	}
+	nextLSN := max(schema.FirstLSN, 1)
+	if last != nil {
+		nextLSN = max(nextLSN, binary.BigEndian.Uint64(last[4:])+1)
+	}
+
+	s := &stream{schema: schema, nextLSN: nextLSN, appended: make(chan struct{})}
+	db.streams[name] = s
+	return s, nil
+}
+
+// Append adds a row to the end of a stream and returns its LSN. Missing
+// values take the column default, and validators run as for Insert. Each
+// row is stored with the time it was appended, for retention by age.
+//
+// Append and the Next method of stream readers may be called from
+// different goroutines, but not alongside other writes to the database.
+func (db *Database) Append(streamName string, row tuple.Tuple) (uint64, error) {
+	db.streamMu.Lock()
+	defer db.streamMu.Unlock()
+
+	s, err := db.loadStream(streamName)
+	if err != nil {
+		return 0, err
+	}
+	if len(row) != len(s.schema.Columns) {
+		return 0, ErrColumnCountMismatch
+	}
+
+	row = fillDefaults(s.schema, row)
+	if err := db.checkRow(s.schema, row); err != nil {
+		return 0, err
+	}
+	data, err := tuple.Serialize(row, s.schema)
+	if err != nil {
+		return 0, err
+	}
+	data = append(binary.BigEndian.AppendUint64(nil, uint64(db.clock.Now().UnixNano())), data...)
+
+	lsn := s.nextLSN
+	if err := db.store.Put(streamKey(s.schema.ID, lsn), data); err != nil {
+		return 0, err
+	}
+	s.nextLSN++
+
+	close(s.appended)
+	s.appended = make(chan struct{})
+
+	// Enforce MaxRows in steps of an eighth of it, so that the catalog is
+	// not written on every append.
+	if retention := s.schema.Retention; retention != nil && retention.MaxRows > 0 {
+		if s.nextLSN-s.firstLSN() > retention.MaxRows+max(retention.MaxRows/8, 1) {
+			if _, err := db.enforceRetention(s); err != nil {
+				return lsn, err
+			}
+		}
+	}
+	return lsn, nil
+}
+
+func (s *stream) firstLSN() uint64 {
+	return max(s.schema.FirstLSN, 1)
+}
+
+// SetRetention sets the retention policy of a stream and applies it, or
+// removes it if retention is nil. Append applies MaxRows as rows come in;
+// MaxAge is only applied by EnforceRetention, which has to be run from
+// time to time.
+func (db *Database) SetRetention(streamName string, retention *catalog.Retention) error {
+	db.streamMu.Lock()
+	defer db.streamMu.Unlock()
+
+	s, err := db.loadStream(streamName)
+	if err != nil {
+		return err
+	}
+	if s.schema, err = db.catalog.SetRetention(streamName, retention); err != nil {
+		return err
+	}
+
+	_, err = db.enforceRetention(s)
+	return err
+}
+
+// EnforceRetention truncates the rows of a stream that its retention
+// policy no longer keeps and returns how many there were.
+func (db *Database) EnforceRetention(streamName string) (uint64, error) {
+	db.streamMu.Lock()
+	defer db.streamMu.Unlock()
+
+	s, err := db.loadStream(streamName)
+	if err != nil {
+		return 0, err
+	}
+	return db.enforceRetention(s)
+}
+
+// enforceRetention moves the first LSN of the stream past the truncated
+// rows, drops their index entries without writing a delete record for
+// each, and empties the log segments that leaves dead. The first LSN is
+// written first, so that it keeps the rows hidden should a crash come
+// before their entries are gone, or the index be rebuilt from rows still
+// in the log. Segments that also hold live records of other tables stay.
+// The caller holds streamMu.
+func (db *Database) enforceRetention(s *stream) (uint64, error) {
+	retention := s.schema.Retention
+	if retention == nil {
+		return 0, nil
+	}
+
+	first := s.firstLSN()
+	cut := first
+	if retention.MaxRows > 0 && s.nextLSN-first > retention.MaxRows {
+		cut = s.nextLSN - retention.MaxRows
+	}
+
+	if retention.MaxAge > 0 {
+		cutoff := uint64(db.clock.Now().Add(-retention.MaxAge).UnixNano())
+		iterator, err := db.store.NewIterator(streamKey(s.schema.ID, cut), streamKey(s.schema.ID+1, 0))
+		if err != nil {
+			return 0, err
+		}
+		for {
+			key, data, err := iterator.Next()
+			if err != nil {
+				return 0, err
+			}
+			if key == nil || binary.BigEndian.Uint64(data) >= cutoff {
+				break
+			}
+			cut = binary.BigEndian.Uint64(key[4:]) + 1
+		}
+	}
+
+	if cut <= first {
+		return 0, nil
+	}
+	schema, err := db.catalog.TruncateStream(s.schema.Name, cut)
+	if err != nil {
+		return 0, err
+	}
+	s.schema = schema
+	if _, err := db.store.Forget(streamKey(s.schema.ID, 0), streamKey(s.schema.ID, cut)); err != nil {
+		return 0, err
+	}
+	if _, err := db.store.DropDeadSegments(); err != nil && !errors.Is(err, storage.ErrBatchOpen) {
+		return 0, err
+	}
+	return cut - first, nil
+}
+
+// StreamReader tails a stream, returning its rows in LSN order and waiting
+// for new ones once it has caught up.
+type StreamReader struct {
+	db      *Database
+	name    string
+	nextLSN uint64
+}
+
+// NewStreamReader returns a reader that starts at the row with the given
+// LSN, or the first row after it if that one was truncated. An LSN of 0 starts at
+// the beginning of the stream.
+func (db *Database) NewStreamReader(streamName string, from uint64) (*StreamReader, error) {
+	db.streamMu.Lock()
+	defer db.streamMu.Unlock()
+
+	s, err := db.loadStream(streamName)
+	if err != nil {
+		return nil, err
+	}
+	if from > s.nextLSN {
+		return nil, ErrInvalidLSN
+	}
+
+	return &StreamReader{db: db, name: streamName, nextLSN: from}, nil
+}
+
+// Next returns the next row of the stream and its LSN. If the reader has
+// caught up with the stream, Next waits up to timeout for a row to be
+// appended and returns a nil row if none was.
+func (r *StreamReader) Next(timeout time.Duration) (uint64, tuple.Tuple, error) {
+	var timer clock.Timer
+	for {
+		lsn, row, appended, err := r.read()
+		if err != nil || row != nil {
+			return lsn, row, err
+		}
+
+		if timeout <= 0 {
+			return 0, nil, nil
+		}
+		if timer == nil {
+			timer = r.db.clock.NewTimer(timeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-appended:
+		case <-timer.C():
+			return 0, nil, nil
+		}
+	}
+}
+
+// read returns the next row if there is one, and otherwise the channel
+// that the next append closes.
+func (r *StreamReader) read() (uint64, tuple.Tuple, <-chan struct{}, error) {
+	r.db.streamMu.Lock()
+	defer r.db.streamMu.Unlock()
+
+	s, err := r.db.loadStream(r.name)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	r.nextLSN = max(r.nextLSN, s.firstLSN())
+	if r.nextLSN >= s.nextLSN {
+		return 0, nil, s.appended, nil
+	}
+
+	iterator, err := r.db.store.NewIterator(streamKey(s.schema.ID, r.nextLSN), streamKey(s.schema.ID+1, 0))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	key, data, err := iterator.Next()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if key == nil {
+		r.nextLSN = s.nextLSN
+		return 0, nil, s.appended, nil
+	}
+
+	row, err := tuple.Deserialize(data[8:], s.schema)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	lsn := binary.BigEndian.Uint64(key[4:])
+	r.nextLSN = lsn + 1
+	return lsn, row, nil, nil
+}
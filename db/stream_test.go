@@ -0,0 +1,280 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestStream(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	clk := clock.NewVirtual(time.Now())
+	db.SetClock(clk)
+
+	columns := []catalog.Column{
+		{Name: "event", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "level", Type: catalog.TypeInt, DefaultValue: int64(1)},
+	}
+	if err := db.CreateStream("events", columns); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+
+	for i := range 3 {
+		lsn, err := db.Append("events", tuple.Tuple{fmt.Sprintf("event_%d", i), nil})
+		if err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+		if lsn != uint64(i+1) {
+			t.Errorf("expected LSN %d, got %d", i+1, lsn)
+		}
+	}
+	if _, err := db.Append("events", tuple.Tuple{nil, int64(2)}); err != ErrNotNULL {
+		t.Errorf("expected %v, got %v", ErrNotNULL, err)
+	}
+	if err := db.Insert("events", tuple.Tuple{"event", int64(1)}); err == nil {
+		t.Errorf("expected Insert into a stream to fail")
+	}
+
+	reader, err := db.NewStreamReader("events", 2)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	for i := 1; i < 3; i++ {
+		lsn, row, err := reader.Next(0)
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		expected := tuple.Tuple{fmt.Sprintf("event_%d", i), int64(1)}
+		if lsn != uint64(i+1) || !reflect.DeepEqual(row, expected) {
+			t.Errorf("expected %v at LSN %d, got %v at %d", expected, i+1, row, lsn)
+		}
+	}
+
+	timedOut := make(chan tuple.Tuple)
+	go func() {
+		_, row, err := reader.Next(time.Second)
+		if err != nil {
+			t.Errorf("failed to read: %v", err)
+		}
+		timedOut <- row
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second - time.Millisecond)
+	select {
+	case row := <-timedOut:
+		t.Fatalf("expected Next to wait for the timeout, got %v early", row)
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	if row := <-timedOut; row != nil {
+		t.Errorf("expected no row once caught up, got %v", row)
+	}
+
+	go func() {
+		clk.BlockUntil(1)
+		db.Append("events", tuple.Tuple{"late", int64(3)})
+	}()
+	lsn, row, err := reader.Next(5 * time.Second)
+	if err != nil || lsn != 4 || !reflect.DeepEqual(row, tuple.Tuple{"late", int64(3)}) {
+		t.Errorf("expected the appended row at LSN 4, got %v at %d, err %v", row, lsn, err)
+	}
+
+	db.Close()
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+
+	if lsn, err := db.Append("events", tuple.Tuple{"reopened", nil}); err != nil || lsn != 5 {
+		t.Errorf("expected LSN 5 after reopening, got %d, err %v", lsn, err)
+	}
+	if _, err := db.NewStreamReader("events", 7); err != ErrInvalidLSN {
+		t.Errorf("expected %v, got %v", ErrInvalidLSN, err)
+	}
+}
+
+func TestStreamRetention(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+
+	columns := []catalog.Column{{Name: "n", Type: catalog.TypeInt}}
+	if err := db.CreateStream("events", columns); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	if err := db.SetRetention("events", &catalog.Retention{MaxRows: 10}); err != nil {
+		t.Fatalf("failed to set retention: %v", err)
+	}
+
+	for i := range 30 {
+		if _, err := db.Append("events", tuple.Tuple{int64(i)}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+	if _, err := db.EnforceRetention("events"); err != nil {
+		t.Fatalf("failed to enforce retention: %v", err)
+	}
+
+	readAll := func(db *Database) []uint64 {
+		t.Helper()
+		reader, err := db.NewStreamReader("events", 0)
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		var lsns []uint64
+		for {
+			lsn, row, err := reader.Next(0)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if row == nil {
+				return lsns
+			}
+			if row[0] != int64(lsn-1) {
+				t.Errorf("expected row %d at LSN %d, got %v", lsn-1, lsn, row)
+			}
+			lsns = append(lsns, lsn)
+		}
+	}
+
+	expected := []uint64{21, 22, 23, 24, 25, 26, 27, 28, 29, 30}
+	if lsns := readAll(db); !reflect.DeepEqual(lsns, expected) {
+		t.Errorf("expected LSNs %v, got %v", expected, lsns)
+	}
+
+	// Rebuilding the index from the log must not bring truncated rows back.
+	db.Close()
+	if err := os.Remove(filepath.Join(dir, "clean.lock")); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "index.db")); err != nil {
+		t.Fatalf("failed to remove index file: %v", err)
+	}
+	db, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+
+	if lsns := readAll(db); !reflect.DeepEqual(lsns, expected) {
+		t.Errorf("expected LSNs %v after rebuilding the index, got %v", expected, lsns)
+	}
+
+	clk := clock.NewVirtual(time.Now())
+	db.SetClock(clk)
+	if err := db.SetRetention("events", &catalog.Retention{MaxAge: time.Hour}); err != nil {
+		t.Fatalf("failed to set retention: %v", err)
+	}
+	clk.Advance(time.Hour + time.Millisecond)
+	if lsn, err := db.Append("events", tuple.Tuple{int64(30)}); err != nil || lsn != 31 {
+		t.Fatalf("expected to append at LSN 31, got %d, err %v", lsn, err)
+	}
+	if truncated, err := db.EnforceRetention("events"); err != nil || truncated != 10 {
+		t.Errorf("expected 10 rows truncated by age, got %d, err %v", truncated, err)
+	}
+	if lsns := readAll(db); !reflect.DeepEqual(lsns, []uint64{31}) {
+		t.Errorf("expected only LSN 31 to be left, got %v", lsns)
+	}
+}
+
+func TestStreamRetentionFreesSegments(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, Options{SegmentSize: 512})
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+
+	columns := []catalog.Column{{Name: "n", Type: catalog.TypeInt}}
+	if err := db.CreateStream("events", columns); err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	if err := db.SetRetention("events", &catalog.Retention{MaxRows: 10}); err != nil {
+		t.Fatalf("failed to set retention: %v", err)
+	}
+	for i := range 300 {
+		if _, err := db.Append("events", tuple.Tuple{int64(i)}); err != nil {
+			t.Fatalf("failed to append: %v", err)
+		}
+	}
+
+	// The segments holding only truncated rows and superseded catalog
+	// entries are emptied, leaving about as many as the kept rows fill.
+	logSize := func() (size int64, used int) {
+		t.Helper()
+		matches, err := filepath.Glob(filepath.Join(dir, "data-*.log"))
+		if err != nil {
+			t.Fatalf("failed to list segments: %v", err)
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat segment: %v", err)
+			}
+			if info.Size() > 0 {
+				size += info.Size()
+				used++
+			}
+		}
+		return size, used
+	}
+	if size, used := logSize(); used > 6 || size > 6*1024 {
+		t.Errorf("expected retention to empty the old segments, got %d bytes in %d segments", size, used)
+	}
+
+	readFrom := func(db *Database) []uint64 {
+		t.Helper()
+		reader, err := db.NewStreamReader("events", 0)
+		if err != nil {
+			t.Fatalf("failed to create reader: %v", err)
+		}
+		var lsns []uint64
+		for {
+			lsn, row, err := reader.Next(0)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if row == nil {
+				return lsns
+			}
+			lsns = append(lsns, lsn)
+		}
+	}
+	lsns := readFrom(db)
+	if len(lsns) < 10 || lsns[len(lsns)-1] != 300 {
+		t.Fatalf("expected at least the last 10 rows up to LSN 300, got %v", lsns)
+	}
+
+	// Rebuilding the index from what is left of the log keeps the rows.
+	db.Close()
+	if err := os.Remove(filepath.Join(dir, "clean.lock")); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "index.db")); err != nil {
+		t.Fatalf("failed to remove index file: %v", err)
+	}
+	db, err = Open(dir, Options{SegmentSize: 512})
+	if err != nil {
+		t.Fatalf("failed to reopen db: %v", err)
+	}
+	defer db.Close()
+	if rebuilt := readFrom(db); !reflect.DeepEqual(rebuilt, lsns) {
+		t.Errorf("expected LSNs %v after rebuilding the index, got %v", lsns, rebuilt)
+	}
+	if lsn, err := db.Append("events", tuple.Tuple{int64(300)}); err != nil || lsn != 301 {
+		t.Errorf("expected to append at LSN 301, got %d, err %v", lsn, err)
+	}
+}
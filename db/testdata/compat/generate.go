@@ -0,0 +1,104 @@
+//go:build ignore
+
+// generate writes a compatibility fixture: a database directory holding
+// the rows that compatRows in compat_test.go expects. It only uses the API
+// of the first release, so that it builds against every later one. Run it
+// from a checkout of the release to capture:
+//
+//	git worktree add /tmp/toydb-old <commit>
+//	cp db/testdata/compat/generate.go /tmp/toydb-old/
+//	(cd /tmp/toydb-old && go run generate.go "$OLDPWD/db/testdata/compat/<name>")
+//	git worktree remove /tmp/toydb-old
+//
+// Formats that only show once a later feature is used are captured by
+// running it along with the generate_<name>.go file of the fixture, which
+// uses the feature through openDatabase and finish:
+//
+//	cp db/testdata/compat/generate_<name>.go /tmp/toydb-old/
+//	(cd /tmp/toydb-old && go run generate.go generate_<name>.go "$OLDPWD/db/testdata/compat/<name>")
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// openDatabase opens the fixture to write the rows to, and finish adds to
+// it once it is closed.
+var (
+	openDatabase = db.NewDatabase
+	finish       = func(dir string) error { return nil }
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: go run generate.go <dir>")
+	}
+	dir := os.Args[1]
+	if _, err := os.Stat(dir); err == nil {
+		log.Fatalf("%s exists", dir)
+	}
+
+	database, err := openDatabase(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar, IsNotNull: true},
+		{Name: "price", Type: catalog.TypeFloat},
+		{Name: "active", Type: catalog.TypeBoolean},
+		{Name: "data", Type: catalog.TypeBlob},
+	}
+	if _, err := database.CreateTable("items", columns); err != nil {
+		log.Fatal(err)
+	}
+
+	for i := range 300 {
+		if err := database.Insert("items", row(i, float64(i)/4)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i := 0; i < 300; i += 7 {
+		if err := database.Update("items", row(i, float64(i)/2)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i := 5; i < 300; i += 10 {
+		if err := database.Delete("items", int64(i)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// The first release closes the catalog after the store it writes to
+	// and fails with the store closed cleanly, so errors here are only
+	// reported.
+	if err := database.Close(); err != nil {
+		log.Printf("close: %v", err)
+	}
+	if err := finish(dir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func row(i int, price float64) tuple.Tuple {
+	var data []byte
+	switch {
+	case i%50 == 0:
+		data = bytes.Repeat(fmt.Appendf(nil, "blob_%03d ", i), 400)
+	case i%3 == 0:
+		data = nil
+	default:
+		data = fmt.Appendf(nil, "data_%03d", i)
+	}
+	var value tuple.Value
+	if data != nil {
+		value = data
+	}
+	return tuple.Tuple{int64(i), fmt.Sprintf("item_%03d", i), price, i%2 == 0, value}
+}
@@ -0,0 +1,13 @@
+//go:build ignore
+
+// generate_compressed-pages.go extends generate.go with an index file of
+// compressed pages.
+package main
+
+import "github.com/rizalta/toydb/db"
+
+func init() {
+	openDatabase = func(dir string) (*db.Database, error) {
+		return db.Open(dir, db.Options{CompressPages: true})
+	}
+}
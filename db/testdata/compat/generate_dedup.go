@@ -0,0 +1,34 @@
+//go:build ignore
+
+// generate_dedup.go extends generate.go with deduplicated values: once the
+// rows are written, it stores one value under three keys of the key-value
+// namespace and deletes one of them, which leaves the value stored once
+// with two references.
+package main
+
+import (
+	"bytes"
+
+	"github.com/rizalta/toydb/storage"
+)
+
+func init() {
+	finish = func(dir string) error {
+		s, err := storage.NewStoreWithOptions(dir, storage.Options{DedupThreshold: 64})
+		if err != nil {
+			return err
+		}
+		value := bytes.Repeat([]byte("shared "), 100)
+		for _, key := range []string{"kv:dedup-a", "kv:dedup-b", "kv:dedup-c"} {
+			if err := s.Put([]byte(key), value); err != nil {
+				s.Close()
+				return err
+			}
+		}
+		if _, err := s.Delete([]byte("kv:dedup-c")); err != nil {
+			s.Close()
+			return err
+		}
+		return s.Close()
+	}
+}
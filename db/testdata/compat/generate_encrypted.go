@@ -0,0 +1,13 @@
+//go:build ignore
+
+// generate_encrypted.go extends generate.go with encrypted index pages and
+// records, under the key compatKey of compat_test.go.
+package main
+
+import "github.com/rizalta/toydb/db"
+
+func init() {
+	openDatabase = func(dir string) (*db.Database, error) {
+		return db.Open(dir, db.Options{EncryptionKey: []byte("toydb compatibility fixture key!")})
+	}
+}
@@ -0,0 +1,29 @@
+//go:build ignore
+
+// generate_merge.go extends generate.go with merge records: once the rows
+// are written, it adds 1 to 5 to a counter of the key-value namespace,
+// which leaves a chain of five operands.
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/rizalta/toydb/storage"
+)
+
+func init() {
+	finish = func(dir string) error {
+		s, err := storage.NewStore(dir)
+		if err != nil {
+			return err
+		}
+		for i := range 5 {
+			operand := binary.BigEndian.AppendUint64(nil, uint64(i+1))
+			if err := s.Merge([]byte("kv:counter"), storage.MergeCounterAdd, operand); err != nil {
+				s.Close()
+				return err
+			}
+		}
+		return s.Close()
+	}
+}
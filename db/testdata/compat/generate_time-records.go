@@ -0,0 +1,23 @@
+//go:build ignore
+
+// generate_time-records.go extends generate.go with the time records a
+// database that archives its log stamps into it. The archive itself is
+// left out of the fixture.
+package main
+
+import (
+	"os"
+
+	"github.com/rizalta/toydb/db"
+)
+
+func init() {
+	openDatabase = func(dir string) (*db.Database, error) {
+		archive, err := os.MkdirTemp("", "archive")
+		if err != nil {
+			return nil, err
+		}
+		finish = func(string) error { return os.RemoveAll(archive) }
+		return db.Open(dir, db.Options{ArchiveDir: archive})
+	}
+}
@@ -0,0 +1,30 @@
+//go:build ignore
+
+// generate_ttl.go extends generate.go with expiring keys: once the rows
+// are written, it stores a key of the key-value namespace that expires in
+// a hundred years and one that has expired by the time it is read.
+package main
+
+import (
+	"time"
+
+	"github.com/rizalta/toydb/storage"
+)
+
+func init() {
+	finish = func(dir string) error {
+		s, err := storage.NewStore(dir)
+		if err != nil {
+			return err
+		}
+		if err := s.PutWithTTL([]byte("kv:ttl-live"), []byte("live"), 100*365*24*time.Hour); err != nil {
+			s.Close()
+			return err
+		}
+		if err := s.PutWithTTL([]byte("kv:ttl-gone"), []byte("gone"), time.Nanosecond); err != nil {
+			s.Close()
+			return err
+		}
+		return s.Close()
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var ErrTransformPrimaryKey = errors.New("db: primary key cannot be transformed")
+
+// Transformer changes the values of a column on their way to and from
+// storage, for example to encrypt or compress them. Decode has to undo
+// Encode, or deliberately not, as when masking values on read. Both must
+// return values of the column type. NULLs are passed through untouched.
+type Transformer interface {
+	Encode(value tuple.Value) (tuple.Value, error)
+	Decode(value tuple.Value) (tuple.Value, error)
+}
+
+type columnTransformer struct {
+	column      string
+	transformer Transformer
+}
+
+// RegisterTransformer applies t to a column of a table, or to every column
+// but the primary key if column is empty. Rows are encoded right before
+// they are serialized and decoded right after they are read, so every read
+// path, from Get to joins, sees decoded values, and so do indexes and
+// other data derived from the rows. Transformers of a table encode in the
+// order they were registered and decode in reverse.
+func (db *Database) RegisterTransformer(tableName, column string, t Transformer) error {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	if column != "" {
+		colIdx := columnIndex(schema, column)
+		if colIdx == -1 {
+			return ErrColumnNotFound
+		}
+		if colIdx == schema.PrimaryKeyIndex {
+			return ErrTransformPrimaryKey
+		}
+	}
+
+	db.transformers[tableName] = append(db.transformers[tableName], columnTransformer{column: column, transformer: t})
+	return nil
+}
+
+func (ct columnTransformer) applies(schema *catalog.Schema, colIdx int) bool {
+	if colIdx == schema.PrimaryKeyIndex {
+		return false
+	}
+	return ct.column == "" || ct.column == schema.Columns[colIdx].Name
+}
+
+// encodeRow serializes a row for storage, encoding it with the
+// transformers of its table first.
+func (db *Database) encodeRow(schema *catalog.Schema, row tuple.Tuple) ([]byte, error) {
+	transformers := db.transformers[schema.Name]
+	if len(transformers) == 0 {
+		return tuple.Serialize(row, schema)
+	}
+
+	encoded := make(tuple.Tuple, len(row))
+	copy(encoded, row)
+	for _, ct := range transformers {
+		for i, value := range encoded {
+			if value == nil || !ct.applies(schema, i) {
+				continue
+			}
+			v, err := ct.transformer.Encode(value)
+			if err != nil {
+				return nil, err
+			}
+			encoded[i] = v
+		}
+	}
+
+	return tuple.Serialize(encoded, schema)
+}
+
+// decodeRow deserializes a stored row and decodes it with the transformers
+// of its table.
+func (db *Database) decodeRow(schema *catalog.Schema, data []byte) (tuple.Tuple, error) {
+	row, err := tuple.Deserialize(data, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	transformers := db.transformers[schema.Name]
+	for j := len(transformers) - 1; j >= 0; j-- {
+		ct := transformers[j]
+		for i, value := range row {
+			if value == nil || !ct.applies(schema, i) {
+				continue
+			}
+			v, err := ct.transformer.Decode(value)
+			if err != nil {
+				return nil, err
+			}
+			row[i] = v
+		}
+	}
+
+	return row, nil
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// rot13 stands in for encryption: it hides the stored text and undoes
+// itself on read.
+type rot13 struct{}
+
+func (rot13) Encode(v tuple.Value) (tuple.Value, error) {
+	return strings.Map(rotate, v.(string)), nil
+}
+
+func (rot13) Decode(v tuple.Value) (tuple.Value, error) {
+	return strings.Map(rotate, v.(string)), nil
+}
+
+func rotate(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return 'a' + (r-'a'+13)%26
+	case r >= 'A' && r <= 'Z':
+		return 'A' + (r-'A'+13)%26
+	}
+	return r
+}
+
+// maskSSN hides all but the last digits of a value on read.
+type maskSSN struct{}
+
+func (maskSSN) Encode(v tuple.Value) (tuple.Value, error) {
+	return v, nil
+}
+
+func (maskSSN) Decode(v tuple.Value) (tuple.Value, error) {
+	s := v.(string)
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:], nil
+}
+
+func TestTransformers(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+		{Name: "ssn", Type: catalog.TypeVarChar},
+	}
+	schema, err := db.CreateTable("patients", columns)
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndex("patients", "by_name", []string{"name"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	if err := db.RegisterTransformer("patients", "id", rot13{}); !errors.Is(err, ErrTransformPrimaryKey) {
+		t.Errorf("expected %v, got %v", ErrTransformPrimaryKey, err)
+	}
+	if err := db.RegisterTransformer("patients", "missing", rot13{}); !errors.Is(err, ErrColumnNotFound) {
+		t.Errorf("expected %v, got %v", ErrColumnNotFound, err)
+	}
+	if err := db.RegisterTransformer("patients", "", rot13{}); err != nil {
+		t.Fatalf("failed to register transformer: %v", err)
+	}
+	if err := db.RegisterTransformer("patients", "ssn", maskSSN{}); err != nil {
+		t.Fatalf("failed to register transformer: %v", err)
+	}
+
+	rows := []tuple.Tuple{
+		{int64(1), "Alice", "123-45-6789"},
+		{int64(2), "Bob", nil},
+	}
+	for _, row := range rows {
+		if err := db.Insert("patients", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+	}
+
+	key, _ := EncodeKey(schema.ID, int64(1))
+	stored, _, err := db.store.Get(key)
+	if err != nil {
+		t.Fatalf("failed to read stored row: %v", err)
+	}
+	if bytes.Contains(stored, []byte("Alice")) || !bytes.Contains(stored, []byte("Nyvpr")) {
+		t.Errorf("expected the stored row to hold the encoded name, got %q", stored)
+	}
+
+	expected := tuple.Tuple{int64(1), "Alice", "*******6789"}
+	row, _, err := db.Get("patients", int64(1))
+	if err != nil {
+		t.Fatalf("failed to get row: %v", err)
+	}
+	if !reflect.DeepEqual(row, expected) {
+		t.Errorf("expected %v from Get, got %v", expected, row)
+	}
+
+	result, err := db.Query("patients", QueryOptions{Filter: Where("name", OpEqual, "Alice")})
+	if err != nil {
+		t.Fatalf("failed to query: %v", err)
+	}
+	if got := collectRows(t, result); len(got) != 1 || !reflect.DeepEqual(got[0], expected) {
+		t.Errorf("expected %v through the index, got %v", expected, got)
+	}
+
+	scanner, err := db.Scan("patients", nil, nil, Where("ssn", OpIsNull, nil))
+	if err != nil {
+		t.Fatalf("failed to scan: %v", err)
+	}
+	if got := collectRows(t, scanner); len(got) != 1 || !reflect.DeepEqual(got[0], rows[1]) {
+		t.Errorf("expected %v from Scan, got %v", rows[1], got)
+	}
+}
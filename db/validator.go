@@ -0,0 +1,38 @@
+package db
+
+import (
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// Validator checks a row about to be written to a table and returns an
+// error to reject it. It sees the row as it will be stored, after default
+// values are filled in.
+type Validator func(table string, row tuple.Tuple) error
+
+// RegisterValidator adds a validator that runs on every row written by
+// Insert, InsertNamed, Update and RewriteTable, before the engine checks
+// its own constraints. Validators run in the order they were registered
+// and the first error is returned unchanged.
+func (db *Database) RegisterValidator(v Validator) {
+	db.validators = append(db.validators, v)
+}
+
+// checkRow runs the registered validators, the CHECK constraints and then
+// the schema constraints on a row.
+func (db *Database) checkRow(schema *catalog.Schema, row tuple.Tuple) error {
+	if len(row) != len(schema.Columns) {
+		return ErrColumnCountMismatch
+	}
+
+	for _, v := range db.validators {
+		if err := v(schema.Name, row); err != nil {
+			return err
+		}
+	}
+	if err := db.checkConstraints(schema, row); err != nil {
+		return err
+	}
+
+	return validateRow(schema, row)
+}
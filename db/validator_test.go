@@ -0,0 +1,91 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestValidators(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "email", Type: catalog.TypeVarChar},
+		{Name: "role", Type: catalog.TypeVarChar, IsNotNull: true, DefaultValue: "member"},
+	}
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateTable("notes", []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "email", Type: catalog.TypeVarChar},
+	}); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	errBadEmail := errors.New("bad email")
+	errBadRole := errors.New("bad role")
+	var seen []tuple.Tuple
+	db.RegisterValidator(func(table string, row tuple.Tuple) error {
+		if table != "users" {
+			return nil
+		}
+		seen = append(seen, row)
+		if email, ok := row[1].(string); ok && !strings.Contains(email, "@") {
+			return errBadEmail
+		}
+		return nil
+	})
+	db.RegisterValidator(func(table string, row tuple.Tuple) error {
+		if table == "users" && row[2] != "member" && row[2] != "admin" {
+			return errBadRole
+		}
+		return nil
+	})
+
+	tests := []struct {
+		name     string
+		write    func() error
+		expected error
+	}{
+		{"valid insert", func() error { return db.Insert("users", tuple.Tuple{int64(1), "a@example.com", "admin"}) }, nil},
+		{"default filled before validation", func() error { return db.Insert("users", tuple.Tuple{int64(2), nil, nil}) }, nil},
+		{"first validator rejects", func() error { return db.Insert("users", tuple.Tuple{int64(3), "nope", "owner"}) }, errBadEmail},
+		{"second validator rejects", func() error { return db.Insert("users", tuple.Tuple{int64(3), "c@example.com", "owner"}) }, errBadRole},
+		{"validators run before constraints", func() error { return db.Update("users", tuple.Tuple{int64(1), "nope", nil}) }, errBadEmail},
+		{"update rejected", func() error { return db.Update("users", tuple.Tuple{int64(1), "a@example.com", "owner"}) }, errBadRole},
+		{"named insert rejected", func() error {
+			return db.InsertNamed("users", []string{"id", "email"}, tuple.Tuple{int64(4), "nope"})
+		}, errBadEmail},
+		{"other table unaffected", func() error { return db.Insert("notes", tuple.Tuple{int64(1), "nope"}) }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.write(); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if seen[1][2] != "member" {
+		t.Errorf("expected validator to see the default role, got %v", seen[1][2])
+	}
+	for _, id := range []int64{3, 4} {
+		if _, found, err := db.Get("users", id); err != nil || found {
+			t.Errorf("expected rejected row %d not to be written, got found=%v err=%v", id, found, err)
+		}
+	}
+	row, _, err := db.Get("users", int64(1))
+	if err != nil {
+		t.Fatalf("failed to get row: %v", err)
+	}
+	if row[2] != "admin" {
+		t.Errorf("expected rejected update to leave the row alone, got %v", row)
+	}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"sync"
+
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// RowChange is a write to a row of a watched table. Old is nil for rows
+// that did not exist before and New for deleted rows.
+type RowChange struct {
+	Op  storage.ChangeOp
+	Old tuple.Tuple
+	New tuple.Tuple
+}
+
+// TableWatcher receives the changes to the rows of a table on C, in the
+// order they were written. C is closed when the watcher or the database is
+// closed, or when it falls behind by more than storage.WatchBuffer
+// changes, which Err then reports.
+type TableWatcher struct {
+	C       <-chan RowChange
+	watcher *storage.Watcher
+	done    chan struct{}
+	stop    sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// WatchTable returns a watcher of the rows of a table. Rows are decoded
+// with the schema the table has now, so a watcher has to be made again
+// after the table is rewritten.
+func (db *Database) WatchTable(tableName string) (*TableWatcher, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan RowChange)
+	w := &TableWatcher{
+		C:       c,
+		watcher: db.store.Watch(tablePrefix(schema.ID)),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(c)
+		for change := range w.watcher.C {
+			rowChange := RowChange{Op: change.Op}
+			var err error
+			if change.OldValue != nil {
+				rowChange.Old, err = db.decodeRow(schema, change.OldValue)
+			}
+			if err == nil && change.NewValue != nil {
+				rowChange.New, err = db.decodeRow(schema, change.NewValue)
+			}
+			if err != nil {
+				w.fail(err)
+				return
+			}
+			select {
+			case c <- rowChange:
+			case <-w.done:
+				return
+			}
+		}
+		w.fail(w.watcher.Err())
+	}()
+	return w, nil
+}
+
+func (w *TableWatcher) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.watcher.Close()
+}
+
+// Close stops the watcher and closes C.
+func (w *TableWatcher) Close() {
+	w.stop.Do(func() {
+		close(w.done)
+		w.watcher.Close()
+	})
+}
+
+// Err returns why C was closed early: storage.ErrWatchOverflow, or the
+// error a row failed to decode with.
+func (w *TableWatcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
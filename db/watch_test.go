@@ -0,0 +1,70 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestWatchTable(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	for _, table := range []string{"users", "other"} {
+		if _, err := db.CreateTable(table, columns); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+	if _, err := db.CreateIndex("users", "by_name", []string{"name"}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	w, err := db.WatchTable("users")
+	if err != nil {
+		t.Fatalf("failed to watch: %v", err)
+	}
+	if err := db.Insert("users", tuple.Tuple{int64(1), "alice"}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Insert("other", tuple.Tuple{int64(1), "ignored"}); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := db.Update("users", tuple.Tuple{int64(1), "alicia"}); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if err := db.Delete("users", int64(1)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	expected := []RowChange{
+		{Op: storage.ChangePut, New: tuple.Tuple{int64(1), "alice"}},
+		{Op: storage.ChangePut, Old: tuple.Tuple{int64(1), "alice"}, New: tuple.Tuple{int64(1), "alicia"}},
+		{Op: storage.ChangeDelete, Old: tuple.Tuple{int64(1), "alicia"}},
+	}
+	for _, change := range expected {
+		select {
+		case got := <-w.C:
+			if !reflect.DeepEqual(got, change) {
+				t.Errorf("expected %+v, got %+v", change, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %+v", change)
+		}
+	}
+
+	w.Close()
+	for range w.C {
+		t.Errorf("expected no changes left after close")
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("expected a clean close, got %v", err)
+	}
+}
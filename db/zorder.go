@@ -0,0 +1,243 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// zcode is a 128-bit Morton code, most significant word first. Bit 2k+1
+// holds bit k of the first coordinate and bit 2k bit k of the second.
+type zcode [2]uint64
+
+// zOrderCoordinate maps an int64 or timestamp to an unsigned coordinate
+// with the same order.
+func zOrderCoordinate(v tuple.Value) (uint64, error) {
+	switch v := v.(type) {
+	case int64:
+		return uint64(v) ^ signBit, nil
+	case time.Time:
+		nanos, err := tuple.TimestampNanos(v)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(nanos) ^ signBit, nil
+	default:
+		return 0, nil
+	}
+}
+
+func spreadBits(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+func compactBits(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
+
+func mortonEncode(x, y uint64) zcode {
+	return zcode{
+		spreadBits(uint32(x>>32))<<1 | spreadBits(uint32(y>>32)),
+		spreadBits(uint32(x))<<1 | spreadBits(uint32(y)),
+	}
+}
+
+func mortonDecode(z zcode) (uint64, uint64) {
+	x := uint64(compactBits(z[0]>>1))<<32 | uint64(compactBits(z[1]>>1))
+	y := uint64(compactBits(z[0]))<<32 | uint64(compactBits(z[1]))
+	return x, y
+}
+
+func appendZOrder(buf []byte, z zcode) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, z[0])
+	return binary.BigEndian.AppendUint64(buf, z[1])
+}
+
+func readZOrder(buf []byte) zcode {
+	return zcode{binary.BigEndian.Uint64(buf), binary.BigEndian.Uint64(buf[8:])}
+}
+
+func (z zcode) bit(i int) bool {
+	return z[1-i/64]>>(i%64)&1 == 1
+}
+
+func (z *zcode) setBit(i int, set bool) {
+	if set {
+		z[1-i/64] |= 1 << (i % 64)
+	} else {
+		z[1-i/64] &^= 1 << (i % 64)
+	}
+}
+
+// load sets bit i of z to set and the lower bits of the same coordinate
+// to the opposite, giving the smallest (set) or largest (not set) code
+// with the higher bits of z.
+func (z zcode) load(i int, set bool) zcode {
+	z.setBit(i, set)
+	for j := i - 2; j >= 0; j -= 2 {
+		z.setBit(j, !set)
+	}
+	return z
+}
+
+// bigMin returns the smallest Morton code greater than z that lies inside
+// the box with corners low and high, following Tropf and Herzog. It
+// reports false if there is none.
+func bigMin(z, low, high zcode) (zcode, bool) {
+	var result zcode
+	found := false
+
+	for i := 127; i >= 0; i-- {
+		switch zb, lb, hb := z.bit(i), low.bit(i), high.bit(i); {
+		case !zb && !lb && hb:
+			result, found = low.load(i, true), true
+			high = high.load(i, false)
+		case !zb && lb && hb:
+			return low, true
+		case zb && !lb && !hb:
+			return result, found
+		case zb && !lb && hb:
+			low = low.load(i, true)
+		}
+	}
+
+	return result, found
+}
+
+// ScanBox returns the rows whose two z-order index columns lie within low
+// and high, inclusive. Only the index entries along the Morton curve
+// between the corners of the box are read, skipping ahead whenever the
+// curve leaves the box.
+func (db *Database) ScanBox(tableName, indexName string, low, high tuple.Tuple) (Rows, error) {
+	schema, err := db.catalog.GetTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := findIndex(schema, indexName)
+	if err != nil {
+		return nil, err
+	}
+	if info.Type != catalog.IndexZOrder {
+		return nil, ErrIndexType
+	}
+
+	if len(low) != 2 || len(high) != 2 {
+		return nil, ErrInvalidFilter
+	}
+	var lowCoords, highCoords [2]uint64
+	for i, name := range info.Columns {
+		colType := schema.Columns[columnIndex(schema, name)].Type
+		if !isTypeMatch(colType, low[i]) || !isTypeMatch(colType, high[i]) {
+			return nil, ErrInvalidFilter
+		}
+		if lowCoords[i], err = zOrderCoordinate(low[i]); err != nil {
+			return nil, err
+		}
+		if highCoords[i], err = zOrderCoordinate(high[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	box := &boxRows{
+		db:     db,
+		schema: schema,
+		prefix: indexEntryPrefix(info.ID),
+		low:    lowCoords,
+		high:   highCoords,
+		zLow:   mortonEncode(lowCoords[0], lowCoords[1]),
+		zHigh:  mortonEncode(highCoords[0], highCoords[1]),
+	}
+	box.done = lowCoords[0] > highCoords[0] || lowCoords[1] > highCoords[1]
+	if !box.done {
+		if err := box.seek(box.zLow); err != nil {
+			return nil, err
+		}
+	}
+
+	return box, nil
+}
+
+type boxRows struct {
+	db     *Database
+	schema *catalog.Schema
+	prefix []byte
+
+	low, high   [2]uint64
+	zLow, zHigh zcode
+
+	iterator *storage.Iterator
+	done     bool
+}
+
+func (b *boxRows) seek(z zcode) error {
+	startKey := appendZOrder(bytes.Clone(b.prefix), z)
+	endKey := prefixEnd(appendZOrder(bytes.Clone(b.prefix), b.zHigh))
+
+	iterator, err := b.db.store.NewIterator(startKey, endKey)
+	if err != nil {
+		return err
+	}
+	b.iterator = iterator
+	return nil
+}
+
+func (b *boxRows) Next() (tuple.Tuple, error) {
+	for !b.done {
+		entry, _, err := b.iterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			b.done = true
+			break
+		}
+
+		z := readZOrder(entry[len(b.prefix):])
+		x, y := mortonDecode(z)
+		if x < b.low[0] || x > b.high[0] || y < b.low[1] || y > b.high[1] {
+			next, found := bigMin(z, b.zLow, b.zHigh)
+			if !found {
+				b.done = true
+				break
+			}
+			if err := b.seek(next); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		key := entry[len(b.prefix)+16:]
+		value, found, err := b.db.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		return b.db.decodeRow(b.schema, value)
+	}
+
+	return nil, nil
+}
+
+func (b *boxRows) Close() error {
+	b.done = true
+	return nil
+}
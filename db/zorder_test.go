@@ -0,0 +1,157 @@
+package db
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+func TestMortonEncode(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for range 1000 {
+		x, y := r.Uint64(), r.Uint64()
+		z := mortonEncode(x, y)
+		if gx, gy := mortonDecode(z); gx != x || gy != y {
+			t.Fatalf("expected (%d, %d) to round trip, got (%d, %d)", x, y, gx, gy)
+		}
+	}
+
+	if z := mortonEncode(1, 0); z != (zcode{0, 2}) {
+		t.Errorf("expected x to take the odd bits, got %v", z)
+	}
+	if z := mortonEncode(0, 1<<63); z != (zcode{1 << 62, 0}) {
+		t.Errorf("expected high bits of y in the first word, got %v", z)
+	}
+}
+
+func TestBigMin(t *testing.T) {
+	const size = 16
+	low, high := [2]uint64{3, 5}, [2]uint64{10, 9}
+	zLow, zHigh := mortonEncode(low[0], low[1]), mortonEncode(high[0], high[1])
+
+	var inside []zcode
+	for x := uint64(0); x < size; x++ {
+		for y := uint64(0); y < size; y++ {
+			if x >= low[0] && x <= high[0] && y >= low[1] && y <= high[1] {
+				inside = append(inside, mortonEncode(x, y))
+			}
+		}
+	}
+	slices.SortFunc(inside, func(a, b zcode) int {
+		return slices.Compare(a[:], b[:])
+	})
+
+	for x := uint64(0); x < size; x++ {
+		for y := uint64(0); y < size; y++ {
+			z := mortonEncode(x, y)
+			if x >= low[0] && x <= high[0] && y >= low[1] && y <= high[1] {
+				continue
+			}
+			if slices.Compare(z[:], zLow[:]) < 0 || slices.Compare(z[:], zHigh[:]) > 0 {
+				continue
+			}
+
+			i, _ := slices.BinarySearchFunc(inside, z, func(a, b zcode) int {
+				return slices.Compare(a[:], b[:])
+			})
+			next, found := bigMin(z, zLow, zHigh)
+			if i == len(inside) {
+				if found {
+					t.Errorf("expected no code after (%d, %d), got %v", x, y, next)
+				}
+				continue
+			}
+			if !found || next != inside[i] {
+				t.Errorf("expected next code after (%d, %d) to be %v, got %v (found %v)", x, y, inside[i], next, found)
+			}
+		}
+	}
+}
+
+func TestScanBox(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "lat", Type: catalog.TypeInt},
+		{Name: "lon", Type: catalog.TypeInt},
+		{Name: "seen", Type: catalog.TypeTimestamp},
+	}
+	if _, err := db.CreateTable("places", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	if _, err := db.CreateIndexWithOptions("places", "geo", []string{"lat", "lon"}, catalog.IndexOptions{Type: catalog.IndexZOrder}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if _, err := db.CreateIndexWithOptions("places", "time_id", []string{"seen", "id"}, catalog.IndexOptions{Type: catalog.IndexZOrder}); err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := rand.New(rand.NewSource(7))
+	var rows []tuple.Tuple
+	for i := range 1500 {
+		row := tuple.Tuple{int64(i), int64(r.Intn(400) - 200), int64(r.Intn(400) - 200), base.Add(time.Duration(r.Intn(1000)) * time.Minute)}
+		if i%100 == 0 {
+			row[2] = nil
+		}
+		if err := db.Insert("places", row); err != nil {
+			t.Fatalf("failed to insert %v: %v", row, err)
+		}
+		rows = append(rows, row)
+	}
+
+	within := func(v, low, high tuple.Value) bool {
+		return v != nil && tuple.Compare(v, low) >= 0 && tuple.Compare(v, high) <= 0
+	}
+
+	tests := []struct {
+		name      string
+		index     string
+		cols      [2]int
+		low, high tuple.Tuple
+	}{
+		{"box across zero", "geo", [2]int{1, 2}, tuple.Tuple{int64(-30), int64(-50)}, tuple.Tuple{int64(25), int64(10)}},
+		{"thin box", "geo", [2]int{1, 2}, tuple.Tuple{int64(-200), int64(7)}, tuple.Tuple{int64(200), int64(7)}},
+		{"single point", "geo", [2]int{1, 2}, rows[42][1:3], rows[42][1:3]},
+		{"empty box", "geo", [2]int{1, 2}, tuple.Tuple{int64(5), int64(5)}, tuple.Tuple{int64(4), int64(9)}},
+		{"time and id", "time_id", [2]int{3, 0}, tuple.Tuple{base.Add(100 * time.Minute), int64(200)}, tuple.Tuple{base.Add(300 * time.Minute), int64(900)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expected []tuple.Tuple
+			for _, row := range rows {
+				if within(row[tt.cols[0]], tt.low[0], tt.high[0]) && within(row[tt.cols[1]], tt.low[1], tt.high[1]) {
+					expected = append(expected, row)
+				}
+			}
+
+			result, err := db.ScanBox("places", tt.index, tt.low, tt.high)
+			if err != nil {
+				t.Fatalf("failed to scan box: %v", err)
+			}
+			got := collectRows(t, result)
+			slices.SortFunc(got, func(a, b tuple.Tuple) int {
+				return tuple.Compare(a[0], b[0])
+			})
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %d rows, got %d", len(expected), len(got))
+			}
+		})
+	}
+
+	if _, err := db.ScanBox("places", "PRIMARY", tuple.Tuple{int64(0), int64(0)}, tuple.Tuple{int64(1), int64(1)}); !errors.Is(err, ErrIndexType) {
+		t.Errorf("expected %v, got %v", ErrIndexType, err)
+	}
+	if _, err := db.ScanBox("places", "geo", tuple.Tuple{int64(0), "x"}, tuple.Tuple{int64(1), int64(1)}); !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("expected %v, got %v", ErrInvalidFilter, err)
+	}
+}
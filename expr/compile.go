@@ -0,0 +1,538 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var (
+	ErrColumnNotFound  = errors.New("expr: column not found")
+	ErrTypeMismatch    = errors.New("expr: operand types do not match")
+	ErrInvalidLiteral  = errors.New("expr: unsupported literal type")
+	ErrUnknownFunction = errors.New("expr: unknown function")
+	ErrArgumentCount   = errors.New("expr: wrong number of arguments")
+	ErrDivisionByZero  = errors.New("expr: division by zero")
+)
+
+// typeNull is the type of a NULL literal, which fits wherever a value of
+// any type does.
+const typeNull catalog.DataType = math.MaxUint8
+
+type evalFunc func(row tuple.Tuple) (tuple.Value, error)
+
+type node struct {
+	typ  catalog.DataType
+	eval evalFunc
+}
+
+// Program is an expression compiled against a schema, ready to be
+// evaluated on its rows.
+type Program struct {
+	expr Expr
+	node
+}
+
+// Compile checks the types in an expression against a schema and prepares
+// it for evaluation. NULLs propagate as in SQL: an operator or function
+// with a NULL operand gives NULL, except for IS NULL, IS NOT NULL,
+// COALESCE, and AND and OR when the other operand decides the result.
+//
+// Comparisons and arithmetic take operands of the same type, or an int
+// and a float, in which case the int is converted. The functions are
+// LOWER, UPPER and TRIM of a string, LENGTH of a string or blob, CONCAT of
+// one or more strings, SUBSTR(s, start[, length]) with a 1-based start,
+// CONTAINS(s, substr), ABS of a number and COALESCE of one or more values
+// of one type.
+func Compile(e Expr, schema *catalog.Schema) (*Program, error) {
+	n, err := compile(e, schema)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{expr: e, node: n}, nil
+}
+
+// Type returns the type of the values the program evaluates to.
+func (p *Program) Type() catalog.DataType {
+	return p.typ
+}
+
+func (p *Program) Eval(row tuple.Tuple) (tuple.Value, error) {
+	return p.eval(row)
+}
+
+// Match evaluates a boolean program as a WHERE clause would: only true
+// matches, and NULL does not.
+func (p *Program) Match(row tuple.Tuple) (bool, error) {
+	v, err := p.eval(row)
+	if err != nil {
+		return false, err
+	}
+	b, _ := v.(bool)
+	return b, nil
+}
+
+func (p *Program) String() string {
+	return p.expr.String()
+}
+
+func compile(e Expr, schema *catalog.Schema) (node, error) {
+	switch e := e.(type) {
+	case Column:
+		for i, c := range schema.Columns {
+			if c.Name == e.Name {
+				return node{typ: c.Type, eval: func(row tuple.Tuple) (tuple.Value, error) {
+					return row[i], nil
+				}}, nil
+			}
+		}
+		return node{}, fmt.Errorf("%w: %s", ErrColumnNotFound, e.Name)
+	case Literal:
+		typ, ok := typeOf(e.Value)
+		if !ok {
+			return node{}, fmt.Errorf("%w: %T", ErrInvalidLiteral, e.Value)
+		}
+		value := e.Value
+		return node{typ: typ, eval: func(tuple.Tuple) (tuple.Value, error) {
+			return value, nil
+		}}, nil
+	case Binary:
+		return compileBinary(e, schema)
+	case Unary:
+		return compileUnary(e, schema)
+	case Call:
+		return compileCall(e, schema)
+	default:
+		return node{}, fmt.Errorf("expr: unknown node %T", e)
+	}
+}
+
+func typeOf(v tuple.Value) (catalog.DataType, bool) {
+	switch v.(type) {
+	case nil:
+		return typeNull, true
+	case int64:
+		return catalog.TypeInt, true
+	case float64:
+		return catalog.TypeFloat, true
+	case string:
+		return catalog.TypeVarChar, true
+	case bool:
+		return catalog.TypeBoolean, true
+	case []byte:
+		return catalog.TypeBlob, true
+	case time.Time:
+		return catalog.TypeTimestamp, true
+	default:
+		return 0, false
+	}
+}
+
+func isNumeric(t catalog.DataType) bool {
+	return t == catalog.TypeInt || t == catalog.TypeFloat || t == typeNull
+}
+
+// unify returns the type two operands are compared or combined in, or
+// false if they do not fit together.
+func unify(a, b catalog.DataType) (catalog.DataType, bool) {
+	switch {
+	case a == typeNull:
+		return b, true
+	case b == typeNull, a == b:
+		return a, true
+	case isNumeric(a) && isNumeric(b):
+		return catalog.TypeFloat, true
+	default:
+		return 0, false
+	}
+}
+
+func toFloat(v tuple.Value) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+func compileBinary(e Binary, schema *catalog.Schema) (node, error) {
+	left, err := compile(e.Left, schema)
+	if err != nil {
+		return node{}, err
+	}
+	right, err := compile(e.Right, schema)
+	if err != nil {
+		return node{}, err
+	}
+	mismatch := fmt.Errorf("%w: %s", ErrTypeMismatch, e)
+
+	switch e.Op {
+	case OpAnd, OpOr:
+		if !isBoolean(left.typ) || !isBoolean(right.typ) {
+			return node{}, mismatch
+		}
+		return node{typ: catalog.TypeBoolean, eval: logical(e.Op, left.eval, right.eval)}, nil
+	case OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		typ, ok := unify(left.typ, right.typ)
+		if !ok {
+			return node{}, mismatch
+		}
+		promote := typ == catalog.TypeFloat && (left.typ == catalog.TypeInt || right.typ == catalog.TypeInt)
+		op := e.Op
+		return node{typ: catalog.TypeBoolean, eval: binary(left.eval, right.eval, func(a, b tuple.Value) (tuple.Value, error) {
+			var c int
+			if promote {
+				c = tuple.Compare(toFloat(a), toFloat(b))
+			} else {
+				c = tuple.Compare(a, b)
+			}
+			return compareResult(op, c), nil
+		})}, nil
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod:
+		typ, ok := unify(left.typ, right.typ)
+		if !ok || !isNumeric(typ) {
+			return node{}, mismatch
+		}
+		op := e.Op
+		if typ == catalog.TypeInt || typ == typeNull {
+			return node{typ: typ, eval: binary(left.eval, right.eval, func(a, b tuple.Value) (tuple.Value, error) {
+				return intArith(op, a.(int64), b.(int64))
+			})}, nil
+		}
+		return node{typ: typ, eval: binary(left.eval, right.eval, func(a, b tuple.Value) (tuple.Value, error) {
+			return floatArith(op, toFloat(a), toFloat(b))
+		})}, nil
+	default:
+		return node{}, fmt.Errorf("expr: %s is not a binary operator", e.Op)
+	}
+}
+
+func isBoolean(t catalog.DataType) bool {
+	return t == catalog.TypeBoolean || t == typeNull
+}
+
+// binary evaluates both operands and applies fn unless one is NULL.
+func binary(left, right evalFunc, fn func(a, b tuple.Value) (tuple.Value, error)) evalFunc {
+	return func(row tuple.Tuple) (tuple.Value, error) {
+		a, err := left(row)
+		if err != nil || a == nil {
+			return nil, err
+		}
+		b, err := right(row)
+		if err != nil || b == nil {
+			return nil, err
+		}
+		return fn(a, b)
+	}
+}
+
+// logical implements the three-valued AND and OR of SQL. The right operand
+// is not evaluated when the left one decides the result.
+func logical(op Op, left, right evalFunc) evalFunc {
+	decisive := op == OpOr
+	return func(row tuple.Tuple) (tuple.Value, error) {
+		a, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		if a == decisive {
+			return decisive, nil
+		}
+		b, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		if b == decisive {
+			return decisive, nil
+		}
+		if a == nil || b == nil {
+			return nil, nil
+		}
+		return !decisive, nil
+	}
+}
+
+func compareResult(op Op, c int) bool {
+	switch op {
+	case OpEqual:
+		return c == 0
+	case OpNotEqual:
+		return c != 0
+	case OpLess:
+		return c < 0
+	case OpLessEqual:
+		return c <= 0
+	case OpGreater:
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func intArith(op Op, a, b int64) (tuple.Value, error) {
+	switch op {
+	case OpAdd:
+		return a + b, nil
+	case OpSub:
+		return a - b, nil
+	case OpMul:
+		return a * b, nil
+	case OpDiv:
+		if b == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return a / b, nil
+	default:
+		if b == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return a % b, nil
+	}
+}
+
+func floatArith(op Op, a, b float64) (tuple.Value, error) {
+	switch op {
+	case OpAdd:
+		return a + b, nil
+	case OpSub:
+		return a - b, nil
+	case OpMul:
+		return a * b, nil
+	case OpDiv:
+		if b == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return a / b, nil
+	default:
+		if b == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return math.Mod(a, b), nil
+	}
+}
+
+func compileUnary(e Unary, schema *catalog.Schema) (node, error) {
+	operand, err := compile(e.Operand, schema)
+	if err != nil {
+		return node{}, err
+	}
+	eval := operand.eval
+
+	switch e.Op {
+	case OpIsNull, OpIsNotNull:
+		isNull := e.Op == OpIsNull
+		return node{typ: catalog.TypeBoolean, eval: func(row tuple.Tuple) (tuple.Value, error) {
+			v, err := eval(row)
+			if err != nil {
+				return nil, err
+			}
+			return (v == nil) == isNull, nil
+		}}, nil
+	case OpNot:
+		if !isBoolean(operand.typ) {
+			return node{}, fmt.Errorf("%w: %s", ErrTypeMismatch, e)
+		}
+		return node{typ: catalog.TypeBoolean, eval: func(row tuple.Tuple) (tuple.Value, error) {
+			v, err := eval(row)
+			if err != nil || v == nil {
+				return nil, err
+			}
+			return !v.(bool), nil
+		}}, nil
+	case OpNeg:
+		if !isNumeric(operand.typ) {
+			return node{}, fmt.Errorf("%w: %s", ErrTypeMismatch, e)
+		}
+		return node{typ: operand.typ, eval: func(row tuple.Tuple) (tuple.Value, error) {
+			v, err := eval(row)
+			if err != nil || v == nil {
+				return nil, err
+			}
+			if i, ok := v.(int64); ok {
+				return -i, nil
+			}
+			return -v.(float64), nil
+		}}, nil
+	default:
+		return node{}, fmt.Errorf("expr: %s is not a unary operator", e.Op)
+	}
+}
+
+func compileCall(e Call, schema *catalog.Schema) (node, error) {
+	args := make([]node, len(e.Args))
+	for i, arg := range e.Args {
+		n, err := compile(arg, schema)
+		if err != nil {
+			return node{}, err
+		}
+		args[i] = n
+	}
+
+	name := strings.ToUpper(e.Func)
+	argCount := func(lo, hi int) error {
+		if len(args) < lo || hi >= 0 && len(args) > hi {
+			return fmt.Errorf("%w: %s", ErrArgumentCount, e)
+		}
+		return nil
+	}
+	expect := func(types ...catalog.DataType) error {
+		for _, arg := range args {
+			if arg.typ != typeNull && !containsType(types, arg.typ) {
+				return fmt.Errorf("%w: %s", ErrTypeMismatch, e)
+			}
+		}
+		return nil
+	}
+
+	var typ catalog.DataType
+	var fn func(values []tuple.Value) (tuple.Value, error)
+	var err error
+
+	switch name {
+	case "LOWER", "UPPER", "TRIM":
+		if err = argCount(1, 1); err == nil {
+			err = expect(catalog.TypeVarChar)
+		}
+		typ = catalog.TypeVarChar
+		transform := map[string]func(string) string{
+			"LOWER": strings.ToLower,
+			"UPPER": strings.ToUpper,
+			"TRIM":  strings.TrimSpace,
+		}[name]
+		fn = func(values []tuple.Value) (tuple.Value, error) {
+			return transform(values[0].(string)), nil
+		}
+	case "LENGTH":
+		if err = argCount(1, 1); err == nil {
+			err = expect(catalog.TypeVarChar, catalog.TypeBlob)
+		}
+		typ = catalog.TypeInt
+		fn = func(values []tuple.Value) (tuple.Value, error) {
+			if s, ok := values[0].(string); ok {
+				return int64(utf8.RuneCountInString(s)), nil
+			}
+			return int64(len(values[0].([]byte))), nil
+		}
+	case "CONCAT":
+		if err = argCount(1, -1); err == nil {
+			err = expect(catalog.TypeVarChar)
+		}
+		typ = catalog.TypeVarChar
+		fn = func(values []tuple.Value) (tuple.Value, error) {
+			var sb strings.Builder
+			for _, v := range values {
+				sb.WriteString(v.(string))
+			}
+			return sb.String(), nil
+		}
+	case "SUBSTR":
+		if err = argCount(2, 3); err == nil {
+			err = expectEach(e, args, catalog.TypeVarChar, catalog.TypeInt, catalog.TypeInt)
+		}
+		typ = catalog.TypeVarChar
+		fn = func(values []tuple.Value) (tuple.Value, error) {
+			runes := []rune(values[0].(string))
+			start := max(values[1].(int64)-1, 0)
+			end := int64(len(runes))
+			if len(values) == 3 {
+				end = min(start+max(values[2].(int64), 0), end)
+			}
+			if start >= end {
+				return "", nil
+			}
+			return string(runes[start:end]), nil
+		}
+	case "CONTAINS":
+		if err = argCount(2, 2); err == nil {
+			err = expect(catalog.TypeVarChar)
+		}
+		typ = catalog.TypeBoolean
+		fn = func(values []tuple.Value) (tuple.Value, error) {
+			return strings.Contains(values[0].(string), values[1].(string)), nil
+		}
+	case "ABS":
+		if err = argCount(1, 1); err == nil {
+			err = expect(catalog.TypeInt, catalog.TypeFloat)
+		}
+		typ = args[0].typ
+		fn = func(values []tuple.Value) (tuple.Value, error) {
+			if i, ok := values[0].(int64); ok {
+				if i < 0 {
+					return -i, nil
+				}
+				return i, nil
+			}
+			return math.Abs(values[0].(float64)), nil
+		}
+	case "COALESCE":
+		return compileCoalesce(e, args)
+	default:
+		return node{}, fmt.Errorf("%w: %s", ErrUnknownFunction, e.Func)
+	}
+	if err != nil {
+		return node{}, err
+	}
+
+	return node{typ: typ, eval: func(row tuple.Tuple) (tuple.Value, error) {
+		values := make([]tuple.Value, len(args))
+		for i, arg := range args {
+			v, err := arg.eval(row)
+			if err != nil || v == nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return fn(values)
+	}}, nil
+}
+
+func containsType(types []catalog.DataType, t catalog.DataType) bool {
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
+
+// expectEach checks the type of every argument against its own type.
+func expectEach(e Call, args []node, types ...catalog.DataType) error {
+	for i, arg := range args {
+		if arg.typ != typeNull && arg.typ != types[i] {
+			return fmt.Errorf("%w: %s", ErrTypeMismatch, e)
+		}
+	}
+	return nil
+}
+
+func compileCoalesce(e Call, args []node) (node, error) {
+	if len(args) == 0 {
+		return node{}, fmt.Errorf("%w: %s", ErrArgumentCount, e)
+	}
+
+	typ := typeNull
+	for _, arg := range args {
+		if arg.typ == typeNull {
+			continue
+		}
+		if typ != typeNull && arg.typ != typ {
+			return node{}, fmt.Errorf("%w: %s", ErrTypeMismatch, e)
+		}
+		typ = arg.typ
+	}
+
+	return node{typ: typ, eval: func(row tuple.Tuple) (tuple.Value, error) {
+		for _, arg := range args {
+			v, err := arg.eval(row)
+			if err != nil || v != nil {
+				return v, err
+			}
+		}
+		return nil, nil
+	}}, nil
+}
@@ -0,0 +1,192 @@
+// Package expr
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rizalta/toydb/tuple"
+)
+
+// Expr is a node of an expression tree. Trees are built from the node
+// types below, directly or with the helper functions, and compiled against
+// a table schema before they are evaluated.
+type Expr interface {
+	String() string
+}
+
+type Op uint8
+
+const (
+	OpEqual Op = iota
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpAnd
+	OpOr
+	OpNot
+	OpNeg
+	OpIsNull
+	OpIsNotNull
+)
+
+var opSymbols = map[Op]string{
+	OpEqual:        "=",
+	OpNotEqual:     "!=",
+	OpLess:         "<",
+	OpLessEqual:    "<=",
+	OpGreater:      ">",
+	OpGreaterEqual: ">=",
+	OpAdd:          "+",
+	OpSub:          "-",
+	OpMul:          "*",
+	OpDiv:          "/",
+	OpMod:          "%",
+	OpAnd:          "AND",
+	OpOr:           "OR",
+	OpNot:          "NOT",
+	OpNeg:          "-",
+	OpIsNull:       "IS NULL",
+	OpIsNotNull:    "IS NOT NULL",
+}
+
+func (op Op) String() string {
+	if symbol, ok := opSymbols[op]; ok {
+		return symbol
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Column refers to a column of the row being evaluated.
+type Column struct {
+	Name string
+}
+
+// Literal is a constant. Its type follows from the Go type of the value,
+// and a nil value is NULL.
+type Literal struct {
+	Value tuple.Value
+}
+
+// Binary applies a comparison, arithmetic or boolean operator to two
+// operands.
+type Binary struct {
+	Op          Op
+	Left, Right Expr
+}
+
+// Unary applies OpNot, OpNeg, OpIsNull or OpIsNotNull to an operand.
+type Unary struct {
+	Op      Op
+	Operand Expr
+}
+
+// Call applies a built-in function to its arguments. See Compile for the
+// functions there are.
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+func (c Column) String() string {
+	return c.Name
+}
+
+func (l Literal) String() string {
+	switch v := l.Value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return fmt.Sprintf("x'%x'", v)
+	case time.Time:
+		return tuple.FormatTimestamp(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (b Binary) String() string {
+	return fmt.Sprintf("(%s %s %s)", b.Left, b.Op, b.Right)
+}
+
+func (u Unary) String() string {
+	switch u.Op {
+	case OpIsNull, OpIsNotNull:
+		return fmt.Sprintf("(%s %s)", u.Operand, u.Op)
+	case OpNeg:
+		return fmt.Sprintf("-%s", u.Operand)
+	default:
+		return fmt.Sprintf("(%s %s)", u.Op, u.Operand)
+	}
+}
+
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.Func, strings.Join(args, ", "))
+}
+
+func Col(name string) Expr {
+	return Column{Name: name}
+}
+
+func Lit(value tuple.Value) Expr {
+	return Literal{Value: value}
+}
+
+func Eq(left, right Expr) Expr { return Binary{Op: OpEqual, Left: left, Right: right} }
+func Ne(left, right Expr) Expr { return Binary{Op: OpNotEqual, Left: left, Right: right} }
+func Lt(left, right Expr) Expr { return Binary{Op: OpLess, Left: left, Right: right} }
+func Le(left, right Expr) Expr { return Binary{Op: OpLessEqual, Left: left, Right: right} }
+func Gt(left, right Expr) Expr { return Binary{Op: OpGreater, Left: left, Right: right} }
+func Ge(left, right Expr) Expr { return Binary{Op: OpGreaterEqual, Left: left, Right: right} }
+
+func Add(left, right Expr) Expr { return Binary{Op: OpAdd, Left: left, Right: right} }
+func Sub(left, right Expr) Expr { return Binary{Op: OpSub, Left: left, Right: right} }
+func Mul(left, right Expr) Expr { return Binary{Op: OpMul, Left: left, Right: right} }
+func Div(left, right Expr) Expr { return Binary{Op: OpDiv, Left: left, Right: right} }
+func Mod(left, right Expr) Expr { return Binary{Op: OpMod, Left: left, Right: right} }
+
+// And combines operands with AND, left to right. With no operands it is
+// true.
+func And(operands ...Expr) Expr {
+	return fold(OpAnd, true, operands)
+}
+
+// Or combines operands with OR, left to right. With no operands it is
+// false.
+func Or(operands ...Expr) Expr {
+	return fold(OpOr, false, operands)
+}
+
+func fold(op Op, empty bool, operands []Expr) Expr {
+	if len(operands) == 0 {
+		return Lit(empty)
+	}
+	e := operands[0]
+	for _, operand := range operands[1:] {
+		e = Binary{Op: op, Left: e, Right: operand}
+	}
+	return e
+}
+
+func Not(operand Expr) Expr       { return Unary{Op: OpNot, Operand: operand} }
+func Neg(operand Expr) Expr       { return Unary{Op: OpNeg, Operand: operand} }
+func IsNull(operand Expr) Expr    { return Unary{Op: OpIsNull, Operand: operand} }
+func IsNotNull(operand Expr) Expr { return Unary{Op: OpIsNotNull, Operand: operand} }
+
+func Func(name string, args ...Expr) Expr {
+	return Call{Func: name, Args: args}
+}
@@ -0,0 +1,147 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var testSchema = &catalog.Schema{
+	Name: "items",
+	Columns: []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+		{Name: "price", Type: catalog.TypeFloat},
+		{Name: "qty", Type: catalog.TypeInt},
+		{Name: "active", Type: catalog.TypeBoolean},
+	},
+}
+
+func TestEval(t *testing.T) {
+	row := tuple.Tuple{int64(7), "  Widget ", 2.5, int64(4), nil}
+
+	tests := []struct {
+		name     string
+		e        Expr
+		expected tuple.Value
+		typ      catalog.DataType
+	}{
+		{"column", Col("qty"), int64(4), catalog.TypeInt},
+		{"int arithmetic", Sub(Mul(Col("qty"), Lit(int64(3))), Mod(Col("id"), Lit(int64(4)))), int64(9), catalog.TypeInt},
+		{"int division truncates", Div(Col("id"), Lit(int64(2))), int64(3), catalog.TypeInt},
+		{"int promoted to float", Mul(Col("price"), Col("qty")), 10.0, catalog.TypeFloat},
+		{"mixed comparison", Gt(Col("price"), Lit(int64(2))), true, catalog.TypeBoolean},
+		{"negation", Neg(Col("price")), -2.5, catalog.TypeFloat},
+		{"string comparison", Lt(Col("name"), Lit("a")), true, catalog.TypeBoolean},
+		{"null column compares to null", Eq(Col("active"), Lit(true)), nil, catalog.TypeBoolean},
+		{"null propagates through arithmetic", Add(Col("qty"), Lit(nil)), nil, catalog.TypeInt},
+		{"is null", IsNull(Col("active")), true, catalog.TypeBoolean},
+		{"is not null", IsNotNull(Col("name")), true, catalog.TypeBoolean},
+		{"false and null", And(Lit(false), Col("active")), false, catalog.TypeBoolean},
+		{"true and null", And(Lit(true), Col("active")), nil, catalog.TypeBoolean},
+		{"true or null", Or(Col("active"), Lit(true)), true, catalog.TypeBoolean},
+		{"false or null", Or(Col("active"), Lit(false)), nil, catalog.TypeBoolean},
+		{"not null", Not(Col("active")), nil, catalog.TypeBoolean},
+		{"empty and", And(), true, catalog.TypeBoolean},
+		{"right operand skipped", Or(Lit(true), Eq(Div(Col("qty"), Lit(int64(0))), Lit(int64(1)))), true, catalog.TypeBoolean},
+		{"upper of trim", Func("upper", Func("trim", Col("name"))), "WIDGET", catalog.TypeVarChar},
+		{"length", Func("LENGTH", Lit("héllo")), int64(5), catalog.TypeInt},
+		{"concat", Func("concat", Lit("#"), Func("lower", Func("trim", Col("name")))), "#widget", catalog.TypeVarChar},
+		{"concat with null", Func("concat", Lit("a"), Lit(nil)), nil, catalog.TypeVarChar},
+		{"substr", Func("substr", Lit("database"), Lit(int64(5)), Lit(int64(3))), "bas", catalog.TypeVarChar},
+		{"substr to end", Func("substr", Lit("database"), Lit(int64(5))), "base", catalog.TypeVarChar},
+		{"substr past end", Func("substr", Lit("data"), Lit(int64(9)), Lit(int64(2))), "", catalog.TypeVarChar},
+		{"contains", Func("contains", Col("name"), Lit("idg")), true, catalog.TypeBoolean},
+		{"abs", Func("abs", Sub(Lit(int64(1)), Col("id"))), int64(6), catalog.TypeInt},
+		{"coalesce", Func("coalesce", Col("active"), Lit(false)), false, catalog.TypeBoolean},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Compile(tt.e, testSchema)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %v", tt.e, err)
+			}
+			if p.Type() != tt.typ {
+				t.Errorf("expected type %v, got %v", tt.typ, p.Type())
+			}
+			got, err := p.Eval(row)
+			if err != nil {
+				t.Fatalf("failed to evaluate %s: %v", tt.e, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %s to be %v, got %v", tt.e, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		e        Expr
+		expected error
+	}{
+		{"unknown column", Eq(Col("missing"), Lit(int64(1))), ErrColumnNotFound},
+		{"string compared to int", Eq(Col("name"), Lit(int64(1))), ErrTypeMismatch},
+		{"arithmetic on strings", Add(Col("name"), Lit("x")), ErrTypeMismatch},
+		{"and of ints", And(Col("qty"), Lit(true)), ErrTypeMismatch},
+		{"not of string", Not(Col("name")), ErrTypeMismatch},
+		{"unsupported literal", Eq(Col("qty"), Lit(4)), ErrInvalidLiteral},
+		{"unknown function", Func("reverse", Col("name")), ErrUnknownFunction},
+		{"too few arguments", Func("substr", Col("name")), ErrArgumentCount},
+		{"too many arguments", Func("lower", Col("name"), Col("name")), ErrArgumentCount},
+		{"function argument type", Func("upper", Col("qty")), ErrTypeMismatch},
+		{"coalesce of mixed types", Func("coalesce", Col("qty"), Lit("none")), ErrTypeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile(tt.e, testSchema); !errors.Is(err, tt.expected) {
+				t.Errorf("expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	p, err := Compile(Gt(Div(Col("price"), Col("qty")), Lit(0.5)), testSchema)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		row      tuple.Tuple
+		expected bool
+		err      error
+	}{
+		{"true", tuple.Tuple{int64(1), "a", 3.0, int64(2), true}, true, nil},
+		{"false", tuple.Tuple{int64(1), "a", 1.0, int64(2), true}, false, nil},
+		{"null does not match", tuple.Tuple{int64(1), "a", nil, int64(2), true}, false, nil},
+		{"division by zero", tuple.Tuple{int64(1), "a", 1.0, int64(0), true}, false, ErrDivisionByZero},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Match(tt.row)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	e := And(Ge(Col("qty"), Lit(int64(1))), Not(IsNull(Col("name"))), Eq(Func("lower", Col("name")), Lit("a b")))
+	expected := `(((qty >= 1) AND (NOT (name IS NULL))) AND (lower(name) = "a b"))`
+	if got := e.String(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
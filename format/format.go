@@ -0,0 +1,238 @@
+// Package format writes query results as aligned tables, CSV or JSON.
+package format
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+var (
+	ErrUnknownFormat = errors.New("format: unknown output format")
+	ErrColumnCount   = errors.New("format: row does not match the schema columns")
+)
+
+// Rows is the part of db.Rows the writers need.
+type Rows interface {
+	Next() (tuple.Tuple, error)
+}
+
+type Format uint8
+
+const (
+	// Table aligns the rows in columns under a header for reading in a
+	// terminal, and ends with a row count.
+	Table Format = iota
+	// CSV writes a header and one record per row, in the form ImportCSV
+	// reads back: NULL is an empty field and blobs are hex.
+	CSV
+	// JSON writes an array with an object per row, keyed by column name in
+	// schema order. Blobs are base64 strings as encoding/json writes them.
+	JSON
+)
+
+var formatNames = map[Format]string{
+	Table: "table",
+	CSV:   "csv",
+	JSON:  "json",
+}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Parse returns the format with the given name, as printed by String.
+func Parse(name string) (Format, error) {
+	for f, n := range formatNames {
+		if n == name {
+			return f, nil
+		}
+	}
+	return 0, ErrUnknownFormat
+}
+
+// Write writes every row to w in format f and returns the number of rows.
+// The rows must have the columns of schema.
+func Write(w io.Writer, f Format, schema *catalog.Schema, rows Rows) (int, error) {
+	switch f {
+	case Table:
+		return writeTable(w, schema, rows)
+	case CSV:
+		return writeCSV(w, schema, rows)
+	case JSON:
+		return writeJSON(w, schema, rows)
+	default:
+		return 0, ErrUnknownFormat
+	}
+}
+
+// forEach calls fn with every row until the rows run out.
+func forEach(rows Rows, fn func(row tuple.Tuple) error) (int, error) {
+	n := 0
+	for {
+		row, err := rows.Next()
+		if err != nil || row == nil {
+			return n, err
+		}
+		if err := fn(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func formatText(v tuple.Value, null string) string {
+	switch v := v.(type) {
+	case nil:
+		return null
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case []byte:
+		return hex.EncodeToString(v)
+	case time.Time:
+		return tuple.FormatTimestamp(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func writeTable(w io.Writer, schema *catalog.Schema, rows Rows) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	header := make([]byte, 0, 64)
+	rule := make([]byte, 0, 64)
+	for i, col := range schema.Columns {
+		if i > 0 {
+			header = append(header, '\t')
+			rule = append(rule, '\t')
+		}
+		header = append(header, col.Name...)
+		for range len(col.Name) {
+			rule = append(rule, '-')
+		}
+	}
+	fmt.Fprintf(tw, "%s\n%s\n", header, rule)
+
+	line := make([]byte, 0, 64)
+	n, err := forEach(rows, func(row tuple.Tuple) error {
+		if len(row) != len(schema.Columns) {
+			return ErrColumnCount
+		}
+		line = line[:0]
+		for i, v := range row {
+			if i > 0 {
+				line = append(line, '\t')
+			}
+			line = append(line, formatText(v, "NULL")...)
+		}
+		line = append(line, '\n')
+		_, err := tw.Write(line)
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := tw.Flush(); err != nil {
+		return n, err
+	}
+
+	if n == 1 {
+		_, err = fmt.Fprintln(w, "(1 row)")
+	} else {
+		_, err = fmt.Fprintf(w, "(%d rows)\n", n)
+	}
+	return n, err
+}
+
+func writeCSV(w io.Writer, schema *catalog.Schema, rows Rows) (int, error) {
+	cw := csv.NewWriter(w)
+
+	record := make([]string, len(schema.Columns))
+	for i, col := range schema.Columns {
+		record[i] = col.Name
+	}
+	if err := cw.Write(record); err != nil {
+		return 0, err
+	}
+
+	n, err := forEach(rows, func(row tuple.Tuple) error {
+		if len(row) != len(record) {
+			return ErrColumnCount
+		}
+		for i, v := range row {
+			record[i] = formatText(v, "")
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return n, err
+	}
+
+	cw.Flush()
+	return n, cw.Error()
+}
+
+func writeJSON(w io.Writer, schema *catalog.Schema, rows Rows) (int, error) {
+	bw := bufio.NewWriter(w)
+
+	keys := make([][]byte, len(schema.Columns))
+	for i, col := range schema.Columns {
+		key, err := json.Marshal(col.Name)
+		if err != nil {
+			return 0, err
+		}
+		keys[i] = key
+	}
+
+	bw.WriteByte('[')
+	separator := "\n  {"
+	n, err := forEach(rows, func(row tuple.Tuple) error {
+		if len(row) != len(keys) {
+			return ErrColumnCount
+		}
+		bw.WriteString(separator)
+		separator = ",\n  {"
+		for i, v := range row {
+			if i > 0 {
+				bw.WriteString(", ")
+			}
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			bw.Write(keys[i])
+			bw.WriteString(": ")
+			bw.Write(value)
+		}
+		bw.WriteByte('}')
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if n > 0 {
+		bw.WriteByte('\n')
+	}
+	bw.WriteString("]\n")
+
+	return n, bw.Flush()
+}
@@ -0,0 +1,118 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/tuple"
+)
+
+type sliceRows []tuple.Tuple
+
+func (r *sliceRows) Next() (tuple.Tuple, error) {
+	if len(*r) == 0 {
+		return nil, nil
+	}
+	row := (*r)[0]
+	*r = (*r)[1:]
+	return row, nil
+}
+
+func TestWrite(t *testing.T) {
+	schema := &catalog.Schema{
+		Name: "events",
+		Columns: []catalog.Column{
+			{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true},
+			{Name: "name", Type: catalog.TypeVarChar},
+			{Name: "score", Type: catalog.TypeFloat},
+			{Name: "ok", Type: catalog.TypeBoolean},
+			{Name: "data", Type: catalog.TypeBlob},
+			{Name: "at", Type: catalog.TypeTimestamp},
+		},
+	}
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	rows := []tuple.Tuple{
+		{int64(1), "launch", 1.5, true, []byte{0xca, 0xfe}, at},
+		{int64(20), "say \"hi\", bye", nil, false, nil, nil},
+	}
+
+	tests := []struct {
+		format   Format
+		rows     []tuple.Tuple
+		expected string
+	}{
+		{
+			format: Table,
+			rows:   rows,
+			expected: "id  name           score  ok     data  at\n" +
+				"--  ----           -----  --     ----  --\n" +
+				"1   launch         1.5    true   cafe  2024-03-01T12:30:00Z\n" +
+				"20  say \"hi\", bye  NULL   false  NULL  NULL\n" +
+				"(2 rows)\n",
+		},
+		{
+			format:   Table,
+			rows:     rows[:1],
+			expected: "id  name    score  ok    data  at\n--  ----    -----  --    ----  --\n1   launch  1.5    true  cafe  2024-03-01T12:30:00Z\n(1 row)\n",
+		},
+		{
+			format: CSV,
+			rows:   rows,
+			expected: "id,name,score,ok,data,at\n" +
+				"1,launch,1.5,true,cafe,2024-03-01T12:30:00Z\n" +
+				"20,\"say \"\"hi\"\", bye\",,false,,\n",
+		},
+		{
+			format: JSON,
+			rows:   rows,
+			expected: "[\n" +
+				`  {"id": 1, "name": "launch", "score": 1.5, "ok": true, "data": "yv4=", "at": "2024-03-01T12:30:00Z"},` + "\n" +
+				`  {"id": 20, "name": "say \"hi\", bye", "score": null, "ok": false, "data": null, "at": null}` + "\n" +
+				"]\n",
+		},
+		{
+			format:   JSON,
+			rows:     nil,
+			expected: "[]\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			input := sliceRows(tt.rows)
+			n, err := Write(&buf, tt.format, schema, &input)
+			if err != nil {
+				t.Fatalf("failed to write: %v", err)
+			}
+			if n != len(tt.rows) {
+				t.Errorf("expected %d rows, got %d", len(tt.rows), n)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, buf.String())
+			}
+		})
+	}
+
+	for _, f := range []Format{Table, CSV, JSON} {
+		input := sliceRows{{int64(1)}}
+		if _, err := Write(&bytes.Buffer{}, f, schema, &input); !errors.Is(err, ErrColumnCount) {
+			t.Errorf("expected %v for %s, got %v", ErrColumnCount, f, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, f := range []Format{Table, CSV, JSON} {
+		got, err := Parse(f.String())
+		if err != nil || got != f {
+			t.Errorf("expected %s, got %v, %v", f, got, err)
+		}
+	}
+	if _, err := Parse("xml"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("expected %v, got %v", ErrUnknownFormat, err)
+	}
+}
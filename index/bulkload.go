@@ -0,0 +1,187 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+var (
+	ErrIndexNotEmpty     = errors.New("index: bulk load needs an empty index")
+	ErrUnsortedKeys      = errors.New("index: bulk load keys are not in strictly increasing order")
+	ErrInvalidFillFactor = errors.New("index: fill factor must be above 0 and at most 1")
+)
+
+const defaultBulkFillFactor = 0.9
+
+// Source yields the entries for BulkLoad in key order. Next returns a nil
+// key once there are no more, and Payload the inline payload of the entry
+// last returned, or nil if it holds a value. A Cursor is a Source, so one
+// index can be loaded from another.
+type Source interface {
+	Next() ([]byte, uint64, error)
+	Payload() []byte
+}
+
+// levelEntry is a node built by BulkLoad, known to its parent by the first
+// key below it.
+type levelEntry struct {
+	key    []byte
+	pageID pager.PageID
+}
+
+// BulkLoad fills an empty index from entries sorted by key. Instead of
+// inserting them one at a time, which splits every leaf on the way, it
+// writes the leaves left to right, each filled to fillFactor of a page,
+// and then builds the internal levels above them. A fill factor of 0 uses
+// 0.9, which leaves room for a few inserts before leaves split again.
+func (idx *Index) BulkLoad(source Source, fillFactor float64) error {
+	if fillFactor == 0 {
+		fillFactor = defaultBulkFillFactor
+	}
+	if fillFactor < 0 || fillFactor > 1 {
+		return ErrInvalidFillFactor
+	}
+	if idx.readOnly {
+		return ErrReadOnly
+	}
+	if key, _, err := idx.First(); err != nil {
+		return err
+	} else if key != nil {
+		return ErrIndexNotEmpty
+	}
+	limit := max(int(fillFactor*splitThreshold), headerSize)
+
+	idx.version++
+	level, err := idx.loadLeaves(source, limit)
+	if err != nil || len(level) == 0 {
+		return err
+	}
+	for len(level) > 1 {
+		if level, err = idx.loadInternal(level, limit); err != nil {
+			return err
+		}
+	}
+
+	if err := idx.release(idx.root); err != nil {
+		return err
+	}
+	idx.root = level[0].pageID
+	return idx.maybeCommit()
+}
+
+// loadLeaves writes the entries of source into new leaves, left to right.
+func (idx *Index) loadLeaves(source Source, limit int) ([]levelEntry, error) {
+	var level []levelEntry
+	var page *pager.Page
+	var previous []byte
+	n := newLeafNode()
+	size := 0
+
+	for {
+		key, value, err := source.Next()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			break
+		}
+		if previous != nil && bytes.Compare(previous, key) >= 0 {
+			return nil, ErrUnsortedKeys
+		}
+		payload := source.Payload()
+		if len(payload) > MaxInlineSize {
+			return nil, ErrValueTooLarge
+		}
+
+		entrySize := slotSize + valueSize + len(key) + len(payload)
+		if page == nil || len(n.keys) > 0 &&
+			packedSize(size+entrySize, len(n.keys)+1, sharedPrefixLen(n.keys[0], key)) > limit {
+			next, err := idx.newPage()
+			if err != nil {
+				return nil, err
+			}
+			if page != nil {
+				if err := idx.writeNode(page, n); err != nil {
+					return nil, err
+				}
+			}
+			page, n, size = next, newLeafNode(), 0
+		}
+
+		key = bytes.Clone(key)
+		if payload != nil {
+			payload = bytes.Clone(payload)
+		}
+		if len(n.keys) == 0 {
+			first := key
+			if previous != nil {
+				first = separator(previous, key)
+			}
+			level = append(level, levelEntry{key: first, pageID: page.ID})
+		}
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, value)
+		n.payloads = append(n.payloads, payload)
+		size += entrySize
+		previous = key
+	}
+
+	if page == nil {
+		return nil, nil
+	}
+	return level, idx.writeNode(page, n)
+}
+
+// loadInternal writes a level of internal nodes over the nodes of the level
+// below and returns it.
+func (idx *Index) loadInternal(children []levelEntry, limit int) ([]levelEntry, error) {
+	// The keys of a group are those of all its children but the first.
+	var groups [][]levelEntry
+	start, size := 0, childSize
+	for i := 1; i < len(children); i++ {
+		entrySize := slotSize + childSize + len(children[i].key)
+		prefixLen := sharedPrefixLen(children[start+1].key, children[i].key)
+		if packedSize(size+entrySize, i-start, prefixLen) > limit && i-start > 1 {
+			groups = append(groups, children[start:i])
+			start, size = i, childSize
+			continue
+		}
+		size += entrySize
+	}
+	groups = append(groups, children[start:])
+
+	// An internal node needs two children: take one from the node before,
+	// or join it if that one has only two.
+	if last := len(groups) - 1; last > 0 && len(groups[last]) == 1 {
+		if previous := groups[last-1]; len(previous) > 2 {
+			groups[last-1] = previous[:len(previous)-1]
+			groups[last] = children[len(children)-2:]
+		} else {
+			groups = groups[:last]
+			groups[last-1] = children[len(children)-3:]
+		}
+	}
+
+	level := make([]levelEntry, len(groups))
+	for i, group := range groups {
+		n := newInternalNode()
+		for j, child := range group {
+			if j > 0 {
+				n.keys = append(n.keys, child.key)
+			}
+			n.children = append(n.children, child.pageID)
+		}
+
+		page, err := idx.newPage()
+		if err != nil {
+			return nil, err
+		}
+		if err := idx.writeNode(page, n); err != nil {
+			return nil, err
+		}
+		level[i] = levelEntry{key: group[0].key, pageID: page.ID}
+	}
+	return level, nil
+}
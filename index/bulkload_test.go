@@ -0,0 +1,133 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// sliceSource yields the entries of a slice as a Source.
+type sliceSource struct {
+	keys     [][]byte
+	payloads [][]byte
+	next     int
+}
+
+func (s *sliceSource) Next() ([]byte, uint64, error) {
+	if s.next == len(s.keys) {
+		return nil, 0, nil
+	}
+	s.next++
+	return s.keys[s.next-1], uint64(s.next - 1), nil
+}
+
+func (s *sliceSource) Payload() []byte {
+	if s.payloads == nil {
+		return nil
+	}
+	return s.payloads[s.next-1]
+}
+
+func TestBulkLoad(t *testing.T) {
+	var keys [][]byte
+	for i := range 20000 {
+		keys = append(keys, fmt.Appendf(nil, "key_%05d", i))
+	}
+
+	tests := []struct {
+		name       string
+		count      int
+		fillFactor float64
+	}{
+		{"Empty source", 0, 0},
+		{"Single leaf", 10, 0},
+		{"Default fill factor", 20000, 0},
+		{"Full pages", 20000, 1},
+		{"Tiny fill factor", 2000, 0.01},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := newTestIndex(t)
+			defer idx.Close()
+
+			if err := idx.BulkLoad(&sliceSource{keys: keys[:tt.count]}, tt.fillFactor); err != nil {
+				t.Fatalf("failed to bulk load: %v", err)
+			}
+			for i := 0; i < tt.count; i += 7 {
+				value, err := idx.Search(keys[i])
+				if err != nil || value != uint64(i) {
+					t.Fatalf("expected %s to hold %d, got %d, err %v", keys[i], i, value, err)
+				}
+			}
+			if count, err := idx.Count(nil, nil); err != nil || count != tt.count {
+				t.Errorf("expected %d keys, got %d, err %v", tt.count, count, err)
+			}
+
+			// The tree must stay usable for ordinary inserts and deletes.
+			for i := 0; i < tt.count; i += 2 {
+				if err := idx.Delete(keys[i]); err != nil {
+					t.Fatalf("failed to delete %s: %v", keys[i], err)
+				}
+			}
+			if err := idx.Insert([]byte("key_00000a"), 1, InsertOnly); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+			if count, err := idx.Count(nil, nil); err != nil || count != tt.count/2+1 {
+				t.Errorf("expected %d keys after changes, got %d, err %v", tt.count/2+1, count, err)
+			}
+		})
+	}
+}
+
+func TestBulkLoadPages(t *testing.T) {
+	inserted := newTestIndex(t)
+	defer inserted.Close()
+	for i := range 20000 {
+		payload := bytes.Repeat([]byte{byte(i)}, i%50)
+		if err := inserted.InsertInline(fmt.Appendf(nil, "key_%05d", i), payload, Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	loaded := newTestIndex(t)
+	defer loaded.Close()
+	cursor, err := inserted.NewCursor(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %v", err)
+	}
+	if err := loaded.BulkLoad(cursor, 1); err != nil {
+		t.Fatalf("failed to bulk load: %v", err)
+	}
+
+	for i := range 20000 {
+		key := fmt.Appendf(nil, "key_%05d", i)
+		_, payload, err := loaded.Lookup(key)
+		if err != nil || !bytes.Equal(payload, bytes.Repeat([]byte{byte(i)}, i%50)) {
+			t.Fatalf("expected the payload of %s to be copied, got %v, err %v", key, payload, err)
+		}
+	}
+	if loaded.pager.GetNumPages() >= inserted.pager.GetNumPages() {
+		t.Errorf("expected fewer pages than with inserts, got %d and %d", loaded.pager.GetNumPages(), inserted.pager.GetNumPages())
+	}
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+	idx := newTestIndex(t)
+	defer idx.Close()
+
+	unsorted := &sliceSource{keys: [][]byte{[]byte("b"), []byte("a")}}
+	if err := idx.BulkLoad(unsorted, 0); err != ErrUnsortedKeys {
+		t.Errorf("expected %v, got %v", ErrUnsortedKeys, err)
+	}
+	if err := idx.BulkLoad(&sliceSource{}, 1.5); err != ErrInvalidFillFactor {
+		t.Errorf("expected %v, got %v", ErrInvalidFillFactor, err)
+	}
+
+	if err := idx.Insert([]byte("a"), 1, Upsert); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := idx.BulkLoad(&sliceSource{keys: [][]byte{[]byte("b")}}, 0); err != ErrIndexNotEmpty {
+		t.Errorf("expected %v, got %v", ErrIndexNotEmpty, err)
+	}
+}
@@ -0,0 +1,139 @@
+package index
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+const (
+	// metaClean is set in the flags of the meta page by Close and cleared
+	// again when the index is opened.
+	metaClean = 1
+	// commitPages is the number of pages written since the last commit
+	// after which a modification commits the index.
+	commitPages = 256
+)
+
+// Pages of the committed tree are never written over. A modification
+// writes every node it changes to a page allocated since the last commit,
+// which takes a new page the first time, and so changes the child pointer
+// in the parent too, up to a new root. The nodes replaced are freed once a
+// commit has written the new root to the meta page, so after a crash the
+// index opens with the tree of the last commit, intact.
+
+// newPage allocates a page that may be written in place until the next
+// commit.
+func (idx *Index) newPage() (*pager.Page, error) {
+	return idx.alloc.Allocate()
+}
+
+// release frees a page that is no longer part of the tree, right away if
+// it was allocated since the last commit or else after the next.
+func (idx *Index) release(pageID pager.PageID) error {
+	return idx.alloc.Release(pageID)
+}
+
+// putNode writes a node that was read from pageID and returns the page it
+// is now on, which is a new one if pageID belongs to the committed tree.
+func (idx *Index) putNode(pageID pager.PageID, n *node) (pager.PageID, error) {
+	var page *pager.Page
+	if idx.alloc.IsFresh(pageID) {
+		page = &pager.Page{ID: pageID}
+	} else {
+		var err error
+		if page, err = idx.newPage(); err != nil {
+			return 0, err
+		}
+		if err := idx.release(pageID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := idx.writeNode(page, n); err != nil {
+		return 0, err
+	}
+	return page.ID, nil
+}
+
+// maybeCommit commits the index once enough pages were written since the
+// last commit, which bounds how many replaced pages wait to be freed.
+func (idx *Index) maybeCommit() error {
+	if idx.alloc.Uncommitted() < commitPages {
+		return nil
+	}
+	return idx.Commit()
+}
+
+// Commit makes the tree as it is now the one the index opens with after a
+// crash. It writes all pages to the file, then the meta page pointing at
+// the new root, and then frees the pages the new tree replaced. If the
+// write barrier of the pager defers the writes, Commit does nothing and
+// the tree is committed later. A read-only index has nothing to commit.
+func (idx *Index) Commit() error {
+	if idx.readOnly {
+		return nil
+	}
+	if err := idx.pager.Flush(); errors.Is(err, pager.ErrWriteDeferred) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if idx.pager.HasDirtyPages() {
+		return nil
+	}
+
+	if err := idx.writeMeta(false); err != nil {
+		return err
+	}
+	if err := idx.pager.Flush(); err != nil {
+		return err
+	}
+
+	return idx.alloc.Commit()
+}
+
+// reclaim rebuilds the free list of an index that was not closed cleanly
+// from the pages the tree does not reach. Pages allocated after the last
+// commit and taken off the free list since then are all unreachable. If
+// the tree cannot be read, the free list is left empty and no page is
+// reused.
+func (idx *Index) reclaim() {
+	idx.alloc = pager.NewAllocator(idx.pager, 0)
+
+	reachable := make(map[pager.PageID]bool)
+	if idx.root != 0 {
+		stack := []pager.PageID{idx.root}
+		for len(stack) > 0 {
+			pageID := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if reachable[pageID] {
+				return
+			}
+			reachable[pageID] = true
+
+			n, _, err := idx.readNode(pageID)
+			if err != nil {
+				return
+			}
+			stack = append(stack, n.children...)
+		}
+	}
+
+	// A failed rebuild leaves the free list empty too.
+	_ = idx.alloc.Rebuild(func(pageID pager.PageID) bool {
+		return reachable[pageID]
+	})
+}
+
+// clone returns a copy of n whose slices can be changed without changing
+// those of n.
+func (n *node) clone() *node {
+	c := *n
+	c.keys = slices.Clone(n.keys)
+	c.values = slices.Clone(n.values)
+	c.payloads = slices.Clone(n.payloads)
+	c.children = slices.Clone(n.children)
+	return &c
+}
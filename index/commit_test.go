@@ -0,0 +1,257 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+// reachablePages returns the pages of the tree of idx with their contents.
+func reachablePages(t *testing.T, idx *Index) map[pager.PageID][]byte {
+	t.Helper()
+
+	pages := make(map[pager.PageID][]byte)
+	stack := []pager.PageID{idx.root}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n, page, err := idx.readNode(id)
+		if err != nil {
+			t.Fatalf("failed to read page %d: %v", id, err)
+		}
+		pages[id] = bytes.Clone(page.Data[:])
+		stack = append(stack, n.children...)
+	}
+	return pages
+}
+
+func TestCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	p, err := pager.NewPager(path)
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	idx, err := NewIndex(p)
+	if err != nil {
+		t.Fatalf("failed to initialize index: %v", err)
+	}
+
+	key := func(i int) []byte { return fmt.Appendf(nil, "key_%06d", i) }
+	for i := range 2000 {
+		if err := idx.Insert(key(i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := idx.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	committed := reachablePages(t, idx)
+
+	// Change the tree without committing, with few enough pages that no
+	// commit happens on the way, and write everything to the file as if
+	// the pager evicted it before a crash.
+	for i := 0; i < 2000; i += 20 {
+		if err := idx.Delete(key(i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		if err := idx.Insert(key(i+1), 0, Upsert); err != nil {
+			t.Fatalf("failed to update: %v", err)
+		}
+	}
+	if idx.alloc.Uncommitted() == 0 || idx.alloc.Uncommitted() >= commitPages {
+		t.Fatalf("expected less than %d uncommitted pages, got %d", commitPages, idx.alloc.Uncommitted())
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	t.Run("committed pages are not written over", func(t *testing.T) {
+		for id, data := range committed {
+			page, err := p.ReadPage(id)
+			if err != nil {
+				t.Fatalf("failed to read page %d: %v", id, err)
+			}
+			if !bytes.Equal(page.Data[:], data) {
+				t.Errorf("committed page %d was written over", id)
+			}
+		}
+	})
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("failed to close pager: %v", err)
+	}
+	p, err = pager.NewPager(path)
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	idx, err = NewIndex(p)
+	if err != nil {
+		t.Fatalf("failed to reopen index: %v", err)
+	}
+	defer idx.Close()
+
+	t.Run("reopens with the committed tree", func(t *testing.T) {
+		report, err := idx.Verify()
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+		if !report.OK() {
+			t.Fatalf("expected an intact tree, got %v", report.Problems)
+		}
+		if report.Keys != 2000 {
+			t.Errorf("expected 2000 keys, got %d", report.Keys)
+		}
+		for _, i := range []int{0, 1, 20, 21, 1999} {
+			value, err := idx.Search(key(i))
+			if err != nil {
+				t.Fatalf("failed to search %s: %v", key(i), err)
+			}
+			if value != uint64(i) {
+				t.Errorf("expected %d for %s, got %d", i, key(i), value)
+			}
+		}
+	})
+
+	t.Run("reclaims unreachable pages", func(t *testing.T) {
+		reachable := len(reachablePages(t, idx))
+		free, err := idx.alloc.FreeCount()
+		if err != nil {
+			t.Fatalf("failed to count free pages: %v", err)
+		}
+		if want := int(p.GetNumPages()) - 1 - reachable; free != want {
+			t.Errorf("expected %d free pages, got %d", want, free)
+		}
+
+		numPages := p.GetNumPages()
+		for i := range free / 2 {
+			if err := idx.Insert(key(i), 0, Upsert); err != nil {
+				t.Fatalf("failed to update: %v", err)
+			}
+		}
+		if p.GetNumPages() != numPages {
+			t.Errorf("expected free pages to be reused, file grew from %d to %d pages", numPages, p.GetNumPages())
+		}
+	})
+}
+
+func TestCloseMarksClean(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	p, err := pager.NewPager(path)
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	idx, err := NewIndex(p)
+	if err != nil {
+		t.Fatalf("failed to initialize index: %v", err)
+	}
+	for i := range 1000 {
+		if err := idx.Insert(fmt.Appendf(nil, "key_%06d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	for i := range 500 {
+		if err := idx.Delete(fmt.Appendf(nil, "key_%06d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	p, err = pager.NewPager(path)
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	meta, err := p.ReadPage(0)
+	if err != nil {
+		t.Fatalf("failed to read meta page: %v", err)
+	}
+	if meta.Data[8]&metaClean == 0 {
+		t.Fatalf("expected the meta page to be marked clean")
+	}
+	freeListID := pager.PageID(binary.LittleEndian.Uint32(meta.Data[4:]))
+
+	idx, err = NewIndex(p)
+	if err != nil {
+		t.Fatalf("failed to reopen index: %v", err)
+	}
+	defer idx.Close()
+
+	if idx.alloc.Head() != freeListID {
+		t.Errorf("expected free list at page %d, got %d", freeListID, idx.alloc.Head())
+	}
+	free, err := idx.alloc.FreeCount()
+	if err != nil {
+		t.Fatalf("failed to count free pages: %v", err)
+	}
+	if want := int(p.GetNumPages()) - 1 - len(reachablePages(t, idx)); free != want {
+		t.Errorf("expected %d free pages, got %d", want, free)
+	}
+	meta, err = p.ReadPage(0)
+	if err != nil {
+		t.Fatalf("failed to read meta page: %v", err)
+	}
+	if meta.Data[8]&metaClean != 0 {
+		t.Errorf("expected the meta page to be marked unclean while open")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	p, err := pager.NewPager(path)
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	idx, err := NewIndex(p)
+	if err != nil {
+		t.Fatalf("failed to initialize index: %v", err)
+	}
+	for i := range 1000 {
+		if err := idx.Insert(fmt.Appendf(nil, "key_%06d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	p, err = pager.NewPagerWithOptions(path, pager.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	idx, err = NewIndexWithOptions(p, Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open index read-only: %v", err)
+	}
+	if value, err := idx.Search([]byte("key_000500")); err != nil || value != 500 {
+		t.Errorf("expected 500, got %d, err %v", value, err)
+	}
+	if err := idx.Insert([]byte("key"), 0, Upsert); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v from Insert, got %v", ErrReadOnly, err)
+	}
+	if err := idx.Delete([]byte("key_000500")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v from Delete, got %v", ErrReadOnly, err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// The index was left marked clean.
+	p, err = pager.NewPager(path)
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	defer p.Close()
+	meta, err := p.ReadPage(0)
+	if err != nil {
+		t.Fatalf("failed to read meta page: %v", err)
+	}
+	if meta.Data[8]&metaClean == 0 {
+		t.Errorf("expected a read-only open to leave the meta page clean")
+	}
+}
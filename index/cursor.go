@@ -10,9 +10,24 @@ import (
 type Cursor struct {
 	index  *Index
 	pageID pager.PageID
-	endKey []byte
-	keyNum int
-	isEnd  bool
+	// upper is the separator above the leaf at pageID, at which the next
+	// leaf starts, or nil for the last leaf.
+	upper   []byte
+	endKey  []byte
+	keyNum  int
+	isEnd   bool
+	payload []byte
+	// lastKey is the key last returned, from which the cursor seeks again
+	// if the index changed since.
+	lastKey []byte
+	version uint64
+	// limit is the number of keys of the leaf at limitPage that are below
+	// endKey, found once per leaf instead of comparing every key.
+	limit     int
+	limitPage pager.PageID
+	// depth is the number of internal nodes the last seek went through,
+	// after which the next one reads the leaf with a scan.
+	depth int
 }
 
 func (idx *Index) NewCursor(startKey, endKey []byte) (*Cursor, error) {
@@ -20,79 +35,194 @@ func (idx *Index) NewCursor(startKey, endKey []byte) (*Cursor, error) {
 		return &Cursor{isEnd: true}, nil
 	}
 
-	pageID := idx.root
-	n, _, err := idx.readNode(pageID)
-	if err != nil {
+	c := &Cursor{index: idx, endKey: endKey, version: idx.version}
+	if err := c.seek(startKey, false); err != nil {
 		return nil, err
 	}
-	keyNum := 0
+	return c, nil
+}
 
-	if startKey == nil {
-		for n.nodeType == NodeTypeInternal {
-			pageID = n.children[0]
-			n, _, err = idx.readNode(pageID)
-			if err != nil {
-				return nil, err
-			}
+// NewPrefixCursor returns a cursor over the keys that start with prefix.
+func (idx *Index) NewPrefixCursor(prefix []byte) (*Cursor, error) {
+	return idx.NewCursor(prefix, prefixEnd(prefix))
+}
+
+// prefixEnd returns the first key past every key with the given prefix, or
+// nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
 		}
-	} else {
+	}
+	return nil
+}
+
+// seek positions the cursor at the first key at or, if after is set, past
+// startKey. A nil startKey is before every key.
+func (c *Cursor) seek(startKey []byte, after bool) error {
+	idx := c.index
+	if idx.root == 0 {
+		c.isEnd = true
+		return nil
+	}
+
+	for {
+		pageID := idx.root
+		n, err := c.readNode(pageID, 0)
+		if err != nil {
+			return err
+		}
+		var upper []byte
+		depth := 0
 		for n.nodeType == NodeTypeInternal {
-			i := sort.Search(len(n.keys), func(j int) bool {
-				return bytes.Compare(n.keys[j], startKey) > 0
-			})
+			i := 0
+			if startKey != nil {
+				i = sort.Search(len(n.keys), func(j int) bool {
+					return bytes.Compare(n.keys[j], startKey) > 0
+				})
+			}
+			if i < len(n.keys) {
+				upper = n.keys[i]
+			}
 			pageID = n.children[i]
-			n, _, err = idx.readNode(pageID)
+			depth++
+			n, err = c.readNode(pageID, depth)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
+		c.depth = depth
 
-		keyNum = sort.Search(len(n.keys), func(j int) bool {
-			return bytes.Compare(n.keys[j], startKey) >= 0
-		})
-		if keyNum >= len(n.keys) {
-			pageID = n.next
-			keyNum = 0
+		keyNum := 0
+		if startKey != nil {
+			keyNum = sort.Search(len(n.keys), func(j int) bool {
+				cmp := bytes.Compare(n.keys[j], startKey)
+				return cmp > 0 || cmp == 0 && !after
+			})
 		}
+
+		c.pageID = pageID
+		c.upper = upper
+		c.keyNum = keyNum
+		c.isEnd = false
+		c.limitPage = 0
+		if keyNum < len(n.keys) || upper == nil {
+			c.isEnd = keyNum >= len(n.keys)
+			return nil
+		}
+		startKey, after = upper, false
+	}
+}
+
+// readNode reads the node at depth of the path of a seek, with a scan if
+// it is a leaf, going by the depth of the last one. The leaves a cursor
+// passes are left out of the cache, while the internal nodes above them,
+// which every seek reads, are kept.
+func (c *Cursor) readNode(pageID pager.PageID, depth int) (*node, error) {
+	if depth > 0 && depth == c.depth {
+		return c.index.scanNode(pageID)
 	}
+	n, _, err := c.index.readNode(pageID)
+	return n, err
+}
 
-	return &Cursor{
-		index:  idx,
-		pageID: pageID,
-		keyNum: keyNum,
-		isEnd:  pageID == 0,
-		endKey: endKey,
-	}, nil
+// nextLeaf moves the cursor to the first key of the leaf after the current
+// one. Leaves do not point to each other, as a page written again moves to
+// a new one, so it seeks from the root to the separator above the current
+// leaf.
+func (c *Cursor) nextLeaf() error {
+	if c.upper == nil || c.endKey != nil && bytes.Compare(c.upper, c.endKey) >= 0 {
+		c.isEnd = true
+		return nil
+	}
+	return c.seek(c.upper, false)
 }
 
 func (c *Cursor) Next() ([]byte, uint64, error) {
+	if !c.isEnd && c.lastKey != nil && c.version != c.index.version {
+		if err := c.seek(c.lastKey, true); err != nil {
+			return nil, 0, err
+		}
+		c.version = c.index.version
+	}
+
 	for {
 		if c.isEnd {
 			return nil, 0, nil
 		}
 
-		n, _, err := c.index.readNode(c.pageID)
+		n, err := c.index.scanNode(c.pageID)
 		if err != nil {
 			return nil, 0, err
 		}
 
+		if c.limitPage != c.pageID {
+			c.limit = len(n.keys)
+			if c.endKey != nil && len(n.keys) > 0 && bytes.Compare(n.keys[len(n.keys)-1], c.endKey) >= 0 {
+				c.limit = sort.Search(len(n.keys), func(j int) bool {
+					return bytes.Compare(n.keys[j], c.endKey) >= 0
+				})
+			}
+			c.limitPage = c.pageID
+		}
+
 		if c.keyNum < len(n.keys) {
-			key := n.keys[c.keyNum]
-			if c.endKey != nil && bytes.Compare(key, c.endKey) >= 0 {
+			if c.keyNum >= c.limit {
 				c.isEnd = true
 				return nil, 0, nil
 			}
+			key := n.keys[c.keyNum]
 			value := n.values[c.keyNum]
+			c.payload = n.payload(c.keyNum)
 			c.keyNum++
+			c.lastKey = key
 			return key, value, nil
 		}
 
-		c.pageID = n.next
-		c.keyNum = 0
+		if err := c.nextLeaf(); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// Payload returns the inline payload of the entry last returned by Next, or
+// nil if that entry holds an offset.
+func (c *Cursor) Payload() []byte {
+	return c.payload
+}
 
-		if c.pageID == 0 {
+// Count returns the number of keys in [startKey, endKey), counting whole
+// leaves at a time instead of visiting every entry.
+func (idx *Index) Count(startKey, endKey []byte) (int, error) {
+	c, err := idx.NewCursor(startKey, endKey)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for !c.isEnd {
+		n, err := idx.scanNode(c.pageID)
+		if err != nil {
+			return 0, err
+		}
+
+		limit := len(n.keys)
+		if endKey != nil && limit > 0 && bytes.Compare(n.keys[limit-1], endKey) >= 0 {
+			limit = sort.Search(len(n.keys), func(j int) bool {
+				return bytes.Compare(n.keys[j], endKey) >= 0
+			})
 			c.isEnd = true
-			return nil, 0, nil
+		}
+		count += max(limit-c.keyNum, 0)
+
+		if !c.isEnd {
+			if err := c.nextLeaf(); err != nil {
+				return 0, err
+			}
 		}
 	}
+	return count, nil
 }
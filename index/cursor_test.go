@@ -1,9 +1,14 @@
 package index
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"slices"
 	"testing"
+
+	"github.com/rizalta/toydb/metrics"
+	"github.com/rizalta/toydb/pager"
 )
 
 func TestCursorRange(t *testing.T) {
@@ -102,6 +107,178 @@ func TestCursorRange(t *testing.T) {
 			if !reflect.DeepEqual(tt.expected, foundKeys) {
 				t.Errorf("expected keys not matching with scanned keys for startKey %s, endKey %s", tt.startKey, tt.endKey)
 			}
+
+			count, err := idx.Count(tt.startKey, tt.endKey)
+			if err != nil {
+				t.Fatalf("failed to count: %v", err)
+			}
+			if count != len(tt.expected) {
+				t.Errorf("expected count %d, got %d", len(tt.expected), count)
+			}
 		})
 	}
 }
+
+func TestCursorDuringModification(t *testing.T) {
+	idx := newTestIndex(t)
+	defer idx.Close()
+
+	for i := range 5000 {
+		if err := idx.Insert(fmt.Appendf(nil, "key_%04d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	// Delete every key as it is visited, which empties and merges the
+	// leaves under the cursor, and insert keys both behind and ahead of it.
+	cursor, err := idx.NewCursor(nil, []byte("key_9"))
+	if err != nil {
+		t.Fatalf("failed to create cursor: %v", err)
+	}
+	var visited []string
+	for {
+		key, _, err := cursor.Next()
+		if err != nil {
+			t.Fatalf("failed to advance cursor: %v", err)
+		}
+		if key == nil {
+			break
+		}
+		visited = append(visited, string(key))
+		if err := idx.Delete(key); err != nil {
+			t.Fatalf("failed to delete %s: %v", key, err)
+		}
+		if len(visited) == 100 {
+			if err := idx.Insert([]byte("key_0000_behind"), 1, Upsert); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+			if err := idx.Insert([]byte("key_4999_ahead"), 2, Upsert); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+	}
+
+	var expected []string
+	for i := range 5000 {
+		expected = append(expected, fmt.Sprintf("key_%04d", i))
+	}
+	expected = append(expected, "key_4999_ahead")
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("expected to visit %d keys once each, got %d", len(expected), len(visited))
+	}
+}
+
+func TestPrefixCursor(t *testing.T) {
+	idx := newTestIndex(t)
+	defer idx.Close()
+
+	var all [][]byte
+	for _, prefix := range []string{"a", "b", "b\xff", "c"} {
+		for i := range 1000 {
+			key := fmt.Appendf(nil, "%s_%03d", prefix, i)
+			if err := idx.Insert(key, uint64(i), Upsert); err != nil {
+				t.Fatalf("failed to insert %q: %v", key, err)
+			}
+			all = append(all, key)
+		}
+	}
+	slices.SortFunc(all, bytes.Compare)
+
+	tests := []struct {
+		name   string
+		prefix []byte
+	}{
+		{"Empty prefix", nil},
+		{"First prefix", []byte("a_")},
+		{"Middle prefix", []byte("b_1")},
+		{"Prefix ending in 0xff", []byte("b\xff")},
+		{"Prefix of all 0xff", []byte("\xff")},
+		{"Last prefix", []byte("c_99")},
+		{"Missing prefix", []byte("bb")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := [][]byte{}
+			for _, key := range all {
+				if bytes.HasPrefix(key, tt.prefix) {
+					expected = append(expected, key)
+				}
+			}
+
+			c, err := idx.NewPrefixCursor(tt.prefix)
+			if err != nil {
+				t.Fatalf("failed to create cursor: %v", err)
+			}
+			found := [][]byte{}
+			for {
+				key, _, err := c.Next()
+				if err != nil {
+					t.Fatalf("next call failed: %v", err)
+				}
+				if key == nil {
+					break
+				}
+				found = append(found, key)
+			}
+
+			if !reflect.DeepEqual(expected, found) {
+				t.Errorf("expected %d keys with prefix %q, got %d", len(expected), tt.prefix, len(found))
+			}
+		})
+	}
+}
+
+func TestCursorKeepsCache(t *testing.T) {
+	for _, eviction := range []pager.EvictionPolicy{pager.EvictLRU, pager.EvictClock} {
+		registry := &metrics.Registry{}
+		p, err := pager.NewPagerWithOptions("", pager.Options{InMemory: true, CacheSize: 8, Eviction: eviction, Metrics: registry})
+		if err != nil {
+			t.Fatalf("failed to initialize pager: %v", err)
+		}
+		idx, err := NewIndex(p)
+		if err != nil {
+			t.Fatalf("failed to initialize index: %v", err)
+		}
+		for i := range 5000 {
+			if err := idx.Insert(fmt.Appendf(nil, "key_%04d", i), uint64(i), Upsert); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+
+		lookup := func() {
+			if _, _, err := idx.Lookup([]byte("key_2500")); err != nil {
+				t.Fatalf("failed to look up: %v", err)
+			}
+		}
+		lookup()
+		misses := registry.CacheMisses.Load()
+		count, err := idx.Count(nil, nil)
+		if err != nil || count != 5000 {
+			t.Fatalf("expected 5000 keys, got %d and %v", count, err)
+		}
+		c, err := idx.NewCursor(nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create cursor: %v", err)
+		}
+		for {
+			key, _, err := c.Next()
+			if err != nil {
+				t.Fatalf("next call failed: %v", err)
+			}
+			if key == nil {
+				break
+			}
+		}
+		if registry.CacheMisses.Load() == misses {
+			t.Fatalf("expected the scans to read leaves from the file")
+		}
+
+		misses = registry.CacheMisses.Load()
+		lookup()
+		if got := registry.CacheMisses.Load() - misses; got != 0 {
+			t.Errorf("policy %d: expected the path of the lookup to stay cached, got %d misses", eviction, got)
+		}
+		idx.Close()
+	}
+}
@@ -8,170 +8,190 @@ import (
 )
 
 func (idx *Index) Delete(key []byte) error {
+	if idx.readOnly {
+		return ErrReadOnly
+	}
 	if idx.root == 0 {
 		return ErrKeyNotFound
 	}
 
-	if err := idx.delete(0, idx.root, key); err != nil {
+	idx.version++
+	rootID, err := idx.delete(idx.root, key)
+	if err != nil {
 		return err
 	}
+	idx.root = rootID
 
 	root, _, err := idx.readNode(idx.root)
 	if err != nil {
 		return err
 	}
 	if root.nodeType == NodeTypeInternal && len(root.keys) == 0 {
+		if err := idx.release(idx.root); err != nil {
+			return err
+		}
 		if len(root.children) > 0 {
 			idx.root = root.children[0]
 		} else {
 			idx.root = 0
 		}
-		if err := idx.syncMetaPage(); err != nil {
-			return err
-		}
 	}
 
-	return nil
+	return idx.maybeCommit()
 }
 
-func (idx *Index) delete(parentID, pageID pager.PageID, key []byte) error {
-	n, page, err := idx.readNode(pageID)
+// delete removes key from the subtree at pageID and returns the page the
+// root of the subtree is now on.
+func (idx *Index) delete(pageID pager.PageID, key []byte) (pager.PageID, error) {
+	n, _, err := idx.readNode(pageID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if n.nodeType == NodeTypeLeaf {
 		i := sort.Search(len(n.keys), func(j int) bool {
 			return bytes.Compare(n.keys[j], key) >= 0
 		})
-		if i < len(n.keys) && bytes.Equal(key, n.keys[i]) {
-			n.keys = append(n.keys[:i], n.keys[i+1:]...)
-			n.values = append(n.values[:i], n.values[i+1:]...)
-			if err := idx.writeNode(page, n); err != nil {
-				return err
-			}
-		} else {
-			return ErrKeyNotFound
-		}
-		if parentID != 0 && n.calculateSize() < mergeThreshold {
-			return idx.fixUnderflow(parentID, pageID)
-		}
-	} else {
-		i := sort.Search(len(n.keys), func(j int) bool {
-			return bytes.Compare(n.keys[j], key) > 0
-		})
-
-		childID := n.children[i]
-		err = idx.delete(pageID, childID, key)
-		if err != nil {
-			return err
-		}
-		child, _, err := idx.readNode(childID)
-		if err != nil {
-			return err
-		}
-		if child.calculateSize() < mergeThreshold {
-			return idx.fixUnderflow(pageID, childID)
+		if i >= len(n.keys) || !bytes.Equal(key, n.keys[i]) {
+			return 0, ErrKeyNotFound
 		}
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+		n.payloads = append(n.payloads[:i], n.payloads[i+1:]...)
+		return idx.putNode(pageID, n)
 	}
 
-	return nil
-}
+	i := sort.Search(len(n.keys), func(j int) bool {
+		return bytes.Compare(n.keys[j], key) > 0
+	})
 
-func (idx *Index) fixUnderflow(parentID, childID pager.PageID) error {
-	parentNode, parentPage, err := idx.readNode(parentID)
+	childID, err := idx.delete(n.children[i], key)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	changed := childID != n.children[i]
+	n.children[i] = childID
 
-	childNode, childPage, err := idx.readNode(childID)
+	child, _, err := idx.readNode(childID)
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	childIdx := -1
-	for i, c := range parentNode.children {
-		if c == childID {
-			childIdx = i
-			break
+	if child.calculateSize() < mergeThreshold {
+		fixed, err := idx.fixUnderflow(n, i)
+		if err != nil {
+			return 0, err
 		}
+		changed = changed || fixed
 	}
 
-	if childIdx == -1 {
-		return ErrKeyNotFound
+	if !changed {
+		return pageID, nil
 	}
+	return idx.putNode(pageID, n)
+}
+
+// fixUnderflow refills the child of parent at childIdx by borrowing an
+// entry from a sibling or merging with one, and reports whether it changed
+// parent.
+func (idx *Index) fixUnderflow(parent *node, childIdx int) (bool, error) {
+	childID := parent.children[childIdx]
+	childNode, _, err := idx.readNode(childID)
+	if err != nil {
+		return false, err
+	}
+	p := parent.clone()
 
+	var leftID pager.PageID
+	var leftNode *node
 	if childIdx > 0 {
-		leftID := parentNode.children[childIdx-1]
-		leftNode, leftPage, err := idx.readNode(leftID)
-		if err != nil {
-			return err
+		leftID = p.children[childIdx-1]
+		if leftNode, _, err = idx.readNode(leftID); err != nil {
+			return false, err
 		}
 		if leftNode.calculateSize() > mergeThreshold {
-			idx.borrowLeft(parentNode, leftNode, childNode, childIdx-1)
-			if err := idx.writeNode(leftPage, leftNode); err != nil {
-				return err
+			idx.borrowLeft(p, leftNode, childNode, childIdx-1)
+			if !fits(p, childNode) {
+				return false, nil
+			}
+			if p.children[childIdx-1], err = idx.putNode(leftID, leftNode); err != nil {
+				return false, err
 			}
-			if err := idx.writeNode(parentPage, parentNode); err != nil {
-				return err
+			if p.children[childIdx], err = idx.putNode(childID, childNode); err != nil {
+				return false, err
 			}
-			return idx.writeNode(childPage, childNode)
+			*parent = *p
+			return true, nil
 		}
 	}
 
-	if childIdx < len(parentNode.children)-1 {
-		rightID := parentNode.children[childIdx+1]
-		rightNode, rightPage, err := idx.readNode(rightID)
-		if err != nil {
-			return err
+	var rightID pager.PageID
+	var rightNode *node
+	if childIdx < len(p.children)-1 {
+		rightID = p.children[childIdx+1]
+		if rightNode, _, err = idx.readNode(rightID); err != nil {
+			return false, err
 		}
 		if rightNode.calculateSize() > mergeThreshold {
-			idx.borrowRight(parentNode, rightNode, childNode, childIdx)
-			if err := idx.writeNode(rightPage, rightNode); err != nil {
-				return err
+			idx.borrowRight(p, rightNode, childNode, childIdx)
+			if !fits(p, childNode) {
+				return false, nil
 			}
-			if err := idx.writeNode(parentPage, parentNode); err != nil {
-				return err
+			if p.children[childIdx+1], err = idx.putNode(rightID, rightNode); err != nil {
+				return false, err
 			}
-			return idx.writeNode(childPage, childNode)
+			if p.children[childIdx], err = idx.putNode(childID, childNode); err != nil {
+				return false, err
+			}
+			*parent = *p
+			return true, nil
 		}
 	}
 
-	if childIdx > 0 {
-		leftID := parentNode.children[childIdx-1]
-		leftNode, leftPage, err := idx.readNode(leftID)
-		if err != nil {
-			return err
+	if leftNode != nil {
+		idx.merge(p, leftNode, childNode, childIdx-1)
+		if !fits(leftNode) {
+			return false, nil
 		}
-		if err := idx.syncMetaPage(); err != nil {
-			return err
+		if err := idx.release(childID); err != nil {
+			return false, err
 		}
-		idx.merge(parentNode, leftNode, childNode, childIdx-1)
-		if err := idx.pager.FreePage(childID); err != nil {
-			return err
-		}
-		if err := idx.writeNode(leftPage, leftNode); err != nil {
-			return err
+		if p.children[childIdx-1], err = idx.putNode(leftID, leftNode); err != nil {
+			return false, err
 		}
-		return idx.writeNode(parentPage, parentNode)
+		idx.metrics.Merges.Inc()
+		idx.hooks.Merge(uint32(p.children[childIdx-1]), uint32(childID), leftNode.nodeType == NodeTypeLeaf)
 	} else {
-		rightID := parentNode.children[childIdx+1]
-		rightNode, _, err := idx.readNode(rightID)
-		if err != nil {
-			return err
+		if rightNode == nil {
+			return false, nil
 		}
-		idx.merge(parentNode, childNode, rightNode, childIdx)
-		if err := idx.pager.FreePage(rightID); err != nil {
-			return err
+		idx.merge(p, childNode, rightNode, childIdx)
+		if !fits(childNode) {
+			return false, nil
 		}
-		if err := idx.syncMetaPage(); err != nil {
-			return err
+		if err := idx.release(rightID); err != nil {
+			return false, err
 		}
-		if err := idx.writeNode(parentPage, parentNode); err != nil {
-			return err
+		if p.children[childIdx], err = idx.putNode(childID, childNode); err != nil {
+			return false, err
+		}
+		idx.metrics.Merges.Inc()
+		idx.hooks.Merge(uint32(p.children[childIdx]), uint32(rightID), childNode.nodeType == NodeTypeLeaf)
+	}
+	*parent = *p
+	return true, nil
+}
+
+// fits reports whether nodes changed by a borrow or merge still fit in a
+// page. Keys that share a shorter prefix take more room together than
+// apart, and if they do not fit, the change is dropped and the child is
+// left underfull.
+func fits(nodes ...*node) bool {
+	for _, n := range nodes {
+		if n.calculateSize() > splitThreshold {
+			return false
 		}
-		return idx.writeNode(childPage, childNode)
 	}
+	return true
 }
 
 func (idx *Index) borrowLeft(parent, left, child *node, sepKeyIdx int) {
@@ -179,9 +199,14 @@ func (idx *Index) borrowLeft(parent, left, child *node, sepKeyIdx int) {
 	if child.nodeType == NodeTypeLeaf {
 		child.keys = append([][]byte{left.keys[leftIdx]}, child.keys...)
 		child.values = append([]uint64{left.values[leftIdx]}, child.values...)
+		child.payloads = append([][]byte{left.payloads[leftIdx]}, child.payloads...)
 		left.keys = left.keys[:leftIdx]
 		left.values = left.values[:leftIdx]
+		left.payloads = left.payloads[:leftIdx]
 		parent.keys[sepKeyIdx] = child.keys[0]
+		if leftIdx > 0 {
+			parent.keys[sepKeyIdx] = separator(left.keys[leftIdx-1], child.keys[0])
+		}
 	} else {
 		oldSeperator := parent.keys[sepKeyIdx]
 		newSeperator := left.keys[leftIdx]
@@ -197,9 +222,11 @@ func (idx *Index) borrowRight(parent, right, child *node, sepKeyIdx int) {
 	if child.nodeType == NodeTypeLeaf {
 		child.keys = append(child.keys, right.keys[0])
 		child.values = append(child.values, right.values[0])
+		child.payloads = append(child.payloads, right.payloads[0])
 		right.keys = right.keys[1:]
 		right.values = right.values[1:]
-		parent.keys[sepKeyIdx] = right.keys[0]
+		right.payloads = right.payloads[1:]
+		parent.keys[sepKeyIdx] = separator(child.keys[len(child.keys)-1], right.keys[0])
 	} else {
 		oldSeperator := parent.keys[sepKeyIdx]
 		newSeperator := right.keys[0]
@@ -215,7 +242,7 @@ func (idx *Index) merge(parent, left, right *node, sepKeyIdx int) {
 	if left.nodeType == NodeTypeLeaf {
 		left.keys = append(left.keys, right.keys...)
 		left.values = append(left.values, right.values...)
-		left.next = right.next
+		left.payloads = append(left.payloads, right.payloads...)
 	} else {
 		left.keys = append(left.keys, parent.keys[sepKeyIdx])
 		left.keys = append(left.keys, right.keys...)
@@ -188,8 +188,7 @@ func TestDeleteUnderflowBorrow(t *testing.T) {
 		key := makeKey(i)
 		value := uint64(i + 1000)
 		rootNode, _, _ := index.readNode(index.root)
-		entrySize := len(key) + slotSize + valueSize
-		if rootNode.calculateSize()+entrySize > splitThreshold {
+		if sizeWithKey(rootNode, key) > splitThreshold {
 			break
 		}
 		m[i] = value
@@ -252,8 +251,7 @@ func TestDeleteUnderflowMerge(t *testing.T) {
 		rootNode, _, _ := index.readNode(index.root)
 		key := makeKey(i)
 		value := uint64(i + 1000)
-		entrySize := len(key) + slotSize + valueSize
-		if rootNode.calculateSize()+entrySize > splitThreshold {
+		if sizeWithKey(rootNode, key) > splitThreshold {
 			break
 		}
 		m[i] = value
@@ -0,0 +1,152 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+// dumpNode is a node as Dump shows it.
+type dumpNode struct {
+	id   pager.PageID
+	node *node
+}
+
+// fill returns how full the page of the node is, in percent.
+func (d dumpNode) fill() int {
+	return d.node.calculateSize() * 100 / pager.PageSize
+}
+
+// levels reads the tree breadth first and returns its nodes by level, root
+// first and each level in key order.
+func (idx *Index) levels() ([][]dumpNode, error) {
+	var levels [][]dumpNode
+	ids := []pager.PageID{idx.root}
+	for len(ids) > 0 {
+		level := make([]dumpNode, 0, len(ids))
+		var next []pager.PageID
+		for _, id := range ids {
+			n, _, err := idx.readNode(id)
+			if err != nil {
+				return nil, fmt.Errorf("index: failed to read page %d: %w", id, err)
+			}
+			level = append(level, dumpNode{id: id, node: n})
+			if n.nodeType == NodeTypeInternal {
+				next = append(next, n.children...)
+			}
+		}
+		levels = append(levels, level)
+		ids = next
+	}
+	return levels, nil
+}
+
+// Dump writes the tree level by level, root first, one line per node with
+// its page ID, kind, number of keys, first and last key and how full its
+// page is.
+func (idx *Index) Dump(w io.Writer) error {
+	levels, err := idx.levels()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for depth, level := range levels {
+		fmt.Fprintf(bw, "level %d: %d pages\n", depth, len(level))
+		for _, d := range level {
+			n := d.node
+			kind := "internal"
+			if n.nodeType == NodeTypeLeaf {
+				kind = "leaf"
+			}
+			fmt.Fprintf(bw, "  page %d: %s, %d keys", d.id, kind, len(n.keys))
+			if len(n.keys) > 0 {
+				fmt.Fprintf(bw, " %q..%q", n.keys[0], n.keys[len(n.keys)-1])
+			}
+			fmt.Fprintf(bw, ", %d%% full\n", d.fill())
+		}
+	}
+	return bw.Flush()
+}
+
+// DOTOptions limits the part of the tree DumpDOT draws.
+type DOTOptions struct {
+	// MaxDepth is the deepest level drawn, the root being level 0. Zero
+	// draws every level.
+	MaxDepth int
+	// Start and End limit the drawing to the nodes whose keys may fall in
+	// [Start, End). A nil bound is open.
+	Start, End []byte
+}
+
+// DumpDOT writes the tree as a Graphviz digraph, one record per node with
+// its page ID, kind, number of keys, first and last key and fill, and an
+// edge to each child.
+func (idx *Index) DumpDOT(w io.Writer, opts DOTOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph btree {\n\tnode [shape=record];\n")
+
+	var walk func(id pager.PageID, low, high []byte, depth int) error
+	walk = func(id pager.PageID, low, high []byte, depth int) error {
+		n, _, err := idx.readNode(id)
+		if err != nil {
+			return fmt.Errorf("index: failed to read page %d: %w", id, err)
+		}
+		d := dumpNode{id: id, node: n}
+
+		kind := "internal"
+		if n.nodeType == NodeTypeLeaf {
+			kind = "leaf"
+		}
+		keys := fmt.Sprintf("%d keys", len(n.keys))
+		if len(n.keys) > 0 {
+			keys += fmt.Sprintf(" %q..%q", n.keys[0], n.keys[len(n.keys)-1])
+		}
+		fmt.Fprintf(bw, "\tpage%d [label=\"{page %d %s|%s|%d%% full}\"];\n", id, id, kind, dotEscape(keys), d.fill())
+
+		if n.nodeType == NodeTypeLeaf || opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+			return nil
+		}
+		for i, child := range n.children {
+			childLow, childHigh := low, high
+			if i > 0 {
+				childLow = n.keys[i-1]
+			}
+			if i < len(n.keys) {
+				childHigh = n.keys[i]
+			}
+			if opts.End != nil && childLow != nil && bytes.Compare(childLow, opts.End) >= 0 ||
+				opts.Start != nil && childHigh != nil && bytes.Compare(childHigh, opts.Start) <= 0 {
+				continue
+			}
+			fmt.Fprintf(bw, "\tpage%d -> page%d;\n", id, child)
+			if err := walk(child, childLow, childHigh, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(idx.root, nil, nil, 0); err != nil {
+		return err
+	}
+
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// dotEscape escapes the characters that are special in Graphviz record
+// labels.
+func dotEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\"{}|<>`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	idx := newTestIndex(t)
+	defer idx.Close()
+
+	for _, key := range []string{"b", "a", "c"} {
+		if err := idx.Insert([]byte(key), 1, Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := idx.Dump(&buf); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	expected := fmt.Sprintf("level 0: 1 pages\n  page %d: leaf, 3 keys \"a\"..\"c\", 1%% full\n", idx.root)
+	if buf.String() != expected {
+		t.Errorf("unexpected dump:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	for i := range 2000 {
+		if err := idx.Insert(fmt.Appendf(nil, "key_%06d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	report, err := idx.Verify()
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+
+	buf.Reset()
+	if err := idx.Dump(&buf); err != nil {
+		t.Fatalf("failed to dump: %v", err)
+	}
+	dump := buf.String()
+	if levels := strings.Count(dump, "level "); levels != report.Depth {
+		t.Errorf("expected %d levels, got %d", report.Depth, levels)
+	}
+	if pages := strings.Count(dump, "  page "); pages != report.Pages {
+		t.Errorf("expected %d pages, got %d", report.Pages, pages)
+	}
+	if leaves := strings.Count(dump, ": leaf, "); leaves != report.Leaves {
+		t.Errorf("expected %d leaves, got %d", report.Leaves, leaves)
+	}
+}
+
+func TestDumpDOT(t *testing.T) {
+	idx := newTestIndex(t)
+	defer idx.Close()
+
+	for i := range 2000 {
+		if err := idx.Insert(fmt.Appendf(nil, "key_%06d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := idx.Insert([]byte("{a|b}"), 1, Upsert); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	report, err := idx.Verify()
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	root, _, err := idx.readNode(idx.root)
+	if err != nil {
+		t.Fatalf("failed to read root: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		opts       DOTOptions
+		nodes      int
+		wantLabels []string
+	}{
+		{
+			name:       "whole tree",
+			nodes:      report.Pages,
+			wantLabels: []string{`\"\{a\|b\}\"`},
+		},
+		{
+			name:  "depth",
+			opts:  DOTOptions{MaxDepth: 1},
+			nodes: 1 + len(root.children),
+		},
+		{
+			name:  "range",
+			opts:  DOTOptions{Start: []byte("key_000500"), End: []byte("key_000501")},
+			nodes: report.Depth,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := idx.DumpDOT(&buf, tt.opts); err != nil {
+				t.Fatalf("failed to dump: %v", err)
+			}
+			dot := buf.String()
+
+			if !strings.HasPrefix(dot, "digraph btree {\n") || !strings.HasSuffix(dot, "}\n") {
+				t.Errorf("expected a digraph, got:\n%s", dot)
+			}
+			if nodes := strings.Count(dot, "[label="); nodes != tt.nodes {
+				t.Errorf("expected %d nodes, got %d", tt.nodes, nodes)
+			}
+			if edges := strings.Count(dot, " -> "); edges != tt.nodes-1 {
+				t.Errorf("expected %d edges, got %d", tt.nodes-1, edges)
+			}
+			for _, label := range tt.wantLabels {
+				if !strings.Contains(dot, label) {
+					t.Errorf("expected %s in:\n%s", label, dot)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package index_test
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/pager"
+)
+
+func ExampleIndex() {
+	dir, err := os.MkdirTemp("", "toydb-index")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := pager.NewPager(filepath.Join(dir, "index.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	idx, err := index.NewIndex(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer idx.Close()
+
+	if err := idx.Insert([]byte("k1"), 100, index.InsertOnly); err != nil {
+		log.Fatal(err)
+	}
+	if err := idx.Insert([]byte("k1"), 200, index.InsertOnly); errors.Is(err, index.ErrKeyAlreadyExists) {
+		fmt.Println("k1 already exists")
+	}
+	if err := idx.Insert([]byte("k1"), 300, index.Upsert); err != nil {
+		log.Fatal(err)
+	}
+
+	value, err := idx.Search([]byte("k1"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(value)
+
+	if err := idx.Delete([]byte("k1")); err != nil {
+		log.Fatal(err)
+	}
+	_, err = idx.Search([]byte("k1"))
+	fmt.Println(errors.Is(err, index.ErrKeyNotFound))
+
+	// Output:
+	// k1 already exists
+	// 300
+	// true
+}
+
+func ExampleIndex_NewCursor() {
+	dir, err := os.MkdirTemp("", "toydb-index")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := pager.NewPager(filepath.Join(dir, "index.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	idx, err := index.NewIndex(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer idx.Close()
+
+	for i := range 5 {
+		key := fmt.Appendf(nil, "key_%d", i)
+		if err := idx.Insert(key, uint64(i), index.Upsert); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cursor, err := idx.NewCursor([]byte("key_1"), []byte("key_4"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		key, value, err := cursor.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if key == nil {
+			break
+		}
+		fmt.Printf("%s -> %d\n", key, value)
+	}
+
+	// Output:
+	// key_1 -> 1
+	// key_2 -> 2
+	// key_3 -> 3
+}
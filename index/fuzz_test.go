@@ -0,0 +1,179 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+// modelIndex is what FuzzIndex and FuzzHashIndex check against a map.
+type modelIndex interface {
+	Insert(key []byte, value uint64, mode InsertMode) error
+	InsertInline(key []byte, payload []byte, mode InsertMode) error
+	Lookup(key []byte) (uint64, []byte, error)
+	Delete(key []byte) error
+	Commit() error
+}
+
+type modelEntry struct {
+	value   uint64
+	payload []byte
+}
+
+// fuzzKey picks one of 64 keys, some long enough that a few of them fill
+// a page, from b.
+func fuzzKey(b byte) []byte {
+	return fmt.Appendf(nil, "%02x%s", b&0x3f, strings.Repeat("p", int(b>>6)*70))
+}
+
+// checkIndexModel applies the operations data encodes, three bytes each, to
+// idx and to a map, and fails t where they disagree. It returns the map.
+func checkIndexModel(t *testing.T, idx modelIndex, data []byte) map[string]modelEntry {
+	t.Helper()
+	model := map[string]modelEntry{}
+	for i := 0; i+3 <= len(data); i += 3 {
+		kind, key, arg := data[i]%8, fuzzKey(data[i+1]), data[i+2]
+		current, exists := model[string(key)]
+		entry := modelEntry{value: uint64(arg)}
+
+		var err, expected error
+		switch kind {
+		case 0, 1:
+			err = idx.Insert(key, entry.value, Upsert)
+		case 2:
+			entry.payload = bytes.Repeat([]byte{arg}, int(arg)*4)
+			err = idx.InsertInline(key, entry.payload, Upsert)
+		case 3:
+			err = idx.Insert(key, entry.value, InsertOnly)
+			if exists {
+				expected = ErrKeyAlreadyExists
+			}
+		case 4:
+			err = idx.Insert(key, entry.value, UpdateOnly)
+			if !exists {
+				expected = ErrKeyNotFound
+			}
+		case 5, 6:
+			err = idx.Delete(key)
+			if !exists {
+				expected = ErrKeyNotFound
+			}
+		case 7:
+			if err := idx.Commit(); err != nil {
+				t.Fatalf("op %d: failed to commit: %v", i/3, err)
+			}
+			continue
+		}
+		if !errors.Is(err, expected) {
+			t.Fatalf("op %d on %q: expected %v, got %v", i/3, key, expected, err)
+		}
+		if err == nil {
+			if kind == 5 || kind == 6 {
+				delete(model, string(key))
+			} else {
+				model[string(key)] = entry
+			}
+		}
+
+		current, exists = model[string(key)]
+		value, payload, err := idx.Lookup(key)
+		switch {
+		case !exists:
+			if !errors.Is(err, ErrKeyNotFound) {
+				t.Fatalf("op %d: expected %q to be gone, got %v", i/3, key, err)
+			}
+		case err != nil:
+			t.Fatalf("op %d: failed to look up %q: %v", i/3, key, err)
+		case current.payload != nil:
+			if !bytes.Equal(payload, current.payload) {
+				t.Fatalf("op %d: expected the payload of %q to be %d bytes, got %d", i/3, key, len(current.payload), len(payload))
+			}
+		case payload != nil || value != current.value:
+			t.Fatalf("op %d: expected %q to hold %d, got %d and payload %v", i/3, key, current.value, value, payload)
+		}
+	}
+	return model
+}
+
+func newFuzzPager(t *testing.T) *pager.Pager {
+	t.Helper()
+	p, err := pager.NewPagerWithOptions("", pager.Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	return p
+}
+
+func fuzzSeeds(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 5, 1, 0, 3, 1, 4})
+	f.Add([]byte{2, 0xc0, 255, 2, 0xc1, 255, 2, 0xc2, 255, 5, 0xc1, 0, 7, 0, 0})
+	var fill []byte
+	for i := range 64 {
+		fill = append(fill, 0, byte(i)|0xc0, byte(i))
+	}
+	for i := range 64 {
+		fill = append(fill, 5, byte(i)|0xc0, 0)
+	}
+	f.Add(fill)
+}
+
+func FuzzIndex(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		idx, err := NewIndex(newFuzzPager(t))
+		if err != nil {
+			t.Fatalf("failed to initialize index: %v", err)
+		}
+		defer idx.Close()
+		model := checkIndexModel(t, idx, data)
+
+		cursor, err := idx.NewCursor(nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create cursor: %v", err)
+		}
+		var keys []string
+		for {
+			key, _, err := cursor.Next()
+			if err != nil {
+				t.Fatalf("failed to advance cursor: %v", err)
+			}
+			if key == nil {
+				break
+			}
+			keys = append(keys, string(key))
+		}
+		if expected := slices.Sorted(maps.Keys(model)); !slices.Equal(keys, expected) {
+			t.Fatalf("expected the cursor to return %q, got %q", expected, keys)
+		}
+
+		report, err := idx.Verify()
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+		if !report.OK() {
+			t.Fatalf("expected a sound tree, got %+v", report.Problems)
+		}
+	})
+}
+
+func FuzzHashIndex(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := NewHashIndex(newFuzzPager(t))
+		if err != nil {
+			t.Fatalf("failed to initialize hash index: %v", err)
+		}
+		defer h.Close()
+		model := checkIndexModel(t, h, data)
+
+		if h.Len() != len(model) {
+			t.Fatalf("expected %d keys, got %d", len(model), h.Len())
+		}
+	})
+}
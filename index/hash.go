@@ -0,0 +1,540 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"slices"
+
+	"github.com/rizalta/toydb/checksum"
+	"github.com/rizalta/toydb/pager"
+)
+
+// MaxHashKeySize is the longest key a HashIndex accepts. With inline
+// payloads bounded by MaxInlineSize too, a bucket always has room for two
+// entries, so splitting it makes progress.
+const MaxHashKeySize = 1024
+
+var (
+	ErrKeyTooLarge      = errors.New("index: key too large")
+	ErrNotHashIndex     = errors.New("index: not a hash index")
+	ErrHashDirFull      = errors.New("index: hash directory at its largest")
+	ErrHashDirTruncated = errors.New("index: hash directory is cut short")
+)
+
+const (
+	hashMagic = 0x68736831
+	// maxHashDepth bounds the directory to 1<<maxHashDepth buckets. Only
+	// keys sharing that many bits of their hash fill it.
+	maxHashDepth = 20
+
+	bucketHeaderSize = 8
+	dirHeaderSize    = 8
+	dirEntries       = (pager.PageSize - dirHeaderSize) / 4
+	// offsetTag stands in the payload length of an entry that holds an
+	// offset.
+	offsetTag = 0xffff
+)
+
+// HashIndex maps keys to values, or to inline payloads, by extendible
+// hashing. It answers point lookups in one page read but keeps no order,
+// so it has no cursors or ranges.
+//
+// A directory of 1<<depth slots, indexed by the low bits of the FNV-1a hash
+// of a key, points at buckets, each one page. A bucket of local depth d is
+// pointed at by every slot that shares its low d bits. A full bucket is
+// split in two of depth d+1, doubling the directory first if d is the
+// depth of the directory. Buckets are not merged when emptied.
+//
+// Pages are copied on write as in Index. A commit writes the directory to
+// new pages and then the meta page, which holds its first page, the
+// depth, the number of keys and the checksum algorithm. The free list is
+// not recorded: opening the index rebuilds it from the pages the
+// directory does not use, which only reads the directory.
+type HashIndex struct {
+	pager    Pager
+	alloc    *pager.Allocator
+	checksum checksum.Algorithm
+
+	depth    uint8
+	dir      []pager.PageID
+	dirPages []pager.PageID
+	// dirDirty is set once the directory changed since the last commit.
+	dirDirty bool
+	count    int
+}
+
+type bucket struct {
+	depth   uint8
+	entries []hashEntry
+}
+
+type hashEntry struct {
+	key     []byte
+	value   uint64
+	payload []byte
+}
+
+func (e *hashEntry) size() int {
+	if e.payload != nil {
+		return 4 + len(e.key) + len(e.payload)
+	}
+	return 4 + len(e.key) + valueSize
+}
+
+func (b *bucket) size() int {
+	size := bucketHeaderSize
+	for i := range b.entries {
+		size += b.entries[i].size()
+	}
+	return size
+}
+
+func (b *bucket) find(key []byte) int {
+	for i := range b.entries {
+		if bytes.Equal(b.entries[i].key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+func NewHashIndex(p Pager) (*HashIndex, error) {
+	return NewHashIndexWithOptions(p, Options{})
+}
+
+func NewHashIndexWithOptions(p Pager, opts Options) (*HashIndex, error) {
+	if p.GetNumPages() == 0 {
+		if !opts.Checksum.Valid() {
+			return nil, checksum.ErrUnknownAlgorithm
+		}
+		if _, err := p.NewPage(); err != nil {
+			return nil, err
+		}
+
+		h := &HashIndex{
+			pager:    p,
+			alloc:    pager.NewAllocator(p, 0),
+			checksum: opts.Checksum,
+		}
+		page, err := h.alloc.Allocate()
+		if err != nil {
+			return nil, err
+		}
+		if err := h.writeBucket(page, &bucket{}); err != nil {
+			return nil, err
+		}
+		h.dir = []pager.PageID{page.ID}
+		h.dirDirty = true
+
+		if err := h.Commit(); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+
+	meta, err := p.ReadPage(0)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(meta.Data[0:4]) != hashMagic {
+		return nil, ErrNotHashIndex
+	}
+	h := &HashIndex{
+		pager:    p,
+		alloc:    pager.NewAllocator(p, 0),
+		checksum: checksum.Algorithm(meta.Data[5]),
+		depth:    meta.Data[4],
+		count:    int(binary.LittleEndian.Uint64(meta.Data[12:20])),
+	}
+	if !h.checksum.Valid() {
+		return nil, checksum.ErrUnknownAlgorithm
+	}
+	if err := h.readDir(pager.PageID(binary.LittleEndian.Uint32(meta.Data[8:12]))); err != nil {
+		return nil, err
+	}
+
+	inUse := make(map[pager.PageID]bool)
+	for _, pageID := range h.dir {
+		inUse[pageID] = true
+	}
+	for _, pageID := range h.dirPages {
+		inUse[pageID] = true
+	}
+	if err := h.alloc.Rebuild(func(pageID pager.PageID) bool { return inUse[pageID] }); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Len returns the number of keys in the index.
+func (h *HashIndex) Len() int {
+	return h.count
+}
+
+func (h *HashIndex) Insert(key []byte, value uint64, mode InsertMode) error {
+	return h.put(hashEntry{key: key, value: value}, mode)
+}
+
+// InsertInline stores payload in the bucket next to key, in place of a
+// value, so that Lookup returns it without another read.
+func (h *HashIndex) InsertInline(key []byte, payload []byte, mode InsertMode) error {
+	if len(payload) > MaxInlineSize {
+		return ErrValueTooLarge
+	}
+	if payload == nil {
+		payload = []byte{}
+	}
+	return h.put(hashEntry{key: key, payload: payload}, mode)
+}
+
+func (h *HashIndex) put(e hashEntry, mode InsertMode) error {
+	if len(e.key) > MaxHashKeySize {
+		return ErrKeyTooLarge
+	}
+
+	hash := hashKey(e.key)
+	for {
+		pageID := h.dir[hash&(1<<h.depth-1)]
+		b, err := h.readBucket(pageID)
+		if err != nil {
+			return err
+		}
+
+		i := b.find(e.key)
+		switch {
+		case i >= 0 && mode == InsertOnly:
+			return ErrKeyAlreadyExists
+		case i < 0 && mode == UpdateOnly:
+			return ErrKeyNotFound
+		}
+
+		grown := &bucket{depth: b.depth, entries: slices.Clone(b.entries)}
+		if i >= 0 {
+			grown.entries[i] = e
+		} else {
+			grown.entries = append(grown.entries, e)
+		}
+		if grown.size() <= pager.PageSize {
+			if err := h.putBucket(pageID, grown); err != nil {
+				return err
+			}
+			if i < 0 {
+				h.count++
+			}
+			return h.maybeCommit()
+		}
+
+		if err := h.split(pageID, b); err != nil {
+			return err
+		}
+	}
+}
+
+// split replaces the bucket b on pageID with two of one more bit of depth.
+func (h *HashIndex) split(pageID pager.PageID, b *bucket) error {
+	if b.depth == h.depth {
+		if h.depth == maxHashDepth {
+			return ErrHashDirFull
+		}
+		h.dir = append(h.dir, h.dir...)
+		h.depth++
+		h.dirDirty = true
+	}
+
+	low := &bucket{depth: b.depth + 1}
+	high := &bucket{depth: b.depth + 1}
+	for _, e := range b.entries {
+		if hashKey(e.key)>>b.depth&1 == 0 {
+			low.entries = append(low.entries, e)
+		} else {
+			high.entries = append(high.entries, e)
+		}
+	}
+
+	page, err := h.alloc.Allocate()
+	if err != nil {
+		return err
+	}
+	if err := h.writeBucket(page, high); err != nil {
+		return err
+	}
+	for slot, id := range h.dir {
+		if id == pageID && slot>>b.depth&1 == 1 {
+			h.dir[slot] = page.ID
+		}
+	}
+	h.dirDirty = true
+	return h.putBucket(pageID, low)
+}
+
+func (h *HashIndex) Lookup(key []byte) (uint64, []byte, error) {
+	b, err := h.readBucket(h.dir[hashKey(key)&(1<<h.depth-1)])
+	if err != nil {
+		return 0, nil, err
+	}
+	i := b.find(key)
+	if i < 0 {
+		return 0, nil, ErrKeyNotFound
+	}
+	return b.entries[i].value, b.entries[i].payload, nil
+}
+
+// LookupMany looks up several keys and returns their entries in the order
+// of keys.
+func (h *HashIndex) LookupMany(keys [][]byte) ([]Entry, error) {
+	entries := make([]Entry, len(keys))
+	for i, key := range keys {
+		value, payload, err := h.Lookup(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = Entry{Found: true, Value: value, Payload: payload}
+	}
+	return entries, nil
+}
+
+func (h *HashIndex) Delete(key []byte) error {
+	pageID := h.dir[hashKey(key)&(1<<h.depth-1)]
+	b, err := h.readBucket(pageID)
+	if err != nil {
+		return err
+	}
+	i := b.find(key)
+	if i < 0 {
+		return ErrKeyNotFound
+	}
+
+	b.entries = append(b.entries[:i], b.entries[i+1:]...)
+	if err := h.putBucket(pageID, b); err != nil {
+		return err
+	}
+	h.count--
+	return h.maybeCommit()
+}
+
+// putBucket writes a bucket that was read from pageID, to a new page if
+// pageID belongs to the committed index, and points the directory at it.
+func (h *HashIndex) putBucket(pageID pager.PageID, b *bucket) error {
+	if h.alloc.IsFresh(pageID) {
+		return h.writeBucket(&pager.Page{ID: pageID}, b)
+	}
+
+	page, err := h.alloc.Allocate()
+	if err != nil {
+		return err
+	}
+	if err := h.writeBucket(page, b); err != nil {
+		return err
+	}
+	if err := h.alloc.Release(pageID); err != nil {
+		return err
+	}
+	for slot, id := range h.dir {
+		if id == pageID {
+			h.dir[slot] = page.ID
+		}
+	}
+	h.dirDirty = true
+	return nil
+}
+
+func (h *HashIndex) maybeCommit() error {
+	if h.alloc.Uncommitted() < commitPages {
+		return nil
+	}
+	return h.Commit()
+}
+
+// Commit makes the index as it is now the one it opens with after a crash,
+// like Index.Commit.
+func (h *HashIndex) Commit() error {
+	if h.dirDirty {
+		if err := h.writeDir(); err != nil {
+			return err
+		}
+	}
+
+	if err := h.pager.Flush(); errors.Is(err, pager.ErrWriteDeferred) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if h.pager.HasDirtyPages() {
+		return nil
+	}
+
+	meta := &pager.Page{ID: 0}
+	binary.LittleEndian.PutUint32(meta.Data[0:4], hashMagic)
+	meta.Data[4] = h.depth
+	meta.Data[5] = byte(h.checksum)
+	binary.LittleEndian.PutUint32(meta.Data[8:12], uint32(h.dirPages[0]))
+	binary.LittleEndian.PutUint64(meta.Data[12:20], uint64(h.count))
+	if err := h.pager.WritePage(meta); err != nil {
+		return err
+	}
+	if err := h.pager.Flush(); err != nil {
+		return err
+	}
+
+	return h.alloc.Commit()
+}
+
+func (h *HashIndex) Close() error {
+	if err := h.Commit(); err != nil {
+		return err
+	}
+	return h.pager.Close()
+}
+
+// writeDir writes the directory to new pages, each holding the ID of the
+// next page, its checksum and dirEntries slots.
+func (h *HashIndex) writeDir() error {
+	for _, pageID := range h.dirPages {
+		if err := h.alloc.Release(pageID); err != nil {
+			return err
+		}
+	}
+
+	pages := make([]*pager.Page, (len(h.dir)+dirEntries-1)/dirEntries)
+	for i := range pages {
+		var err error
+		if pages[i], err = h.alloc.Allocate(); err != nil {
+			return err
+		}
+	}
+
+	h.dirPages = h.dirPages[:0]
+	for i, page := range pages {
+		if i+1 < len(pages) {
+			binary.LittleEndian.PutUint32(page.Data[0:4], uint32(pages[i+1].ID))
+		}
+		slots := h.dir[i*dirEntries : min((i+1)*dirEntries, len(h.dir))]
+		for j, pageID := range slots {
+			binary.LittleEndian.PutUint32(page.Data[dirHeaderSize+4*j:], uint32(pageID))
+		}
+		h.seal(page)
+		if err := h.pager.WritePage(page); err != nil {
+			return err
+		}
+		h.dirPages = append(h.dirPages, page.ID)
+	}
+	h.dirDirty = false
+	return nil
+}
+
+// readDir reads the directory of 1<<depth slots from the pages starting
+// at pageID.
+func (h *HashIndex) readDir(pageID pager.PageID) error {
+	h.dir = make([]pager.PageID, 0, 1<<h.depth)
+	for len(h.dir) < 1<<h.depth {
+		if pageID == 0 {
+			return ErrHashDirTruncated
+		}
+		page, err := h.readPage(pageID)
+		if err != nil {
+			return err
+		}
+		h.dirPages = append(h.dirPages, pageID)
+
+		n := min(dirEntries, 1<<h.depth-len(h.dir))
+		for j := range n {
+			h.dir = append(h.dir, pager.PageID(binary.LittleEndian.Uint32(page.Data[dirHeaderSize+4*j:])))
+		}
+		pageID = pager.PageID(binary.LittleEndian.Uint32(page.Data[0:4]))
+	}
+	return nil
+}
+
+// A bucket page holds its depth in byte 0, the number of entries in bytes
+// 2 to 4 and its checksum in bytes 4 to 8. Each entry is the key length,
+// the payload length or offsetTag, the key, and the payload or value.
+
+func (h *HashIndex) writeBucket(page *pager.Page, b *bucket) error {
+	page.Data = [pager.PageSize]byte{}
+	page.Data[0] = b.depth
+	binary.LittleEndian.PutUint16(page.Data[2:4], uint16(len(b.entries)))
+
+	offset := bucketHeaderSize
+	for _, e := range b.entries {
+		tag := uint16(offsetTag)
+		if e.payload != nil {
+			tag = uint16(len(e.payload))
+		}
+		binary.LittleEndian.PutUint16(page.Data[offset:], uint16(len(e.key)))
+		binary.LittleEndian.PutUint16(page.Data[offset+2:], tag)
+		offset += 4
+		offset += copy(page.Data[offset:], e.key)
+		if e.payload != nil {
+			offset += copy(page.Data[offset:], e.payload)
+		} else {
+			binary.LittleEndian.PutUint64(page.Data[offset:], e.value)
+			offset += valueSize
+		}
+	}
+
+	h.seal(page)
+	return h.pager.WritePage(page)
+}
+
+func (h *HashIndex) readBucket(pageID pager.PageID) (*bucket, error) {
+	page, err := h.readPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bucket{
+		depth:   page.Data[0],
+		entries: make([]hashEntry, binary.LittleEndian.Uint16(page.Data[2:4])),
+	}
+	offset := bucketHeaderSize
+	for i := range b.entries {
+		keyLen := int(binary.LittleEndian.Uint16(page.Data[offset:]))
+		payloadLen := int(binary.LittleEndian.Uint16(page.Data[offset+2:]))
+		offset += 4
+
+		e := &b.entries[i]
+		e.key = bytes.Clone(page.Data[offset : offset+keyLen])
+		offset += keyLen
+		if payloadLen == offsetTag {
+			e.value = binary.LittleEndian.Uint64(page.Data[offset:])
+			offset += valueSize
+		} else {
+			e.payload = bytes.Clone(page.Data[offset : offset+payloadLen])
+			offset += payloadLen
+		}
+	}
+	return b, nil
+}
+
+// seal stores the checksum of a directory or bucket page in bytes 4 to 8,
+// computed with those bytes zeroed.
+func (h *HashIndex) seal(page *pager.Page) {
+	binary.LittleEndian.PutUint32(page.Data[4:8], 0)
+	binary.LittleEndian.PutUint32(page.Data[4:8], h.checksum.Sum(page.Data[:]))
+}
+
+// readPage reads a directory or bucket page and checks its checksum.
+func (h *HashIndex) readPage(pageID pager.PageID) (*pager.Page, error) {
+	page, err := h.pager.ReadPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	data := page.Data
+	stored := binary.LittleEndian.Uint32(data[4:8])
+	binary.LittleEndian.PutUint32(data[4:8], 0)
+	if h.checksum.Sum(data[:]) != stored {
+		return nil, ErrChecksumMismatch
+	}
+	return page, nil
+}
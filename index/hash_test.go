@@ -0,0 +1,187 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizalta/toydb/checksum"
+	"github.com/rizalta/toydb/pager"
+)
+
+func openTestHashIndex(t *testing.T, path string, opts Options) (*pager.Pager, *HashIndex) {
+	t.Helper()
+	p, err := pager.NewPager(path)
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	h, err := NewHashIndexWithOptions(p, opts)
+	if err != nil {
+		t.Fatalf("failed to initialize hash index: %v", err)
+	}
+	return p, h
+}
+
+func TestHashIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hash.db")
+	_, h := openTestHashIndex(t, path, Options{Checksum: checksum.XXH32})
+
+	key := func(i int) []byte { return fmt.Appendf(nil, "key_%06d", i) }
+	const n = 5000
+	for i := range n {
+		var err error
+		if i%3 == 0 {
+			err = h.InsertInline(key(i), fmt.Appendf(nil, "inline_%d", i), InsertOnly)
+		} else {
+			err = h.Insert(key(i), uint64(i), InsertOnly)
+		}
+		if err != nil {
+			t.Fatalf("failed to insert %s: %v", key(i), err)
+		}
+	}
+	if h.depth == 0 {
+		t.Fatalf("expected buckets to have split")
+	}
+
+	check := func(t *testing.T, h *HashIndex, deleted func(int) bool) {
+		t.Helper()
+		for i := range n {
+			value, payload, err := h.Lookup(key(i))
+			switch {
+			case deleted(i):
+				if !errors.Is(err, ErrKeyNotFound) {
+					t.Fatalf("expected %s to be deleted, got %v", key(i), err)
+				}
+			case err != nil:
+				t.Fatalf("failed to look up %s: %v", key(i), err)
+			case i%3 == 0:
+				if want := fmt.Appendf(nil, "inline_%d", i); !bytes.Equal(payload, want) {
+					t.Fatalf("expected payload %q for %s, got %q", want, key(i), payload)
+				}
+			case payload != nil || value != uint64(i):
+				t.Fatalf("expected value %d for %s, got %d (payload %q)", i, key(i), value, payload)
+			}
+		}
+	}
+	check(t, h, func(int) bool { return false })
+
+	if err := h.Insert(key(1), 0, InsertOnly); !errors.Is(err, ErrKeyAlreadyExists) {
+		t.Errorf("expected %v, got %v", ErrKeyAlreadyExists, err)
+	}
+	if err := h.Insert(key(n), 0, UpdateOnly); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if err := h.Insert(make([]byte, MaxHashKeySize+1), 0, Upsert); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected %v, got %v", ErrKeyTooLarge, err)
+	}
+
+	for i := 0; i < n; i += 2 {
+		if err := h.Delete(key(i)); err != nil {
+			t.Fatalf("failed to delete %s: %v", key(i), err)
+		}
+	}
+	if err := h.Delete(key(0)); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected %v, got %v", ErrKeyNotFound, err)
+	}
+	even := func(i int) bool { return i%2 == 0 }
+	check(t, h, even)
+	if h.Len() != n/2 {
+		t.Errorf("expected %d keys, got %d", n/2, h.Len())
+	}
+
+	entries, err := h.LookupMany([][]byte{key(1), key(2), key(3)})
+	if err != nil {
+		t.Fatalf("failed to look up many: %v", err)
+	}
+	if !entries[0].Found || entries[0].Value != 1 || entries[1].Found || !entries[2].Found || string(entries[2].Payload) != "inline_3" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	p, h := openTestHashIndex(t, path, Options{})
+	defer h.Close()
+	if h.checksum != checksum.XXH32 {
+		t.Errorf("expected the checksum algorithm to persist, got %v", h.checksum)
+	}
+	if h.Len() != n/2 {
+		t.Errorf("expected %d keys after reopening, got %d", n/2, h.Len())
+	}
+	check(t, h, even)
+
+	// Opening rebuilds the free list from the pages the directory leaves
+	// out.
+	buckets := make(map[pager.PageID]bool)
+	for _, pageID := range h.dir {
+		buckets[pageID] = true
+	}
+	free, err := h.alloc.FreeCount()
+	if err != nil {
+		t.Fatalf("failed to count free pages: %v", err)
+	}
+	if want := int(p.GetNumPages()) - 1 - len(buckets) - len(h.dirPages); free != want {
+		t.Errorf("expected %d free pages, got %d", want, free)
+	}
+}
+
+func TestHashIndexCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hash.db")
+	p, h := openTestHashIndex(t, path, Options{})
+
+	key := func(i int) []byte { return fmt.Appendf(nil, "key_%06d", i) }
+	for i := range 2000 {
+		if err := h.Insert(key(i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := h.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	// Change the index without committing and write everything to the
+	// file as if the pager evicted it before a crash.
+	for i := 0; i < 2000; i += 20 {
+		if err := h.Delete(key(i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		if err := h.Insert(key(i+1), 0, Upsert); err != nil {
+			t.Fatalf("failed to update: %v", err)
+		}
+	}
+	if h.alloc.Uncommitted() == 0 || h.alloc.Uncommitted() >= commitPages {
+		t.Fatalf("expected less than %d uncommitted pages, got %d", commitPages, h.alloc.Uncommitted())
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("failed to close pager: %v", err)
+	}
+
+	_, h = openTestHashIndex(t, path, Options{})
+	defer h.Close()
+	if h.Len() != 2000 {
+		t.Errorf("expected 2000 keys, got %d", h.Len())
+	}
+	for _, i := range []int{0, 1, 20, 21, 1999} {
+		value, _, err := h.Lookup(key(i))
+		if err != nil {
+			t.Fatalf("failed to look up %s: %v", key(i), err)
+		}
+		if value != uint64(i) {
+			t.Errorf("expected %s to hold its committed value %d, got %d", key(i), i, value)
+		}
+	}
+}
+
+func TestHashIndexRejectsTree(t *testing.T) {
+	idx := newTestIndex(t)
+	p := idx.pager
+	if _, err := NewHashIndex(p); !errors.Is(err, ErrNotHashIndex) {
+		t.Errorf("expected %v, got %v", ErrNotHashIndex, err)
+	}
+	idx.Close()
+}
@@ -5,10 +5,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"hash/crc32"
 	"sort"
 
+	"github.com/rizalta/toydb/checksum"
+	"github.com/rizalta/toydb/metrics"
 	"github.com/rizalta/toydb/pager"
+	"github.com/rizalta/toydb/trace"
 )
 
 type NodeType uint16
@@ -25,59 +27,102 @@ const (
 	NodeTypeLeaf
 )
 
+// MaxInlineSize is the largest payload InsertInline accepts, small enough
+// that a split always leaves both halves of a leaf within a page.
+const MaxInlineSize = 1024
+
+// inlineFlag marks a leaf value whose payload is stored in the page right
+// after the key. The rest of the value is then the key length.
+const inlineFlag = 1 << 62
+
 var (
 	ErrKeyNotFound      = errors.New("index: key not found")
 	ErrChecksumMismatch = errors.New("index: page checksum mismatch")
 	ErrKeyAlreadyExists = errors.New("index: key already exists")
+	ErrValueTooLarge    = errors.New("index: inline value too large")
+	ErrInlineEntry      = errors.New("index: entry holds an inline value")
+	ErrReadOnly         = errors.New("index: write to a read-only index")
 )
 
 type Pager interface {
 	NewPage() (*pager.Page, error)
 	ReadPage(pageID pager.PageID) (*pager.Page, error)
+	// ScanPage reads a page for a cursor, which is not going to need it
+	// again soon.
+	ScanPage(pageID pager.PageID) (*pager.Page, error)
 	WritePage(page *pager.Page) error
 	GetNumPages() uint32
-	FreePage(pageID pager.PageID) error
-	GetFreeListID() pager.PageID
-	SetFreeListID(pageID pager.PageID)
+	Flush() error
+	HasDirtyPages() bool
 	Close() error
 }
 
+// Header starts every node page. The keys of a node are stored without
+// the prefix all of them share, which is stored once, at the end of the
+// page, and prefixLen long. Bytes 6 to 10 are unused; they held the page
+// of the next leaf before pages were copied on write.
 type Header struct {
 	nodeType     NodeType
 	numKeys      uint16
 	freeSpacePtr uint16
-	next         pager.PageID
 	checksum     uint32
-	_            [2]byte
+	prefixLen    uint16
 }
 
 func (h *Header) serialize(data []byte) {
 	binary.LittleEndian.PutUint16(data[0:2], uint16(h.nodeType))
 	binary.LittleEndian.PutUint16(data[2:4], h.numKeys)
 	binary.LittleEndian.PutUint16(data[4:6], h.freeSpacePtr)
-	binary.LittleEndian.PutUint32(data[6:10], uint32(h.next))
 	binary.LittleEndian.PutUint32(data[10:14], h.checksum)
+	binary.LittleEndian.PutUint16(data[14:16], h.prefixLen)
 }
 
 func (h *Header) deserialize(data []byte) {
 	h.nodeType = NodeType(binary.LittleEndian.Uint16(data[0:2]))
 	h.numKeys = binary.LittleEndian.Uint16(data[2:4])
 	h.freeSpacePtr = binary.LittleEndian.Uint16(data[4:6])
-	h.next = pager.PageID(binary.LittleEndian.Uint32(data[6:10]))
 	h.checksum = binary.LittleEndian.Uint32(data[10:14])
+	h.prefixLen = binary.LittleEndian.Uint16(data[14:16])
 }
 
 type node struct {
 	nodeType NodeType
 	keys     [][]byte
 	values   []uint64
+	payloads [][]byte
 	children []pager.PageID
-	next     pager.PageID
 }
 
 type Index struct {
 	pager Pager
 	root  pager.PageID
+	// version changes with every modification, so cursors know when
+	// their position in a leaf may have moved.
+	version uint64
+	// alloc hands out the pages of the tree and frees the ones it
+	// replaced once a commit no longer uses them.
+	alloc *pager.Allocator
+	// checksum guards every node page, as chosen when the file was created.
+	checksum checksum.Algorithm
+	readOnly bool
+	hooks    *trace.Hooks
+	metrics  *metrics.Registry
+}
+
+// Options configures a new index. An existing index keeps the options it
+// was created with, which its meta page records.
+type Options struct {
+	// Checksum is the algorithm of the page checksums.
+	Checksum checksum.Algorithm
+	// ReadOnly opens an existing index without writing to it, not even to
+	// mark it open, for a pager opened read-only. The index has to be
+	// changed by nobody while it is open.
+	ReadOnly bool
+	// Trace is called as nodes split and merge.
+	Trace *trace.Hooks
+	// Metrics counts node splits and merges. Nil counts in a registry of
+	// the index's own.
+	Metrics *metrics.Registry
 }
 
 func newLeafNode() *node {
@@ -85,8 +130,8 @@ func newLeafNode() *node {
 		nodeType: NodeTypeLeaf,
 		keys:     make([][]byte, 0),
 		values:   make([]uint64, 0),
+		payloads: make([][]byte, 0),
 		children: nil,
-		next:     0,
 	}
 }
 
@@ -96,35 +141,44 @@ func newInternalNode() *node {
 		keys:     make([][]byte, 0),
 		values:   nil,
 		children: make([]pager.PageID, 0),
-		next:     0,
 	}
 }
 
 func NewIndex(p Pager) (*Index, error) {
+	return NewIndexWithOptions(p, Options{})
+}
+
+func NewIndexWithOptions(p Pager, opts Options) (*Index, error) {
 	if p.GetNumPages() == 0 {
-		_, err := p.NewPage()
-		if err != nil {
-			return nil, err
+		if opts.ReadOnly {
+			return nil, ErrReadOnly
 		}
-		rootPage, err := p.NewPage()
-		if err != nil {
+		if !opts.Checksum.Valid() {
+			return nil, checksum.ErrUnknownAlgorithm
+		}
+		if _, err := p.NewPage(); err != nil {
 			return nil, err
 		}
 
 		idx := &Index{
-			root:  rootPage.ID,
-			pager: p,
+			pager:    p,
+			alloc:    pager.NewAllocator(p, 0),
+			checksum: opts.Checksum,
+			hooks:    opts.Trace,
+			metrics:  metrics.OrNew(opts.Metrics),
 		}
-
-		if err := idx.syncMetaPage(); err != nil {
+		rootPage, err := idx.newPage()
+		if err != nil {
 			return nil, err
 		}
-
-		rootNode := newLeafNode()
-		if err := idx.writeNode(rootPage, rootNode); err != nil {
+		if err := idx.writeNode(rootPage, newLeafNode()); err != nil {
 			return nil, err
 		}
+		idx.root = rootPage.ID
 
+		if err := idx.Commit(); err != nil {
+			return nil, err
+		}
 		return idx, nil
 	}
 
@@ -133,14 +187,36 @@ func NewIndex(p Pager) (*Index, error) {
 		return nil, err
 	}
 
-	rootPageID := pager.PageID(binary.LittleEndian.Uint32(meta.Data[:]))
-	freeListID := pager.PageID(binary.LittleEndian.Uint32(meta.Data[4:]))
-	p.SetFreeListID(freeListID)
+	idx := &Index{
+		root:     pager.PageID(binary.LittleEndian.Uint32(meta.Data[:])),
+		pager:    p,
+		checksum: checksum.Algorithm(meta.Data[9]),
+		readOnly: opts.ReadOnly,
+		hooks:    opts.Trace,
+		metrics:  metrics.OrNew(opts.Metrics),
+	}
+	if !idx.checksum.Valid() {
+		return nil, checksum.ErrUnknownAlgorithm
+	}
+	if opts.ReadOnly {
+		idx.alloc = pager.NewAllocator(p, pager.PageID(binary.LittleEndian.Uint32(meta.Data[4:])))
+		return idx, nil
+	}
+	if meta.Data[8]&metaClean == 0 {
+		idx.reclaim()
+		return idx, nil
+	}
 
-	return &Index{
-		root:  rootPageID,
-		pager: p,
-	}, nil
+	// Until the index is closed again, pages are taken off the free list
+	// and written over, so the free list on disk cannot be trusted.
+	idx.alloc = pager.NewAllocator(p, pager.PageID(binary.LittleEndian.Uint32(meta.Data[4:])))
+	if err := idx.writeMeta(false); err != nil {
+		return nil, err
+	}
+	if err := p.Flush(); err != nil {
+		return nil, err
+	}
+	return idx, nil
 }
 
 func (idx *Index) readNode(pageID pager.PageID) (*node, *pager.Page, error) {
@@ -148,31 +224,47 @@ func (idx *Index) readNode(pageID pager.PageID) (*node, *pager.Page, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	return idx.decodeNode(page)
+}
+
+// scanNode reads a node for a cursor, which leaves the pages other reads
+// keep in the cache there.
+func (idx *Index) scanNode(pageID pager.PageID) (*node, error) {
+	page, err := idx.pager.ScanPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	n, _, err := idx.decodeNode(page)
+	return n, err
+}
 
-	storedChecksum := binary.LittleEndian.Uint32(page.Data[10:14])
-	binary.LittleEndian.PutUint32(page.Data[10:14], 0)
-	calculatedChecksum := crc32.ChecksumIEEE(page.Data[:])
-	if calculatedChecksum != storedChecksum {
+func (idx *Index) decodeNode(page *pager.Page) (*node, *pager.Page, error) {
+	// The checksum is computed over a copy, as the cached page can be
+	// written to the file in the background at any time.
+	data := page.Data
+	storedChecksum := binary.LittleEndian.Uint32(data[10:14])
+	binary.LittleEndian.PutUint32(data[10:14], 0)
+	if idx.checksum.Sum(data[:]) != storedChecksum {
 		return nil, nil, ErrChecksumMismatch
 	}
 
-	binary.LittleEndian.PutUint32(page.Data[10:14], storedChecksum)
 	header := &Header{}
 	header.deserialize(page.Data[0:headerSize])
 
 	n := &node{
 		nodeType: header.nodeType,
 		keys:     make([][]byte, header.numKeys),
-		next:     header.next,
 	}
 
 	slotOffset := headerSize
-	endOffset := uint16(pager.PageSize)
+	endOffset := uint16(pager.PageSize) - header.prefixLen
+	prefix := page.Data[endOffset:]
 	for i := range header.numKeys {
 		startOffset := binary.LittleEndian.Uint16(page.Data[slotOffset:])
-		key := page.Data[startOffset:endOffset]
-		n.keys[i] = make([]byte, len(key))
-		copy(n.keys[i], key)
+		suffix := page.Data[startOffset:endOffset]
+		n.keys[i] = make([]byte, len(prefix)+len(suffix))
+		copy(n.keys[i], prefix)
+		copy(n.keys[i][len(prefix):], suffix)
 		slotOffset += slotSize
 		endOffset = startOffset
 	}
@@ -180,9 +272,20 @@ func (idx *Index) readNode(pageID pager.PageID) (*node, *pager.Page, error) {
 	pointersOffset := slotOffset
 	if n.nodeType == NodeTypeLeaf {
 		n.values = make([]uint64, header.numKeys)
+		n.payloads = make([][]byte, header.numKeys)
 		for i := range n.values {
 			n.values[i] = binary.LittleEndian.Uint64(page.Data[pointersOffset:])
 			pointersOffset += valueSize
+
+			if n.values[i]&inlineFlag != 0 {
+				keyLen := int(n.values[i] &^ inlineFlag)
+				if keyLen > len(n.keys[i]) {
+					return nil, nil, ErrChecksumMismatch
+				}
+				n.payloads[i] = n.keys[i][keyLen:]
+				n.keys[i] = n.keys[i][:keyLen]
+				n.values[i] = 0
+			}
 		}
 	} else {
 		n.children = make([]pager.PageID, header.numKeys+1)
@@ -201,17 +304,23 @@ func (idx *Index) writeNode(page *pager.Page, n *node) error {
 	}
 
 	numKeys := len(n.keys)
+	prefixLen := n.prefixLen()
 	header := &Header{
 		nodeType:     n.nodeType,
 		numKeys:      uint16(numKeys),
-		freeSpacePtr: pager.PageSize,
-		next:         n.next,
+		freeSpacePtr: uint16(pager.PageSize - prefixLen),
+		prefixLen:    uint16(prefixLen),
+	}
+	if numKeys > 0 {
+		copy(page.Data[header.freeSpacePtr:], n.keys[0][:prefixLen])
 	}
 
 	slotOffset := headerSize
-	for _, key := range n.keys {
-		header.freeSpacePtr -= uint16(len(key))
-		copy(page.Data[header.freeSpacePtr:], key)
+	for i, key := range n.keys {
+		suffix, payload := key[prefixLen:], n.payload(i)
+		header.freeSpacePtr -= uint16(len(suffix) + len(payload))
+		copy(page.Data[header.freeSpacePtr:], suffix)
+		copy(page.Data[int(header.freeSpacePtr)+len(suffix):], payload)
 		binary.LittleEndian.PutUint16(page.Data[slotOffset:], header.freeSpacePtr)
 		slotOffset += slotSize
 	}
@@ -219,7 +328,10 @@ func (idx *Index) writeNode(page *pager.Page, n *node) error {
 	pointersOffset := slotOffset
 
 	if n.nodeType == NodeTypeLeaf {
-		for _, v := range n.values {
+		for i, v := range n.values {
+			if n.payload(i) != nil {
+				v = inlineFlag | uint64(len(n.keys[i]))
+			}
 			binary.LittleEndian.PutUint64(page.Data[pointersOffset:], v)
 			pointersOffset += valueSize
 		}
@@ -233,8 +345,7 @@ func (idx *Index) writeNode(page *pager.Page, n *node) error {
 	header.serialize(page.Data[:headerSize])
 
 	binary.LittleEndian.PutUint32(page.Data[10:], 0)
-	checksum := crc32.ChecksumIEEE(page.Data[:])
-	header.checksum = checksum
+	header.checksum = idx.checksum.Sum(page.Data[:])
 
 	header.serialize(page.Data[:headerSize])
 
@@ -243,39 +354,93 @@ func (idx *Index) writeNode(page *pager.Page, n *node) error {
 
 func (n *node) calculateSize() int {
 	numKeys := len(n.keys)
-	size := headerSize + (slotSize * numKeys)
+	prefixLen := n.prefixLen()
+	size := headerSize + prefixLen + (slotSize * numKeys)
 	if n.nodeType == NodeTypeLeaf {
 		size += valueSize * numKeys
 	} else {
 		size += childSize * (numKeys + 1)
 	}
-	for _, key := range n.keys {
-		size += len(key)
+	for i, key := range n.keys {
+		size += len(key) - prefixLen + len(n.payload(i))
 	}
 
 	return size
 }
 
-func (idx *Index) syncMetaPage() error {
+// prefixLen returns the length of the prefix all keys of the node share.
+// The keys are sorted, so it is the one the first and last key share.
+func (n *node) prefixLen() int {
+	if len(n.keys) == 0 {
+		return 0
+	}
+	return sharedPrefixLen(n.keys[0], n.keys[len(n.keys)-1])
+}
+
+func sharedPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// packedSize returns the size of a node whose entries take size bytes in
+// total, count of which have keys sharing a prefix of prefixLen bytes.
+func packedSize(size, count, prefixLen int) int {
+	return headerSize + prefixLen + size - count*prefixLen
+}
+
+// payload returns the inline payload of the i-th leaf entry, or nil if the
+// entry holds an offset.
+func (n *node) payload(i int) []byte {
+	if i >= len(n.payloads) {
+		return nil
+	}
+	return n.payloads[i]
+}
+
+// writeMeta writes the root, the head of the free list, whether the index
+// was closed cleanly and the checksum algorithm to the meta page.
+func (idx *Index) writeMeta(clean bool) error {
 	meta, err := idx.pager.ReadPage(0)
 	if err != nil {
 		return err
 	}
 
 	binary.LittleEndian.PutUint32(meta.Data[:], uint32(idx.root))
-	binary.LittleEndian.PutUint32(meta.Data[4:], uint32(idx.pager.GetFreeListID()))
+	binary.LittleEndian.PutUint32(meta.Data[4:], uint32(idx.alloc.Head()))
+	meta.Data[8] = 0
+	if clean {
+		meta.Data[8] = metaClean
+	}
+	meta.Data[9] = byte(idx.checksum)
 
 	return idx.pager.WritePage(meta)
 }
 
 func (idx *Index) Search(key []byte) (uint64, error) {
+	value, payload, err := idx.Lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	if payload != nil {
+		return 0, ErrInlineEntry
+	}
+
+	return value, nil
+}
+
+// Lookup returns the entry for key, which is either an offset or, for
+// entries written with InsertInline, a non-nil payload.
+func (idx *Index) Lookup(key []byte) (uint64, []byte, error) {
 	if idx.root == 0 {
-		return 0, ErrKeyNotFound
+		return 0, nil, ErrKeyNotFound
 	}
 
 	n, _, err := idx.readNode(idx.root)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	for n.nodeType == NodeTypeInternal {
@@ -284,7 +449,7 @@ func (idx *Index) Search(key []byte) (uint64, error) {
 		})
 		n, _, err = idx.readNode(n.children[i])
 		if err != nil {
-			return 0, err
+			return 0, nil, err
 		}
 	}
 
@@ -293,15 +458,28 @@ func (idx *Index) Search(key []byte) (uint64, error) {
 	})
 
 	if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
-		return n.values[i], nil
+		return n.values[i], n.payload(i), nil
 	}
 
-	return 0, ErrKeyNotFound
+	return 0, nil, ErrKeyNotFound
 }
 
+// Close commits the index and marks it closed cleanly, so that the next
+// open trusts its free list.
 func (idx *Index) Close() error {
-	if err := idx.syncMetaPage(); err != nil {
+	if idx.readOnly {
+		return idx.pager.Close()
+	}
+	if err := idx.Commit(); err != nil {
 		return err
 	}
+	if err := idx.pager.Flush(); err != nil {
+		return err
+	}
+	if !idx.pager.HasDirtyPages() {
+		if err := idx.writeMeta(true); err != nil {
+			return err
+		}
+	}
 	return idx.pager.Close()
 }
@@ -3,13 +3,17 @@ package index
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"path/filepath"
+	"slices"
 	"testing"
 
+	"github.com/rizalta/toydb/checksum"
 	"github.com/rizalta/toydb/pager"
+	"github.com/rizalta/toydb/trace"
 )
 
-func newTestIndex(t *testing.T) *Index {
+func newTestIndex(t testing.TB) *Index {
 	t.Helper()
 
 	tempDir := t.TempDir()
@@ -28,6 +32,13 @@ func newTestIndex(t *testing.T) *Index {
 	return idx
 }
 
+// sizeWithKey returns the size of n with key added after its other keys.
+func sizeWithKey(n *node, key []byte) int {
+	grown := *n
+	grown.keys = append(slices.Clone(n.keys), key)
+	return grown.calculateSize()
+}
+
 func TestNewIndex(t *testing.T) {
 	index := newTestIndex(t)
 	defer index.Close()
@@ -104,6 +115,52 @@ func TestNewIndexForExistingPager(t *testing.T) {
 	}
 }
 
+func TestNewIndexWithOptions(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.db")
+	p, err := pager.NewPager(indexPath)
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	index, err := NewIndexWithOptions(p, Options{Checksum: checksum.XXH32})
+	if err != nil {
+		t.Fatalf("failed to initialize index: %v", err)
+	}
+	for i := range 500 {
+		if err := index.Insert(fmt.Appendf(nil, "key_%04d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := index.Close(); err != nil {
+		t.Fatalf("failed to close index: %v", err)
+	}
+
+	// The algorithm of the file wins over the options of the reopen.
+	p, err = pager.NewPager(indexPath)
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	index, err = NewIndexWithOptions(p, Options{Checksum: checksum.CRC32C})
+	if err != nil {
+		t.Fatalf("failed to reopen index: %v", err)
+	}
+	defer index.Close()
+	if index.checksum != checksum.XXH32 {
+		t.Errorf("expected %s, got %s", checksum.XXH32, index.checksum)
+	}
+	if value, err := index.Search([]byte("key_0250")); err != nil || value != 250 {
+		t.Errorf("expected 250, got %d, err %v", value, err)
+	}
+
+	p, err = pager.NewPager(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	defer p.Close()
+	if _, err := NewIndexWithOptions(p, Options{Checksum: 200}); !errors.Is(err, checksum.ErrUnknownAlgorithm) {
+		t.Errorf("expected ErrUnknownAlgorithm, got %v", err)
+	}
+}
+
 func TestSearchEmptyIndex(t *testing.T) {
 	index := newTestIndex(t)
 	defer index.Close()
@@ -190,3 +247,119 @@ func TestSearchSingleNode(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkSearch(b *testing.B) {
+	idx := newTestIndex(b)
+	defer idx.Close()
+
+	const numKeys = 100000
+	for i := range numKeys {
+		key := fmt.Appendf(nil, "key_%09d", i)
+		if err := idx.Insert(key, uint64(i), Upsert); err != nil {
+			b.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Appendf(nil, "key_%09d", (i*7919)%numKeys)
+		if _, err := idx.Search(key); err != nil {
+			b.Fatalf("failed to search: %v", err)
+		}
+	}
+}
+
+func TestPrefixCompression(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.db")
+	p, err := pager.NewPager(indexPath)
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	index, err := NewIndex(p)
+	if err != nil {
+		t.Fatalf("failed to initialize index: %v", err)
+	}
+
+	const numKeys = 3000
+	key := func(i int) []byte {
+		return fmt.Appendf(nil, "table_0001/padding/padding/padding/%06d", i)
+	}
+	for i := range numKeys {
+		if err := index.Insert(key(i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := index.InsertInline(key(numKeys), []byte("payload"), Upsert); err != nil {
+		t.Fatalf("failed to insert inline: %v", err)
+	}
+
+	uncompressed := numKeys * (slotSize + valueSize + len(key(0))) / pager.PageSize
+	if pages := int(p.GetNumPages()); pages >= uncompressed {
+		t.Errorf("expected fewer than the %d pages the keys fill uncompressed, got %d", uncompressed, pages)
+	}
+
+	if err := index.Close(); err != nil {
+		t.Fatalf("failed to close index: %v", err)
+	}
+	p, err = pager.NewPager(indexPath)
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	index, err = NewIndex(p)
+	if err != nil {
+		t.Fatalf("failed to reopen index: %v", err)
+	}
+	defer index.Close()
+
+	for i := range numKeys {
+		if value, err := index.Search(key(i)); err != nil || value != uint64(i) {
+			t.Fatalf("expected %d for %s, got %d, err %v", i, key(i), value, err)
+		}
+	}
+	if _, payload, err := index.Lookup(key(numKeys)); err != nil || string(payload) != "payload" {
+		t.Errorf("expected the inline payload back, got %q, err %v", payload, err)
+	}
+}
+
+func TestTraceHooks(t *testing.T) {
+	var splits, merges int
+	hooks := &trace.Hooks{
+		OnSplit: func(page, sibling uint32, leaf bool) { splits++ },
+		OnMerge: func(page, freed uint32, leaf bool) { merges++ },
+	}
+	p, err := pager.NewPager(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatalf("failed to initialize pager: %v", err)
+	}
+	idx, err := NewIndexWithOptions(p, Options{Trace: hooks})
+	if err != nil {
+		t.Fatalf("failed to initialize index: %v", err)
+	}
+	defer idx.Close()
+
+	for i := range 2000 {
+		if err := idx.Insert(fmt.Appendf(nil, "key_%06d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	stats, err := idx.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	// Every split adds a node, and the splits of the root a new root too.
+	nodes := 0
+	for _, n := range stats.Levels {
+		nodes += n
+	}
+	if splits != nodes-stats.Height {
+		t.Errorf("expected %d splits for %d nodes on %d levels, got %d", nodes-stats.Height, nodes, stats.Height, splits)
+	}
+	for i := range 2000 {
+		if err := idx.Delete(fmt.Appendf(nil, "key_%06d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if merges == 0 {
+		t.Errorf("expected deletes to merge nodes")
+	}
+}
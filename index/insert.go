@@ -16,14 +16,36 @@ const (
 )
 
 func (idx *Index) Insert(key []byte, value uint64, inserMode InsertMode) error {
-	promotedKey, newSiblingID, err := idx.insert(idx.root, key, value, inserMode)
+	return idx.put(key, value, nil, inserMode)
+}
+
+// InsertInline stores payload in the leaf next to key, in place of an
+// offset, so that Lookup and cursors return it without another read.
+func (idx *Index) InsertInline(key []byte, payload []byte, inserMode InsertMode) error {
+	if len(payload) > MaxInlineSize {
+		return ErrValueTooLarge
+	}
+	if payload == nil {
+		payload = []byte{}
+	}
+
+	return idx.put(key, 0, payload, inserMode)
+}
+
+func (idx *Index) put(key []byte, value uint64, payload []byte, inserMode InsertMode) error {
+	if idx.readOnly {
+		return ErrReadOnly
+	}
+	idx.version++
+	rootID, promotedKey, newSiblingID, err := idx.insert(idx.root, key, value, payload, inserMode)
 	if err != nil {
 		return err
 	}
+	idx.root = rootID
 
 	if newSiblingID != 0 {
 		newRoot := newInternalNode()
-		rootPage, err := idx.pager.NewPage()
+		rootPage, err := idx.newPage()
 		if err != nil {
 			return err
 		}
@@ -34,18 +56,18 @@ func (idx *Index) Insert(key []byte, value uint64, inserMode InsertMode) error {
 		}
 
 		idx.root = rootPage.ID
-		if err := idx.syncMetaPage(); err != nil {
-			return err
-		}
 	}
 
-	return nil
+	return idx.maybeCommit()
 }
 
-func (idx *Index) insert(pageID pager.PageID, key []byte, value uint64, inserMode InsertMode) ([]byte, pager.PageID, error) {
-	n, page, err := idx.readNode(pageID)
+// insert adds the entry to the subtree at pageID and returns the page the
+// root of the subtree is now on, and the key and page of a new sibling if
+// it split.
+func (idx *Index) insert(pageID pager.PageID, key []byte, value uint64, payload []byte, inserMode InsertMode) (pager.PageID, []byte, pager.PageID, error) {
+	n, _, err := idx.readNode(pageID)
 	if err != nil {
-		return nil, 0, err
+		return 0, nil, 0, err
 	}
 
 	if n.nodeType == NodeTypeLeaf {
@@ -54,43 +76,46 @@ func (idx *Index) insert(pageID pager.PageID, key []byte, value uint64, inserMod
 		})
 		if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
 			if inserMode == InsertOnly {
-				return nil, 0, ErrKeyAlreadyExists
+				return 0, nil, 0, ErrKeyAlreadyExists
 			}
 
 			n.values[i] = value
-			err := idx.writeNode(page, n)
-			return nil, 0, err
-		}
+			n.payloads[i] = payload
+		} else {
+			if inserMode == UpdateOnly {
+				return 0, nil, 0, ErrKeyNotFound
+			}
 
-		if inserMode == UpdateOnly {
-			return nil, 0, ErrKeyNotFound
+			n.keys = append(n.keys, []byte{})
+			n.values = append(n.values, 0)
+			n.payloads = append(n.payloads, nil)
+			copy(n.keys[i+1:], n.keys[i:])
+			copy(n.values[i+1:], n.values[i:])
+			copy(n.payloads[i+1:], n.payloads[i:])
+			n.keys[i] = key
+			n.values[i] = value
+			n.payloads[i] = payload
 		}
 
-		n.keys = append(n.keys, []byte{})
-		n.values = append(n.values, 0)
-		copy(n.keys[i+1:], n.keys[i:])
-		copy(n.values[i+1:], n.values[i:])
-		n.keys[i] = key
-		n.values[i] = value
 		if n.calculateSize() > splitThreshold {
-			return idx.splitNode(page, n)
-		}
-
-		if err := idx.writeNode(page, n); err != nil {
-			return nil, 0, err
+			return idx.splitNode(pageID, n)
 		}
-
-		return nil, 0, nil
+		pageID, err := idx.putNode(pageID, n)
+		return pageID, nil, 0, err
 	}
 
 	i := sort.Search(len(n.keys), func(j int) bool {
 		return bytes.Compare(n.keys[j], key) > 0
 	})
 
-	promotedKey, newSiblingID, err := idx.insert(n.children[i], key, value, inserMode)
+	childID, promotedKey, newSiblingID, err := idx.insert(n.children[i], key, value, payload, inserMode)
 	if err != nil {
-		return nil, 0, err
+		return 0, nil, 0, err
+	}
+	if childID == n.children[i] && newSiblingID == 0 {
+		return pageID, nil, 0, nil
 	}
+	n.children[i] = childID
 
 	if newSiblingID != 0 {
 		n.keys = append(n.keys, []byte{})
@@ -101,23 +126,21 @@ func (idx *Index) insert(pageID pager.PageID, key []byte, value uint64, inserMod
 		n.children[i+1] = newSiblingID
 
 		if n.calculateSize() > splitThreshold {
-			return idx.splitNode(page, n)
-		}
-
-		if err := idx.writeNode(page, n); err != nil {
-			return nil, 0, err
+			return idx.splitNode(pageID, n)
 		}
-
-		return nil, 0, nil
 	}
 
-	return nil, 0, nil
+	pageID, err = idx.putNode(pageID, n)
+	return pageID, nil, 0, err
 }
 
-func (idx *Index) splitNode(page *pager.Page, n *node) ([]byte, pager.PageID, error) {
-	siblingPage, err := idx.pager.NewPage()
+// splitNode writes the lower half of n, which was read from pageID, and
+// its upper half to a new sibling. It returns the page the lower half is
+// on and the key and page of the sibling.
+func (idx *Index) splitNode(pageID pager.PageID, n *node) (pager.PageID, []byte, pager.PageID, error) {
+	siblingPage, err := idx.newPage()
 	if err != nil {
-		return nil, 0, err
+		return 0, nil, 0, err
 	}
 
 	var siblingNode *node
@@ -126,14 +149,15 @@ func (idx *Index) splitNode(page *pager.Page, n *node) ([]byte, pager.PageID, er
 	var promotedKey []byte
 	switch n.nodeType {
 	case NodeTypeLeaf:
+		mid = n.leafSplitPoint(mid)
 		siblingNode = newLeafNode()
 		siblingNode.keys = append(siblingNode.keys, n.keys[mid:]...)
 		siblingNode.values = append(siblingNode.values, n.values[mid:]...)
+		siblingNode.payloads = append(siblingNode.payloads, n.payloads[mid:]...)
 		n.keys = n.keys[:mid]
 		n.values = n.values[:mid]
-		siblingNode.next = n.next
-		n.next = siblingPage.ID
-		promotedKey = siblingNode.keys[0]
+		n.payloads = n.payloads[:mid]
+		promotedKey = separator(n.keys[len(n.keys)-1], siblingNode.keys[0])
 
 	case NodeTypeInternal:
 		siblingNode = newInternalNode()
@@ -144,13 +168,47 @@ func (idx *Index) splitNode(page *pager.Page, n *node) ([]byte, pager.PageID, er
 		n.children = n.children[:mid+1]
 	}
 
-	if err := idx.writeNode(page, n); err != nil {
-		return nil, 0, err
+	pageID, err = idx.putNode(pageID, n)
+	if err != nil {
+		return 0, nil, 0, err
 	}
 
 	if err := idx.writeNode(siblingPage, siblingNode); err != nil {
-		return nil, 0, err
+		return 0, nil, 0, err
+	}
+
+	idx.metrics.Splits.Inc()
+	idx.hooks.Split(uint32(pageID), uint32(siblingPage.ID), n.nodeType == NodeTypeLeaf)
+	return pageID, promotedKey, siblingPage.ID, nil
+}
+
+// separator returns the shortest key that is above left and at most
+// right, for a parent to tell apart two leaves whose keys end at left and
+// start at right. Only its leading bytes matter for that, so promoting it
+// instead of all of right leaves more room for keys in internal nodes.
+func separator(left, right []byte) []byte {
+	return bytes.Clone(right[:sharedPrefixLen(left, right)+1])
+}
+
+// leafSplitPoint moves mid away from the larger half until both halves of
+// the leaf fit in a page, which only matters when entries carry inline
+// payloads of very different sizes.
+func (n *node) leafSplitPoint(mid int) int {
+	half := func(start, end int) int {
+		h := &node{
+			nodeType: NodeTypeLeaf,
+			keys:     n.keys[start:end],
+			payloads: n.payloads[start:end],
+		}
+		return h.calculateSize()
+	}
+
+	for mid > 1 && half(0, mid) > splitThreshold {
+		mid--
+	}
+	for mid < len(n.keys)-1 && half(mid, len(n.keys)) > splitThreshold {
+		mid++
 	}
 
-	return promotedKey, siblingPage.ID, nil
+	return mid
 }
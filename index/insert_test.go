@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"reflect"
@@ -52,7 +53,7 @@ func TestInsertSimple(t *testing.T) {
 	})
 
 	t.Run("Validate_keys_are_sorted_in_page", func(t *testing.T) {
-		root, _, _ := index.readNode(1)
+		root, _, _ := index.readNode(index.root)
 		expectedKeys := [][]byte{[]byte("key1"), []byte("key2")}
 		if !reflect.DeepEqual(root.keys, expectedKeys) {
 			t.Errorf("expected keys in root node as %v, got %v", expectedKeys, root.keys)
@@ -132,10 +133,8 @@ func TestInsertAndSplit(t *testing.T) {
 		key := fmt.Appendf(nil, "key_%04d", i)
 		value := uint64(1000 + i)
 		rootNode, _, _ := index.readNode(index.root)
-		keySize := len(key)
-		entrySize := keySize + slotSize + valueSize
 		err := index.Insert(key, value, Upsert)
-		if rootNode.calculateSize()+entrySize > splitThreshold {
+		if sizeWithKey(rootNode, key) > splitThreshold {
 			break
 		}
 		if err != nil {
@@ -187,10 +186,6 @@ func TestInsertAndSplit(t *testing.T) {
 		if leftChild.calculateSize() < mergeThreshold {
 			t.Errorf("expected left child size to be greater than %v, got %v", mergeThreshold, leftChild.calculateSize())
 		}
-
-		if leftChild.next != rightChildID {
-			t.Errorf("expected left child next to be %v, got %v", rightChildID, leftChild.next)
-		}
 	})
 
 	t.Run("Verify_right_child", func(t *testing.T) {
@@ -237,8 +232,7 @@ func TestInternalNodeSplit(t *testing.T) {
 	for {
 		key := makeKey()
 		rootNode, _, _ := index.readNode(index.root)
-		entrySize := len(key) + slotSize + valueSize
-		if rootNode.calculateSize()+entrySize > splitThreshold {
+		if sizeWithKey(rootNode, key) > splitThreshold {
 			break
 		}
 		err := index.Insert(key, value(), Upsert)
@@ -257,15 +251,15 @@ func TestInternalNodeSplit(t *testing.T) {
 	for {
 		rootNode, _, _ := index.readNode(index.root)
 		key := makeKey()
-		if rootNode.calculateSize()+len(key)+slotSize+childSize > splitThreshold {
+		if sizeWithKey(rootNode, key) > splitThreshold {
 			break
 		}
 		for {
+			rootNode, _, _ := index.readNode(index.root)
 			rightChildID := rootNode.children[len(rootNode.children)-1]
 			rightChild, _, _ := index.readNode(rightChildID)
 			key := makeKey()
-			entrySize := len(key) + slotSize + valueSize
-			if rightChild.calculateSize()+entrySize > splitThreshold {
+			if sizeWithKey(rightChild, key) > splitThreshold {
 				break
 			}
 			err := index.Insert(key, value(), Upsert)
@@ -282,13 +276,12 @@ func TestInternalNodeSplit(t *testing.T) {
 	}
 
 	t.Log("phase3: trigger internal node split")
-	rootNode, _, _ := index.readNode(index.root)
 	for {
+		rootNode, _, _ := index.readNode(index.root)
 		rightChildID := rootNode.children[len(rootNode.children)-1]
 		rightChild, _, _ := index.readNode(rightChildID)
 		key := makeKey()
-		entrySize := len(key) + slotSize + valueSize
-		if rightChild.calculateSize()+entrySize > splitThreshold {
+		if sizeWithKey(rightChild, key) > splitThreshold {
 			break
 		}
 		err := index.Insert(key, value(), Upsert)
@@ -351,3 +344,152 @@ func TestInternalNodeSplit(t *testing.T) {
 		}
 	})
 }
+
+func TestInsertInline(t *testing.T) {
+	index := newTestIndex(t)
+	defer index.Close()
+
+	payloadFor := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, (i*37)%MaxInlineSize)
+	}
+
+	n := 2000
+	for i := range n {
+		key := fmt.Appendf(nil, "key_%05d", i)
+		var err error
+		if i%3 == 0 {
+			err = index.Insert(key, uint64(i), InsertOnly)
+		} else {
+			err = index.InsertInline(key, payloadFor(i), InsertOnly)
+		}
+		if err != nil {
+			t.Fatalf("failed to insert key %s: %v", key, err)
+		}
+	}
+
+	check := func(i int) {
+		t.Helper()
+		key := fmt.Appendf(nil, "key_%05d", i)
+		value, payload, err := index.Lookup(key)
+		if err != nil {
+			t.Fatalf("failed to look up key %s: %v", key, err)
+		}
+		if i%3 == 0 {
+			if payload != nil || value != uint64(i) {
+				t.Errorf("expected offset %d for key %s, got %d and payload of %d bytes", i, key, value, len(payload))
+			}
+		} else if !bytes.Equal(payload, payloadFor(i)) {
+			t.Errorf("expected inline payload for key %s", key)
+		}
+	}
+	for i := range n {
+		check(i)
+	}
+
+	cursor, err := index.NewCursor(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %v", err)
+	}
+	for i := range n {
+		key, _, err := cursor.Next()
+		if err != nil || key == nil {
+			t.Fatalf("expected key %d from cursor, got %v", i, err)
+		}
+		if i%3 != 0 && !bytes.Equal(cursor.Payload(), payloadFor(i)) {
+			t.Fatalf("expected cursor payload for key %s", key)
+		}
+	}
+
+	for i := 0; i < n; i += 2 {
+		key := fmt.Appendf(nil, "key_%05d", i)
+		if err := index.Delete(key); err != nil {
+			t.Fatalf("failed to delete key %s: %v", key, err)
+		}
+	}
+	for i := 1; i < n; i += 2 {
+		check(i)
+	}
+
+	key := []byte("key_00001")
+	if err := index.Insert(key, 77, UpdateOnly); err != nil {
+		t.Fatalf("failed to replace inline entry with an offset: %v", err)
+	}
+	if value, payload, _ := index.Lookup(key); value != 77 || payload != nil {
+		t.Errorf("expected offset 77 after replacing inline entry, got %d and %v", value, payload)
+	}
+
+	if _, err := index.Search([]byte("key_00005")); !errors.Is(err, ErrInlineEntry) {
+		t.Errorf("expected %v from Search on an inline entry, got %v", ErrInlineEntry, err)
+	}
+	if err := index.InsertInline([]byte("big"), make([]byte, MaxInlineSize+1), Upsert); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected %v, got %v", ErrValueTooLarge, err)
+	}
+}
+
+func BenchmarkInsertSequential(b *testing.B) {
+	idx := newTestIndex(b)
+	defer idx.Close()
+
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Appendf(nil, "key_%09d", i)
+		if err := idx.Insert(key, uint64(i), Upsert); err != nil {
+			b.Fatalf("failed to insert: %v", err)
+		}
+	}
+}
+
+func BenchmarkInsertRandom(b *testing.B) {
+	idx := newTestIndex(b)
+	defer idx.Close()
+
+	b.ResetTimer()
+	for i := range b.N {
+		key := fmt.Appendf(nil, "key_%09d", (uint64(i)*2654435761)%1000000007)
+		if err := idx.Insert(key, uint64(i), Upsert); err != nil {
+			b.Fatalf("failed to insert: %v", err)
+		}
+	}
+}
+
+func TestSeparatorTruncation(t *testing.T) {
+	padding := bytes.Repeat([]byte("x"), 200)
+	var keys [][]byte
+	for i := range 2000 {
+		keys = append(keys, fmt.Appendf(nil, "%05d/%s", i, padding))
+	}
+
+	inserted := newTestIndex(t)
+	defer inserted.Close()
+	for i, key := range keys {
+		if err := inserted.Insert(key, uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	loaded := newTestIndex(t)
+	defer loaded.Close()
+	if err := loaded.BulkLoad(&sliceSource{keys: keys}, 0); err != nil {
+		t.Fatalf("failed to bulk load: %v", err)
+	}
+
+	for name, idx := range map[string]*Index{"inserted": inserted, "loaded": loaded} {
+		root, _, err := idx.readNode(idx.root)
+		if err != nil {
+			t.Fatalf("%s: failed to read root: %v", name, err)
+		}
+		if root.nodeType != NodeTypeInternal {
+			t.Fatalf("%s: expected an internal root", name)
+		}
+		for _, separator := range root.keys {
+			if len(separator) > 5 {
+				t.Errorf("%s: expected separators of at most 5 bytes, got %q", name, separator)
+			}
+		}
+
+		for i, key := range keys {
+			if value, err := idx.Search(key); err != nil || value != uint64(i) {
+				t.Fatalf("%s: expected %d for key %d, got %d, err %v", name, i, i, value, err)
+			}
+		}
+	}
+}
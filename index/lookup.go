@@ -0,0 +1,151 @@
+package index
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+// Entry is the result of looking up one key with LookupMany.
+type Entry struct {
+	Found   bool
+	Value   uint64
+	Payload []byte
+}
+
+// LookupMany looks up several keys at once and returns their entries in
+// the order of keys. The keys are visited in sorted order, and every leaf
+// that holds more than one of them is read only once.
+func (idx *Index) LookupMany(keys [][]byte) ([]Entry, error) {
+	entries := make([]Entry, len(keys))
+	if idx.root == 0 || len(keys) == 0 {
+		return entries, nil
+	}
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return bytes.Compare(keys[a], keys[b])
+	})
+
+	var leaf *node
+	// upper is the first key past the current leaf, or nil for the last
+	// leaf.
+	var upper []byte
+	for _, i := range order {
+		key := keys[i]
+		if leaf == nil || upper != nil && bytes.Compare(key, upper) >= 0 {
+			var err error
+			if leaf, upper, err = idx.findLeaf(key); err != nil {
+				return nil, err
+			}
+		}
+
+		j := sort.Search(len(leaf.keys), func(j int) bool {
+			return bytes.Compare(leaf.keys[j], key) >= 0
+		})
+		if j < len(leaf.keys) && bytes.Equal(leaf.keys[j], key) {
+			entries[i] = Entry{Found: true, Value: leaf.values[j], Payload: leaf.payload(j)}
+		}
+	}
+
+	return entries, nil
+}
+
+// findLeaf returns the leaf that would hold key together with the
+// separator that bounds it from above, which is nil for the last leaf.
+func (idx *Index) findLeaf(key []byte) (*node, []byte, error) {
+	var upper []byte
+	pageID := idx.root
+	for {
+		n, _, err := idx.readNode(pageID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n.nodeType != NodeTypeInternal {
+			return n, upper, nil
+		}
+
+		i := sort.Search(len(n.keys), func(j int) bool {
+			return bytes.Compare(n.keys[j], key) > 0
+		})
+		if i < len(n.keys) {
+			upper = n.keys[i]
+		}
+		pageID = n.children[i]
+	}
+}
+
+// First returns the smallest key and its value, or a nil key if the index
+// is empty.
+func (idx *Index) First() ([]byte, uint64, error) {
+	if idx.root == 0 {
+		return nil, 0, nil
+	}
+
+	pageID := idx.root
+	for {
+		n, _, err := idx.readNode(pageID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if n.nodeType == NodeTypeInternal {
+			pageID = n.children[0]
+			continue
+		}
+		if len(n.keys) == 0 {
+			return nil, 0, nil
+		}
+		return n.keys[0], n.values[0], nil
+	}
+}
+
+// Last returns the largest key and its value, or a nil key if the index is
+// empty.
+func (idx *Index) Last() ([]byte, uint64, error) {
+	return idx.LastBefore(nil)
+}
+
+// LastBefore returns the largest key below endKey and its value, or a nil
+// key if there is none. A nil endKey is past every key.
+func (idx *Index) LastBefore(endKey []byte) ([]byte, uint64, error) {
+	if idx.root == 0 {
+		return nil, 0, nil
+	}
+	return idx.lastBefore(idx.root, endKey)
+}
+
+// lastBefore walks down the rightmost path that can hold keys below endKey,
+// stepping back to the child on the left when a subtree has none.
+func (idx *Index) lastBefore(pageID pager.PageID, endKey []byte) ([]byte, uint64, error) {
+	n, _, err := idx.readNode(pageID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	i := len(n.keys)
+	if endKey != nil {
+		i = sort.Search(len(n.keys), func(j int) bool {
+			return bytes.Compare(n.keys[j], endKey) >= 0
+		})
+	}
+
+	if n.nodeType != NodeTypeInternal {
+		if i == 0 {
+			return nil, 0, nil
+		}
+		return n.keys[i-1], n.values[i-1], nil
+	}
+
+	for ; i >= 0; i-- {
+		key, value, err := idx.lastBefore(n.children[i], endKey)
+		if err != nil || key != nil {
+			return key, value, err
+		}
+	}
+	return nil, 0, nil
+}
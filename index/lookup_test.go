@@ -0,0 +1,133 @@
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+type countingPager struct {
+	Pager
+	reads int
+}
+
+func (p *countingPager) ReadPage(pageID pager.PageID) (*pager.Page, error) {
+	p.reads++
+	return p.Pager.ReadPage(pageID)
+}
+
+func TestLookupMany(t *testing.T) {
+	idx := newTestIndex(t)
+	defer idx.Close()
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key_%05d", i)) }
+	for i := 0; i < 3000; i += 2 {
+		if err := idx.Insert(key(i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	if err := idx.InsertInline(key(3), []byte("inline"), Upsert); err != nil {
+		t.Fatalf("failed to insert inline: %v", err)
+	}
+
+	var keys [][]byte
+	for _, i := range []int{2998, 3, 0, 1, 1500, 4000, 1500, 77, 640, 642, 2} {
+		keys = append(keys, key(i))
+	}
+	keys = append(keys, []byte("a"), []byte("z"))
+
+	entries, err := idx.LookupMany(keys)
+	if err != nil {
+		t.Fatalf("failed to look up keys: %v", err)
+	}
+	for i, k := range keys {
+		value, payload, err := idx.Lookup(k)
+		expected := Entry{Found: err == nil, Value: value, Payload: payload}
+		if err != nil && err != ErrKeyNotFound {
+			t.Fatalf("failed to look up %s: %v", k, err)
+		}
+		got := entries[i]
+		if got.Found != expected.Found || got.Value != expected.Value || string(got.Payload) != string(expected.Payload) {
+			t.Errorf("expected %s to be %+v, got %+v", k, expected, got)
+		}
+	}
+
+	counter := &countingPager{Pager: idx.pager}
+	idx.pager = counter
+	defer func() { idx.pager = counter.Pager }()
+
+	keys = keys[:0]
+	for i := 1000; i < 1100; i += 2 {
+		keys = append(keys, key(i))
+	}
+	for _, k := range keys {
+		if _, _, err := idx.Lookup(k); err != nil {
+			t.Fatalf("failed to look up %s: %v", k, err)
+		}
+	}
+	single := counter.reads
+
+	counter.reads = 0
+	if _, err := idx.LookupMany(keys); err != nil {
+		t.Fatalf("failed to look up keys: %v", err)
+	}
+	if counter.reads*4 > single {
+		t.Errorf("expected batched lookups to read far fewer pages than %d, got %d", single, counter.reads)
+	}
+}
+
+func TestFirstLast(t *testing.T) {
+	idx := newTestIndex(t)
+	defer idx.Close()
+
+	if key, _, err := idx.First(); err != nil || key != nil {
+		t.Errorf("expected no first key in an empty index, got %q, err %v", key, err)
+	}
+	if key, _, err := idx.Last(); err != nil || key != nil {
+		t.Errorf("expected no last key in an empty index, got %q, err %v", key, err)
+	}
+
+	for i := range 5000 {
+		if err := idx.Insert(fmt.Appendf(nil, "key_%04d", i), uint64(i), Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	for i := 1000; i < 4000; i++ {
+		if err := idx.Delete(fmt.Appendf(nil, "key_%04d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+
+	key, value, err := idx.First()
+	if err != nil || string(key) != "key_0000" || value != 0 {
+		t.Errorf("expected first key key_0000, got %q with value %d, err %v", key, value, err)
+	}
+	key, value, err = idx.Last()
+	if err != nil || string(key) != "key_4999" || value != 4999 {
+		t.Errorf("expected last key key_4999, got %q with value %d, err %v", key, value, err)
+	}
+
+	tests := []struct {
+		endKey   string
+		expected string
+	}{
+		{"key_4500", "key_4499"},
+		{"key_4500a", "key_4500"},
+		{"key_3500", "key_0999"},
+		{"key_4000", "key_0999"},
+		{"key_0001", "key_0000"},
+		{"key_0000", ""},
+		{"a", ""},
+		{"z", "key_4999"},
+	}
+	for _, tt := range tests {
+		key, _, err := idx.LastBefore([]byte(tt.endKey))
+		if err != nil {
+			t.Fatalf("LastBefore(%s) failed: %v", tt.endKey, err)
+		}
+		if string(key) != tt.expected {
+			t.Errorf("LastBefore(%s): expected %q, got %q", tt.endKey, tt.expected, key)
+		}
+	}
+}
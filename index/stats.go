@@ -0,0 +1,55 @@
+package index
+
+import "github.com/rizalta/toydb/pager"
+
+// Stats describes how the tree uses its pages.
+type Stats struct {
+	// Height is the number of levels, 1 for a tree that is a single leaf.
+	Height int
+	// Levels holds the number of nodes on each level, root first.
+	Levels []int
+	// Pages is the number of pages in the file, the meta page included.
+	Pages int
+	// FreePages is the number of pages on the free list. Pages replaced
+	// since the last commit are not on it yet.
+	FreePages int
+	// AvgFill is how full node pages are on average, from 0 to 1, and
+	// LeafFill the same for leaves alone. Sequential inserts that split
+	// leaves at the middle leave them about half full.
+	AvgFill  float64
+	LeafFill float64
+}
+
+// Stats reads the whole tree and the free list and reports the shape of
+// the tree and how full its pages are.
+func (idx *Index) Stats() (*Stats, error) {
+	levels, err := idx.levels()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Height: len(levels),
+		Levels: make([]int, len(levels)),
+		Pages:  int(idx.pager.GetNumPages()),
+	}
+	var nodes, used int
+	for depth, level := range levels {
+		stats.Levels[depth] = len(level)
+		levelUsed := 0
+		for _, d := range level {
+			levelUsed += d.node.calculateSize()
+		}
+		nodes += len(level)
+		used += levelUsed
+		if depth == len(levels)-1 {
+			stats.LeafFill = float64(levelUsed) / float64(len(level)*pager.PageSize)
+		}
+	}
+	stats.AvgFill = float64(used) / float64(nodes*pager.PageSize)
+
+	if stats.FreePages, err = idx.alloc.FreeCount(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,131 @@
+package index
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		idx := newTestIndex(t)
+		defer idx.Close()
+
+		stats, err := idx.Stats()
+		if err != nil {
+			t.Fatalf("failed to get stats: %v", err)
+		}
+		if stats.Height != 1 || len(stats.Levels) != 1 || stats.Levels[0] != 1 {
+			t.Errorf("expected a single leaf, got height %d and levels %v", stats.Height, stats.Levels)
+		}
+		if stats.Pages != 2 || stats.FreePages != 0 {
+			t.Errorf("expected 2 pages and none free, got %d and %d", stats.Pages, stats.FreePages)
+		}
+	})
+
+	tests := []struct {
+		name string
+		keys func(n int) []int
+		// minFill and maxFill bound the fill of the leaves.
+		minFill, maxFill float64
+	}{
+		{
+			// Every split of the last leaf leaves a half-full leaf behind.
+			name:    "sequential",
+			keys:    func(n int) []int { return rangeInts(n) },
+			minFill: 0.4,
+			maxFill: 0.55,
+		},
+		{
+			name: "random",
+			keys: func(n int) []int {
+				keys := rangeInts(n)
+				rand.New(rand.NewPCG(1, 2)).Shuffle(n, func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+				return keys
+			},
+			minFill: 0.55,
+			maxFill: 0.9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := newTestIndex(t)
+			defer idx.Close()
+
+			for _, i := range tt.keys(5000) {
+				if err := idx.Insert(fmt.Appendf(nil, "key_%06d", i), uint64(i), Upsert); err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+			}
+			stats, err := idx.Stats()
+			if err != nil {
+				t.Fatalf("failed to get stats: %v", err)
+			}
+			report, err := idx.Verify()
+			if err != nil {
+				t.Fatalf("failed to verify: %v", err)
+			}
+
+			if stats.Height != report.Depth {
+				t.Errorf("expected height %d, got %d", report.Depth, stats.Height)
+			}
+			nodes := 0
+			for _, n := range stats.Levels {
+				nodes += n
+			}
+			if nodes != report.Pages {
+				t.Errorf("expected %d nodes, got %d", report.Pages, nodes)
+			}
+			if stats.Levels[stats.Height-1] != report.Leaves {
+				t.Errorf("expected %d leaves, got %d", report.Leaves, stats.Levels[stats.Height-1])
+			}
+			if stats.LeafFill < tt.minFill || stats.LeafFill > tt.maxFill {
+				t.Errorf("expected leaf fill in [%.2f, %.2f], got %.2f", tt.minFill, tt.maxFill, stats.LeafFill)
+			}
+			if stats.AvgFill <= 0 || stats.AvgFill > 1 {
+				t.Errorf("expected average fill in (0, 1], got %.2f", stats.AvgFill)
+			}
+		})
+	}
+
+	t.Run("free pages", func(t *testing.T) {
+		idx := newTestIndex(t)
+		defer idx.Close()
+
+		for i := range 5000 {
+			if err := idx.Insert(fmt.Appendf(nil, "key_%06d", i), uint64(i), Upsert); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+		for i := range 4000 {
+			if err := idx.Delete(fmt.Appendf(nil, "key_%06d", i)); err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+		}
+		if err := idx.Commit(); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+
+		stats, err := idx.Stats()
+		if err != nil {
+			t.Fatalf("failed to get stats: %v", err)
+		}
+		nodes := 0
+		for _, n := range stats.Levels {
+			nodes += n
+		}
+		if stats.FreePages == 0 || nodes+stats.FreePages != stats.Pages-1 {
+			t.Errorf("expected the %d pages besides %d nodes and the meta page to be free, got %d",
+				stats.Pages-1-nodes, nodes, stats.FreePages)
+		}
+	})
+}
+
+func rangeInts(n int) []int {
+	ints := make([]int, n)
+	for i := range ints {
+		ints[i] = i
+	}
+	return ints
+}
@@ -0,0 +1,172 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+type ProblemKind uint8
+
+const (
+	// ProblemChecksum is a page whose checksum does not match its contents.
+	ProblemChecksum ProblemKind = iota
+	// ProblemKeyOrder is a node whose keys are not in strictly increasing
+	// order.
+	ProblemKeyOrder
+	// ProblemKeyRange is a key outside the range the separators of its
+	// parents give its node.
+	ProblemKeyRange
+	// ProblemChildCount is an internal node without one more child than
+	// keys.
+	ProblemChildCount
+	// ProblemDepth is a leaf at another depth than the first leaf.
+	ProblemDepth
+	// ProblemOverflow is a node larger than a page.
+	ProblemOverflow
+	// ProblemCycle is a page reached twice while walking the tree.
+	ProblemCycle
+)
+
+var problemNames = map[ProblemKind]string{
+	ProblemChecksum:   "checksum",
+	ProblemKeyOrder:   "key order",
+	ProblemKeyRange:   "key range",
+	ProblemChildCount: "child count",
+	ProblemDepth:      "depth",
+	ProblemOverflow:   "overflow",
+	ProblemCycle:      "cycle",
+}
+
+func (k ProblemKind) String() string {
+	if name, ok := problemNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("ProblemKind(%d)", int(k))
+}
+
+type Problem struct {
+	PageID pager.PageID
+	Kind   ProblemKind
+	Detail string
+}
+
+// VerifyReport describes the tree Verify walked and what is wrong with it.
+// Underfull counts nodes other than the root below the merge threshold,
+// which deletes may leave behind and which are not a problem.
+type VerifyReport struct {
+	Pages     int
+	Leaves    int
+	Keys      int
+	Depth     int
+	Underfull int
+	Problems  []Problem
+}
+
+// OK reports whether Verify found no problems.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// verifier holds the state of a walk over the tree.
+type verifier struct {
+	idx     *Index
+	report  *VerifyReport
+	visited map[pager.PageID]bool
+}
+
+// Verify walks the whole tree and checks the checksum of every page, the
+// order of the keys within each node and against the separators above it,
+// that internal nodes have one child more than keys, that all leaves are
+// at the same depth, and that no node outgrows a page. Problems go into the report; the error is for pages that could
+// not be read at all. A subtree under a page that fails its checksum is
+// not walked.
+func (idx *Index) Verify() (*VerifyReport, error) {
+	v := &verifier{
+		idx:     idx,
+		report:  &VerifyReport{},
+		visited: make(map[pager.PageID]bool),
+	}
+	if idx.root == 0 {
+		return v.report, nil
+	}
+
+	if err := v.walk(idx.root, nil, nil, 1); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+func (v *verifier) problem(id pager.PageID, kind ProblemKind, format string, args ...any) {
+	v.report.Problems = append(v.report.Problems, Problem{
+		PageID: id,
+		Kind:   kind,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// walk checks the subtree at id, whose keys must be in [low, high), where
+// a nil bound is open.
+func (v *verifier) walk(id pager.PageID, low, high []byte, depth int) error {
+	if v.visited[id] {
+		v.problem(id, ProblemCycle, "page reached again at depth %d", depth)
+		return nil
+	}
+	v.visited[id] = true
+	v.report.Pages++
+
+	n, _, err := v.idx.readNode(id)
+	if errors.Is(err, ErrChecksumMismatch) {
+		v.problem(id, ProblemChecksum, "checksum mismatch")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if size := n.calculateSize(); size > splitThreshold {
+		v.problem(id, ProblemOverflow, "node takes %d bytes", size)
+	} else if size < mergeThreshold && id != v.idx.root {
+		v.report.Underfull++
+	}
+
+	for i, key := range n.keys {
+		if i > 0 && bytes.Compare(n.keys[i-1], key) >= 0 {
+			v.problem(id, ProblemKeyOrder, "key %d %q is not above key %d %q", i, key, i-1, n.keys[i-1])
+		}
+		if low != nil && bytes.Compare(key, low) < 0 || high != nil && bytes.Compare(key, high) >= 0 {
+			v.problem(id, ProblemKeyRange, "key %d %q is outside [%q, %q)", i, key, low, high)
+		}
+	}
+
+	if n.nodeType == NodeTypeLeaf {
+		if v.report.Depth == 0 {
+			v.report.Depth = depth
+		} else if depth != v.report.Depth {
+			v.problem(id, ProblemDepth, "leaf at depth %d, expected %d", depth, v.report.Depth)
+		}
+		v.report.Leaves++
+		v.report.Keys += len(n.keys)
+		return nil
+	}
+
+	if len(n.children) != len(n.keys)+1 {
+		v.problem(id, ProblemChildCount, "%d children for %d keys", len(n.children), len(n.keys))
+		return nil
+	}
+	for i, child := range n.children {
+		childLow, childHigh := low, high
+		if i > 0 {
+			childLow = n.keys[i-1]
+		}
+		if i < len(n.keys) {
+			childHigh = n.keys[i]
+		}
+		if err := v.walk(child, childLow, childHigh, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package index
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+// firstLeaf returns the leftmost leaf of idx.
+func firstLeaf(t *testing.T, idx *Index) (pager.PageID, *node, *pager.Page) {
+	t.Helper()
+
+	id := idx.root
+	for {
+		n, page, err := idx.readNode(id)
+		if err != nil {
+			t.Fatalf("failed to read node: %v", err)
+		}
+		if n.nodeType == NodeTypeLeaf {
+			return id, n, page
+		}
+		id = n.children[0]
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const numKeys = 2000
+	key := func(i int) []byte {
+		return fmt.Appendf(nil, "key_%06d", i)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, idx *Index)
+		want    ProblemKind
+	}{
+		{
+			name: "checksum",
+			corrupt: func(t *testing.T, idx *Index) {
+				_, _, page := firstLeaf(t, idx)
+				page.Data[pager.PageSize-1] ^= 0xff
+			},
+			want: ProblemChecksum,
+		},
+		{
+			name: "key order",
+			corrupt: func(t *testing.T, idx *Index) {
+				_, n, page := firstLeaf(t, idx)
+				n.keys[0], n.keys[1] = n.keys[1], n.keys[0]
+				if err := idx.writeNode(page, n); err != nil {
+					t.Fatalf("failed to write node: %v", err)
+				}
+			},
+			want: ProblemKeyOrder,
+		},
+		{
+			name: "key range",
+			corrupt: func(t *testing.T, idx *Index) {
+				_, n, page := firstLeaf(t, idx)
+				n.keys[len(n.keys)-1] = []byte("key_999999")
+				if err := idx.writeNode(page, n); err != nil {
+					t.Fatalf("failed to write node: %v", err)
+				}
+			},
+			want: ProblemKeyRange,
+		},
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		idx := newTestIndex(t)
+		defer idx.Close()
+
+		report, err := idx.Verify()
+		if err != nil {
+			t.Fatalf("failed to verify empty index: %v", err)
+		}
+		if !report.OK() || report.Keys != 0 || report.Leaves != 1 {
+			t.Errorf("unexpected report for empty index: %+v", report)
+		}
+
+		for i := range numKeys {
+			if err := idx.Insert(key(i), uint64(i), Upsert); err != nil {
+				t.Fatalf("failed to insert: %v", err)
+			}
+		}
+		for i := 0; i < numKeys; i += 3 {
+			if err := idx.Delete(key(i)); err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+		}
+
+		report, err = idx.Verify()
+		if err != nil {
+			t.Fatalf("failed to verify: %v", err)
+		}
+		if !report.OK() {
+			t.Errorf("expected no problems, got %v", report.Problems)
+		}
+		if want := numKeys - (numKeys+2)/3; report.Keys != want {
+			t.Errorf("expected %d keys, got %d", want, report.Keys)
+		}
+		if report.Depth < 2 || report.Leaves < 2 || report.Pages <= report.Leaves {
+			t.Errorf("expected a tree of several levels, got %+v", report)
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := newTestIndex(t)
+			defer idx.Close()
+
+			for i := range numKeys {
+				if err := idx.Insert(key(i), uint64(i), Upsert); err != nil {
+					t.Fatalf("failed to insert: %v", err)
+				}
+			}
+			tt.corrupt(t, idx)
+
+			report, err := idx.Verify()
+			if err != nil {
+				t.Fatalf("failed to verify: %v", err)
+			}
+			if !slices.ContainsFunc(report.Problems, func(p Problem) bool { return p.Kind == tt.want }) {
+				t.Errorf("expected a %s problem, got %v", tt.want, report.Problems)
+			}
+		})
+	}
+}
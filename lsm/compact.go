@@ -0,0 +1,169 @@
+package lsm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const manifestFile = "MANIFEST"
+
+// maybeCompact starts merging all runs into one in the background once
+// there are CompactAt of them.
+func (t *Tree) maybeCompact() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.compactErr != nil {
+		return t.compactErr
+	}
+	if t.compacting || len(t.runs) < t.opts.CompactAt {
+		return nil
+	}
+
+	t.compacting = true
+	runs := slices.Clone(t.runs)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		err := t.compact(runs)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.compacting = false
+		if err != nil {
+			t.compactErr = fmt.Errorf("lsm: compaction failed: %w", err)
+		}
+	}()
+	return nil
+}
+
+// compact merges runs, the oldest runs of the tree, into one. Runs are
+// never written to, so it reads them without holding mu. Since the oldest
+// run is among them, no older entry is left for a tombstone to hide, and
+// tombstones are dropped. Runs flushed meanwhile stay in front of the
+// merged one.
+func (t *Tree) compact(runs []*run) error {
+	sources := make([]source, len(runs))
+	for i, r := range runs {
+		it, err := seekRun(r, nil, false)
+		if err != nil {
+			return err
+		}
+		sources[i] = it
+	}
+	merged, err := t.writeRun(newMerger(sources), true)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	current := append(slices.Clone(t.runs[:len(t.runs)-len(runs)]), merged)
+	if err := t.writeManifest(current); err != nil {
+		merged.close()
+		os.Remove(t.runPath(merged.id))
+		return err
+	}
+	t.runs = current
+	t.version++
+
+	for _, r := range runs {
+		r.close()
+		os.Remove(t.runPath(r.id))
+	}
+
+	t.opts.Metrics.Compactions.Inc()
+	t.opts.Metrics.CompactedRuns.Add(uint64(len(runs)))
+	if info, err := merged.file.Stat(); err == nil {
+		t.opts.Metrics.CompactionBytes.Add(uint64(info.Size()))
+	}
+	return nil
+}
+
+// writeManifest replaces the list of runs in the manifest, newest first,
+// by renaming a complete new one over it. The caller holds mu.
+func (t *Tree) writeManifest(runs []*run) error {
+	var b strings.Builder
+	for _, r := range runs {
+		fmt.Fprintf(&b, "%d\n", r.id)
+	}
+
+	path := filepath.Join(t.dir, manifestFile)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(b.String()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(t.dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readManifest returns the IDs of the runs of the tree in dir, newest
+// first.
+func readManifest(dir string) ([]uint64, error) {
+	file, err := os.Open(filepath.Join(dir, manifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ids []uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		id, err := strconv.ParseUint(scanner.Text(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("lsm: bad manifest line %q", scanner.Text())
+		}
+		ids = append(ids, id)
+	}
+	return ids, scanner.Err()
+}
+
+// removeUnlisted removes the run files the manifest does not list, left
+// by a flush or compaction that did not finish.
+func removeUnlisted(dir string, ids []uint64) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.run"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), ".run"), 10, 64)
+		if err == nil && slices.Contains(ids, id) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package lsm
+
+import "bytes"
+
+// merger merges sources, newest first, into one stream in key order in
+// which each key has only the entry of the newest source holding it.
+type merger struct {
+	sources []source
+	// cur is the source of the current entry, or -1 at the end.
+	cur int
+}
+
+func newMerger(sources []source) *merger {
+	m := &merger{sources: sources}
+	m.pick()
+	return m
+}
+
+// pick finds the source with the smallest key, preferring newer ones.
+func (m *merger) pick() {
+	m.cur = -1
+	for i, s := range m.sources {
+		if s.valid() && (m.cur < 0 || bytes.Compare(s.current().key, m.sources[m.cur].current().key) < 0) {
+			m.cur = i
+		}
+	}
+}
+
+func (m *merger) valid() bool    { return m.cur >= 0 }
+func (m *merger) current() entry { return m.sources[m.cur].current() }
+
+// next moves past the current key in every source that holds it.
+func (m *merger) next() error {
+	key := m.current().key
+	for _, s := range m.sources {
+		if s.valid() && bytes.Equal(s.current().key, key) {
+			if err := s.next(); err != nil {
+				return err
+			}
+		}
+	}
+	m.pick()
+	return nil
+}
+
+// sources returns a source for the memtable and each run, positioned at
+// the first key at or, if after is set, past key. The caller holds mu.
+func (t *Tree) sources(key []byte, after bool) ([]source, error) {
+	sources := []source{&memtableIterator{node: t.memtable.seek(key, after)}}
+	for _, r := range t.runs {
+		it, err := seekRun(r, key, after)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+	return sources, nil
+}
+
+// Cursor walks the keys of a range in order, skipping deleted ones.
+type Cursor struct {
+	tree    *Tree
+	merger  *merger
+	endKey  []byte
+	payload []byte
+	isEnd   bool
+	// lastKey is the key last returned, from which the cursor seeks again
+	// if the tree changed since.
+	lastKey []byte
+	version uint64
+}
+
+// NewCursor returns a cursor over [startKey, endKey). A nil bound is open.
+func (t *Tree) NewCursor(startKey, endKey []byte) (*Cursor, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	sources, err := t.sources(startKey, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{
+		tree:    t,
+		merger:  newMerger(sources),
+		endKey:  endKey,
+		version: t.version,
+	}, nil
+}
+
+func (c *Cursor) Next() ([]byte, uint64, error) {
+	if c.isEnd {
+		return nil, 0, nil
+	}
+
+	t := c.tree
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if c.version != t.version {
+		sources, err := t.sources(c.lastKey, c.lastKey != nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		c.merger = newMerger(sources)
+		c.version = t.version
+	}
+
+	for c.merger.valid() {
+		e := c.merger.current()
+		if c.endKey != nil && bytes.Compare(e.key, c.endKey) >= 0 {
+			break
+		}
+		if err := c.merger.next(); err != nil {
+			return nil, 0, err
+		}
+		if e.kind == kindTombstone {
+			continue
+		}
+		c.lastKey = e.key
+		c.payload = e.payload
+		return e.key, e.value, nil
+	}
+
+	c.isEnd = true
+	return nil, 0, nil
+}
+
+// Payload returns the inline payload of the entry last returned by Next, or
+// nil if that entry holds an offset.
+func (c *Cursor) Payload() []byte {
+	return c.payload
+}
@@ -0,0 +1,449 @@
+// Package lsm maps keys to log offsets or inline payloads like package
+// index does, but as a log-structured merge tree: writes go to a memtable
+// in memory, which is written out as an immutable sorted run once it is
+// full, and runs are merged in the background. A write costs no page
+// writes, at the price of reads that may look at several runs.
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rizalta/toydb/checksum"
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/metrics"
+	"github.com/rizalta/toydb/pager"
+)
+
+var ErrClosed = errors.New("lsm: tree is closed")
+
+const (
+	defaultMemtableSize = 4 << 20
+	defaultCompactAt    = 4
+)
+
+// Options configures a tree. Zero values use the defaults.
+type Options struct {
+	// MemtableSize is the size in bytes from which the memtable is
+	// flushed to a run. It defaults to 4 MiB.
+	MemtableSize int
+	// CompactAt is the number of runs from which all of them are merged
+	// into one in the background. It defaults to 4.
+	CompactAt int
+	// Checksum is the algorithm of the block checksums of new runs.
+	Checksum checksum.Algorithm
+	// Metrics counts memtable flushes and compactions. Nil counts in a
+	// registry of the tree's own.
+	Metrics *metrics.Registry
+}
+
+// Tree is a log-structured merge tree. Its methods may be called while a
+// compaction runs in the background, but not concurrently with each
+// other, like those of index.Index.
+type Tree struct {
+	dir  string
+	opts Options
+
+	// mu guards memtable, runs and version against the compaction.
+	mu       sync.RWMutex
+	memtable *memtable
+	// runs are ordered newest first, so the first run holding a key has
+	// its latest entry.
+	runs []*run
+	// version changes with every write and every change to runs, so
+	// cursors know when to seek again.
+	version uint64
+	nextID  uint64
+
+	barrier    func() error
+	compacting bool
+	compactErr error
+	wg         sync.WaitGroup
+	closed     bool
+}
+
+// Open opens the tree in dir, creating it if needed.
+func Open(dir string, opts Options) (*Tree, error) {
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = defaultMemtableSize
+	}
+	if opts.CompactAt < 2 {
+		opts.CompactAt = defaultCompactAt
+	}
+	if !opts.Checksum.Valid() {
+		return nil, checksum.ErrUnknownAlgorithm
+	}
+	opts.Metrics = metrics.OrNew(opts.Metrics)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	ids, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{dir: dir, opts: opts, memtable: newMemtable(), nextID: 1}
+	for _, id := range ids {
+		r, err := openRun(t.runPath(id), id)
+		if err != nil {
+			t.closeRuns()
+			return nil, err
+		}
+		t.runs = append(t.runs, r)
+		t.nextID = max(t.nextID, id+1)
+	}
+	if err := removeUnlisted(dir, ids); err != nil {
+		t.closeRuns()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tree) runPath(id uint64) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%06d.run", id))
+}
+
+// SetWriteBarrier sets a function called before the memtable is written to
+// a run. If it returns pager.ErrWriteDeferred, the memtable keeps growing
+// and is flushed on a later write.
+func (t *Tree) SetWriteBarrier(barrier func() error) {
+	t.barrier = barrier
+}
+
+func (t *Tree) Insert(key []byte, value uint64, insertMode index.InsertMode) error {
+	return t.put(entry{key: bytes.Clone(key), kind: kindValue, value: value}, insertMode)
+}
+
+// InsertInline stores payload in place of an offset, so that Lookup and
+// cursors return it without another read.
+func (t *Tree) InsertInline(key []byte, payload []byte, insertMode index.InsertMode) error {
+	if len(payload) > index.MaxInlineSize {
+		return index.ErrValueTooLarge
+	}
+	return t.put(entry{key: bytes.Clone(key), kind: kindInline, payload: bytes.Clone(payload)}, insertMode)
+}
+
+// Delete writes a tombstone for key, which must exist.
+func (t *Tree) Delete(key []byte) error {
+	if _, _, err := t.Lookup(key); err != nil {
+		return err
+	}
+	return t.write(entry{key: bytes.Clone(key), kind: kindTombstone})
+}
+
+func (t *Tree) put(e entry, insertMode index.InsertMode) error {
+	if insertMode != index.Upsert {
+		_, _, err := t.Lookup(e.key)
+		if err != nil && !errors.Is(err, index.ErrKeyNotFound) {
+			return err
+		}
+		if found := err == nil; found && insertMode == index.InsertOnly {
+			return index.ErrKeyAlreadyExists
+		} else if !found && insertMode == index.UpdateOnly {
+			return index.ErrKeyNotFound
+		}
+	}
+	if e.kind == kindInline && e.payload == nil {
+		e.payload = []byte{}
+	}
+	return t.write(e)
+}
+
+func (t *Tree) write(e entry) error {
+	if t.closed {
+		return ErrClosed
+	}
+	t.mu.Lock()
+	t.memtable.put(e)
+	t.version++
+	full := t.memtable.size >= t.opts.MemtableSize
+	t.mu.Unlock()
+
+	if full {
+		return t.flush()
+	}
+	return nil
+}
+
+// flush writes the memtable to a new run, unless the write barrier defers
+// it, and starts a compaction once there are enough runs.
+func (t *Tree) flush() error {
+	if t.memtable.len == 0 {
+		return nil
+	}
+	if t.barrier != nil {
+		if err := t.barrier(); errors.Is(err, pager.ErrWriteDeferred) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	it := &memtableIterator{node: t.memtable.seek(nil, false)}
+	r, err := t.writeRun(it, false)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	runs := append([]*run{r}, t.runs...)
+	if err := t.writeManifest(runs); err != nil {
+		t.mu.Unlock()
+		r.close()
+		os.Remove(t.runPath(r.id))
+		return err
+	}
+	t.runs = runs
+	t.memtable = newMemtable()
+	t.version++
+	t.mu.Unlock()
+	t.opts.Metrics.MemtableFlushes.Inc()
+
+	return t.maybeCompact()
+}
+
+// source is a sorted stream of entries: the memtable, a run or a merge of
+// several.
+type source interface {
+	valid() bool
+	current() entry
+	next() error
+}
+
+// writeRun writes the entries of src to a new run, leaving out tombstones
+// if dropTombstones is set, and opens it.
+func (t *Tree) writeRun(src source, dropTombstones bool) (*run, error) {
+	t.mu.Lock()
+	id := t.nextID
+	t.nextID++
+	t.mu.Unlock()
+
+	path := t.runPath(id)
+	w, err := newRunWriter(path, t.opts.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	for src.valid() {
+		if e := src.current(); !dropTombstones || e.kind != kindTombstone {
+			if err := w.add(e); err != nil {
+				w.abort()
+				return nil, err
+			}
+		}
+		if err := src.next(); err != nil {
+			w.abort()
+			return nil, err
+		}
+	}
+	if err := w.finish(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return openRun(path, id)
+}
+
+// latest returns the newest entry of key, which may be a tombstone.
+func (t *Tree) latest(key []byte) (entry, bool, error) {
+	if e, ok := t.memtable.get(key); ok {
+		return e, true, nil
+	}
+	for _, r := range t.runs {
+		e, ok, err := r.get(key)
+		if err != nil || ok {
+			return e, ok, err
+		}
+	}
+	return entry{}, false, nil
+}
+
+// Lookup returns the entry for key, which is either an offset or, for
+// entries written with InsertInline, a non-nil payload.
+func (t *Tree) Lookup(key []byte) (uint64, []byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	e, ok, err := t.latest(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !ok || e.kind == kindTombstone {
+		return 0, nil, index.ErrKeyNotFound
+	}
+	return e.value, e.payload, nil
+}
+
+// LookupMany looks up several keys and returns their entries in the order
+// of keys.
+func (t *Tree) LookupMany(keys [][]byte) ([]index.Entry, error) {
+	entries := make([]index.Entry, len(keys))
+	for i, key := range keys {
+		value, payload, err := t.Lookup(key)
+		if errors.Is(err, index.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = index.Entry{Found: true, Value: value, Payload: payload}
+	}
+	return entries, nil
+}
+
+// First returns the smallest key and its value, or a nil key if the tree
+// is empty.
+func (t *Tree) First() ([]byte, uint64, error) {
+	c, err := t.NewCursor(nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.Next()
+}
+
+// LastBefore returns the largest key below endKey and its value, or a nil
+// key if there is none. A nil endKey is past every key. The largest key
+// below endKey in any run may be deleted in a newer one, in which case it
+// looks again below that key.
+func (t *Tree) LastBefore(endKey []byte) ([]byte, uint64, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for {
+		var last []byte
+		if e, ok := t.memtable.lastBefore(endKey); ok {
+			last = e.key
+		}
+		for _, r := range t.runs {
+			e, ok, err := r.lastBefore(endKey)
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok && (last == nil || bytes.Compare(e.key, last) > 0) {
+				last = e.key
+			}
+		}
+		if last == nil {
+			return nil, 0, nil
+		}
+
+		e, _, err := t.latest(last)
+		if err != nil {
+			return nil, 0, err
+		}
+		if e.kind != kindTombstone {
+			return e.key, e.value, nil
+		}
+		endKey = last
+	}
+}
+
+// Count returns the number of keys in [startKey, endKey).
+func (t *Tree) Count(startKey, endKey []byte) (int, error) {
+	c, err := t.NewCursor(startKey, endKey)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for {
+		key, _, err := c.Next()
+		if err != nil {
+			return 0, err
+		}
+		if key == nil {
+			return count, nil
+		}
+		count++
+	}
+}
+
+// BulkLoad fills an empty tree from entries sorted by key, writing them
+// straight to a single run. The fill factor is accepted for the sake of
+// index.Index and ignored, as runs are never written to again.
+func (t *Tree) BulkLoad(src index.Source, fillFactor float64) error {
+	if fillFactor < 0 || fillFactor > 1 {
+		return index.ErrInvalidFillFactor
+	}
+	if key, _, err := t.First(); err != nil {
+		return err
+	} else if key != nil {
+		return index.ErrIndexNotEmpty
+	}
+
+	s := &bulkSource{src: src}
+	if err := s.next(); err != nil {
+		return err
+	}
+	r, err := t.writeRun(s, true)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	runs := append([]*run{r}, t.runs...)
+	if err := t.writeManifest(runs); err != nil {
+		r.close()
+		os.Remove(t.runPath(r.id))
+		return err
+	}
+	t.runs = runs
+	t.version++
+	return nil
+}
+
+// bulkSource turns an index.Source into a source, checking its order.
+type bulkSource struct {
+	src   index.Source
+	entry entry
+	done  bool
+}
+
+func (s *bulkSource) valid() bool    { return !s.done }
+func (s *bulkSource) current() entry { return s.entry }
+func (s *bulkSource) next() error {
+	key, value, err := s.src.Next()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		s.done = true
+		return nil
+	}
+	if s.entry.key != nil && bytes.Compare(s.entry.key, key) >= 0 {
+		return index.ErrUnsortedKeys
+	}
+
+	payload := s.src.Payload()
+	if len(payload) > index.MaxInlineSize {
+		return index.ErrValueTooLarge
+	}
+	s.entry = entry{key: bytes.Clone(key), kind: kindValue, value: value}
+	if payload != nil {
+		s.entry = entry{key: s.entry.key, kind: kindInline, payload: bytes.Clone(payload)}
+	}
+	return nil
+}
+
+// Close flushes the memtable, waits for a running compaction and closes
+// the runs.
+func (t *Tree) Close() error {
+	if t.closed {
+		return ErrClosed
+	}
+	err := t.flush()
+	t.wg.Wait()
+	t.closed = true
+	return errors.Join(err, t.compactErr, t.closeRuns())
+}
+
+func (t *Tree) closeRuns() error {
+	var errs []error
+	for _, r := range t.runs {
+		errs = append(errs, r.close())
+	}
+	t.runs = nil
+	return errors.Join(errs...)
+}
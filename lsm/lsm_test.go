@@ -0,0 +1,260 @@
+package lsm
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/rizalta/toydb/index"
+)
+
+func newTestTree(t *testing.T, dir string) *Tree {
+	t.Helper()
+
+	tree, err := Open(dir, Options{MemtableSize: 4096, CompactAt: 3})
+	if err != nil {
+		t.Fatalf("failed to open tree: %v", err)
+	}
+	return tree
+}
+
+func key(i int) []byte {
+	return fmt.Appendf(nil, "key_%05d", i)
+}
+
+// checkTree compares every way of reading the tree with want.
+func checkTree(t *testing.T, tree *Tree, want map[string]uint64) {
+	t.Helper()
+
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	c, err := tree.NewCursor(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create cursor: %v", err)
+	}
+	var got []string
+	for {
+		k, v, err := c.Next()
+		if err != nil {
+			t.Fatalf("failed to advance cursor: %v", err)
+		}
+		if k == nil {
+			break
+		}
+		if v != want[string(k)] {
+			t.Errorf("expected %d for %s, got %d", want[string(k)], k, v)
+		}
+		got = append(got, string(k))
+	}
+	if !slices.Equal(got, keys) {
+		t.Fatalf("expected %d keys from the cursor, got %d", len(keys), len(got))
+	}
+
+	for k, v := range want {
+		value, _, err := tree.Lookup([]byte(k))
+		if err != nil || value != v {
+			t.Errorf("expected %d for %s, got %d, err %v", v, k, value, err)
+		}
+	}
+
+	if count, err := tree.Count(nil, nil); err != nil || count != len(keys) {
+		t.Errorf("expected a count of %d, got %d, err %v", len(keys), count, err)
+	}
+	last, _, err := tree.LastBefore(nil)
+	if err != nil {
+		t.Fatalf("failed to get last key: %v", err)
+	}
+	if len(keys) > 0 && string(last) != keys[len(keys)-1] || len(keys) == 0 && last != nil {
+		t.Errorf("expected last key %v, got %s", keys[len(keys)-1:], last)
+	}
+}
+
+func TestTree(t *testing.T) {
+	dir := t.TempDir()
+	tree := newTestTree(t, dir)
+
+	want := make(map[string]uint64)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range 5000 {
+		k := key(rng.IntN(1000))
+		if rng.IntN(4) == 0 {
+			err := tree.Delete(k)
+			if _, ok := want[string(k)]; ok != (err == nil) {
+				t.Fatalf("unexpected delete result for %s: %v", k, err)
+			}
+			delete(want, string(k))
+			continue
+		}
+		if err := tree.Insert(k, uint64(i), index.Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+		want[string(k)] = uint64(i)
+	}
+	checkTree(t, tree, want)
+
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	stats := tree.opts.Metrics.Snapshot()
+	if stats.MemtableFlushes == 0 || stats.Compactions == 0 || stats.CompactionBytes == 0 {
+		t.Errorf("expected flushes and compactions to be counted, got %+v", stats)
+	}
+	if stats.CompactedRuns < 3*stats.Compactions {
+		t.Errorf("expected compactions to merge at least 3 runs each, got %d for %d", stats.CompactedRuns, stats.Compactions)
+	}
+	tree = newTestTree(t, dir)
+	defer tree.Close()
+	if len(tree.runs) == 0 || len(tree.runs) > 3 {
+		t.Errorf("expected compacted runs after reopening, got %d", len(tree.runs))
+	}
+	checkTree(t, tree, want)
+}
+
+func TestInsertModes(t *testing.T) {
+	tree := newTestTree(t, t.TempDir())
+	defer tree.Close()
+
+	if err := tree.Insert([]byte("a"), 1, index.UpdateOnly); !errors.Is(err, index.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := tree.Insert([]byte("a"), 1, index.InsertOnly); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := tree.Insert([]byte("a"), 2, index.InsertOnly); !errors.Is(err, index.ErrKeyAlreadyExists) {
+		t.Errorf("expected ErrKeyAlreadyExists, got %v", err)
+	}
+	if err := tree.InsertInline([]byte("a"), []byte("payload"), index.UpdateOnly); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if _, payload, err := tree.Lookup([]byte("a")); err != nil || string(payload) != "payload" {
+		t.Errorf("expected payload, got %q, err %v", payload, err)
+	}
+	if err := tree.Delete([]byte("a")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := tree.Delete([]byte("a")); !errors.Is(err, index.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestCursorWhileWriting(t *testing.T) {
+	tree := newTestTree(t, t.TempDir())
+	defer tree.Close()
+
+	for i := range 1000 {
+		if err := tree.Insert(key(i), uint64(i), index.Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+
+	// Deleting each key as it is returned flushes and compacts under the
+	// cursor, which has to seek again each time.
+	c, err := tree.NewCursor(key(100), key(900))
+	if err != nil {
+		t.Fatalf("failed to create cursor: %v", err)
+	}
+	seen := 0
+	for {
+		k, _, err := c.Next()
+		if err != nil {
+			t.Fatalf("failed to advance cursor: %v", err)
+		}
+		if k == nil {
+			break
+		}
+		if want := key(100 + seen); string(k) != string(want) {
+			t.Fatalf("expected %s, got %s", want, k)
+		}
+		if err := tree.Delete(k); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+		seen++
+	}
+	if seen != 800 {
+		t.Errorf("expected 800 keys, got %d", seen)
+	}
+	if count, err := tree.Count(nil, nil); err != nil || count != 200 {
+		t.Errorf("expected 200 keys left, got %d, err %v", count, err)
+	}
+}
+
+func TestLastBefore(t *testing.T) {
+	tree := newTestTree(t, t.TempDir())
+	defer tree.Close()
+
+	for i := range 500 {
+		if err := tree.Insert(key(i), uint64(i), index.Upsert); err != nil {
+			t.Fatalf("failed to insert: %v", err)
+		}
+	}
+	// The last keys are deleted in the memtable and live in older runs.
+	for i := 400; i < 500; i++ {
+		if err := tree.Delete(key(i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+
+	tests := []struct {
+		endKey []byte
+		want   []byte
+	}{
+		{nil, key(399)},
+		{key(450), key(399)},
+		{key(250), key(249)},
+		{key(0), nil},
+	}
+	for _, tt := range tests {
+		got, _, err := tree.LastBefore(tt.endKey)
+		if err != nil {
+			t.Fatalf("failed to get last key before %s: %v", tt.endKey, err)
+		}
+		if string(got) != string(tt.want) {
+			t.Errorf("expected %s before %s, got %s", tt.want, tt.endKey, got)
+		}
+	}
+}
+
+type sliceSource struct {
+	keys [][]byte
+	i    int
+}
+
+func (s *sliceSource) Next() ([]byte, uint64, error) {
+	if s.i == len(s.keys) {
+		return nil, 0, nil
+	}
+	s.i++
+	return s.keys[s.i-1], uint64(s.i), nil
+}
+
+func (s *sliceSource) Payload() []byte { return nil }
+
+func TestBulkLoad(t *testing.T) {
+	tree := newTestTree(t, t.TempDir())
+	defer tree.Close()
+
+	if err := tree.BulkLoad(&sliceSource{keys: [][]byte{key(2), key(1)}}, 0); !errors.Is(err, index.ErrUnsortedKeys) {
+		t.Errorf("expected ErrUnsortedKeys, got %v", err)
+	}
+
+	want := make(map[string]uint64)
+	src := &sliceSource{}
+	for i := range 2000 {
+		src.keys = append(src.keys, key(i))
+		want[string(key(i))] = uint64(i + 1)
+	}
+	if err := tree.BulkLoad(src, 0); err != nil {
+		t.Fatalf("failed to bulk load: %v", err)
+	}
+	checkTree(t, tree, want)
+
+	if err := tree.BulkLoad(&sliceSource{keys: [][]byte{key(1)}}, 0); !errors.Is(err, index.ErrIndexNotEmpty) {
+		t.Errorf("expected ErrIndexNotEmpty, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package lsm
+
+import (
+	"bytes"
+	"math/rand/v2"
+)
+
+const (
+	maxHeight = 12
+	// branching is the inverse of the chance that a node of a level is on
+	// the next level too.
+	branching = 4
+)
+
+// memtable holds the latest writes in key order, in a skip list, until
+// they are flushed to a run.
+type memtable struct {
+	head   *skipNode
+	height int
+	len    int
+	// size approximates the bytes the entries take in a run.
+	size int
+}
+
+type skipNode struct {
+	entry entry
+	next  []*skipNode
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		head:   &skipNode{next: make([]*skipNode, maxHeight)},
+		height: 1,
+	}
+}
+
+// put adds e, replacing the entry of the same key if there is one.
+func (m *memtable) put(e entry) {
+	var prev [maxHeight]*skipNode
+	x := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for x.next[level] != nil && bytes.Compare(x.next[level].entry.key, e.key) < 0 {
+			x = x.next[level]
+		}
+		prev[level] = x
+	}
+
+	if x = x.next[0]; x != nil && bytes.Equal(x.entry.key, e.key) {
+		m.size += e.size() - x.entry.size()
+		x.entry = e
+		return
+	}
+
+	height := 1
+	for height < maxHeight && rand.IntN(branching) == 0 {
+		height++
+	}
+	for ; m.height < height; m.height++ {
+		prev[m.height] = m.head
+	}
+
+	n := &skipNode{entry: e, next: make([]*skipNode, height)}
+	for level := range height {
+		n.next[level] = prev[level].next[level]
+		prev[level].next[level] = n
+	}
+	m.len++
+	m.size += e.size()
+}
+
+// seek returns the node of the first key at or, if after is set, past
+// key. A nil key is before every key.
+func (m *memtable) seek(key []byte, after bool) *skipNode {
+	x := m.head
+	if key == nil {
+		return x.next[0]
+	}
+	for level := m.height - 1; level >= 0; level-- {
+		for x.next[level] != nil {
+			cmp := bytes.Compare(x.next[level].entry.key, key)
+			if cmp > 0 || cmp == 0 && !after {
+				break
+			}
+			x = x.next[level]
+		}
+	}
+	return x.next[0]
+}
+
+// get returns the entry of key, which may be a tombstone.
+func (m *memtable) get(key []byte) (entry, bool) {
+	n := m.seek(key, false)
+	if n == nil || !bytes.Equal(n.entry.key, key) {
+		return entry{}, false
+	}
+	return n.entry, true
+}
+
+// lastBefore returns the entry of the largest key below endKey, which may
+// be a tombstone. A nil endKey is past every key.
+func (m *memtable) lastBefore(endKey []byte) (entry, bool) {
+	x := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for x.next[level] != nil && (endKey == nil || bytes.Compare(x.next[level].entry.key, endKey) < 0) {
+			x = x.next[level]
+		}
+	}
+	if x == m.head {
+		return entry{}, false
+	}
+	return x.entry, true
+}
+
+// memtableIterator walks a memtable in key order.
+type memtableIterator struct {
+	node *skipNode
+}
+
+func (it *memtableIterator) valid() bool    { return it.node != nil }
+func (it *memtableIterator) current() entry { return it.node.entry }
+func (it *memtableIterator) next() error {
+	it.node = it.node.next[0]
+	return nil
+}
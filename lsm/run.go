@@ -0,0 +1,411 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/rizalta/toydb/checksum"
+)
+
+var (
+	ErrCorruptRun = errors.New("lsm: run file is corrupt")
+)
+
+// Kinds of entries.
+const (
+	kindValue byte = iota
+	kindInline
+	kindTombstone
+)
+
+// entry maps a key to an offset, an inline payload, or, for a tombstone,
+// to nothing, hiding the key in older runs.
+type entry struct {
+	key     []byte
+	kind    byte
+	value   uint64
+	payload []byte
+}
+
+// size returns about how many bytes e takes in a run.
+func (e entry) size() int {
+	return len(e.key) + len(e.payload) + 12
+}
+
+func (e entry) appendTo(buf []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(e.key)))
+	buf = append(buf, e.key...)
+	buf = append(buf, e.kind)
+	switch e.kind {
+	case kindValue:
+		buf = binary.AppendUvarint(buf, e.value)
+	case kindInline:
+		buf = binary.AppendUvarint(buf, uint64(len(e.payload)))
+		buf = append(buf, e.payload...)
+	}
+	return buf
+}
+
+// decodeEntry decodes the entry at the start of data and returns the
+// number of bytes it took.
+func decodeEntry(data []byte) (entry, int, error) {
+	var e entry
+	keyLen, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < keyLen+1 {
+		return e, 0, ErrCorruptRun
+	}
+	pos := n
+	e.key = data[pos : pos+int(keyLen)]
+	pos += int(keyLen)
+	e.kind = data[pos]
+	pos++
+
+	switch e.kind {
+	case kindValue:
+		if e.value, n = binary.Uvarint(data[pos:]); n <= 0 {
+			return e, 0, ErrCorruptRun
+		}
+		pos += n
+	case kindInline:
+		payloadLen, n := binary.Uvarint(data[pos:])
+		if n <= 0 || uint64(len(data)-pos-n) < payloadLen {
+			return e, 0, ErrCorruptRun
+		}
+		pos += n
+		e.payload = data[pos : pos+int(payloadLen)]
+		pos += int(payloadLen)
+	case kindTombstone:
+	default:
+		return e, 0, ErrCorruptRun
+	}
+	return e, pos, nil
+}
+
+// A run file holds its entries in key order, in blocks of about
+// blockSize bytes, followed by an index of the blocks and a footer:
+//
+//	blocks | index | index offset (8) | index length (4) | entries (8) |
+//	checksum algorithm (1) | magic (4)
+//
+// Each index item is the first key of a block, its offset, its length and
+// its checksum.
+const (
+	blockSize  = 4096
+	footerSize = 25
+	runMagic   = 0x6c736d31
+)
+
+type blockHandle struct {
+	firstKey []byte
+	offset   uint64
+	length   uint32
+	sum      uint32
+}
+
+// runWriter writes entries, added in key order, to a new run file.
+type runWriter struct {
+	file      *os.File
+	algorithm checksum.Algorithm
+	block     []byte
+	firstKey  []byte
+	offset    uint64
+	handles   []blockHandle
+	entries   uint64
+}
+
+func newRunWriter(path string, algorithm checksum.Algorithm) (*runWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &runWriter{file: file, algorithm: algorithm}, nil
+}
+
+func (w *runWriter) add(e entry) error {
+	if len(w.block) == 0 {
+		w.firstKey = bytes.Clone(e.key)
+	}
+	w.block = e.appendTo(w.block)
+	w.entries++
+	if len(w.block) >= blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+func (w *runWriter) flushBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	if _, err := w.file.Write(w.block); err != nil {
+		return err
+	}
+	w.handles = append(w.handles, blockHandle{
+		firstKey: w.firstKey,
+		offset:   w.offset,
+		length:   uint32(len(w.block)),
+		sum:      w.algorithm.Sum(w.block),
+	})
+	w.offset += uint64(len(w.block))
+	w.block = w.block[:0]
+	return nil
+}
+
+// finish writes the index and footer and makes the file durable.
+func (w *runWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, h := range w.handles {
+		buf = binary.AppendUvarint(buf, uint64(len(h.firstKey)))
+		buf = append(buf, h.firstKey...)
+		buf = binary.AppendUvarint(buf, h.offset)
+		buf = binary.AppendUvarint(buf, uint64(h.length))
+		buf = binary.LittleEndian.AppendUint32(buf, h.sum)
+	}
+	indexLen := len(buf)
+	buf = binary.LittleEndian.AppendUint64(buf, w.offset)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(indexLen))
+	buf = binary.LittleEndian.AppendUint64(buf, w.entries)
+	buf = append(buf, byte(w.algorithm))
+	buf = binary.LittleEndian.AppendUint32(buf, runMagic)
+
+	if _, err := w.file.Write(buf); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// abort closes and removes a run file that was not finished.
+func (w *runWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// run is an immutable run file opened for reading. Its block index is
+// kept in memory and blocks are read as needed.
+type run struct {
+	id        uint64
+	file      *os.File
+	algorithm checksum.Algorithm
+	handles   []blockHandle
+	entries   uint64
+}
+
+func openRun(path string, id uint64) (*run, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := readRun(file, id)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptRun, path, err)
+	}
+	return r, nil
+}
+
+func readRun(file *os.File, id uint64) (*run, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < footerSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	footer := make([]byte, footerSize)
+	if _, err := file.ReadAt(footer, stat.Size()-footerSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[21:]) != runMagic {
+		return nil, errors.New("bad magic")
+	}
+	indexOffset := binary.LittleEndian.Uint64(footer)
+	indexLen := binary.LittleEndian.Uint32(footer[8:])
+	r := &run{
+		id:        id,
+		file:      file,
+		entries:   binary.LittleEndian.Uint64(footer[12:]),
+		algorithm: checksum.Algorithm(footer[20]),
+	}
+	if !r.algorithm.Valid() {
+		return nil, checksum.ErrUnknownAlgorithm
+	}
+	if indexOffset+uint64(indexLen)+footerSize != uint64(stat.Size()) {
+		return nil, errors.New("bad index offset")
+	}
+
+	index := make([]byte, indexLen)
+	if _, err := file.ReadAt(index, int64(indexOffset)); err != nil {
+		return nil, err
+	}
+	for len(index) > 0 {
+		var h blockHandle
+		keyLen, n := binary.Uvarint(index)
+		if n <= 0 || uint64(len(index)-n) < keyLen {
+			return nil, errors.New("bad index")
+		}
+		h.firstKey = index[n : n+int(keyLen)]
+		index = index[n+int(keyLen):]
+		if h.offset, n = binary.Uvarint(index); n <= 0 {
+			return nil, errors.New("bad index")
+		}
+		index = index[n:]
+		length, n := binary.Uvarint(index)
+		if n <= 0 || len(index)-n < 4 {
+			return nil, errors.New("bad index")
+		}
+		h.length = uint32(length)
+		h.sum = binary.LittleEndian.Uint32(index[n:])
+		index = index[n+4:]
+		r.handles = append(r.handles, h)
+	}
+	return r, nil
+}
+
+func (r *run) close() error {
+	return r.file.Close()
+}
+
+// block reads and decodes the i-th block.
+func (r *run) block(i int) ([]entry, error) {
+	h := r.handles[i]
+	data := make([]byte, h.length)
+	if _, err := r.file.ReadAt(data, int64(h.offset)); err != nil {
+		return nil, err
+	}
+	if r.algorithm.Sum(data) != h.sum {
+		return nil, fmt.Errorf("%w: checksum mismatch in block %d of run %d", ErrCorruptRun, i, r.id)
+	}
+
+	var entries []entry
+	for len(data) > 0 {
+		e, n, err := decodeEntry(data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+		data = data[n:]
+	}
+	return entries, nil
+}
+
+// blockFor returns the index of the block that holds key if any does, or
+// -1 if key is below the first key of the run.
+func (r *run) blockFor(key []byte) int {
+	return sort.Search(len(r.handles), func(i int) bool {
+		return bytes.Compare(r.handles[i].firstKey, key) > 0
+	}) - 1
+}
+
+// get returns the entry of key, which may be a tombstone.
+func (r *run) get(key []byte) (entry, bool, error) {
+	b := r.blockFor(key)
+	if b < 0 {
+		return entry{}, false, nil
+	}
+	entries, err := r.block(b)
+	if err != nil {
+		return entry{}, false, err
+	}
+	i := sort.Search(len(entries), func(j int) bool {
+		return bytes.Compare(entries[j].key, key) >= 0
+	})
+	if i == len(entries) || !bytes.Equal(entries[i].key, key) {
+		return entry{}, false, nil
+	}
+	return entries[i], true, nil
+}
+
+// lastBefore returns the entry of the largest key below endKey, which may
+// be a tombstone. A nil endKey is past every key.
+func (r *run) lastBefore(endKey []byte) (entry, bool, error) {
+	b := len(r.handles) - 1
+	if endKey != nil {
+		b = sort.Search(len(r.handles), func(i int) bool {
+			return bytes.Compare(r.handles[i].firstKey, endKey) >= 0
+		}) - 1
+	}
+	if b < 0 {
+		return entry{}, false, nil
+	}
+	entries, err := r.block(b)
+	if err != nil {
+		return entry{}, false, err
+	}
+	i := len(entries)
+	if endKey != nil {
+		i = sort.Search(len(entries), func(j int) bool {
+			return bytes.Compare(entries[j].key, endKey) >= 0
+		})
+	}
+	return entries[i-1], true, nil
+}
+
+// runIterator walks a run in key order, a block at a time.
+type runIterator struct {
+	run     *run
+	block   int
+	entries []entry
+	pos     int
+}
+
+// seekRun returns an iterator at the first key of r at or, if after is
+// set, past key. A nil key is before every key.
+func seekRun(r *run, key []byte, after bool) (*runIterator, error) {
+	it := &runIterator{run: r}
+	if key != nil {
+		it.block = max(r.blockFor(key), 0)
+	}
+	if err := it.load(); err != nil {
+		return nil, err
+	}
+	if key != nil {
+		it.pos = sort.Search(len(it.entries), func(j int) bool {
+			cmp := bytes.Compare(it.entries[j].key, key)
+			return cmp > 0 || cmp == 0 && !after
+		})
+		if it.pos == len(it.entries) {
+			if err := it.nextBlock(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return it, nil
+}
+
+func (it *runIterator) load() error {
+	it.pos = 0
+	if it.block >= len(it.run.handles) {
+		it.entries = nil
+		return nil
+	}
+	var err error
+	it.entries, err = it.run.block(it.block)
+	return err
+}
+
+func (it *runIterator) nextBlock() error {
+	it.block++
+	return it.load()
+}
+
+func (it *runIterator) valid() bool    { return it.pos < len(it.entries) }
+func (it *runIterator) current() entry { return it.entries[it.pos] }
+func (it *runIterator) next() error {
+	if it.pos++; it.pos == len(it.entries) {
+		return it.nextBlock()
+	}
+	return nil
+}
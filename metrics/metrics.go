@@ -0,0 +1,130 @@
+// Package metrics counts what a database does, so that embedders, and a
+// server in front of one, can tell how it is used: cache hits, page and
+// record I/O, fsyncs, index node splits and merges, and compactions.
+package metrics
+
+import (
+	"iter"
+	"sync/atomic"
+)
+
+// Counter is a count that only goes up. It is safe for concurrent use.
+type Counter struct {
+	n atomic.Uint64
+}
+
+func (c *Counter) Add(n uint64) { c.n.Add(n) }
+
+func (c *Counter) Inc() { c.n.Add(1) }
+
+func (c *Counter) Load() uint64 { return c.n.Load() }
+
+// Registry holds the counters of a database, which its layers add to. The
+// zero value is ready to use.
+type Registry struct {
+	CacheHits   Counter
+	CacheMisses Counter
+	PageReads   Counter
+	PageWrites  Counter
+	Fsyncs      Counter
+	Splits      Counter
+	Merges      Counter
+	// RecordsWritten and RecordBytes count the records appended to the
+	// data log, batch records included, and their size as logged.
+	RecordsWritten Counter
+	RecordBytes    Counter
+	// MemtableFlushes, Compactions, CompactedRuns and CompactionBytes
+	// count the work of the LSM engine: memtables written to runs, the
+	// compactions that merged runs, the runs they merged and the bytes of
+	// the runs they wrote.
+	MemtableFlushes Counter
+	Compactions     Counter
+	CompactedRuns   Counter
+	CompactionBytes Counter
+}
+
+// OrNew returns r, or a new registry if r is nil, for layers that count
+// whether or not anyone reads the counts.
+func OrNew(r *Registry) *Registry {
+	if r == nil {
+		return new(Registry)
+	}
+	return r
+}
+
+// Stats is a snapshot of the counters of a Registry.
+type Stats struct {
+	CacheHits       uint64
+	CacheMisses     uint64
+	PageReads       uint64
+	PageWrites      uint64
+	Fsyncs          uint64
+	Splits          uint64
+	Merges          uint64
+	RecordsWritten  uint64
+	RecordBytes     uint64
+	MemtableFlushes uint64
+	Compactions     uint64
+	CompactedRuns   uint64
+	CompactionBytes uint64
+}
+
+// Snapshot reads every counter. Counters are read one at a time, so a
+// snapshot taken while the database is busy may be off by the operations
+// that ran meanwhile.
+func (r *Registry) Snapshot() Stats {
+	return Stats{
+		CacheHits:       r.CacheHits.Load(),
+		CacheMisses:     r.CacheMisses.Load(),
+		PageReads:       r.PageReads.Load(),
+		PageWrites:      r.PageWrites.Load(),
+		Fsyncs:          r.Fsyncs.Load(),
+		Splits:          r.Splits.Load(),
+		Merges:          r.Merges.Load(),
+		RecordsWritten:  r.RecordsWritten.Load(),
+		RecordBytes:     r.RecordBytes.Load(),
+		MemtableFlushes: r.MemtableFlushes.Load(),
+		Compactions:     r.Compactions.Load(),
+		CompactedRuns:   r.CompactedRuns.Load(),
+		CompactionBytes: r.CompactionBytes.Load(),
+	}
+}
+
+// All yields every counter by a snake_case name, such as cache_hits, for
+// exporting them.
+func (s Stats) All() iter.Seq2[string, uint64] {
+	return func(yield func(string, uint64) bool) {
+		counters := []struct {
+			name  string
+			value uint64
+		}{
+			{"cache_hits", s.CacheHits},
+			{"cache_misses", s.CacheMisses},
+			{"page_reads", s.PageReads},
+			{"page_writes", s.PageWrites},
+			{"fsyncs", s.Fsyncs},
+			{"splits", s.Splits},
+			{"merges", s.Merges},
+			{"records_written", s.RecordsWritten},
+			{"record_bytes", s.RecordBytes},
+			{"memtable_flushes", s.MemtableFlushes},
+			{"compactions", s.Compactions},
+			{"compacted_runs", s.CompactedRuns},
+			{"compaction_bytes", s.CompactionBytes},
+		}
+		for _, c := range counters {
+			if !yield(c.name, c.value) {
+				return
+			}
+		}
+	}
+}
+
+// CacheHitRatio is the share of page reads served from the cache, from 0
+// to 1, or 0 before any read.
+func (s Stats) CacheHitRatio() float64 {
+	if total := s.CacheHits + s.CacheMisses; total > 0 {
+		return float64(s.CacheHits) / float64(total)
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var r Registry
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				r.CacheHits.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	r.CacheMisses.Add(8000)
+	r.RecordBytes.Add(42)
+
+	stats := r.Snapshot()
+	if stats.CacheHits != 8000 || stats.CacheMisses != 8000 || stats.RecordBytes != 42 {
+		t.Errorf("expected the counts added, got %+v", stats)
+	}
+	if ratio := stats.CacheHitRatio(); ratio != 0.5 {
+		t.Errorf("expected a hit ratio of 0.5, got %f", ratio)
+	}
+	if ratio := (Stats{}).CacheHitRatio(); ratio != 0 {
+		t.Errorf("expected a hit ratio of 0 before any read, got %f", ratio)
+	}
+
+	all := make(map[string]uint64)
+	for name, value := range stats.All() {
+		all[name] = value
+	}
+	if len(all) != 13 || all["cache_hits"] != 8000 || all["record_bytes"] != 42 {
+		t.Errorf("expected every counter by name, got %v", all)
+	}
+
+	if OrNew(&r) != &r || OrNew(nil) == nil {
+		t.Errorf("expected OrNew to keep a registry and make one for nil")
+	}
+}
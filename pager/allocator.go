@@ -0,0 +1,183 @@
+package pager
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var ErrFreeListCycle = errors.New("pager: free list loops")
+
+// PageStore is the part of a Pager an Allocator needs.
+type PageStore interface {
+	NewPage() (*Page, error)
+	ReadPage(pageID PageID) (*Page, error)
+	WritePage(page *Page) error
+	GetNumPages() uint32
+}
+
+// Allocator hands out the pages of a file and takes them back. It is safe
+// for concurrent use.
+//
+// Free pages form a list, each holding the ID of the next in its first
+// bytes, whose head the owner of the file records together with its own
+// state through Head. The allocator keeps crashes from losing or sharing
+// pages in two ways:
+//   - A page allocated since the last Commit is fresh and is freed at once
+//     when released. A released page that is not fresh may still be
+//     referenced by the state last committed, so it is freed only on the
+//     next Commit.
+//   - After a crash, the owner calls Rebuild, which frees every page that
+//     the committed state does not use. These include any fresh pages
+//     taken off the list since the last commit.
+type Allocator struct {
+	mu      sync.Mutex
+	pages   PageStore
+	head    PageID
+	fresh   map[PageID]bool
+	pending []PageID
+}
+
+// NewAllocator returns an allocator for pages whose free list starts at
+// head, or is empty if head is 0.
+func NewAllocator(pages PageStore, head PageID) *Allocator {
+	return &Allocator{
+		pages: pages,
+		head:  head,
+		fresh: make(map[PageID]bool),
+	}
+}
+
+// Allocate returns a zeroed page, from the free list if it has one and
+// otherwise from the end of the file.
+func (a *Allocator) Allocate() (*Page, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var page *Page
+	if a.head != 0 {
+		free, err := a.pages.ReadPage(a.head)
+		if err != nil {
+			return nil, err
+		}
+		page = &Page{ID: a.head}
+		a.head = PageID(binary.LittleEndian.Uint32(free.Data[:]))
+	} else {
+		var err error
+		if page, err = a.pages.NewPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	a.fresh[page.ID] = true
+	return page, nil
+}
+
+// IsFresh reports whether a page was allocated since the last commit, so
+// that the committed state does not use it and it may be written in place.
+func (a *Allocator) IsFresh(pageID PageID) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.fresh[pageID]
+}
+
+// Uncommitted returns the number of pages allocated since the last commit.
+func (a *Allocator) Uncommitted() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.fresh)
+}
+
+// Release gives back a page that is no longer used, right away if it is
+// fresh and otherwise on the next commit.
+func (a *Allocator) Release(pageID PageID) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.fresh[pageID] {
+		delete(a.fresh, pageID)
+		return a.free(pageID)
+	}
+	a.pending = append(a.pending, pageID)
+	return nil
+}
+
+// Commit is called once the owner has durably committed a state that no
+// longer uses the released pages. It frees them, and from then on the
+// pages allocated so far are part of the committed state.
+func (a *Allocator) Commit() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, pageID := range a.pending {
+		if err := a.free(pageID); err != nil {
+			a.pending = a.pending[i:]
+			return err
+		}
+	}
+	a.pending = a.pending[:0]
+	clear(a.fresh)
+	return nil
+}
+
+// Head returns the first page of the free list, which the owner records
+// to hand to NewAllocator when the file is opened again.
+func (a *Allocator) Head() PageID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.head
+}
+
+// Rebuild replaces the free list with every page of the file that inUse
+// rejects, except page 0, as 0 ends the list. If it fails, the free list
+// is left empty, which wastes the free pages but never hands out one in
+// use.
+func (a *Allocator) Rebuild(inUse func(PageID) bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.head = 0
+	a.pending = a.pending[:0]
+	clear(a.fresh)
+	for pageID := PageID(a.pages.GetNumPages()) - 1; pageID > 0; pageID-- {
+		if inUse(pageID) {
+			continue
+		}
+		if err := a.free(pageID); err != nil {
+			a.head = 0
+			return err
+		}
+	}
+	return nil
+}
+
+// FreeCount walks the free list and returns its length. Pages released
+// since the last commit are not on it yet.
+func (a *Allocator) FreeCount() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	count := 0
+	for pageID := a.head; pageID != 0; count++ {
+		if count >= int(a.pages.GetNumPages()) {
+			return 0, ErrFreeListCycle
+		}
+		page, err := a.pages.ReadPage(pageID)
+		if err != nil {
+			return 0, err
+		}
+		pageID = PageID(binary.LittleEndian.Uint32(page.Data[:]))
+	}
+	return count, nil
+}
+
+// free pushes a page onto the free list. The caller holds mu.
+func (a *Allocator) free(pageID PageID) error {
+	page := &Page{ID: pageID}
+	binary.LittleEndian.PutUint32(page.Data[:], uint32(a.head))
+	if err := a.pages.WritePage(page); err != nil {
+		return err
+	}
+	a.head = pageID
+	return nil
+}
@@ -0,0 +1,145 @@
+package pager
+
+import (
+	"sync"
+	"testing"
+)
+
+func newTestAllocator(t *testing.T, pages int) (*Pager, *Allocator) {
+	t.Helper()
+	pager, err := NewPager(createTempDB(t))
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	t.Cleanup(func() { pager.Close() })
+
+	alloc := NewAllocator(pager, 0)
+	for range pages {
+		if _, err := alloc.Allocate(); err != nil {
+			t.Fatalf("failed to allocate page: %v", err)
+		}
+	}
+	if err := alloc.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	return pager, alloc
+}
+
+func TestAllocatorFreeList(t *testing.T) {
+	_, alloc := newTestAllocator(t, 3)
+
+	for _, id := range []PageID{1, 2} {
+		if err := alloc.Release(id); err != nil {
+			t.Fatalf("failed to release page %d: %v", id, err)
+		}
+	}
+	if alloc.Head() != 0 {
+		t.Fatalf("expected committed pages to wait for a commit, got free list at %d", alloc.Head())
+	}
+	if err := alloc.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	if count, err := alloc.FreeCount(); err != nil || count != 2 {
+		t.Fatalf("expected 2 free pages, got %d (%v)", count, err)
+	}
+
+	for _, want := range []PageID{2, 1, 3} {
+		page, err := alloc.Allocate()
+		if err != nil {
+			t.Fatalf("failed to allocate page: %v", err)
+		}
+		if page.ID != want {
+			t.Errorf("expected page %d, got %d", want, page.ID)
+		}
+		if !alloc.IsFresh(page.ID) {
+			t.Errorf("expected page %d to be fresh", page.ID)
+		}
+	}
+}
+
+func TestAllocatorReleaseFresh(t *testing.T) {
+	_, alloc := newTestAllocator(t, 1)
+
+	page, err := alloc.Allocate()
+	if err != nil {
+		t.Fatalf("failed to allocate page: %v", err)
+	}
+	if alloc.Uncommitted() != 1 {
+		t.Errorf("expected 1 uncommitted page, got %d", alloc.Uncommitted())
+	}
+	if err := alloc.Release(page.ID); err != nil {
+		t.Fatalf("failed to release page: %v", err)
+	}
+	if alloc.Head() != page.ID {
+		t.Errorf("expected fresh page %d to be freed at once, got free list at %d", page.ID, alloc.Head())
+	}
+	if alloc.Uncommitted() != 0 {
+		t.Errorf("expected no uncommitted pages, got %d", alloc.Uncommitted())
+	}
+}
+
+func TestAllocatorRebuild(t *testing.T) {
+	_, alloc := newTestAllocator(t, 6)
+
+	inUse := map[PageID]bool{1: true, 3: true}
+	if err := alloc.Rebuild(func(id PageID) bool { return inUse[id] }); err != nil {
+		t.Fatalf("failed to rebuild: %v", err)
+	}
+
+	// Page 0 ends the list and is never handed out.
+	for _, want := range []PageID{2, 4, 5, 6} {
+		page, err := alloc.Allocate()
+		if err != nil {
+			t.Fatalf("failed to allocate page: %v", err)
+		}
+		if page.ID != want {
+			t.Errorf("expected page %d, got %d", want, page.ID)
+		}
+	}
+}
+
+func TestAllocatorFreeListCycle(t *testing.T) {
+	pager, alloc := newTestAllocator(t, 2)
+
+	page := &Page{ID: 1}
+	page.Data[0] = 1
+	if err := pager.WritePage(page); err != nil {
+		t.Fatalf("failed to write page: %v", err)
+	}
+	alloc = NewAllocator(pager, 1)
+	if _, err := alloc.FreeCount(); err != ErrFreeListCycle {
+		t.Errorf("expected %v, got %v", ErrFreeListCycle, err)
+	}
+}
+
+func TestAllocatorConcurrent(t *testing.T) {
+	_, alloc := newTestAllocator(t, 0)
+
+	const workers, perWorker = 8, 50
+	ids := make(chan PageID, workers*perWorker)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				page, err := alloc.Allocate()
+				if err != nil {
+					t.Errorf("failed to allocate page: %v", err)
+					return
+				}
+				ids <- page.ID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[PageID]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("page %d allocated twice", id)
+		}
+		seen[id] = true
+	}
+}
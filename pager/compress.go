@@ -0,0 +1,450 @@
+package pager
+
+import (
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrCompressed = errors.New("pager: offset reads and writes on a compressed or encrypted pager")
+	ErrDirectory  = errors.New("pager: corrupt page directory")
+)
+
+// A compressed or encrypted file starts with two header slots, which take
+// turns pointing at the latest page directory, so that a torn write of one
+// leaves the other. The pages and the directory are stored in extents
+// after them. The flags of the header say how pages are stored.
+const (
+	compressedMagic = "TDBZ"
+	headerSlotSize  = 512
+	headerSize      = 56
+	dataStart       = 2 * headerSlotSize
+	// dirEntrySize is the size of the extent of one page in the directory.
+	dirEntrySize = 12
+
+	flagCompressed = 1
+	flagEncrypted  = 2
+)
+
+// extent is where the bytes of a page, or of the directory, are stored.
+// A page whose bytes, once decrypted, are PageSize long is not compressed,
+// and one of length zero was never written and reads as zeros.
+type extent struct {
+	offset int64
+	length uint32
+}
+
+func (e extent) end() int64 {
+	return e.offset + int64(e.length)
+}
+
+// directory maps the pages of a compressed or encrypted file to the
+// extents they are stored in, and keeps track of the space between them.
+type directory struct {
+	// flags and check are those of the header, fixed when the file is
+	// created.
+	flags uint32
+	check [keyCheckSize]byte
+
+	mu    sync.Mutex
+	pages []extent
+	// free is the unused space before end, sorted by offset.
+	free []extent
+	// pending is the space freed since the directory was last committed,
+	// which the committed one may still point to.
+	pending []extent
+	end     int64
+	dir     extent
+	seq     uint64
+	changed bool
+
+	// commitMu keeps commits in order.
+	commitMu sync.Mutex
+}
+
+type header struct {
+	seq      uint64
+	numPages uint32
+	dir      extent
+	dirSum   uint32
+	flags    uint32
+	check    [keyCheckSize]byte
+}
+
+func (h header) encode() []byte {
+	data := make([]byte, headerSize)
+	copy(data, compressedMagic)
+	binary.LittleEndian.PutUint64(data[4:], h.seq)
+	binary.LittleEndian.PutUint32(data[12:], h.numPages)
+	binary.LittleEndian.PutUint64(data[16:], uint64(h.dir.offset))
+	binary.LittleEndian.PutUint32(data[24:], h.dir.length)
+	binary.LittleEndian.PutUint32(data[28:], h.dirSum)
+	binary.LittleEndian.PutUint32(data[32:], h.flags)
+	copy(data[36:], h.check[:])
+	binary.LittleEndian.PutUint32(data[52:], crc32.ChecksumIEEE(data[:52]))
+	return data
+}
+
+func decodeHeader(data []byte) (header, bool) {
+	if len(data) < headerSize || string(data[:4]) != compressedMagic {
+		return header{}, false
+	}
+	h := header{
+		seq:      binary.LittleEndian.Uint64(data[4:]),
+		numPages: binary.LittleEndian.Uint32(data[12:]),
+		dir:      extent{int64(binary.LittleEndian.Uint64(data[16:])), binary.LittleEndian.Uint32(data[24:])},
+		dirSum:   binary.LittleEndian.Uint32(data[28:]),
+	}
+	switch {
+	case binary.LittleEndian.Uint32(data[52:]) == crc32.ChecksumIEEE(data[:52]):
+		h.flags = binary.LittleEndian.Uint32(data[32:])
+		copy(h.check[:], data[36:])
+	case binary.LittleEndian.Uint32(data[32:]) == crc32.ChecksumIEEE(data[:32]):
+		// Headers written before encryption end with the checksum after
+		// dirSum, and their files are always compressed.
+		h.flags = flagCompressed
+	default:
+		return header{}, false
+	}
+	return h, true
+}
+
+// openDirectory reads the directory of a compressed or encrypted file. In
+// an empty file it starts one with the flags and key check value of
+// create, unless that is nil. It returns nil for a file of plain pages.
+func openDirectory(f file, size int64, create *header) (*directory, error) {
+	if size == 0 {
+		if create == nil {
+			return nil, nil
+		}
+		h := *create
+		h.seq = 1
+		if _, err := f.WriteAt(h.encode(), headerSlotSize); err != nil {
+			return nil, fmt.Errorf("pager: failed to write header: %w", err)
+		}
+		return &directory{flags: h.flags, check: h.check, end: dataStart, seq: h.seq}, f.Sync()
+	}
+
+	var h header
+	var found bool
+	for slot := range int64(2) {
+		data := make([]byte, headerSize)
+		if n, _ := f.ReadAt(data, slot*headerSlotSize); n < headerSize {
+			continue
+		}
+		if slotHeader, ok := decodeHeader(data); ok && (!found || slotHeader.seq > h.seq) {
+			h, found = slotHeader, true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	d := &directory{flags: h.flags, check: h.check, pages: make([]extent, h.numPages), dir: h.dir, seq: h.seq}
+	if h.numPages > 0 {
+		data := make([]byte, h.dir.length)
+		if _, err := f.ReadAt(data, h.dir.offset); err != nil {
+			return nil, fmt.Errorf("pager: failed to read page directory: %w", err)
+		}
+		if len(data) != int(h.numPages)*dirEntrySize || crc32.ChecksumIEEE(data) != h.dirSum {
+			return nil, ErrDirectory
+		}
+		for i := range d.pages {
+			entry := data[i*dirEntrySize:]
+			d.pages[i] = extent{int64(binary.LittleEndian.Uint64(entry)), binary.LittleEndian.Uint32(entry[8:])}
+		}
+	}
+
+	// What the directory does not point to is free, including whatever
+	// was written after the last commit.
+	used := []extent{d.dir}
+	for _, e := range d.pages {
+		if e.length > 0 {
+			used = append(used, e)
+		}
+	}
+	slices.SortFunc(used, func(a, b extent) int { return cmp.Compare(a.offset, b.offset) })
+	maxLength := uint32(PageSize)
+	if d.flags&flagEncrypted != 0 {
+		maxLength += sealOverhead
+	}
+	d.end = dataStart
+	for _, e := range used {
+		if e.length == 0 {
+			continue
+		}
+		if e.offset < d.end || e.length > maxLength && e != d.dir {
+			return nil, ErrDirectory
+		}
+		if e.offset > d.end {
+			d.free = append(d.free, extent{d.end, uint32(e.offset - d.end)})
+		}
+		d.end = e.end()
+	}
+	return d, nil
+}
+
+// allocate returns the offset of length bytes of free space, from the end
+// of the file if no free extent is large enough. The caller holds mu.
+func (d *directory) allocate(length int) int64 {
+	for i, e := range d.free {
+		if int(e.length) < length {
+			continue
+		}
+		if int(e.length) == length {
+			d.free = slices.Delete(d.free, i, i+1)
+		} else {
+			d.free[i] = extent{e.offset + int64(length), e.length - uint32(length)}
+		}
+		return e.offset
+	}
+	offset := d.end
+	d.end += int64(length)
+	return offset
+}
+
+// release returns space to the free extents, merging it with its
+// neighbours. The caller holds mu.
+func (d *directory) release(e extent) {
+	if e.length == 0 {
+		return
+	}
+	i, _ := slices.BinarySearchFunc(d.free, e.offset, func(f extent, offset int64) int { return cmp.Compare(f.offset, offset) })
+	d.free = slices.Insert(d.free, i, e)
+	if i+1 < len(d.free) && d.free[i].end() == d.free[i+1].offset {
+		d.free[i].length += d.free[i+1].length
+		d.free = slices.Delete(d.free, i+1, i+2)
+	}
+	if i > 0 && d.free[i-1].end() == d.free[i].offset {
+		d.free[i-1].length += d.free[i].length
+		d.free = slices.Delete(d.free, i, i+1)
+	}
+}
+
+// place records that pages are stored in extents of lengths one after the
+// other from offset. The caller holds mu.
+func (d *directory) place(pages []*Page, lengths []int, offset int64) {
+	for i, page := range pages {
+		for int(page.ID) >= len(d.pages) {
+			d.pages = append(d.pages, extent{})
+		}
+		if old := d.pages[page.ID]; old.length > 0 {
+			d.pending = append(d.pending, old)
+		}
+		d.pages[page.ID] = extent{offset, uint32(lengths[i])}
+		offset += int64(lengths[i])
+	}
+	d.changed = true
+}
+
+func (d *directory) encode() []byte {
+	data := make([]byte, len(d.pages)*dirEntrySize)
+	for i, e := range d.pages {
+		binary.LittleEndian.PutUint64(data[i*dirEntrySize:], uint64(e.offset))
+		binary.LittleEndian.PutUint32(data[i*dirEntrySize+8:], e.length)
+	}
+	return data
+}
+
+var pageWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// compressPage returns page compressed, unless that does not make it
+// smaller.
+func compressPage(page *Page) ([]byte, error) {
+	var buf bytes.Buffer
+	w := pageWriters.Get().(*flate.Writer)
+	defer pageWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(page.Data[:]); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= PageSize {
+		return page.Data[:], nil
+	}
+	return buf.Bytes(), nil
+}
+
+// encodePage returns the bytes page is stored in: compressed if the file
+// is, and then sealed if it is encrypted.
+func (p *Pager) encodePage(page *Page) ([]byte, error) {
+	data := page.Data[:]
+	if p.dir.flags&flagCompressed != 0 {
+		var err error
+		if data, err = compressPage(page); err != nil {
+			return nil, fmt.Errorf("pager: failed to compress page: %w", err)
+		}
+	}
+	if p.aead != nil {
+		var err error
+		if data, err = sealPage(p.aead, page.ID, data); err != nil {
+			return nil, fmt.Errorf("pager: failed to encrypt page: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// writeExtents writes a run of pages as they are stored, one after the
+// other in a single extent of free space. They replace the pages in the
+// directory once written, and become durable with the next commit.
+func (p *Pager) writeExtents(run []*Page, prio Priority) error {
+	var data []byte
+	lengths := make([]int, len(run))
+	for i, page := range run {
+		stored, err := p.encodePage(page)
+		if err != nil {
+			return err
+		}
+		data = append(data, stored...)
+		lengths[i] = len(stored)
+	}
+	p.scheduler.Load().Acquire(prio, len(data))
+
+	d := p.dir
+	d.mu.Lock()
+	offset := d.allocate(len(data))
+	d.mu.Unlock()
+
+	n, err := p.file.WriteAt(data, offset)
+	if err == nil && n != len(data) {
+		err = fmt.Errorf("pager: partial write: wrote %d bytes, expected %d bytes", n, len(data))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.release(extent{offset, uint32(len(data))})
+		return fmt.Errorf("pager: failed to write page: %w", err)
+	}
+	d.place(run, lengths, offset)
+
+	for _, page := range run {
+		p.metrics.PageWrites.Inc()
+		p.hooks.PageWrite(uint32(page.ID))
+	}
+	return nil
+}
+
+// readExtent reads a page from the extent the directory has for it.
+func (p *Pager) readExtent(page *Page) error {
+	d := p.dir
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if int(page.ID) >= len(d.pages) || d.pages[page.ID].length == 0 {
+		return nil
+	}
+
+	e := d.pages[page.ID]
+	var data []byte
+	if p.mapping != nil {
+		var err error
+		if data, err = p.mapping.read(e.offset, int(e.length)); err != nil {
+			return err
+		}
+	}
+	if data == nil {
+		data = make([]byte, e.length)
+		if _, err := p.file.ReadAt(data, e.offset); err != nil {
+			return err
+		}
+	}
+
+	if p.aead != nil {
+		var err error
+		if data, err = openPage(p.aead, page.ID, data); err != nil {
+			return err
+		}
+	}
+	if len(data) == PageSize {
+		copy(page.Data[:], data)
+		return nil
+	}
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	if _, err := io.ReadFull(r, page.Data[:]); err != nil {
+		return fmt.Errorf("pager: failed to decompress page %d: %w", page.ID, err)
+	}
+	return nil
+}
+
+// commit makes the pages written so far durable. It writes the directory
+// to free space and syncs the file, then points the spare header slot at
+// it and syncs again. The space of the pages written over, and of the old
+// directory, is reused only after that, as a crash before would leave the
+// old directory in charge.
+func (p *Pager) commit() error {
+	d := p.dir
+	d.commitMu.Lock()
+	defer d.commitMu.Unlock()
+
+	d.mu.Lock()
+	if !d.changed {
+		d.mu.Unlock()
+		return p.file.Sync()
+	}
+	data := d.encode()
+	previous := d.dir
+	if previous.length > 0 {
+		d.pending = append(d.pending, previous)
+	}
+	d.dir = extent{d.allocate(len(data)), uint32(len(data))}
+	h := header{
+		seq:      d.seq + 1,
+		numPages: uint32(len(d.pages)),
+		dir:      d.dir,
+		dirSum:   crc32.ChecksumIEEE(data),
+		flags:    d.flags,
+		check:    d.check,
+	}
+	freed := len(d.pending)
+	d.changed = false
+	d.mu.Unlock()
+
+	err := p.writeDirectory(h, data)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		// The old directory is still the one in charge, so it is not to
+		// be reused, and the space of the new one is free again.
+		if previous.length > 0 {
+			d.pending = slices.Delete(d.pending, freed-1, freed)
+		}
+		d.release(d.dir)
+		d.dir = previous
+		d.changed = true
+		return err
+	}
+	d.seq = h.seq
+	for _, e := range d.pending[:freed] {
+		d.release(e)
+	}
+	d.pending = slices.Delete(d.pending, 0, freed)
+	return nil
+}
+
+func (p *Pager) writeDirectory(h header, data []byte) error {
+	if _, err := p.file.WriteAt(data, h.dir.offset); err != nil {
+		return fmt.Errorf("pager: failed to write page directory: %w", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if _, err := p.file.WriteAt(h.encode(), int64(h.seq%2)*headerSlotSize); err != nil {
+		return fmt.Errorf("pager: failed to write header: %w", err)
+	}
+	return p.file.Sync()
+}
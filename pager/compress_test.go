@@ -0,0 +1,266 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// textPage fills a page with text that compresses well, different for
+// every page and version.
+func textPage(id PageID, version int) []byte {
+	return []byte(strings.Repeat(fmt.Sprintf("page %d version %d, ", id, version), PageSize)[:PageSize])
+}
+
+func TestCompressedPager(t *testing.T) {
+	dbPath := createTempDB(t)
+	pager, err := NewPagerWithOptions(dbPath, Options{CacheSize: 4, Compress: true})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	if pager.dir == nil {
+		t.Fatalf("expected a compressed file")
+	}
+
+	write := func(id PageID, version int) {
+		t.Helper()
+		page := &Page{ID: id}
+		copy(page.Data[:], textPage(id, version))
+		if err := pager.WritePage(page); err != nil {
+			t.Fatalf("failed to write page %d: %v", id, err)
+		}
+	}
+	for range 100 {
+		page, err := pager.NewPage()
+		if err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+		write(page.ID, 0)
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	size, err := pager.GetSize()
+	if err != nil {
+		t.Fatalf("failed to get size: %v", err)
+	}
+	if size > 100*PageSize/10 {
+		t.Errorf("expected 100 pages of text to take a tenth of their size, got %d bytes", size)
+	}
+
+	// Pages written over are stored anew, and their old space is reused
+	// once the directory that points to it is replaced.
+	for round := 1; round <= 5; round++ {
+		for id := range PageID(100) {
+			write(id, round)
+		}
+		if err := pager.Flush(); err != nil {
+			t.Fatalf("failed to flush: %v", err)
+		}
+	}
+	if grown, _ := pager.GetSize(); grown > 3*size {
+		t.Errorf("expected the space of old pages to be reused, grew from %d to %d bytes", size, grown)
+	}
+
+	if _, err := pager.ReadAtOffset(0, 4); !errors.Is(err, ErrCompressed) {
+		t.Errorf("expected %v from ReadAtOffset, got %v", ErrCompressed, err)
+	}
+	if err := pager.WriteAtOffset(0, []byte("data")); !errors.Is(err, ErrCompressed) {
+		t.Errorf("expected %v from WriteAtOffset, got %v", ErrCompressed, err)
+	}
+	if err := pager.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// The file says it is compressed, whatever the options.
+	for _, opts := range []Options{{}, {ReadOnly: true, Mmap: true}} {
+		pager, err := NewPagerWithOptions(dbPath, opts)
+		if err != nil {
+			t.Fatalf("failed to reopen pager: %v", err)
+		}
+		if pager.GetNumPages() != 100 {
+			t.Errorf("expected 100 pages, got %d", pager.GetNumPages())
+		}
+		for id := range PageID(100) {
+			page, err := pager.ReadPage(id)
+			if err != nil {
+				t.Fatalf("failed to read page %d: %v", id, err)
+			}
+			if !bytes.Equal(page.Data[:], textPage(id, 5)) {
+				t.Fatalf("expected page %d to hold its last version, got %.30q", id, page.Data[:])
+			}
+		}
+		if err := pager.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
+	}
+}
+
+func TestCompressExistingFile(t *testing.T) {
+	dbPath := createTempDB(t)
+	pager, err := NewPager(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	if _, err := pager.NewPage(); err != nil {
+		t.Fatalf("failed to create page: %v", err)
+	}
+	if err := pager.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	pager, err = NewPagerWithOptions(dbPath, Options{Compress: true})
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+	if pager.dir != nil || pager.GetNumPages() != 1 {
+		t.Errorf("expected the file to stay uncompressed with its page")
+	}
+}
+
+// TestCompressedCrash crashes a compressed pager at every write of its
+// second round of pages and checks that the file reopens to the pages of
+// one round or the other, but never a mix.
+func TestCompressedCrash(t *testing.T) {
+	const pages = 20
+	run := func(dbPath string, faults *Faults, crashAt int) {
+		pager, err := NewPagerWithOptions(dbPath, Options{
+			CacheSize: 8,
+			Compress:  true,
+			Faults:    faults,
+			Logger:    slog.New(slog.DiscardHandler),
+		})
+		if err != nil {
+			t.Fatalf("failed to create pager: %v", err)
+		}
+		for version := range 2 {
+			if version == 1 {
+				faults.CrashAt(crashAt, crashAt%2*7)
+			}
+			for id := range PageID(pages) {
+				page := &Page{ID: id}
+				copy(page.Data[:], textPage(id, version))
+				if err := pager.WritePage(page); err != nil && !errors.Is(err, ErrInjectedFault) {
+					t.Fatalf("failed to write page %d: %v", id, err)
+				}
+			}
+			if err := pager.Flush(); err != nil && !errors.Is(err, ErrInjectedFault) {
+				t.Fatalf("failed to flush: %v", err)
+			}
+		}
+		pager.Close()
+	}
+
+	faults := &Faults{}
+	run(createTempDB(t), faults, 0)
+	writes := faults.Writes()
+
+	for n := 1; n <= writes; n++ {
+		dbPath := createTempDB(t)
+		faults := &Faults{}
+		run(dbPath, faults, n)
+		if !faults.Crashed() {
+			continue
+		}
+
+		pager, err := NewPager(dbPath)
+		if err != nil {
+			t.Fatalf("crash at write %d: failed to reopen: %v", n, err)
+		}
+		versions := map[int]int{}
+		for id := range PageID(pages) {
+			page, err := pager.ReadPage(id)
+			if err != nil {
+				t.Fatalf("crash at write %d: failed to read page %d: %v", n, id, err)
+			}
+			for version := range 2 {
+				if bytes.Equal(page.Data[:], textPage(id, version)) {
+					versions[version]++
+				}
+			}
+		}
+		pager.Close()
+		if versions[0] != pages && versions[1] != pages {
+			t.Errorf("crash at write %d: expected all pages of one flush, got %v", n, versions)
+		}
+	}
+}
+
+// TestCompressedFailedCommit checks that a commit that fails to write its
+// directory leaves the old one in charge and frees the space of the new.
+func TestCompressedFailedCommit(t *testing.T) {
+	faults := &Faults{}
+	pager, err := NewPagerWithOptions(createTempDB(t), Options{CacheSize: 4, Compress: true, Faults: faults})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	page, err := pager.NewPage()
+	if err != nil {
+		t.Fatalf("failed to create page: %v", err)
+	}
+	copy(page.Data[:], textPage(page.ID, 0))
+	if err := pager.WritePage(page); err != nil {
+		t.Fatalf("failed to write page: %v", err)
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	d := pager.dir
+	d.mu.Lock()
+	previous, end := d.dir, d.end
+	d.changed = true
+	d.mu.Unlock()
+
+	faults.CrashAt(1, 0)
+	if err := pager.commit(); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("expected %v, got %v", ErrInjectedFault, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dir != previous {
+		t.Errorf("expected directory %v to stay in charge, got %v", previous, d.dir)
+	}
+	if len(d.pending) != 0 {
+		t.Errorf("expected no space waiting to be freed, got %v", d.pending)
+	}
+	if free := (extent{end, previous.length}); !slices.Contains(d.free, free) {
+		t.Errorf("expected the space of the new directory %v to be free, got %v", free, d.free)
+	}
+}
+
+func TestDecodeHeaderBeforeEncryption(t *testing.T) {
+	// Before encryption, the header ended with the checksum after dirSum.
+	data := make([]byte, headerSize)
+	copy(data, compressedMagic)
+	binary.LittleEndian.PutUint64(data[4:], 7)
+	binary.LittleEndian.PutUint32(data[12:], 3)
+	binary.LittleEndian.PutUint64(data[16:], dataStart)
+	binary.LittleEndian.PutUint32(data[24:], 3*dirEntrySize)
+	binary.LittleEndian.PutUint32(data[28:], 42)
+	binary.LittleEndian.PutUint32(data[32:], crc32.ChecksumIEEE(data[:32]))
+
+	h, ok := decodeHeader(data)
+	if !ok {
+		t.Fatalf("expected the header to decode")
+	}
+	expected := header{seq: 7, numPages: 3, dir: extent{dataStart, 3 * dirEntrySize}, dirSum: 42, flags: flagCompressed}
+	if h != expected {
+		t.Errorf("expected header %+v, got %+v", expected, h)
+	}
+
+	data[20]++
+	if _, ok := decodeHeader(data); ok {
+		t.Errorf("expected a corrupt header not to decode")
+	}
+}
@@ -0,0 +1,89 @@
+package pager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrWrongKey     = errors.New("pager: wrong encryption key")
+	ErrKeyRequired  = errors.New("pager: file is encrypted and no key was given")
+	ErrNotEncrypted = errors.New("pager: key given for a file that is not encrypted")
+	ErrPageAuth     = errors.New("pager: page failed authentication")
+)
+
+// keyCheckSize is the size of the key check value in the header of an
+// encrypted file: the tag AES-GCM computes with the key over
+// keyCheckLabel, which tells a wrong key from a right one before any page
+// is read.
+const (
+	keyCheckSize  = 16
+	keyCheckLabel = "toydb key check"
+)
+
+// sealOverhead is what sealPage adds to the stored bytes of a page: its
+// nonce and tag.
+const sealOverhead = 12 + 16
+
+// newAEAD returns AES-GCM with key, which must be 16, 24 or 32 bytes.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("pager: invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyCheck returns the key check value of aead. The nonce is fixed, which
+// is safe as it only ever authenticates the same label.
+func keyCheck(aead cipher.AEAD) [keyCheckSize]byte {
+	var check [keyCheckSize]byte
+	copy(check[:], aead.Seal(nil, make([]byte, aead.NonceSize()), nil, []byte(keyCheckLabel)))
+	return check
+}
+
+// checkKey matches the key of a pager with the header of its file.
+func checkKey(d *directory, aead cipher.AEAD) error {
+	switch {
+	case d.flags&flagEncrypted == 0 && aead != nil:
+		return ErrNotEncrypted
+	case d.flags&flagEncrypted == 0:
+		return nil
+	case aead == nil:
+		return ErrKeyRequired
+	}
+	check := keyCheck(aead)
+	if subtle.ConstantTimeCompare(check[:], d.check[:]) != 1 {
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// sealPage encrypts the stored bytes of a page under a random nonce, which
+// it prefixes them with. The page ID is authenticated along, so that the
+// extents of two pages cannot be swapped.
+func sealPage(aead cipher.AEAD, id PageID, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, binary.LittleEndian.AppendUint32(nil, uint32(id))), nil
+}
+
+// openPage decrypts what sealPage returned.
+func openPage(aead cipher.AEAD, id PageID, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: page %d", ErrPageAuth, id)
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, binary.LittleEndian.AppendUint32(nil, uint32(id)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: page %d", ErrPageAuth, id)
+	}
+	return plain, nil
+}
@@ -0,0 +1,146 @@
+package pager
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestEncryptedPager(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, compress := range []bool{false, true} {
+		dbPath := createTempDB(t)
+		pager, err := NewPagerWithOptions(dbPath, Options{CacheSize: 4, Compress: compress, Key: key})
+		if err != nil {
+			t.Fatalf("failed to create pager: %v", err)
+		}
+		if !pager.Encrypted() || pager.Compressed() != compress {
+			t.Fatalf("expected an encrypted file, compressed %v", compress)
+		}
+		for id := range PageID(20) {
+			if _, err := pager.NewPage(); err != nil {
+				t.Fatalf("failed to create page: %v", err)
+			}
+			page := &Page{ID: id}
+			copy(page.Data[:], textPage(id, 0))
+			if err := pager.WritePage(page); err != nil {
+				t.Fatalf("failed to write page %d: %v", id, err)
+			}
+		}
+		if err := pager.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
+
+		data, err := os.ReadFile(dbPath)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if bytes.Contains(data, []byte("version 0")) {
+			t.Errorf("compress %v: expected the file not to hold the pages in the clear", compress)
+		}
+
+		for _, opts := range []Options{{Key: key}, {Key: key, ReadOnly: true, Mmap: true}} {
+			pager, err := NewPagerWithOptions(dbPath, opts)
+			if err != nil {
+				t.Fatalf("failed to reopen pager: %v", err)
+			}
+			for id := range PageID(20) {
+				page, err := pager.ReadPage(id)
+				if err != nil {
+					t.Fatalf("failed to read page %d: %v", id, err)
+				}
+				if !bytes.Equal(page.Data[:], textPage(id, 0)) {
+					t.Fatalf("compress %v: expected page %d back, got %.30q", compress, id, page.Data[:])
+				}
+			}
+			if err := pager.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+		}
+
+		tests := []struct {
+			name string
+			key  []byte
+			err  error
+		}{
+			{"wrong key", bytes.Repeat([]byte{8}, 32), ErrWrongKey},
+			{"shorter key", key[:16], ErrWrongKey},
+			{"no key", nil, ErrKeyRequired},
+		}
+		for _, tt := range tests {
+			if _, err := NewPagerWithOptions(dbPath, Options{Key: tt.key}); !errors.Is(err, tt.err) {
+				t.Errorf("compress %v, %s: expected %v, got %v", compress, tt.name, tt.err, err)
+			}
+		}
+	}
+}
+
+func TestEncryptedPageTampered(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 16)
+	dbPath := createTempDB(t)
+	pager, err := NewPagerWithOptions(dbPath, Options{Key: key})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	for id := range PageID(2) {
+		if _, err := pager.NewPage(); err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+		page := &Page{ID: id}
+		copy(page.Data[:], textPage(id, 0))
+		if err := pager.WritePage(page); err != nil {
+			t.Fatalf("failed to write page %d: %v", id, err)
+		}
+	}
+	if err := pager.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	extents := pager.dir.pages
+
+	// A flipped bit fails the page it is in, and so does the extent of
+	// another page.
+	f, err := os.OpenFile(dbPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	b := make([]byte, 1)
+	f.ReadAt(b, extents[0].offset+100)
+	f.WriteAt([]byte{b[0] ^ 1}, extents[0].offset+100)
+	swapped := make([]byte, extents[1].length)
+	f.ReadAt(swapped, extents[1].offset)
+	f.Close()
+
+	pager, err = NewPagerWithOptions(dbPath, Options{Key: key})
+	if err != nil {
+		t.Fatalf("failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+	if _, err := pager.ReadPage(0); !errors.Is(err, ErrPageAuth) {
+		t.Errorf("expected %v for a flipped bit, got %v", ErrPageAuth, err)
+	}
+	if _, err := openPage(pager.aead, 0, swapped); !errors.Is(err, ErrPageAuth) {
+		t.Errorf("expected %v for the extent of another page, got %v", ErrPageAuth, err)
+	}
+}
+
+func TestKeyForPlainFile(t *testing.T) {
+	dbPath := createTempDB(t)
+	pager, err := NewPager(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	if _, err := pager.NewPage(); err != nil {
+		t.Fatalf("failed to create page: %v", err)
+	}
+	if err := pager.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	if _, err := NewPagerWithOptions(dbPath, Options{Key: make([]byte, 16)}); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected %v, got %v", ErrNotEncrypted, err)
+	}
+	if _, err := NewPagerWithOptions(createTempDB(t), Options{Key: make([]byte, 10)}); err == nil {
+		t.Errorf("expected a key of 10 bytes to be rejected")
+	}
+}
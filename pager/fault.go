@@ -0,0 +1,92 @@
+package pager
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrInjectedFault is returned by the writes and syncs that Faults fails.
+var ErrInjectedFault = errors.New("pager: injected fault")
+
+// Faults injects failures into the writes of the pagers opened with it, for
+// tests of what a crash leaves behind. Writes are counted across all of
+// them, so that one Faults can crash a store that writes several files at
+// any of its writes. The zero value injects nothing.
+type Faults struct {
+	mu      sync.Mutex
+	writes  int
+	crashAt int
+	torn    int
+	crashed bool
+}
+
+// CrashAt makes the nth write from now the one the process dies in: only
+// its first torn bytes reach the file, and it and every write and sync
+// after it fail with ErrInjectedFault. A torn of zero fails the write
+// without writing anything. An n of zero or less cancels a crash that has
+// not happened yet.
+func (f *Faults) CrashAt(n, torn int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.crashAt = 0
+	if n > 0 {
+		f.crashAt = f.writes + n
+	}
+	f.torn = torn
+}
+
+// Writes returns the number of writes so far, failed ones included.
+func (f *Faults) Writes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes
+}
+
+// Crashed reports whether the crash set with CrashAt happened.
+func (f *Faults) Crashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
+
+// write counts a write of size bytes and returns how many of them are to
+// reach the file, and the error to fail it with, if any.
+func (f *Faults) write(size int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes++
+	if f.crashed {
+		return 0, ErrInjectedFault
+	}
+	if f.writes == f.crashAt {
+		f.crashed = true
+		return min(f.torn, size), ErrInjectedFault
+	}
+	return size, nil
+}
+
+// faultFile fails the writes and syncs of a file as its Faults say.
+type faultFile struct {
+	file
+	faults *Faults
+}
+
+func (f faultFile) WriteAt(data []byte, offset int64) (int, error) {
+	n, err := f.faults.write(len(data))
+	if err == nil {
+		return f.file.WriteAt(data, offset)
+	}
+	if n > 0 {
+		if _, werr := f.file.WriteAt(data[:n], offset); werr != nil {
+			return 0, werr
+		}
+	}
+	return n, err
+}
+
+func (f faultFile) Sync() error {
+	if f.faults.Crashed() {
+		return ErrInjectedFault
+	}
+	return f.file.Sync()
+}
@@ -0,0 +1,78 @@
+package pager
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name string
+		torn int
+		file []byte
+	}{
+		{"failed write", 0, []byte("first")},
+		{"torn write", 3, []byte("firstsec")},
+		{"whole write", 100, []byte("firstsecond")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := createTempDB(t)
+			faults := &Faults{}
+			p, err := NewPagerWithOptions(dbPath, Options{Faults: faults})
+			if err != nil {
+				t.Fatalf("failed to create pager: %v", err)
+			}
+
+			faults.CrashAt(2, tt.torn)
+			if err := p.WriteAtOffset(0, []byte("first")); err != nil {
+				t.Fatalf("expected the first write to succeed, got %v", err)
+			}
+			if faults.Crashed() {
+				t.Fatalf("expected no crash yet")
+			}
+			if err := p.WriteAtOffset(5, []byte("second")); !errors.Is(err, ErrInjectedFault) {
+				t.Fatalf("expected the second write to fail, got %v", err)
+			}
+			if err := p.WriteAtOffset(0, []byte("third")); !errors.Is(err, ErrInjectedFault) {
+				t.Errorf("expected writes after the crash to fail, got %v", err)
+			}
+			if err := p.Sync(); !errors.Is(err, ErrInjectedFault) {
+				t.Errorf("expected syncs after the crash to fail, got %v", err)
+			}
+			if !faults.Crashed() || faults.Writes() != 3 {
+				t.Errorf("expected a crash after 3 writes, got %v after %d", faults.Crashed(), faults.Writes())
+			}
+
+			data, err := os.ReadFile(dbPath)
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			if !bytes.Equal(data, tt.file) {
+				t.Errorf("expected the file to hold %q, got %q", tt.file, data)
+			}
+		})
+	}
+}
+
+func TestFaultsCancel(t *testing.T) {
+	faults := &Faults{}
+	p, err := NewPagerWithOptions("", Options{InMemory: true, Faults: faults})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer p.Close()
+
+	faults.CrashAt(1, 0)
+	faults.CrashAt(0, 0)
+	for i := range 3 {
+		if err := p.WriteAtOffset(uint64(i), []byte{byte(i)}); err != nil {
+			t.Fatalf("expected no fault, got %v", err)
+		}
+	}
+	if faults.Crashed() {
+		t.Errorf("expected no crash")
+	}
+}
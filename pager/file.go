@@ -0,0 +1,110 @@
+package pager
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// file is what a Pager keeps its pages in: a file on disk, or memory for
+// a pager opened with InMemory. It is read and written at offsets, so that
+// concurrent reads and writes need no lock around a shared position.
+type file interface {
+	io.ReaderAt
+	io.WriterAt
+	size() (int64, error)
+	Sync() error
+	Close() error
+}
+
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) size() (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+var errNegativeOffset = errors.New("pager: negative file offset")
+
+// memFile holds the bytes of a file in memory, one slice of PageSize
+// bytes per page that was written to. Pages never written read as zeros,
+// as the holes of a sparse file do.
+type memFile struct {
+	mu     sync.Mutex
+	pages  map[PageID][]byte
+	length int64
+}
+
+func newMemFile() *memFile {
+	return &memFile{pages: make(map[PageID][]byte)}
+}
+
+func (f *memFile) ReadAt(data []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	if offset >= f.length {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(data)), f.length-offset))
+	for done := 0; done < n; {
+		pos := offset + int64(done)
+		id, start := PageID(pos/PageSize), int(pos%PageSize)
+		end := min(PageSize, start+n-done)
+		if page, ok := f.pages[id]; ok {
+			copy(data[done:], page[start:end])
+		} else {
+			clear(data[done : done+end-start])
+		}
+		done += end - start
+	}
+	if n < len(data) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(data []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	for done := 0; done < len(data); {
+		pos := offset + int64(done)
+		id, start := PageID(pos/PageSize), int(pos%PageSize)
+		page, ok := f.pages[id]
+		if !ok {
+			page = make([]byte, PageSize)
+			f.pages[id] = page
+		}
+		done += copy(page[start:], data[done:])
+	}
+	f.length = max(f.length, offset+int64(len(data)))
+	return len(data), nil
+}
+
+func (f *memFile) size() (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.length, nil
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pages = nil
+	return nil
+}
@@ -0,0 +1,122 @@
+package pager
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+)
+
+// runFlusher writes dirty pages in the background every SyncPeriod, and
+// whenever writes take the number of dirty pages to the high-water mark,
+// until the pager is closed.
+func (p *Pager) runFlusher() {
+	defer p.wg.Done()
+
+	ticker := p.clock.NewTicker(SyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-p.wake:
+		case <-p.done:
+			return
+		}
+		if err := p.flushInBackground(); err != nil {
+			p.logger.Error("pager: background flush failed", "err", err)
+		}
+	}
+}
+
+// flushInBackground sweeps the dirty pages in order of page ID, writing
+// them to the file a batch at a time, and then syncs it. Pages written to
+// behind the sweep wait for the next one.
+func (p *Pager) flushInBackground() error {
+	start := p.clock.Now()
+	written := 0
+	for from := PageID(0); ; {
+		n, next, err := p.flushBatch(from)
+		if errors.Is(err, ErrWriteDeferred) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		written += n
+		from = next
+	}
+
+	err := p.sync()
+	if written > 0 {
+		p.hooks.Flush(written, p.clock.Now().Sub(start), err)
+	}
+	return err
+}
+
+// flushCopy is a copy of a dirty page that the background writer writes
+// while the cache lock is free.
+type flushCopy struct {
+	entry   *cacheEntry
+	page    Page
+	version uint64
+}
+
+// flushBatch runs the write barrier and copies a batch of the dirty pages
+// from page ID from on under the cache lock, and writes the copies without
+// it. It returns the number of pages it wrote and the ID to go on from. A
+// page written to again while its copy was in flight stays dirty.
+func (p *Pager) flushBatch(from PageID) (int, PageID, error) {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	var entries []*cacheEntry
+	for _, elem := range p.cache {
+		if entry := elem.Value.(*cacheEntry); entry.isDirty && !entry.flushing && entry.page.ID >= from {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		p.mu.Unlock()
+		return 0, from, nil
+	}
+	if err := p.runBarrier(); err != nil {
+		p.mu.Unlock()
+		return 0, from, err
+	}
+	slices.SortFunc(entries, func(a, b *cacheEntry) int { return cmp.Compare(a.page.ID, b.page.ID) })
+	copies := make([]flushCopy, min(p.batchSize, len(entries)))
+	pages := make([]*Page, len(copies))
+	for i := range copies {
+		entries[i].flushing = true
+		copies[i] = flushCopy{entry: entries[i], page: *entries[i].page, version: entries[i].version}
+		pages[i] = &copies[i].page
+	}
+	p.mu.Unlock()
+
+	var err error
+	written := map[PageID]bool{}
+	p.writePages(pages, PriorityBackground, func(run []*Page, runErr error) {
+		if runErr != nil {
+			if err == nil {
+				err = runErr
+			}
+			return
+		}
+		for _, page := range run {
+			written[page.ID] = true
+		}
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range copies {
+		c.entry.flushing = false
+		if written[c.page.ID] && c.entry.version == c.version {
+			p.markClean(c.entry)
+		}
+	}
+	return len(written), copies[len(copies)-1].page.ID + 1, err
+}
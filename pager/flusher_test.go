@@ -0,0 +1,130 @@
+package pager
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/trace"
+)
+
+func TestBackgroundFlush(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	var mu sync.Mutex
+	var writes []PageID
+	var pager *Pager
+	flushed := make(chan int, 1)
+	hooks := &trace.Hooks{
+		OnPageWrite: func(id uint32) {
+			mu.Lock()
+			writes = append(writes, PageID(id))
+			first := len(writes) == 1
+			mu.Unlock()
+			if !first {
+				return
+			}
+
+			// The first batch is written from copies without the cache
+			// lock, so that the cache can be read and written meanwhile.
+			if _, err := pager.ReadPage(9); err != nil {
+				t.Errorf("failed to read page during the flush: %v", err)
+			}
+			page := &Page{ID: 1}
+			copy(page.Data[:], "again")
+			if err := pager.WritePage(page); err != nil {
+				t.Errorf("failed to write page during the flush: %v", err)
+			}
+		},
+		OnFlush: func(pages int, took time.Duration, err error) { flushed <- pages },
+	}
+	dbPath := createTempDB(t)
+	pager, err := NewPagerWithOptions(dbPath, Options{Clock: clk, FlushBatch: 4, Trace: hooks})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	for range 10 {
+		page, err := pager.NewPage()
+		if err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+		copy(page.Data[:], "first")
+	}
+
+	barriers := 0
+	pager.SetWriteBarrier(func() error {
+		barriers++
+		return nil
+	})
+
+	clk.BlockUntil(1)
+	clk.Advance(SyncPeriod)
+	if pages := <-flushed; pages != 10 {
+		t.Errorf("expected 10 pages to be written, got %d", pages)
+	}
+	if barriers != 3 {
+		t.Errorf("expected a barrier before each of 3 batches, got %d", barriers)
+	}
+	mu.Lock()
+	if expected := []PageID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !slices.Equal(writes, expected) {
+		t.Errorf("expected the pages to be written in order, got %v", writes)
+	}
+	mu.Unlock()
+
+	page1 := func() []byte {
+		data, err := os.ReadFile(dbPath)
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		return data[PageSize : PageSize+5]
+	}
+	if !pager.HasDirtyPages() || !bytes.Equal(page1(), []byte("first")) {
+		t.Fatalf("expected page 1, written during the flush, to stay dirty")
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if !bytes.Equal(page1(), []byte("again")) {
+		t.Errorf("expected the flush to write the latest page 1, got %q", page1())
+	}
+}
+
+func TestDirtyHighWater(t *testing.T) {
+	flushed := make(chan int, 1)
+	hooks := &trace.Hooks{OnFlush: func(pages int, took time.Duration, err error) { flushed <- pages }}
+	// The clock never moves, so only the high-water mark wakes the writer.
+	pager, err := NewPagerWithOptions(createTempDB(t), Options{
+		Clock:          clock.NewVirtual(time.Now()),
+		DirtyHighWater: 3,
+		Trace:          hooks,
+	})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	for range 2 {
+		if _, err := pager.NewPage(); err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+	}
+	select {
+	case <-flushed:
+		t.Fatalf("expected no flush below the high-water mark")
+	default:
+	}
+	if _, err := pager.NewPage(); err != nil {
+		t.Fatalf("failed to create page: %v", err)
+	}
+	if pages := <-flushed; pages != 3 {
+		t.Errorf("expected 3 pages to be written, got %d", pages)
+	}
+	if pager.HasDirtyPages() {
+		t.Errorf("expected no dirty pages after the flush")
+	}
+}
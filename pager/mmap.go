@@ -0,0 +1,83 @@
+package pager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errMmapUnsupported is returned by mmap where the platform has none, for
+// the pager to read with read calls instead.
+var errMmapUnsupported = errors.New("pager: mmap not supported")
+
+// minMapSize is the smallest length a file is mapped with, so that a small
+// file that grows is not remapped for every page added to it.
+const minMapSize = 256 * PageSize
+
+// mapping is a read-only memory mapping of a file. It may reach past the
+// end of the file, so that the file can grow into it.
+type mapping struct {
+	f    *os.File
+	data []byte
+	// size is the size of the file when last looked at. Touching the
+	// mapping past the end of the file faults.
+	size int64
+}
+
+func newMapping(f *os.File) (*mapping, error) {
+	m := &mapping{f: f}
+	if err := m.grow(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// grow looks at the size of the file again, and maps it anew with twice
+// its size if it outgrew the mapping.
+func (m *mapping) grow() error {
+	stat, err := m.f.Stat()
+	if err != nil {
+		return fmt.Errorf("pager: failed to stat file: %w", err)
+	}
+	m.size = stat.Size()
+	if m.size <= int64(len(m.data)) {
+		return nil
+	}
+
+	if err := m.unmap(); err != nil {
+		return err
+	}
+	data, err := mmap(m.f, int(max(2*m.size, minMapSize)))
+	if err != nil {
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+// read returns the n bytes of the file at offset, which alias the
+// mapping, or nil if the file ends before them.
+func (m *mapping) read(offset int64, n int) ([]byte, error) {
+	end := offset + int64(n)
+	if end > m.size {
+		if err := m.grow(); err != nil {
+			return nil, err
+		}
+		if end > m.size {
+			return nil, nil
+		}
+	}
+	return m.data[offset:end], nil
+}
+
+func (m *mapping) unmap() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	if err := munmap(data); err != nil {
+		return fmt.Errorf("pager: failed to unmap file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package pager
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMmap(t *testing.T) {
+	dbPath := createTempDB(t)
+	pager, err := NewPagerWithOptions(dbPath, Options{CacheSize: 4, Mmap: true})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	if pager.mapping == nil {
+		pager.Close()
+		t.Skip("no mmap on this platform")
+	}
+
+	content := func(id PageID) []byte { return fmt.Appendf(nil, "page %d", id) }
+	for range 300 {
+		page, err := pager.NewPage()
+		if err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+		copy(page.Data[:], content(page.ID))
+		if err := pager.WritePage(page); err != nil {
+			t.Fatalf("failed to write page: %v", err)
+		}
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	read := func(id PageID) *Page {
+		t.Helper()
+		page, err := pager.ReadPage(id)
+		if err != nil {
+			t.Fatalf("failed to read page %d: %v", id, err)
+		}
+		return page
+	}
+	for id := range PageID(300) {
+		if page := read(id); !bytes.HasPrefix(page.Data[:], content(id)) {
+			t.Fatalf("expected page %d to hold %q, got %q", id, content(id), page.Data[:10])
+		}
+	}
+	if len(pager.mapping.data) < 300*PageSize {
+		t.Errorf("expected the mapping to grow with the file, got %d bytes", len(pager.mapping.data))
+	}
+	if _, found := pager.cache[0]; found || len(pager.cache) > 4 {
+		t.Errorf("expected pages read from the mapping to stay out of the cache, got %d pages", len(pager.cache))
+	}
+
+	page := read(0)
+	copy(page.Data[:], "changed")
+	if got := read(0); !bytes.HasPrefix(got.Data[:], content(0)) {
+		t.Errorf("expected a change to a page read from the mapping to stay in its copy, got %q", got.Data[:10])
+	}
+	if err := pager.WritePage(page); err != nil {
+		t.Fatalf("failed to write page: %v", err)
+	}
+	if got := read(0); !bytes.HasPrefix(got.Data[:], []byte("changed")) {
+		t.Errorf("expected the written page from the cache, got %q", got.Data[:10])
+	}
+	if data, _ := pager.mapping.read(0, 7); !bytes.Equal(data, content(0)[:7]) {
+		t.Errorf("expected the file to hold the old page until a flush, got %q", data)
+	}
+	if err := pager.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	pager, err = NewPagerWithOptions(dbPath, Options{ReadOnly: true, Mmap: true})
+	if err != nil {
+		t.Fatalf("failed to open pager read-only: %v", err)
+	}
+	defer pager.Close()
+	if page := read(0); !bytes.HasPrefix(page.Data[:], []byte("changed")) {
+		t.Errorf("expected the change after reopening, got %q", page.Data[:10])
+	}
+	if page := read(299); !bytes.HasPrefix(page.Data[:], content(299)) {
+		t.Errorf("expected page 299 to hold %q, got %q", content(299), page.Data[:10])
+	}
+	if _, err := pager.ReadPage(300); err == nil {
+		t.Errorf("expected reading past the end to fail")
+	}
+}
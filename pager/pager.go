@@ -2,74 +2,277 @@
 package pager
 
 import (
+	"cmp"
 	"container/list"
-	"encoding/binary"
+	"crypto/cipher"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/metrics"
+	"github.com/rizalta/toydb/trace"
 )
 
 const (
-	PageSize     = 4096
+	PageSize = 4096
+	// MaxCacheSize is the number of pages cached by a pager whose options
+	// leave CacheSize zero.
 	MaxCacheSize = 128
 	SyncPeriod   = 10 * time.Second
+	// DefaultFlushBatch is the number of dirty pages the background writer
+	// of a pager whose options leave FlushBatch zero writes at a time.
+	DefaultFlushBatch = 16
 )
 
-var ErrPagerClosed = errors.New("pager: operations on a closed pager")
+var (
+	ErrPagerClosed = errors.New("pager: operations on a closed pager")
+	// ErrWriteDeferred is returned by a write barrier to keep dirty pages
+	// out of the file for now without failing the write that evicts them.
+	ErrWriteDeferred = errors.New("pager: page writes deferred")
+	ErrReadOnly      = errors.New("pager: write to a read-only pager")
+)
 
 type PageID uint32
 
+// EvictionPolicy picks the cached page a pager evicts to make room.
+type EvictionPolicy uint8
+
+const (
+	// EvictLRU evicts the least recently used page.
+	EvictLRU EvictionPolicy = iota
+	// EvictClock sweeps the cached pages like a clock hand, evicting the
+	// first one not used since the hand last passed it. Unlike EvictLRU it
+	// keeps a page that is used often even when many others were used
+	// once since.
+	EvictClock
+)
+
 type Page struct {
 	ID   PageID
 	Data [PageSize]byte
 }
 
 type Pager struct {
-	file       *os.File
-	numPages   uint32
-	freeListID PageID
-	cache      map[PageID]*list.Element
-	lruList    *list.List
-	mu         sync.Mutex
-	isClosed   bool
-	done       chan struct{}
-	wg         sync.WaitGroup
+	file      file
+	numPages  uint32
+	cache     map[PageID]*list.Element
+	lruList   *list.List
+	mu        sync.Mutex
+	isClosed  bool
+	done      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+	barrier   func() error
+	scheduler atomic.Pointer[Scheduler]
+	clock     clock.Clock
+	logger    trace.Logger
+	hooks     *trace.Hooks
+	metrics   *metrics.Registry
+	cacheSize int
+	eviction  EvictionPolicy
+	readOnly  bool
+	// mapping, if set, is what pages that are not cached are read from.
+	mapping *mapping
+	// dir, if set, is where the compressed or encrypted pages are in the
+	// file.
+	dir *directory
+	// aead, if set, encrypts the pages of the file.
+	aead cipher.AEAD
+
+	// flushMu keeps Flush from writing pages while the background writer
+	// has a batch of copies of them in flight.
+	flushMu   sync.Mutex
+	wake      chan struct{}
+	dirty     int
+	batchSize int
+	highWater int
+}
+
+// Options configures a Pager.
+type Options struct {
+	// Clock times the periodic sync. Nil means the real clock.
+	Clock clock.Clock
+	// InMemory keeps the pages in memory instead of a file, which is not
+	// created. They are gone once the pager is closed.
+	InMemory bool
+	// CacheSize is the number of pages kept in memory. Zero or less means
+	// MaxCacheSize.
+	CacheSize int
+	// ReadOnly opens the file for reading only. Writes fail with
+	// ErrReadOnly and the file must exist.
+	ReadOnly bool
+	// Logger receives the errors of writes nobody waits for, such as the
+	// periodic sync. Nil means slog.Default().
+	Logger trace.Logger
+	// Trace is called as pages are read from and written to the file, and
+	// as flushes write them.
+	Trace *trace.Hooks
+	// Metrics counts cache hits and misses, page reads and writes, and
+	// syncs of the file. Nil counts in a registry of the pager's own.
+	Metrics *metrics.Registry
+	// Faults, if set, fails writes to the file as it is told to.
+	Faults *Faults
+	// FlushBatch is the number of dirty pages the background writer copies
+	// and writes at a time, in order of page ID, letting reads and writes
+	// of the cache go on in between. Zero or less means DefaultFlushBatch.
+	FlushBatch int
+	// Eviction is how the cache picks the page to evict.
+	Eviction EvictionPolicy
+	// Mmap reads pages from a memory mapping of the file instead of with
+	// read calls, and caches only those written to, leaving the rest to the
+	// page cache of the system. ReadPage returns a copy of a page that is
+	// not cached, which becomes cached once written. Without InMemory it
+	// falls back to read calls where the platform has no mmap.
+	Mmap bool
+	// Compress stores pages compressed with DEFLATE as they are written to
+	// a new file, in extents a page directory in the file keeps track of.
+	// Each sync writes the directory and commits it, so that a crash
+	// leaves the pages as of the last sync. Offset reads and writes fail
+	// with ErrCompressed. An existing file keeps the format it was created
+	// with, whatever the option.
+	Compress bool
+	// Key, if set, encrypts pages with AES-GCM as they are written to a new
+	// file, stored in extents like those of Compress, with or without it,
+	// so offset reads and writes fail with ErrCompressed as well. It must
+	// be 16, 24 or 32 bytes. The header of the file keeps a key
+	// check value, so an encrypted file opened with another key fails with
+	// ErrWrongKey, and without one with ErrKeyRequired. A key for an
+	// existing file that is not encrypted fails with ErrNotEncrypted. A
+	// pager in memory ignores it.
+	Key []byte
+	// DirtyHighWater, if above zero, wakes the background writer before
+	// its next period once that many cached pages are dirty, so that
+	// evictions find clean pages to drop rather than write dirty ones.
+	DirtyHighWater int
 }
 
 type cacheEntry struct {
 	page    *Page
 	isDirty bool
+	// version counts the writes of the page, so that the background writer
+	// can tell whether the copy it wrote is still the latest.
+	version uint64
+	// flushing is set while the background writer writes a copy of the
+	// page, which keeps it from being evicted.
+	flushing bool
+	// referenced is set as the page is used under EvictClock, and cleared
+	// as the hand passes it.
+	referenced bool
 }
 
 func NewPager(filename string) (*Pager, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o644)
-	if err != nil {
-		return nil, fmt.Errorf("pager: failed opening file: %w", err)
+	return NewPagerWithOptions(filename, Options{})
+}
+
+// NewPagerWithOptions opens a pager over filename, creating the file if
+// there is none. The name is ignored with opts.InMemory.
+func NewPagerWithOptions(filename string, opts Options) (*Pager, error) {
+	var f file = newMemFile()
+	var m *mapping
+	if !opts.InMemory {
+		flag := os.O_RDWR | os.O_CREATE
+		if opts.ReadOnly {
+			flag = os.O_RDONLY
+		}
+		osf, err := os.OpenFile(filename, flag, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("pager: failed opening file: %w", err)
+		}
+		f = osFile{osf}
+		if opts.Mmap {
+			if m, err = newMapping(osf); errors.Is(err, errMmapUnsupported) {
+				m = nil
+			} else if err != nil {
+				osf.Close()
+				return nil, err
+			}
+		}
+	}
+	if opts.Faults != nil {
+		f = faultFile{f, opts.Faults}
 	}
-	stat, err := file.Stat()
+	// fail undoes the opening of the file and its mapping.
+	fail := func(err error) (*Pager, error) {
+		if m != nil {
+			m.unmap()
+		}
+		f.Close()
+		return nil, err
+	}
+	size, err := f.size()
 	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("pager: failed to stat file: %w", err)
+		return fail(fmt.Errorf("pager: failed to stat file: %w", err))
 	}
 
-	numPages := uint32(stat.Size() / PageSize)
+	var aead cipher.AEAD
+	var create *header
+	if !opts.InMemory && opts.Key != nil {
+		if aead, err = newAEAD(opts.Key); err != nil {
+			return fail(err)
+		}
+		create = &header{flags: flagEncrypted, check: keyCheck(aead)}
+	}
+	if opts.Compress && !opts.InMemory {
+		if create == nil {
+			create = &header{}
+		}
+		create.flags |= flagCompressed
+	}
+	if opts.ReadOnly {
+		create = nil
+	}
+	dir, err := openDirectory(f, size, create)
+	if err == nil && dir != nil {
+		err = checkKey(dir, aead)
+	} else if err == nil && aead != nil && size > 0 {
+		err = ErrNotEncrypted
+	}
+	if err != nil {
+		return fail(err)
+	}
+	numPages := uint32(size / PageSize)
+	if dir != nil {
+		numPages = uint32(len(dir.pages))
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = MaxCacheSize
+	}
+	if opts.FlushBatch <= 0 {
+		opts.FlushBatch = DefaultFlushBatch
+	}
 
 	p := &Pager{
-		file:       file,
-		numPages:   numPages,
-		cache:      make(map[PageID]*list.Element),
-		freeListID: 0,
-		lruList:    list.New(),
-		mu:         sync.Mutex{},
-		isClosed:   false,
-		done:       make(chan struct{}),
+		file:      f,
+		numPages:  numPages,
+		cache:     make(map[PageID]*list.Element),
+		lruList:   list.New(),
+		mu:        sync.Mutex{},
+		isClosed:  false,
+		done:      make(chan struct{}),
+		clock:     clock.OrReal(opts.Clock),
+		logger:    trace.OrDefault(opts.Logger),
+		hooks:     opts.Trace,
+		metrics:   metrics.OrNew(opts.Metrics),
+		cacheSize: opts.CacheSize,
+		eviction:  opts.Eviction,
+		readOnly:  opts.ReadOnly,
+		mapping:   m,
+		dir:       dir,
+		aead:      aead,
+
+		wake:      make(chan struct{}, 1),
+		batchSize: opts.FlushBatch,
+		highWater: opts.DirtyHighWater,
 	}
 
 	p.wg.Add(1)
-	go p.startPeriodicSync()
+	go p.runFlusher()
 
 	return p, nil
 }
@@ -77,54 +280,201 @@ func NewPager(filename string) (*Pager, error) {
 func (p *Pager) readFromDisk(pageID PageID) (*Page, error) {
 	page := &Page{ID: pageID}
 	offset := int64(pageID) * PageSize
+	p.scheduler.Load().Acquire(PriorityForeground, PageSize)
 
-	_, err := p.file.Seek(offset, 0)
-	if err != nil {
-		return nil, fmt.Errorf("pager: failed to seek to page: %w", err)
+	if p.dir != nil {
+		if err := p.readExtent(page); err != nil {
+			return nil, err
+		}
+		p.metrics.PageReads.Inc()
+		p.hooks.PageRead(uint32(pageID))
+		return page, nil
+	}
+	if p.mapping != nil {
+		data, err := p.mapping.read(offset, PageSize)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			copy(page.Data[:], data)
+			p.metrics.PageReads.Inc()
+			p.hooks.PageRead(uint32(pageID))
+			return page, nil
+		}
 	}
 
-	_, err = p.file.Read(page.Data[:])
-	return page, err
+	// A page the file ends within reads as zeros past its end.
+	if n, err := p.file.ReadAt(page.Data[:], offset); err != nil && (n == 0 || !errors.Is(err, io.EOF)) {
+		return nil, err
+	}
+	p.metrics.PageReads.Inc()
+	p.hooks.PageRead(uint32(pageID))
+	return page, nil
 }
 
 func (p *Pager) writeToDisk(page *Page) error {
-	offset := int64(page.ID) * PageSize
-	_, err := p.file.Seek(offset, 0)
-	if err != nil {
-		return fmt.Errorf("pager: failed to seek to page: %w", err)
+	return p.writeRun([]*Page{page}, PriorityForeground)
+}
+
+// writeRun writes pages of consecutive IDs to the file with one write.
+func (p *Pager) writeRun(run []*Page, prio Priority) error {
+	if p.dir != nil {
+		return p.writeExtents(run, prio)
 	}
+	data := run[0].Data[:]
+	if len(run) > 1 {
+		data = make([]byte, 0, len(run)*PageSize)
+		for _, page := range run {
+			data = append(data, page.Data[:]...)
+		}
+	}
+	p.scheduler.Load().Acquire(prio, len(data))
 
-	n, err := p.file.Write(page.Data[:])
+	n, err := p.file.WriteAt(data, int64(run[0].ID)*PageSize)
 	if err != nil {
 		return fmt.Errorf("pager: failed to write page: %w", err)
 	}
-	if n != PageSize {
-		return fmt.Errorf("pager: partial write: wrote %d bytes, expected %d bytes", n, PageSize)
+	if n != len(data) {
+		return fmt.Errorf("pager: partial write: wrote %d bytes, expected %d bytes", n, len(data))
 	}
 
+	for _, page := range run {
+		p.metrics.PageWrites.Inc()
+		p.hooks.PageWrite(uint32(page.ID))
+	}
 	return nil
 }
 
-func (p *Pager) evict() error {
-	elem := p.lruList.Back()
-	if elem == nil {
-		return nil
+// writePages writes pages, sorted by ID, to the file, each run of
+// consecutive IDs with one write, and calls done with each run and the
+// error writing it, if any.
+func (p *Pager) writePages(pages []*Page, prio Priority, done func(run []*Page, err error)) {
+	for start := 0; start < len(pages); {
+		end := start + 1
+		for end < len(pages) && pages[end].ID == pages[end-1].ID+1 {
+			end++
+		}
+		done(pages[start:end], p.writeRun(pages[start:end], prio))
+		start = end
+	}
+}
+
+func (p *Pager) markDirty(entry *cacheEntry) {
+	if !entry.isDirty {
+		entry.isDirty = true
+		p.dirty++
 	}
+	entry.version++
+}
 
-	entry := elem.Value.(*cacheEntry)
+func (p *Pager) markClean(entry *cacheEntry) {
 	if entry.isDirty {
-		if err := p.writeToDisk(entry.page); err != nil {
-			return err
+		entry.isDirty = false
+		p.dirty--
+	}
+}
+
+// touch records a use of a cached page, unless it is by a scan, which
+// leaves the page as likely to be evicted as it was.
+func (p *Pager) touch(elem *list.Element, scan bool) {
+	if scan {
+		return
+	}
+	if p.eviction == EvictClock {
+		elem.Value.(*cacheEntry).referenced = true
+	} else {
+		p.lruList.MoveToFront(elem)
+	}
+}
+
+// insert caches page. A page read by a scan goes to the back of the list,
+// to be evicted first.
+func (p *Pager) insert(entry *cacheEntry, scan bool) *list.Element {
+	var elem *list.Element
+	if scan {
+		elem = p.lruList.PushBack(entry)
+	} else {
+		entry.referenced = p.eviction == EvictClock
+		elem = p.lruList.PushFront(entry)
+	}
+	p.cache[entry.page.ID] = elem
+	return elem
+}
+
+// victim returns the page to evict next, passing over those the background
+// writer is writing, or nil if there is none. The back of the list is the
+// least recently used page, or under EvictClock the hand, which moves the
+// referenced pages it passes to the front.
+func (p *Pager) victim() *list.Element {
+	// The hand clears every reference it passes, so a second pass finds a
+	// page unless all are being written.
+	for range 2 {
+		for elem := p.lruList.Back(); elem != nil; {
+			prev := elem.Prev()
+			switch entry := elem.Value.(*cacheEntry); {
+			case entry.flushing:
+			case entry.referenced:
+				entry.referenced = false
+				p.lruList.MoveToFront(elem)
+			default:
+				return elem
+			}
+			elem = prev
 		}
 	}
+	return nil
+}
+
+// evict removes pages until no more than size are cached, writing dirty
+// ones to the file first.
+func (p *Pager) evict(size int) error {
+	for p.lruList.Len() > size {
+		elem := p.victim()
+		if elem == nil {
+			return nil
+		}
+		entry := elem.Value.(*cacheEntry)
+		if entry.isDirty {
+			err := p.runBarrier()
+			if errors.Is(err, ErrWriteDeferred) {
+				// Evict the next clean page instead, or let the cache grow
+				// if every page is dirty.
+				for elem = elem.Prev(); elem != nil; elem = elem.Prev() {
+					if entry = elem.Value.(*cacheEntry); !entry.isDirty && !entry.flushing {
+						break
+					}
+				}
+				if elem == nil {
+					return nil
+				}
+			} else if err != nil {
+				return err
+			} else if err := p.writeToDisk(entry.page); err != nil {
+				return err
+			} else {
+				p.markClean(entry)
+			}
+		}
 
-	p.lruList.Remove(elem)
-	delete(p.cache, entry.page.ID)
+		p.lruList.Remove(elem)
+		delete(p.cache, entry.page.ID)
+	}
 
 	return nil
 }
 
 func (p *Pager) ReadPage(pageID PageID) (*Page, error) {
+	return p.readPage(pageID, false)
+}
+
+// ScanPage reads a page like ReadPage for a scan, which is not going to
+// need it again soon. The page is evicted before those read otherwise,
+// and if it was cached, it is not made any less likely to be evicted.
+func (p *Pager) ScanPage(pageID PageID) (*Page, error) {
+	return p.readPage(pageID, true)
+}
+
+func (p *Pager) readPage(pageID PageID, scan bool) (*Page, error) {
 	if p.isClosed {
 		return nil, ErrPagerClosed
 	}
@@ -133,9 +483,11 @@ func (p *Pager) ReadPage(pageID PageID) (*Page, error) {
 	defer p.mu.Unlock()
 
 	if elem, found := p.cache[pageID]; found {
-		p.lruList.MoveToFront(elem)
+		p.touch(elem, scan)
+		p.metrics.CacheHits.Inc()
 		return elem.Value.(*cacheEntry).page, nil
 	}
+	p.metrics.CacheMisses.Inc()
 
 	if uint32(pageID) >= p.numPages {
 		return nil, fmt.Errorf("pager: page %d does not exist", pageID)
@@ -145,16 +497,18 @@ func (p *Pager) ReadPage(pageID PageID) (*Page, error) {
 	if err != nil {
 		return nil, fmt.Errorf("pager: failed to read page: %w", err)
 	}
+	if p.mapping != nil {
+		return page, nil
+	}
 
-	entry := &cacheEntry{page: page, isDirty: false}
-	elem := p.lruList.PushFront(entry)
-	p.cache[page.ID] = elem
-
-	if p.lruList.Len() > MaxCacheSize {
-		if err := p.evict(); err != nil {
+	// Room is made first, so that a page read by a scan is not the one
+	// evicted.
+	if p.lruList.Len() >= p.cacheSize {
+		if err := p.evict(p.cacheSize - 1); err != nil {
 			return nil, err
 		}
 	}
+	p.insert(&cacheEntry{page: page}, scan)
 
 	return page, nil
 }
@@ -163,76 +517,70 @@ func (p *Pager) WritePage(page *Page) error {
 	if p.isClosed {
 		return ErrPagerClosed
 	}
+	if p.readOnly {
+		return ErrReadOnly
+	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	elem, found := p.cache[page.ID]
-	var entry *cacheEntry
 	if !found {
-		entry = &cacheEntry{
-			page:    page,
-			isDirty: true,
-		}
-		elem = p.lruList.PushFront(entry)
-		p.cache[page.ID] = elem
+		elem = p.insert(&cacheEntry{page: page}, false)
 	} else {
 		elem.Value.(*cacheEntry).page = page
-		p.lruList.MoveToFront(elem)
+		p.touch(elem, false)
+	}
+	p.markDirty(elem.Value.(*cacheEntry))
+	if p.highWater > 0 && p.dirty >= p.highWater {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
 	}
-	elem.Value.(*cacheEntry).isDirty = true
 
-	if p.lruList.Len() > MaxCacheSize {
-		if err := p.evict(); err != nil {
+	if p.lruList.Len() > p.cacheSize {
+		if err := p.evict(p.cacheSize); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// NewPage appends a zeroed page to the file. Pages that were freed are
+// reused through an Allocator instead.
 func (p *Pager) NewPage() (*Page, error) {
 	if p.isClosed {
 		return nil, ErrPagerClosed
 	}
-
-	if p.freeListID != 0 {
-		page, err := p.ReadPage(p.freeListID)
-		if err != nil {
-			return nil, fmt.Errorf("pager: failed to read free list page: %w", err)
-		}
-
-		nextID := PageID(binary.LittleEndian.Uint32(page.Data[:]))
-		p.freeListID = nextID
-
-		for i := range page.Data {
-			page.Data[i] = 0
-		}
-
-		return page, nil
+	if p.readOnly {
+		return nil, ErrReadOnly
 	}
-	pageID := PageID(p.numPages)
-	page := &Page{ID: pageID}
 
-	for i := range page.Data {
-		page.Data[i] = 0
-	}
+	p.mu.Lock()
+	page := &Page{ID: PageID(p.numPages)}
+	p.numPages++
+	p.mu.Unlock()
 
-	err := p.WritePage(page)
-	if err != nil {
+	if err := p.WritePage(page); err != nil {
 		return nil, err
 	}
-	p.numPages++
-
 	return page, nil
 }
 
 func (p *Pager) WriteAtOffset(offset uint64, data []byte) error {
-	_, err := p.file.Seek(int64(offset), 0)
-	if err != nil {
-		return fmt.Errorf("pager: failed to seek to offset: %w", err)
-	}
+	p.scheduler.Load().Acquire(PriorityForeground, len(data))
+	return p.writeAtOffset(offset, data)
+}
 
-	n, err := p.file.Write(data)
+func (p *Pager) writeAtOffset(offset uint64, data []byte) error {
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	if p.dir != nil {
+		return ErrCompressed
+	}
+	n, err := p.file.WriteAt(data, int64(offset))
 	if err != nil {
 		return fmt.Errorf("pager: failed to write at offset: %w", err)
 	}
@@ -244,27 +592,33 @@ func (p *Pager) WriteAtOffset(offset uint64, data []byte) error {
 }
 
 func (p *Pager) ReadAtOffset(offset uint64, size int) ([]byte, error) {
-	_, err := p.file.Seek(int64(offset), 0)
-	if err != nil {
-		return nil, fmt.Errorf("pager: failed to seek to offset: %w", err)
-	}
+	p.scheduler.Load().Acquire(PriorityForeground, size)
+	return p.readAtOffset(offset, size)
+}
 
+func (p *Pager) readAtOffset(offset uint64, size int) ([]byte, error) {
+	if p.dir != nil {
+		return nil, ErrCompressed
+	}
 	data := make([]byte, size)
-	n, err := p.file.Read(data)
-	if err != nil {
-		return nil, fmt.Errorf("pager: failed to read at offset: %w", err)
+	n, err := p.file.ReadAt(data, int64(offset))
+	if n == size {
+		// ReadAt may return io.EOF along with the last bytes of the file.
+		return data, nil
 	}
-	if n != size {
+	if n > 0 {
 		return nil, fmt.Errorf("pager: partial read: read %d bytes, expected %d bytes", n, size)
 	}
-
-	return data, nil
+	return nil, fmt.Errorf("pager: failed to read at offset: %w", err)
 }
 
 func (p *Pager) GetNumPages() uint32 {
 	if p.isClosed {
 		return 0
 	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.numPages
 }
 
@@ -273,85 +627,136 @@ func (p *Pager) GetSize() (uint64, error) {
 		return 0, ErrPagerClosed
 	}
 
-	stat, err := p.file.Stat()
+	size, err := p.file.size()
 	if err != nil {
 		return 0, err
 	}
-	return uint64(stat.Size()), nil
+	return uint64(size), nil
 }
 
-func (p *Pager) GetFreeListID() PageID {
+// Flush writes every dirty page to the file, after the write barrier,
+// and syncs it. Unlike the background writer it holds the cache lock
+// throughout. If the barrier defers the writes, Flush writes nothing and
+// returns ErrWriteDeferred.
+func (p *Pager) Flush() error {
 	if p.isClosed {
-		return 0
+		return ErrPagerClosed
 	}
 
-	return p.freeListID
-}
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-func (p *Pager) SetFreeListID(pageID PageID) {
-	if !p.isClosed {
-		p.freeListID = pageID
+	if p.dirty > 0 {
+		if err := p.runBarrier(); errors.Is(err, ErrWriteDeferred) {
+			return ErrWriteDeferred
+		} else if err != nil {
+			return err
+		}
 	}
-}
 
-func (p *Pager) FreePage(pageID PageID) error {
-	if p.isClosed {
-		return ErrPagerClosed
+	start := p.clock.Now()
+	var pages []*Page
+	for _, elem := range p.cache {
+		if entry := elem.Value.(*cacheEntry); entry.isDirty {
+			pages = append(pages, entry.page)
+		}
 	}
+	slices.SortFunc(pages, func(a, b *Page) int { return cmp.Compare(a.ID, b.ID) })
+	written := 0
+	p.writePages(pages, PriorityForeground, func(run []*Page, err error) {
+		if err != nil {
+			p.logger.Error("pager: failed to write dirty pages", "page", run[0].ID, "pages", len(run), "err", err)
+			return
+		}
+		for _, page := range run {
+			p.markClean(p.cache[page.ID].Value.(*cacheEntry))
+		}
+		written += len(run)
+	})
 
-	page := &Page{
-		ID: pageID,
+	err := p.sync()
+	if written > 0 {
+		p.hooks.Flush(written, p.clock.Now().Sub(start), err)
 	}
+	return err
+}
 
-	binary.LittleEndian.PutUint32(page.Data[:], uint32(p.freeListID))
-
-	if err := p.WritePage(page); err != nil {
-		return err
-	}
+// Compressed reports whether the pages are stored compressed, which the
+// file decides when it already exists.
+func (p *Pager) Compressed() bool {
+	return p.dir != nil && p.dir.flags&flagCompressed != 0
+}
 
-	p.freeListID = pageID
+// Encrypted reports whether the pages are stored encrypted.
+func (p *Pager) Encrypted() bool {
+	return p.aead != nil
+}
 
-	return nil
+// HasDirtyPages reports whether any cached page was written since it was
+// last written to the file, which after a Flush means the write barrier
+// deferred the writes.
+func (p *Pager) HasDirtyPages() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dirty > 0
 }
 
-func (p *Pager) Flush() error {
+// Sync makes the writes done with WriteAtOffset durable. Unlike Flush it
+// leaves cached pages alone.
+func (p *Pager) Sync() error {
 	if p.isClosed {
 		return ErrPagerClosed
 	}
+	return p.sync()
+}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	for _, elem := range p.cache {
-		entry := elem.Value.(*cacheEntry)
-		if entry.isDirty {
-			if err := p.writeToDisk(entry.page); err != nil {
-				log.Printf("ERROR: failed to write dirty page %d: %v", entry.page.ID, err)
-				continue
-			}
-			entry.isDirty = false
-		}
+// sync syncs the file, unless it is only read, which leaves nothing to
+// sync.
+func (p *Pager) sync() error {
+	if p.readOnly {
+		return nil
+	}
+	p.metrics.Fsyncs.Inc()
+	if p.dir != nil {
+		return p.commit()
 	}
-
 	return p.file.Sync()
 }
 
-func (p *Pager) startPeriodicSync() {
-	defer p.wg.Done()
+// SetWriteBarrier sets a function that runs before dirty pages are written
+// to the file, whether on eviction, by Flush or by the periodic sync. If
+// it fails, the pages stay in the cache. A store uses it to make the
+// records an index points to durable before the index itself.
+//
+// A barrier that returns ErrWriteDeferred keeps the dirty pages cached
+// without an error: eviction picks a clean page instead, growing the cache
+// past its size if there is none, and the background writer writes
+// nothing. Flush writes nothing either, and returns ErrWriteDeferred.
+func (p *Pager) SetWriteBarrier(barrier func() error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.barrier = barrier
+}
 
-	ticker := time.NewTicker(SyncPeriod)
+// SetScheduler makes the pager take tokens from s for its file I/O. Its
+// own methods do foreground I/O; background I/O goes through a view from
+// WithPriority. Schedulers can be shared between pagers to pace their
+// combined I/O.
+func (p *Pager) SetScheduler(s *Scheduler) {
+	p.scheduler.Store(s)
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			if err := p.Flush(); err != nil {
-				log.Printf("pager: periodic sync failed: %v", err)
-			}
-		case <-p.done:
-			ticker.Stop()
-			return
-		}
+// runBarrier runs the write barrier. The cache lock must be held.
+func (p *Pager) runBarrier() error {
+	if p.barrier == nil {
+		return nil
 	}
+	if err := p.barrier(); err != nil {
+		return fmt.Errorf("pager: write barrier failed: %w", err)
+	}
+	return nil
 }
 
 func (p *Pager) Close() error {
@@ -359,13 +764,23 @@ func (p *Pager) Close() error {
 		return nil
 	}
 
-	close(p.done)
-	p.wg.Wait()
+	// A Close that fails to flush leaves the pager open, so that it can be
+	// closed again once the pages can be written.
+	p.stopOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+	})
 
 	if err := p.Flush(); err != nil {
 		return err
 	}
 
 	p.isClosed = true
+	if p.mapping != nil {
+		if err := p.mapping.unmap(); err != nil {
+			p.file.Close()
+			return err
+		}
+	}
 	return p.file.Close()
 }
@@ -2,10 +2,17 @@ package pager
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/trace"
 )
 
 func createTempDB(t *testing.T) string {
@@ -211,42 +218,431 @@ func TestPagerClose(t *testing.T) {
 	}
 }
 
-func TestPagerFreeList(t *testing.T) {
+func TestPeriodicSync(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	flushed := make(chan int, 1)
+	hooks := &trace.Hooks{OnFlush: func(pages int, took time.Duration, err error) { flushed <- pages }}
+	pager, err := NewPagerWithOptions(createTempDB(t), Options{Clock: clk, Trace: hooks})
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	if _, err := pager.NewPage(); err != nil {
+		t.Fatalf("failed to create page: %v", err)
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(SyncPeriod - time.Millisecond)
+	if !pager.HasDirtyPages() {
+		t.Fatalf("expected no sync before the period is over")
+	}
+	clk.Advance(time.Millisecond)
+	if pages := <-flushed; pages != 1 {
+		t.Errorf("expected the periodic sync to write 1 page, got %d", pages)
+	}
+	if pager.HasDirtyPages() {
+		t.Errorf("expected the periodic sync to write the page")
+	}
+}
+
+func TestWriteBarrier(t *testing.T) {
 	dbPath := createTempDB(t)
+	pager, err := NewPager(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	// onDisk reports whether a page has reached the file, bypassing the
+	// cache.
+	onDisk := func(id PageID) bool {
+		size, err := pager.file.size()
+		if err != nil {
+			t.Fatalf("failed to stat file: %v", err)
+		}
+		return size >= int64(id+1)*PageSize
+	}
+
+	errBarrier := errors.New("barrier failed")
+	var failing bool
+	var calls []bool
+	pager.SetWriteBarrier(func() error {
+		calls = append(calls, onDisk(0))
+		if failing {
+			return errBarrier
+		}
+		return nil
+	})
+
+	first, err := pager.NewPage()
+	if err != nil {
+		t.Fatalf("failed to create page: %v", err)
+	}
+	copy(first.Data[:], "first")
+	if err := pager.WritePage(first); err != nil {
+		t.Fatalf("failed to write page: %v", err)
+	}
+
+	failing = true
+	if err := pager.Flush(); !errors.Is(err, errBarrier) {
+		t.Fatalf("expected flush to fail with %v, got %v", errBarrier, err)
+	}
+	if onDisk(first.ID) {
+		t.Fatalf("expected page to stay in the cache when the barrier fails")
+	}
+
+	failing = false
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if len(calls) != 2 || calls[0] || calls[1] {
+		t.Fatalf("expected the barrier to run before each flush wrote pages, got %v", calls)
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if len(calls) != 2 {
+		t.Errorf("expected no barrier without dirty pages, got %d calls", len(calls))
+	}
+
+	for range MaxCacheSize + 1 {
+		if _, err := pager.NewPage(); err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+	}
+	if len(calls) != 3 {
+		t.Errorf("expected the barrier to run before evicting a dirty page, got %d calls", len(calls))
+	}
+}
+
+func TestCloseAfterFailedFlush(t *testing.T) {
+	pager, err := NewPager(createTempDB(t))
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+
+	errBarrier := errors.New("barrier failed")
+	failing := true
+	pager.SetWriteBarrier(func() error {
+		if failing {
+			return errBarrier
+		}
+		return nil
+	})
+	if _, err := pager.NewPage(); err != nil {
+		t.Fatalf("failed to create page: %v", err)
+	}
+
+	for range 2 {
+		if err := pager.Close(); !errors.Is(err, errBarrier) {
+			t.Fatalf("expected close to fail with %v, got %v", errBarrier, err)
+		}
+	}
+	failing = false
+	if err := pager.Close(); err != nil {
+		t.Fatalf("failed to close once the barrier passes: %v", err)
+	}
+	if pager.HasDirtyPages() {
+		t.Errorf("expected close to write the page")
+	}
+}
 
+func TestDeferredWrites(t *testing.T) {
+	dbPath := createTempDB(t)
 	pager, err := NewPager(dbPath)
 	if err != nil {
 		t.Fatalf("failed to create pager: %v", err)
 	}
+	defer pager.Close()
 
-	for range 3 {
-		_, err := pager.NewPage()
+	size := func() int64 {
+		size, err := pager.file.size()
 		if err != nil {
-			t.Fatalf("failed to create new page: %v", err)
+			t.Fatalf("failed to stat file: %v", err)
 		}
+		return size
+	}
+
+	deferring := true
+	pager.SetWriteBarrier(func() error {
+		if deferring {
+			return ErrWriteDeferred
+		}
+		return nil
+	})
+
+	for range MaxCacheSize + 10 {
+		if _, err := pager.NewPage(); err != nil {
+			t.Fatalf("failed to create page while writes are deferred: %v", err)
+		}
+	}
+	if err := pager.Flush(); !errors.Is(err, ErrWriteDeferred) {
+		t.Fatalf("expected ErrWriteDeferred from a flush while writes are deferred, got %v", err)
+	}
+	if size() != 0 {
+		t.Fatalf("expected no page in the file while writes are deferred, got %d bytes", size())
+	}
+	if len(pager.cache) != MaxCacheSize+10 {
+		t.Errorf("expected the cache to grow to %d pages, got %d", MaxCacheSize+10, len(pager.cache))
+	}
+
+	deferring = false
+	if _, err := pager.NewPage(); err != nil {
+		t.Fatalf("failed to create page: %v", err)
 	}
+	if len(pager.cache) != MaxCacheSize {
+		t.Errorf("expected the cache to shrink back to %d pages, got %d", MaxCacheSize, len(pager.cache))
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if want := int64(MaxCacheSize+11) * PageSize; size() != want {
+		t.Errorf("expected %d bytes after the flush, got %d", want, size())
+	}
+}
 
-	err = pager.FreePage(1)
+func TestInMemoryPager(t *testing.T) {
+	dbPath := createTempDB(t)
+	pager, err := NewPagerWithOptions(dbPath, Options{InMemory: true})
 	if err != nil {
-		t.Fatalf("failed to free page 1: %v", err)
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	// More pages than the cache holds, so that some are evicted to memory
+	// and read back.
+	for i := range MaxCacheSize * 2 {
+		page, err := pager.NewPage()
+		if err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+		copy(page.Data[:], fmt.Sprintf("page %d", i))
+		if err := pager.WritePage(page); err != nil {
+			t.Fatalf("failed to write page: %v", err)
+		}
+	}
+	for i := range MaxCacheSize * 2 {
+		page, err := pager.ReadPage(PageID(i))
+		if err != nil {
+			t.Fatalf("failed to read page %d: %v", i, err)
+		}
+		if expected := fmt.Sprintf("page %d", i); !bytes.HasPrefix(page.Data[:], []byte(expected)) {
+			t.Errorf("expected page %d to start with %q, got %q", i, expected, page.Data[:len(expected)])
+		}
 	}
-	err = pager.FreePage(2)
+
+	// Offset writes may span pages and leave holes, which read as zeros.
+	offset := uint64(MaxCacheSize*2*PageSize + PageSize - 3)
+	if err := pager.WriteAtOffset(offset, []byte("spanning")); err != nil {
+		t.Fatalf("failed to write at offset: %v", err)
+	}
+	data, err := pager.ReadAtOffset(offset-2, 10)
+	if err != nil || !bytes.Equal(data, []byte("\x00\x00spanning")) {
+		t.Errorf("expected the write to read back after a hole, got %q, err %v", data, err)
+	}
+	if size, err := pager.GetSize(); err != nil || size != offset+8 {
+		t.Errorf("expected a size of %d, got %d, err %v", offset+8, size, err)
+	}
+	if _, err := pager.ReadAtOffset(offset+8, 1); err == nil {
+		t.Errorf("expected reading past the end to fail")
+	}
+
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be created, got %v", err)
+	}
+}
+
+func TestPagerOptions(t *testing.T) {
+	dbPath := createTempDB(t)
+	pager, err := NewPagerWithOptions(dbPath, Options{CacheSize: 4})
 	if err != nil {
-		t.Fatalf("failed to free page 2: %v", err)
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	for range 10 {
+		if _, err := pager.NewPage(); err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+	}
+	if len(pager.cache) != 4 {
+		t.Errorf("expected the cache to hold 4 pages, got %d", len(pager.cache))
+	}
+	if err := pager.Close(); err != nil {
+		t.Fatalf("failed to close pager: %v", err)
 	}
 
-	newPage, err := pager.NewPage()
+	pager, err = NewPagerWithOptions(dbPath, Options{ReadOnly: true})
 	if err != nil {
-		t.Fatalf("failed to create new page after free page: %v", err)
+		t.Fatalf("failed to open pager read-only: %v", err)
+	}
+	defer pager.Close()
+	if _, err := pager.ReadPage(9); err != nil {
+		t.Errorf("failed to read page: %v", err)
 	}
-	if newPage.ID != PageID(2) {
-		t.Errorf("expected page ID for new page after free page to be 2, got %d", newPage.ID)
+	if _, err := pager.NewPage(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v from NewPage, got %v", ErrReadOnly, err)
 	}
-	newPage, err = pager.NewPage()
+	if err := pager.WriteAtOffset(0, []byte("data")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v from WriteAtOffset, got %v", ErrReadOnly, err)
+	}
+	if err := pager.Flush(); err != nil {
+		t.Errorf("failed to flush read-only pager: %v", err)
+	}
+
+	if _, err := NewPagerWithOptions(filepath.Join(t.TempDir(), "missing.db"), Options{ReadOnly: true}); err == nil {
+		t.Errorf("expected opening a missing file read-only to fail")
+	}
+}
+
+func TestTraceHooks(t *testing.T) {
+	var reads, writes []PageID
+	var flushed []int
+	hooks := &trace.Hooks{
+		OnPageRead:  func(page uint32) { reads = append(reads, PageID(page)) },
+		OnPageWrite: func(page uint32) { writes = append(writes, PageID(page)) },
+		OnFlush:     func(pages int, took time.Duration, err error) { flushed = append(flushed, pages) },
+	}
+	pager, err := NewPagerWithOptions(createTempDB(t), Options{CacheSize: 2, Trace: hooks})
 	if err != nil {
-		t.Fatalf("failed to create new page after free page: %v", err)
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	for range 3 {
+		if _, err := pager.NewPage(); err != nil {
+			t.Fatalf("failed to create page: %v", err)
+		}
+	}
+	if _, err := pager.ReadPage(0); err != nil {
+		t.Fatalf("failed to read page: %v", err)
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	if err := pager.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
 	}
-	if newPage.ID != PageID(1) {
-		t.Errorf("expected page ID for new page after free page to be 1, got %d", newPage.ID)
+
+	// Page 0 is evicted by page 2 and read back, evicting page 1, and the
+	// flush writes page 2.
+	if !slices.Equal(reads, []PageID{0}) {
+		t.Errorf("expected page 0 to be read, got %v", reads)
+	}
+	if !slices.Equal(writes, []PageID{0, 1, 2}) {
+		t.Errorf("expected pages 0, 1 and 2 to be written, got %v", writes)
+	}
+	if !slices.Equal(flushed, []int{1}) {
+		t.Errorf("expected one flush of one page, got %v", flushed)
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		eviction EvictionPolicy
+		scan     bool
+		kept     bool
+	}{
+		{"LRU", EvictLRU, false, false},
+		{"LRU with scan", EvictLRU, true, true},
+		{"clock", EvictClock, false, false},
+		{"clock with scan", EvictClock, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pager, err := NewPagerWithOptions("", Options{InMemory: true, CacheSize: 4, Eviction: tt.eviction})
+			if err != nil {
+				t.Fatalf("failed to create pager: %v", err)
+			}
+			defer pager.Close()
+			for range 10 {
+				if _, err := pager.NewPage(); err != nil {
+					t.Fatalf("failed to create page: %v", err)
+				}
+			}
+			if err := pager.Flush(); err != nil {
+				t.Fatalf("failed to flush: %v", err)
+			}
+
+			read := pager.ReadPage
+			if tt.scan {
+				read = pager.ScanPage
+			}
+			for _, id := range []PageID{0, 1, 0, 1} {
+				if _, err := pager.ReadPage(id); err != nil {
+					t.Fatalf("failed to read page %d: %v", id, err)
+				}
+			}
+			hits := pager.metrics.CacheHits.Load()
+			// Like a cursor, the scan reads each page more than once.
+			for id := range PageID(10) {
+				for range 2 {
+					if _, err := read(id); err != nil {
+						t.Fatalf("failed to read page %d: %v", id, err)
+					}
+				}
+			}
+			if got := pager.metrics.CacheHits.Load() - hits; got < 10 {
+				t.Errorf("expected the second read of each page to hit the cache, got %d hits", got)
+			}
+			if len(pager.cache) != 4 {
+				t.Errorf("expected the cache to hold 4 pages, got %d", len(pager.cache))
+			}
+
+			_, found0 := pager.cache[0]
+			_, found1 := pager.cache[1]
+			if found0 != tt.kept || found1 != tt.kept {
+				t.Errorf("expected pages 0 and 1 to be cached %v, got %v and %v", tt.kept, found0, found1)
+			}
+		})
+	}
+}
+
+func TestConcurrentOffsetIO(t *testing.T) {
+	for _, inMemory := range []bool{false, true} {
+		pager, err := NewPagerWithOptions(createTempDB(t), Options{InMemory: inMemory})
+		if err != nil {
+			t.Fatalf("failed to create pager: %v", err)
+		}
+
+		// Each goroutine writes and reads back its own records, which a
+		// shared file position would mix up.
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for g := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 50 {
+					offset := uint64(i*8+g) * 16
+					record := fmt.Appendf(nil, "g%d record %06d", g, i)
+					if err := pager.WriteAtOffset(offset, record); err != nil {
+						errs <- err
+						return
+					}
+					data, err := pager.ReadAtOffset(offset, len(record))
+					if err != nil {
+						errs <- err
+						return
+					}
+					if !bytes.Equal(data, record) {
+						errs <- fmt.Errorf("expected %q at %d, got %q", record, offset, data)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("in memory %v: %v", inMemory, err)
+		}
+
+		if _, err := pager.ReadAtOffset(50*8*16-4, 8); err == nil {
+			t.Errorf("expected a read past the end to fail")
+		}
+		if err := pager.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
 	}
 }
@@ -0,0 +1,99 @@
+package pager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+)
+
+// Priority tells a Scheduler whether I/O may wait for other I/O.
+type Priority uint8
+
+const (
+	// PriorityForeground is I/O a caller is waiting on, such as a Get or a
+	// Put. It never waits.
+	PriorityForeground Priority = iota
+	// PriorityBackground is I/O of maintenance work such as scrubbing or
+	// garbage statistics, which waits for the bandwidth foreground I/O
+	// leaves over.
+	PriorityBackground
+)
+
+// Scheduler paces I/O with a token bucket refilled at a fixed number of
+// bytes per second and holding at most a second's worth. Foreground I/O
+// takes its tokens without waiting, running the bucket into debt if it has
+// to, and background I/O waits until the bucket has enough tokens for it.
+// A nil Scheduler lets all I/O through.
+type Scheduler struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	clock  clock.Clock
+}
+
+func NewScheduler(bytesPerSecond int64) *Scheduler {
+	return NewSchedulerWithClock(bytesPerSecond, clock.Real)
+}
+
+// NewSchedulerWithClock returns a Scheduler that refills its bucket and
+// waits by c.
+func NewSchedulerWithClock(bytesPerSecond int64, c clock.Clock) *Scheduler {
+	return &Scheduler{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   c.Now(),
+		clock:  c,
+	}
+}
+
+// Acquire takes n bytes' worth of tokens for I/O at prio, waiting first
+// if prio is PriorityBackground and the bucket holds too few. Background
+// I/O larger than the bucket waits for a full bucket.
+func (s *Scheduler) Acquire(prio Priority, n int) {
+	if s == nil || s.rate <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		now := s.clock.Now()
+		s.tokens = min(s.tokens+now.Sub(s.last).Seconds()*s.rate, s.rate)
+		s.last = now
+
+		need := min(float64(n), s.rate)
+		if prio == PriorityForeground || s.tokens >= need {
+			s.tokens -= float64(n)
+			return
+		}
+
+		wait := time.Duration((need - s.tokens) / s.rate * float64(time.Second))
+		s.mu.Unlock()
+		s.clock.Sleep(wait)
+		s.mu.Lock()
+	}
+}
+
+// PriorityPager is a view of a Pager whose offset reads and writes are
+// scheduled at another priority. Page reads and writes stay foreground, as
+// they run under the lock of the page cache.
+type PriorityPager struct {
+	*Pager
+	priority Priority
+}
+
+func (p *Pager) WithPriority(prio Priority) *PriorityPager {
+	return &PriorityPager{Pager: p, priority: prio}
+}
+
+func (p *PriorityPager) WriteAtOffset(offset uint64, data []byte) error {
+	p.scheduler.Load().Acquire(p.priority, len(data))
+	return p.writeAtOffset(offset, data)
+}
+
+func (p *PriorityPager) ReadAtOffset(offset uint64, size int) ([]byte, error) {
+	p.scheduler.Load().Acquire(p.priority, size)
+	return p.readAtOffset(offset, size)
+}
@@ -0,0 +1,70 @@
+package pager
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	const rate = 100_000
+
+	tests := []struct {
+		name string
+		// foreground is the I/O done before the background request.
+		foreground int
+		background int
+		minWait    time.Duration
+		maxWait    time.Duration
+	}{
+		{name: "full bucket", background: rate / 2, maxWait: 100 * time.Millisecond},
+		{name: "after foreground", foreground: rate, background: rate / 2, minWait: 400 * time.Millisecond, maxWait: 2 * time.Second},
+		{name: "in debt", foreground: 3 * rate / 2, background: rate / 10, minWait: 500 * time.Millisecond, maxWait: 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(rate)
+
+			start := time.Now()
+			s.Acquire(PriorityForeground, tt.foreground)
+			if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+				t.Fatalf("expected foreground I/O not to wait, waited %v", elapsed)
+			}
+
+			start = time.Now()
+			s.Acquire(PriorityBackground, tt.background)
+			if elapsed := time.Since(start); elapsed < tt.minWait || elapsed > tt.maxWait {
+				t.Errorf("expected background I/O to wait between %v and %v, waited %v", tt.minWait, tt.maxWait, elapsed)
+			}
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var s *Scheduler
+		s.Acquire(PriorityBackground, 1<<30)
+	})
+}
+
+func TestPriorityPager(t *testing.T) {
+	pager, err := NewPager(createTempDB(t))
+	if err != nil {
+		t.Fatalf("failed to create pager: %v", err)
+	}
+	defer pager.Close()
+	pager.SetScheduler(NewScheduler(1 << 20))
+
+	data := []byte("background data")
+	background := pager.WithPriority(PriorityBackground)
+	if err := background.WriteAtOffset(0, data); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	got, err := pager.ReadAtOffset(0, len(data))
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+}
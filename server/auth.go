@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/db"
+)
+
+var (
+	ErrAuthRequired     = errors.New("server: authentication required")
+	ErrPermissionDenied = errors.New("server: permission denied")
+)
+
+// login checks the password of a user of database. It holds mu only to
+// read the user, as checking a password is slow on purpose, and an unknown
+// user takes as long to turn away as a wrong password.
+func login(mu *sync.Mutex, database *db.Database, user, password string) (*catalog.Principal, error) {
+	mu.Lock()
+	p, err := database.Principal(user)
+	mu.Unlock()
+	if errors.Is(err, catalog.ErrPrincipalNotFound) {
+		return nil, catalog.RejectPassword(password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.CheckPassword(password); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// authorize returns nil if user holds the privileges need on object, or
+// any privilege on it if need is zero. It reads the grants afresh, so that
+// revoking them takes effect on connections that are already logged in,
+// and has to be called with the lock of the database held.
+func authorize(database *db.Database, user, object string, need catalog.Privilege) error {
+	if user == "" {
+		return ErrAuthRequired
+	}
+	have, err := database.Privileges(user, object)
+	if errors.Is(err, catalog.ErrPrincipalNotFound) {
+		return ErrPermissionDenied
+	}
+	if err != nil {
+		return err
+	}
+	if have&need != need || have == 0 {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// authorizeSuperuser returns nil if user is a superuser, and has to be
+// called with the lock of the database held.
+func authorizeSuperuser(database *db.Database, user string) error {
+	if user == "" {
+		return ErrAuthRequired
+	}
+	p, err := database.Principal(user)
+	if err != nil && !errors.Is(err, catalog.ErrPrincipalNotFound) {
+		return err
+	}
+	if err != nil || !p.Superuser {
+		return ErrPermissionDenied
+	}
+	return nil
+}
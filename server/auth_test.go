@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/db"
+)
+
+// newAuthDB returns a database with a users table, a superuser root, a
+// reader of the key-value namespace and the table, and a user with no
+// grants.
+func newAuthDB(t *testing.T) *db.Database {
+	t.Helper()
+
+	database := newTestDB(t, db.Options{})
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	if _, err := database.CreateTable("users", columns); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	steps := []error{
+		database.CreateUser("root", "rootpw", true),
+		database.CreateUser("reader", "readpw", false),
+		database.CreateUser("nobody", "nopw", false),
+		database.CreateRole("readers"),
+		database.Grant("readers", "users", catalog.PrivSelect),
+		database.Grant("reader", catalog.KeyValue, catalog.PrivSelect),
+		database.GrantRole("reader", "readers"),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to set up users: %v", err)
+		}
+	}
+	return database
+}
+
+func TestAuthBinary(t *testing.T) {
+	srv := New(newAuthDB(t), Options{RequireAuth: true})
+	addr := serve(t, srv)
+
+	var remote *RemoteError
+	expect := func(err error, message string) {
+		t.Helper()
+		if !errors.As(err, &remote) || remote.Message != message {
+			t.Errorf("expected %q, got %v", message, err)
+		}
+	}
+
+	client := dial(t, addr)
+	_, _, err := client.Get([]byte("key"))
+	expect(err, ErrAuthRequired.Error())
+	expect(client.Auth("reader", "wrong"), catalog.ErrAuthFailed.Error())
+	if err := client.Auth("reader", "readpw"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if _, _, err := client.Get([]byte("key")); err != nil {
+		t.Errorf("expected reader to get, got %v", err)
+	}
+	expect(client.Put([]byte("key"), []byte("value")), ErrPermissionDenied.Error())
+
+	root := dial(t, addr)
+	if err := root.Auth("root", "rootpw"); err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	if err := root.Put([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("expected root to put, got %v", err)
+	}
+
+	// Revoking applies to connections that are logged in already.
+	if err := srv.db.Revoke("reader", catalog.KeyValue, catalog.PrivSelect); err != nil {
+		t.Fatalf("failed to revoke: %v", err)
+	}
+	_, err = client.Scan(nil, nil, 0)
+	expect(err, ErrPermissionDenied.Error())
+}
+
+func TestAuthRESP(t *testing.T) {
+	addr := serve(t, NewRESP(newAuthDB(t), Options{RequireAuth: true}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		request, reply string
+	}{
+		{command("PING"), "-NOAUTH Authentication required.\r\n"},
+		{command("AUTH", "secret"), "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{command("AUTH", "reader", "readpw"), "+OK\r\n"},
+		{command("PING"), "+PONG\r\n"},
+		{command("GET", "key"), "$-1\r\n"},
+		{command("SET", "key", "value"), "-NOPERM User reader has no permissions to run the 'set' command\r\n"},
+		{command("DEL", "key"), "-NOPERM User reader has no permissions to run the 'del' command\r\n"},
+		{command("AUTH", "root", "rootpw"), "+OK\r\n"},
+		{command("SET", "key", "value"), "+OK\r\n"},
+	}
+	for _, tt := range tests {
+		io.WriteString(conn, tt.request)
+		reply, err := r.ReadString('\n')
+		if err != nil || reply != tt.reply {
+			t.Fatalf("%q: expected %q, got %q, err %v", tt.request, tt.reply, reply, err)
+		}
+	}
+}
+
+func TestAuthHTTP(t *testing.T) {
+	srv := httptest.NewServer(NewHTTP(newAuthDB(t), Options{RequireAuth: true}))
+	defer srv.Close()
+
+	tests := []struct {
+		user, password string
+		method, path   string
+		body           string
+		code           int
+	}{
+		{"", "", "GET", "/tables", "", http.StatusUnauthorized},
+		{"reader", "wrong", "GET", "/tables", "", http.StatusUnauthorized},
+		{"nobody", "nopw", "GET", "/tables/users", "", http.StatusForbidden},
+		{"nobody", "nopw", "GET", "/tables/missing", "", http.StatusForbidden},
+		{"reader", "readpw", "GET", "/tables/users", "", http.StatusOK},
+		{"reader", "readpw", "POST", "/tables/users/rows", `{"id": 1}`, http.StatusForbidden},
+		{"root", "rootpw", "POST", "/tables/users/rows", `{"id": 1, "name": "ann"}`, http.StatusCreated},
+		{"reader", "readpw", "GET", "/tables/users/rows/1", "", http.StatusOK},
+		{"reader", "readpw", "POST", "/query", `{"table": "users"}`, http.StatusOK},
+		{"nobody", "nopw", "POST", "/query", `{"table": "users"}`, http.StatusForbidden},
+		{"reader", "readpw", "DELETE", "/tables/users/rows/1", "", http.StatusForbidden},
+		{"reader", "readpw", "POST", "/tables", `{"name": "t", "columns": [{"name": "id", "type": "INT", "primary_key": true, "not_null": true}]}`, http.StatusForbidden},
+		{"root", "rootpw", "POST", "/tables", `{"name": "t", "columns": [{"name": "id", "type": "INT", "primary_key": true, "not_null": true}]}`, http.StatusCreated},
+		{"root", "rootpw", "DELETE", "/tables/users/rows/1", "", http.StatusNoContent},
+		{"reader", "readpw", "GET", "/backup", "", http.StatusForbidden},
+		{"root", "rootpw", "GET", "/backup", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.password)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%s %s as %q: expected %d, got %d %s", tt.method, tt.path, tt.user, tt.code, resp.StatusCode, body)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: expected a basic authentication challenge", tt.method, tt.path)
+		}
+	}
+
+	// Tables the user holds nothing on are left out of the list.
+	req, _ := http.NewRequest("GET", srv.URL+"/tables", nil)
+	req.SetBasicAuth("reader", "readpw")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); strings.TrimSpace(string(body)) != `{"tables":["users"]}` {
+		t.Errorf("expected only users, got %s", body)
+	}
+}
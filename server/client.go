@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"net"
+	"sync"
+
+	"github.com/rizalta/toydb/db"
+)
+
+// RemoteError is an error the server answered a request with.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "server: remote error: " + e.Message
+}
+
+// Client sends requests to a server over one connection. It is safe for
+// concurrent use, with requests sent one at a time.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// Dial connects to the server at the TCP address addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client that sends its requests over conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// do sends a request and reads its response, turning StatusError into a
+// RemoteError.
+func (c *Client) do(op Op, args ...[]byte) (Status, [][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := writeFrame(c.w, byte(op), args...); err != nil {
+		return 0, nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, nil, err
+	}
+	// The records of a batch go whole in a REPLICATE response, however
+	// large.
+	limit := DefaultMaxFrameSize
+	if op == OpReplicate {
+		limit = math.MaxInt32
+	}
+	status, fields, err := readFrame(c.r, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch Status(status) {
+	case StatusOK, StatusNotFound:
+		return Status(status), fields, nil
+	case StatusError:
+		if len(fields) != 1 {
+			return 0, nil, ErrMalformed
+		}
+		return 0, nil, &RemoteError{Message: string(fields[0])}
+	default:
+		return 0, nil, ErrMalformed
+	}
+}
+
+// Auth logs the connection in as a user of the database.
+func (c *Client) Auth(user, password string) error {
+	_, _, err := c.do(OpAuth, []byte(user), []byte(password))
+	return err
+}
+
+func (c *Client) Get(key []byte) ([]byte, bool, error) {
+	status, fields, err := c.do(OpGet, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if status == StatusNotFound {
+		return nil, false, nil
+	}
+	if len(fields) != 1 {
+		return nil, false, ErrMalformed
+	}
+	return fields[0], true, nil
+}
+
+func (c *Client) Put(key, value []byte) error {
+	_, _, err := c.do(OpPut, key, value)
+	return err
+}
+
+// Delete removes key and reports whether it was there.
+func (c *Client) Delete(key []byte) (bool, error) {
+	status, _, err := c.do(OpDelete, key)
+	if err != nil {
+		return false, err
+	}
+	return status == StatusOK, nil
+}
+
+// Scan returns up to limit pairs with keys in [start, end) in key order,
+// from the first key if start is empty and to the last if end is empty.
+// A limit of zero, or one above the server's, takes the server's limit. To
+// read on past the pairs returned, scan again from the last key with a
+// zero byte appended.
+func (c *Client) Scan(start, end []byte, limit int) ([]db.Pair, error) {
+	status, fields, err := c.do(OpScan, start, end, binary.BigEndian.AppendUint32(nil, uint32(limit)))
+	if err != nil {
+		return nil, err
+	}
+	if status != StatusOK || len(fields)%2 != 0 {
+		return nil, ErrMalformed
+	}
+	pairs := make([]db.Pair, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		pairs = append(pairs, db.Pair{Key: fields[i], Value: fields[i+1]})
+	}
+	return pairs, nil
+}
+
+// ReadLog returns about maxBytes of the data log of the server from
+// position from on, and the position they start at, or no records if the
+// log ends at from. A maxBytes of zero takes the server's frame size.
+func (c *Client) ReadLog(from uint64, maxBytes int) (uint64, []byte, error) {
+	status, fields, err := c.do(OpReplicate, binary.BigEndian.AppendUint64(nil, from), binary.BigEndian.AppendUint32(nil, uint32(maxBytes)))
+	if err != nil {
+		return 0, nil, err
+	}
+	if status == StatusNotFound {
+		return from, nil, nil
+	}
+	if len(fields) != 2 || len(fields[0]) != 8 {
+		return 0, nil, ErrMalformed
+	}
+	return binary.BigEndian.Uint64(fields[0]), fields[1], nil
+}
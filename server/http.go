@@ -0,0 +1,896 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// The HTTP API serves the tables of a database as JSON:
+//
+//	GET    /tables                    names of the tables
+//	POST   /tables                    create a table
+//	GET    /tables/{name}             schema of a table
+//	GET    /tables/{name}/rows        rows of a table in key order
+//	POST   /tables/{name}/rows        insert a row
+//	GET    /tables/{name}/rows/{key}  the row with a primary key
+//	PUT    /tables/{name}/rows/{key}  replace a row
+//	PATCH  /tables/{name}/rows/{key}  change some columns of a row
+//	DELETE /tables/{name}/rows/{key}  delete a row
+//	POST   /query                     rows matching a query
+//	GET    /backup                    a backup of the database, for db.Restore
+//
+// A row is an object keyed by column name, with values typed from the
+// schema: numbers for INT and FLOAT, strings for VARCHAR, base64 strings
+// for BLOB and RFC 3339 strings for TIMESTAMP, which also take any form
+// ParseTimestamp reads. Keys in paths and query strings are plain text,
+// with blobs in hex.
+//
+// Rows are listed as {"columns": [...], "rows": [...], "truncated": bool},
+// streamed and flushed every flushRows rows. A listing stops at the limit
+// and max_bytes of the request, capped by Options.MaxRows and MaxBytes, and
+// sets truncated if rows were left out. An error after the rows started
+// ends the listing with an "error" member in place of truncated. Other
+// errors answer {"error": message} with a status that fits them.
+//
+// With Options.RequireAuth, requests log in with HTTP basic authentication
+// and need SELECT to read rows, INSERT, UPDATE and DELETE to change them,
+// and any privilege to see a table at all. Creating tables and taking
+// backups takes a superuser.
+
+// flushRows is the number of rows a listing writes between flushes.
+const flushRows = 100
+
+var errBadRequest = errors.New("server: bad request")
+
+type httpAPI struct {
+	db   *db.Database
+	mu   *sync.Mutex
+	opts Options
+}
+
+type userKey struct{}
+
+// NewHTTP returns a handler of the HTTP API of database, which has to stay
+// open while the handler is in use. Requests run one at a time, and share
+// a lock with the other servers of the database; a listing holds it until
+// its last row is written.
+func NewHTTP(database *db.Database, opts Options) http.Handler {
+	api := &httpAPI{
+		db:   database,
+		mu:   database.ServeLock(),
+		opts: opts.withDefaults(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tables", api.listTables)
+	mux.HandleFunc("POST /tables", api.createTable)
+	mux.HandleFunc("GET /tables/{name}", api.getTable)
+	mux.HandleFunc("GET /tables/{name}/rows", api.listRows)
+	mux.HandleFunc("POST /tables/{name}/rows", api.insertRow)
+	mux.HandleFunc("GET /tables/{name}/rows/{key}", api.getRow)
+	mux.HandleFunc("PUT /tables/{name}/rows/{key}", api.replaceRow)
+	mux.HandleFunc("PATCH /tables/{name}/rows/{key}", api.updateRow)
+	mux.HandleFunc("DELETE /tables/{name}/rows/{key}", api.deleteRow)
+	mux.HandleFunc("POST /query", api.query)
+	mux.HandleFunc("GET /backup", api.backup)
+	if !api.opts.RequireAuth {
+		return mux
+	}
+	return api.authenticate(mux)
+}
+
+// authenticate requires HTTP basic authentication as a user of the
+// database, and passes the user on in the context of the request.
+func (api *httpAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			writeHTTPError(w, ErrAuthRequired)
+			return
+		}
+		if _, err := login(api.mu, api.db, user, password); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// authorize checks the grants of the user of a request, with the lock of
+// the database held, if the API requires authentication.
+func (api *httpAPI) authorize(r *http.Request, table string, need catalog.Privilege) error {
+	if !api.opts.RequireAuth {
+		return nil
+	}
+	user, _ := r.Context().Value(userKey{}).(string)
+	return authorize(api.db, user, table, need)
+}
+
+// authorizeSuperuser requires the user of a request to be a superuser if
+// the API requires authentication.
+func (api *httpAPI) authorizeSuperuser(r *http.Request) error {
+	if !api.opts.RequireAuth {
+		return nil
+	}
+	user, _ := r.Context().Value(userKey{}).(string)
+	return authorizeSuperuser(api.db, user)
+}
+
+// badRequest lists the errors that blame the request rather than the
+// server.
+var badRequest = []error{
+	errBadRequest,
+	db.ErrInvalidPrimaryKey,
+	db.ErrColumnCountMismatch,
+	db.ErrNotNULL,
+	db.ErrColumnNotFound,
+	db.ErrForeignKeyViolation,
+	db.ErrInvalidValue,
+	db.ErrInvalidFilter,
+	db.ErrInvalidQuery,
+	db.ErrCheckViolation,
+	catalog.ErrNoPrimaryKey,
+	catalog.ErrMultiplePrimaryKeys,
+	catalog.ErrPrimaryKeyNotNull,
+	catalog.ErrUnsupportedPrimaryKey,
+	catalog.ErrDuplicateColumnName,
+	catalog.ErrInvalidDefault,
+	catalog.ErrInvalidReference,
+	catalog.ErrInvalidGenerator,
+	tuple.ErrTypeMismatch,
+	tuple.ErrInvalidTimestamp,
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrAuthRequired), errors.Is(err, catalog.ErrAuthFailed):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, catalog.ErrTableNotFound), errors.Is(err, index.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, catalog.ErrAlreadyExists), errors.Is(err, index.ErrKeyAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrReadOnly):
+		return http.StatusForbidden
+	}
+	for _, target := range badRequest {
+		if errors.Is(err, target) {
+			return http.StatusBadRequest
+		}
+	}
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="toydb"`)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// readJSON decodes the body of a request, which may be at most
+// MaxFrameSize bytes.
+func (api *httpAPI) readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(api.opts.MaxFrameSize)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errBadRequest, err)
+	}
+	return nil
+}
+
+// apiColumn is a column as the API reads and writes it, with the type by
+// name.
+type apiColumn struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	PrimaryKey bool            `json:"primary_key,omitempty"`
+	NotNull    bool            `json:"not_null,omitempty"`
+	Default    json.RawMessage `json:"default,omitempty"`
+}
+
+type apiTable struct {
+	Name    string      `json:"name"`
+	Columns []apiColumn `json:"columns"`
+}
+
+func parseType(name string) (catalog.DataType, error) {
+	for t := catalog.TypeInt; t <= catalog.TypeTimestamp; t++ {
+		if strings.EqualFold(t.String(), name) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown type %q", errBadRequest, name)
+}
+
+func (api *httpAPI) listTables(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	tables, err := api.db.Tables()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	// A user only sees the tables it holds privileges on.
+	visible := []string{}
+	for _, table := range tables {
+		err := api.authorize(r, table, 0)
+		if errors.Is(err, ErrPermissionDenied) {
+			continue
+		}
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		visible = append(visible, table)
+	}
+	tables = visible
+	writeJSON(w, http.StatusOK, map[string][]string{"tables": tables})
+}
+
+func (api *httpAPI) createTable(w http.ResponseWriter, r *http.Request) {
+	var table apiTable
+	if err := api.readJSON(w, r, &table); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	columns := make([]catalog.Column, len(table.Columns))
+	for i, c := range table.Columns {
+		typ, err := parseType(c.Type)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		value, err := decodeValue(catalog.Column{Name: c.Name, Type: typ}, c.Default)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		columns[i] = catalog.Column{Name: c.Name, Type: typ, IsPrimaryKey: c.PrimaryKey, IsNotNull: c.NotNull, DefaultValue: value}
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorizeSuperuser(r); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, err := api.db.CreateTable(table.Name, columns)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/tables/"+url.PathEscape(schema.Name))
+	writeJSON(w, http.StatusCreated, tableOf(schema))
+}
+
+func tableOf(schema *catalog.Schema) apiTable {
+	table := apiTable{Name: schema.Name, Columns: make([]apiColumn, len(schema.Columns))}
+	for i, c := range schema.Columns {
+		table.Columns[i] = apiColumn{Name: c.Name, Type: c.Type.String(), PrimaryKey: c.IsPrimaryKey, NotNull: c.IsNotNull}
+		if c.DefaultValue != nil {
+			table.Columns[i].Default, _ = encodeValue(c.DefaultValue)
+		}
+	}
+	return table
+}
+
+func (api *httpAPI) getTable(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, r.PathValue("name"), 0); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, err := api.db.Schema(r.PathValue("name"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tableOf(schema))
+}
+
+// encodeValue encodes a value of a row as JSON.
+func encodeValue(v tuple.Value) ([]byte, error) {
+	if t, ok := v.(time.Time); ok {
+		return json.Marshal(tuple.FormatTimestamp(t))
+	}
+	return json.Marshal(v)
+}
+
+// decodeValue decodes a JSON value into the Go type of the column. A
+// missing value or null decodes to nil.
+func decodeValue(column catalog.Column, data json.RawMessage) (tuple.Value, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var v tuple.Value
+	var err error
+	switch column.Type {
+	case catalog.TypeInt:
+		var i int64
+		err = json.Unmarshal(data, &i)
+		v = i
+	case catalog.TypeVarChar:
+		var s string
+		err = json.Unmarshal(data, &s)
+		v = s
+	case catalog.TypeBoolean:
+		var b bool
+		err = json.Unmarshal(data, &b)
+		v = b
+	case catalog.TypeBlob:
+		var b []byte
+		err = json.Unmarshal(data, &b)
+		v = b
+	case catalog.TypeFloat:
+		var f float64
+		err = json.Unmarshal(data, &f)
+		v = f
+	case catalog.TypeTimestamp:
+		var s string
+		if err = json.Unmarshal(data, &s); err == nil {
+			v, err = tuple.ParseTimestamp(s)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", db.ErrInvalidValue, column.Name, err)
+	}
+	return v, nil
+}
+
+// parseText parses a value of a column written as plain text.
+func parseText(column catalog.Column, text string) (tuple.Value, error) {
+	var v tuple.Value
+	var err error
+	switch column.Type {
+	case catalog.TypeInt:
+		v, err = strconv.ParseInt(text, 10, 64)
+	case catalog.TypeVarChar:
+		v = text
+	case catalog.TypeBoolean:
+		v, err = strconv.ParseBool(text)
+	case catalog.TypeBlob:
+		v, err = hex.DecodeString(text)
+	case catalog.TypeFloat:
+		v, err = strconv.ParseFloat(text, 64)
+	case catalog.TypeTimestamp:
+		v, err = tuple.ParseTimestamp(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", db.ErrInvalidValue, column.Name, err)
+	}
+	return v, nil
+}
+
+func formatText(v tuple.Value) string {
+	switch v := v.(type) {
+	case []byte:
+		return hex.EncodeToString(v)
+	case time.Time:
+		return tuple.FormatTimestamp(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func encodeRow(schema *catalog.Schema, row tuple.Tuple) ([]byte, error) {
+	data := []byte{'{'}
+	for i, c := range schema.Columns {
+		if i > 0 {
+			data = append(data, ',')
+		}
+		name, _ := json.Marshal(c.Name)
+		value, err := encodeValue(row[i])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, name...)
+		data = append(data, ':')
+		data = append(data, value...)
+	}
+	return append(data, '}'), nil
+}
+
+// decodeRow decodes the columns of a row object into their values, with
+// nil for the columns the object leaves out, and reports which it holds.
+func decodeRow(schema *catalog.Schema, object map[string]json.RawMessage) (tuple.Tuple, []bool, error) {
+	row := make(tuple.Tuple, len(schema.Columns))
+	present := make([]bool, len(schema.Columns))
+	for i, c := range schema.Columns {
+		data, ok := object[c.Name]
+		if !ok {
+			continue
+		}
+		value, err := decodeValue(c, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		row[i], present[i] = value, true
+	}
+	for name := range object {
+		if columnIndex(schema, name) < 0 {
+			return nil, nil, fmt.Errorf("%w: %s", db.ErrColumnNotFound, name)
+		}
+	}
+	return row, present, nil
+}
+
+func columnIndex(schema *catalog.Schema, name string) int {
+	for i, c := range schema.Columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// rowOf decodes the row object of a request for the table of the path and
+// returns the schema of the table with the row.
+func (api *httpAPI) rowOf(r *http.Request, object map[string]json.RawMessage) (*catalog.Schema, tuple.Tuple, []bool, error) {
+	schema, err := api.db.Schema(r.PathValue("name"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	row, present, err := decodeRow(schema, object)
+	return schema, row, present, err
+}
+
+// pathKey parses the primary key of the path.
+func pathKey(schema *catalog.Schema, r *http.Request) (tuple.Value, error) {
+	return parseText(schema.Columns[schema.PrimaryKeyIndex], r.PathValue("key"))
+}
+
+func (api *httpAPI) insertRow(w http.ResponseWriter, r *http.Request) {
+	var object map[string]json.RawMessage
+	if err := api.readJSON(w, r, &object); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, r.PathValue("name"), catalog.PrivInsert); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, row, _, err := api.rowOf(r, object)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	key, err := api.db.InsertKey(schema.Name, row)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	encoded, err := encodeValue(key)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/tables/"+url.PathEscape(schema.Name)+"/rows/"+url.PathEscape(formatText(key)))
+	writeJSON(w, http.StatusCreated, map[string]json.RawMessage{"key": encoded})
+}
+
+func (api *httpAPI) getRow(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, r.PathValue("name"), catalog.PrivSelect); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, err := api.db.Schema(r.PathValue("name"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	key, err := pathKey(schema, r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	row, found, err := api.db.Get(schema.Name, key)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if !found {
+		writeHTTPError(w, index.ErrKeyNotFound)
+		return
+	}
+	data, err := encodeRow(schema, row)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// replaceRow writes the row of the request over the row with the key of
+// the path. Columns it leaves out become NULL, and a primary key in it has
+// to match the path.
+func (api *httpAPI) replaceRow(w http.ResponseWriter, r *http.Request) {
+	var object map[string]json.RawMessage
+	if err := api.readJSON(w, r, &object); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, r.PathValue("name"), catalog.PrivUpdate); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, row, present, err := api.rowOf(r, object)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	key, err := pathKey(schema, r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if pk := schema.PrimaryKeyIndex; present[pk] && formatText(row[pk]) != formatText(key) {
+		writeHTTPError(w, fmt.Errorf("%w: key differs from the path", db.ErrInvalidPrimaryKey))
+		return
+	}
+	row[schema.PrimaryKeyIndex] = key
+	if err := api.db.Update(schema.Name, row); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateRow changes the columns the request holds in the row with the key
+// of the path.
+func (api *httpAPI) updateRow(w http.ResponseWriter, r *http.Request) {
+	var object map[string]json.RawMessage
+	if err := api.readJSON(w, r, &object); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, r.PathValue("name"), catalog.PrivUpdate); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, row, present, err := api.rowOf(r, object)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	key, err := pathKey(schema, r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	changes := make(map[string]tuple.Value)
+	for i, c := range schema.Columns {
+		if present[i] {
+			changes[c.Name] = row[i]
+		}
+	}
+	if err := api.db.UpdateColumns(schema.Name, key, changes); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *httpAPI) deleteRow(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, r.PathValue("name"), catalog.PrivDelete); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, err := api.db.Schema(r.PathValue("name"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	key, err := pathKey(schema, r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	exists, err := api.db.Exists(schema.Name, key)
+	if err == nil && !exists {
+		err = index.ErrKeyNotFound
+	}
+	if err == nil {
+		err = api.db.Delete(schema.Name, key)
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// limits turns the limit and max_bytes of a request into the ones a
+// listing keeps to, with zero meaning the server's.
+func (api *httpAPI) limits(limit, maxBytes int) (int, int, error) {
+	if limit < 0 || maxBytes < 0 {
+		return 0, 0, fmt.Errorf("%w: negative limit", errBadRequest)
+	}
+	if limit == 0 || limit > api.opts.MaxRows {
+		limit = api.opts.MaxRows
+	}
+	if maxBytes == 0 || maxBytes > api.opts.MaxBytes {
+		maxBytes = api.opts.MaxBytes
+	}
+	return limit, maxBytes, nil
+}
+
+func (api *httpAPI) listRows(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, r.PathValue("name"), catalog.PrivSelect); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, err := api.db.Schema(r.PathValue("name"))
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	params := r.URL.Query()
+	var opts db.QueryOptions
+	pk := schema.Columns[schema.PrimaryKeyIndex]
+	for name, bound := range map[string]*tuple.Value{"start": &opts.Start, "end": &opts.End} {
+		if params.Has(name) {
+			if *bound, err = parseText(pk, params.Get(name)); err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+		}
+	}
+	var counts [3]int
+	for i, name := range []string{"offset", "limit", "max_bytes"} {
+		if params.Has(name) {
+			if counts[i], err = strconv.Atoi(params.Get(name)); err != nil {
+				writeHTTPError(w, fmt.Errorf("%w: invalid %s", errBadRequest, name))
+				return
+			}
+		}
+	}
+	opts.Offset = counts[0]
+	api.streamRows(w, r, schema, opts, counts[1], counts[2])
+}
+
+type whereClause struct {
+	Column string          `json:"column"`
+	Op     string          `json:"op"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+type orderClause struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc,omitempty"`
+}
+
+// queryRequest is the body of /query. Where clauses all have to match,
+// and start and end bound the primary key as in db.QueryOptions.
+type queryRequest struct {
+	Table    string          `json:"table"`
+	Where    []whereClause   `json:"where,omitempty"`
+	OrderBy  []orderClause   `json:"order_by,omitempty"`
+	Start    json.RawMessage `json:"start,omitempty"`
+	End      json.RawMessage `json:"end,omitempty"`
+	Offset   int             `json:"offset,omitempty"`
+	Limit    int             `json:"limit,omitempty"`
+	MaxBytes int             `json:"max_bytes,omitempty"`
+}
+
+func parseOperator(symbol string) (db.Operator, error) {
+	for op := db.OpEqual; op <= db.OpIsNotNull; op++ {
+		if strings.EqualFold(op.String(), symbol) {
+			return op, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown operator %q", db.ErrInvalidFilter, symbol)
+}
+
+func (api *httpAPI) query(w http.ResponseWriter, r *http.Request) {
+	var req queryRequest
+	if err := api.readJSON(w, r, &req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if err := api.authorize(r, req.Table, catalog.PrivSelect); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	schema, err := api.db.Schema(req.Table)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	opts := db.QueryOptions{Offset: req.Offset}
+	pk := schema.Columns[schema.PrimaryKeyIndex]
+	if opts.Start, err = decodeValue(pk, req.Start); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if opts.End, err = decodeValue(pk, req.End); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	var filters []db.Filter
+	for _, clause := range req.Where {
+		op, err := parseOperator(clause.Op)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		i := columnIndex(schema, clause.Column)
+		if i < 0 {
+			writeHTTPError(w, fmt.Errorf("%w: %s", db.ErrColumnNotFound, clause.Column))
+			return
+		}
+		value, err := decodeValue(schema.Columns[i], clause.Value)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		filters = append(filters, db.Where(clause.Column, op, value))
+	}
+	if len(filters) > 0 {
+		opts.Filter = db.And(filters...)
+	}
+	for _, order := range req.OrderBy {
+		opts.OrderBy = append(opts.OrderBy, db.OrderBy{Column: order.Column, Desc: order.Desc})
+	}
+	api.streamRows(w, r, schema, opts, req.Limit, req.MaxBytes)
+}
+
+// streamRows writes the rows of a query as a listing, flushing as it goes
+// so that a large one is never held whole.
+func (api *httpAPI) streamRows(w http.ResponseWriter, r *http.Request, schema *catalog.Schema, opts db.QueryOptions, limit, maxBytes int) {
+	limit, maxBytes, err := api.limits(limit, maxBytes)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	// One row past the limit tells whether the listing is truncated.
+	opts.Limit = limit + 1
+	rows, err := api.db.QueryContext(r.Context(), schema.Name, opts)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	rc := http.NewResponseController(w)
+
+	columns := make([]string, len(schema.Columns))
+	for i, c := range schema.Columns {
+		columns[i] = c.Name
+	}
+	header, _ := json.Marshal(columns)
+	bw.WriteString(`{"columns":`)
+	bw.Write(header)
+	bw.WriteString(`,"rows":[`)
+
+	fail := func(err error) {
+		msg, _ := json.Marshal(err.Error())
+		fmt.Fprintf(bw, "\n],\"error\":%s}\n", msg)
+		bw.Flush()
+	}
+	n, size, truncated := 0, 0, false
+	for {
+		row, err := rows.Next()
+		if err != nil {
+			fail(err)
+			return
+		}
+		if row == nil {
+			break
+		}
+		data, err := encodeRow(schema, row)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if n == limit || size+len(data) > maxBytes {
+			truncated = true
+			break
+		}
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteByte('\n')
+		bw.Write(data)
+		n++
+		size += len(data)
+		if n%flushRows == 0 {
+			bw.Flush()
+			rc.Flush()
+		}
+	}
+	fmt.Fprintf(bw, "\n],\"truncated\":%t}\n", truncated)
+	bw.Flush()
+}
+
+// backup streams a backup of the database as a tar archive. Only taking
+// the snapshot holds the lock, so requests go on while it is written. A
+// failure midway aborts the response, so that a partial backup never
+// looks complete.
+func (api *httpAPI) backup(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	err := api.authorizeSuperuser(r)
+	var snapshot *storage.Snapshot
+	if err == nil {
+		snapshot, err = api.db.Snapshot()
+	}
+	api.mu.Unlock()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.WriteHeader(http.StatusOK)
+	if _, err := snapshot.WriteTo(w); err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
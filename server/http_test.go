@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rizalta/toydb/db"
+)
+
+// do sends a request with a JSON body to the API and returns the status
+// and body of the response.
+func do(t *testing.T, srv *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: failed to read body: %v", method, path, err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(data))
+}
+
+func newTestAPI(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(NewHTTP(newTestDB(t, db.Options{}), opts))
+	t.Cleanup(srv.Close)
+	code, body := do(t, srv, "POST", "/tables", `{"name": "users", "columns": [
+		{"name": "id", "type": "INT", "primary_key": true, "not_null": true},
+		{"name": "name", "type": "varchar", "not_null": true},
+		{"name": "active", "type": "BOOLEAN", "default": true},
+		{"name": "avatar", "type": "BLOB"},
+		{"name": "joined", "type": "TIMESTAMP"}
+	]}`)
+	if code != http.StatusCreated {
+		t.Fatalf("failed to create table: %d %s", code, body)
+	}
+	return srv
+}
+
+func TestHTTPRows(t *testing.T) {
+	srv := newTestAPI(t, Options{})
+
+	tests := []struct {
+		method, path, body string
+		code               int
+		response           string
+	}{
+		{"GET", "/tables", "", 200, `{"tables":["users"]}`},
+		{"GET", "/tables/users", "", 200, `{"name":"users","columns":[{"name":"id","type":"INT","primary_key":true,"not_null":true},{"name":"name","type":"VARCHAR","not_null":true},{"name":"active","type":"BOOLEAN","default":true},{"name":"avatar","type":"BLOB"},{"name":"joined","type":"TIMESTAMP"}]}`},
+		{"GET", "/tables/missing", "", 404, `{"error":"catalog: table missing not found"}`},
+		{"POST", "/tables", `{"name": "users", "columns": [{"name": "id", "type": "INT", "primary_key": true, "not_null": true}]}`, 409, `{"error":"catalog: table already exists"}`},
+		{"POST", "/tables", `{"name": "t", "columns": [{"name": "id", "type": "DECIMAL"}]}`, 400, `{"error":"server: bad request: unknown type \"DECIMAL\""}`},
+
+		{"POST", "/tables/users/rows", `{"id": 1, "name": "ada", "avatar": "AQI=", "joined": "2024-05-01"}`, 201, `{"key":1}`},
+		{"POST", "/tables/users/rows", `{"id": 2, "name": "bob", "active": false}`, 201, `{"key":2}`},
+		{"POST", "/tables/users/rows", `{"id": 1, "name": "again"}`, 409, `{"error":"index: key already exists"}`},
+		{"POST", "/tables/users/rows", `{"id": 3}`, 400, `{"error":"db: value cannot be NULL"}`},
+		{"POST", "/tables/users/rows", `{"id": "three", "name": "x"}`, 400, `{"error":"db: value does not match column type: column id: json: cannot unmarshal string into Go value of type int64"}`},
+		{"POST", "/tables/users/rows", `{"id": 3, "name": "x", "age": 7}`, 400, `{"error":"db: column not found: age"}`},
+		{"POST", "/tables/users/rows", `{"id": 3,`, 400, `{"error":"server: bad request: unexpected EOF"}`},
+
+		{"GET", "/tables/users/rows/1", "", 200, `{"id":1,"name":"ada","active":true,"avatar":"AQI=","joined":"2024-05-01T00:00:00Z"}`},
+		{"GET", "/tables/users/rows/9", "", 404, `{"error":"index: key not found"}`},
+		{"GET", "/tables/users/rows/x", "", 400, `{"error":"db: value does not match column type: column id: strconv.ParseInt: parsing \"x\": invalid syntax"}`},
+
+		{"PATCH", "/tables/users/rows/2", `{"active": true, "avatar": "/w=="}`, 204, ""},
+		{"GET", "/tables/users/rows/2", "", 200, `{"id":2,"name":"bob","active":true,"avatar":"/w==","joined":null}`},
+		{"PATCH", "/tables/users/rows/9", `{"active": true}`, 404, `{"error":"index: key not found"}`},
+		{"PUT", "/tables/users/rows/2", `{"name": "bobby"}`, 204, ""},
+		{"GET", "/tables/users/rows/2", "", 200, `{"id":2,"name":"bobby","active":null,"avatar":null,"joined":null}`},
+		{"PUT", "/tables/users/rows/2", `{"id": 3, "name": "bobby"}`, 400, `{"error":"db: invalid primary key: key differs from the path"}`},
+		{"PUT", "/tables/users/rows/9", `{"name": "nobody"}`, 404, `{"error":"index: key not found"}`},
+
+		{"DELETE", "/tables/users/rows/2", "", 204, ""},
+		{"DELETE", "/tables/users/rows/2", "", 404, `{"error":"index: key not found"}`},
+		{"GET", "/tables/users/rows", "", 200, `{"columns":["id","name","active","avatar","joined"],"rows":[
+{"id":1,"name":"ada","active":true,"avatar":"AQI=","joined":"2024-05-01T00:00:00Z"}
+],"truncated":false}`},
+	}
+	for _, tt := range tests {
+		code, body := do(t, srv, tt.method, tt.path, tt.body)
+		if code != tt.code || body != tt.response {
+			t.Errorf("%s %s: expected %d %s, got %d %s", tt.method, tt.path, tt.code, tt.response, code, body)
+		}
+	}
+}
+
+// listing is a response that lists rows.
+type listing struct {
+	Columns   []string         `json:"columns"`
+	Rows      []map[string]any `json:"rows"`
+	Truncated bool             `json:"truncated"`
+	Error     string           `json:"error"`
+}
+
+func ids(t *testing.T, code int, body string) ([]int, bool) {
+	t.Helper()
+
+	if code != http.StatusOK {
+		t.Fatalf("expected rows, got %d %s", code, body)
+	}
+	var l listing
+	if err := json.Unmarshal([]byte(body), &l); err != nil {
+		t.Fatalf("failed to decode %s: %v", body, err)
+	}
+	if l.Error != "" {
+		t.Fatalf("listing failed: %s", l.Error)
+	}
+	ids := []int{}
+	for _, row := range l.Rows {
+		ids = append(ids, int(row["id"].(float64)))
+	}
+	return ids, l.Truncated
+}
+
+func TestHTTPQuery(t *testing.T) {
+	// Rows take 70 to 80 bytes of JSON each.
+	srv := newTestAPI(t, Options{MaxRows: 150, MaxBytes: 8000})
+	for i := range 200 {
+		body := fmt.Sprintf(`{"id": %d, "name": "user%03d", "active": %t}`, i, i, i%2 == 0)
+		if code, resp := do(t, srv, "POST", "/tables/users/rows", body); code != http.StatusCreated {
+			t.Fatalf("failed to insert: %d %s", code, resp)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		ids       []int
+		truncated bool
+	}{
+		{"range", "GET", "/tables/users/rows?start=10&end=14", "", []int{10, 11, 12, 13}, false},
+		{"offset and limit", "GET", "/tables/users/rows?offset=5&limit=3", "", []int{5, 6, 7}, true},
+		{"last rows", "GET", "/tables/users/rows?start=198", "", []int{198, 199}, false},
+		{"max bytes", "GET", "/tables/users/rows?max_bytes=200", "", []int{0, 1}, true},
+		{"filter", "POST", "/query", `{"table": "users", "where": [{"column": "active", "op": "=", "value": false}, {"column": "id", "op": "<", "value": 8}]}`, []int{1, 3, 5, 7}, false},
+		{"order", "POST", "/query", `{"table": "users", "where": [{"column": "name", "op": ">=", "value": "user195"}], "order_by": [{"column": "name", "desc": true}], "limit": 3}`, []int{199, 198, 197}, true},
+		{"key bounds", "POST", "/query", `{"table": "users", "start": 50, "end": 53, "where": [{"column": "joined", "op": "IS NULL"}]}`, []int{50, 51, 52}, false},
+	}
+	for _, tt := range tests {
+		code, body := do(t, srv, tt.method, tt.path, tt.body)
+		got, truncated := ids(t, code, body)
+		if !reflect.DeepEqual(got, tt.ids) || truncated != tt.truncated {
+			t.Errorf("%s: expected %v, truncated %v, got %v, truncated %v", tt.name, tt.ids, tt.truncated, got, truncated)
+		}
+	}
+
+	// The server caps the limits of requests.
+	for _, path := range []string{"/tables/users/rows", "/tables/users/rows?limit=1000"} {
+		code, body := do(t, srv, "GET", path, "")
+		got, truncated := ids(t, code, body)
+		if len(got) >= 150 || len(got) < 100 || !truncated {
+			t.Errorf("%s: expected the 8000 byte cap to truncate the listing, got %d rows, truncated %v", path, len(got), truncated)
+		}
+	}
+
+	errorTests := []struct {
+		body string
+		code int
+	}{
+		{`{"table": "missing"}`, http.StatusNotFound},
+		{`{"table": "users", "where": [{"column": "id", "op": "~", "value": 1}]}`, http.StatusBadRequest},
+		{`{"table": "users", "where": [{"column": "age", "op": "=", "value": 1}]}`, http.StatusBadRequest},
+		{`{"table": "users", "where": [{"column": "id", "op": "=", "value": "one"}]}`, http.StatusBadRequest},
+		{`{"table": "users", "limit": -1}`, http.StatusBadRequest},
+		{`{"table": "users", "select": ["id"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range errorTests {
+		if code, body := do(t, srv, "POST", "/query", tt.body); code != tt.code {
+			t.Errorf("%s: expected %d, got %d %s", tt.body, tt.code, code, body)
+		}
+	}
+}
+
+func TestHTTPBodyLimit(t *testing.T) {
+	srv := newTestAPI(t, Options{MaxFrameSize: 1000})
+	body := fmt.Sprintf(`{"id": 1, "name": %q}`, strings.Repeat("x", 1000))
+	if code, resp := do(t, srv, "POST", "/tables/users/rows", body); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d, got %d %s", http.StatusRequestEntityTooLarge, code, resp)
+	}
+}
+
+func TestHTTPBackup(t *testing.T) {
+	srv := newTestAPI(t, Options{})
+	if code, body := do(t, srv, "POST", "/tables/users/rows", `{"id": 1, "name": "ann"}`); code != http.StatusCreated {
+		t.Fatalf("failed to insert: %d %s", code, body)
+	}
+
+	resp, err := srv.Client().Get(srv.URL + "/backup")
+	if err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	dir := filepath.Join(t.TempDir(), "restored")
+	if err := db.Restore(resp.Body, dir); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	restored, err := db.Open(dir, db.Options{})
+	if err != nil {
+		t.Fatalf("failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	if row, found, err := restored.Get("users", int64(1)); err != nil || !found || row[1] != "ann" {
+		t.Errorf("expected the row of ann, got %v, %v, %v", row, found, err)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The protocol is a series of frames, each a 4-byte big-endian length
+// followed by that many bytes. A client sends a request frame and reads
+// the response frame before sending the next request.
+//
+// A request frame holds an op byte and the arguments of the op, each a
+// 4-byte big-endian length and its bytes:
+//
+//	GET       key
+//	PUT       key value
+//	DELETE    key
+//	SCAN      start end limit
+//	AUTH      user password
+//	REPLICATE from limit
+//
+// An empty start or end leaves that side of a scan open, and limit is a
+// 4-byte big-endian count, with zero meaning the server's limit. REPLICATE
+// asks for about limit bytes of the data log from the 8-byte big-endian
+// position from on, for a follower, and takes a superuser if the server
+// requires authentication.
+//
+// A response frame holds a status byte and its fields, again each length
+// prefixed: a value for GET, nothing for PUT, DELETE and AUTH, pairs of
+// key and value for SCAN, the 8-byte position and the records for
+// REPLICATE, and a message for an error. DELETE of a missing key and GET
+// of one answer StatusNotFound, as does REPLICATE at the end of the log.
+// A server that requires authentication answers requests other than AUTH
+// with an error until one succeeds.
+
+// Op is the operation of a request.
+type Op byte
+
+const (
+	OpGet Op = iota + 1
+	OpPut
+	OpDelete
+	OpScan
+	OpAuth
+	OpReplicate
+)
+
+var opNames = map[Op]string{
+	OpGet:       "GET",
+	OpPut:       "PUT",
+	OpDelete:    "DELETE",
+	OpScan:      "SCAN",
+	OpAuth:      "AUTH",
+	OpReplicate: "REPLICATE",
+}
+
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("Op(%d)", op)
+}
+
+// Status is the outcome of a request.
+type Status byte
+
+const (
+	StatusOK Status = iota
+	StatusNotFound
+	StatusError
+)
+
+// DefaultMaxFrameSize is the largest frame either side accepts unless
+// configured otherwise.
+const DefaultMaxFrameSize = 16 << 20
+
+var (
+	ErrFrameTooLarge = errors.New("server: frame too large")
+	ErrMalformed     = errors.New("server: malformed frame")
+)
+
+// writeFrame writes a frame of the given fields, each length prefixed,
+// after a leading byte.
+func writeFrame(w io.Writer, lead byte, fields ...[]byte) error {
+	size := 1
+	for _, f := range fields {
+		size += 4 + len(f)
+	}
+	frame := make([]byte, 0, 4+size)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(size))
+	frame = append(frame, lead)
+	for _, f := range fields {
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(f)))
+		frame = append(frame, f...)
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads a frame of at most maxSize bytes and splits it into its
+// leading byte and fields.
+func readFrame(r io.Reader, maxSize int) (byte, [][]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > uint32(maxSize) {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if size == 0 {
+		return 0, nil, ErrMalformed
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	var fields [][]byte
+	for rest := frame[1:]; len(rest) > 0; {
+		if len(rest) < 4 || uint32(len(rest)-4) < binary.BigEndian.Uint32(rest) {
+			return 0, nil, ErrMalformed
+		}
+		n := binary.BigEndian.Uint32(rest)
+		fields = append(fields, rest[4:4+n])
+		rest = rest[4+n:]
+	}
+	return frame[0], fields, nil
+}
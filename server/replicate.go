@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/trace"
+)
+
+// DefaultPollInterval is how long a follower waits before asking its
+// leader again once it has caught up, unless configured otherwise.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// FollowOptions configures Follow. Zero values use the defaults.
+type FollowOptions struct {
+	// User and Password log in to a leader that requires authentication,
+	// as a superuser.
+	User     string
+	Password string
+	// TLS, if set, connects to the leader over TLS.
+	TLS *tls.Config
+	// PollInterval is how long the follower waits before asking again
+	// once it has caught up, and before reconnecting to a leader it lost.
+	PollInterval time.Duration
+	// MaxBytes is about the most log a request asks for. Zero takes the
+	// frame size of the leader.
+	MaxBytes int
+	// Logger receives the errors that end connections to the leader. Nil
+	// means slog.Default().
+	Logger trace.Logger
+}
+
+// Follow replicates the database served with the binary protocol at the
+// TCP address addr into database, which has to be opened with
+// db.Options.Follower, until ctx is done. It reconnects whenever the
+// connection fails and carries on from where the follower ends, however
+// far behind that is. The follower applies the log under the lock its
+// servers share, so it can serve reads as it follows.
+//
+// Follow returns ctx.Err() once ctx is done, which is how to stop it
+// before promoting the follower with db.Database.Promote. It returns
+// early with the error if the leader turns down its requests, or if the
+// log of the leader cannot be applied, as when the two parted ways.
+func Follow(ctx context.Context, database *db.Database, addr string, opts FollowOptions) error {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	logger := trace.OrDefault(opts.Logger)
+	for {
+		err := follow(ctx, database, addr, opts)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var remote *RemoteError
+		var apply *applyError
+		if errors.As(err, &remote) {
+			return err
+		}
+		if errors.As(err, &apply) {
+			return apply.err
+		}
+		logger.Warn("server: lost leader", "leader", addr, "err", err)
+		if !sleep(ctx, opts.PollInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// applyError is an error of the follower rather than of the connection.
+type applyError struct {
+	err error
+}
+
+func (e *applyError) Error() string {
+	return e.err.Error()
+}
+
+// follow replicates the leader over one connection until it fails.
+func follow(ctx context.Context, database *db.Database, addr string, opts FollowOptions) error {
+	var conn net.Conn
+	var err error
+	if opts.TLS != nil {
+		conn, err = (&tls.Dialer{Config: opts.TLS}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	client := NewClient(conn)
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	if opts.User != "" {
+		if err := client.Auth(opts.User, opts.Password); err != nil {
+			return err
+		}
+	}
+	mu := database.ServeLock()
+	for {
+		mu.Lock()
+		from := database.LogEnd()
+		mu.Unlock()
+		pos, data, err := client.ReadLog(from, opts.MaxBytes)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			if !sleep(ctx, opts.PollInterval) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		mu.Lock()
+		err = database.ApplyLog(pos, data)
+		mu.Unlock()
+		if err != nil {
+			return &applyError{err}
+		}
+	}
+}
+
+// sleep waits for d, and reports false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/storage"
+	"github.com/rizalta/toydb/tuple"
+)
+
+// waitFor polls cond under the lock of database until it holds.
+func waitFor(t *testing.T, database *db.Database, cond func() bool) {
+	t.Helper()
+	mu := database.ServeLock()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		mu.Lock()
+		ok := cond()
+		mu.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for the follower")
+}
+
+func TestFollow(t *testing.T) {
+	leader := newTestDB(t, db.Options{})
+	addr := serve(t, New(leader, Options{Logger: quiet}))
+	follower := newTestDB(t, db.Options{Follower: true})
+
+	columns := []catalog.Column{
+		{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+		{Name: "name", Type: catalog.TypeVarChar},
+	}
+	mu := leader.ServeLock()
+	mu.Lock()
+	_, err := leader.CreateTable("users", columns)
+	if err == nil {
+		err = leader.Insert("users", tuple.Tuple{int64(1), "ann"})
+	}
+	mu.Unlock()
+	if err != nil {
+		t.Fatalf("failed to write to the leader: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Follow(ctx, follower, addr, FollowOptions{PollInterval: time.Millisecond, MaxBytes: 64, Logger: quiet})
+	}()
+	waitFor(t, follower, func() bool {
+		_, found, _ := follower.Get("users", int64(1))
+		return found
+	})
+
+	// Writes keep flowing once the follower has caught up.
+	client := dial(t, addr)
+	if err := client.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	waitFor(t, follower, func() bool {
+		_, found, _ := follower.KV().Get([]byte("key"))
+		return found
+	})
+	mu = follower.ServeLock()
+	mu.Lock()
+	err = follower.KV().Put([]byte("key"), []byte("other"))
+	mu.Unlock()
+	if !errors.Is(err, storage.ErrReadOnly) {
+		t.Errorf("expected %v, got %v", storage.ErrReadOnly, err)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v from Follow, got %v", context.Canceled, err)
+	}
+	if err := follower.Promote(); err != nil {
+		t.Fatalf("failed to promote: %v", err)
+	}
+	schema, err := follower.CreateTable("orders", columns)
+	if err != nil {
+		t.Fatalf("failed to create table on the promoted follower: %v", err)
+	}
+	users, err := follower.Schema("users")
+	if err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+	if schema.ID == users.ID {
+		t.Errorf("expected a new table ID, got the ID of users, %d", users.ID)
+	}
+}
+
+func TestFollowAuth(t *testing.T) {
+	addr := serve(t, New(newTestDB(t, db.Options{}), Options{RequireAuth: true, Logger: quiet}))
+	follower := newTestDB(t, db.Options{Follower: true})
+
+	err := Follow(context.Background(), follower, addr, FollowOptions{Logger: quiet})
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Message != ErrAuthRequired.Error() {
+		t.Errorf("expected %v, got %v", ErrAuthRequired, err)
+	}
+}
@@ -0,0 +1,587 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+)
+
+// RESP2 requests are arrays of bulk strings, or inline commands of words
+// on a line as typed into telnet, and map onto the key-value namespace:
+//
+//	GET key
+//	SET key value [EX seconds | PX milliseconds] [NX | XX]
+//	DEL key [key ...]
+//	EXPIRE key seconds
+//	SCAN cursor [MATCH pattern] [COUNT count]
+//	AUTH [user] password
+//
+// PING, ECHO, SELECT 0, COMMAND and QUIT are answered as well, for the
+// handshakes of clients. AUTH without a user logs in as "default", as in
+// Redis. SCAN cursors are kept by the server, which
+// forgets the oldest past maxCursors.
+
+const (
+	// respBufferSize is the longest line a RESP connection reads, which
+	// bounds inline commands.
+	respBufferSize = 64 << 10
+	// maxRESPArgs is the most arguments a RESP command takes.
+	maxRESPArgs = 1 << 20
+	// defaultScanCount is the number of keys a SCAN examines unless told.
+	defaultScanCount = 10
+	maxCursors       = 1024
+)
+
+// serveRESP answers the commands of a connection until the client closes
+// it or sends QUIT. A protocol error is answered and ends the connection.
+func (s *Server) serveRESP(conn net.Conn) error {
+	r := bufio.NewReaderSize(conn, respBufferSize)
+	w := bufio.NewWriter(conn)
+	var user string
+	for {
+		args, err := readCommand(r, s.opts.MaxFrameSize)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, ErrFrameTooLarge) || errors.Is(err, ErrMalformed) {
+			writeError(w, "ERR Protocol error: "+err.Error())
+			w.Flush()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		var quit bool
+		if strings.EqualFold(string(args[0]), "auth") {
+			s.authRESP(w, &user, args[1:])
+		} else {
+			quit = s.handleRESP(w, user, args)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// readCommand reads a command and returns its arguments, none for an
+// empty array or line.
+func readCommand(r *bufio.Reader, maxSize int) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		var args [][]byte
+		for _, field := range bytes.Fields(line) {
+			args = append(args, bytes.Clone(field))
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrMalformed)
+	}
+	args := make([][]byte, 0, max(n, 0))
+	for range n {
+		line, err := readLine(r)
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got %q", ErrMalformed, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%w: invalid bulk length", ErrMalformed)
+		}
+		if size > maxSize {
+			return nil, ErrFrameTooLarge
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string not terminated", ErrMalformed)
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readLine reads a line and strips its line ending. The line is only
+// valid until the next read.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, ErrFrameTooLarge
+	}
+	if errors.Is(err, io.EOF) && len(line) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return line, nil
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	w.WriteString("-" + msg + "\r\n")
+}
+
+func writeInteger(w *bufio.Writer, n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func writeBulk(w *bufio.Writer, b []byte) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func writeNull(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func writeArray(w *bufio.Writer, n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// authRESP logs a connection in as the user of an AUTH command.
+func (s *Server) authRESP(w *bufio.Writer, user *string, args [][]byte) {
+	if len(args) == 1 {
+		args = [][]byte{[]byte("default"), args[0]}
+	}
+	if len(args) != 2 {
+		writeError(w, "ERR wrong number of arguments for 'auth' command")
+		return
+	}
+	p, err := login(s.mu, s.db, string(args[0]), string(args[1]))
+	if errors.Is(err, catalog.ErrAuthFailed) {
+		writeError(w, "WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	*user = p.Name
+	writeSimple(w, "OK")
+}
+
+// respPrivileges are the privileges each command needs on the key-value
+// namespace.
+var respPrivileges = map[string]catalog.Privilege{
+	"get":    catalog.PrivSelect,
+	"scan":   catalog.PrivSelect,
+	"set":    catalog.PrivInsert | catalog.PrivUpdate,
+	"expire": catalog.PrivUpdate,
+	"del":    catalog.PrivDelete,
+}
+
+// handleRESP runs a command of user, writes its reply and reports whether
+// the client asked to quit.
+func (s *Server) handleRESP(w *bufio.Writer, user string, args [][]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.ToLower(string(args[0]))
+	args = args[1:]
+	if s.opts.RequireAuth && name != "quit" {
+		var err error
+		if user == "" {
+			err = ErrAuthRequired
+		} else if need, ok := respPrivileges[name]; ok {
+			err = authorize(s.db, user, catalog.KeyValue, need)
+		}
+		switch {
+		case errors.Is(err, ErrAuthRequired):
+			writeError(w, "NOAUTH Authentication required.")
+			return false
+		case errors.Is(err, ErrPermissionDenied):
+			writeError(w, fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", user, name))
+			return false
+		case err != nil:
+			writeError(w, "ERR "+err.Error())
+			return false
+		}
+	}
+	arity := func(ok bool) bool {
+		if !ok {
+			writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		}
+		return ok
+	}
+	fail := func(err error) {
+		writeError(w, "ERR "+err.Error())
+	}
+
+	switch name {
+	case "ping":
+		if !arity(len(args) <= 1) {
+			break
+		}
+		if len(args) == 1 {
+			writeBulk(w, args[0])
+		} else {
+			writeSimple(w, "PONG")
+		}
+
+	case "echo":
+		if arity(len(args) == 1) {
+			writeBulk(w, args[0])
+		}
+
+	case "select":
+		if !arity(len(args) == 1) {
+			break
+		}
+		if string(args[0]) != "0" {
+			writeError(w, "ERR DB index is out of range")
+			break
+		}
+		writeSimple(w, "OK")
+
+	case "command":
+		writeArray(w, 0)
+
+	case "quit":
+		writeSimple(w, "OK")
+		return true
+
+	case "get":
+		if !arity(len(args) == 1) {
+			break
+		}
+		value, found, err := s.kv.Get(args[0])
+		switch {
+		case err != nil:
+			fail(err)
+		case !found:
+			writeNull(w)
+		default:
+			writeBulk(w, value)
+		}
+
+	case "set":
+		if arity(len(args) >= 2) {
+			s.set(w, args)
+		}
+
+	case "del":
+		if !arity(len(args) >= 1) {
+			break
+		}
+		deleted := 0
+		for _, key := range args {
+			ok, err := s.kv.Delete(key)
+			if err != nil {
+				fail(err)
+				return false
+			}
+			if ok {
+				deleted++
+			}
+		}
+		writeInteger(w, deleted)
+
+	case "expire":
+		if !arity(len(args) == 2) {
+			break
+		}
+		seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || seconds > maxTTLSeconds || seconds < -maxTTLSeconds {
+			writeError(w, "ERR value is not an integer or out of range")
+			break
+		}
+		expired, err := s.kv.Expire(args[0], time.Duration(seconds)*time.Second)
+		if err != nil {
+			fail(err)
+			break
+		}
+		writeInteger(w, boolInt(expired))
+
+	case "scan":
+		if arity(len(args) >= 1) {
+			s.scan(w, args)
+		}
+
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+	return false
+}
+
+// maxTTLSeconds keeps a TTL in seconds within a time.Duration.
+const maxTTLSeconds = int64(1<<63-1) / int64(time.Second)
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) set(w *bufio.Writer, args [][]byte) {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	var nx, xx bool
+	for opts := args[2:]; len(opts) > 0; opts = opts[1:] {
+		switch opt := strings.ToLower(string(opts[0])); {
+		case (opt == "ex" || opt == "px") && ttl == 0 && len(opts) > 1:
+			n, err := strconv.ParseInt(string(opts[1]), 10, 64)
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+			if err != nil || n <= 0 || n > int64(1<<63-1)/int64(unit) {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+			opts = opts[1:]
+		case opt == "nx" && !xx:
+			nx = true
+		case opt == "xx" && !nx:
+			xx = true
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+
+	if nx || xx {
+		_, found, err := s.kv.Get(key)
+		if err != nil {
+			writeError(w, "ERR "+err.Error())
+			return
+		}
+		if found == nx {
+			writeNull(w)
+			return
+		}
+	}
+	var err error
+	if ttl > 0 {
+		err = s.kv.PutWithTTL(key, value, ttl)
+	} else {
+		err = s.kv.Put(key, value)
+	}
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return
+	}
+	writeSimple(w, "OK")
+}
+
+// scan answers SCAN with the keys it examines that match, and a cursor
+// that resumes from the first key it did not examine, or 0 at the end.
+func (s *Server) scan(w *bufio.Writer, args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		writeError(w, "ERR invalid cursor")
+		return
+	}
+	start, ok := s.cursors.load(cursor)
+	if !ok {
+		writeError(w, "ERR invalid cursor")
+		return
+	}
+
+	var pattern []byte
+	count := defaultScanCount
+	for opts := args[1:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			writeError(w, "ERR syntax error")
+			return
+		}
+		switch strings.ToLower(string(opts[0])) {
+		case "match":
+			pattern = opts[1]
+		case "count":
+			count, err = strconv.Atoi(string(opts[1]))
+			if err != nil || count < 1 {
+				writeError(w, "ERR syntax error")
+				return
+			}
+		default:
+			writeError(w, "ERR syntax error")
+			return
+		}
+	}
+	count = min(count, s.opts.ScanLimit)
+
+	var keys [][]byte
+	var next uint64
+	examined := 0
+	for pair, err := range s.kv.Scan(start, nil) {
+		if err != nil {
+			writeError(w, "ERR "+err.Error())
+			return
+		}
+		if examined == count {
+			next = s.cursors.save(pair.Key)
+			break
+		}
+		examined++
+		if pattern == nil || matchGlob(pattern, pair.Key) {
+			keys = append(keys, pair.Key)
+		}
+	}
+
+	writeArray(w, 2)
+	writeBulk(w, strconv.AppendUint(nil, next, 10))
+	writeArray(w, len(keys))
+	for _, key := range keys {
+		writeBulk(w, key)
+	}
+}
+
+// cursors holds the keys SCAN cursors resume from. Cursor 0 starts from
+// the first key.
+type cursors struct {
+	last  uint64
+	keys  map[uint64][]byte
+	order []uint64
+}
+
+func newCursors() *cursors {
+	return &cursors{keys: make(map[uint64][]byte)}
+}
+
+func (c *cursors) save(key []byte) uint64 {
+	c.last++
+	c.keys[c.last] = key
+	c.order = append(c.order, c.last)
+	if len(c.order) > maxCursors {
+		delete(c.keys, c.order[0])
+		c.order = c.order[1:]
+	}
+	return c.last
+}
+
+func (c *cursors) load(cursor uint64) ([]byte, bool) {
+	if cursor == 0 {
+		return nil, true
+	}
+	key, ok := c.keys[cursor]
+	return key, ok
+}
+
+// matchGlob reports whether key matches a Redis glob pattern, where * is
+// any run of bytes, ? any byte, [...] a class of bytes and ranges, negated
+// by a leading ^, and \ escapes the byte after it.
+func matchGlob(pattern, key []byte) bool {
+	// On a mismatch, the last * seen takes one more byte of key.
+	starP, starK := -1, 0
+	p, k := 0, 0
+	for k < len(key) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starK = p, k
+				p++
+				continue
+			case '?':
+				p++
+				k++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern[p:], key[k]); end > 0 {
+					if ok {
+						p += end
+						k++
+						continue
+					}
+					break
+				}
+				if key[k] == '[' {
+					p++
+					k++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == key[k] {
+					p += 2
+					k++
+					continue
+				}
+			default:
+				if pattern[p] == key[k] {
+					p++
+					k++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starK++
+		p, k = starP+1, starK
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches b against the class that starts pattern and returns
+// the length of the class, or 0 if it is not closed.
+func matchClass(pattern []byte, b byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for first := true; i < len(pattern); first = false {
+		c := pattern[i]
+		if c == ']' && !first {
+			return i + 1, matched != negate
+		}
+		if c == '\\' && i+1 < len(pattern) {
+			i++
+			c = pattern[i]
+		}
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			lo, hi := c, pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= b && b <= hi)
+			i += 3
+			continue
+		}
+		matched = matched || c == b
+		i++
+	}
+	return 0, false
+}
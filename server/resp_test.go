@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/db"
+)
+
+// command encodes args as a RESP array of bulk strings.
+func command(args ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.String()
+}
+
+func TestRESP(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(1000, 0))
+	addr := serve(t, NewRESP(newTestDB(t, db.Options{Clock: clk}), Options{}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		request string
+		reply   string
+		advance time.Duration
+	}{
+		{request: command("PING"), reply: "+PONG\r\n"},
+		{request: "ping hello\r\n", reply: "$5\r\nhello\r\n"},
+		{request: command("SELECT", "0"), reply: "+OK\r\n"},
+		{request: command("SELECT", "1"), reply: "-ERR DB index is out of range\r\n"},
+		{request: command("COMMAND", "DOCS"), reply: "*0\r\n"},
+		{request: command("GET", "a"), reply: "$-1\r\n"},
+		{request: command("SET", "a", "1"), reply: "+OK\r\n"},
+		{request: command("set", "b", ""), reply: "+OK\r\n"},
+		{request: command("GET", "a"), reply: "$1\r\n1\r\n"},
+		{request: command("GET", "b"), reply: "$0\r\n\r\n"},
+		{request: command("SET", "a", "2", "NX"), reply: "$-1\r\n"},
+		{request: command("SET", "c", "3", "XX"), reply: "$-1\r\n"},
+		{request: command("SET", "c", "3", "NX", "EX", "10"), reply: "+OK\r\n"},
+		{request: command("SET", "d", "4", "PX", "25000"), reply: "+OK\r\n"},
+		{request: command("SET", "e", "5", "EX", "0"), reply: "-ERR invalid expire time in 'set' command\r\n"},
+		{request: command("SET", "e", "5", "NX", "XX"), reply: "-ERR syntax error\r\n"},
+		{request: command("SET", "e"), reply: "-ERR wrong number of arguments for 'set' command\r\n"},
+		{request: command("SET", "", "5"), reply: "-ERR db: empty key\r\n"},
+		{request: command("EXPIRE", "a", "30"), reply: ":1\r\n"},
+		{request: command("EXPIRE", "missing", "30"), reply: ":0\r\n"},
+		{request: command("EXPIRE", "a", "soon"), reply: "-ERR value is not an integer or out of range\r\n"},
+		{request: command("GET", "c"), reply: "$-1\r\n", advance: 10 * time.Second},
+		{request: command("GET", "d"), reply: "$1\r\n4\r\n"},
+		{request: command("GET", "a"), reply: "$-1\r\n", advance: 20 * time.Second},
+		{request: command("GET", "d"), reply: "$-1\r\n"},
+		{request: command("MSET", "x", "1"), reply: "-ERR unknown command 'mset'\r\n"},
+		{request: command("DEL", "b", "missing", "f"), reply: ":1\r\n"},
+		{request: command("GET", "b"), reply: "$-1\r\n"},
+		{request: command("EXPIRE", "b", "-1"), reply: ":0\r\n"},
+	}
+	for _, tt := range tests {
+		clk.Advance(tt.advance)
+		if _, err := io.WriteString(conn, tt.request); err != nil {
+			t.Fatalf("failed to write %q: %v", tt.request, err)
+		}
+		reply := make([]byte, len(tt.reply))
+		if _, err := io.ReadFull(r, reply); err != nil {
+			t.Fatalf("%q: failed to read reply: %v", tt.request, err)
+		}
+		if string(reply) != tt.reply {
+			t.Fatalf("%q: expected %q, got %q", tt.request, tt.reply, reply)
+		}
+	}
+
+	if _, err := io.WriteString(conn, command("QUIT")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if reply, err := r.ReadString('\n'); err != nil || reply != "+OK\r\n" {
+		t.Errorf("expected +OK, got %q, err %v", reply, err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("expected the server to hang up after QUIT, got %v", err)
+	}
+}
+
+// readScan reads a SCAN reply.
+func readScan(t *testing.T, r *bufio.Reader) (string, []string) {
+	t.Helper()
+
+	line := func() string {
+		t.Helper()
+		s, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read reply: %v", err)
+		}
+		return strings.TrimSuffix(s, "\r\n")
+	}
+	bulk := func() string {
+		t.Helper()
+		line()
+		return line()
+	}
+	if header := line(); header != "*2" {
+		t.Fatalf("expected a SCAN reply, got %q", header)
+	}
+	cursor := bulk()
+	var n int
+	fmt.Sscanf(line(), "*%d", &n)
+	keys := []string{}
+	for range n {
+		keys = append(keys, bulk())
+	}
+	return cursor, keys
+}
+
+func TestRESPScan(t *testing.T) {
+	database := newTestDB(t, db.Options{})
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1", "user:3", "session"} {
+		if err := database.KV().Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	addr := serve(t, NewRESP(database, Options{ScanLimit: 4}))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		opts []string
+		keys []string
+	}{
+		{nil, []string{"order:1", "session", "user:1", "user:10", "user:2", "user:3"}},
+		{[]string{"MATCH", "user:?"}, []string{"user:1", "user:2", "user:3"}},
+		{[]string{"MATCH", "user:[^2-3]*", "COUNT", "2"}, []string{"user:1", "user:10"}},
+		{[]string{"COUNT", "100"}, []string{"order:1", "session", "user:1", "user:10", "user:2", "user:3"}},
+	}
+	for _, tt := range tests {
+		cursor, calls := "0", 0
+		keys := []string{}
+		for {
+			io.WriteString(conn, command(append([]string{"SCAN", cursor}, tt.opts...)...))
+			var page []string
+			cursor, page = readScan(t, r)
+			keys = append(keys, page...)
+			calls++
+			if cursor == "0" {
+				break
+			}
+		}
+		if strings.Join(keys, " ") != strings.Join(tt.keys, " ") {
+			t.Errorf("SCAN %v: expected %v, got %v", tt.opts, tt.keys, keys)
+		}
+		// The scan limit caps COUNT, so six keys take two calls at most
+		// four at a time.
+		if calls < 2 {
+			t.Errorf("SCAN %v: expected several calls, got %d", tt.opts, calls)
+		}
+	}
+
+	io.WriteString(conn, command("SCAN", "12345"))
+	if reply, _ := r.ReadString('\n'); reply != "-ERR invalid cursor\r\n" {
+		t.Errorf("expected an invalid cursor error, got %q", reply)
+	}
+}
+
+func TestRESPProtocolErrors(t *testing.T) {
+	addr := serve(t, NewRESP(newTestDB(t, db.Options{}), Options{MaxFrameSize: 8, Logger: quiet}))
+
+	tests := []struct {
+		name    string
+		request string
+		reply   string
+	}{
+		{"bulk too large", "*1\r\n$9\r\n", "-ERR Protocol error: server: frame too large\r\n"},
+		{"missing bulk", "*1\r\n:1\r\n", "-ERR Protocol error: server: malformed frame: expected '$', got \":1\"\r\n"},
+		{"bad length", "*x\r\n", "-ERR Protocol error: server: malformed frame: invalid multibulk length\r\n"},
+		{"unterminated", "*1\r\n$4\r\nPINGxx", "-ERR Protocol error: server: malformed frame: bulk string not terminated\r\n"},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		io.WriteString(conn, tt.request)
+		reply, err := io.ReadAll(conn)
+		if err != nil || string(reply) != tt.reply {
+			t.Errorf("%s: expected %q and a hang-up, got %q, err %v", tt.name, tt.reply, reply, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "order:1", false},
+		{"*:1", "order:1", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"[", "[", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob([]byte(tt.pattern), []byte(tt.key)); got != tt.match {
+			t.Errorf("matchGlob(%q, %q): expected %v, got %v", tt.pattern, tt.key, tt.match, got)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Package server serves the key-value namespace of a database over TCP,
+// either with the length-prefixed binary protocol described in protocol.go,
+// for which it provides a client, or with RESP2 for Redis clients. It also
+// serves the tables of a database as JSON over HTTP. Each can run over TLS,
+// with client certificates if required. Follow keeps a follower database
+// in step with a leader served with the binary protocol.
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/rizalta/toydb/catalog"
+	"github.com/rizalta/toydb/db"
+	"github.com/rizalta/toydb/trace"
+)
+
+const (
+	// DefaultScanLimit is the most pairs a scan response holds unless
+	// configured otherwise.
+	DefaultScanLimit = 1000
+	// DefaultMaxRows and DefaultMaxBytes cap the rows of an HTTP response
+	// unless configured otherwise.
+	DefaultMaxRows  = 10000
+	DefaultMaxBytes = 16 << 20
+)
+
+var ErrServerClosed = errors.New("server: closed")
+
+// Options configures a Server. Zero values use the defaults.
+type Options struct {
+	// MaxFrameSize is the largest request frame the server reads, or
+	// with RESP the largest argument. A connection that sends a larger
+	// one is answered with an error and closed.
+	MaxFrameSize int
+	// ScanLimit is the most pairs a scan response holds, whatever the
+	// limit of the request, or with RESP the most keys a SCAN examines.
+	ScanLimit int
+	// MaxRows and MaxBytes cap the rows of an HTTP response and the bytes
+	// of JSON they take, whatever the limits of the request.
+	MaxRows  int
+	MaxBytes int
+	// RequireAuth makes clients log in as a user of the database before
+	// anything else, and checks the grants of the user on every request.
+	// The key-value namespace takes grants on catalog.KeyValue: reads need
+	// SELECT, PUT and SET need INSERT and UPDATE, EXPIRE needs UPDATE and
+	// DELETE and DEL need DELETE. Creating a table over HTTP takes a
+	// superuser.
+	RequireAuth bool
+	// TLS, if set, makes Serve and ListenAndServe accept TLS connections
+	// only. LoadTLSConfig builds one from PEM files. NewHTTP leaves TLS to
+	// the http.Server, which takes the same config as its TLSConfig.
+	TLS *tls.Config
+	// Logger receives the errors that end connections. Nil means
+	// slog.Default().
+	Logger trace.Logger
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.MaxFrameSize <= 0 {
+		opts.MaxFrameSize = DefaultMaxFrameSize
+	}
+	if opts.ScanLimit <= 0 {
+		opts.ScanLimit = DefaultScanLimit
+	}
+	if opts.MaxRows <= 0 {
+		opts.MaxRows = DefaultMaxRows
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	return opts
+}
+
+// Server serves GET, PUT, DELETE and SCAN requests against the key-value
+// namespace of a database. Requests run one at a time, as the database is
+// used by a single goroutine, whatever the number of connections and of
+// servers of the database.
+type Server struct {
+	db     *db.Database
+	kv     *db.KV
+	opts   Options
+	logger trace.Logger
+	serve  func(net.Conn) error
+
+	// mu is the lock of the database, which serializes requests. It
+	// also guards cursors.
+	mu      *sync.Mutex
+	cursors *cursors
+
+	// connMu guards listeners, conns and closed.
+	connMu    sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a server of database that speaks the binary protocol. The
+// database has to stay open until the server is closed.
+func New(database *db.Database, opts Options) *Server {
+	s := newServer(database, opts)
+	s.serve = s.serveConn
+	return s
+}
+
+// NewRESP returns a server of database that speaks RESP2, for redis-cli
+// and Redis client libraries. The database has to stay open until the
+// server is closed.
+func NewRESP(database *db.Database, opts Options) *Server {
+	s := newServer(database, opts)
+	s.serve = s.serveRESP
+	s.cursors = newCursors()
+	return s
+}
+
+func newServer(database *db.Database, opts Options) *Server {
+	opts = opts.withDefaults()
+	return &Server{
+		db:        database,
+		kv:        database.KV(),
+		mu:        database.ServeLock(),
+		opts:      opts,
+		logger:    trace.OrDefault(opts.Logger),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves the
+// connections it accepts until the server is closed, over TLS if
+// Options.TLS is set.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the connections l accepts until the server is closed, when
+// it returns ErrServerClosed. It closes l. With Options.TLS set, the
+// connections have to complete a TLS handshake first.
+func (s *Server) Serve(l net.Listener) error {
+	if s.opts.TLS != nil {
+		l = tls.NewListener(l, s.opts.TLS)
+	}
+	if !s.track(l, nil) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(nil, conn)
+			if err := s.serve(conn); err != nil && !s.isClosed() {
+				s.logger.Warn("server: connection failed", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+		}()
+	}
+}
+
+// Close stops the listeners and closes every connection, waiting for the
+// requests in progress to finish. It leaves the database open.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) isClosed() bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.closed
+}
+
+// track adds a listener or a connection to those Close closes, unless the
+// server is closed already.
+func (s *Server) track(l net.Listener, conn net.Conn) bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	} else {
+		s.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, conn net.Conn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if l != nil {
+		delete(s.listeners, l)
+	} else {
+		delete(s.conns, conn)
+		conn.Close()
+	}
+}
+
+// serveConn answers the requests of a connection until the client closes
+// it. A malformed frame is answered with an error and ends the connection,
+// as the stream cannot be trusted past it.
+func (s *Server) serveConn(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var user string
+	for {
+		op, args, err := readFrame(r, s.opts.MaxFrameSize)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, ErrFrameTooLarge) || errors.Is(err, ErrMalformed) {
+			writeFrame(w, byte(StatusError), []byte(err.Error()))
+			w.Flush()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		var status Status
+		var fields [][]byte
+		if Op(op) == OpAuth {
+			status, fields = s.auth(&user, args)
+		} else {
+			status, fields = s.handle(user, Op(op), args)
+		}
+		if err := writeFrame(w, byte(status), fields...); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+var errArguments = errors.New("server: wrong number of arguments")
+
+func fail(err error) (Status, [][]byte) {
+	return StatusError, [][]byte{[]byte(err.Error())}
+}
+
+// auth logs a connection in as the user of the request.
+func (s *Server) auth(user *string, args [][]byte) (Status, [][]byte) {
+	if len(args) != 2 {
+		return fail(errArguments)
+	}
+	p, err := login(s.mu, s.db, string(args[0]), string(args[1]))
+	if err != nil {
+		return fail(err)
+	}
+	*user = p.Name
+	return StatusOK, nil
+}
+
+// kvPrivileges are the privileges each op needs on the key-value
+// namespace.
+var kvPrivileges = map[Op]catalog.Privilege{
+	OpGet:    catalog.PrivSelect,
+	OpPut:    catalog.PrivInsert | catalog.PrivUpdate,
+	OpDelete: catalog.PrivDelete,
+	OpScan:   catalog.PrivSelect,
+}
+
+// handle runs a request of user and returns the status and fields of its
+// response.
+func (s *Server) handle(user string, op Op, args [][]byte) (Status, [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if need, ok := kvPrivileges[op]; ok && s.opts.RequireAuth {
+		if err := authorize(s.db, user, catalog.KeyValue, need); err != nil {
+			return fail(err)
+		}
+	}
+	if op == OpReplicate && s.opts.RequireAuth {
+		if err := authorizeSuperuser(s.db, user); err != nil {
+			return fail(err)
+		}
+	}
+	switch op {
+	case OpGet:
+		if len(args) != 1 {
+			return fail(errArguments)
+		}
+		value, found, err := s.kv.Get(args[0])
+		if err != nil {
+			return fail(err)
+		}
+		if !found {
+			return StatusNotFound, nil
+		}
+		return StatusOK, [][]byte{value}
+
+	case OpPut:
+		if len(args) != 2 {
+			return fail(errArguments)
+		}
+		if err := s.kv.Put(args[0], args[1]); err != nil {
+			return fail(err)
+		}
+		return StatusOK, nil
+
+	case OpDelete:
+		if len(args) != 1 {
+			return fail(errArguments)
+		}
+		deleted, err := s.kv.Delete(args[0])
+		if err != nil {
+			return fail(err)
+		}
+		if !deleted {
+			return StatusNotFound, nil
+		}
+		return StatusOK, nil
+
+	case OpScan:
+		if len(args) != 3 || len(args[2]) != 4 {
+			return fail(errArguments)
+		}
+		limit := int(binary.BigEndian.Uint32(args[2]))
+		if limit == 0 || limit > s.opts.ScanLimit {
+			limit = s.opts.ScanLimit
+		}
+		var fields [][]byte
+		for pair, err := range s.kv.Scan(openEnded(args[0]), openEnded(args[1])) {
+			if err != nil {
+				return fail(err)
+			}
+			fields = append(fields, pair.Key, pair.Value)
+			if len(fields) == 2*limit {
+				break
+			}
+		}
+		return StatusOK, fields
+
+	case OpReplicate:
+		if len(args) != 2 || len(args[0]) != 8 || len(args[1]) != 4 {
+			return fail(errArguments)
+		}
+		limit := int(binary.BigEndian.Uint32(args[1]))
+		if limit == 0 || limit > s.opts.MaxFrameSize {
+			limit = s.opts.MaxFrameSize
+		}
+		pos, data, err := s.db.ReadLog(binary.BigEndian.Uint64(args[0]), limit)
+		if err != nil {
+			return fail(err)
+		}
+		if data == nil {
+			return StatusNotFound, nil
+		}
+		return StatusOK, [][]byte{binary.BigEndian.AppendUint64(nil, pos), data}
+
+	default:
+		return fail(ErrMalformed)
+	}
+}
+
+// openEnded turns the empty bound of a scan into an open one.
+func openEnded(bound []byte) []byte {
+	if len(bound) == 0 {
+		return nil
+	}
+	return bound
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/db"
+)
+
+func newTestDB(t *testing.T, opts db.Options) *db.Database {
+	t.Helper()
+
+	database, err := db.Open(db.Memory, opts)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// serve starts srv on a local port and returns its address.
+func serve(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("expected %v from Serve, got %v", ErrServerClosed, err)
+		}
+	})
+	return l.Addr().String()
+}
+
+// quiet drops the warnings of the connections tests break on purpose.
+var quiet = slog.New(slog.DiscardHandler)
+
+func newTestServer(t *testing.T, opts Options) (*Server, string) {
+	t.Helper()
+
+	srv := New(newTestDB(t, db.Options{}), opts)
+	return srv, serve(t, srv)
+}
+
+func dial(t *testing.T, addr string) *Client {
+	t.Helper()
+
+	client, err := Dial(addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestServer(t *testing.T) {
+	_, addr := newTestServer(t, Options{ScanLimit: 3})
+	client := dial(t, addr)
+
+	for i := range 5 {
+		key, value := fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)
+		if err := client.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("failed to put %s: %v", key, err)
+		}
+	}
+	if err := client.Put([]byte("empty"), nil); err != nil {
+		t.Fatalf("failed to put empty: %v", err)
+	}
+
+	if value, found, err := client.Get([]byte("key2")); err != nil || !found || string(value) != "value2" {
+		t.Errorf("expected value2, got %q, found %v, err %v", value, found, err)
+	}
+	if value, found, err := client.Get([]byte("empty")); err != nil || !found || len(value) != 0 {
+		t.Errorf("expected an empty value, got %q, found %v, err %v", value, found, err)
+	}
+	if _, found, err := client.Get([]byte("missing")); err != nil || found {
+		t.Errorf("expected missing to be absent, got found %v, err %v", found, err)
+	}
+
+	if deleted, err := client.Delete([]byte("key0")); err != nil || !deleted {
+		t.Errorf("expected key0 to be deleted, got %v, err %v", deleted, err)
+	}
+	if deleted, err := client.Delete([]byte("key0")); err != nil || deleted {
+		t.Errorf("expected key0 to be gone, got %v, err %v", deleted, err)
+	}
+
+	var remote *RemoteError
+	if err := client.Put(nil, []byte("value")); !errors.As(err, &remote) || remote.Message != db.ErrEmptyKey.Error() {
+		t.Errorf("expected a remote %v, got %v", db.ErrEmptyKey, err)
+	}
+
+	tests := []struct {
+		start, end string
+		limit      int
+		keys       []string
+	}{
+		{"", "", 0, []string{"empty", "key1", "key2"}},
+		{"key1", "key4", 0, []string{"key1", "key2", "key3"}},
+		{"key2", "", 1, []string{"key2"}},
+		{"key3", "", 10, []string{"key3", "key4"}},
+		{"x", "", 0, []string{}},
+	}
+	for _, tt := range tests {
+		pairs, err := client.Scan([]byte(tt.start), []byte(tt.end), tt.limit)
+		if err != nil {
+			t.Fatalf("failed to scan [%q, %q): %v", tt.start, tt.end, err)
+		}
+		keys := []string{}
+		for _, pair := range pairs {
+			keys = append(keys, string(pair.Key))
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("scan [%q, %q) limit %d: expected %v, got %v", tt.start, tt.end, tt.limit, tt.keys, keys)
+		}
+	}
+}
+
+func TestServerConnections(t *testing.T) {
+	_, addr := newTestServer(t, Options{})
+
+	const clients = 4
+	errs := make(chan error, clients)
+	for c := range clients {
+		client := dial(t, addr)
+		go func() {
+			for i := range 50 {
+				key := fmt.Appendf(nil, "c%d-%d", c, i)
+				if err := client.Put(key, key); err != nil {
+					errs <- err
+					return
+				}
+				if value, found, err := client.Get(key); err != nil || !found || string(value) != string(key) {
+					errs <- fmt.Errorf("expected %s, got %q, found %v, err %v", key, value, found, err)
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for range clients {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	client := dial(t, addr)
+	pairs, err := client.Scan(nil, nil, 0)
+	if err != nil || len(pairs) != clients*50 {
+		t.Errorf("expected %d pairs, got %d, err %v", clients*50, len(pairs), err)
+	}
+}
+
+func TestServerFrames(t *testing.T) {
+	_, addr := newTestServer(t, Options{MaxFrameSize: 64, Logger: quiet})
+
+	tests := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"too large", binary.BigEndian.AppendUint32(nil, 65), ErrFrameTooLarge},
+		{"empty", binary.BigEndian.AppendUint32(nil, 0), ErrMalformed},
+		{"short field", []byte{0, 0, 0, 3, byte(OpGet), 0, 9}, ErrMalformed},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		if _, err := conn.Write(tt.frame); err != nil {
+			t.Fatalf("%s: failed to write: %v", tt.name, err)
+		}
+		status, fields, err := readFrame(conn, DefaultMaxFrameSize)
+		if err != nil || Status(status) != StatusError || len(fields) != 1 || string(fields[0]) != tt.err.Error() {
+			t.Errorf("%s: expected %v, got status %d, fields %q, err %v", tt.name, tt.err, status, fields, err)
+		}
+		// The server hangs up after a bad frame.
+		if _, _, err := readFrame(conn, DefaultMaxFrameSize); err == nil {
+			t.Errorf("%s: expected the connection to be closed", tt.name)
+		}
+		conn.Close()
+	}
+
+	client := dial(t, addr)
+	var remote *RemoteError
+	if _, _, err := client.do(Op(9)); !errors.As(err, &remote) {
+		t.Errorf("expected a remote error for an unknown op, got %v", err)
+	}
+	if _, _, err := client.do(OpGet); !errors.As(err, &remote) || remote.Message != errArguments.Error() {
+		t.Errorf("expected a remote %v, got %v", errArguments, err)
+	}
+	// The connection survives errors of well-formed requests.
+	if err := client.Put([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("failed to put after an error: %v", err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	srv, addr := newTestServer(t, Options{})
+	client := dial(t, addr)
+	if err := client.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if _, _, err := client.Get([]byte("key")); err == nil {
+		t.Error("expected a request to a closed server to fail")
+	}
+	if _, err := Dial(addr); err == nil {
+		t.Error("expected a dial to a closed server to fail")
+	}
+}
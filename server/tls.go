@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+var ErrNoCertificates = errors.New("server: no certificates in CA file")
+
+// LoadTLSConfig returns a TLS configuration for Options.TLS that presents
+// the certificate and key in the PEM files certFile and keyFile. If
+// clientCAFile is not empty, clients have to present a certificate signed
+// by one of the CAs in it.
+func LoadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCertificates
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// DialTLS connects to the server at the TCP address addr over TLS. A nil
+// config verifies the server against the system roots.
+func DialTLS(addr string, config *tls.Config) (*Client, error) {
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
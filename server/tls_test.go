@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/db"
+)
+
+// issue returns a certificate for 127.0.0.1 signed by parent, or a self
+// signed CA if parent is nil, with its key.
+func issue(t *testing.T, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "toydb test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes the certificate and key of cert to files in dir and
+// returns their paths.
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// newTestTLS returns a server config that requires client certificates,
+// and the config of a client that presents one.
+func newTestTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+
+	dir := t.TempDir()
+	ca := issue(t, nil)
+	caFile, _ := writePEM(t, dir, "ca", ca)
+	certFile, keyFile := writePEM(t, dir, "server", issue(t, &ca))
+	config, err := LoadTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	client := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{issue(t, &ca)},
+	}
+	return config, client
+}
+
+func TestTLS(t *testing.T) {
+	config, clientConfig := newTestTLS(t)
+	addr := serve(t, New(newTestDB(t, db.Options{}), Options{TLS: config, Logger: quiet}))
+
+	client, err := DialTLS(addr, clientConfig)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+	if err := client.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if value, found, err := client.Get([]byte("key")); err != nil || !found || string(value) != "value" {
+		t.Errorf("expected value, got %q, %v, %v", value, found, err)
+	}
+
+	// A client without a certificate is turned away during the handshake,
+	// which TLS 1.3 reports on the first read.
+	anonymous, err := DialTLS(addr, &tls.Config{RootCAs: clientConfig.RootCAs})
+	if err == nil {
+		defer anonymous.Close()
+		_, _, err = anonymous.Get([]byte("key"))
+	}
+	if err == nil {
+		t.Error("expected a client without a certificate to fail")
+	}
+
+	plain := dial(t, addr)
+	if _, _, err := plain.Get([]byte("key")); err == nil {
+		t.Error("expected a plain client to fail")
+	}
+}
+
+func TestTLSHTTP(t *testing.T) {
+	config, clientConfig := newTestTLS(t)
+	srv := httptest.NewUnstartedServer(NewHTTP(newTestDB(t, db.Options{}), Options{}))
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	resp, err := client.Get(srv.URL + "/tables")
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writePEM(t, dir, "server", issue(t, nil))
+
+	config, err := LoadTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %v", err)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Errorf("expected no client certificates, got %v", config.ClientAuth)
+	}
+	if _, err := LoadTLSConfig(certFile, keyFile, keyFile); !errors.Is(err, ErrNoCertificates) {
+		t.Errorf("expected %v, got %v", ErrNoCertificates, err)
+	}
+	if _, err := LoadTLSConfig(certFile, filepath.Join(dir, "missing"), ""); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v, got %v", os.ErrNotExist, err)
+	}
+}
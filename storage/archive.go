@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+var ErrNotLogOnly = errors.New("storage: directory holds more than a data log")
+
+// markResolution is how often at most the store stamps the log with the
+// time, and so how close to a point in time RestoreToTime gets.
+const markResolution = time.Millisecond
+
+var segmentName = regexp.MustCompile(`^data-\d{6}\.log$`)
+
+// stamp writes a time record ahead of the record or batch about to be
+// written, if the store archives its log and the time moved on since the
+// last one. It runs between records and batches, like rotateIfFull.
+func (s *Store) stamp() error {
+	if s.archiveDir == "" {
+		return nil
+	}
+	now := s.clock.Now().Truncate(markResolution).UnixNano()
+	if now <= s.stamped {
+		return nil
+	}
+	record := &Record{RecordType: RecordTypeTime, Value: binary.LittleEndian.AppendUint64(nil, uint64(now))}
+	serialized := record.serialize()
+	if err := s.pager.WriteAtOffset(s.offset, serialized); err != nil {
+		return fmt.Errorf("storage: failed to write time record: %w", err)
+	}
+	s.unsynced.Store(true)
+	s.offset += uint64(len(serialized))
+	s.stamped = now
+	return nil
+}
+
+// archiveSealed copies the sealed segments that are not in the archive yet
+// to it. A copy is synced and then renamed into place, so the archive only
+// ever holds whole segments, and one DropDeadSegments emptied since is
+// left as it was archived.
+func (s *Store) archiveSealed() error {
+	if s.archiveDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.archiveDir, 0o755); err != nil {
+		return err
+	}
+
+	s.segments.mu.RLock()
+	paths := s.segments.paths[:len(s.segments.sizes)]
+	sizes := s.segments.sizes
+	s.segments.mu.RUnlock()
+	for ; s.archived < len(sizes); s.archived++ {
+		dst := filepath.Join(s.archiveDir, segmentFile(s.archived))
+		if info, err := os.Stat(dst); err == nil && (info.Size() == int64(sizes[s.archived]) || sizes[s.archived] == 0) {
+			continue
+		}
+		tmp := dst + ".tmp"
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := copyFile(paths[s.archived], tmp, int64(sizes[s.archived])); err != nil {
+			return fmt.Errorf("storage: failed to archive segment: %w", err)
+		}
+		if err := os.Rename(tmp, dst); err != nil {
+			return err
+		}
+	}
+	return syncDir(s.archiveDir)
+}
+
+// RestoreToTime rebuilds in dir the store as it was at time t, to the
+// millisecond, from the time records an archiving store wrote. dir holds
+// the data log to start from, as Restore leaves a base backup, or nothing.
+// Each segment of the log is taken from dir or from one of sources,
+// whichever holds the most of it: sources are archive directories and
+// data directories, such as the one of the store itself, whose last
+// segment the archive does not have yet. The log is then cut at the first
+// time record past t, or at its end if there is none, and the index is
+// rebuilt, or when the log is encrypted left for the first open with its
+// key.
+func RestoreToTime(dir string, t time.Time, sources ...string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if !segmentName.MatchString(entry.Name()) {
+			return fmt.Errorf("%w: %s", ErrNotLogOnly, entry.Name())
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for segment := 0; ; segment++ {
+		dst := filepath.Join(dir, segmentFile(segment))
+		best, size := "", fileSize(dst)
+		for _, source := range sources {
+			path := filepath.Join(source, segmentFile(segment))
+			if n := fileSize(path); n > size {
+				best, size = path, n
+			}
+		}
+		if size < 0 {
+			break
+		}
+		if best == "" {
+			continue
+		}
+		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := copyFile(best, dst, size); err != nil {
+			return err
+		}
+	}
+
+	var end uint64
+	report, err := InspectLog(dir, func(r LogRecord) error {
+		if r.Type == RecordTypeTime && r.Time.After(t) {
+			end = r.Offset
+			return errStopReplay
+		}
+		return nil
+	})
+	if errors.Is(err, errStopReplay) {
+		err = truncateLog(dir, end)
+	} else if err == nil {
+		err = truncateLog(dir, report.End)
+	}
+	if err != nil {
+		return err
+	}
+
+	s, err := NewStore(dir)
+	if errors.Is(err, pager.ErrKeyRequired) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+// fileSize returns the size of the file at path, or -1 if there is none.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// truncateLog cuts the data log in dir, which no store has open, at
+// position end.
+func truncateLog(dir string, end uint64) error {
+	last := segmentOf(end)
+	if err := os.Truncate(filepath.Join(dir, segmentFile(last)), int64(end&segmentOffsetMask)); err != nil {
+		return err
+	}
+	for segment := last + 1; ; segment++ {
+		err := os.Remove(filepath.Join(dir, segmentFile(segment)))
+		if os.IsNotExist(err) {
+			return syncDir(dir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
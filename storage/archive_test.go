@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+)
+
+func TestRestoreToTime(t *testing.T) {
+	dataDir, archiveDir := t.TempDir(), t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewVirtual(start)
+
+	// Segments sealed before the store archived are copied once it does.
+	store, err := NewStoreWithOptions(dataDir, Options{SegmentSize: 1024, Clock: clk})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	putKeys(t, store, 0, 50)
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	store, err = NewStoreWithOptions(dataDir, Options{SegmentSize: 1024, Clock: clk, ArchiveDir: archiveDir})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if got, want := segmentCount(t, archiveDir), segmentCount(t, dataDir)-1; got != want {
+		t.Fatalf("expected %d archived segments, got %d", want, got)
+	}
+
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+	var backup bytes.Buffer
+	if _, err := snapshot.WriteTo(&backup); err != nil {
+		t.Fatalf("failed to write backup: %v", err)
+	}
+
+	// Key i is written at second i from here on, and key_000 is deleted at
+	// second 100.
+	clk.Advance(49 * time.Second)
+	for i := 50; i < 100; i++ {
+		clk.Advance(time.Second)
+		putKeys(t, store, i, i+1)
+	}
+	clk.Advance(time.Second)
+	if _, err := store.Delete([]byte("key_000")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if got, want := segmentCount(t, archiveDir), segmentCount(t, dataDir)-1; got != want {
+		t.Fatalf("expected %d archived segments, got %d", want, got)
+	}
+
+	tests := []struct {
+		name    string
+		at      time.Duration
+		base    bool
+		sources []string
+		keys    int
+		deleted bool
+	}{
+		{"base backup", 75*time.Second + time.Millisecond, true, []string{archiveDir, dataDir}, 76, false},
+		{"archive only", 80 * time.Second, false, []string{archiveDir, dataDir}, 81, false},
+		{"before backup", 20 * time.Second, true, []string{archiveDir, dataDir}, 50, false},
+		{"after delete", time.Hour, false, []string{archiveDir, dataDir}, 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "restored")
+			if tt.base {
+				if err := Restore(bytes.NewReader(backup.Bytes()), dir); err != nil {
+					t.Fatalf("failed to restore backup: %v", err)
+				}
+			}
+			if err := RestoreToTime(dir, start.Add(tt.at), tt.sources...); err != nil {
+				t.Fatalf("failed to restore to time: %v", err)
+			}
+
+			restored, err := NewStore(dir)
+			if err != nil {
+				t.Fatalf("failed to open restored store: %v", err)
+			}
+			defer restored.Close()
+			count, err := restored.Count(nil, nil)
+			if err != nil {
+				t.Fatalf("failed to count: %v", err)
+			}
+			if tt.deleted {
+				count++
+			}
+			if count != tt.keys {
+				t.Errorf("expected %d keys, got %d", tt.keys, count)
+			}
+			if _, found, err := restored.Get([]byte("key_000")); err != nil || found == tt.deleted {
+				t.Errorf("expected key_000 to be found %v, got %v, err %v", !tt.deleted, found, err)
+			}
+			if _, found, err := restored.Get(fmt.Appendf(nil, "key_%03d", tt.keys)); err != nil || found {
+				t.Errorf("expected key_%03d to be missing, got %v, err %v", tt.keys, found, err)
+			}
+		})
+	}
+
+	// Without the data directory, the writes in its last segment are lost.
+	dir := filepath.Join(t.TempDir(), "sealed")
+	if err := RestoreToTime(dir, start.Add(time.Hour), archiveDir); err != nil {
+		t.Fatalf("failed to restore to time: %v", err)
+	}
+	restored, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open restored store: %v", err)
+	}
+	if count, err := restored.Count(nil, nil); err != nil || count < 50 || count >= 100 {
+		t.Errorf("expected the keys of the sealed segments, got %d, err %v", count, err)
+	}
+	restored.Close()
+
+	dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, indexFile), nil, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := RestoreToTime(dir, start, archiveDir); !errors.Is(err, ErrNotLogOnly) {
+		t.Errorf("expected %v, got %v", ErrNotLogOnly, err)
+	}
+}
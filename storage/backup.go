@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidBackup   = errors.New("storage: not a backup of a store")
+	ErrRestoreNotEmpty = errors.New("storage: restore directory is not empty")
+)
+
+// A backup is a tar archive of the segments of the data log, named as in
+// the data directory, and nothing else. The index is left out, as it
+// changes in place and can be rebuilt from the log, which only grows: a
+// store opened on a restored directory finds no index and rebuilds it.
+
+// Snapshot is the data log of a store up to where it ended when the
+// snapshot was taken. Writes after that only add to the log, so the
+// snapshot stays consistent while the store goes on.
+type Snapshot struct {
+	segments []snapshotSegment
+}
+
+type snapshotSegment struct {
+	path string
+	size int64
+	// data holds the segment of a store in memory, which has no file to
+	// read it from later.
+	data []byte
+}
+
+// Snapshot marks the current end of the data log, before the open batch,
+// if any, whose records are not in the log yet. Only taking the snapshot
+// has to be serialized with the other calls to the store; writing it out
+// reads the segment files on its own and can run alongside them. A store
+// in memory is copied instead, and the snapshot holds the copy.
+func (s *Store) Snapshot() (*Snapshot, error) {
+	end := s.offset
+	if s.batch != nil {
+		end = s.batch.start
+	}
+
+	s.segments.mu.RLock()
+	defer s.segments.mu.RUnlock()
+	snapshot := &Snapshot{}
+	last := segmentOf(end)
+	for i := 0; i <= last; i++ {
+		size := end & segmentOffsetMask
+		if i < last {
+			size = s.segments.sizes[i]
+		}
+		segment := snapshotSegment{path: s.segments.paths[i], size: int64(size)}
+		if s.inMemory && size > 0 {
+			data, err := s.segments.segments[i].ReadAtOffset(0, int(size))
+			if err != nil {
+				return nil, err
+			}
+			segment.data = data
+		}
+		snapshot.segments = append(snapshot.segments, segment)
+	}
+	return snapshot, nil
+}
+
+// WriteTo writes the snapshot to w as a backup that Restore reads.
+func (snapshot *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tw := tar.NewWriter(cw)
+	for i, segment := range snapshot.segments {
+		if err := segment.writeTo(tw, segmentFile(i)); err != nil {
+			return cw.n, err
+		}
+	}
+	err := tw.Close()
+	return cw.n, err
+}
+
+func (segment snapshotSegment) writeTo(tw *tar.Writer, name string) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0o644,
+		Size:     segment.size,
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if segment.data != nil || segment.size == 0 {
+		_, err := tw.Write(segment.data)
+		return err
+	}
+
+	f, err := os.Open(segment.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, io.NewSectionReader(f, 0, segment.size))
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Restore writes the backup r holds to dir, which must be empty or not
+// exist yet, for a store to be opened on. The files are synced before it
+// returns.
+func Restore(r io.Reader, dir string) error {
+	if err := checkEmptyDir(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	segments := 0
+	for ; ; segments++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if header.Name != segmentFile(segments) || header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%w: unexpected entry %q", ErrInvalidBackup, header.Name)
+		}
+		if err := restoreFile(filepath.Join(dir, header.Name), tr); err != nil {
+			return err
+		}
+	}
+	if segments == 0 {
+		return fmt.Errorf("%w: no data log", ErrInvalidBackup)
+	}
+	return syncDir(dir)
+}
+
+// checkEmptyDir returns ErrRestoreNotEmpty unless dir is empty or does not
+// exist.
+func checkEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrRestoreNotEmpty
+	}
+	return nil
+}
+
+func restoreFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
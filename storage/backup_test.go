@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func putKeys(t *testing.T, store *Store, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := store.Put(fmt.Appendf(nil, "key_%03d", i), fmt.Appendf(nil, "value_%03d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"disk", Options{SegmentSize: 1024}},
+		{"memory", Options{InMemory: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStoreWithOptions(t.TempDir(), tt.opts)
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			defer store.Close()
+
+			putKeys(t, store, 0, 100)
+			if err := store.BeginBatch(); err != nil {
+				t.Fatalf("failed to begin batch: %v", err)
+			}
+			putKeys(t, store, 100, 110)
+			snapshot, err := store.Snapshot()
+			if err != nil {
+				t.Fatalf("failed to take snapshot: %v", err)
+			}
+
+			// Neither the open batch nor writes after the snapshot are in
+			// the backup, even as they land before it is written out.
+			if err := store.CommitBatch(); err != nil {
+				t.Fatalf("failed to commit batch: %v", err)
+			}
+			putKeys(t, store, 110, 200)
+			if _, err := store.Delete([]byte("key_000")); err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+			var buf bytes.Buffer
+			n, err := snapshot.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("failed to write backup: %v", err)
+			}
+			if n != int64(buf.Len()) {
+				t.Errorf("expected %d bytes written, got %d", buf.Len(), n)
+			}
+
+			dir := filepath.Join(t.TempDir(), "restored")
+			if err := Restore(&buf, dir); err != nil {
+				t.Fatalf("failed to restore: %v", err)
+			}
+			restored, err := NewStoreWithOptions(dir, Options{})
+			if err != nil {
+				t.Fatalf("failed to open restored store: %v", err)
+			}
+			defer restored.Close()
+			checkKeys(t, restored, 100)
+			if count, err := restored.Count(nil, nil); err != nil || count != 100 {
+				t.Errorf("expected 100 keys, got %d, err %v", count, err)
+			}
+		})
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	store, err := NewStoreWithOptions("", Options{InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	putKeys(t, store, 0, 10)
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+	var backup bytes.Buffer
+	if _, err := snapshot.WriteTo(&backup); err != nil {
+		t.Fatalf("failed to write backup: %v", err)
+	}
+
+	full := t.TempDir()
+	if err := os.WriteFile(filepath.Join(full, "file"), nil, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := Restore(bytes.NewReader(backup.Bytes()), full); !errors.Is(err, ErrRestoreNotEmpty) {
+		t.Errorf("expected %v, got %v", ErrRestoreNotEmpty, err)
+	}
+
+	var escape bytes.Buffer
+	tw := tar.NewWriter(&escape)
+	tw.WriteHeader(&tar.Header{Name: "../data-000001.log", Mode: 0o644, Typeflag: tar.TypeReg})
+	tw.Close()
+	var empty bytes.Buffer
+	tar.NewWriter(&empty).Close()
+	for name, backup := range map[string][]byte{
+		"garbage": []byte("not a tar archive at all, and longer than a block header would need to be"),
+		"escape":  escape.Bytes(),
+		"empty":   empty.Bytes(),
+	} {
+		if err := Restore(bytes.NewReader(backup), filepath.Join(t.TempDir(), name)); !errors.Is(err, ErrInvalidBackup) {
+			t.Errorf("%s: expected %v, got %v", name, ErrInvalidBackup, err)
+		}
+	}
+}
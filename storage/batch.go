@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/rizalta/toydb/checksum"
+	"github.com/rizalta/toydb/index"
+)
+
+var (
+	ErrBatchOpen = errors.New("storage: a batch is already open")
+	ErrNoBatch   = errors.New("storage: no batch is open")
+)
+
+// batchHeaderSize is the size of a batch record: the record header, without
+// a checksum of its own, and a value holding the length and checksum of the
+// records that follow it.
+// The top byte of the length holds the checksum algorithm, which is 0,
+// CRC-32 IEEE, in batches written before it could be chosen.
+const batchHeaderSize = recordHeaderSize + 12
+
+const (
+	batchAlgorithmShift = 56
+	batchLengthMask     = 1<<batchAlgorithmShift - 1
+)
+
+// batch holds the records written since BeginBatch, which reach the log in
+// one write on CommitBatch, and what the index held for each key they
+// touched before, for AbortBatch.
+type batch struct {
+	start    uint64
+	buf      []byte
+	records  int
+	saved    map[string]savedEntry
+	hasBlobs bool
+	// changes are sent to watchers on commit.
+	changes []Change
+}
+
+type savedEntry struct {
+	found   bool
+	offset  uint64
+	payload []byte
+}
+
+// BeginBatch starts a batch of writes that reach the log all together or
+// not at all. Writes in the batch update the index right away, so reads
+// see them, but their records are kept in memory until CommitBatch, and
+// index pages stay in the cache until then. Walks over the whole log, such
+// as GarbageStats and Scrub, must not run while a batch is open.
+func (s *Store) BeginBatch() error {
+	if s.batch != nil {
+		return ErrBatchOpen
+	}
+	if s.readOnly || s.follower {
+		return ErrReadOnly
+	}
+	if err := s.rotateIfFull(); err != nil {
+		return err
+	}
+	if err := s.stamp(); err != nil {
+		return err
+	}
+
+	s.batch = &batch{
+		start:    s.offset,
+		saved:    make(map[string]savedEntry),
+		hasBlobs: s.hasBlobs,
+	}
+	s.offset += batchHeaderSize
+	s.batching.Store(true)
+	return nil
+}
+
+// CommitBatch writes the records of the open batch to the log, behind a
+// batch record that lets recovery tell whether all of them were written.
+// If the write fails, the batch is aborted.
+func (s *Store) CommitBatch() error {
+	b := s.batch
+	if b == nil {
+		return ErrNoBatch
+	}
+	if len(b.buf) == 0 {
+		s.batch = nil
+		s.offset = b.start
+		s.batching.Store(false)
+		s.deliver(b.changes...)
+		return nil
+	}
+
+	value := make([]byte, 12)
+	binary.LittleEndian.PutUint64(value, uint64(len(b.buf))|uint64(s.checksum)<<batchAlgorithmShift)
+	binary.LittleEndian.PutUint32(value[8:], s.checksum.Sum(b.buf))
+	data := append((&Record{RecordType: RecordTypeBatch, Value: value, legacy: true}).serialize(), b.buf...)
+
+	if err := s.pager.WriteAtOffset(b.start, data); err != nil {
+		if abortErr := s.AbortBatch(); abortErr != nil {
+			return abortErr
+		}
+		return fmt.Errorf("storage: failed to write batch: %w", err)
+	}
+	s.unsynced.Store(true)
+	s.metrics.RecordsWritten.Add(uint64(b.records) + 1)
+	s.metrics.RecordBytes.Add(uint64(len(data)))
+
+	s.batch = nil
+	s.batching.Store(false)
+	s.deliver(b.changes...)
+	if s.syncWrites {
+		if err := s.syncData(); err != nil {
+			return fmt.Errorf("storage: failed to sync batch: %w", err)
+		}
+	}
+	return nil
+}
+
+// AbortBatch drops the records of the open batch and points the index back
+// at what it held before. If it fails, the index is left part way and the
+// store has to be reopened, which recovers the index from the log.
+func (s *Store) AbortBatch() error {
+	b := s.batch
+	if b == nil {
+		return ErrNoBatch
+	}
+	s.batch = nil
+
+	for key, entry := range b.saved {
+		var err error
+		switch {
+		case !entry.found:
+			if err = s.index.Delete([]byte(key)); errors.Is(err, index.ErrKeyNotFound) {
+				err = nil
+			}
+		case entry.payload != nil:
+			err = s.index.InsertInline([]byte(key), entry.payload, index.Upsert)
+		default:
+			err = s.index.Insert([]byte(key), entry.offset, index.Upsert)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.offset = b.start
+	s.hasBlobs = b.hasBlobs
+	s.batching.Store(false)
+	return nil
+}
+
+// save remembers what the index holds for key the first time the batch
+// touches it.
+func (b *batch) save(idx Index, key []byte) error {
+	if _, ok := b.saved[string(key)]; ok {
+		return nil
+	}
+
+	offset, payload, err := idx.Lookup(key)
+	if errors.Is(err, index.ErrKeyNotFound) {
+		b.saved[string(key)] = savedEntry{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		payload = bytes.Clone(payload)
+	}
+	b.saved[string(key)] = savedEntry{found: true, offset: offset, payload: payload}
+	return nil
+}
+
+// batchIntact reports whether all the records of the batch whose batch
+// record r is at offset of the log behind p made it to the log.
+func batchIntact(p Pager, offset uint64, r *Record) bool {
+	if len(r.Value) != 12 {
+		return false
+	}
+	length := binary.LittleEndian.Uint64(r.Value)
+	algorithm := checksum.Algorithm(length >> batchAlgorithmShift)
+	if !algorithm.Valid() {
+		return false
+	}
+	data, err := p.ReadAtOffset(offset+batchHeaderSize, int(length&batchLengthMask))
+	return err == nil && algorithm.Sum(data) == binary.LittleEndian.Uint32(r.Value[8:])
+}
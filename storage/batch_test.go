@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizalta/toydb/checksum"
+)
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if err := store.Put([]byte(key), []byte(key+"_old")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+
+	// write changes a, adds c and removes b inside the open batch.
+	write := func() {
+		t.Helper()
+		if err := store.Put([]byte("a"), []byte("a_new")); err != nil {
+			t.Fatalf("failed to put in batch: %v", err)
+		}
+		if err := store.Add([]byte("c"), []byte("c_new")); err != nil {
+			t.Fatalf("failed to add in batch: %v", err)
+		}
+		if _, err := store.Delete([]byte("b")); err != nil {
+			t.Fatalf("failed to delete in batch: %v", err)
+		}
+	}
+	check := func(s *Store, expected map[string]string) {
+		t.Helper()
+		for _, key := range []string{"a", "b", "c"} {
+			value, found, err := s.Get([]byte(key))
+			if err != nil {
+				t.Fatalf("failed to get %s: %v", key, err)
+			}
+			want, ok := expected[key]
+			if found != ok || string(value) != want {
+				t.Errorf("expected %s to hold %q (found %v), got %q (found %v)", key, want, ok, value, found)
+			}
+		}
+	}
+	before := map[string]string{"a": "a_old", "b": "b_old"}
+	after := map[string]string{"a": "a_new", "c": "c_new"}
+
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	if err := store.BeginBatch(); !errors.Is(err, ErrBatchOpen) {
+		t.Errorf("expected %v, got %v", ErrBatchOpen, err)
+	}
+	write()
+	check(store, after)
+	if err := store.AbortBatch(); err != nil {
+		t.Fatalf("failed to abort batch: %v", err)
+	}
+	check(store, before)
+	if err := store.CommitBatch(); !errors.Is(err, ErrNoBatch) {
+		t.Errorf("expected %v, got %v", ErrNoBatch, err)
+	}
+
+	// A batch larger than the page cache of the index is aborted as well.
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	for i := range 5000 {
+		if err := store.Put(fmt.Appendf(nil, "bulk_%04d_%s", i, bytes.Repeat([]byte("x"), 50)), []byte("value")); err != nil {
+			t.Fatalf("failed to put in batch: %v", err)
+		}
+	}
+	if err := store.AbortBatch(); err != nil {
+		t.Fatalf("failed to abort batch: %v", err)
+	}
+	if count, err := store.Count(nil, nil); err != nil || count != 2 {
+		t.Errorf("expected 2 keys after the abort, got %d, err %v", count, err)
+	}
+
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	write()
+	if err := store.CommitBatch(); err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+	check(store, after)
+	if err := store.Put([]byte("d"), []byte("d")); err != nil {
+		t.Fatalf("failed to put after the batch: %v", err)
+	}
+	store.Close()
+
+	// Recover the index from the log, replaying it and rebuilding it.
+	for _, remove := range [][]string{{lockFile}, {lockFile, indexFile}} {
+		for _, file := range remove {
+			if err := os.Remove(filepath.Join(dir, file)); err != nil {
+				t.Fatalf("failed to remove %s: %v", file, err)
+			}
+		}
+		store, err = NewStore(dir)
+		if err != nil {
+			t.Fatalf("failed to reopen store: %v", err)
+		}
+		check(store, after)
+		if value, _, err := store.Get([]byte("d")); err != nil || string(value) != "d" {
+			t.Errorf("expected d after the batch, got %q, err %v", value, err)
+		}
+		store.Close()
+	}
+}
+
+func TestTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := store.Put([]byte("a"), []byte("a_old")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	store.Close()
+
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Put([]byte(key), []byte(key+"_new")); err != nil {
+			t.Fatalf("failed to put in batch: %v", err)
+		}
+	}
+	if err := store.CommitBatch(); err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+
+	// Crash with the last byte of the batch lost, before any index page
+	// written in the batch reached the disk.
+	dataPath := filepath.Join(dir, segmentFile(0))
+	stat, err := os.Stat(dataPath)
+	if err != nil {
+		t.Fatalf("failed to stat data file: %v", err)
+	}
+	if err := os.Truncate(dataPath, stat.Size()-1); err != nil {
+		t.Fatalf("failed to truncate data file: %v", err)
+	}
+
+	reopened, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer reopened.Close()
+
+	expected := map[string]string{"a": "a_old"}
+	for _, key := range []string{"a", "b", "c"} {
+		value, found, err := reopened.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", key, err)
+		}
+		want, ok := expected[key]
+		if found != ok || string(value) != want {
+			t.Errorf("expected %s to hold %q (found %v), got %q (found %v)", key, want, ok, value, found)
+		}
+	}
+}
+
+func TestBatchChecksum(t *testing.T) {
+	for _, algorithm := range []checksum.Algorithm{checksum.CRC32IEEE, checksum.CRC32C, checksum.XXH32} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewStoreWithOptions(dir, Options{Checksum: algorithm})
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			if err := store.BeginBatch(); err != nil {
+				t.Fatalf("failed to begin batch: %v", err)
+			}
+			for _, key := range []string{"a", "b", "c"} {
+				if err := store.Put([]byte(key), []byte(key)); err != nil {
+					t.Fatalf("failed to put in batch: %v", err)
+				}
+			}
+			if err := store.CommitBatch(); err != nil {
+				t.Fatalf("failed to commit batch: %v", err)
+			}
+			store.Close()
+
+			// Rebuild the index with another algorithm chosen, which
+			// must still check the batch with the one it was written with.
+			for _, file := range []string{lockFile, indexFile} {
+				if err := os.Remove(filepath.Join(dir, file)); err != nil {
+					t.Fatalf("failed to remove %s: %v", file, err)
+				}
+			}
+			store, err = NewStoreWithOptions(dir, Options{Checksum: (algorithm + 1) % 3})
+			if err != nil {
+				t.Fatalf("failed to reopen store: %v", err)
+			}
+			defer store.Close()
+			if count, err := store.Count(nil, nil); err != nil || count != 3 {
+				t.Errorf("expected 3 keys, got %d, err %v", count, err)
+			}
+		})
+	}
+
+	if _, err := NewStoreWithOptions(t.TempDir(), Options{Checksum: 200}); !errors.Is(err, checksum.ErrUnknownAlgorithm) {
+		t.Errorf("expected ErrUnknownAlgorithm, got %v", err)
+	}
+}
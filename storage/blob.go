@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrReservedKey = errors.New("storage: key uses the reserved blob prefix")
+	ErrMissingBlob = errors.New("storage: referenced blob not found")
+)
+
+// Deduplicated values live under keys of their own: the content under
+// blobDataPrefix+hash and the number of records pointing at it under
+// blobRefsPrefix+hash. Both sort after any table key and are hidden from
+// iterators.
+var (
+	blobPrefix     = []byte("\xffblob:")
+	blobDataPrefix = []byte("\xffblob:data:")
+	blobRefsPrefix = []byte("\xffblob:refs:")
+)
+
+func isBlobKey(key []byte) bool {
+	return bytes.HasPrefix(key, blobPrefix)
+}
+
+func blobKey(prefix, hash []byte) []byte {
+	key := make([]byte, 0, len(prefix)+len(hash))
+	key = append(key, prefix...)
+	return append(key, hash...)
+}
+
+// retainBlob stores value if its content is not stored yet, takes a
+// reference to it and returns its hash.
+func (s *Store) retainBlob(value []byte) ([]byte, error) {
+	sum := sha256.Sum256(value)
+	hash := sum[:]
+
+	refs, err := s.blobRefs(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if refs == 0 {
+		err := s.append(&Record{
+			RecordType: RecordTypeInsert,
+			Key:        blobKey(blobDataPrefix, hash),
+			Value:      value,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.setBlobRefs(hash, refs+1); err != nil {
+		return nil, err
+	}
+	s.hasBlobs = true
+
+	return hash, nil
+}
+
+// releaseBlob drops a reference to the blob with the given hash and deletes
+// the blob once nothing refers to it.
+func (s *Store) releaseBlob(hash []byte) error {
+	refs, err := s.blobRefs(hash)
+	if err != nil {
+		return err
+	}
+
+	if refs > 1 {
+		return s.setBlobRefs(hash, refs-1)
+	}
+
+	for _, prefix := range [][]byte{blobDataPrefix, blobRefsPrefix} {
+		err := s.append(&Record{
+			RecordType: RecordTypeDelete,
+			Key:        blobKey(prefix, hash),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) blobRefs(hash []byte) (uint64, error) {
+	record, err := s.current(blobKey(blobRefsPrefix, hash))
+	if err != nil || record == nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(record.Value), nil
+}
+
+func (s *Store) setBlobRefs(hash []byte, refs uint64) error {
+	return s.append(&Record{
+		RecordType: RecordTypeInsert,
+		Key:        blobKey(blobRefsPrefix, hash),
+		Value:      binary.LittleEndian.AppendUint64(nil, refs),
+	})
+}
+
+// resolve returns the value of a live record, loading it from the blob
+// store if the record only holds a reference and folding in the operands
+// of a merge record.
+func (s *Store) resolve(record *Record) ([]byte, error) {
+	if record.RecordType == RecordTypeMerge {
+		return s.mergedValue(record)
+	}
+	if record.RecordType != RecordTypeBlobRef {
+		return record.Value, nil
+	}
+
+	blob, err := s.current(blobKey(blobDataPrefix, record.Value))
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, ErrMissingBlob
+	}
+
+	return blob.Value, nil
+}
+
+func (s *Store) containsBlobs() (bool, error) {
+	cursor, err := s.index.NewCursor(blobPrefix, nil)
+	if err != nil {
+		return false, err
+	}
+
+	key, _, err := cursor.Next()
+	if err != nil {
+		return false, err
+	}
+
+	return key != nil && isBlobKey(key), nil
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newDedupStore(t *testing.T, dir string) *Store {
+	t.Helper()
+
+	store, err := NewStoreWithOptions(dir, Options{DedupThreshold: 64})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	return store
+}
+
+func TestDedup(t *testing.T) {
+	store := newDedupStore(t, t.TempDir())
+	defer store.Close()
+
+	attachment := bytes.Repeat([]byte("attachment"), 100)
+
+	if err := store.Put([]byte("a"), attachment); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	first := store.offset
+
+	for i := range 10 {
+		key := fmt.Appendf(nil, "copy_%d", i)
+		if err := store.Put(key, attachment); err != nil {
+			t.Fatalf("failed to put %s: %v", key, err)
+		}
+	}
+	if grown := store.offset - first; grown >= uint64(2*len(attachment)) {
+		t.Errorf("expected copies to share the stored value, log grew by %d bytes", grown)
+	}
+
+	value, found, err := store.Get([]byte("copy_3"))
+	if err != nil || !found {
+		t.Fatalf("failed to get copy: found %v, err %v", found, err)
+	}
+	if !bytes.Equal(value, attachment) {
+		t.Errorf("expected deduplicated value to round trip")
+	}
+
+	small := []byte("small")
+	if err := store.Put([]byte("small"), small); err != nil {
+		t.Fatalf("failed to put small value: %v", err)
+	}
+
+	it, err := store.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	count := 0
+	for {
+		key, value, err := it.Next()
+		if err != nil {
+			t.Fatalf("next call failed: %v", err)
+		}
+		if key == nil {
+			break
+		}
+		if isBlobKey(key) {
+			t.Fatalf("iterator returned internal key %q", key)
+		}
+		if string(key) != "small" && !bytes.Equal(value, attachment) {
+			t.Errorf("expected iterator to resolve value for key %s", key)
+		}
+		count++
+	}
+	if count != 12 {
+		t.Errorf("expected 12 keys, got %d", count)
+	}
+	if counted, err := store.Count(nil, nil); err != nil || counted != count {
+		t.Errorf("expected Count to agree with the iterator on %d keys, got %d, err %v", count, counted, err)
+	}
+
+	if err := store.Put(blobKey(blobDataPrefix, nil), small); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("expected %v, got %v", ErrReservedKey, err)
+	}
+}
+
+func TestDedupRelease(t *testing.T) {
+	store := newDedupStore(t, t.TempDir())
+	defer store.Close()
+
+	attachment := bytes.Repeat([]byte("x"), 128)
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	for _, key := range keys {
+		if err := store.Put(key, attachment); err != nil {
+			t.Fatalf("failed to put %s: %v", key, err)
+		}
+	}
+
+	hash := mustBlobHash(t, store, keys[0])
+	assertRefs := func(want uint64) {
+		t.Helper()
+		refs, err := store.blobRefs(hash)
+		if err != nil {
+			t.Fatalf("failed to read reference count: %v", err)
+		}
+		if refs != want {
+			t.Errorf("expected %d references, got %d", want, refs)
+		}
+	}
+	assertRefs(3)
+
+	if err := store.Put(keys[0], attachment); err != nil {
+		t.Fatalf("failed to overwrite with the same value: %v", err)
+	}
+	assertRefs(3)
+
+	if err := store.Update(keys[1], []byte("short")); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	assertRefs(2)
+
+	if _, err := store.Delete(keys[2]); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	assertRefs(1)
+
+	if _, err := store.Delete(keys[0]); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	assertRefs(0)
+
+	blob, err := store.current(blobKey(blobDataPrefix, hash))
+	if err != nil {
+		t.Fatalf("failed to look up blob: %v", err)
+	}
+	if blob != nil {
+		t.Errorf("expected unreferenced blob to be deleted")
+	}
+}
+
+func TestDedupRecovery(t *testing.T) {
+	dir := t.TempDir()
+	store := newDedupStore(t, dir)
+
+	attachment := bytes.Repeat([]byte("y"), 256)
+	for _, key := range []string{"a", "b"} {
+		if err := store.Put([]byte(key), attachment); err != nil {
+			t.Fatalf("failed to put %s: %v", key, err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if !store.hasBlobs {
+		t.Fatalf("expected reopened store to track existing blobs")
+	}
+
+	value, found, err := store.Get([]byte("b"))
+	if err != nil || !found {
+		t.Fatalf("failed to get after recovery: found %v, err %v", found, err)
+	}
+	if !bytes.Equal(value, attachment) {
+		t.Errorf("expected value to survive recovery")
+	}
+
+	if err := store.Put([]byte("a"), []byte("plain")); err != nil {
+		t.Fatalf("failed to overwrite: %v", err)
+	}
+	refs, err := store.blobRefs(mustBlobHash(t, store, []byte("b")))
+	if err != nil {
+		t.Fatalf("failed to read reference count: %v", err)
+	}
+	if refs != 1 {
+		t.Errorf("expected 1 reference after overwrite without dedup, got %d", refs)
+	}
+}
+
+func mustBlobHash(t *testing.T, store *Store, key []byte) []byte {
+	t.Helper()
+
+	record, err := store.current(key)
+	if err != nil {
+		t.Fatalf("failed to look up %s: %v", key, err)
+	}
+	if record == nil || record.RecordType != RecordTypeBlobRef {
+		t.Fatalf("expected %s to hold a blob reference", key)
+	}
+
+	return record.Value
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	bloomFile  = "bloom.db"
+	bloomMagic = 0x626c6d31
+	// minBloomKeys is the fewest keys a filter is sized for, so that a
+	// small store does not rebuild its filter on every few writes.
+	minBloomKeys = 1024
+	bloomHeader  = 24
+)
+
+var errBadBloomFile = errors.New("storage: bad bloom filter file")
+
+// bloomFilter tells keys that were never written from keys that may have
+// been. Deleted keys stay in it, and so do keys of aborted batches, which
+// only costs a lookup that finds nothing.
+type bloomFilter struct {
+	bits []uint64
+	k    int
+	// count is the number of keys added and capacity the number the
+	// filter was sized for, past which its false positive rate climbs.
+	count    int
+	capacity int
+}
+
+func newBloomFilter(capacity, bitsPerKey int) *bloomFilter {
+	capacity = max(capacity, minBloomKeys)
+	return &bloomFilter{
+		bits:     make([]uint64, (capacity*bitsPerKey+63)/64),
+		k:        max(1, int(math.Round(float64(bitsPerKey)*math.Ln2))),
+		capacity: capacity,
+	}
+}
+
+// probes calls fn with the k bit positions of key, derived from the two
+// halves of its hash.
+func (f *bloomFilter) probes(key []byte, fn func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	n := uint64(len(f.bits)) * 64
+	for i := range uint64(f.k) {
+		fn((h1 + i*h2) % n)
+	}
+}
+
+func (f *bloomFilter) add(key []byte) {
+	f.probes(key, func(bit uint64) { f.bits[bit/64] |= 1 << (bit % 64) })
+	f.count++
+}
+
+func (f *bloomFilter) mayContain(key []byte) bool {
+	found := true
+	f.probes(key, func(bit uint64) {
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// marshal encodes the filter as a header of magic, k, count and capacity,
+// the bits, and a CRC-32 of everything before it.
+func (f *bloomFilter) marshal() []byte {
+	data := make([]byte, bloomHeader, bloomHeader+len(f.bits)*8+4)
+	binary.LittleEndian.PutUint32(data[0:], bloomMagic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(f.k))
+	binary.LittleEndian.PutUint64(data[8:], uint64(f.count))
+	binary.LittleEndian.PutUint64(data[16:], uint64(f.capacity))
+	for _, word := range f.bits {
+		data = binary.LittleEndian.AppendUint64(data, word)
+	}
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+func unmarshalBloomFilter(data []byte) (*bloomFilter, error) {
+	if len(data) < bloomHeader+4 || (len(data)-bloomHeader-4)%8 != 0 {
+		return nil, errBadBloomFile
+	}
+	body := data[:len(data)-4]
+	if binary.LittleEndian.Uint32(body) != bloomMagic ||
+		crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, errBadBloomFile
+	}
+
+	f := &bloomFilter{
+		k:        int(binary.LittleEndian.Uint32(body[4:])),
+		count:    int(binary.LittleEndian.Uint64(body[8:])),
+		capacity: int(binary.LittleEndian.Uint64(body[16:])),
+		bits:     make([]uint64, (len(body)-bloomHeader)/8),
+	}
+	if f.k == 0 || len(f.bits) == 0 {
+		return nil, errBadBloomFile
+	}
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(body[bloomHeader+8*i:])
+	}
+	return f, nil
+}
+
+// openBloom sets up the filter of a store opened with a positive
+// BloomBitsPerKey. The file written by Close is only trusted if the store
+// was closed cleanly; otherwise the filter is built from the index.
+func (s *Store) openBloom(clean bool) error {
+	if clean {
+		data, err := os.ReadFile(filepath.Join(s.dataDir, bloomFile))
+		if err == nil {
+			if s.bloom, err = unmarshalBloomFilter(data); err == nil {
+				return nil
+			}
+		}
+	}
+	return s.rebuildBloom(0)
+}
+
+// rebuildBloom replaces the filter with one sized for at least capacity
+// keys, or for twice the keys in the index, holding every key of the
+// index.
+func (s *Store) rebuildBloom(capacity int) error {
+	count, err := s.index.Count(nil, nil)
+	if err != nil {
+		return err
+	}
+	cursor, err := s.index.NewCursor(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	f := newBloomFilter(max(capacity, 2*count), s.bloomBitsPerKey)
+	for {
+		key, _, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			break
+		}
+		f.add(key)
+	}
+	s.bloom = f
+	return nil
+}
+
+// addToBloom adds a key that was just indexed to the filter, rebuilding
+// it at twice the size once it holds more keys than it was sized for. Keys
+// it may hold already are not counted again, so rewriting the same keys
+// does not grow it.
+func (s *Store) addToBloom(key []byte) error {
+	if s.bloom == nil || s.bloom.mayContain(key) {
+		return nil
+	}
+	s.bloom.add(key)
+	if s.bloom.count <= s.bloom.capacity {
+		return nil
+	}
+	return s.rebuildBloom(2 * s.bloom.capacity)
+}
+
+// writeBloom saves the filter for the next open, by renaming a complete
+// new file over the old one, or removes a file left by an earlier open
+// with a filter when the store has none.
+func (s *Store) writeBloom() error {
+	path := filepath.Join(s.dataDir, bloomFile)
+	if s.bloom == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, s.bloom.marshal(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(10000, 10)
+	for i := range 10000 {
+		f.add(fmt.Appendf(nil, "key_%d", i))
+	}
+	for i := range 10000 {
+		if !f.mayContain(fmt.Appendf(nil, "key_%d", i)) {
+			t.Fatalf("expected key_%d to be in the filter", i)
+		}
+	}
+
+	positives := 0
+	for i := range 10000 {
+		if f.mayContain(fmt.Appendf(nil, "missing_%d", i)) {
+			positives++
+		}
+	}
+	if positives > 300 {
+		t.Errorf("expected about 1%% false positives, got %d in 10000", positives)
+	}
+
+	g, err := unmarshalBloomFilter(f.marshal())
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	if g.k != f.k || g.count != f.count || g.capacity != f.capacity || len(g.bits) != len(f.bits) {
+		t.Errorf("expected %+v after a round trip, got %+v", f, g)
+	}
+	data := f.marshal()
+	data[bloomHeader] ^= 1
+	if _, err := unmarshalBloomFilter(data); err != errBadBloomFile {
+		t.Errorf("expected %v, got %v", errBadBloomFile, err)
+	}
+}
+
+func TestStoreBloom(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{BloomBitsPerKey: 10}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	key := func(i int) []byte { return fmt.Appendf(nil, "key_%05d", i) }
+	const n = 3000
+	for i := range n {
+		if err := store.Put(key(i), []byte("value")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	// Rewriting keys does not grow the filter.
+	for i := range n {
+		if err := store.Put(key(i), []byte("value")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	if store.bloom.capacity < n || store.bloom.capacity > 4*n {
+		t.Errorf("expected the filter to be sized for %d to %d keys, got %d", n, 4*n, store.bloom.capacity)
+	}
+	if _, err := store.Delete(key(0)); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	check := func(t *testing.T, s *Store) {
+		t.Helper()
+		for _, i := range []int{0, 1, n - 1, n} {
+			_, found, err := s.Get(key(i))
+			if err != nil {
+				t.Fatalf("failed to get %s: %v", key(i), err)
+			}
+			if want := i > 0 && i < n; found != want {
+				t.Errorf("expected %s found %v, got %v", key(i), want, found)
+			}
+		}
+	}
+	check(t, store)
+	count := store.bloom.count
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	t.Run("loaded after a clean close", func(t *testing.T) {
+		store, err := NewStoreWithOptions(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to reopen: %v", err)
+		}
+		defer store.Close()
+		if store.bloom.count != count {
+			t.Errorf("expected the saved filter with %d keys, got %d", count, store.bloom.count)
+		}
+		check(t, store)
+	})
+
+	t.Run("rebuilt from a bad file", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(dir, bloomFile), []byte("junk"), 0o644); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		store, err := NewStoreWithOptions(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to reopen: %v", err)
+		}
+		defer store.Close()
+		if store.bloom.count != n-1 {
+			t.Errorf("expected a filter rebuilt with %d keys, got %d", n-1, store.bloom.count)
+		}
+		check(t, store)
+	})
+
+	t.Run("removed without a filter", func(t *testing.T) {
+		store, err := NewStore(dir)
+		if err != nil {
+			t.Fatalf("failed to reopen: %v", err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("failed to close: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, bloomFile)); !os.IsNotExist(err) {
+			t.Errorf("expected the filter file to be removed, got %v", err)
+		}
+	})
+}
+
+func BenchmarkGetMissing(b *testing.B) {
+	for _, bits := range []int{0, 10} {
+		b.Run(fmt.Sprintf("bits=%d", bits), func(b *testing.B) {
+			store, err := NewStoreWithOptions(b.TempDir(), Options{BloomBitsPerKey: bits})
+			if err != nil {
+				b.Fatalf("failed to create store: %v", err)
+			}
+			defer store.Close()
+			for i := range 20000 {
+				if err := store.Put(fmt.Appendf(nil, "key_%06d", i), []byte("value")); err != nil {
+					b.Fatalf("failed to put: %v", err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				if _, _, err := store.Get(fmt.Appendf(nil, "missing_%d", i)); err != nil {
+					b.Fatalf("failed to get: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"bytes"
+
+	"github.com/rizalta/toydb/index"
+)
+
+// CompareAndSwap writes value to key if the key holds expected, and
+// reports whether it did. A nil expected matches a key that is missing or
+// expired, and a nil value deletes the key; use an empty slice for an
+// empty value. The current value is read and the replacement written in
+// the one call, so no other write to the store can come in between; that
+// lets callers that read a value, change it and write it back detect
+// writes made in the meantime. The replacement keeps the layout of the
+// current value and does not expire.
+func (s *Store) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	if isBlobKey(key) {
+		return false, ErrReservedKey
+	}
+	record, err := s.current(key)
+	if err != nil {
+		return false, err
+	}
+
+	layout := LayoutHeap
+	var current []byte
+	if record != nil {
+		if current, err = s.resolve(record); err != nil {
+			return false, err
+		}
+		if current == nil {
+			current = []byte{}
+		}
+		if record.RecordType == RecordTypeInline {
+			layout = LayoutInline
+		}
+	}
+	if (current == nil) != (expected == nil) || !bytes.Equal(current, expected) {
+		return false, nil
+	}
+
+	if value == nil {
+		if record == nil {
+			return true, nil
+		}
+		_, err := s.Delete(key)
+		return err == nil, err
+	}
+	if err := s.Write(key, value, index.Upsert, layout); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/index"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	store, err := NewStoreWithOptions(t.TempDir(), Options{Clock: clk})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.PutWithTTL([]byte("expired"), []byte("old"), time.Minute); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	clk.Advance(time.Hour)
+
+	tests := []struct {
+		name      string
+		key       string
+		expected  []byte
+		value     []byte
+		swapped   bool
+		remaining []byte
+	}{
+		{"insert if absent", "a", nil, []byte("1"), true, []byte("1")},
+		{"absent when present", "a", nil, []byte("2"), false, []byte("1")},
+		{"match", "a", []byte("1"), []byte("2"), true, []byte("2")},
+		{"stale", "a", []byte("1"), []byte("3"), false, []byte("2")},
+		{"empty is not absent", "a", []byte{}, []byte("3"), false, []byte("2")},
+		{"to empty", "a", []byte("2"), []byte{}, true, []byte{}},
+		{"empty", "a", []byte{}, []byte("4"), true, []byte("4")},
+		{"delete", "a", []byte("4"), nil, true, nil},
+		{"delete absent", "a", nil, nil, true, nil},
+		{"expired is absent", "expired", nil, []byte("new"), true, []byte("new")},
+	}
+	for _, tt := range tests {
+		swapped, err := store.CompareAndSwap([]byte(tt.key), tt.expected, tt.value)
+		if err != nil {
+			t.Fatalf("%s: failed to swap: %v", tt.name, err)
+		}
+		if swapped != tt.swapped {
+			t.Errorf("%s: expected swapped %v, got %v", tt.name, tt.swapped, swapped)
+		}
+		value, found, err := store.Get([]byte(tt.key))
+		if err != nil {
+			t.Fatalf("%s: failed to get: %v", tt.name, err)
+		}
+		if found != (tt.remaining != nil) || (found && !reflect.DeepEqual(append([]byte{}, value...), tt.remaining)) {
+			t.Errorf("%s: expected %q to remain, got %q, found %v", tt.name, tt.remaining, value, found)
+		}
+	}
+
+	// Merged and inline values compare by what reads return, and inline
+	// ones stay inline.
+	if err := store.Merge([]byte("count"), MergeCounterAdd, counter(2)); err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+	if swapped, err := store.CompareAndSwap([]byte("count"), counter(2), counter(3)); err != nil || !swapped {
+		t.Errorf("expected to swap a merged value, got %v, err %v", swapped, err)
+	}
+	if err := store.Write([]byte("inline"), []byte("x"), index.Upsert, LayoutInline); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	if swapped, err := store.CompareAndSwap([]byte("inline"), []byte("x"), []byte("y")); err != nil || !swapped {
+		t.Errorf("expected to swap an inline value, got %v, err %v", swapped, err)
+	}
+	if record, err := store.lookup([]byte("inline")); err != nil || record.RecordType != RecordTypeInline {
+		t.Errorf("expected the value to stay inline, got %+v, err %v", record, err)
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"iter"
+)
+
+// LogChange is a write that the data log holds, as Changes reads it back.
+type LogChange struct {
+	// Pos is the log position of the record of the write. Changes(Pos+1)
+	// goes on after it.
+	Pos uint64
+	Change
+}
+
+// Changes returns the writes the log holds from position from on, up to
+// where it ends when Changes is called, in the order they were written.
+// The records of a batch only reach the log when it is committed, so
+// every change returned is. Writes to the blob store are left out. Forget
+// writes nothing, so the keys it drops keep their changes.
+//
+// The old value of each change is what its key held before in the log,
+// which Changes finds by walking the log from its start and keeping the
+// position of the last record of every key. It reads through the store,
+// so the store must not be written to while the changes are read.
+func (s *Store) Changes(from uint64) iter.Seq2[LogChange, error] {
+	end := s.LogEnd()
+	return func(yield func(LogChange, error) bool) {
+		last := make(map[string]uint64)
+		for pos := s.segments.next(0); pos < end; {
+			r, err := s.readRecord(pos)
+			if err != nil {
+				yield(LogChange{}, err)
+				return
+			}
+			size := r.size()
+			switch r.RecordType {
+			case RecordTypeBatch:
+				size = batchHeaderSize
+			case RecordTypeTime, RecordTypeKeyCheck:
+			default:
+				if pos >= from && !isBlobKey(r.Key) {
+					change, err := s.logChange(r, pos, last)
+					if err != nil {
+						yield(LogChange{}, err)
+						return
+					}
+					if !yield(change, nil) {
+						return
+					}
+				}
+				last[string(r.Key)] = pos
+			}
+			pos = s.segments.next(pos + size)
+		}
+	}
+}
+
+// logChange returns the change r makes, reading the value its key held
+// before from the record last points at.
+func (s *Store) logChange(r *Record, pos uint64, last map[string]uint64) (LogChange, error) {
+	change := LogChange{Pos: pos, Change: Change{Op: ChangePut, Key: r.Key}}
+	if r.RecordType == RecordTypeDelete {
+		change.Op = ChangeDelete
+	} else {
+		value, err := s.logValue(r, last)
+		if err != nil {
+			return LogChange{}, err
+		}
+		change.NewValue = value
+	}
+
+	if previous, ok := last[string(r.Key)]; ok {
+		old, err := s.readRecord(previous)
+		if err != nil {
+			return LogChange{}, err
+		}
+		if old.RecordType != RecordTypeDelete {
+			if change.OldValue, err = s.logValue(old, last); err != nil {
+				return LogChange{}, err
+			}
+		}
+	}
+	return change, nil
+}
+
+// logValue resolves the value of r as it was when r was written, taking
+// the content of a blob from the record last points at rather than from
+// the index, which may have dropped the blob since.
+func (s *Store) logValue(r *Record, last map[string]uint64) ([]byte, error) {
+	if r.RecordType != RecordTypeBlobRef {
+		return s.resolve(r)
+	}
+	pos, ok := last[string(blobKey(blobDataPrefix, r.Value))]
+	if !ok {
+		return nil, ErrMissingBlob
+	}
+	blob, err := s.readRecord(pos)
+	if err != nil {
+		return nil, err
+	}
+	if blob.RecordType == RecordTypeDelete {
+		return nil, ErrMissingBlob
+	}
+	return blob.Value, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestChanges(t *testing.T) {
+	store, err := NewStoreWithOptions(t.TempDir(), Options{SegmentSize: 256, DedupThreshold: 32})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	big := bytes.Repeat([]byte("b"), 40)
+	bigger := bytes.Repeat([]byte("c"), 48)
+	steps := []func() error{
+		func() error { return store.Put([]byte("a"), []byte("1")) },
+		func() error { return store.Put([]byte("blob"), big) },
+		func() error { return store.Put([]byte("a"), []byte("2")) },
+		func() error { return store.Merge([]byte("hits"), MergeCounterAdd, counter(3)) },
+		func() error { return store.Merge([]byte("hits"), MergeCounterAdd, counter(4)) },
+		// Replacing the blob releases the old one, which the old value of
+		// the change still reads.
+		func() error { return store.Put([]byte("blob"), bigger) },
+		func() error { _, err := store.Delete([]byte("a")); return err },
+		func() error { _, err := store.Delete([]byte("missing")); return err },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	for _, commit := range []bool{false, true} {
+		if err := store.BeginBatch(); err != nil {
+			t.Fatalf("failed to begin batch: %v", err)
+		}
+		if err := store.Put([]byte("d"), []byte("batched")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+		end := store.AbortBatch
+		if commit {
+			end = store.CommitBatch
+		}
+		if err := end(); err != nil {
+			t.Fatalf("failed to end batch: %v", err)
+		}
+	}
+	// An open batch is not in the log yet.
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	if err := store.Put([]byte("e"), []byte("open")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	expected := []Change{
+		{Op: ChangePut, Key: []byte("a"), NewValue: []byte("1")},
+		{Op: ChangePut, Key: []byte("blob"), NewValue: big},
+		{Op: ChangePut, Key: []byte("a"), OldValue: []byte("1"), NewValue: []byte("2")},
+		{Op: ChangePut, Key: []byte("hits"), NewValue: counter(3)},
+		{Op: ChangePut, Key: []byte("hits"), OldValue: counter(3), NewValue: counter(7)},
+		{Op: ChangePut, Key: []byte("blob"), OldValue: big, NewValue: bigger},
+		{Op: ChangeDelete, Key: []byte("a"), OldValue: []byte("2")},
+		{Op: ChangePut, Key: []byte("d"), NewValue: []byte("batched")},
+	}
+	var changes []LogChange
+	for change, err := range store.Changes(0) {
+		if err != nil {
+			t.Fatalf("failed to read changes: %v", err)
+		}
+		changes = append(changes, change)
+	}
+	var got []Change
+	for i, change := range changes {
+		if i > 0 && change.Pos <= changes[i-1].Pos {
+			t.Errorf("expected positions to grow, got %d after %d", change.Pos, changes[i-1].Pos)
+		}
+		got = append(got, change.Change)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+	if segmentOf(changes[len(changes)-1].Pos) == 0 {
+		t.Errorf("expected the changes to span segments")
+	}
+
+	// Reading on from a change leaves out it and those before it, but not
+	// the old values they left behind.
+	var rest []Change
+	for change, err := range store.Changes(changes[4].Pos + 1) {
+		if err != nil {
+			t.Fatalf("failed to read changes: %v", err)
+		}
+		rest = append(rest, change.Change)
+	}
+	if !reflect.DeepEqual(rest, expected[5:]) {
+		t.Errorf("expected %+v, got %+v", expected[5:], rest)
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+)
+
+// CompactTo writes to dir a copy of the store that holds one record for
+// every key the index holds, its latest, and none of the records that
+// deletes and later writes left dead in the log. Merge records are folded
+// into the value they make, and values are written uncompressed, though
+// encrypted with the key of the store if it has one. Keys that expired
+// are copied as they are, for SweepExpired to drop.
+//
+// dir must be empty or not exist yet. The copy has a data log of its own,
+// whose positions start over, so it takes the place of the store as a
+// new database: archives, followers and change feeds of the store do not
+// carry over to it. No batch may be open.
+func (s *Store) CompactTo(dir string) error {
+	if s.batch != nil {
+		return ErrBatchOpen
+	}
+	if err := checkEmptyDir(dir); err != nil {
+		return err
+	}
+	dst, err := NewStoreWithOptions(dir, Options{Checksum: s.checksum, EncryptionKey: s.encryptionKey})
+	if err != nil {
+		return err
+	}
+	return errors.Join(s.copyLive(dst), dst.Close())
+}
+
+func (s *Store) copyLive(dst *Store) error {
+	cursor, err := s.index.NewCursor(nil, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		key, _, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+		key = bytes.Clone(key)
+
+		r, err := s.lookup(key)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			continue
+		}
+		record := &Record{RecordType: r.RecordType, Key: key, Value: r.Value, expires: r.expires}
+		if r.RecordType == RecordTypeMerge {
+			record.RecordType = RecordTypeInsert
+			if record.Value, err = s.mergedValue(r); err != nil {
+				return err
+			}
+		}
+		if err := dst.append(record); err != nil {
+			return err
+		}
+		if isBlobKey(key) {
+			dst.hasBlobs = true
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/index"
+)
+
+func TestCompactTo(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStoreWithOptions(filepath.Join(dir, "store"), Options{DedupThreshold: 32})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	// Overwrite every key a few times and delete some, so that most of
+	// the log is dead.
+	for range 3 {
+		putKeys(t, store, 0, 50)
+	}
+	for i := 50; i < 60; i++ {
+		putKeys(t, store, i, i+1)
+		if _, err := store.Delete(fmt.Appendf(nil, "key_%03d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	big := bytes.Repeat([]byte("x"), 64)
+	steps := []func() error{
+		func() error { return store.Put([]byte("blob_a"), big) },
+		func() error { return store.Put([]byte("blob_b"), big) },
+		func() error { return store.Merge([]byte("hits"), MergeCounterAdd, counter(2)) },
+		func() error { return store.Merge([]byte("hits"), MergeCounterAdd, counter(3)) },
+		func() error { return store.Write([]byte("inline"), []byte("leaf"), index.Upsert, LayoutInline) },
+		func() error { return store.PutWithTTL([]byte("ttl"), []byte("soon"), time.Hour) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	copyDir := filepath.Join(dir, "copy")
+	if err := store.CompactTo(copyDir); err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if err := store.CompactTo(copyDir); !errors.Is(err, ErrRestoreNotEmpty) {
+		t.Errorf("expected ErrRestoreNotEmpty compacting into the copy again, got %v", err)
+	}
+
+	compacted, err := NewStore(copyDir)
+	if err != nil {
+		t.Fatalf("failed to open the copy: %v", err)
+	}
+	defer compacted.Close()
+	if compacted.LogEnd() >= store.LogEnd()/2 {
+		t.Errorf("expected the copy to be much smaller, got %d of %d bytes", compacted.LogEnd(), store.LogEnd())
+	}
+	checkKeys(t, compacted, 50)
+	for i := 50; i < 60; i++ {
+		key := fmt.Appendf(nil, "key_%03d", i)
+		if found, err := compacted.Has(key); err != nil || found {
+			t.Errorf("expected %s to stay deleted, got found %v, err %v", key, found, err)
+		}
+	}
+	for key, expected := range map[string][]byte{
+		"blob_a": big,
+		"blob_b": big,
+		"hits":   counter(5),
+		"inline": []byte("leaf"),
+		"ttl":    []byte("soon"),
+	} {
+		value, found, err := compacted.Get([]byte(key))
+		if err != nil || !found || !bytes.Equal(value, expected) {
+			t.Errorf("expected %s to hold %q, got %q, found %v, err %v", key, expected, value, found, err)
+		}
+	}
+
+	// The copy is a store of its own, whose blobs are still shared.
+	if _, err := compacted.Delete([]byte("blob_a")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if value, _, err := compacted.Get([]byte("blob_b")); err != nil || !bytes.Equal(value, big) {
+		t.Errorf("expected blob_b to keep its value, got %q, err %v", value, err)
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+// DefaultCompressionThreshold is the smallest value compressed when
+// Options.CompressionThreshold is zero.
+const DefaultCompressionThreshold = 512
+
+var (
+	ErrUnknownCompressor = errors.New("storage: value compressed by an unregistered compressor")
+	ErrCompressorID      = errors.New("storage: compressor ID taken or reserved")
+)
+
+// Compressor compresses values in the data log. Its ID is stored with
+// every value it compressed, so IDs must be unique and must not change.
+// ID 0 is reserved.
+type Compressor interface {
+	ID() uint8
+	Compress(value []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Flate compresses with DEFLATE at the default level. It needs no
+// registration.
+var Flate Compressor = flateCompressor{}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[uint8]Compressor{Flate.ID(): Flate}
+)
+
+// RegisterCompressor makes the values compressed by c readable. A store
+// registers the compressor of its options itself; one that earlier writes
+// used has to be registered before their values are read.
+func RegisterCompressor(c Compressor) error {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if _, taken := compressors[c.ID()]; taken || c.ID() == 0 {
+		return ErrCompressorID
+	}
+	compressors[c.ID()] = c
+	return nil
+}
+
+// registerOwn registers the compressor a store writes with, unless its ID
+// is registered already.
+func registerOwn(c Compressor) error {
+	if c.ID() == 0 {
+		return ErrCompressorID
+	}
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if _, taken := compressors[c.ID()]; !taken {
+		compressors[c.ID()] = c
+	}
+	return nil
+}
+
+func lookupCompressor(id uint8) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[id]
+}
+
+// compress stores the value of r compressed by c, prefixed with the ID of
+// c, if that makes it smaller.
+func (r *Record) compress(c Compressor) error {
+	data, err := c.Compress(r.Value)
+	if err != nil {
+		return err
+	}
+	if 1+len(data) < len(r.Value) {
+		r.compressed = append([]byte{c.ID()}, data...)
+	}
+	return nil
+}
+
+// decompress sets the value of a record read from the log with its value
+// compressed.
+func (r *Record) decompress() error {
+	if r.compressed == nil || r.Value != nil {
+		return nil
+	}
+	c := lookupCompressor(r.compressed[0])
+	if c == nil {
+		return ErrUnknownCompressor
+	}
+	value, err := c.Decompress(r.compressed[1:])
+	if err != nil {
+		return err
+	}
+	r.Value = value
+	return nil
+}
+
+type flateCompressor struct{}
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+func (flateCompressor) ID() uint8 {
+	return 1
+}
+
+func (flateCompressor) Compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// reverser is a lossy compressor whose values never read back, for tests
+// of compressors other than Flate.
+type reverser struct{}
+
+func (reverser) ID() uint8 {
+	return 200
+}
+
+func (reverser) Compress(value []byte) ([]byte, error) {
+	out := bytes.Clone(value)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out[:len(out)/2], nil
+}
+
+func (reverser) Decompress(data []byte) ([]byte, error) {
+	return nil, errors.New("reverser: lossy")
+}
+
+func TestCompression(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Compression: Flate, CompressionThreshold: 64}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	random := make([]byte, 1000)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range random {
+		random[i] = byte(rng.Uint32())
+	}
+	values := map[string][]byte{
+		"large":  bytes.Repeat([]byte("toydb "), 200),
+		"small":  []byte("toydb toydb toydb toydb"),
+		"random": random,
+	}
+	tests := []struct {
+		key        string
+		compressed bool
+	}{
+		{"large", true},
+		{"small", false},
+		{"random", false},
+		{"batched", true},
+	}
+	for _, key := range []string{"large", "small", "random"} {
+		if err := store.Put([]byte(key), values[key]); err != nil {
+			t.Fatalf("failed to put %s: %v", key, err)
+		}
+	}
+	values["batched"] = bytes.Repeat([]byte("batch "), 100)
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	if err := store.Put([]byte("batched"), values["batched"]); err != nil {
+		t.Fatalf("failed to put batched: %v", err)
+	}
+	if value, _, err := store.Get([]byte("batched")); err != nil || !bytes.Equal(value, values["batched"]) {
+		t.Errorf("expected to read the batched value before commit, got %d bytes, err %v", len(value), err)
+	}
+	if err := store.CommitBatch(); err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+
+	check := func(store *Store) {
+		t.Helper()
+		for key, expected := range values {
+			value, found, err := store.Get([]byte(key))
+			if err != nil || !found || !bytes.Equal(value, expected) {
+				t.Errorf("expected %s to hold %d bytes, got %d, found %v, err %v", key, len(expected), len(value), found, err)
+			}
+		}
+	}
+	check(store)
+	store.Close()
+
+	records, report := inspectAll(t, dir)
+	if len(report.Corrupt) != 0 {
+		t.Fatalf("expected a clean log, got %+v", report)
+	}
+	compressed := make(map[string]LogRecord)
+	for _, r := range records {
+		if r.Type != RecordTypeBatch {
+			compressed[string(r.Key)] = r
+		}
+	}
+	for _, tt := range tests {
+		r := compressed[tt.key]
+		if r.Compressed != tt.compressed {
+			t.Errorf("expected %s to be compressed %v, got %v", tt.key, tt.compressed, r.Compressed)
+		}
+		if tt.compressed && r.Size >= uint64(len(values[tt.key])) {
+			t.Errorf("expected %s to take less than its %d bytes in the log, got %d", tt.key, len(values[tt.key]), r.Size)
+		}
+	}
+
+	// Values read back the same after the index is rebuilt from the log,
+	// and by a store that no longer compresses.
+	if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+		t.Fatalf("failed to remove index file: %v", err)
+	}
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	check(store)
+}
+
+func TestUnknownCompressor(t *testing.T) {
+	if err := RegisterCompressor(Flate); !errors.Is(err, ErrCompressorID) {
+		t.Errorf("expected registering a taken ID to fail with %v, got %v", ErrCompressorID, err)
+	}
+
+	dir := t.TempDir()
+	store, err := NewStoreWithOptions(dir, Options{Compression: reverser{}, CompressionThreshold: 16})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	if err := store.Put([]byte("key"), bytes.Repeat([]byte("x"), 100)); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	// Values name their compressor, so a failing one surfaces on read.
+	if _, _, err := store.Get([]byte("key")); err == nil || err.Error() != "reverser: lossy" {
+		t.Errorf("expected the compressor's error on read, got %v", err)
+	}
+	r := &Record{RecordType: RecordTypeInsert, Key: []byte("key"), compressed: []byte{199, 1, 2, 3}}
+	decoded, err := deserialize(r.serialize())
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if err := decoded.decompress(); !errors.Is(err, ErrUnknownCompressor) {
+		t.Errorf("expected %v, got %v", ErrUnknownCompressor, err)
+	}
+}
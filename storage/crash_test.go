@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/pager"
+)
+
+// crashOp is one step of the workload of TestCrashRecovery, along with
+// what it does to the keys of the store once it took effect.
+type crashOp struct {
+	name  string
+	run   func(s *Store) error
+	apply func(state map[string]string)
+}
+
+func crashWorkload() []crashOp {
+	var ops []crashOp
+	put := func(key, value string) {
+		ops = append(ops, crashOp{
+			name:  "put " + key,
+			run:   func(s *Store) error { return s.Put([]byte(key), []byte(value)) },
+			apply: func(state map[string]string) { state[key] = value },
+		})
+	}
+	del := func(key string) {
+		ops = append(ops, crashOp{
+			name: "delete " + key,
+			run: func(s *Store) error {
+				_, err := s.Delete([]byte(key))
+				return err
+			},
+			apply: func(state map[string]string) { delete(state, key) },
+		})
+	}
+	batch := func(puts map[string]string, deletes ...string) {
+		ops = append(ops, crashOp{
+			name: "batch",
+			run: func(s *Store) error {
+				if err := s.BeginBatch(); err != nil {
+					return err
+				}
+				for key, value := range puts {
+					if err := s.Put([]byte(key), []byte(value)); err != nil {
+						return err
+					}
+				}
+				for _, key := range deletes {
+					if _, err := s.Delete([]byte(key)); err != nil {
+						return err
+					}
+				}
+				return s.CommitBatch()
+			},
+			apply: func(state map[string]string) {
+				maps.Copy(state, puts)
+				for _, key := range deletes {
+					delete(state, key)
+				}
+			},
+		})
+	}
+
+	// Long keys spread the index over several pages, which the small cache
+	// of the store writes out as it goes. Values of 64 bytes and more are
+	// deduplicated, so the blobs they share have to survive as well.
+	key := func(i int) string { return fmt.Sprintf("key_%03d_%s", i, strings.Repeat("k", 80)) }
+	shared := string(bytes.Repeat([]byte("shared"), 16))
+	for i := range 40 {
+		key := key(i)
+		if i%5 == 0 {
+			put(key, shared)
+		} else {
+			put(key, "value_"+key)
+		}
+	}
+	for i := 0; i < 40; i += 3 {
+		put(key(i), fmt.Sprintf("updated_%03d", i))
+	}
+	for i := 1; i < 40; i += 4 {
+		del(key(i))
+	}
+	batch(map[string]string{"batch_a": "a", "batch_b": shared, key(2): "batched"}, key(3), key(4))
+	for i := 40; i < 60; i++ {
+		put(key(i), "late")
+	}
+	batch(map[string]string{"batch_c": "c"}, "batch_a")
+	return ops
+}
+
+// TestCrashRecovery crashes a store at every write of a workload, the
+// write itself failed or torn, and checks that the store reopens to the
+// keys of the operations that succeeded, plus perhaps those of the one
+// that failed, and passes Verify.
+func TestCrashRecovery(t *testing.T) {
+	opts := func(faults *pager.Faults) Options {
+		return Options{
+			SegmentSize:    512,
+			CacheSize:      4,
+			DedupThreshold: 64,
+			Faults:         faults,
+			// The periodic sync of a crashed store must not run, nor its
+			// failed writes be logged.
+			Clock:  clock.NewVirtual(time.Unix(0, 0)),
+			Logger: slog.New(slog.DiscardHandler),
+		}
+	}
+	ops := crashWorkload()
+
+	faults := &pager.Faults{}
+	store, err := NewStoreWithOptions(t.TempDir(), opts(faults))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for _, op := range ops {
+		if err := op.run(store); err != nil {
+			t.Fatalf("failed to %s: %v", op.name, err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	writes := faults.Writes()
+
+	step := 1
+	if testing.Short() {
+		step = 5
+	}
+	for n := 1; n <= writes; n += step {
+		// Every other crash tears its write instead of failing it whole.
+		torn := n % 2 * 9
+		t.Run(fmt.Sprintf("write %d torn %d", n, torn), func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			faults := &pager.Faults{}
+			store, err := NewStoreWithOptions(dir, opts(faults))
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			faults.CrashAt(n, torn)
+
+			committed := map[string]string{}
+			var failed *crashOp
+			for _, op := range ops {
+				if err := op.run(store); err != nil {
+					if !errors.Is(err, pager.ErrInjectedFault) {
+						t.Fatalf("expected %s to fail with the injected fault, got %v", op.name, err)
+					}
+					failed = &op
+					break
+				}
+				op.apply(committed)
+			}
+			if err := store.Close(); faults.Crashed() == (err == nil) {
+				t.Fatalf("expected Close to fail only after a crash, got %v", err)
+			}
+
+			store, err = NewStore(dir)
+			if err != nil {
+				t.Fatalf("failed to reopen: %v", err)
+			}
+			got := map[string]string{}
+			for key, value := range store.Range(nil, nil) {
+				got[string(key)] = string(value)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+
+			expected := maps.Clone(committed)
+			if failed != nil {
+				failed.apply(expected)
+			}
+			if !maps.Equal(got, committed) && !maps.Equal(got, expected) {
+				name := "nothing"
+				if failed != nil {
+					name = failed.name
+				}
+				t.Fatalf("expected the keys of the successful operations, and perhaps of %s, got %v, want %v", name, got, expected)
+			}
+
+			report, err := Verify(dir, VerifyOptions{})
+			if err != nil {
+				t.Fatalf("failed to verify: %v", err)
+			}
+			if !report.OK() {
+				t.Errorf("expected a sound store, got %+v", report)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+var (
+	ErrEncryptionKey   = errors.New("storage: encryption key shorter than 16 bytes")
+	ErrEncryptedEngine = errors.New("storage: engine cannot be encrypted")
+	ErrRecordAuth      = errors.New("storage: record failed authentication")
+)
+
+// The index pages and the records are encrypted under keys of their own,
+// derived from the key of the store.
+const (
+	pageKeyInfo   = "toydb index pages"
+	recordKeyInfo = "toydb data log records"
+)
+
+// recordNonceSize is the size of the random nonce that starts the sealed
+// key and value of an encrypted record.
+const recordNonceSize = 12
+
+// deriveKey returns the AES-256 key for info derived from secret, or nil
+// if there is no secret.
+func deriveKey(secret []byte, info string) ([]byte, error) {
+	if secret == nil {
+		return nil, nil
+	}
+	return hkdf.Key(sha256.New, secret, nil, info, 32)
+}
+
+// newRecordAEAD returns what encrypts the records of a store with secret,
+// or nil if there is no secret.
+func newRecordAEAD(secret []byte) (cipher.AEAD, error) {
+	if secret == nil {
+		return nil, nil
+	}
+	if len(secret) < 16 {
+		return nil, ErrEncryptionKey
+	}
+	key, err := deriveKey(secret, recordKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealedHeaderSize returns the length of the header of a record with a
+// checksum from its first byte.
+func sealedHeaderSize(flags byte) int {
+	if flags&recordExpiryFlag != 0 {
+		return checksumHeaderSize + expirySize
+	}
+	return checksumHeaderSize
+}
+
+// recordAAD returns what the sealed key and value of a record are
+// authenticated along with: its header but for the checksum, which covers
+// them.
+func recordAAD(data []byte, headerSize int) []byte {
+	aad := make([]byte, 0, headerSize)
+	aad = append(aad, data[:recordHeaderSize]...)
+	return append(aad, data[checksumHeaderSize:headerSize]...)
+}
+
+// sealRecord encrypts the key and value of a serialized record with a
+// checksum. The record sets recordEncryptedFlag and holds a random nonce
+// followed by them sealed, with its value length grown by the nonce and
+// the tag, so that recordSize still tells its length.
+func sealRecord(aead cipher.AEAD, data []byte) ([]byte, error) {
+	headerSize := sealedHeaderSize(data[0])
+	keyLen := int(binary.LittleEndian.Uint32(data[1:5]))
+	body := data[headerSize:]
+
+	sealed := make([]byte, headerSize+recordNonceSize, headerSize+recordNonceSize+len(body)+aead.Overhead())
+	copy(sealed, data[:headerSize])
+	sealed[0] |= recordEncryptedFlag
+	binary.LittleEndian.PutUint32(sealed[5:9], uint32(len(body)-keyLen+recordNonceSize+aead.Overhead()))
+	nonce := sealed[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed = aead.Seal(sealed, nonce, body, recordAAD(sealed, headerSize))
+	binary.LittleEndian.PutUint32(sealed[recordHeaderSize:], recordCRC(sealed))
+	return sealed, nil
+}
+
+// unseal decrypts the key and value of a record read from the log, if
+// they are encrypted. A record whose checksum matches but that fails
+// authentication was written with another key or tampered with, and fails
+// with ErrRecordAuth.
+func (s *Store) unseal(r *Record) error {
+	if r.sealed == nil {
+		return nil
+	}
+	if s.aead == nil {
+		return pager.ErrKeyRequired
+	}
+	data := r.sealed
+	headerSize := sealedHeaderSize(data[0])
+	keyLen := int(binary.LittleEndian.Uint32(data[1:5]))
+	body := data[headerSize:]
+	if len(body) < recordNonceSize {
+		return ErrRecordAuth
+	}
+	plain, err := s.aead.Open(nil, body[:recordNonceSize], body[recordNonceSize:], recordAAD(data, headerSize))
+	if err != nil || len(plain) < keyLen {
+		return ErrRecordAuth
+	}
+
+	r.Key = plain[:keyLen]
+	if value := plain[keyLen:]; r.RecordType != RecordTypeDelete && len(value) > 0 {
+		if data[0]&recordCompressedFlag != 0 {
+			r.compressed = value
+		} else {
+			r.Value = value
+		}
+	}
+	return nil
+}
+
+// writeKeyCheck writes a key check record, sealed with the key of the
+// store, at the start of a segment of an encrypted log.
+func (s *Store) writeKeyCheck() error {
+	if s.aead == nil {
+		return nil
+	}
+	sealed, err := sealRecord(s.aead, (&Record{RecordType: RecordTypeKeyCheck}).serialize())
+	if err != nil {
+		return fmt.Errorf("storage: failed to encrypt key check: %w", err)
+	}
+	if err := s.pager.WriteAtOffset(s.offset, sealed); err != nil {
+		return fmt.Errorf("storage: failed to write key check: %w", err)
+	}
+	s.unsynced.Store(true)
+	s.offset += uint64(len(sealed))
+	return nil
+}
+
+// checkKey matches the key the store was opened with against the key
+// check record that starts its log, or in a log from before them its
+// first record that has a key, before the index is opened, so that the
+// key of a store whose index is gone, as after a restore, is checked as
+// well.
+func (s *Store) checkKey() error {
+	offset := s.segments.next(0)
+	for {
+		r, err := readLogRecord(s.pager, offset)
+		if err != nil {
+			return nil
+		}
+		switch r.RecordType {
+		case RecordTypeBatch:
+			offset += batchHeaderSize
+			continue
+		case RecordTypeTime:
+			offset = s.segments.next(offset + r.size())
+			continue
+		}
+
+		if r.sealed == nil {
+			if s.aead != nil {
+				return pager.ErrNotEncrypted
+			}
+			return nil
+		}
+		err = s.unseal(r)
+		if errors.Is(err, ErrRecordAuth) {
+			return pager.ErrWrongKey
+		}
+		return err
+	}
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/pager"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+// dirContains reports whether any file in dir holds data.
+func dirContains(t *testing.T, dir string, data []byte) bool {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", entry.Name(), err)
+		}
+		if bytes.Contains(content, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEncryptedStore(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{EncryptionKey: testEncryptionKey, Compression: Flate, CompressionThreshold: 64}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	putKeys(t, store, 0, 100)
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	putKeys(t, store, 100, 200)
+	if err := store.CommitBatch(); err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+	long := bytes.Repeat([]byte("secret "), 100)
+	if err := store.Put([]byte("long"), long); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := store.Write([]byte("inline"), []byte("inline secret"), index.Upsert, LayoutInline); err != nil {
+		t.Fatalf("failed to write inline: %v", err)
+	}
+	if err := store.PutWithTTL([]byte("expiring"), []byte("expiring secret"), time.Hour); err != nil {
+		t.Fatalf("failed to put with ttl: %v", err)
+	}
+	if _, err := store.Delete([]byte("key_000")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	for _, data := range []string{"key_050", "value_150", "secret", "inline", "expiring"} {
+		if dirContains(t, dir, []byte(data)) {
+			t.Errorf("expected no file to hold %q in the clear", data)
+		}
+	}
+
+	check := func(store *Store) {
+		t.Helper()
+		if _, found, err := store.Get([]byte("key_000")); err != nil || found {
+			t.Errorf("expected key_000 to be deleted, found %v, err %v", found, err)
+		}
+		for key, value := range map[string][]byte{
+			"key_199":  []byte("value_199"),
+			"long":     long,
+			"inline":   []byte("inline secret"),
+			"expiring": []byte("expiring secret"),
+		} {
+			got, found, err := store.Get([]byte(key))
+			if err != nil || !found || !bytes.Equal(got, value) {
+				t.Errorf("expected %s to hold %.20q, got %.20q, found %v, err %v", key, value, got, found, err)
+			}
+		}
+		if count, err := store.Count(nil, nil); err != nil || count != 202 {
+			t.Errorf("expected 202 keys, got %d, err %v", count, err)
+		}
+	}
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	check(store)
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// Without its index the log alone tells a wrong key, and the index
+	// is rebuilt once the right one is given.
+	for _, removeIndex := range []bool{false, true} {
+		if removeIndex {
+			if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+				t.Fatalf("failed to remove index: %v", err)
+			}
+		}
+		tests := []struct {
+			name string
+			key  []byte
+			err  error
+		}{
+			{"wrong key", []byte("fedcba9876543210"), pager.ErrWrongKey},
+			{"no key", nil, pager.ErrKeyRequired},
+		}
+		for _, tt := range tests {
+			if _, err := NewStoreWithOptions(dir, Options{EncryptionKey: tt.key}); !errors.Is(err, tt.err) {
+				t.Errorf("index removed %v, %s: expected %v, got %v", removeIndex, tt.name, tt.err, err)
+			}
+		}
+	}
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store without index: %v", err)
+	}
+	defer store.Close()
+	check(store)
+}
+
+func TestEncryptedKeyCheck(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{EncryptionKey: testEncryptionKey, SegmentSize: 256}
+	tryKeys := func(stage string) {
+		t.Helper()
+		if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+			t.Fatalf("failed to remove index: %v", err)
+		}
+		if _, err := NewStoreWithOptions(dir, Options{EncryptionKey: []byte("fedcba9876543210")}); !errors.Is(err, pager.ErrWrongKey) {
+			t.Errorf("%s, wrong key: expected %v, got %v", stage, pager.ErrWrongKey, err)
+		}
+		if _, err := NewStoreWithOptions(dir, Options{}); !errors.Is(err, pager.ErrKeyRequired) {
+			t.Errorf("%s, no key: expected %v, got %v", stage, pager.ErrKeyRequired, err)
+		}
+	}
+
+	// A new store holds no keys, but the key check tells a wrong key.
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	tryKeys("empty store")
+
+	// Every segment starts with one, so emptying the first ones leaves it.
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	putKeys(t, store, 0, 30)
+	if _, err := store.Forget(nil, nil); err != nil {
+		t.Fatalf("failed to forget: %v", err)
+	}
+	if dropped, err := store.DropDeadSegments(); err != nil || dropped == 0 {
+		t.Fatalf("expected segments to be emptied, got %d, err %v", dropped, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+	records, _ := inspectAll(t, dir)
+	for _, record := range records {
+		if first := record.Offset&segmentOffsetMask == 0; first != (record.Type == RecordTypeKeyCheck) {
+			t.Errorf("expected key checks only at the start of segments, got %s at %x", record.Type, record.Offset)
+		}
+	}
+	tryKeys("emptied segments")
+}
+
+func TestEncryptionKeyOptions(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	putKeys(t, store, 0, 10)
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		opts Options
+		err  error
+	}{
+		{"store not encrypted", dir, Options{EncryptionKey: testEncryptionKey}, pager.ErrNotEncrypted},
+		{"short key", t.TempDir(), Options{EncryptionKey: []byte("short")}, ErrEncryptionKey},
+		{"lsm", t.TempDir(), Options{EncryptionKey: testEncryptionKey, Engine: EngineLSM}, ErrEncryptedEngine},
+	}
+	for _, tt := range tests {
+		if _, err := NewStoreWithOptions(tt.dir, tt.opts); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestEncryptedRecordTampered(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{EncryptionKey: testEncryptionKey}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	putKeys(t, store, 0, 2)
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	// A record whose ciphertext changed along with its checksum passes
+	// the checksum but not authentication.
+	path := filepath.Join(dir, segmentFile(0))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	// The log holds the key check, key_000 and key_001.
+	third := recordSize(data)
+	third += recordSize(data[third:])
+	record := data[third : third+recordSize(data[third:])]
+	record[len(record)-1] ^= 1
+	binary.LittleEndian.PutUint32(record[recordHeaderSize:], recordCRC(record))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if _, _, err := store.Get([]byte("key_001")); !errors.Is(err, ErrRecordAuth) {
+		t.Errorf("expected %v, got %v", ErrRecordAuth, err)
+	}
+
+	report, err := InspectLog(dir, func(r LogRecord) error {
+		if !r.Encrypted || r.Key != nil {
+			t.Errorf("expected record at %d to be encrypted without a key, got %+v", r.Offset, r)
+		}
+		return nil
+	})
+	if err != nil || report.Records != 3 {
+		t.Errorf("expected 3 records, got %+v, err %v", report, err)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/lsm"
+	"github.com/rizalta/toydb/pager"
+)
+
+var (
+	ErrUnknownEngine  = errors.New("storage: unknown engine")
+	ErrInMemoryEngine = errors.New("storage: engine cannot run in memory")
+	ErrReadOnlyEngine = errors.New("storage: engine cannot be opened read-only")
+)
+
+// Engine selects what maps keys to their records in the data log.
+type Engine uint8
+
+const (
+	// EngineBTree keeps the keys in a B+tree of pages in index.db.
+	EngineBTree Engine = iota
+	// EngineLSM keeps the keys in a log-structured merge tree in the lsm
+	// directory. Writes only append to the memtable, and the data log,
+	// which every key is recovered from, serves as its write-ahead log.
+	EngineLSM
+)
+
+const lsmDir = "lsm"
+
+// btree adapts index.Index to Index.
+type btree struct {
+	*index.Index
+	pages *pager.Pager
+}
+
+func (b btree) NewCursor(startKey, endKey []byte) (Cursor, error) {
+	c, err := b.Index.NewCursor(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// lsmTree adapts lsm.Tree to Index.
+type lsmTree struct {
+	*lsm.Tree
+}
+
+func (t lsmTree) NewCursor(startKey, endKey []byte) (Cursor, error) {
+	c, err := t.Tree.NewCursor(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// openIndex opens the index of the engine in dataDir, making every index
+// write wait for barrier unless it is nil, and reports whether it existed
+// before.
+func openIndex(dataDir string, opts Options, barrier func() error) (Index, *pager.Pager, bool, error) {
+	switch opts.Engine {
+	case EngineBTree:
+		indexPath := filepath.Join(dataDir, indexFile)
+		statErr := os.ErrNotExist
+		if !opts.InMemory {
+			_, statErr = os.Stat(indexPath)
+		}
+		if opts.ReadOnly && statErr != nil {
+			return nil, nil, false, ErrNeedsRecovery
+		}
+		pageKey, err := deriveKey(opts.EncryptionKey, pageKeyInfo)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		indexPager, err := pager.NewPagerWithOptions(indexPath, pager.Options{
+			Clock:     opts.Clock,
+			InMemory:  opts.InMemory,
+			CacheSize: opts.CacheSize,
+			Eviction:  opts.Eviction,
+			Mmap:      opts.Mmap,
+			Compress:  opts.CompressPages,
+			Key:       pageKey,
+			ReadOnly:  opts.ReadOnly,
+			Logger:    opts.Logger,
+			Trace:     opts.Trace,
+			Metrics:   opts.Metrics,
+			Faults:    opts.Faults,
+		})
+		if err != nil {
+			return nil, nil, false, err
+		}
+		idx, err := index.NewIndexWithOptions(indexPager, index.Options{
+			Checksum: opts.Checksum,
+			ReadOnly: opts.ReadOnly,
+			Trace:    opts.Trace,
+			Metrics:  opts.Metrics,
+		})
+		if err != nil {
+			indexPager.Close()
+			return nil, nil, false, err
+		}
+		if barrier != nil {
+			indexPager.SetWriteBarrier(barrier)
+		}
+		return btree{idx, indexPager}, indexPager, statErr == nil, nil
+
+	case EngineLSM:
+		dir := filepath.Join(dataDir, lsmDir)
+		_, statErr := os.Stat(dir)
+		tree, err := lsm.Open(dir, lsm.Options{Checksum: opts.Checksum, Metrics: opts.Metrics})
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if barrier != nil {
+			tree.SetWriteBarrier(barrier)
+		}
+		return lsmTree{tree}, nil, statErr == nil, nil
+
+	default:
+		return nil, nil, false, ErrUnknownEngine
+	}
+}
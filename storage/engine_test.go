@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLSMEngine(t *testing.T) {
+	dir := t.TempDir()
+	open := func(engine Engine) *Store {
+		t.Helper()
+		store, err := NewStoreWithOptions(dir, Options{Engine: engine})
+		if err != nil {
+			t.Fatalf("failed to open store: %v", err)
+		}
+		return store
+	}
+	check := func(store *Store) {
+		t.Helper()
+		for i := range 3000 {
+			value, found, err := store.Get(fmt.Appendf(nil, "key_%05d", i))
+			if err != nil {
+				t.Fatalf("failed to get: %v", err)
+			}
+			want := fmt.Sprintf("value_%d", i)
+			if i%3 == 0 {
+				want = ""
+			}
+			if found != (want != "") || string(value) != want {
+				t.Fatalf("expected %q for key_%05d, got %q (found %v)", want, i, value, found)
+			}
+		}
+		if count, err := store.Count(nil, nil); err != nil || count != 2000 {
+			t.Errorf("expected 2000 keys, got %d, err %v", count, err)
+		}
+	}
+
+	store := open(EngineLSM)
+	for i := range 3000 {
+		if err := store.Put(fmt.Appendf(nil, "key_%05d", i), fmt.Appendf(nil, "value_%d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	for i := 0; i < 3000; i += 3 {
+		if _, err := store.Delete(fmt.Appendf(nil, "key_%05d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := store.CommitBatch(); err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+	check(store)
+	if stats, err := store.IndexStats(); err != nil || stats != nil {
+		t.Errorf("expected no index stats, got %v, err %v", stats, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	t.Run("clean reopen", func(t *testing.T) {
+		store := open(EngineLSM)
+		check(store)
+		store.Close()
+	})
+
+	t.Run("unclean reopen", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+			t.Fatalf("failed to remove lock file: %v", err)
+		}
+		store := open(EngineLSM)
+		check(store)
+		store.Close()
+	})
+
+	t.Run("lost index", func(t *testing.T) {
+		if err := os.RemoveAll(filepath.Join(dir, lsmDir)); err != nil {
+			t.Fatalf("failed to remove index: %v", err)
+		}
+		store := open(EngineLSM)
+		check(store)
+		store.Close()
+	})
+
+	t.Run("switch engine", func(t *testing.T) {
+		store := open(EngineBTree)
+		check(store)
+		store.Close()
+	})
+}
+
+func BenchmarkEnginePut(b *testing.B) {
+	for _, engine := range []struct {
+		name   string
+		engine Engine
+	}{{"btree", EngineBTree}, {"lsm", EngineLSM}} {
+		b.Run(engine.name, func(b *testing.B) {
+			store, err := NewStoreWithOptions(b.TempDir(), Options{Engine: engine.engine, NoSyncBarrier: true})
+			if err != nil {
+				b.Fatalf("failed to open store: %v", err)
+			}
+			defer store.Close()
+
+			value := make([]byte, 100)
+			i := 0
+			for b.Loop() {
+				if err := store.Put(fmt.Appendf(nil, "key_%08d", i*7919%1000003), value); err != nil {
+					b.Fatalf("failed to put: %v", err)
+				}
+				i++
+			}
+		})
+	}
+}
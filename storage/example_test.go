@@ -0,0 +1,89 @@
+package storage_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rizalta/toydb/storage"
+)
+
+func ExampleStore() {
+	dir, err := os.MkdirTemp("", "toydb-store")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.Add([]byte("apple"), []byte("red")); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.Put([]byte("banana"), []byte("yellow")); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.Update([]byte("apple"), []byte("green")); err != nil {
+		log.Fatal(err)
+	}
+
+	value, found, err := store.Get([]byte("apple"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(value), found)
+
+	deleted, err := store.Delete([]byte("banana"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, found, _ = store.Get([]byte("banana"))
+	fmt.Println(deleted, found)
+
+	// Output:
+	// green true
+	// true false
+}
+
+func ExampleStore_NewIterator() {
+	dir, err := os.MkdirTemp("", "toydb-store")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, fruit := range []string{"cherry", "apple", "date", "banana"} {
+		if err := store.Put([]byte(fruit), []byte(fruit[:1])); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	it, err := store.NewIterator([]byte("b"), []byte("d"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		key, value, err := it.Next()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if key == nil {
+			break
+		}
+		fmt.Printf("%s=%s\n", key, value)
+	}
+
+	// Output:
+	// banana=b
+	// cherry=c
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"slices"
+)
+
+var ErrInvalidRegionSize = errors.New("storage: region size must be positive")
+
+// RegionStats describes the records in a stretch of the data log. A record
+// is live while it is the latest record of a key the index holds; delete
+// records and the records they or later writes superseded are dead.
+type RegionStats struct {
+	Start, End uint64
+	Records    int
+	Live       int
+	Tombstones int
+	LiveBytes  uint64
+	DeadBytes  uint64
+}
+
+// DeadRatio returns the share of the bytes in the region that are dead.
+func (r RegionStats) DeadRatio() float64 {
+	total := r.LiveBytes + r.DeadBytes
+	if total == 0 {
+		return 0
+	}
+	return float64(r.DeadBytes) / float64(total)
+}
+
+// GarbageStats splits the data log into regions of regionSize bytes,
+// rounded up to whole records and cut short at the end of a segment, and
+// reports how much of each is dead, in log order. Deleted keys are not in
+// the index, so scans never visit them; the dead records only take up room
+// in the log, and the regions with the most of it are where reclaiming
+// space pays off first.
+func (s *Store) GarbageStats(regionSize uint64) ([]RegionStats, error) {
+	if regionSize == 0 {
+		return nil, ErrInvalidRegionSize
+	}
+
+	background := s.backgroundPager()
+	latest := make(map[string]uint64)
+	// previous holds the record each merge record points back at, which
+	// stays live as long as the merge record does.
+	previous := make(map[uint64]uint64)
+	for offset := s.segments.next(0); offset < s.offset; {
+		r, err := s.readStoredRecord(background, offset)
+		if err != nil {
+			return nil, err
+		}
+		if r.RecordType != RecordTypeBatch && !r.RecordType.mark() {
+			latest[string(r.Key)] = offset
+		}
+		if r.RecordType == RecordTypeMerge {
+			if previous[offset], _, _, _, err = decodeMerge(r.Value); err != nil {
+				return nil, err
+			}
+		}
+		offset = s.segments.next(offset + r.size())
+	}
+	chained := make(map[uint64]bool)
+	for _, offset := range latest {
+		for {
+			p, ok := previous[offset]
+			if !ok || p == noPrevious {
+				break
+			}
+			chained[p] = true
+			offset = p
+		}
+	}
+
+	var regions []RegionStats
+	region := RegionStats{Start: s.segments.next(0)}
+	for offset := region.Start; offset < s.offset; {
+		r, err := s.readStoredRecord(background, offset)
+		if err != nil {
+			return nil, err
+		}
+		size := r.size()
+
+		live := false
+		if r.RecordType != RecordTypeDelete && r.RecordType != RecordTypeBatch &&
+			(latest[string(r.Key)] == offset || chained[offset]) {
+			if live, err = s.Has(r.Key); err != nil {
+				return nil, err
+			}
+		}
+
+		region.Records++
+		if r.RecordType == RecordTypeDelete {
+			region.Tombstones++
+		}
+		if live {
+			region.Live++
+			region.LiveBytes += size
+		} else {
+			region.DeadBytes += size
+		}
+
+		offset += size
+		next := s.segments.next(offset)
+		if offset-region.Start >= regionSize || next != offset || offset == s.offset {
+			region.End = offset
+			regions = append(regions, region)
+			region = RegionStats{Start: next}
+		}
+		offset = next
+	}
+
+	return regions, nil
+}
+
+// DropDeadSegments empties the sealed segments of the data log whose
+// records are all dead, as forgetting, deleting or overwriting all their
+// keys leaves them, and returns how many it emptied. Their files are cut
+// to nothing but stay, as the positions of later segments carry their
+// numbers. A segment holding delete records only goes once all segments
+// before it are empty, as a delete record may be what keeps a key of an
+// earlier one deleted. A forgotten key that an index rebuilt from the log
+// has again may come back with the value of an older record. A store that
+// archives its log keeps the segments it has not archived yet. Like
+// GarbageStats it walks the whole data log at background priority. No
+// batch may be open.
+func (s *Store) DropDeadSegments() (int, error) {
+	if s.readOnly || s.follower {
+		return 0, ErrReadOnly
+	}
+	if s.batch != nil {
+		return 0, ErrBatchOpen
+	}
+
+	s.segments.mu.RLock()
+	sealed := len(s.segments.sizes)
+	s.segments.mu.RUnlock()
+	if s.archiveDir != "" {
+		sealed = min(sealed, s.archived)
+	}
+	if segmentOf(s.segments.next(0)) >= sealed {
+		return 0, nil
+	}
+
+	regions, err := s.GarbageStats(math.MaxUint64)
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	leading := true
+	for _, region := range regions {
+		segment := segmentOf(region.Start)
+		if segment >= sealed {
+			break
+		}
+		if region.Live > 0 || region.Tombstones > 0 && !leading {
+			leading = false
+			continue
+		}
+		if err := s.segments.empty(segment); err != nil {
+			return dropped, err
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+// DeadestFirst orders regions by their dead bytes, most first, which is the
+// order in which compacting them frees the most space soonest.
+func DeadestFirst(regions []RegionStats) {
+	slices.SortStableFunc(regions, func(a, b RegionStats) int {
+		return cmp.Compare(b.DeadBytes, a.DeadBytes)
+	})
+}
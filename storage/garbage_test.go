@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGarbageStats(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	// The first 100 keys are overwritten and then deleted, the last 100
+	// are written once.
+	value := make([]byte, 100)
+	for round := range 2 {
+		for i := range 100 {
+			if err := store.Put(fmt.Appendf(nil, "key_%03d", i), value); err != nil {
+				t.Fatalf("failed to put in round %d: %v", round, err)
+			}
+		}
+	}
+	for i := range 100 {
+		if _, err := store.Delete(fmt.Appendf(nil, "key_%03d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	for i := 100; i < 200; i++ {
+		if err := store.Put(fmt.Appendf(nil, "key_%03d", i), value); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+
+	if _, err := store.GarbageStats(0); err != ErrInvalidRegionSize {
+		t.Errorf("expected %v, got %v", ErrInvalidRegionSize, err)
+	}
+
+	regions, err := store.GarbageStats(store.offset / 4)
+	if err != nil {
+		t.Fatalf("failed to collect stats: %v", err)
+	}
+	var total RegionStats
+	for i, r := range regions {
+		if i > 0 && r.Start != regions[i-1].End {
+			t.Errorf("expected region %d to start at %d, got %d", i, regions[i-1].End, r.Start)
+		}
+		total.Records += r.Records
+		total.Live += r.Live
+		total.Tombstones += r.Tombstones
+	}
+	if len(regions) < 4 || regions[len(regions)-1].End != store.offset {
+		t.Fatalf("expected at least 4 regions covering the log, got %d ending at %d", len(regions), regions[len(regions)-1].End)
+	}
+	if total.Records != 400 || total.Live != 100 || total.Tombstones != 100 {
+		t.Errorf("expected 400 records, 100 live and 100 tombstones, got %+v", total)
+	}
+	if first, last := regions[0], regions[len(regions)-1]; first.DeadRatio() != 1 || last.DeadRatio() != 0 {
+		t.Errorf("expected the first region all dead and the last all live, got %v and %v", first.DeadRatio(), last.DeadRatio())
+	}
+
+	DeadestFirst(regions)
+	for i := 1; i < len(regions); i++ {
+		if regions[i].DeadBytes > regions[i-1].DeadBytes {
+			t.Errorf("expected regions ordered by dead bytes, got %d after %d", regions[i].DeadBytes, regions[i-1].DeadBytes)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rizalta/toydb/checksum"
+	"github.com/rizalta/toydb/pager"
+)
+
+var ErrNotRecordOffset = errors.New("storage: offset does not start a record")
+
+// LogRecord is a record of the data log, which is the write-ahead log of
+// the store, as InspectLog decodes it.
+type LogRecord struct {
+	// Offset is the log position of the record: its segment number in the
+	// high bits and where it starts in the segment file in the rest. It
+	// orders records the way a log sequence number would.
+	Offset uint64
+	Type   RecordType
+	Key    []byte
+	// Size is the length of the record, header included. The records of a
+	// batch follow its batch record and are not part of its size.
+	Size uint64
+	// Batch is the offset of the batch record heading the record, if
+	// Batched is set.
+	Batch   uint64
+	Batched bool
+	// Checksum is the algorithm a batch record was written with, and
+	// Intact whether the records of the batch match its checksum. Other
+	// records carry a CRC-32 of their own, checked as they are read, and
+	// are always intact.
+	Checksum checksum.Algorithm
+	Intact   bool
+	// Compressed is set if the value of the record is compressed.
+	Compressed bool
+	// Encrypted is set if the key and value of the record are encrypted,
+	// which leaves Key nil, as InspectLog has no key to decrypt them.
+	Encrypted bool
+	// Time is the time a RecordTypeTime record holds.
+	Time time.Time
+}
+
+// LogReport sums up a walk over the data log.
+type LogReport struct {
+	Records int
+	// End is the position past the last record that could be read, and
+	// Size the position past the end of the last segment file. Bytes in
+	// between are a write torn by a crash, which recovery drops.
+	End  uint64
+	Size uint64
+	// Corrupt holds the offsets of batch records whose records do not
+	// match their checksum, and of a record that does not match its own,
+	// where the walk stops. Recovery stops at the first of them.
+	Corrupt []uint64
+}
+
+// InspectLog walks the data log of a data directory that no store has open,
+// calling fn with each record in order, and verifies the checksum of every
+// record and batch. It stops at the first record it cannot read or that
+// is corrupt.
+func InspectLog(dataDir string, fn func(LogRecord) error) (*LogReport, error) {
+	p, err := openSegmentLog(dataDir, pager.Options{}, false)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	report := &LogReport{}
+	if report.Size, err = p.end(); err != nil {
+		return nil, err
+	}
+
+	var batch, batchEnd uint64
+	offset := p.next(0)
+	for offset < report.Size {
+		r, err := readLogRecord(p, offset)
+		if errors.Is(err, ErrCorruptRecord) {
+			report.Corrupt = append(report.Corrupt, offset)
+		}
+		if err != nil {
+			break
+		}
+
+		record := LogRecord{
+			Offset:     offset,
+			Type:       r.RecordType,
+			Key:        r.Key,
+			Size:       r.size(),
+			Intact:     true,
+			Compressed: r.compressed != nil || r.sealed != nil && r.sealed[0]&recordCompressedFlag != 0,
+			Encrypted:  r.sealed != nil,
+		}
+		if offset < batchEnd {
+			record.Batch, record.Batched = batch, true
+		}
+		if r.RecordType == RecordTypeBatch {
+			record.Size = batchHeaderSize
+			record.Intact = batchIntact(p, offset, r)
+			if len(r.Value) == 12 {
+				record.Checksum = checksum.Algorithm(binary.LittleEndian.Uint64(r.Value) >> batchAlgorithmShift)
+			}
+			if !record.Intact {
+				report.Corrupt = append(report.Corrupt, offset)
+			}
+			batch, batchEnd = offset, offset+batchHeaderSize+batchLength(r)
+		}
+		if r.RecordType == RecordTypeTime && len(r.Value) == 8 {
+			record.Time = time.Unix(0, int64(binary.LittleEndian.Uint64(r.Value)))
+		}
+
+		if err := fn(record); err != nil {
+			return nil, err
+		}
+		report.Records++
+		offset = p.next(offset + record.Size)
+	}
+
+	report.End = offset
+	return report, nil
+}
+
+// batchLength returns the length of the records of the batch whose batch
+// record is r.
+func batchLength(r *Record) uint64 {
+	if len(r.Value) != 12 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(r.Value) & batchLengthMask
+}
+
+// ReplayLog builds in copyDir a copy of the database in dataDir, which no
+// store has open, as it was after the record at offset lsn. A record in a
+// batch brings in the rest of the batch, as the store never held part of
+// one. Pass math.MaxUint64 to replay the whole log. The copy gets a new
+// index, rebuilt from the replayed log, or when the log is encrypted by
+// the first open of the copy with its key.
+func ReplayLog(dataDir, copyDir string, lsn uint64) error {
+	var end, batch uint64
+	found, inBatch := lsn == math.MaxUint64, false
+	_, err := InspectLog(dataDir, func(r LogRecord) error {
+		switch {
+		case r.Offset == lsn:
+			found = true
+			if r.Type == RecordTypeBatch {
+				batch, inBatch = r.Offset, true
+			} else if r.Batched {
+				batch, inBatch = r.Batch, true
+			}
+		case r.Offset > lsn && !(inBatch && r.Batched && r.Batch == batch):
+			return errStopReplay
+		}
+		end = r.Offset + r.Size
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopReplay) {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %d", ErrNotRecordOffset, lsn)
+	}
+
+	if err := copyLog(dataDir, copyDir, end); err != nil {
+		return err
+	}
+	s, err := NewStore(copyDir)
+	if errors.Is(err, pager.ErrKeyRequired) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+var errStopReplay = errors.New("storage: replay reached its offset")
+
+// copyLog copies the data log in dataDir up to position end to a new data
+// log in copyDir.
+func copyLog(dataDir, copyDir string, end uint64) error {
+	src, err := openSegmentLog(dataDir, pager.Options{}, false)
+	if err != nil {
+		return err
+	}
+	paths := src.paths
+	if err := src.Close(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(copyDir, 0o755); err != nil {
+		return err
+	}
+	last := segmentOf(end)
+	for segment := 0; segment <= last; segment++ {
+		n := int64(-1)
+		if segment == last {
+			n = int64(end & segmentOffsetMask)
+		}
+		if err := copyFile(paths[segment], filepath.Join(copyDir, segmentFile(segment)), n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the first n bytes of src, or all of it if n is negative,
+// to a new file dst.
+func copyFile(src, dst string, n int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		_, err = io.Copy(out, in)
+	} else {
+		_, err = io.CopyN(out, in, n)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeInspectLog fills a store with two puts, a batch of a put and a
+// delete, and a last put, and closes it.
+func writeInspectLog(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	must(store.Put([]byte("a"), []byte("a_1")))
+	must(store.Put([]byte("b"), []byte("b_1")))
+	must(store.BeginBatch())
+	must(store.Put([]byte("a"), []byte("a_2")))
+	_, err = store.Delete([]byte("b"))
+	must(err)
+	must(store.CommitBatch())
+	must(store.Put([]byte("c"), []byte("c_1")))
+	must(store.Close())
+	return dir
+}
+
+func inspectAll(t *testing.T, dir string) ([]LogRecord, *LogReport) {
+	t.Helper()
+	var records []LogRecord
+	report, err := InspectLog(dir, func(r LogRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to inspect log: %v", err)
+	}
+	return records, report
+}
+
+func TestInspectLog(t *testing.T) {
+	dir := writeInspectLog(t)
+	records, report := inspectAll(t, dir)
+
+	expected := []struct {
+		typ     RecordType
+		key     string
+		batched bool
+	}{
+		{RecordTypeInsert, "a", false},
+		{RecordTypeInsert, "b", false},
+		{RecordTypeBatch, "", false},
+		{RecordTypeInsert, "a", true},
+		{RecordTypeDelete, "b", true},
+		{RecordTypeInsert, "c", false},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(records))
+	}
+	offset := uint64(0)
+	for i, want := range expected {
+		r := records[i]
+		if r.Offset != offset || r.Type != want.typ || string(r.Key) != want.key || r.Batched != want.batched {
+			t.Errorf("record %d: expected %v %q at %d (batched %v), got %v %q at %d (batched %v)",
+				i, want.typ, want.key, offset, want.batched, r.Type, r.Key, r.Offset, r.Batched)
+		}
+		if r.Batched && r.Batch != records[2].Offset {
+			t.Errorf("record %d: expected batch at %d, got %d", i, records[2].Offset, r.Batch)
+		}
+		if !r.Intact {
+			t.Errorf("record %d: expected intact", i)
+		}
+		offset += r.Size
+	}
+	if report.Records != len(expected) || report.End != offset || report.Size != offset || len(report.Corrupt) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// Flip a byte of the batched put's value.
+	file, err := os.OpenFile(filepath.Join(dir, segmentFile(0)), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("X"), int64(records[4].Offset-1)); err != nil {
+		t.Fatalf("failed to corrupt log: %v", err)
+	}
+	file.Close()
+
+	// Both the batch and the put fail their checksums, and the walk stops
+	// at the put.
+	batch, put := records[2].Offset, records[3].Offset
+	records, report = inspectAll(t, dir)
+	if len(records) != 3 || records[2].Intact || !slices.Equal(report.Corrupt, []uint64{batch, put}) || report.End != put {
+		t.Errorf("expected the batch at %d and the put at %d to be corrupt, got %+v", batch, put, report)
+	}
+
+	if _, err := InspectLog(t.TempDir(), func(LogRecord) error { return nil }); !os.IsNotExist(err) {
+		t.Errorf("expected a missing log to fail, got %v", err)
+	}
+}
+
+func TestReplayLog(t *testing.T) {
+	dir := writeInspectLog(t)
+	records, _ := inspectAll(t, dir)
+
+	tests := []struct {
+		name     string
+		lsn      uint64
+		expected map[string]string
+	}{
+		{"first", records[0].Offset, map[string]string{"a": "a_1"}},
+		{"before batch", records[1].Offset, map[string]string{"a": "a_1", "b": "b_1"}},
+		{"batch record", records[2].Offset, map[string]string{"a": "a_2"}},
+		{"inside batch", records[3].Offset, map[string]string{"a": "a_2"}},
+		{"all", math.MaxUint64, map[string]string{"a": "a_2", "c": "c_1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copyDir := filepath.Join(t.TempDir(), "copy")
+			if err := ReplayLog(dir, copyDir, tt.lsn); err != nil {
+				t.Fatalf("failed to replay: %v", err)
+			}
+			store, err := NewStore(copyDir)
+			if err != nil {
+				t.Fatalf("failed to open copy: %v", err)
+			}
+			defer store.Close()
+
+			for _, key := range []string{"a", "b", "c"} {
+				value, found, err := store.Get([]byte(key))
+				if err != nil {
+					t.Fatalf("failed to get %s: %v", key, err)
+				}
+				want, ok := tt.expected[key]
+				if found != ok || string(value) != want {
+					t.Errorf("expected %s to hold %q (found %v), got %q (found %v)", key, want, ok, value, found)
+				}
+			}
+		})
+	}
+
+	err := ReplayLog(dir, filepath.Join(t.TempDir(), "copy"), records[0].Offset+1)
+	if !errors.Is(err, ErrNotRecordOffset) {
+		t.Errorf("expected %v, got %v", ErrNotRecordOffset, err)
+	}
+}
@@ -1,12 +1,22 @@
 package storage
 
+import (
+	"bytes"
+	"errors"
+	"iter"
+
+	"github.com/rizalta/toydb/index"
+)
+
 type Cursor interface {
 	Next() ([]byte, uint64, error)
+	Payload() []byte
 }
 
 type Iterator struct {
 	store  *Store
 	cursor Cursor
+	err    error
 }
 
 func (s *Store) NewIterator(startKey, endKey []byte) (*Iterator, error) {
@@ -22,19 +32,148 @@ func (s *Store) NewIterator(startKey, endKey []byte) (*Iterator, error) {
 }
 
 func (it *Iterator) Next() ([]byte, []byte, error) {
-	key, offset, err := it.cursor.Next()
+	for {
+		key, offset, err := it.cursor.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if key == nil {
+			return nil, nil, nil
+		}
+
+		if isBlobKey(key) {
+			continue
+		}
+
+		if payload := it.cursor.Payload(); payload != nil {
+			return key, payload, nil
+		}
+
+		record, err := it.store.readRecord(offset)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if record.RecordType == RecordTypeDelete || it.store.expired(record) {
+			continue
+		}
+
+		value, err := it.store.resolve(record)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return key, value, nil
+	}
+}
+
+// All returns the remaining pairs of the iterator for a range-over-func
+// loop. A read error ends the loop and is returned by Err.
+func (it *Iterator) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for {
+			key, value, err := it.Next()
+			if err != nil {
+				it.err = err
+				return
+			}
+			if key == nil || !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Err returns the error that ended a loop over All, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Range returns the pairs in [startKey, endKey) for a range-over-func loop.
+// An error ends the loop as the end of the range does; callers that need
+// to tell them apart use All and Err of an iterator from NewIterator.
+func (s *Store) Range(startKey, endKey []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		it, err := s.NewIterator(startKey, endKey)
+		if err != nil {
+			return
+		}
+		it.All()(yield)
+	}
+}
+
+// KeyIterator walks the keys of a range straight from the index leaves,
+// without reading the data file.
+type KeyIterator struct {
+	cursor Cursor
+}
+
+func (s *Store) NewKeyIterator(startKey, endKey []byte) (*KeyIterator, error) {
+	cursor, err := s.index.NewCursor(startKey, endKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	return &KeyIterator{cursor: cursor}, nil
+}
+
+func (it *KeyIterator) Next() ([]byte, error) {
+	for {
+		key, _, err := it.cursor.Next()
+		if err != nil || key == nil {
+			return nil, err
+		}
+		if !isBlobKey(key) {
+			return key, nil
+		}
+	}
+}
+
+// Has reports whether a key exists, looking only at the index.
+func (s *Store) Has(key []byte) (bool, error) {
+	_, _, err := s.index.Lookup(key)
+	if errors.Is(err, index.ErrKeyNotFound) {
+		return false, nil
 	}
+	return err == nil, err
+}
 
-	if key == nil {
-		return nil, nil, nil
+// Count returns the number of keys in [startKey, endKey) from the index
+// alone.
+func (s *Store) Count(startKey, endKey []byte) (int, error) {
+	count, err := s.index.Count(startKey, endKey)
+	if err != nil {
+		return 0, err
 	}
 
-	record, err := it.store.readRecord(offset)
+	// Take out the blob keys in the range, which iterators skip.
+	blobStart, blobEnd := blobPrefix, []byte("\xffblob;")
+	if bytes.Compare(startKey, blobStart) > 0 {
+		blobStart = startKey
+	}
+	if endKey != nil && bytes.Compare(endKey, blobEnd) < 0 {
+		blobEnd = endKey
+	}
+	if bytes.Compare(blobStart, blobEnd) >= 0 {
+		return count, nil
+	}
+	blobs, err := s.index.Count(blobStart, blobEnd)
 	if err != nil {
-		return nil, nil, err
+		return 0, err
 	}
+	return count - blobs, nil
+}
 
-	return key, record.Value, nil
+// LastKey returns the largest key in [startKey, endKey), or nil if the
+// range is empty.
+func (s *Store) LastKey(startKey, endKey []byte) ([]byte, error) {
+	key, _, err := s.index.LastBefore(endKey)
+	if err == nil && isBlobKey(key) {
+		key, _, err = s.index.LastBefore(blobPrefix)
+	}
+	if err != nil || key == nil || bytes.Compare(key, startKey) < 0 {
+		return nil, err
+	}
+	return key, nil
 }
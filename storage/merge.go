@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/rizalta/toydb/index"
+)
+
+var (
+	ErrUnknownMergeOperator = errors.New("storage: unknown merge operator")
+	ErrMergeOperatorName    = errors.New("storage: merge operator name taken or invalid")
+	ErrInvalidOperand       = errors.New("storage: invalid merge operand")
+	ErrCorruptMerge         = errors.New("storage: malformed merge record")
+)
+
+// MergeFunc folds an operand into a value, which is nil if the key holds
+// none. It must not keep either slice.
+type MergeFunc func(existing, operand []byte) ([]byte, error)
+
+// Built-in merge operators.
+const (
+	// MergeCounterAdd adds operands to a counter. Both are int64 as 8
+	// bytes, big-endian, and a missing counter is zero.
+	MergeCounterAdd = "counter-add"
+	// MergeSetUnion adds the elements of operands to a set. Both are
+	// sequences of elements, each a uvarint length and its bytes; values
+	// are kept sorted and without duplicates.
+	MergeSetUnion = "set-union"
+)
+
+var (
+	mergeOperatorsMu sync.RWMutex
+	mergeOperators   = map[string]MergeFunc{
+		MergeCounterAdd: counterAdd,
+		MergeSetUnion:   setUnion,
+	}
+)
+
+// RegisterMergeOperator makes fn available to Merge under name. The name
+// is stored with every operand, so stores whose logs hold it need fn
+// registered before they read those keys.
+func RegisterMergeOperator(name string, fn MergeFunc) error {
+	mergeOperatorsMu.Lock()
+	defer mergeOperatorsMu.Unlock()
+	if _, taken := mergeOperators[name]; taken || name == "" || len(name) > math.MaxUint8 {
+		return ErrMergeOperatorName
+	}
+	mergeOperators[name] = fn
+	return nil
+}
+
+func lookupMergeOperator(name string) MergeFunc {
+	mergeOperatorsMu.RLock()
+	defer mergeOperatorsMu.RUnlock()
+	return mergeOperators[name]
+}
+
+// maxMergeOperands is the longest chain of merge records behind a key.
+// The merge that would exceed it writes the folded value instead, which
+// bounds the records a read of the key goes through.
+const maxMergeOperands = 16
+
+// noPrevious is the previous offset of a merge record that starts a chain
+// with nothing beneath it.
+const noPrevious = math.MaxUint64
+
+// A merge record holds the offset of the previous record of its key, the
+// number of merge records in its chain, itself included, the operator
+// name and the operand.
+func encodeMerge(previous uint64, depth int, operator string, operand []byte) []byte {
+	value := make([]byte, 0, 11+len(operator)+len(operand))
+	value = binary.LittleEndian.AppendUint64(value, previous)
+	value = binary.LittleEndian.AppendUint16(value, uint16(depth))
+	value = append(value, byte(len(operator)))
+	value = append(value, operator...)
+	return append(value, operand...)
+}
+
+func decodeMerge(value []byte) (previous uint64, depth int, operator string, operand []byte, err error) {
+	if len(value) < 11 || len(value) < 11+int(value[10]) {
+		return 0, 0, "", nil, ErrCorruptMerge
+	}
+	previous = binary.LittleEndian.Uint64(value)
+	depth = int(binary.LittleEndian.Uint16(value[8:]))
+	end := 11 + int(value[10])
+	return previous, depth, string(value[11:end]), value[end:], nil
+}
+
+// Merge folds operand into the value of key with a registered operator,
+// without reading the value: the operand is logged and folded in when the
+// key is read. Reads, iterators and watchers see the folded value. A key
+// that is missing or deleted starts from nil. Values that are inline,
+// deduplicated or expiring are folded at once and written whole, and the
+// result never expires.
+func (s *Store) Merge(key []byte, operator string, operand []byte) error {
+	if isBlobKey(key) {
+		return ErrReservedKey
+	}
+	fn := lookupMergeOperator(operator)
+	if fn == nil {
+		return ErrUnknownMergeOperator
+	}
+	// Folding into nothing checks the operand, so that a bad one fails
+	// here rather than on every later read.
+	if _, err := fn(nil, operand); err != nil {
+		return err
+	}
+
+	previous, depth, layout := uint64(noPrevious), 0, LayoutHeap
+	offset, payload, err := s.index.Lookup(key)
+	switch {
+	case errors.Is(err, index.ErrKeyNotFound):
+	case err != nil:
+		return err
+	case payload != nil:
+		depth, layout = maxMergeOperands, LayoutInline
+	default:
+		r, err := s.readRecord(offset)
+		if err != nil {
+			return err
+		}
+		switch {
+		case r.RecordType == RecordTypeDelete:
+		case r.RecordType == RecordTypeInsert && r.expires == 0:
+			previous = offset
+		case r.RecordType == RecordTypeMerge:
+			if _, depth, _, _, err = decodeMerge(r.Value); err != nil {
+				return err
+			}
+			previous = offset
+		default:
+			depth = maxMergeOperands
+		}
+	}
+
+	if depth >= maxMergeOperands {
+		existing, _, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+		value, err := fn(existing, operand)
+		if err != nil {
+			return err
+		}
+		return s.Write(key, value, index.Upsert, layout)
+	}
+
+	watched := s.watched(key)
+	var change Change
+	if watched {
+		change = Change{Op: ChangePut, Key: bytes.Clone(key)}
+		if change.OldValue, _, err = s.Get(key); err != nil {
+			return err
+		}
+		if change.NewValue, err = fn(slices.Clone(change.OldValue), operand); err != nil {
+			return err
+		}
+	}
+	err = s.append(&Record{
+		RecordType: RecordTypeMerge,
+		Key:        key,
+		Value:      encodeMerge(previous, depth+1, operator, operand),
+	})
+	if err != nil {
+		return err
+	}
+	if watched {
+		s.notify(change)
+	}
+	return nil
+}
+
+// mergedValue folds the operands of the merge chain ending at r into the
+// value beneath it, oldest first.
+func (s *Store) mergedValue(r *Record) ([]byte, error) {
+	var chain []*Record
+	for r != nil && r.RecordType == RecordTypeMerge {
+		chain = append(chain, r)
+		previous, _, _, _, err := decodeMerge(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		r = nil
+		if previous != noPrevious {
+			if r, err = s.readRecord(previous); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var value []byte
+	if r != nil && r.RecordType == RecordTypeInsert {
+		value = r.Value
+	}
+	for _, m := range slices.Backward(chain) {
+		_, _, operator, operand, _ := decodeMerge(m.Value)
+		fn := lookupMergeOperator(operator)
+		if fn == nil {
+			return nil, ErrUnknownMergeOperator
+		}
+		var err error
+		if value, err = fn(value, operand); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func counterAdd(existing, operand []byte) ([]byte, error) {
+	if len(operand) != 8 || (existing != nil && len(existing) != 8) {
+		return nil, ErrInvalidOperand
+	}
+	var sum uint64
+	if existing != nil {
+		sum = binary.BigEndian.Uint64(existing)
+	}
+	return binary.BigEndian.AppendUint64(nil, sum+binary.BigEndian.Uint64(operand)), nil
+}
+
+func setUnion(existing, operand []byte) ([]byte, error) {
+	elements, err := decodeSet(existing)
+	if err != nil {
+		return nil, err
+	}
+	added, err := decodeSet(operand)
+	if err != nil {
+		return nil, err
+	}
+	elements = append(elements, added...)
+	slices.SortFunc(elements, bytes.Compare)
+	elements = slices.CompactFunc(elements, bytes.Equal)
+
+	var value []byte
+	for _, element := range elements {
+		value = binary.AppendUvarint(value, uint64(len(element)))
+		value = append(value, element...)
+	}
+	return value, nil
+}
+
+func decodeSet(data []byte) ([][]byte, error) {
+	var elements [][]byte
+	for len(data) > 0 {
+		n, size := binary.Uvarint(data)
+		if size <= 0 || uint64(len(data)-size) < n {
+			return nil, ErrInvalidOperand
+		}
+		elements = append(elements, data[size:size+int(n)])
+		data = data[size+int(n):]
+	}
+	return elements, nil
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/index"
+)
+
+func counter(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+func set(elements ...string) []byte {
+	var value []byte
+	for _, element := range elements {
+		value = binary.AppendUvarint(value, uint64(len(element)))
+		value = append(value, element...)
+	}
+	return value
+}
+
+// registerAppend registers an operator that appends operands, once per
+// test binary, as operators cannot be unregistered.
+var registerAppend = sync.OnceValue(func() error {
+	return RegisterMergeOperator("append", func(existing, operand []byte) ([]byte, error) {
+		return fmt.Appendf(nil, "%s%s", existing, operand), nil
+	})
+})
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	// Enough adds to fold the chain into a value more than once.
+	for i := range 40 {
+		if err := store.Merge([]byte("hits"), MergeCounterAdd, counter(int64(i%3-1))); err != nil {
+			t.Fatalf("failed to merge: %v", err)
+		}
+	}
+	if err := store.Put([]byte("base"), counter(100)); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := store.Merge([]byte("base"), MergeCounterAdd, counter(5)); err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+	for _, tags := range [][]string{{"b", "a"}, {"c", "a"}, {}} {
+		if err := store.Merge([]byte("tags"), MergeSetUnion, set(tags...)); err != nil {
+			t.Fatalf("failed to merge: %v", err)
+		}
+	}
+	if err := store.Merge([]byte("deleted"), MergeCounterAdd, counter(1)); err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+	if _, err := store.Delete([]byte("deleted")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := store.Merge([]byte("deleted"), MergeCounterAdd, counter(2)); err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+
+	expected := map[string][]byte{
+		"hits":    counter(-1), // 14 times -1, 13 times 0 and 1
+		"base":    counter(105),
+		"tags":    set("a", "b", "c"),
+		"deleted": counter(2),
+	}
+	check := func(store *Store) {
+		t.Helper()
+		for key, value := range expected {
+			got, found, err := store.Get([]byte(key))
+			if err != nil || !found || !reflect.DeepEqual(got, value) {
+				t.Errorf("expected %s to hold %x, got %x, found %v, err %v", key, value, got, found, err)
+			}
+		}
+		values := make(map[string][]byte)
+		for key, value := range store.Range(nil, nil) {
+			values[string(key)] = value
+		}
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("expected to iterate %x, got %x", expected, values)
+		}
+	}
+	check(store)
+
+	regions, err := store.GarbageStats(1 << 20)
+	if err != nil {
+		t.Fatalf("failed to get garbage stats: %v", err)
+	}
+	// Live: the 6 hits operands since the last fold and the value it
+	// wrote, base and its operand, the tags chain and the deleted key's
+	// second operand.
+	if regions[0].Live != 13 {
+		t.Errorf("expected the records of live merge chains to be live, got %+v", regions[0])
+	}
+	store.Close()
+
+	// Operands are folded the same from an index rebuilt from the log.
+	if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+		t.Fatalf("failed to remove index file: %v", err)
+	}
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	check(store)
+}
+
+func TestMergeFolds(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	tests := []struct {
+		name   string
+		write  func(key []byte) error
+		merged []byte
+	}{
+		{"inline", func(key []byte) error { return store.Write(key, counter(1), index.Upsert, LayoutInline) }, counter(2)},
+		{"expiring", func(key []byte) error { return store.PutWithTTL(key, counter(1), time.Hour) }, counter(2)},
+	}
+	for _, tt := range tests {
+		key := []byte(tt.name)
+		if err := tt.write(key); err != nil {
+			t.Fatalf("%s: failed to write: %v", tt.name, err)
+		}
+		if err := store.Merge(key, MergeCounterAdd, counter(1)); err != nil {
+			t.Fatalf("%s: failed to merge: %v", tt.name, err)
+		}
+		record, err := store.lookup(key)
+		if err != nil || record.RecordType == RecordTypeMerge || record.expires != 0 {
+			t.Errorf("%s: expected a merge to write the folded value, got %+v, err %v", tt.name, record, err)
+		}
+		if value, _, err := store.Get(key); err != nil || !reflect.DeepEqual(value, tt.merged) {
+			t.Errorf("%s: expected %x, got %x, err %v", tt.name, tt.merged, value, err)
+		}
+	}
+
+	errorTests := []struct {
+		operator string
+		operand  []byte
+		err      error
+	}{
+		{"missing", counter(1), ErrUnknownMergeOperator},
+		{MergeCounterAdd, []byte("short"), ErrInvalidOperand},
+		{MergeSetUnion, []byte{5, 'a'}, ErrInvalidOperand},
+	}
+	for _, tt := range errorTests {
+		if err := store.Merge([]byte("key"), tt.operator, tt.operand); !errors.Is(err, tt.err) {
+			t.Errorf("%s %q: expected %v, got %v", tt.operator, tt.operand, tt.err, err)
+		}
+	}
+
+	if err := registerAppend(); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if err := RegisterMergeOperator(MergeCounterAdd, nil); !errors.Is(err, ErrMergeOperatorName) {
+		t.Errorf("expected %v, got %v", ErrMergeOperatorName, err)
+	}
+	for _, part := range []string{"to", "y", "db"} {
+		if err := store.Merge([]byte("name"), "append", []byte(part)); err != nil {
+			t.Fatalf("failed to merge: %v", err)
+		}
+	}
+	if value, _, err := store.Get([]byte("name")); err != nil || string(value) != "toydb" {
+		t.Errorf("expected toydb, got %q, err %v", value, err)
+	}
+}
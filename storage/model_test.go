@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type modelOpKind uint8
+
+const (
+	modelPut modelOpKind = iota
+	modelDelete
+	modelGet
+	modelScan
+	modelReopen
+)
+
+// modelOp is one step of a sequence that checkModel applies to a store and
+// to a map.
+type modelOp struct {
+	kind  modelOpKind
+	key   []byte
+	value []byte
+	// end bounds a scan, which starts at key.
+	end []byte
+}
+
+func (op modelOp) String() string {
+	switch op.kind {
+	case modelPut:
+		return fmt.Sprintf("put %.12q (%d bytes)", op.key, len(op.value))
+	case modelDelete:
+		return fmt.Sprintf("delete %.12q", op.key)
+	case modelGet:
+		return fmt.Sprintf("get %.12q", op.key)
+	case modelScan:
+		return fmt.Sprintf("scan %.12q to %.12q", op.key, op.end)
+	default:
+		return "reopen"
+	}
+}
+
+// modelKey picks one of 64 short keys from the low bits of b, or, for a
+// quarter of the bytes, a long one that shares its prefix, a few of which
+// fill an index page.
+func modelKey(b byte) []byte {
+	key := fmt.Appendf(nil, "k%02d", b&0x3f)
+	if b >= 0xc0 {
+		key = append(key, strings.Repeat("_", 150)...)
+	}
+	return key
+}
+
+// decodeModelOps turns every three bytes of data into an operation: one
+// picks what it does, one its key, and one the size of its value or the
+// end of its scan. Values of the same size are equal, so that a store
+// that deduplicates them shares them between keys.
+func decodeModelOps(data []byte) []modelOp {
+	var ops []modelOp
+	for i := 0; i+3 <= len(data); i += 3 {
+		op := modelOp{key: modelKey(data[i+1])}
+		switch kind := data[i] % 16; {
+		case kind < 7:
+			op.kind = modelPut
+			op.value = bytes.Repeat([]byte{'a' + data[i+2]%26}, int(data[i+2])*8)
+		case kind < 10:
+			op.kind = modelDelete
+		case kind < 12:
+			op.kind = modelGet
+		case kind < 15:
+			op.kind = modelScan
+			op.end = modelKey(data[i+2])
+		default:
+			op.kind = modelReopen
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// checkModel applies ops to a store in dir opened with opts and to a map,
+// and returns where they first disagree, or an error of the store.
+func checkModel(dir string, opts Options, ops []modelOp) (err error) {
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if store != nil {
+			if closeErr := store.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}()
+
+	model := map[string][]byte{}
+	scan := func(start, end []byte) error {
+		var expected, got []string
+		for _, key := range slices.Sorted(maps.Keys(model)) {
+			if key >= string(start) && (end == nil || key < string(end)) {
+				expected = append(expected, fmt.Sprintf("%.12q=%d", key, len(model[key])))
+			}
+		}
+		it, err := store.NewIterator(start, end)
+		if err != nil {
+			return err
+		}
+		for key, value := range it.All() {
+			got = append(got, fmt.Sprintf("%.12q=%d", key, len(value)))
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+		if !slices.Equal(got, expected) {
+			return fmt.Errorf("expected %v, got %v", expected, got)
+		}
+		return nil
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.kind {
+		case modelPut:
+			if err = store.Put(op.key, op.value); err == nil {
+				model[string(op.key)] = op.value
+			}
+		case modelDelete:
+			var found bool
+			if found, err = store.Delete(op.key); err == nil {
+				_, exists := model[string(op.key)]
+				if found != exists {
+					err = fmt.Errorf("expected found %v, got %v", exists, found)
+				}
+				delete(model, string(op.key))
+			}
+		case modelGet:
+			var value []byte
+			var found bool
+			if value, found, err = store.Get(op.key); err == nil {
+				expected, exists := model[string(op.key)]
+				if found != exists || !bytes.Equal(value, expected) {
+					err = fmt.Errorf("expected %d bytes, found %v, got %d, found %v", len(expected), exists, len(value), found)
+				}
+			}
+		case modelScan:
+			err = scan(op.key, op.end)
+		case modelReopen:
+			err, store = store.Close(), nil
+			if err == nil {
+				store, err = NewStoreWithOptions(dir, opts)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("op %d, %s: %w", i, op, err)
+		}
+	}
+	if err := scan(nil, nil); err != nil {
+		return fmt.Errorf("after the last op: %w", err)
+	}
+	return nil
+}
+
+// shrinkModel removes ever smaller runs of ops from a sequence for which
+// fails holds, as long as it keeps holding, and returns what is left.
+func shrinkModel(ops []modelOp, fails func([]modelOp) bool) []modelOp {
+	for size := len(ops) / 2; size > 0; size /= 2 {
+		for i := 0; i+size <= len(ops); {
+			if shorter := slices.Concat(ops[:i], ops[i+size:]); fails(shorter) {
+				ops = shorter
+			} else {
+				i += size
+			}
+		}
+	}
+	return ops
+}
+
+// modelOptions are the configurations the model is checked in, each with
+// its own way of keeping keys and values.
+var modelOptions = []struct {
+	name string
+	opts Options
+}{
+	{"btree", Options{}},
+	{"small cache", Options{CacheSize: 2, SegmentSize: 4096}},
+	{"dedup", Options{DedupThreshold: 256}},
+	{"compressed", Options{Compression: Flate, CompressionThreshold: 64}},
+	{"mmap", Options{CacheSize: 2, Mmap: true}},
+	{"compressed pages", Options{CacheSize: 2, CompressPages: true}},
+	{"encrypted", Options{CacheSize: 2, EncryptionKey: []byte("0123456789abcdef"), Compression: Flate}},
+	{"lsm", Options{Engine: EngineLSM}},
+}
+
+func TestModel(t *testing.T) {
+	for _, tt := range modelOptions {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := range uint64(3) {
+				r := rand.New(rand.NewPCG(seed, 0))
+				data := make([]byte, 3*400)
+				for i := range data {
+					data[i] = byte(r.Uint32())
+				}
+				ops := decodeModelOps(data)
+
+				fails := func(ops []modelOp) bool {
+					dir, err := os.MkdirTemp(t.TempDir(), "model")
+					if err != nil {
+						t.Fatalf("failed to create directory: %v", err)
+					}
+					return checkModel(dir, tt.opts, ops) != nil
+				}
+				if err := checkModel(t.TempDir(), tt.opts, ops); err != nil {
+					ops = shrinkModel(ops, fails)
+					t.Fatalf("seed %d: %v\nshrunk to %d ops: %v", seed, err, len(ops), ops)
+				}
+			}
+		})
+	}
+}
+
+func TestShrinkModel(t *testing.T) {
+	ops := decodeModelOps([]byte{
+		0, 1, 1, 0, 2, 2, 7, 1, 0, 0, 3, 3, 15, 0, 0, 0, 1, 4, 10, 1, 0,
+	})
+	// Fails while a put of key 1 is followed by a get of it.
+	fails := func(ops []modelOp) bool {
+		put := slices.IndexFunc(ops, func(op modelOp) bool { return op.kind == modelPut && bytes.Equal(op.key, modelKey(1)) })
+		return put >= 0 && slices.ContainsFunc(ops[put:], func(op modelOp) bool { return op.kind == modelGet && bytes.Equal(op.key, modelKey(1)) })
+	}
+	shrunk := shrinkModel(ops, fails)
+	if len(shrunk) != 2 || shrunk[0].kind != modelPut || shrunk[1].kind != modelGet {
+		t.Errorf("expected a put and a get, got %v", shrunk)
+	}
+}
+
+func FuzzStore(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 7, 1, 0, 10, 1, 0})
+	f.Add([]byte{0, 0xc0, 40, 0, 0xc1, 40, 15, 0, 0, 7, 0xc0, 0, 12, 0, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Every reopen syncs, so long sequences run slowly.
+		data = data[:min(len(data), 3*200)]
+		if err := checkModel(t.TempDir(), Options{CacheSize: 2, DedupThreshold: 256}, decodeModelOps(data)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/rizalta/toydb/index"
+)
+
+// OrphanAction selects what RepairOrphans does about the orphans it finds.
+type OrphanAction uint8
+
+const (
+	// OrphanReport only reports orphans.
+	OrphanReport OrphanAction = iota
+	// OrphanReindex points the index at each orphan, making the log the
+	// source of truth.
+	OrphanReindex
+	// OrphanPurge keeps what the index holds and appends records that
+	// supersede the orphans, so that a later recovery from the log does
+	// not bring them back.
+	OrphanPurge
+)
+
+type OrphanKind uint8
+
+const (
+	// OrphanMissing is a record whose key is not in the index.
+	OrphanMissing OrphanKind = iota
+	// OrphanStale is a record whose key is in the index but with an older
+	// record or value.
+	OrphanStale
+)
+
+// Orphan is the latest record for a key that the index does not point to.
+type Orphan struct {
+	Offset uint64
+	Key    []byte
+	Kind   OrphanKind
+}
+
+type RepairReport struct {
+	Records int
+	Orphans []Orphan
+}
+
+type latestRecord struct {
+	offset uint64
+	record *Record
+}
+
+// RepairOrphans walks the data log and compares the latest record of every
+// key with the index. Earlier records of a key are superseded and never
+// orphans, and a deleted key may be missing from the index. It complements
+// the full index rebuild on an unclean open when only a few entries are
+// inconsistent.
+func (s *Store) RepairOrphans(action OrphanAction) (*RepairReport, error) {
+	report := &RepairReport{}
+	background := s.backgroundPager()
+	latest := make(map[string]latestRecord)
+	var keys []string
+	for offset := s.segments.next(0); offset < s.offset; {
+		r, err := s.readStoredRecord(background, offset)
+		if err != nil {
+			return nil, err
+		}
+		if r.RecordType == RecordTypeBatch {
+			offset += batchHeaderSize
+			continue
+		}
+		if r.RecordType.mark() {
+			offset = s.segments.next(offset + r.size())
+			continue
+		}
+		report.Records++
+		if _, ok := latest[string(r.Key)]; !ok {
+			keys = append(keys, string(r.Key))
+		}
+		latest[string(r.Key)] = latestRecord{offset: offset, record: r}
+		offset = s.segments.next(offset + r.size())
+	}
+
+	for _, key := range keys {
+		l := latest[key]
+		kind, ok, err := s.checkIndexed(l.offset, l.record)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			report.Orphans = append(report.Orphans, Orphan{Offset: l.offset, Key: l.record.Key, Kind: kind})
+		}
+	}
+
+	for _, orphan := range report.Orphans {
+		var err error
+		switch action {
+		case OrphanReindex:
+			err = s.reindex(orphan.Offset, latest[string(orphan.Key)].record)
+		case OrphanPurge:
+			err = s.purge(orphan)
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	if action != OrphanReport && len(report.Orphans) > 0 {
+		var err error
+		if s.hasBlobs, err = s.containsBlobs(); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// checkIndexed reports whether the index holds the record at offset, and
+// how it fails to if not.
+func (s *Store) checkIndexed(offset uint64, r *Record) (OrphanKind, bool, error) {
+	indexed, payload, err := s.index.Lookup(r.Key)
+	if errors.Is(err, index.ErrKeyNotFound) {
+		return OrphanMissing, r.RecordType == RecordTypeDelete, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if r.RecordType == RecordTypeInline {
+		return OrphanStale, payload != nil && bytes.Equal(payload, r.Value), nil
+	}
+	return OrphanStale, payload == nil && indexed == offset, nil
+}
+
+func (s *Store) reindex(offset uint64, r *Record) error {
+	var err error
+	if r.RecordType == RecordTypeInline {
+		err = s.index.InsertInline(r.Key, r.Value, index.Upsert)
+	} else {
+		err = s.index.Insert(r.Key, offset, index.Upsert)
+	}
+	if err != nil {
+		return err
+	}
+	return s.addToBloom(r.Key)
+}
+
+// purge appends a copy of what the index holds for the key of an orphan,
+// or a delete record if it holds nothing.
+func (s *Store) purge(orphan Orphan) error {
+	if orphan.Kind == OrphanMissing {
+		return s.append(&Record{RecordType: RecordTypeDelete, Key: orphan.Key})
+	}
+
+	indexed, payload, err := s.index.Lookup(orphan.Key)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		return s.append(&Record{RecordType: RecordTypeInline, Key: orphan.Key, Value: payload})
+	}
+	r, err := s.readStoredRecord(s.pager, indexed)
+	if err != nil {
+		return err
+	}
+	return s.append(r)
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizalta/toydb/index"
+)
+
+func TestRepairOrphans(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   OrphanAction
+		expected map[string]string
+	}{
+		{
+			name:   "report",
+			action: OrphanReport,
+			expected: map[string]string{
+				"key_1": "", "key_2": "old_2", "key_3": "inline_3", "key_4": "", "key_5": "value_5",
+			},
+		},
+		{
+			name:   "reindex",
+			action: OrphanReindex,
+			expected: map[string]string{
+				"key_1": "value_1", "key_2": "new_2", "key_3": "inline_new", "key_4": "", "key_5": "value_5",
+			},
+		},
+		{
+			name:   "purge",
+			action: OrphanPurge,
+			expected: map[string]string{
+				"key_1": "", "key_2": "old_2", "key_3": "inline_3", "key_4": "", "key_5": "value_5",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewStore(dir)
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+
+			for i := 1; i <= 5; i++ {
+				key := fmt.Appendf(nil, "key_%d", i)
+				if err := store.Put(key, fmt.Appendf(nil, "value_%d", i)); err != nil {
+					t.Fatalf("failed to put: %v", err)
+				}
+			}
+			must := func(err error) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("failed to set up orphans: %v", err)
+				}
+			}
+
+			// key_1 loses its index entry, key_2 and key_3 keep pointing at
+			// older values, and key_4 is deleted consistently.
+			must(store.index.Delete([]byte("key_1")))
+			must(store.Write([]byte("key_2"), []byte("old_2"), index.UpdateOnly, LayoutHeap))
+			oldOffset, _, err := store.index.Lookup([]byte("key_2"))
+			must(err)
+			must(store.Put([]byte("key_2"), []byte("new_2")))
+			must(store.index.Insert([]byte("key_2"), oldOffset, index.Upsert))
+			must(store.Write([]byte("key_3"), []byte("inline_3"), index.UpdateOnly, LayoutInline))
+			must(store.Write([]byte("key_3"), []byte("inline_new"), index.UpdateOnly, LayoutInline))
+			must(store.index.InsertInline([]byte("key_3"), []byte("inline_3"), index.Upsert))
+			_, err = store.Delete([]byte("key_4"))
+			must(err)
+
+			report, err := store.RepairOrphans(tt.action)
+			if err != nil {
+				t.Fatalf("failed to repair: %v", err)
+			}
+			if report.Records != 10 {
+				t.Errorf("expected 10 records, got %d", report.Records)
+			}
+			var got []string
+			for _, orphan := range report.Orphans {
+				got = append(got, fmt.Sprintf("%s:%d", orphan.Key, orphan.Kind))
+			}
+			if expected := fmt.Sprint([]string{"key_1:0", "key_2:1", "key_3:1"}); fmt.Sprint(got) != expected {
+				t.Errorf("expected orphans %s, got %v", expected, got)
+			}
+
+			verify := func(store *Store) {
+				t.Helper()
+				for key, value := range tt.expected {
+					got, found, err := store.Get([]byte(key))
+					if err != nil {
+						t.Fatalf("failed to get %s: %v", key, err)
+					}
+					if found != (value != "") || !bytes.Equal(got, []byte(value)) {
+						t.Errorf("expected %s to be %q, got %q (found %v)", key, value, got, found)
+					}
+				}
+			}
+			verify(store)
+			if tt.action == OrphanReport {
+				store.Close()
+				return
+			}
+
+			if report, err := store.RepairOrphans(OrphanReport); err != nil || len(report.Orphans) != 0 {
+				t.Errorf("expected no orphans after repair, got %v, %v", report, err)
+			}
+
+			// Rebuilding the index from the log has to agree with the repair.
+			if err := store.Close(); err != nil {
+				t.Fatalf("failed to close store: %v", err)
+			}
+			if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+				t.Fatalf("failed to remove lock file: %v", err)
+			}
+			store, err = NewStore(dir)
+			if err != nil {
+				t.Fatalf("failed to reopen store: %v", err)
+			}
+			defer store.Close()
+			verify(store)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFollower = errors.New("storage: store is not a follower")
+	ErrLogGap      = errors.New("storage: log does not continue at position")
+)
+
+// A follower keeps a copy of the data log of another store, its leader,
+// byte for byte and at the same positions, so that it can take over from
+// the leader where it stopped. It reads ReadLog of the leader from its own
+// LogEnd and hands what it gets to ApplyLog.
+
+// LogEnd returns the position past the last record written, before the
+// open batch, if any.
+func (s *Store) LogEnd() uint64 {
+	if s.batch != nil {
+		return s.batch.start
+	}
+	return s.offset
+}
+
+// ReadLog returns the records of the log from position from on, whole
+// batches at a time, and the position they start at: from, or the start
+// of the next segment if from is the end of a sealed one. It returns about
+// maxBytes of records from one segment, but at least one record or batch,
+// and none once from is the end of the log. It fails with ErrLogGap if
+// from is in a segment DropDeadSegments emptied, or reading on from it
+// would pass over one, as a follower there may not have all of it.
+func (s *Store) ReadLog(from uint64, maxBytes int) (uint64, []byte, error) {
+	end := s.LogEnd()
+	if from > end {
+		return 0, nil, fmt.Errorf("%w %d: the log ends at %d", ErrLogGap, from, end)
+	}
+	start := s.segments.next(from)
+	if s.segments.emptiedBefore(from, start) {
+		return 0, nil, fmt.Errorf("%w %d: the log was emptied up to %d", ErrLogGap, from, start)
+	}
+	pos := start
+	// A sealed segment ends where next moves on from it.
+	for pos < end && s.segments.next(pos) == pos && (pos == start || pos-start < uint64(maxBytes)) {
+		size, err := s.unitSize(pos)
+		if err != nil {
+			return 0, nil, err
+		}
+		pos += size
+	}
+	if pos == start {
+		return start, nil, nil
+	}
+	data, err := s.pager.ReadAtOffset(start, int(pos-start))
+	if err != nil {
+		return 0, nil, err
+	}
+	return start, data, nil
+}
+
+// unitSize returns the length of the record at pos, or of the whole batch
+// if it is a batch record.
+func (s *Store) unitSize(pos uint64) (uint64, error) {
+	header, err := s.pager.ReadAtOffset(pos, recordHeaderSize)
+	if err != nil {
+		return 0, err
+	}
+	if RecordType(header[0]&^recordFlags) != RecordTypeBatch {
+		return recordSize(header), nil
+	}
+	r, err := readLogRecord(s.pager, pos)
+	if err != nil {
+		return 0, err
+	}
+	return batchHeaderSize + batchLength(r), nil
+}
+
+// ApplyLog writes records that ReadLog of the leader returned at position
+// pos to the log of a follower and indexes them, which makes them visible
+// to reads. pos has to be the LogEnd of the follower, or the start of the
+// segment after it, which seals the current one where the leader did.
+// Watchers of a follower see nothing of what it applies.
+func (s *Store) ApplyLog(pos uint64, data []byte) error {
+	if !s.follower {
+		return ErrNotFollower
+	}
+	if pos != s.offset {
+		if segmentOf(pos) != segmentOf(s.offset)+1 || pos&segmentOffsetMask != 0 {
+			return fmt.Errorf("%w %d: the log ends at %d", ErrLogGap, pos, s.offset)
+		}
+		if err := s.pager.Sync(); err != nil {
+			return err
+		}
+		offset, err := s.segments.rotate()
+		if err != nil {
+			return err
+		}
+		s.offset = offset
+		if err := s.archiveSealed(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.pager.WriteAtOffset(pos, data); err != nil {
+		return fmt.Errorf("storage: failed to write records: %w", err)
+	}
+	s.unsynced.Store(true)
+	s.metrics.RecordBytes.Add(uint64(len(data)))
+	for off := uint64(0); off < uint64(len(data)); {
+		r, err := deserialize(data[off:])
+		if err != nil {
+			return err
+		}
+		if err := s.unseal(r); err != nil {
+			return err
+		}
+		s.metrics.RecordsWritten.Inc()
+		switch {
+		case r.RecordType == RecordTypeBatch:
+			if !batchIntact(s.pager, pos+off, r) {
+				return fmt.Errorf("%w: batch at %d", ErrCorruptRecord, pos+off)
+			}
+		case !r.RecordType.mark():
+			if err := s.indexRecord(r, pos+off); err != nil {
+				return fmt.Errorf("storage: failed to index key: %w", err)
+			}
+			if isBlobKey(r.Key) {
+				s.hasBlobs = true
+			}
+		}
+		off += r.size()
+	}
+	s.offset += uint64(len(data))
+
+	if s.syncWrites {
+		return s.syncData()
+	}
+	return nil
+}
+
+// Promote makes a follower take writes of its own, continuing its log
+// where the leader's stopped. The leader must not write any more, as
+// the two logs would part ways.
+func (s *Store) Promote() error {
+	if !s.follower {
+		return ErrNotFollower
+	}
+	s.follower = false
+	return nil
+}
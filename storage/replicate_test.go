@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// follow applies the log of leader to follower until it has caught up.
+func follow(t *testing.T, leader, follower *Store) {
+	t.Helper()
+	for {
+		pos, data, err := leader.ReadLog(follower.LogEnd(), 100)
+		if err != nil {
+			t.Fatalf("failed to read log: %v", err)
+		}
+		if data == nil {
+			return
+		}
+		if err := follower.ApplyLog(pos, data); err != nil {
+			t.Fatalf("failed to apply log: %v", err)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leaderDir, followerDir := t.TempDir(), t.TempDir()
+	leader, err := NewStoreWithOptions(leaderDir, Options{SegmentSize: 1024, DedupThreshold: 64})
+	if err != nil {
+		t.Fatalf("failed to create leader: %v", err)
+	}
+	defer leader.Close()
+	opts := Options{Follower: true}
+	follower, err := NewStoreWithOptions(followerDir, opts)
+	if err != nil {
+		t.Fatalf("failed to create follower: %v", err)
+	}
+
+	putKeys(t, leader, 0, 50)
+	follow(t, leader, follower)
+	checkKeys(t, follower, 50)
+
+	// A follower that restarts picks up where it stopped, and a batch
+	// left open on the leader is not shipped until it commits.
+	if err := follower.Close(); err != nil {
+		t.Fatalf("failed to close follower: %v", err)
+	}
+	putKeys(t, leader, 50, 100)
+	if err := leader.Put([]byte("blob"), bytes.Repeat([]byte("x"), 100)); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := leader.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	putKeys(t, leader, 100, 110)
+	if follower, err = NewStoreWithOptions(followerDir, opts); err != nil {
+		t.Fatalf("failed to reopen follower: %v", err)
+	}
+	defer func() { follower.Close() }()
+	follow(t, leader, follower)
+	checkKeys(t, follower, 100)
+	if _, found, _ := follower.Get([]byte("key_100")); found {
+		t.Error("expected the open batch to stay on the leader")
+	}
+	if err := leader.CommitBatch(); err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+	if _, err := leader.Delete([]byte("key_000")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	follow(t, leader, follower)
+
+	for i := range segmentCount(t, leaderDir) {
+		want, _ := os.ReadFile(filepath.Join(leaderDir, segmentFile(i)))
+		got, _ := os.ReadFile(filepath.Join(followerDir, segmentFile(i)))
+		if !bytes.Equal(got, want) {
+			t.Fatalf("expected segment %d of the follower to match the leader", i)
+		}
+	}
+	if value, found, err := follower.Get([]byte("blob")); err != nil || !found || len(value) != 100 {
+		t.Errorf("expected the blob, got %d bytes, %v, %v", len(value), found, err)
+	}
+	if _, found, _ := follower.Get([]byte("key_000")); found {
+		t.Error("expected key_000 to be deleted")
+	}
+	if err := follower.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v, got %v", ErrReadOnly, err)
+	}
+	if err := follower.ApplyLog(follower.LogEnd()+1, []byte{0}); !errors.Is(err, ErrLogGap) {
+		t.Errorf("expected %v, got %v", ErrLogGap, err)
+	}
+	if _, _, err := leader.ReadLog(leader.LogEnd()+1, 100); !errors.Is(err, ErrLogGap) {
+		t.Errorf("expected %v, got %v", ErrLogGap, err)
+	}
+
+	if err := follower.Promote(); err != nil {
+		t.Fatalf("failed to promote: %v", err)
+	}
+	if err := follower.Put([]byte("key"), []byte("value")); err != nil {
+		t.Errorf("expected a promoted follower to take writes, got %v", err)
+	}
+	if err := follower.ApplyLog(follower.LogEnd(), nil); !errors.Is(err, ErrNotFollower) {
+		t.Errorf("expected %v, got %v", ErrNotFollower, err)
+	}
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rizalta/toydb/pager"
+)
+
+// The data log is split into segment files, data-000001.log and on, so
+// that whole files can be copied or dropped once they are no longer
+// written to. A position in the log, as the index holds it, has the
+// segment number in its high bits and the offset in the segment file in
+// the rest. The first segment is number zero, so its positions are plain
+// file offsets, as they were when the log was a single data.db.
+const (
+	segmentShift      = 40
+	segmentOffsetMask = 1<<segmentShift - 1
+	// DefaultSegmentSize is the size a segment grows to before the log
+	// moves on to a new one.
+	DefaultSegmentSize = 64 << 20
+	// legacyDataFile is the data log of stores from before segments, which
+	// becomes the first segment when the store is opened.
+	legacyDataFile = "data.db"
+)
+
+var (
+	ErrInvalidSegmentSize = errors.New("storage: segment size out of range")
+	ErrMissingSegment     = errors.New("storage: data log segment missing")
+)
+
+func segmentPos(segment int, offset uint64) uint64 {
+	return uint64(segment)<<segmentShift | offset
+}
+
+func segmentOf(pos uint64) int {
+	return int(pos >> segmentShift)
+}
+
+func segmentFile(segment int) string {
+	return fmt.Sprintf("data-%06d.log", segment+1)
+}
+
+// segmentLog is the data log as a Pager over log positions. Writes only go
+// to the last segment; the others are sealed and no longer change size.
+type segmentLog struct {
+	dir       string
+	opts      pager.Options
+	scheduler *pager.Scheduler
+
+	mu       sync.RWMutex
+	segments []*pager.Pager
+	paths    []string
+	// sizes holds the length of every sealed segment.
+	sizes []uint64
+	// synced is the first segment that may hold writes not synced yet.
+	synced int
+}
+
+// openSegmentLog opens the data log of dataDir, starting it if create is
+// set and there is none. A data.db left by an older store is renamed to be
+// the first segment if create is set and read in place otherwise. A log
+// in memory always starts empty.
+func openSegmentLog(dataDir string, opts pager.Options, create bool) (*segmentLog, error) {
+	l := &segmentLog{dir: dataDir, opts: opts}
+	if opts.InMemory {
+		p, err := pager.NewPagerWithOptions("", opts)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, p)
+		l.paths = append(l.paths, segmentFile(0))
+		return l, nil
+	}
+
+	legacy := filepath.Join(dataDir, legacyDataFile)
+	first := filepath.Join(dataDir, segmentFile(0))
+	if _, err := os.Stat(first); os.IsNotExist(err) {
+		if _, err := os.Stat(legacy); err == nil {
+			if !create {
+				first = legacy
+			} else if err := os.Rename(legacy, first); err != nil {
+				return nil, err
+			}
+		} else if !create {
+			return nil, &os.PathError{Op: "open", Path: first, Err: os.ErrNotExist}
+		}
+	}
+
+	l.paths = append(l.paths, first)
+	for segment := 1; ; segment++ {
+		path := filepath.Join(dataDir, segmentFile(segment))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		l.paths = append(l.paths, path)
+	}
+	if matches, err := filepath.Glob(filepath.Join(dataDir, "data-*.log")); err != nil {
+		return nil, err
+	} else if len(matches) > len(l.paths) {
+		return nil, fmt.Errorf("%w: %s", ErrMissingSegment, segmentFile(len(l.paths)))
+	}
+
+	for i, path := range l.paths {
+		p, err := pager.NewPagerWithOptions(path, opts)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.segments = append(l.segments, p)
+		if i < len(l.paths)-1 {
+			size, err := p.GetSize()
+			if err != nil {
+				l.Close()
+				return nil, err
+			}
+			l.sizes = append(l.sizes, size)
+		}
+	}
+	return l, nil
+}
+
+func (l *segmentLog) segment(pos uint64) (*pager.Pager, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	segment := segmentOf(pos)
+	if segment >= len(l.segments) {
+		return nil, fmt.Errorf("%w: %s", ErrMissingSegment, segmentFile(segment))
+	}
+	return l.segments[segment], nil
+}
+
+func (l *segmentLog) WriteAtOffset(pos uint64, data []byte) error {
+	p, err := l.segment(pos)
+	if err != nil {
+		return err
+	}
+	return p.WriteAtOffset(pos&segmentOffsetMask, data)
+}
+
+func (l *segmentLog) ReadAtOffset(pos uint64, size int) ([]byte, error) {
+	p, err := l.segment(pos)
+	if err != nil {
+		return nil, err
+	}
+	return p.ReadAtOffset(pos&segmentOffsetMask, size)
+}
+
+// Sync makes the writes to every segment durable.
+func (l *segmentLog) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := l.synced; i < len(l.segments); i++ {
+		if err := l.segments[i].Sync(); err != nil {
+			return err
+		}
+		l.synced = i
+	}
+	return nil
+}
+
+func (l *segmentLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var firstErr error
+	for _, p := range l.segments {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// next returns pos, or the start of the next segment with records if pos
+// is the end of a sealed one, for walks over the log. Walks start at
+// next(0), which passes over the segments emptied by empty.
+func (l *segmentLog) next(pos uint64) uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for segment := segmentOf(pos); segment < len(l.sizes) && pos&segmentOffsetMask >= l.sizes[segment]; segment++ {
+		pos = segmentPos(segment+1, 0)
+	}
+	return pos
+}
+
+// emptiedBefore reports whether a segment from the one holding from up to
+// the one before to was emptied, which leaves no telling how much of it a
+// walk that stopped at from had read.
+func (l *segmentLog) emptiedBefore(from, to uint64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for segment := segmentOf(from); segment < segmentOf(to) && segment < len(l.sizes); segment++ {
+		if l.sizes[segment] == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// end returns the position past the last byte of the log.
+func (l *segmentLog) end() (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	last := len(l.segments) - 1
+	size, err := l.segments[last].GetSize()
+	if err != nil {
+		return 0, err
+	}
+	return segmentPos(last, size), nil
+}
+
+// rotate seals the last segment at its current size and starts a new one,
+// returning the position it starts at.
+func (l *segmentLog) rotate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	last := len(l.segments) - 1
+	size, err := l.segments[last].GetSize()
+	if err != nil {
+		return 0, err
+	}
+
+	path := filepath.Join(l.dir, segmentFile(last+1))
+	p, err := pager.NewPagerWithOptions(path, l.opts)
+	if err != nil {
+		return 0, err
+	}
+	p.SetScheduler(l.scheduler)
+	l.segments = append(l.segments, p)
+	l.paths = append(l.paths, path)
+	l.sizes = append(l.sizes, size)
+	return segmentPos(last+1, 0), nil
+}
+
+// truncate removes the segments after the one holding pos, which becomes
+// the last again. Recovery uses it when it stops before the last segment,
+// as writes go on from where it stopped.
+func (l *segmentLog) truncate(pos uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keep := segmentOf(pos) + 1
+	for i := len(l.segments) - 1; i >= keep; i-- {
+		if err := l.segments[i].Close(); err != nil {
+			return err
+		}
+		if l.opts.InMemory {
+			continue
+		}
+		if err := os.Remove(l.paths[i]); err != nil {
+			return err
+		}
+	}
+	l.segments = l.segments[:keep]
+	l.paths = l.paths[:keep]
+	l.sizes = l.sizes[:keep-1]
+	l.synced = min(l.synced, keep-1)
+	return nil
+}
+
+// empty cuts the sealed segment to nothing, freeing its disk space. The
+// file stays, as the numbers of the segments after it are part of the
+// positions the index holds.
+func (l *segmentLog) empty(segment int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.segments[segment].Close(); err != nil {
+		return err
+	}
+	path := ""
+	if !l.opts.InMemory {
+		path = l.paths[segment]
+		if err := os.Truncate(path, 0); err != nil {
+			return err
+		}
+	}
+	p, err := pager.NewPagerWithOptions(path, l.opts)
+	if err != nil {
+		return err
+	}
+	p.SetScheduler(l.scheduler)
+	l.segments[segment] = p
+	l.sizes[segment] = 0
+	return nil
+}
+
+func (l *segmentLog) setScheduler(s *pager.Scheduler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.scheduler = s
+	for _, p := range l.segments {
+		p.SetScheduler(s)
+	}
+}
+
+// withPriority returns a view of the log whose reads and writes are
+// scheduled at prio.
+func (l *segmentLog) withPriority(prio pager.Priority) Pager {
+	return &prioritySegments{log: l, priority: prio}
+}
+
+type prioritySegments struct {
+	log      *segmentLog
+	priority pager.Priority
+}
+
+func (p *prioritySegments) WriteAtOffset(pos uint64, data []byte) error {
+	segment, err := p.log.segment(pos)
+	if err != nil {
+		return err
+	}
+	return segment.WithPriority(p.priority).WriteAtOffset(pos&segmentOffsetMask, data)
+}
+
+func (p *prioritySegments) ReadAtOffset(pos uint64, size int) ([]byte, error) {
+	segment, err := p.log.segment(pos)
+	if err != nil {
+		return nil, err
+	}
+	return segment.WithPriority(p.priority).ReadAtOffset(pos&segmentOffsetMask, size)
+}
+
+func (p *prioritySegments) Sync() error {
+	return p.log.Sync()
+}
+
+// Close leaves the log open; it is closed through the log itself.
+func (p *prioritySegments) Close() error {
+	return nil
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func segmentCount(t *testing.T, dir string) int {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "data-*.log"))
+	if err != nil {
+		t.Fatalf("failed to list segments: %v", err)
+	}
+	return len(matches)
+}
+
+func checkKeys(t *testing.T, store *Store, n int) {
+	t.Helper()
+	for i := range n {
+		key := fmt.Appendf(nil, "key_%03d", i)
+		value, found, err := store.Get(key)
+		if err != nil || !found || string(value) != fmt.Sprintf("value_%03d", i) {
+			t.Fatalf("expected %s to hold value_%03d, got %q, found %v, err %v", key, i, value, found, err)
+		}
+	}
+}
+
+func TestSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{SegmentSize: 1024}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := range 300 {
+		if i == 100 {
+			if err := store.BeginBatch(); err != nil {
+				t.Fatalf("failed to begin batch: %v", err)
+			}
+		}
+		if err := store.Put(fmt.Appendf(nil, "key_%03d", i), fmt.Appendf(nil, "value_%03d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+		if i == 149 {
+			if err := store.CommitBatch(); err != nil {
+				t.Fatalf("failed to commit batch: %v", err)
+			}
+		}
+	}
+	checkKeys(t, store, 300)
+
+	segments := segmentCount(t, dir)
+	if segments < 8 {
+		t.Fatalf("expected the log to be split into segments of about 1KiB, got %d", segments)
+	}
+	if segmentOf(store.offset) != segments-1 {
+		t.Errorf("expected writes to go to segment %d, offset is in %d", segments-1, segmentOf(store.offset))
+	}
+
+	regions, err := store.GarbageStats(1 << 20)
+	if err != nil {
+		t.Fatalf("failed to get garbage stats: %v", err)
+	}
+	if len(regions) != segments {
+		t.Errorf("expected a region per segment, got %d regions for %d segments", len(regions), segments)
+	}
+	if report, err := store.Scrub(ScrubOptions{}); err != nil || report.Records != 301 || len(report.Mismatches) != 0 {
+		t.Errorf("expected a clean scrub of 301 records, got %+v, err %v", report, err)
+	}
+	store.Close()
+
+	records, report := inspectAll(t, dir)
+	if len(records) != 301 || report.End != report.Size || len(report.Corrupt) != 0 {
+		t.Errorf("expected to inspect 301 records up to the end, got %d and %+v", len(records), report)
+	}
+
+	// A clean open finds the end of the last segment, and an index rebuilt
+	// from the log reads every segment.
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	checkKeys(t, store, 300)
+	store.Close()
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+		t.Fatalf("failed to remove index file: %v", err)
+	}
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	checkKeys(t, store, 300)
+	if segmentCount(t, dir) != segments {
+		t.Errorf("expected %d segments after recovery, got %d", segments, segmentCount(t, dir))
+	}
+}
+
+func TestSegmentRecoveryTruncates(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{SegmentSize: 256}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := range 50 {
+		if err := store.Put(fmt.Appendf(nil, "key_%03d", i), fmt.Appendf(nil, "value_%03d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	store.Close()
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+
+	// Rot the first record of the second segment.
+	file, err := os.OpenFile(filepath.Join(dir, segmentFile(1)), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open segment: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("X"), checksumHeaderSize); err != nil {
+		t.Fatalf("failed to corrupt segment: %v", err)
+	}
+	file.Close()
+
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if store.offset != segmentPos(1, 0) || segmentCount(t, dir) != 2 {
+		t.Errorf("expected recovery to go on from the start of the second of 2 segments, got %x and %d segments",
+			store.offset, segmentCount(t, dir))
+	}
+	// The keys of the first segment are kept and the rest are gone.
+	kept := 0
+	for i := range 50 {
+		_, found, err := store.Get(fmt.Appendf(nil, "key_%03d", i))
+		if err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+		if found && kept != i {
+			t.Fatalf("expected the kept keys to come first, got key_%03d after %d", i, kept)
+		}
+		if found {
+			kept++
+		}
+	}
+	if kept == 0 || kept == 50 {
+		t.Errorf("expected only the keys of the first segment to be kept, got %d", kept)
+	}
+	if err := store.Put([]byte("key_new"), []byte("value")); err != nil {
+		t.Fatalf("failed to put after recovery: %v", err)
+	}
+	if _, found, err := store.Get([]byte("key_new")); err != nil || !found {
+		t.Errorf("expected key_new to be found, got %v, err %v", found, err)
+	}
+}
+
+func TestLegacyDataFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := range 10 {
+		if err := store.Put(fmt.Appendf(nil, "key_%03d", i), fmt.Appendf(nil, "value_%03d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	store.Close()
+	if err := os.Rename(filepath.Join(dir, segmentFile(0)), filepath.Join(dir, legacyDataFile)); err != nil {
+		t.Fatalf("failed to rename segment: %v", err)
+	}
+
+	if records, _ := inspectAll(t, dir); len(records) != 10 {
+		t.Errorf("expected to inspect 10 records of data.db, got %d", len(records))
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyDataFile)); err != nil {
+		t.Errorf("expected inspecting to leave data.db in place: %v", err)
+	}
+
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	checkKeys(t, store, 10)
+	if _, err := os.Stat(filepath.Join(dir, legacyDataFile)); !os.IsNotExist(err) {
+		t.Errorf("expected data.db to become the first segment, got %v", err)
+	}
+
+	if _, err := NewStoreWithOptions(t.TempDir(), Options{SegmentSize: -1}); !errors.Is(err, ErrInvalidSegmentSize) {
+		t.Errorf("expected %v, got %v", ErrInvalidSegmentSize, err)
+	}
+}
+
+func TestDropDeadSegments(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{SegmentSize: 256}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	putKeys := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			if err := store.Put(fmt.Appendf(nil, "key_%03d", i), fmt.Appendf(nil, "value_%03d", i)); err != nil {
+				t.Fatalf("failed to put: %v", err)
+			}
+		}
+	}
+	// Writes 40 keys with the prefix, then deletes the first 20 of them
+	// and forgets the rest.
+	dropKeys := func(prefix string) {
+		t.Helper()
+		for i := range 40 {
+			if err := store.Put(fmt.Appendf(nil, "%s_%03d", prefix, i), []byte("value")); err != nil {
+				t.Fatalf("failed to put: %v", err)
+			}
+		}
+		for i := range 20 {
+			if _, err := store.Delete(fmt.Appendf(nil, "%s_%03d", prefix, i)); err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+		}
+		if _, err := store.Forget(fmt.Appendf(nil, "%s_020", prefix), fmt.Appendf(nil, "%s_~", prefix)); err != nil {
+			t.Fatalf("failed to forget: %v", err)
+		}
+	}
+	dropKeys("old")
+	putKeys(0, 30)
+
+	// Every segment before the one the live keys start in is dead.
+	first, _, err := store.index.Lookup([]byte("key_000"))
+	if err != nil {
+		t.Fatalf("failed to look up key_000: %v", err)
+	}
+	dropped, err := store.DropDeadSegments()
+	if err != nil {
+		t.Fatalf("failed to drop dead segments: %v", err)
+	}
+	if dropped == 0 || dropped != segmentOf(first) {
+		t.Fatalf("expected the %d segments before key_000 to be emptied, got %d", segmentOf(first), dropped)
+	}
+	for segment := range segmentCount(t, dir) {
+		info, err := os.Stat(filepath.Join(dir, segmentFile(segment)))
+		if err != nil {
+			t.Fatalf("failed to stat segment: %v", err)
+		}
+		if emptied := info.Size() == 0; emptied != (segment < dropped) {
+			t.Errorf("expected segment %d to be emptied %v, got size %d", segment, segment < dropped, info.Size())
+		}
+	}
+	if dropped, err := store.DropDeadSegments(); err != nil || dropped != 0 {
+		t.Errorf("expected nothing more to drop, got %d, err %v", dropped, err)
+	}
+	checkKeys(t, store, 30)
+
+	// Dead segments between live ones go too, but not the delete records
+	// that keep keys written before them deleted.
+	dropKeys("mid")
+	putKeys(30, 60)
+	if dropped, err := store.DropDeadSegments(); err != nil || dropped == 0 {
+		t.Errorf("expected dead segments between live ones to be emptied, got %d, err %v", dropped, err)
+	}
+	checkKeys(t, store, 60)
+	store.Close()
+
+	records, report := inspectAll(t, dir)
+	if records[0].Offset != segmentPos(dropped, 0) || report.End != report.Size || len(report.Corrupt) != 0 {
+		t.Errorf("expected to inspect from segment %d up to the end, got %x and %+v", dropped, records[0].Offset, report)
+	}
+	deletes := 0
+	for _, record := range records {
+		if record.Type == RecordTypeDelete && strings.HasPrefix(string(record.Key), "mid_") {
+			deletes++
+		}
+	}
+	if deletes != 20 {
+		t.Errorf("expected the 20 delete records of the mid keys to be kept, got %d", deletes)
+	}
+
+	// A clean open and an index rebuilt from the log both pass over the
+	// emptied segments, and the dropped keys stay gone.
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+				t.Fatalf("failed to remove lock file: %v", err)
+			}
+			if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+				t.Fatalf("failed to remove index file: %v", err)
+			}
+		}
+		store, err = NewStoreWithOptions(dir, opts)
+		if err != nil {
+			t.Fatalf("failed to reopen store: %v", err)
+		}
+		checkKeys(t, store, 60)
+		for i := range 20 {
+			for _, prefix := range []string{"old", "mid"} {
+				if _, found, err := store.Get(fmt.Appendf(nil, "%s_%03d", prefix, i)); err != nil || found {
+					t.Errorf("expected %s_%03d to stay deleted, got %v, err %v", prefix, i, found, err)
+				}
+			}
+		}
+		store.Close()
+	}
+}
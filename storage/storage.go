@@ -2,35 +2,213 @@
 package storage
 
 import (
+	"bytes"
+	"cmp"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
 
+	"github.com/rizalta/toydb/checksum"
+	"github.com/rizalta/toydb/clock"
 	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/metrics"
 	"github.com/rizalta/toydb/pager"
+	"github.com/rizalta/toydb/trace"
 )
 
 type Pager interface {
 	WriteAtOffset(offset uint64, data []byte) error
 	ReadAtOffset(offset uint64, size int) ([]byte, error)
+	Sync() error
 	Close() error
 }
 
 type Index interface {
 	Insert(key []byte, value uint64, insertMode index.InsertMode) error
-	Search(key []byte) (uint64, error)
+	InsertInline(key []byte, payload []byte, insertMode index.InsertMode) error
+	Lookup(key []byte) (uint64, []byte, error)
+	LookupMany(keys [][]byte) ([]index.Entry, error)
 	Delete(key []byte) error
-	NewCursor(startKey, endKey []byte) (*index.Cursor, error)
+	NewCursor(startKey, endKey []byte) (Cursor, error)
+	Count(startKey, endKey []byte) (int, error)
+	First() ([]byte, uint64, error)
+	BulkLoad(source index.Source, fillFactor float64) error
+	LastBefore(endKey []byte) ([]byte, uint64, error)
 	Close() error
 }
 
 type Store struct {
-	pager   Pager
-	index   Index
-	offset  uint64
-	dataDir string
+	pager Pager
+	index Index
+	// offset is the log position the next record is written at.
+	offset   uint64
+	dataDir  string
+	inMemory bool
+	readOnly bool
+	// follower is set while the store only takes writes from ApplyLog.
+	follower bool
+	segments *segmentLog
+	// segmentSize is the size from which the log moves on to a new
+	// segment.
+	segmentSize uint64
+
+	dedupThreshold int
+	syncWrites     bool
+	metrics        *metrics.Registry
+	hasBlobs       bool
+	checksum       checksum.Algorithm
+	// bloom holds every key that was indexed, if the store keeps a bloom
+	// filter.
+	bloom           *bloomFilter
+	bloomBitsPerKey int
+	clock           clock.Clock
+	// compressor compresses values of at least compressionThreshold
+	// bytes, if set.
+	compressor           Compressor
+	compressionThreshold int
+	// aead encrypts the keys and values of records, if set, with a key
+	// derived from encryptionKey.
+	aead          cipher.AEAD
+	encryptionKey []byte
+	// unsynced is set while appended records may not be durable yet.
+	unsynced atomic.Bool
+	// batch is the open batch, if any, and batching is set while it is,
+	// for the write barrier.
+	batch    *batch
+	batching atomic.Bool
+
+	// scheduler paces the I/O of both pagers, and background is the data
+	// pager at background priority. Both are nil without a background rate.
+	scheduler  *pager.Scheduler
+	background Pager
+
+	// archiveDir receives sealed segments, of which the first archived
+	// are known to be there, and stamped is the time of the last time
+	// record, if archiveDir is set.
+	archiveDir string
+	archived   int
+	stamped    int64
+
+	// watchMu guards the watchers and closed, which is set once Close has
+	// closed them, as watchers come and go from other goroutines.
+	watchMu  sync.Mutex
+	watchers []*Watcher
+	closed   bool
+}
+
+type Options struct {
+	// DedupThreshold is the value size from which values are stored once
+	// per distinct content and shared between keys. Zero disables
+	// deduplication.
+	DedupThreshold int
+	// NoSyncBarrier lets index pages reach the disk before the records
+	// they point to. It saves an fsync of the data file per index page
+	// write, but a crash can then leave index entries pointing at records
+	// that were never written, which recovery drops by walking the whole
+	// index.
+	NoSyncBarrier bool
+	// BackgroundBytesPerSecond is the I/O rate of the store, in bytes per
+	// second, beyond which background work such as Scrub, GarbageStats and
+	// RepairOrphans waits. Foreground reads and writes never wait but count
+	// towards the rate, so background work only gets what they leave over.
+	// Zero lets background work run at full speed.
+	BackgroundBytesPerSecond int64
+	// Checksum is the algorithm of the index page checksums, fixed when
+	// the index file is created, and of the checksums of batches written
+	// from now on, each of which records the algorithm it was written
+	// with.
+	Checksum checksum.Algorithm
+	// Engine selects how keys are indexed. A store opened with another
+	// engine than before rebuilds the new index from the data log.
+	Engine Engine
+	// BloomBitsPerKey sizes a bloom filter of the keys in the store, which
+	// lets reads of keys that were never written skip the index. Ten bits
+	// per key let about one such read in a hundred through. The filter is
+	// saved on Close and rebuilt from the index after a crash. Zero
+	// disables it.
+	BloomBitsPerKey int
+	// Clock times periodic syncs and paces background work. Nil means the
+	// real clock.
+	Clock clock.Clock
+	// SegmentSize is the size a data log segment grows to before writes
+	// move on to a new segment file. A segment can exceed it by a record
+	// or a batch, which never span segments. Zero uses
+	// DefaultSegmentSize.
+	SegmentSize int64
+	// Compression compresses the values of heap records of at least
+	// CompressionThreshold bytes, where that makes them smaller. Reads
+	// decompress them with the compressor their record names, which has
+	// to be registered; this one is on open. Nil disables compression.
+	Compression Compressor
+	// CompressionThreshold is the smallest value Compression applies to.
+	// Zero uses DefaultCompressionThreshold.
+	CompressionThreshold int
+	// InMemory keeps the data log and the index in memory rather than in
+	// the data directory, which is not touched, so the store is empty when
+	// opened and gone once closed. Only EngineBTree can run in memory.
+	InMemory bool
+	// ReadOnly opens an existing store without writing to its directory.
+	// Writes fail with ErrReadOnly, and a store that was not closed
+	// cleanly fails to open with ErrNeedsRecovery, as recovering it
+	// writes. Only EngineBTree can be opened read-only.
+	ReadOnly bool
+	// SyncWrites makes every write outside a batch, and every commit,
+	// durable before it returns, at the cost of an fsync of the data log
+	// each. Otherwise writes become durable with the periodic sync, or
+	// when an index page that points at them is written.
+	SyncWrites bool
+	// CacheSize is the number of index pages kept in memory. Zero means
+	// pager.MaxCacheSize.
+	CacheSize int
+	// Eviction is how the index cache picks the page to evict.
+	Eviction pager.EvictionPolicy
+	// Mmap reads index pages from a memory mapping of the index file, as
+	// pager.Options.Mmap describes.
+	Mmap bool
+	// CompressPages stores the pages of a new index file compressed, as
+	// pager.Options.Compress describes.
+	CompressPages bool
+	// EncryptionKey, if set, encrypts the keys and values of the records
+	// in the data log with AES-GCM, and the pages of a new index file as
+	// pager.Options.Key describes, each under a key derived from it with
+	// HKDF-SHA256. It must be at least 16 bytes. A store opened with
+	// another key than its log was written with fails with
+	// pager.ErrWrongKey, one opened without a key with
+	// pager.ErrKeyRequired, and a key for a store that is not encrypted
+	// fails with pager.ErrNotEncrypted. Record headers, batch and time
+	// records, and the bloom filter stay in the clear. A follower needs
+	// the key of its leader. Only EngineBTree can be encrypted.
+	EncryptionKey []byte
+	// Logger receives the errors of background writes. Nil means
+	// slog.Default().
+	Logger trace.Logger
+	// Trace is called as index pages are read and written, index nodes
+	// split and merge, and the index pager flushes.
+	Trace *trace.Hooks
+	// Metrics counts the work of the store and of its index and pagers.
+	// Nil counts in a registry of the store's own.
+	Metrics *metrics.Registry
+	// ArchiveDir, if set, receives a copy of every data log segment once
+	// it is sealed, including those sealed before it was set, which are
+	// copied on open. The store then also stamps the log with the time of
+	// its writes, to the millisecond, so that RestoreToTime can rebuild it
+	// as it was at any point since. A store in memory or opened read-only
+	// archives nothing.
+	ArchiveDir string
+	// Follower opens the store as the follower of another, which takes
+	// writes only from ApplyLog until Promote. Other writes fail with
+	// ErrReadOnly.
+	Follower bool
+	// Faults, if set, fails writes to the data log and to the index of
+	// EngineBTree as it is told to, for tests of recovery from a crash.
+	Faults *pager.Faults
 }
 
 type RecordType byte
@@ -38,71 +216,285 @@ type RecordType byte
 const (
 	RecordTypeInsert RecordType = 0
 	RecordTypeDelete RecordType = 1
+	// RecordTypeBlobRef records hold the content hash of a deduplicated
+	// value instead of the value itself.
+	RecordTypeBlobRef RecordType = 2
+	// RecordTypeInline records are kept in the index leaf as well, so that
+	// reads never go to the log; the logged copy is only for recovery.
+	RecordTypeInline RecordType = 3
+	// RecordTypeBatch records head the records of a batch, which were
+	// written to the log together.
+	RecordTypeBatch RecordType = 4
+	// RecordTypeMerge records hold a merge operand and point back at the
+	// previous record of their key, which reads fold it into.
+	RecordTypeMerge RecordType = 5
+	// RecordTypeTime records hold the time, in Unix nanoseconds, from
+	// which the records after them were written, for RestoreToTime. They
+	// have no key and are never indexed.
+	RecordTypeTime RecordType = 6
+	// RecordTypeKeyCheck records start every segment of an encrypted log.
+	// They are sealed with the key of the store and hold nothing else, so
+	// that the key is checked on open even while the log holds no keys.
+	// They are never indexed.
+	RecordTypeKeyCheck RecordType = 7
+)
+
+var recordTypeNames = map[RecordType]string{
+	RecordTypeInsert:   "insert",
+	RecordTypeDelete:   "delete",
+	RecordTypeBlobRef:  "blobref",
+	RecordTypeInline:   "inline",
+	RecordTypeBatch:    "batch",
+	RecordTypeMerge:    "merge",
+	RecordTypeTime:     "time",
+	RecordTypeKeyCheck: "keycheck",
+}
+
+// mark reports whether records of type t mark the log, as time and key
+// check records do, rather than write a key.
+func (t RecordType) mark() bool {
+	return t == RecordTypeTime || t == RecordTypeKeyCheck
+}
+
+func (t RecordType) String() string {
+	if name, ok := recordTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("RecordType(%d)", t)
+}
+
+// Layout selects where a value is read from.
+type Layout uint8
+
+const (
+	// LayoutHeap keeps values in the data log and their offsets in the
+	// index.
+	LayoutHeap Layout = iota
+	// LayoutInline keeps values in the index leaves, ordered by key.
+	// Values larger than index.MaxInlineSize fall back to the heap.
+	LayoutInline
+)
+
+var (
+	ErrCorruptRecord = errors.New("storage: record checksum mismatch")
+	ErrReadOnly      = errors.New("storage: write to a read-only store")
+	ErrNeedsRecovery = errors.New("storage: store was not closed cleanly and cannot be opened read-only")
 )
 
 type Record struct {
 	RecordType RecordType
 	Key        []byte
 	Value      []byte
+	// legacy is set on records without a checksum: those written before
+	// records had one, and batch records, whose value guards the batch.
+	legacy bool
+	// compressed is the value as the log holds it, if it is compressed:
+	// the ID of the compressor followed by its output. Records read from
+	// the log leave Value nil until decompress.
+	compressed []byte
+	// sealed is the whole record as the log holds it, if its key and value
+	// are encrypted, which keeps its size once they are decrypted. Records
+	// read from the log leave Key and Value nil until Store.unseal.
+	sealed []byte
+	// expires is when the record expires, in Unix nanoseconds, or zero if
+	// it never does.
+	expires int64
 }
 
+// recordHeaderSize is the size of the header of a record without a
+// checksum: its type, key length and value length. Other records set
+// recordChecksumFlag in their type and follow the header with a CRC-32 of
+// the header and the key and value, which makes checksumHeaderSize.
+// Records whose value is compressed set recordCompressedFlag as well, and
+// records that expire set recordExpiryFlag and follow the checksum with
+// their expiry, which adds expirySize to the header. Records whose key and
+// value are encrypted set recordEncryptedFlag, as sealRecord describes.
+const (
+	recordHeaderSize     = 9
+	checksumHeaderSize   = recordHeaderSize + 4
+	expirySize           = 8
+	recordChecksumFlag   = 0x80
+	recordCompressedFlag = 0x40
+	recordExpiryFlag     = 0x20
+	recordEncryptedFlag  = 0x10
+	recordFlags          = recordChecksumFlag | recordCompressedFlag | recordExpiryFlag | recordEncryptedFlag
+)
+
 const (
 	indexFile = "index.db"
-	dataFile  = "data.db"
 	lockFile  = "clean.lock"
 )
 
 func NewStore(dataDir string) (*Store, error) {
-	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+	return NewStoreWithOptions(dataDir, Options{})
+}
+
+func NewStoreWithOptions(dataDir string, opts Options) (_ *Store, err error) {
+	if !opts.Checksum.Valid() {
+		return nil, checksum.ErrUnknownAlgorithm
+	}
+	if opts.SegmentSize < 0 || opts.SegmentSize > segmentOffsetMask {
+		return nil, ErrInvalidSegmentSize
+	}
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.Compression != nil {
+		if err := registerOwn(opts.Compression); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CompressionThreshold == 0 {
+		opts.CompressionThreshold = DefaultCompressionThreshold
+	}
+	opts.Metrics = metrics.OrNew(opts.Metrics)
+	if opts.InMemory && opts.Engine != EngineBTree {
+		return nil, ErrInMemoryEngine
+	}
+	if opts.ReadOnly && opts.Engine != EngineBTree {
+		return nil, ErrReadOnlyEngine
+	}
+	if opts.EncryptionKey != nil && opts.Engine != EngineBTree {
+		return nil, ErrEncryptedEngine
+	}
+	aead, err := newRecordAEAD(opts.EncryptionKey)
+	if err != nil {
 		return nil, err
 	}
+	if !opts.InMemory && !opts.ReadOnly {
+		if err := os.MkdirAll(dataDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
 
-	dataPath := filepath.Join(dataDir, dataFile)
-	dataPager, err := pager.NewPager(dataPath)
+	logOpts := pager.Options{
+		Clock:    opts.Clock,
+		InMemory: opts.InMemory,
+		ReadOnly: opts.ReadOnly,
+		Logger:   opts.Logger,
+		Metrics:  opts.Metrics,
+		Faults:   opts.Faults,
+	}
+	segments, err := openSegmentLog(dataDir, logOpts, !opts.ReadOnly)
 	if err != nil {
 		return nil, err
 	}
 
-	indexPath := filepath.Join(dataDir, indexFile)
-	indexPager, err := pager.NewPager(indexPath)
-	if err != nil {
-		dataPager.Close()
+	s := &Store{
+		pager:       segments,
+		offset:      0,
+		dataDir:     dataDir,
+		inMemory:    opts.InMemory,
+		readOnly:    opts.ReadOnly,
+		follower:    opts.Follower,
+		segments:    segments,
+		segmentSize: uint64(opts.SegmentSize),
+
+		dedupThreshold:  opts.DedupThreshold,
+		syncWrites:      opts.SyncWrites,
+		metrics:         opts.Metrics,
+		checksum:        opts.Checksum,
+		bloomBitsPerKey: opts.BloomBitsPerKey,
+		clock:           clock.OrReal(opts.Clock),
+
+		compressor:           opts.Compression,
+		compressionThreshold: opts.CompressionThreshold,
+
+		aead:          aead,
+		encryptionKey: opts.EncryptionKey,
+	}
+	defer func() {
+		if err != nil {
+			s.closeFiles()
+		}
+	}()
+	if err := s.checkKey(); err != nil {
 		return nil, err
 	}
+	if !opts.InMemory && !opts.ReadOnly {
+		s.archiveDir = opts.ArchiveDir
+	}
 
-	index, err := index.NewIndex(indexPager)
+	var barrier func() error
+	if !opts.NoSyncBarrier {
+		barrier = s.syncData
+	}
+	idx, indexPager, existed, err := openIndex(dataDir, opts, barrier)
 	if err != nil {
-		dataPager.Close()
-		indexPager.Close()
 		return nil, err
 	}
+	s.index = idx
 
-	s := &Store{
-		pager:   dataPager,
-		index:   index,
-		offset:  0,
-		dataDir: dataDir,
+	if opts.BackgroundBytesPerSecond > 0 {
+		s.scheduler = pager.NewSchedulerWithClock(opts.BackgroundBytesPerSecond, s.clock)
+		segments.setScheduler(s.scheduler)
+		if indexPager != nil {
+			indexPager.SetScheduler(s.scheduler)
+		}
+		s.background = segments.withPriority(pager.PriorityBackground)
 	}
 
+	// The clean lock file only vouches for an index that was there when
+	// the store was closed, not for one created now, as when the index
+	// file was lost or the engine changed. A store in memory has neither.
 	lockFilePath := filepath.Join(dataDir, lockFile)
-	if _, err := os.Stat(lockFilePath); err == nil {
-		offset := uint64(0)
+	err = os.ErrNotExist
+	if !opts.InMemory {
+		_, err = os.Stat(lockFilePath)
+	}
+	clean := err == nil && existed
+	switch {
+	case opts.ReadOnly && !clean:
+		return nil, ErrNeedsRecovery
+	case clean:
+		offset := s.segments.next(0)
 		for {
-			r, err := s.readRecord(offset)
+			r, err := readLogRecord(s.pager, offset)
 			if err != nil {
 				break
 			}
-			offset += uint64(len(r.serialize()))
+			offset = s.segments.next(offset + r.size())
+		}
+		if opts.ReadOnly {
+			// The store stays clean, and the log as it is.
+			s.offset = offset
+			break
+		}
+		if err := s.setEnd(offset); err != nil {
+			return nil, err
 		}
-		s.offset = offset
 		if err := os.Remove(lockFilePath); err != nil {
 			return nil, err
 		}
-	} else if os.IsNotExist(err) {
+	case err == nil || os.IsNotExist(err):
+		if err == nil {
+			if err := os.Remove(lockFilePath); err != nil {
+				return nil, err
+			}
+		}
 		if err := s.recoverIndex(); err != nil {
 			return nil, err
 		}
-	} else {
+	default:
+		return nil, err
+	}
+
+	// A new encrypted log starts with a key check record. A follower
+	// gets the one of its leader.
+	if s.offset == 0 && !s.readOnly && !s.follower {
+		if err := s.writeKeyCheck(); err != nil {
+			return nil, err
+		}
+	}
+	if s.hasBlobs, err = s.containsBlobs(); err != nil {
+		return nil, err
+	}
+	if opts.BloomBitsPerKey > 0 {
+		if err := s.openBloom(clean); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.archiveSealed(); err != nil {
 		return nil, err
 	}
 
@@ -110,117 +502,456 @@ func NewStore(dataDir string) (*Store, error) {
 }
 
 func (s *Store) recoverIndex() error {
-	offset := uint64(0)
+	if first, _, err := s.index.First(); err != nil {
+		return err
+	} else if first == nil {
+		return s.rebuildIndex()
+	}
+
+	offset := s.segments.next(0)
 	for {
-		r, err := s.readRecord(offset)
+		r, err := readLogRecord(s.pager, offset)
 		if err != nil {
 			break
 		}
+		if r.RecordType == RecordTypeBatch {
+			if !batchIntact(s.pager, offset, r) {
+				break
+			}
+			offset += batchHeaderSize
+			continue
+		}
 
-		err = s.index.Insert(r.Key, offset, index.Upsert)
+		if !r.RecordType.mark() {
+			if err := s.unseal(r); err != nil {
+				return err
+			}
+			if err := s.indexRecord(r, offset); err != nil {
+				return err
+			}
+		}
+		offset = s.segments.next(offset + r.size())
+	}
+	if err := s.setEnd(offset); err != nil {
+		return err
+	}
+	return s.dropEntriesFrom(offset)
+}
+
+// setEnd makes offset, where a walk over the log on open stopped, the
+// position the next record is written at. If the walk stopped before the
+// last segment, the segments after it are removed, as a single log file
+// would have its tail overwritten.
+func (s *Store) setEnd(offset uint64) error {
+	s.offset = offset
+	return s.segments.truncate(offset)
+}
+
+// dropEntriesFrom removes the index entries that point at or past offset,
+// where recovery stopped, or into a segment DropDeadSegments emptied. Only
+// a corrupt record in the middle of the log leaves the former, as the
+// write barrier keeps index pages from pointing past its durable end, and
+// the latter only keys forgotten just before a crash, which the index on
+// disk still held.
+func (s *Store) dropEntriesFrom(offset uint64) error {
+	cursor, err := s.index.NewCursor(nil, nil)
+	if err != nil {
+		return err
+	}
+	var stale [][]byte
+	for {
+		key, value, err := cursor.Next()
 		if err != nil {
 			return err
 		}
-		offset += uint64(len(r.serialize()))
+		if key == nil {
+			break
+		}
+		if cursor.Payload() == nil && (value >= offset || s.segments.next(value) != value) {
+			stale = append(stale, bytes.Clone(key))
+		}
+	}
+
+	for _, key := range stale {
+		if err := s.index.Delete(key); err != nil {
+			return err
+		}
 	}
-	s.offset = offset
 	return nil
 }
 
-func (r *Record) serialize() []byte {
-	keyBytes := []byte(r.Key)
+// indexEntry is what the index holds for a key: the offset of its record,
+// or the payload of an inline one.
+type indexEntry struct {
+	key     []byte
+	offset  uint64
+	payload []byte
+}
 
-	keyLen := uint32(len(keyBytes))
-	valueLen := uint32(len(r.Value))
+// entrySource hands sorted index entries to BulkLoad.
+type entrySource struct {
+	entries []indexEntry
+	next    int
+}
 
-	totalLength := 9 + len(r.Key) + len(r.Value)
-	buf := make([]byte, totalLength)
+func (e *entrySource) Next() ([]byte, uint64, error) {
+	if e.next == len(e.entries) {
+		return nil, 0, nil
+	}
+	e.next++
+	entry := e.entries[e.next-1]
+	return entry.key, entry.offset, nil
+}
 
-	buf[0] = byte(r.RecordType)
+func (e *entrySource) Payload() []byte {
+	return e.entries[e.next-1].payload
+}
+
+// rebuildIndex fills an empty index from the log. It keeps the latest
+// record of every key, sorts them and bulk loads the index, which is much
+// faster than replaying the log into it one record at a time.
+func (s *Store) rebuildIndex() error {
+	latest := make(map[string]indexEntry)
+	offset := s.segments.next(0)
+	for {
+		r, err := readLogRecord(s.pager, offset)
+		if err != nil {
+			break
+		}
+		if r.RecordType == RecordTypeBatch {
+			if !batchIntact(s.pager, offset, r) {
+				break
+			}
+			offset += batchHeaderSize
+			continue
+		}
+		if err := s.unseal(r); err != nil {
+			return err
+		}
 
-	binary.LittleEndian.PutUint32(buf[1:5], keyLen)
-	binary.LittleEndian.PutUint32(buf[5:9], valueLen)
-	copy(buf[9:9+keyLen], keyBytes)
-	if r.Value != nil {
-		copy(buf[9+keyLen:], r.Value)
+		switch r.RecordType {
+		case RecordTypeTime, RecordTypeKeyCheck:
+		case RecordTypeDelete:
+			delete(latest, string(r.Key))
+		case RecordTypeInline:
+			value := r.Value
+			if value == nil {
+				value = []byte{}
+			}
+			latest[string(r.Key)] = indexEntry{key: r.Key, payload: value}
+		default:
+			latest[string(r.Key)] = indexEntry{key: r.Key, offset: offset}
+		}
+		offset = s.segments.next(offset + r.size())
+	}
+	if err := s.setEnd(offset); err != nil {
+		return err
 	}
 
+	entries := make([]indexEntry, 0, len(latest))
+	for _, entry := range latest {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b indexEntry) int {
+		return bytes.Compare(a.key, b.key)
+	})
+	return s.index.BulkLoad(&entrySource{entries: entries}, 0)
+}
+
+func (r *Record) serialize() []byte {
+	headerSize := r.headerSize()
+	keyLen := len(r.Key)
+	value := r.storedValue()
+	buf := make([]byte, headerSize+keyLen+len(value))
+
+	buf[0] = byte(r.RecordType)
+	if r.compressed != nil {
+		buf[0] |= recordCompressedFlag
+	}
+	binary.LittleEndian.PutUint32(buf[1:5], uint32(keyLen))
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(len(value)))
+	copy(buf[headerSize:], r.Key)
+	copy(buf[headerSize+keyLen:], value)
+
+	if r.expires != 0 {
+		buf[0] |= recordExpiryFlag
+		binary.LittleEndian.PutUint64(buf[checksumHeaderSize:], uint64(r.expires))
+	}
+	if !r.legacy {
+		buf[0] |= recordChecksumFlag
+		binary.LittleEndian.PutUint32(buf[recordHeaderSize:], recordCRC(buf))
+	}
 	return buf
 }
 
-func deserialize(data []byte) (*Record, error) {
-	if len(data) < 9 {
-		return nil, fmt.Errorf("storage: record too short")
+// recordCRC returns the checksum of a serialized record with a checksum,
+// which covers everything but the checksum itself.
+func recordCRC(data []byte) uint32 {
+	crc := crc32.ChecksumIEEE(data[:recordHeaderSize])
+	return crc32.Update(crc, crc32.IEEETable, data[checksumHeaderSize:])
+}
+
+// recordIntact reports whether a serialized record matches its checksum.
+// Records written without one, such as batch records, have nothing to
+// check.
+func recordIntact(data []byte) bool {
+	if data[0]&recordChecksumFlag == 0 {
+		return true
 	}
+	return recordCRC(data) == binary.LittleEndian.Uint32(data[recordHeaderSize:])
+}
 
-	recordType := RecordType(data[0])
+func (r *Record) headerSize() int {
+	switch {
+	case r.legacy:
+		return recordHeaderSize
+	case r.expires != 0:
+		return checksumHeaderSize + expirySize
+	}
+	return checksumHeaderSize
+}
 
-	keyLen := binary.LittleEndian.Uint32(data[1:5])
-	valuelen := binary.LittleEndian.Uint32(data[5:9])
+// size returns the length of the record in the log.
+func (r *Record) size() uint64 {
+	if r.sealed != nil {
+		return uint64(len(r.sealed))
+	}
+	return uint64(r.headerSize() + len(r.Key) + len(r.storedValue()))
+}
 
-	if len(data) < int(9+keyLen+valuelen) {
+// storedValue returns the value as the log holds it.
+func (r *Record) storedValue() []byte {
+	if r.compressed != nil {
+		return r.compressed
+	}
+	return r.Value
+}
+
+func deserialize(data []byte) (*Record, error) {
+	if len(data) < recordHeaderSize {
+		return nil, fmt.Errorf("storage: record too short")
+	}
+
+	legacy := data[0]&recordChecksumFlag == 0
+	compressed := data[0]&recordCompressedFlag != 0
+	recordType := RecordType(data[0] &^ recordFlags)
+	size := recordSize(data)
+	if uint64(len(data)) < size {
 		return nil, fmt.Errorf("storage: record data truncated")
 	}
+	if !recordIntact(data[:size]) {
+		return nil, ErrCorruptRecord
+	}
 
-	key := data[9 : 9+keyLen]
+	headerSize := checksumHeaderSize
+	if legacy {
+		headerSize = recordHeaderSize
+	}
+	var expires int64
+	if !legacy && data[0]&recordExpiryFlag != 0 {
+		expires = int64(binary.LittleEndian.Uint64(data[headerSize:]))
+		headerSize += expirySize
+	}
+	if !legacy && data[0]&recordEncryptedFlag != 0 {
+		return &Record{RecordType: recordType, expires: expires, sealed: bytes.Clone(data[:size])}, nil
+	}
+	keyLen := binary.LittleEndian.Uint32(data[1:5])
+	valuelen := binary.LittleEndian.Uint32(data[5:9])
+	key := data[headerSize : headerSize+int(keyLen)]
 
 	var value []byte
-	if recordType == RecordTypeInsert && valuelen > 0 {
+	if recordType != RecordTypeDelete && valuelen > 0 {
 		value = make([]byte, valuelen)
-		copy(value, data[9+keyLen:9+keyLen+valuelen])
+		copy(value, data[headerSize+int(keyLen):size])
 	}
-
-	return &Record{
+	r := &Record{
 		RecordType: recordType,
 		Key:        key,
 		Value:      value,
-	}, nil
+		legacy:     legacy,
+		expires:    expires,
+	}
+	if compressed && value != nil {
+		r.Value, r.compressed = nil, value
+	}
+	return r, nil
 }
 
 func (s *Store) Put(key []byte, value []byte) error {
+	return s.Write(key, value, index.Upsert, LayoutHeap)
+}
+
+func (s *Store) Update(key []byte, value []byte) error {
+	return s.Write(key, value, index.UpdateOnly, LayoutHeap)
+}
+
+func (s *Store) Add(key []byte, value []byte) error {
+	return s.Write(key, value, index.InsertOnly, LayoutHeap)
+}
+
+// Write stores a value with the given insert mode and layout. A key may
+// change layout between writes.
+func (s *Store) Write(key []byte, value []byte, mode index.InsertMode, layout Layout) error {
+	return s.write(key, value, mode, layout, 0)
+}
+
+// write is Write of a record that expires at expires, in Unix nanoseconds,
+// unless it is zero. Records that expire are kept on the heap, as the
+// index holds no expiry for inline values.
+func (s *Store) write(key []byte, value []byte, mode index.InsertMode, layout Layout, expires int64) error {
+	if isBlobKey(key) {
+		return ErrReservedKey
+	}
+
+	watched := s.watched(key)
+	var previous *Record
+	if mode != index.Upsert || s.hasBlobs || watched {
+		var err error
+		previous, err = s.lookup(key)
+		if err != nil {
+			return err
+		}
+		live := previous != nil && !s.expired(previous)
+		if mode == index.InsertOnly && live {
+			return index.ErrKeyAlreadyExists
+		}
+		if mode == index.UpdateOnly && !live {
+			return index.ErrKeyNotFound
+		}
+	}
+	var change Change
+	if watched {
+		change = Change{Op: ChangePut, Key: bytes.Clone(key), NewValue: bytes.Clone(value)}
+		if previous != nil {
+			var err error
+			if change.OldValue, err = s.resolve(previous); err != nil {
+				return err
+			}
+		}
+	}
+
 	record := &Record{
 		RecordType: RecordTypeInsert,
 		Key:        key,
 		Value:      value,
+		expires:    expires,
 	}
-
-	serialized := record.serialize()
-
-	err := s.pager.WriteAtOffset(s.offset, serialized)
-	if err != nil {
-		return fmt.Errorf("storage: failed to write record: %v", err)
+	if layout == LayoutInline && expires == 0 && len(value) <= index.MaxInlineSize {
+		record.RecordType = RecordTypeInline
+	} else if s.dedupThreshold > 0 && len(value) >= s.dedupThreshold {
+		hash, err := s.retainBlob(value)
+		if err != nil {
+			return err
+		}
+		record.RecordType = RecordTypeBlobRef
+		record.Value = hash
 	}
 
-	err = s.index.Insert(key, s.offset, index.Upsert)
-	if err != nil {
-		return fmt.Errorf("storage: failed to index key: %v", err)
+	if err := s.append(record); err != nil {
+		return err
+	}
+	if watched {
+		s.notify(change)
 	}
 
-	s.offset += uint64(len(serialized))
+	if previous != nil && previous.RecordType == RecordTypeBlobRef {
+		return s.releaseBlob(previous.Value)
+	}
 
 	return nil
 }
 
-func (s *Store) Update(key []byte, value []byte) error {
-	record := &Record{
-		RecordType: RecordTypeInsert,
-		Key:        key,
-		Value:      value,
+// current returns the live record for key, or nil if the key is missing,
+// deleted or expired.
+func (s *Store) current(key []byte) (*Record, error) {
+	record, err := s.lookup(key)
+	if err != nil || record == nil || s.expired(record) {
+		return nil, err
 	}
+	return record, nil
+}
 
-	serialized := record.serialize()
+// lookup returns the record the index holds for key, expired or not, or
+// nil if the key is missing or deleted.
+func (s *Store) lookup(key []byte) (*Record, error) {
+	if s.bloom != nil && !s.bloom.mayContain(key) {
+		return nil, nil
+	}
 
-	err := s.pager.WriteAtOffset(s.offset, serialized)
+	offset, payload, err := s.index.Lookup(key)
 	if err != nil {
-		return fmt.Errorf("storage: failed to write record: %v", err)
+		if errors.Is(err, index.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if payload != nil {
+		return &Record{
+			RecordType: RecordTypeInline,
+			Key:        key,
+			Value:      payload,
+		}, nil
 	}
 
-	err = s.index.Insert(key, s.offset, index.UpdateOnly)
+	record, err := s.readRecord(offset)
 	if err != nil {
-		if errors.Is(err, index.ErrKeyNotFound) {
+		return nil, err
+	}
+	if record.RecordType == RecordTypeDelete {
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+func (s *Store) append(record *Record) error {
+	if s.readOnly || s.follower {
+		return ErrReadOnly
+	}
+	if s.compressor != nil && record.compressed == nil && record.RecordType == RecordTypeInsert &&
+		len(record.Value) >= s.compressionThreshold {
+		if err := record.compress(s.compressor); err != nil {
+			return fmt.Errorf("storage: failed to compress value: %w", err)
+		}
+	}
+	serialized := record.serialize()
+	if s.aead != nil {
+		var err error
+		if serialized, err = sealRecord(s.aead, serialized); err != nil {
+			return fmt.Errorf("storage: failed to encrypt record: %w", err)
+		}
+	}
+
+	if s.batch != nil {
+		if err := s.batch.save(s.index, record.Key); err != nil {
+			return fmt.Errorf("storage: failed to index key: %w", err)
+		}
+		s.batch.buf = append(s.batch.buf, serialized...)
+		s.batch.records++
+	} else {
+		if err := s.rotateIfFull(); err != nil {
 			return err
 		}
-		return fmt.Errorf("storage: failed to index key: %v", err)
+		if err := s.stamp(); err != nil {
+			return err
+		}
+		err := s.pager.WriteAtOffset(s.offset, serialized)
+		if err != nil {
+			return fmt.Errorf("storage: failed to write record: %w", err)
+		}
+		s.unsynced.Store(true)
+		s.metrics.RecordsWritten.Inc()
+		s.metrics.RecordBytes.Add(uint64(len(serialized)))
+		if s.syncWrites {
+			if err := s.syncData(); err != nil {
+				return fmt.Errorf("storage: failed to sync record: %w", err)
+			}
+		}
+	}
+
+	if err := s.indexRecord(record, s.offset); err != nil {
+		return fmt.Errorf("storage: failed to index key: %w", err)
 	}
 
 	s.offset += uint64(len(serialized))
@@ -228,122 +959,318 @@ func (s *Store) Update(key []byte, value []byte) error {
 	return nil
 }
 
-func (s *Store) Add(key []byte, value []byte) error {
-	record := &Record{
-		RecordType: RecordTypeInsert,
-		Key:        key,
-		Value:      value,
+// indexRecord points the index at a record written at offset. Deleted keys
+// are removed from the index rather than pointed at their delete record,
+// so that the index alone tells which keys exist.
+func (s *Store) indexRecord(record *Record, offset uint64) error {
+	switch record.RecordType {
+	case RecordTypeDelete:
+		if err := s.index.Delete(record.Key); err != nil && !errors.Is(err, index.ErrKeyNotFound) {
+			return err
+		}
+		return nil
+	case RecordTypeInline:
+		if err := s.index.InsertInline(record.Key, record.Value, index.Upsert); err != nil {
+			return err
+		}
+	default:
+		if err := s.index.Insert(record.Key, offset, index.Upsert); err != nil {
+			return err
+		}
 	}
+	return s.addToBloom(record.Key)
+}
 
-	serialized := record.serialize()
+// syncData makes every appended record durable. It is the write barrier of
+// the index pager, so an index page on disk never points past the durable
+// end of the log. While a batch is open it defers the writes instead, as
+// the index may point into records that are not in the log yet.
+func (s *Store) syncData() error {
+	if s.batching.Load() {
+		return pager.ErrWriteDeferred
+	}
+	if !s.unsynced.Swap(false) {
+		return nil
+	}
+	if err := s.pager.Sync(); err != nil {
+		s.unsynced.Store(true)
+		return err
+	}
+	return nil
+}
 
-	err := s.pager.WriteAtOffset(s.offset, serialized)
+// rotateIfFull moves the log on to a new segment once the current one has
+// reached the segment size. It only runs between records and batches, so
+// neither ever spans segments. The full segment is synced first, so that a
+// crash can only tear the last segment, and the new one of an encrypted
+// log starts with a key check record.
+func (s *Store) rotateIfFull() error {
+	if s.offset&segmentOffsetMask < s.segmentSize {
+		return nil
+	}
+	if err := s.pager.Sync(); err != nil {
+		return err
+	}
+	offset, err := s.segments.rotate()
 	if err != nil {
-		return fmt.Errorf("storage: failed to write record: %v", err)
+		return err
+	}
+	s.offset = offset
+	if err := s.writeKeyCheck(); err != nil {
+		return err
 	}
+	return s.archiveSealed()
+}
 
-	err = s.index.Insert(key, s.offset, index.InsertOnly)
-	if err != nil {
-		if errors.Is(err, index.ErrKeyAlreadyExists) {
-			return err
+// recordSize returns the length of a record from the first
+// recordHeaderSize bytes of it.
+func recordSize(header []byte) uint64 {
+	keyLen := binary.LittleEndian.Uint32(header[1:5])
+	valueLen := binary.LittleEndian.Uint32(header[5:9])
+	size := uint64(recordHeaderSize) + uint64(keyLen) + uint64(valueLen)
+	if header[0]&recordChecksumFlag != 0 {
+		size += checksumHeaderSize - recordHeaderSize
+		if header[0]&recordExpiryFlag != 0 {
+			size += expirySize
 		}
-		return fmt.Errorf("storage: failed to index key: %v", err)
 	}
+	return size
+}
 
-	s.offset += uint64(len(serialized))
+func (s *Store) readRecord(offset uint64) (*Record, error) {
+	return s.readRecordFrom(s.pager, offset)
+}
 
-	return nil
+// backgroundPager returns the data pager to use for background work.
+func (s *Store) backgroundPager() Pager {
+	if s.background != nil {
+		return s.background
+	}
+	return s.pager
 }
 
-func (s *Store) readRecord(offset uint64) (*Record, error) {
-	headerData, err := s.pager.ReadAtOffset(offset, 9)
+// readRecordFrom reads the record at offset through p, which is the data
+// pager at some priority, decompressing its value.
+func (s *Store) readRecordFrom(p Pager, offset uint64) (*Record, error) {
+	r, err := s.readStoredRecord(p, offset)
 	if err != nil {
 		return nil, err
 	}
+	if err := r.decompress(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// readStoredRecord reads the record at offset through p, decrypted but
+// with its value as it is stored, for walks of the log that only need keys
+// and inline values.
+func (s *Store) readStoredRecord(p Pager, offset uint64) (*Record, error) {
+	var r *Record
+	var err error
+	if s.batch != nil && offset >= s.batch.start+batchHeaderSize {
+		r, err = deserialize(s.batch.buf[offset-s.batch.start-batchHeaderSize:])
+	} else {
+		r, err = readLogRecord(p, offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.unseal(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
 
-	keyLen := binary.LittleEndian.Uint32(headerData[1:5])
-	valuelen := binary.LittleEndian.Uint32(headerData[5:9])
+// readLogRecord reads the record at offset of the log behind p. A record
+// that does not match its checksum, as one torn by a crash or rotted on
+// disk, fails with ErrCorruptRecord. A compressed value is left as it is,
+// and so are an encrypted key and value.
+func readLogRecord(p Pager, offset uint64) (*Record, error) {
+	headerData, err := p.ReadAtOffset(offset, recordHeaderSize)
+	if err != nil {
+		return nil, err
+	}
 
-	remaining := int(keyLen + valuelen)
-	remainingData, err := s.pager.ReadAtOffset(offset+9, remaining)
+	remaining := int(recordSize(headerData)) - recordHeaderSize
+	remainingData, err := p.ReadAtOffset(offset+recordHeaderSize, remaining)
 	if err != nil {
 		return nil, err
 	}
 
-	data := make([]byte, 9+remaining)
+	data := make([]byte, recordHeaderSize+remaining)
 	copy(data, headerData)
-	copy(data[9:], remainingData)
+	copy(data[recordHeaderSize:], remainingData)
 
 	return deserialize(data)
 }
 
 func (s *Store) Get(key []byte) ([]byte, bool, error) {
-	offset, err := s.index.Search(key)
-	if err != nil {
-		if errors.Is(err, index.ErrKeyNotFound) {
-			return nil, false, nil
-		}
+	record, err := s.current(key)
+	if err != nil || record == nil {
 		return nil, false, err
 	}
 
-	record, err := s.readRecord(offset)
+	value, err := s.resolve(record)
 	if err != nil {
 		return nil, false, err
 	}
 
-	if record.RecordType == RecordTypeDelete {
-		return nil, false, nil
-	}
-
-	return record.Value, true, nil
+	return value, true, nil
 }
 
-func (s *Store) Delete(key []byte) (bool, error) {
-	offset, err := s.index.Search(key)
+// MultiGet reads the values of several keys, returning them in the order
+// of keys along with whether each was found. The index is searched once
+// for all keys and the records are then read in the order they lie in the
+// data file.
+func (s *Store) MultiGet(keys [][]byte) ([][]byte, []bool, error) {
+	entries, err := s.index.LookupMany(keys)
 	if err != nil {
-		if errors.Is(err, index.ErrKeyNotFound) {
-			return false, nil
+		return nil, nil, err
+	}
+
+	values := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
+	var onHeap []int
+	for i, entry := range entries {
+		switch {
+		case !entry.Found:
+		case entry.Payload != nil:
+			values[i], found[i] = entry.Payload, true
+		default:
+			onHeap = append(onHeap, i)
 		}
-		return false, err
 	}
+	slices.SortFunc(onHeap, func(a, b int) int {
+		return cmp.Compare(entries[a].Value, entries[b].Value)
+	})
 
-	record, err := s.readRecord(offset)
-	if err != nil {
-		return false, err
+	for _, i := range onHeap {
+		record, err := s.readRecord(entries[i].Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if record.RecordType == RecordTypeDelete || s.expired(record) {
+			continue
+		}
+		if values[i], err = s.resolve(record); err != nil {
+			return nil, nil, err
+		}
+		found[i] = true
 	}
 
-	if record.RecordType == RecordTypeDelete {
-		return false, nil
+	return values, found, nil
+}
+
+// Delete removes a key, reporting whether it existed. An expired key is
+// removed as well but reported missing, as reads already treat it.
+func (s *Store) Delete(key []byte) (bool, error) {
+	record, err := s.lookup(key)
+	if err != nil || record == nil {
+		return false, err
+	}
+	watched := s.watched(key)
+	var old []byte
+	if watched {
+		if old, err = s.resolve(record); err != nil {
+			return false, err
+		}
 	}
 
-	record = &Record{
+	err = s.append(&Record{
 		RecordType: RecordTypeDelete,
 		Key:        key,
 		Value:      nil,
+	})
+	if err != nil {
+		return false, err
+	}
+	if watched {
+		s.notify(Change{Op: ChangeDelete, Key: bytes.Clone(key), OldValue: old})
 	}
 
-	serialized := record.serialize()
-	err = s.pager.WriteAtOffset(s.offset, serialized)
-	if err != nil {
-		return false, fmt.Errorf("storage: failed to write tombstone: %v", err)
+	if record.RecordType == RecordTypeBlobRef {
+		if err := s.releaseBlob(record.Value); err != nil {
+			return false, err
+		}
 	}
 
-	err = s.index.Insert(key, s.offset, index.Upsert)
+	return !s.expired(record), nil
+}
+
+// Forget removes the keys in [startKey, endKey) from the index without
+// writing delete records, and returns how many there were. Their records
+// stay in the log, so an index rebuilt from it has them again; callers
+// that forget keys have to remember which.
+func (s *Store) Forget(startKey, endKey []byte) (int, error) {
+	if s.readOnly || s.follower {
+		return 0, ErrReadOnly
+	}
+	iterator, err := s.NewKeyIterator(startKey, endKey)
 	if err != nil {
-		return false, fmt.Errorf("storage: failed to index key: %v", err)
+		return 0, err
 	}
-	s.offset += uint64(len(serialized))
 
-	return true, nil
+	var keys [][]byte
+	for {
+		key, err := iterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		if key == nil {
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		if err := s.index.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// IndexStats reports the shape of the index tree and how full its pages
+// are, or nil on the LSM engine, which has no such tree.
+func (s *Store) IndexStats() (*index.Stats, error) {
+	tree, ok := s.index.(btree)
+	if !ok {
+		return nil, nil
+	}
+	return tree.Stats()
 }
 
+// closeFiles closes the index and the data log of a store that failed to
+// open, leaving the directory as it is.
+func (s *Store) closeFiles() {
+	if s.index != nil {
+		s.index.Close()
+	}
+	s.pager.Close()
+}
+
+// Close aborts the open batch, if any, before closing the store, and
+// closes its watchers.
 func (s *Store) Close() error {
+	s.closeWatchers()
+	if s.batch != nil {
+		if err := s.AbortBatch(); err != nil {
+			return err
+		}
+	}
 	if err := s.index.Close(); err != nil {
 		return err
 	}
 	if err := s.pager.Close(); err != nil {
 		return err
 	}
+	if s.inMemory || s.readOnly {
+		return nil
+	}
+	if err := s.writeBloom(); err != nil {
+		return err
+	}
 	lockFilePath := filepath.Join(s.dataDir, lockFile)
 	file, err := os.Create(lockFilePath)
 	if err != nil {
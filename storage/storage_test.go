@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"testing"
 
 	"github.com/rizalta/toydb/index"
@@ -554,3 +557,814 @@ func TestCursorRange(t *testing.T) {
 		})
 	}
 }
+
+func TestIteratorSkipsDeleted(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	for i := range 10 {
+		key := fmt.Appendf(nil, "key_%d", i)
+		if err := store.Put(key, []byte("value")); err != nil {
+			t.Fatalf("failed to put key %s: %v", key, err)
+		}
+	}
+	for _, i := range []int{0, 3, 4, 9} {
+		key := fmt.Appendf(nil, "key_%d", i)
+		if _, err := store.Delete(key); err != nil {
+			t.Fatalf("failed to delete key %s: %v", key, err)
+		}
+	}
+
+	itr, err := store.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+
+	var foundKeys []string
+	for {
+		key, _, err := itr.Next()
+		if err != nil {
+			t.Fatalf("next call failed: %v", err)
+		}
+		if key == nil {
+			break
+		}
+		foundKeys = append(foundKeys, string(key))
+	}
+
+	expected := []string{"key_1", "key_2", "key_5", "key_6", "key_7", "key_8"}
+	if !reflect.DeepEqual(foundKeys, expected) {
+		t.Errorf("expected keys %v, got %v", expected, foundKeys)
+	}
+}
+
+func TestRange(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	for i := range 10 {
+		key := fmt.Appendf(nil, "key_%d", i)
+		if err := store.Put(key, fmt.Appendf(nil, "value_%d", i)); err != nil {
+			t.Fatalf("failed to put key %s: %v", key, err)
+		}
+	}
+
+	var pairs []string
+	for key, value := range store.Range([]byte("key_3"), []byte("key_7")) {
+		pairs = append(pairs, string(key)+"="+string(value))
+	}
+	expected := []string{"key_3=value_3", "key_4=value_4", "key_5=value_5", "key_6=value_6"}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expected pairs %v, got %v", expected, pairs)
+	}
+
+	// Breaking out of a loop over All leaves the iterator where it stopped.
+	itr, err := store.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	for key := range itr.All() {
+		if string(key) == "key_1" {
+			break
+		}
+	}
+	if key, _, err := itr.Next(); err != nil || string(key) != "key_2" {
+		t.Errorf("expected key_2 after the break, got %q, err %v", key, err)
+	}
+
+	errRead := errors.New("read failed")
+	store.pager = &failingReadPager{Pager: store.pager, err: errRead}
+	defer func() { store.pager = store.pager.(*failingReadPager).Pager }()
+	itr, err = store.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create iterator: %v", err)
+	}
+	for range itr.All() {
+		t.Errorf("expected no pairs when reads fail")
+	}
+	if !errors.Is(itr.Err(), errRead) {
+		t.Errorf("expected error %v, got %v", errRead, itr.Err())
+	}
+}
+
+type failingReadPager struct {
+	Pager
+	err error
+}
+
+func (p *failingReadPager) ReadAtOffset(offset uint64, size int) ([]byte, error) {
+	return nil, p.err
+}
+
+type readCountingPager struct {
+	Pager
+	reads int
+}
+
+func (p *readCountingPager) ReadAtOffset(offset uint64, size int) ([]byte, error) {
+	p.reads++
+	return p.Pager.ReadAtOffset(offset, size)
+}
+
+func TestKeyIterator(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStoreWithOptions(dir, Options{DedupThreshold: 16})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	for i := range 10 {
+		key := fmt.Appendf(nil, "key_%d", i)
+		if err := store.Put(key, bytes.Repeat([]byte{byte(i)}, 8+i*4)); err != nil {
+			t.Fatalf("failed to put key %s: %v", key, err)
+		}
+	}
+	for _, i := range []int{0, 3, 4, 9} {
+		if _, err := store.Delete(fmt.Appendf(nil, "key_%d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	expected := []string{"key_1", "key_2", "key_5", "key_6", "key_7", "key_8"}
+
+	verify := func(store *Store) {
+		t.Helper()
+		counter := &readCountingPager{Pager: store.pager}
+		store.pager = counter
+		defer func() { store.pager = counter.Pager }()
+
+		itr, err := store.NewKeyIterator([]byte("key_1"), nil)
+		if err != nil {
+			t.Fatalf("failed to create iterator: %v", err)
+		}
+		var keys []string
+		for {
+			key, err := itr.Next()
+			if err != nil {
+				t.Fatalf("next call failed: %v", err)
+			}
+			if key == nil {
+				break
+			}
+			keys = append(keys, string(key))
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("expected keys %v, got %v", expected, keys)
+		}
+
+		for i := range 10 {
+			has, err := store.Has(fmt.Appendf(nil, "key_%d", i))
+			if err != nil {
+				t.Fatalf("failed to check key: %v", err)
+			}
+			if want := slices.Contains(expected, fmt.Sprintf("key_%d", i)); has != want {
+				t.Errorf("expected Has(key_%d) to be %v, got %v", i, want, has)
+			}
+		}
+		if counter.reads != 0 {
+			t.Errorf("expected no reads from the data file, got %d", counter.reads)
+		}
+	}
+	verify(store)
+
+	// Recovering the index from the log leaves deleted keys out as well.
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	store, err = NewStoreWithOptions(dir, Options{DedupThreshold: 16})
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	verify(store)
+}
+
+func BenchmarkPut(b *testing.B) {
+	store, err := NewStore(b.TempDir())
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := make([]byte, 100)
+	b.ResetTimer()
+	for i := range b.N {
+		if err := store.Put(fmt.Appendf(nil, "key_%09d", i), value); err != nil {
+			b.Fatalf("failed to put: %v", err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	store, err := NewStore(b.TempDir())
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	const numKeys = 10000
+	value := make([]byte, 100)
+	for i := range numKeys {
+		if err := store.Put(fmt.Appendf(nil, "key_%09d", i), value); err != nil {
+			b.Fatalf("failed to put: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := range b.N {
+		if _, _, err := store.Get(fmt.Appendf(nil, "key_%09d", (i*7919)%numKeys)); err != nil {
+			b.Fatalf("failed to get: %v", err)
+		}
+	}
+}
+
+func BenchmarkIterator(b *testing.B) {
+	store, err := NewStore(b.TempDir())
+	if err != nil {
+		b.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	value := make([]byte, 100)
+	for i := range 10000 {
+		if err := store.Put(fmt.Appendf(nil, "key_%09d", i), value); err != nil {
+			b.Fatalf("failed to put: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		itr, err := store.NewIterator(nil, nil)
+		if err != nil {
+			b.Fatalf("failed to create iterator: %v", err)
+		}
+		for {
+			key, _, err := itr.Next()
+			if err != nil {
+				b.Fatalf("next call failed: %v", err)
+			}
+			if key == nil {
+				break
+			}
+		}
+	}
+}
+
+func TestAddAfterDelete(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	key := []byte("key")
+	if err := store.Add(key, []byte("first")); err != nil {
+		t.Fatalf("failed to add: %v", err)
+	}
+	if _, err := store.Delete(key); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	if err := store.Update(key, []byte("update")); !errors.Is(err, index.ErrKeyNotFound) {
+		t.Errorf("expected update of deleted key to fail with %v, got %v", index.ErrKeyNotFound, err)
+	}
+
+	offset := store.offset
+	if err := store.Add(key, []byte("second")); err != nil {
+		t.Fatalf("failed to add deleted key again: %v", err)
+	}
+	if err := store.Add(key, []byte("third")); !errors.Is(err, index.ErrKeyAlreadyExists) {
+		t.Errorf("expected %v, got %v", index.ErrKeyAlreadyExists, err)
+	}
+
+	record := &Record{RecordType: RecordTypeInsert, Key: key, Value: []byte("second")}
+	if want := offset + uint64(len(record.serialize())); store.offset != want {
+		t.Errorf("expected failed add not to write to the log, offset %d, want %d", store.offset, want)
+	}
+}
+
+func TestInlineLayout(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	expected := make(map[string][]byte)
+	for i := range 300 {
+		key := fmt.Appendf(nil, "key_%03d", i)
+		value := fmt.Appendf(nil, "inline_%d", i)
+		if i%10 == 0 {
+			value = bytes.Repeat([]byte{byte(i)}, index.MaxInlineSize+1)
+		}
+		if err := store.Write(key, value, index.InsertOnly, LayoutInline); err != nil {
+			t.Fatalf("failed to write key %s: %v", key, err)
+		}
+		expected[string(key)] = value
+	}
+
+	if err := store.Update([]byte("key_001"), []byte("heap")); err != nil {
+		t.Fatalf("failed to move key to the heap: %v", err)
+	}
+	expected["key_001"] = []byte("heap")
+	if err := store.Write([]byte("key_002"), []byte("moved"), index.UpdateOnly, LayoutInline); err != nil {
+		t.Fatalf("failed to update inline key: %v", err)
+	}
+	expected["key_002"] = []byte("moved")
+	if _, err := store.Delete([]byte("key_003")); err != nil {
+		t.Fatalf("failed to delete inline key: %v", err)
+	}
+	delete(expected, "key_003")
+
+	if err := store.Write([]byte("key_001"), []byte("x"), index.InsertOnly, LayoutInline); !errors.Is(err, index.ErrKeyAlreadyExists) {
+		t.Errorf("expected %v, got %v", index.ErrKeyAlreadyExists, err)
+	}
+
+	verify := func(store *Store) {
+		t.Helper()
+		for key, value := range expected {
+			got, found, err := store.Get([]byte(key))
+			if err != nil || !found {
+				t.Fatalf("failed to get key %s: found %v, err %v", key, found, err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Errorf("expected value %q for key %s, got %q", value, key, got)
+			}
+		}
+		if _, found, _ := store.Get([]byte("key_003")); found {
+			t.Errorf("expected deleted key to stay deleted")
+		}
+
+		itr, err := store.NewIterator(nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create iterator: %v", err)
+		}
+		count := 0
+		for {
+			key, value, err := itr.Next()
+			if err != nil {
+				t.Fatalf("next call failed: %v", err)
+			}
+			if key == nil {
+				break
+			}
+			if !bytes.Equal(value, expected[string(key)]) {
+				t.Errorf("expected iterator value %q for key %s, got %q", expected[string(key)], key, value)
+			}
+			count++
+		}
+		if count != len(expected) {
+			t.Errorf("expected %d keys from iterator, got %d", len(expected), count)
+		}
+	}
+	verify(store)
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+		t.Fatalf("failed to remove index file: %v", err)
+	}
+
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	verify(store)
+}
+
+func TestMultiGet(t *testing.T) {
+	store, err := NewStoreWithOptions(t.TempDir(), Options{DedupThreshold: 64})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	expected := make(map[string][]byte)
+	for i := range 200 {
+		key := fmt.Appendf(nil, "key_%03d", 199-i)
+		value := fmt.Appendf(nil, "value_%d", i)
+		layout := LayoutHeap
+		switch {
+		case i%7 == 0:
+			layout = LayoutInline
+		case i%11 == 0:
+			value = bytes.Repeat([]byte{'b'}, 100)
+		}
+		if err := store.Write(key, value, index.InsertOnly, layout); err != nil {
+			t.Fatalf("failed to write key %s: %v", key, err)
+		}
+		expected[string(key)] = value
+	}
+	for _, key := range []string{"key_010", "key_014"} {
+		if _, err := store.Delete([]byte(key)); err != nil {
+			t.Fatalf("failed to delete key %s: %v", key, err)
+		}
+		delete(expected, key)
+	}
+
+	keys := [][]byte{[]byte("key_150"), []byte("key_010"), []byte("missing"), []byte("key_000"), []byte("key_150")}
+	for i := 199; i >= 0; i -= 3 {
+		keys = append(keys, fmt.Appendf(nil, "key_%03d", i))
+	}
+
+	values, found, err := store.MultiGet(keys)
+	if err != nil {
+		t.Fatalf("failed to get keys: %v", err)
+	}
+	for i, key := range keys {
+		value, ok := expected[string(key)]
+		if found[i] != ok {
+			t.Errorf("expected found=%v for key %s, got %v", ok, key, found[i])
+		}
+		if !bytes.Equal(values[i], value) {
+			t.Errorf("expected value %q for key %s, got %q", value, key, values[i])
+		}
+	}
+}
+
+// volatilePager keeps appended data in memory until Sync, like an OS page
+// cache that a crash empties.
+type volatilePager struct {
+	Pager
+	buf     []byte
+	durable int
+}
+
+func (p *volatilePager) WriteAtOffset(offset uint64, data []byte) error {
+	if end := int(offset) + len(data); end > len(p.buf) {
+		p.buf = append(p.buf, make([]byte, end-len(p.buf))...)
+	}
+	copy(p.buf[offset:], data)
+	return nil
+}
+
+func (p *volatilePager) ReadAtOffset(offset uint64, size int) ([]byte, error) {
+	if int(offset)+size > len(p.buf) {
+		return nil, fmt.Errorf("read past end at offset %d", offset)
+	}
+	return bytes.Clone(p.buf[offset : int(offset)+size]), nil
+}
+
+func (p *volatilePager) Sync() error {
+	if err := p.Pager.WriteAtOffset(uint64(p.durable), p.buf[p.durable:]); err != nil {
+		return err
+	}
+	p.durable = len(p.buf)
+	return p.Pager.Sync()
+}
+
+func TestSyncBarrier(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		lost bool
+	}{
+		{"barrier", Options{}, false},
+		{"no barrier", Options{NoSyncBarrier: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewStoreWithOptions(dir, tt.opts)
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			store.pager = &volatilePager{Pager: store.pager}
+
+			for i := range 2000 {
+				key := fmt.Appendf(nil, "key_%d", i)
+				if err := store.Put(key, fmt.Appendf(nil, "value_%d", i)); err != nil {
+					t.Fatalf("failed to put: %v", err)
+				}
+			}
+
+			// Crash right after the index reached the disk: the data file
+			// holds only what was synced, and there is no clean lock file.
+			if err := store.index.Close(); err != nil {
+				t.Fatalf("failed to close index: %v", err)
+			}
+			reopened, err := NewStoreWithOptions(dir, tt.opts)
+			if err != nil {
+				t.Fatalf("failed to reopen store: %v", err)
+			}
+			defer reopened.Close()
+
+			found, broken := 0, 0
+			for i := range 2000 {
+				value, ok, err := reopened.Get(fmt.Appendf(nil, "key_%d", i))
+				switch {
+				case err != nil || ok && !bytes.Equal(value, fmt.Appendf(nil, "value_%d", i)):
+					broken++
+				case ok:
+					found++
+				}
+			}
+			// Without the barrier the index pointed at records that were
+			// lost, and recovery drops those entries.
+			if broken > 0 || tt.lost == (found == 2000) {
+				t.Errorf("expected keys to be lost: %v, got %d found and %d broken", tt.lost, found, broken)
+			}
+		})
+	}
+}
+
+func TestRebuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	for i := range 5000 {
+		if err := store.Put(fmt.Appendf(nil, "key_%04d", i), fmt.Appendf(nil, "value_%d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	for i := 0; i < 5000; i += 3 {
+		if _, err := store.Delete(fmt.Appendf(nil, "key_%04d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	for i := 0; i < 5000; i += 5 {
+		if err := store.Put(fmt.Appendf(nil, "key_%04d", i), fmt.Appendf(nil, "updated_%d", i)); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+
+	store.Close()
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+		t.Fatalf("failed to remove index file: %v", err)
+	}
+
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	expectedCount := 0
+	for i := range 5000 {
+		key := fmt.Appendf(nil, "key_%04d", i)
+		var expected []byte
+		switch {
+		case i%5 == 0:
+			expected = fmt.Appendf(nil, "updated_%d", i)
+		case i%3 != 0:
+			expected = fmt.Appendf(nil, "value_%d", i)
+		}
+
+		value, found, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("failed to get %s: %v", key, err)
+		}
+		if found != (expected != nil) || !bytes.Equal(value, expected) {
+			t.Errorf("expected %s to hold %q, got %q, found %v", key, expected, value, found)
+		}
+		if expected != nil {
+			expectedCount++
+		}
+	}
+	if count, err := store.Count(nil, nil); err != nil || count != expectedCount {
+		t.Errorf("expected %d keys in the rebuilt index, got %d, err %v", expectedCount, count, err)
+	}
+}
+
+func TestRecordChecksum(t *testing.T) {
+	record := &Record{RecordType: RecordTypeInsert, Key: []byte("key"), Value: []byte("value")}
+	data := record.serialize()
+	if len(data) != checksumHeaderSize+len("keyvalue") || data[0] != byte(RecordTypeInsert)|recordChecksumFlag {
+		t.Fatalf("unexpected serialized record %x", data)
+	}
+	decoded, err := deserialize(data)
+	if err != nil || !reflect.DeepEqual(decoded, record) {
+		t.Errorf("expected %+v, got %+v, err %v", record, decoded, err)
+	}
+	for i := range data {
+		corrupted := bytes.Clone(data)
+		corrupted[i] ^= 0x01
+		if _, err := deserialize(corrupted); err == nil {
+			t.Errorf("expected a flipped bit in byte %d to be caught", i)
+		}
+	}
+
+	legacy := &Record{RecordType: RecordTypeInsert, Key: []byte("key"), Value: []byte("value"), legacy: true}
+	data = legacy.serialize()
+	if len(data) != recordHeaderSize+len("keyvalue") {
+		t.Fatalf("unexpected record without checksum %x", data)
+	}
+	if decoded, err := deserialize(data); err != nil || !reflect.DeepEqual(decoded, legacy) {
+		t.Errorf("expected %+v, got %+v, err %v", legacy, decoded, err)
+	}
+}
+
+func TestRecoveryStopsAtCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for _, key := range []string{"key1", "key2", "key3"} {
+		if err := store.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	size := (&Record{Key: []byte("key1"), Value: []byte("value")}).size()
+	store.Close()
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+
+	// Rot the value of key2, which the index still points at.
+	file, err := os.OpenFile(filepath.Join(dir, segmentFile(0)), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("X"), int64(2*size-1)); err != nil {
+		t.Fatalf("failed to corrupt log: %v", err)
+	}
+	file.Close()
+
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+
+	if store.offset != size {
+		t.Errorf("expected recovery to stop at %d, got %d", size, store.offset)
+	}
+	for key, expected := range map[string]bool{"key1": true, "key2": false, "key3": false} {
+		if _, found, err := store.Get([]byte(key)); err != nil || found != expected {
+			t.Errorf("expected %s found %v, got %v, err %v", key, expected, found, err)
+		}
+	}
+	if err := store.Put([]byte("key4"), []byte("value")); err != nil {
+		t.Fatalf("failed to put after recovery: %v", err)
+	}
+	if value, found, err := store.Get([]byte("key4")); err != nil || !found || string(value) != "value" {
+		t.Errorf("expected key4 to hold value, got %q, found %v, err %v", value, found, err)
+	}
+}
+
+func TestInMemory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	opts := Options{InMemory: true, SegmentSize: 1 << 12, BloomBitsPerKey: 10, DedupThreshold: 64}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	// Enough writes to fill several segments and evict index pages.
+	shared := bytes.Repeat([]byte("shared"), 20)
+	for i := range 2000 {
+		key := fmt.Appendf(nil, "key_%05d", i)
+		value := fmt.Appendf(nil, "value_%d", i)
+		if i%100 == 0 {
+			value = shared
+		}
+		if err := store.Put(key, value); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	if _, err := store.Delete([]byte("key_00001")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if len(store.segments.segments) < 2 {
+		t.Errorf("expected the log to span segments, got %d", len(store.segments.segments))
+	}
+	for key, expected := range map[string][]byte{"key_00000": shared, "key_01999": []byte("value_1999"), "key_00001": nil} {
+		value, found, err := store.Get([]byte(key))
+		if err != nil || found != (expected != nil) || !bytes.Equal(value, expected) {
+			t.Errorf("expected %s to hold %q, got %q, found %v, err %v", key, expected, value, found, err)
+		}
+	}
+	if count, err := store.Count(nil, nil); err != nil || count != 1999 {
+		t.Errorf("expected 1999 keys, got %d, err %v", count, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the data directory to be left alone, got %v", err)
+	}
+
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	if _, found, err := store.Get([]byte("key_00000")); err != nil || found {
+		t.Errorf("expected a new store in memory to be empty, got found %v, err %v", found, err)
+	}
+
+	if _, err := NewStoreWithOptions(dir, Options{InMemory: true, Engine: EngineLSM}); !errors.Is(err, ErrInMemoryEngine) {
+		t.Errorf("expected %v, got %v", ErrInMemoryEngine, err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStoreWithOptions(dir, Options{BloomBitsPerKey: 10})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for i := range 500 {
+		if err := store.Put(fmt.Appendf(nil, "key_%03d", i), []byte("value")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	snapshot := func() map[string][]byte {
+		t.Helper()
+		files := make(map[string][]byte)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("failed to read directory: %v", err)
+		}
+		for _, entry := range entries {
+			if files[entry.Name()], err = os.ReadFile(filepath.Join(dir, entry.Name())); err != nil {
+				t.Fatalf("failed to read %s: %v", entry.Name(), err)
+			}
+		}
+		return files
+	}
+	before := snapshot()
+
+	opts := Options{ReadOnly: true, BloomBitsPerKey: 10}
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to open store read-only: %v", err)
+	}
+	if value, found, err := store.Get([]byte("key_042")); err != nil || !found || string(value) != "value" {
+		t.Errorf("expected key_042 to hold value, got %q, found %v, err %v", value, found, err)
+	}
+	if count, err := store.Count(nil, nil); err != nil || count != 500 {
+		t.Errorf("expected 500 keys, got %d, err %v", count, err)
+	}
+	writes := map[string]func() error{
+		"put":    func() error { return store.Put([]byte("key"), []byte("value")) },
+		"delete": func() error { _, err := store.Delete([]byte("key_042")); return err },
+		"batch":  store.BeginBatch,
+		"forget": func() error { _, err := store.Forget(nil, nil); return err },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: expected %v, got %v", name, ErrReadOnly, err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+	if after := snapshot(); !reflect.DeepEqual(after, before) {
+		t.Errorf("expected a read-only open to leave the files alone")
+	}
+
+	// A store that was not closed cleanly needs a writable open first.
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	if _, err := NewStoreWithOptions(dir, opts); !errors.Is(err, ErrNeedsRecovery) {
+		t.Errorf("expected %v, got %v", ErrNeedsRecovery, err)
+	}
+	if _, err := NewStoreWithOptions(t.TempDir(), Options{ReadOnly: true, Engine: EngineLSM}); !errors.Is(err, ErrReadOnlyEngine) {
+		t.Errorf("expected %v, got %v", ErrReadOnlyEngine, err)
+	}
+}
+
+func TestSyncWrites(t *testing.T) {
+	store, err := NewStoreWithOptions(t.TempDir(), Options{SyncWrites: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if store.unsynced.Load() {
+		t.Errorf("expected the put to be synced")
+	}
+	if err := store.BeginBatch(); err != nil {
+		t.Fatalf("failed to begin batch: %v", err)
+	}
+	if err := store.Put([]byte("key"), []byte("batched")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := store.CommitBatch(); err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+	if store.unsynced.Load() {
+		t.Errorf("expected the commit to be synced")
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/rizalta/toydb/index"
+)
+
+var ErrInvalidTTL = errors.New("storage: TTL must be positive")
+
+// PutWithTTL stores a value that expires ttl from now on the store's clock.
+// Get, MultiGet and iterators over values treat an expired key as missing
+// and writes as free, while Has, Count, LastKey and key iterators look
+// only at the index and see it until SweepExpired deletes it. Writing the
+// key again without a TTL makes it permanent.
+func (s *Store) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return s.write(key, value, index.Upsert, LayoutHeap, s.clock.Now().Add(ttl).UnixNano())
+}
+
+func (s *Store) expired(r *Record) bool {
+	return r.expires != 0 && r.expires <= s.clock.Now().UnixNano()
+}
+
+// SweepExpired deletes the keys whose latest record has expired and
+// returns how many there were. The delete records take them out of the
+// index, release the blobs they held and leave their records dead for
+// GarbageStats. It walks the whole data log at background priority; the
+// store does not run it by itself, as it leaves writes to its caller.
+func (s *Store) SweepExpired() (int, error) {
+	now := s.clock.Now().UnixNano()
+	background := s.backgroundPager()
+	expired := make(map[string]uint64)
+	for offset := s.segments.next(0); offset < s.offset; {
+		r, err := s.readStoredRecord(background, offset)
+		if err != nil {
+			return 0, err
+		}
+		if r.RecordType == RecordTypeBatch {
+			offset += batchHeaderSize
+			continue
+		}
+		if r.RecordType.mark() {
+			offset = s.segments.next(offset + r.size())
+			continue
+		}
+		if r.expires != 0 && r.expires <= now {
+			expired[string(r.Key)] = offset
+		} else {
+			delete(expired, string(r.Key))
+		}
+		offset = s.segments.next(offset + r.size())
+	}
+
+	swept := 0
+	for _, key := range slices.Sorted(maps.Keys(expired)) {
+		// Only a key the index still holds at its expired record goes;
+		// others were deleted or forgotten since.
+		indexed, payload, err := s.index.Lookup([]byte(key))
+		if errors.Is(err, index.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return swept, err
+		}
+		if payload != nil || indexed != expired[key] {
+			continue
+		}
+		if _, err := s.Delete([]byte(key)); err != nil {
+			return swept, err
+		}
+		swept++
+	}
+	return swept, nil
+}
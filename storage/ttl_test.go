@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/clock"
+	"github.com/rizalta/toydb/index"
+)
+
+func TestRecordExpiry(t *testing.T) {
+	r := &Record{RecordType: RecordTypeInsert, Key: []byte("key"), Value: []byte("value"), expires: 1 << 60}
+	data := r.serialize()
+	if uint64(len(data)) != r.size() || recordSize(data) != r.size() {
+		t.Fatalf("expected a record of %d bytes, serialized %d, header says %d", r.size(), len(data), recordSize(data))
+	}
+	decoded, err := deserialize(data)
+	if err != nil {
+		t.Fatalf("failed to deserialize: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, r) {
+		t.Errorf("expected %+v, got %+v", r, decoded)
+	}
+	data[checksumHeaderSize] ^= 1
+	if _, err := deserialize(data); !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("expected the checksum to cover the expiry, got %v", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	dir := t.TempDir()
+	clk := clock.NewVirtual(time.Now())
+	opts := Options{Clock: clk, DedupThreshold: 64}
+	store, err := NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	attachment := bytes.Repeat([]byte("attachment"), 100)
+	puts := []struct {
+		key   string
+		value []byte
+		ttl   time.Duration
+	}{
+		{"minute", []byte("short"), time.Minute},
+		{"hour", []byte("long"), time.Hour},
+		{"blob", attachment, time.Minute},
+		{"renewed", []byte("old"), time.Minute},
+	}
+	for _, put := range puts {
+		if err := store.PutWithTTL([]byte(put.key), put.value, put.ttl); err != nil {
+			t.Fatalf("failed to put %s: %v", put.key, err)
+		}
+	}
+	if err := store.Put([]byte("forever"), []byte("value")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := store.Put([]byte("renewed"), []byte("new")); err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+	if err := store.PutWithTTL([]byte("minute"), nil, 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("expected %v, got %v", ErrInvalidTTL, err)
+	}
+
+	live := func(store *Store, expected ...string) {
+		t.Helper()
+		var keys []string
+		for key := range store.Range(nil, nil) {
+			keys = append(keys, string(key))
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("expected to iterate %v, got %v", expected, keys)
+		}
+		for _, put := range puts {
+			_, found, err := store.Get([]byte(put.key))
+			if err != nil {
+				t.Fatalf("failed to get %s: %v", put.key, err)
+			}
+			if found != slices.Contains(expected, put.key) {
+				t.Errorf("expected %s to be found %v", put.key, !found)
+			}
+		}
+	}
+	live(store, "blob", "forever", "hour", "minute", "renewed")
+
+	clk.Advance(2 * time.Minute)
+	live(store, "forever", "hour", "renewed")
+	_, found, err := store.MultiGet([][]byte{[]byte("minute"), []byte("hour")})
+	if err != nil || !reflect.DeepEqual(found, []bool{false, true}) {
+		t.Errorf("expected MultiGet to find only hour, got %v, err %v", found, err)
+	}
+	if err := store.Update([]byte("minute"), []byte("value")); !errors.Is(err, index.ErrKeyNotFound) {
+		t.Errorf("expected updating an expired key to fail with %v, got %v", index.ErrKeyNotFound, err)
+	}
+	// Index-only reads still see expired keys until they are swept.
+	if has, err := store.Has([]byte("minute")); err != nil || !has {
+		t.Errorf("expected Has to see minute until swept, got %v, err %v", has, err)
+	}
+	store.Close()
+
+	// Expiry survives recovery.
+	if err := os.Remove(filepath.Join(dir, lockFile)); err != nil {
+		t.Fatalf("failed to remove lock file: %v", err)
+	}
+	store, err = NewStoreWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	live(store, "forever", "hour", "renewed")
+
+	swept, err := store.SweepExpired()
+	if err != nil || swept != 2 {
+		t.Fatalf("expected to sweep minute and blob, swept %d, err %v", swept, err)
+	}
+	if has, err := store.Has([]byte("minute")); err != nil || has {
+		t.Errorf("expected minute to be gone after the sweep, got %v, err %v", has, err)
+	}
+	if blobs, err := store.containsBlobs(); err != nil || blobs {
+		t.Errorf("expected the sweep to release the blob, got %v, err %v", blobs, err)
+	}
+	if swept, err := store.SweepExpired(); err != nil || swept != 0 {
+		t.Errorf("expected nothing left to sweep, swept %d, err %v", swept, err)
+	}
+
+	if err := store.Add([]byte("hour"), []byte("value")); !errors.Is(err, index.ErrKeyAlreadyExists) {
+		t.Errorf("expected %v, got %v", index.ErrKeyAlreadyExists, err)
+	}
+	clk.Advance(time.Hour)
+	if err := store.Add([]byte("hour"), []byte("value")); err != nil {
+		t.Errorf("expected an expired key to be free for Add, got %v", err)
+	}
+	live(store, "forever", "hour", "renewed")
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rizalta/toydb/index"
+	"github.com/rizalta/toydb/pager"
+)
+
+// VerifyOptions configures Verify.
+type VerifyOptions struct {
+	// Engine is the engine the store was written with.
+	Engine Engine
+	// Repair, unless it is OrphanReport, drops the index entries that do
+	// not point at a record of their key and then deals with the orphans
+	// as RepairOrphans does. Verify opens the store read-only otherwise.
+	Repair OrphanAction
+	// EncryptionKey is the key of an encrypted store.
+	EncryptionKey []byte
+}
+
+// Dangling is an index entry that does not point at a record of its key.
+type Dangling struct {
+	Key    []byte
+	Offset uint64
+	Reason string
+}
+
+// VerifyReport holds what Verify found. The index fields are only set for
+// a store on the B+tree engine.
+type VerifyReport struct {
+	// Log is the walk over the data log that checked every record and
+	// batch against its checksum.
+	Log *LogReport
+	// Index is the walk over the index tree.
+	Index *index.VerifyReport
+	// IndexPages is the number of pages of the index file, of which
+	// FreePages are on the free list and LeakedPages neither there nor in
+	// the tree, or less than zero if some are in both. A file cut short
+	// in the middle of a page has PartialPage set, which a compressed or
+	// encrypted one never has.
+	IndexPages  int
+	FreePages   int
+	LeakedPages int
+	PartialPage bool
+	// Dangling are the index entries that point past the log, at a record
+	// that cannot be read, or at one of another key or that deletes it.
+	Dangling []Dangling
+	// Orphans are the latest records of keys that the index does not
+	// point at, as RepairOrphans reports them.
+	Orphans []Orphan
+}
+
+// OK reports whether Verify found nothing wrong. What a crash leaves
+// behind that only wastes room does not count: leaked pages, a partial
+// page at the end of the index file, which the pager ignores until the
+// next new page overwrites it, and a torn write at the end of the log,
+// which recovery drops.
+func (r *VerifyReport) OK() bool {
+	return len(r.Log.Corrupt) == 0 && (r.Index == nil || r.Index.OK()) &&
+		r.LeakedPages >= 0 && len(r.Dangling) == 0 && len(r.Orphans) == 0
+}
+
+// Verify checks a data directory that no store has open: the checksum of
+// every record of the data log, the structure and page count of the
+// index, that every index entry points at a record of its key and that
+// every key the log holds is indexed. A store that was not closed cleanly
+// has to be opened once before it can be verified without Repair, which
+// rebuilds its index.
+func Verify(dataDir string, opts VerifyOptions) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	var err error
+	if report.Log, err = InspectLog(dataDir, func(LogRecord) error { return nil }); err != nil {
+		return nil, err
+	}
+
+	s, err := NewStoreWithOptions(dataDir, Options{
+		Engine:        opts.Engine,
+		ReadOnly:      opts.Repair == OrphanReport,
+		EncryptionKey: opts.EncryptionKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.verify(report, opts.Repair)
+	return report, errors.Join(err, s.Close())
+}
+
+func (s *Store) verify(report *VerifyReport, action OrphanAction) error {
+	if tree, ok := s.index.(btree); ok {
+		if err := verifyTree(tree, filepath.Join(s.dataDir, indexFile), report); err != nil {
+			return err
+		}
+	}
+
+	if err := s.findDangling(report); err != nil {
+		return err
+	}
+	if action != OrphanReport {
+		for _, d := range report.Dangling {
+			if err := s.index.Delete(d.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	repair, err := s.RepairOrphans(action)
+	if err != nil {
+		return err
+	}
+	report.Orphans = repair.Orphans
+	return nil
+}
+
+// verifyTree walks the index tree and counts its pages against those of
+// the file at path.
+func verifyTree(tree btree, path string, report *VerifyReport) error {
+	var err error
+	if report.Index, err = tree.Verify(); err != nil {
+		return err
+	}
+	stats, err := tree.Stats()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	report.IndexPages = stats.Pages
+	report.FreePages = stats.FreePages
+	// Page 0 holds the meta page of the index.
+	report.LeakedPages = stats.Pages - 1 - report.Index.Pages - stats.FreePages
+	report.PartialPage = !tree.pages.Compressed() && !tree.pages.Encrypted() && info.Size()%pager.PageSize != 0
+	return nil
+}
+
+// findDangling checks that every entry of the index that points into the
+// log points at a live record of its key. Inline entries hold their value
+// and need no record.
+func (s *Store) findDangling(report *VerifyReport) error {
+	cursor, err := s.index.NewCursor(nil, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		key, offset, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+		if cursor.Payload() != nil {
+			continue
+		}
+
+		reason := ""
+		if offset >= s.offset {
+			reason = fmt.Sprintf("past the end of the log at %d", s.offset)
+		} else if r, err := s.readStoredRecord(s.backgroundPager(), offset); err != nil {
+			reason = err.Error()
+		} else if !bytes.Equal(r.Key, key) {
+			reason = fmt.Sprintf("record of key %q", r.Key)
+		} else if r.RecordType == RecordTypeDelete || r.RecordType == RecordTypeBatch || r.RecordType.mark() {
+			reason = fmt.Sprintf("%s record", r.RecordType)
+		}
+		if reason != "" {
+			report.Dangling = append(report.Dangling, Dangling{Key: bytes.Clone(key), Offset: offset, Reason: reason})
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	for range 2 {
+		putKeys(t, store, 0, 300)
+	}
+	for i := 0; i < 300; i += 3 {
+		if _, err := store.Delete(fmt.Appendf(nil, "key_%03d", i)); err != nil {
+			t.Fatalf("failed to delete: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	report, err := Verify(dir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if !report.OK() || report.LeakedPages != 0 {
+		t.Fatalf("expected a sound store without leaked pages, got %+v, index %+v", report, report.Index)
+	}
+	if report.IndexPages != 1+report.Index.Pages+report.FreePages {
+		t.Errorf("expected the pages of the file to add up, got %+v", report)
+	}
+
+	// Point one key at the record of another and drop another from the
+	// index behind the back of the log.
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	offset, _, err := store.index.Lookup([]byte("key_001"))
+	if err != nil {
+		t.Fatalf("failed to look up: %v", err)
+	}
+	if err := store.index.Insert([]byte("key_002"), offset, 0); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if err := store.index.Delete([]byte("key_004")); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	report, err = Verify(dir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if report.OK() || len(report.Dangling) != 1 || string(report.Dangling[0].Key) != "key_002" {
+		t.Fatalf("expected key_002 to dangle, got %+v", report.Dangling)
+	}
+	orphans := map[string]bool{}
+	for _, orphan := range report.Orphans {
+		orphans[string(orphan.Key)] = true
+	}
+	if len(orphans) != 2 || !orphans["key_002"] || !orphans["key_004"] {
+		t.Errorf("expected key_002 and key_004 to be orphans, got %+v", report.Orphans)
+	}
+
+	if _, err := Verify(dir, VerifyOptions{Repair: OrphanReindex}); err != nil {
+		t.Fatalf("failed to repair: %v", err)
+	}
+	report, err = Verify(dir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if !report.OK() {
+		t.Errorf("expected the repair to leave a sound store, got %+v", report)
+	}
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+	for _, key := range []string{"key_002", "key_004"} {
+		value, found, err := store.Get([]byte(key))
+		if err != nil || !found || string(value) != "value_"+key[4:] {
+			t.Errorf("expected %s to be back, got %q, found %v, err %v", key, value, found, err)
+		}
+	}
+}
+
+func TestVerifyCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	putKeys(t, store, 0, 10)
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	path := filepath.Join(dir, segmentFile(0))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read segment: %v", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write segment: %v", err)
+	}
+
+	report, err := Verify(dir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if report.OK() || report.Log.End >= report.Log.Size || len(report.Dangling) == 0 {
+		t.Errorf("expected the log to end early and the index entries past it to dangle, got %+v", report)
+	}
+}
+
+func TestVerifyCompressedPages(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStoreWithOptions(dir, Options{CompressPages: true})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	putKeys(t, store, 0, 300)
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	report, err := Verify(dir, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	if !report.OK() || report.PartialPage || report.LeakedPages != 0 {
+		t.Errorf("expected a sound store, got %+v", report)
+	}
+}
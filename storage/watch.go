@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+)
+
+var ErrWatchOverflow = errors.New("storage: watcher fell behind")
+
+// WatchBuffer is the number of changes a watcher holds for its reader. A
+// watcher whose buffer is full when a change comes is closed with
+// ErrWatchOverflow rather than hold up the write.
+const WatchBuffer = 256
+
+type ChangeOp uint8
+
+const (
+	ChangePut ChangeOp = iota
+	ChangeDelete
+)
+
+// Change is a write to a watched key. OldValue is what the key held
+// before, expired or not, and nil if it held nothing; NewValue is nil for
+// deletes.
+type Change struct {
+	Op       ChangeOp
+	Key      []byte
+	OldValue []byte
+	NewValue []byte
+}
+
+// Watcher receives the changes to the keys under a prefix on C, in the
+// order they were written. C is closed when the watcher or the store is
+// closed, or when the watcher overflows.
+type Watcher struct {
+	C      <-chan Change
+	c      chan Change
+	store  *Store
+	prefix []byte
+	// err is set under the watchMu of the store.
+	err error
+}
+
+// Watch returns a watcher of the keys starting with prefix. Changes are
+// sent as Put, Write and Delete return, and those of a batch when it is
+// committed. Writes that bypass them, such as Forget and orphan repair,
+// are not sent.
+func (s *Store) Watch(prefix []byte) *Watcher {
+	c := make(chan Change, WatchBuffer)
+	w := &Watcher{C: c, c: c, store: s, prefix: bytes.Clone(prefix)}
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if s.closed {
+		close(c)
+	} else {
+		s.watchers = append(s.watchers, w)
+	}
+	return w
+}
+
+// Close stops the watcher and closes C.
+func (w *Watcher) Close() {
+	w.store.watchMu.Lock()
+	defer w.store.watchMu.Unlock()
+	w.store.removeWatcher(w)
+}
+
+// Err returns ErrWatchOverflow if the watcher was closed for falling
+// behind, and nil otherwise.
+func (w *Watcher) Err() error {
+	w.store.watchMu.Lock()
+	defer w.store.watchMu.Unlock()
+	return w.err
+}
+
+// removeWatcher closes w if it is still open. The caller holds watchMu.
+func (s *Store) removeWatcher(w *Watcher) {
+	i := slices.Index(s.watchers, w)
+	if i < 0 {
+		return
+	}
+	s.watchers = slices.Delete(s.watchers, i, i+1)
+	close(w.c)
+}
+
+// watched reports whether a watcher covers key, so that writes only look
+// up the old value when someone will see it.
+func (s *Store) watched(key []byte) bool {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	return slices.ContainsFunc(s.watchers, func(w *Watcher) bool {
+		return bytes.HasPrefix(key, w.prefix)
+	})
+}
+
+// notify sends a change to the watchers of its key, or holds it until the
+// open batch is committed.
+func (s *Store) notify(change Change) {
+	if s.batch != nil {
+		s.batch.changes = append(s.batch.changes, change)
+		return
+	}
+	s.deliver(change)
+}
+
+func (s *Store) deliver(changes ...Change) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for _, change := range changes {
+		for _, w := range slices.Clone(s.watchers) {
+			if !bytes.HasPrefix(change.Key, w.prefix) {
+				continue
+			}
+			select {
+			case w.c <- change:
+			default:
+				w.err = ErrWatchOverflow
+				s.removeWatcher(w)
+			}
+		}
+	}
+}
+
+// closeWatchers closes every watcher as the store closes.
+func (s *Store) closeWatchers() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for _, w := range s.watchers {
+		close(w.c)
+	}
+	s.watchers = nil
+	s.closed = true
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// drain returns the changes a watcher holds without waiting for more.
+func drain(w *Watcher) []Change {
+	var changes []Change
+	for {
+		select {
+		case change, ok := <-w.C:
+			if !ok {
+				return changes
+			}
+			changes = append(changes, change)
+		default:
+			return changes
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	store := newTestStore(t)
+	defer store.Close()
+
+	w := store.Watch([]byte("user/"))
+	steps := []func() error{
+		func() error { return store.Put([]byte("user/1"), []byte("alice")) },
+		func() error { return store.Put([]byte("other"), []byte("ignored")) },
+		func() error { return store.Put([]byte("user/1"), []byte("alicia")) },
+		func() error { _, err := store.Delete([]byte("user/1")); return err },
+		func() error { _, err := store.Delete([]byte("user/missing")); return err },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	expected := []Change{
+		{Op: ChangePut, Key: []byte("user/1"), NewValue: []byte("alice")},
+		{Op: ChangePut, Key: []byte("user/1"), OldValue: []byte("alice"), NewValue: []byte("alicia")},
+		{Op: ChangeDelete, Key: []byte("user/1"), OldValue: []byte("alicia")},
+	}
+	if changes := drain(w); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+
+	// Batches are seen when they commit, and aborted ones never.
+	for _, commit := range []bool{false, true} {
+		if err := store.BeginBatch(); err != nil {
+			t.Fatalf("failed to begin batch: %v", err)
+		}
+		if err := store.Put([]byte("user/2"), []byte("bob")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+		if changes := drain(w); len(changes) != 0 {
+			t.Errorf("expected no changes before the batch ends, got %+v", changes)
+		}
+		end := store.AbortBatch
+		if commit {
+			end = store.CommitBatch
+		}
+		if err := end(); err != nil {
+			t.Fatalf("failed to end batch: %v", err)
+		}
+	}
+	expected = []Change{{Op: ChangePut, Key: []byte("user/2"), NewValue: []byte("bob")}}
+	if changes := drain(w); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected only the committed batch, %+v, got %+v", expected, changes)
+	}
+
+	w.Close()
+	if _, ok := <-w.C; ok {
+		t.Errorf("expected C to be closed")
+	}
+	if err := store.Put([]byte("user/3"), []byte("carol")); err != nil {
+		t.Fatalf("failed to put after close: %v", err)
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	store := newTestStore(t)
+	slow := store.Watch(nil)
+	fast := store.Watch(nil)
+	for i := range WatchBuffer + 1 {
+		if err := store.Put(fmt.Appendf(nil, "key_%d", i), []byte("value")); err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+		drain(fast)
+	}
+
+	if changes := drain(slow); len(changes) != WatchBuffer {
+		t.Errorf("expected the %d buffered changes before C closes, got %d", WatchBuffer, len(changes))
+	}
+	if err := slow.Err(); !errors.Is(err, ErrWatchOverflow) {
+		t.Errorf("expected %v, got %v", ErrWatchOverflow, err)
+	}
+
+	store.Close()
+	if _, ok := <-fast.C; ok || fast.Err() != nil {
+		t.Errorf("expected closing the store to close its watchers cleanly, got %v", fast.Err())
+	}
+	if _, ok := <-store.Watch(nil).C; ok {
+		t.Errorf("expected a watcher of a closed store to be closed")
+	}
+}
@@ -0,0 +1,77 @@
+// Package trace lets embedders observe the internals of a database
+// without forking it: a Logger for the errors of work nobody waits for,
+// and Hooks called as index pages are read and written, index nodes split
+// and merge, and pagers flush.
+package trace
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Logger receives structured log records, with args as alternating keys
+// and values. *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// OrDefault returns l, or slog.Default() if l is nil.
+func OrDefault(l Logger) Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// Hooks are called as operations happen, on the goroutine doing them, so
+// they must be quick and must not call back into the database. A nil
+// *Hooks and nil fields are skipped.
+type Hooks struct {
+	// OnPageRead is called when a page is read from its file, which is
+	// when it was not cached.
+	OnPageRead func(page uint32)
+	// OnPageWrite is called when a page is written to its file.
+	OnPageWrite func(page uint32)
+	// OnFlush is called when a flush wrote dirty pages to the file, with
+	// how many it wrote, how long it took and the error it returns.
+	OnFlush func(pages int, took time.Duration, err error)
+	// OnSplit is called when an index node is split, with the page its
+	// lower half is on and the new page of its upper half.
+	OnSplit func(page, sibling uint32, leaf bool)
+	// OnMerge is called when two neighbouring index nodes are merged, with
+	// the page of the merged node and the page that was freed.
+	OnMerge func(page, freed uint32, leaf bool)
+}
+
+func (h *Hooks) PageRead(page uint32) {
+	if h != nil && h.OnPageRead != nil {
+		h.OnPageRead(page)
+	}
+}
+
+func (h *Hooks) PageWrite(page uint32) {
+	if h != nil && h.OnPageWrite != nil {
+		h.OnPageWrite(page)
+	}
+}
+
+func (h *Hooks) Flush(pages int, took time.Duration, err error) {
+	if h != nil && h.OnFlush != nil {
+		h.OnFlush(pages, took, err)
+	}
+}
+
+func (h *Hooks) Split(page, sibling uint32, leaf bool) {
+	if h != nil && h.OnSplit != nil {
+		h.OnSplit(page, sibling, leaf)
+	}
+}
+
+func (h *Hooks) Merge(page, freed uint32, leaf bool) {
+	if h != nil && h.OnMerge != nil {
+		h.OnMerge(page, freed, leaf)
+	}
+}
@@ -0,0 +1,35 @@
+package trace
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	// Nil hooks and hooks without a callback are skipped.
+	var none *Hooks
+	none.PageRead(1)
+	(&Hooks{}).Flush(1, time.Second, nil)
+
+	var calls []string
+	h := &Hooks{
+		OnPageRead:  func(page uint32) { calls = append(calls, "read") },
+		OnPageWrite: func(page uint32) { calls = append(calls, "write") },
+		OnFlush:     func(pages int, took time.Duration, err error) { calls = append(calls, "flush") },
+		OnSplit:     func(page, sibling uint32, leaf bool) { calls = append(calls, "split") },
+		OnMerge:     func(page, freed uint32, leaf bool) { calls = append(calls, "merge") },
+	}
+	h.PageRead(1)
+	h.PageWrite(1)
+	h.Flush(1, time.Second, nil)
+	h.Split(1, 2, true)
+	h.Merge(1, 2, true)
+	if len(calls) != 5 {
+		t.Errorf("expected every hook to be called, got %v", calls)
+	}
+
+	if OrDefault(nil) != slog.Default() {
+		t.Errorf("expected the default logger for nil")
+	}
+}
@@ -0,0 +1,63 @@
+package tuple
+
+import (
+	"bytes"
+	"cmp"
+	"strings"
+	"time"
+)
+
+// Compare orders two values of the same column type, returning -1, 0 or +1.
+// NULL sorts before every other value and false before true. Values of
+// different types are ordered by type so that the result is always
+// consistent.
+func Compare(a, b Value) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return cmp.Compare(ra, rb)
+	}
+
+	switch a := a.(type) {
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		switch b := b.(bool); {
+		case a == b:
+			return 0
+		case b:
+			return -1
+		default:
+			return 1
+		}
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return 0
+	}
+}
+
+func typeRank(v Value) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int64:
+		return 2
+	case float64:
+		return 3
+	case time.Time:
+		return 4
+	case string:
+		return 5
+	case []byte:
+		return 6
+	default:
+		return 7
+	}
+}
@@ -0,0 +1,39 @@
+package tuple
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		a, b     Value
+		expected int
+	}{
+		{"equal ints", int64(3), int64(3), 0},
+		{"negative int", int64(-5), int64(2), -1},
+		{"floats", float64(2.5), float64(-1), 1},
+		{"infinity", math.Inf(1), float64(1e300), 1},
+		{"strings", "apple", "banana", -1},
+		{"prefix string", "app", "apple", -1},
+		{"bools", false, true, -1},
+		{"equal bools", true, true, 0},
+		{"blobs", []byte{1, 2}, []byte{1, 3}, -1},
+		{"timestamps", now.Add(time.Second), now, 1},
+		{"null first", nil, int64(math.MinInt64), -1},
+		{"both null", nil, nil, 0},
+		{"value after null", "", nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Compare(tt.a, tt.b); got != tt.expected {
+				t.Errorf("Compare(%v, %v) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package tuple
+
+import "github.com/rizalta/toydb/catalog"
+
+// DeserializeWithMapping reads a row that was written with writtenSchema and
+// returns it laid out for currentSchema. Columns are matched by name:
+// columns that were dropped are discarded and columns that were added since
+// the row was written take their default value, or NULL. A column whose
+// type changed cannot be mapped and returns ErrTypeMismatch.
+func DeserializeWithMapping(data []byte, writtenSchema, currentSchema *catalog.Schema) (Tuple, error) {
+	written, err := Deserialize(data, writtenSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	writtenColumns := make(map[string]int, len(writtenSchema.Columns))
+	for i, c := range writtenSchema.Columns {
+		writtenColumns[c.Name] = i
+	}
+
+	tuple := make(Tuple, len(currentSchema.Columns))
+	for i, c := range currentSchema.Columns {
+		j, found := writtenColumns[c.Name]
+		if !found {
+			tuple[i] = c.DefaultValue
+			continue
+		}
+		if writtenSchema.Columns[j].Type != c.Type {
+			return nil, ErrTypeMismatch
+		}
+		tuple[i] = written[j]
+	}
+
+	return tuple, nil
+}
@@ -0,0 +1,78 @@
+package tuple
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/rizalta/toydb/catalog"
+)
+
+func TestDeserializeWithMapping(t *testing.T) {
+	written := &catalog.Schema{
+		ID:   1,
+		Name: "users",
+		Columns: []catalog.Column{
+			{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+			{Name: "name", Type: catalog.TypeVarChar},
+			{Name: "legacy", Type: catalog.TypeBoolean},
+			{Name: "score", Type: catalog.TypeFloat},
+		},
+	}
+
+	data, err := Serialize(Tuple{int64(7), "alice", true, 2.5}, written)
+	if err != nil {
+		t.Fatalf("failed to serialize tuple: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		columns  []catalog.Column
+		expected Tuple
+		err      error
+	}{
+		{
+			name:     "Test_same_schema",
+			columns:  written.Columns,
+			expected: Tuple{int64(7), "alice", true, 2.5},
+		},
+		{
+			name: "Test_reordered_dropped_added",
+			columns: []catalog.Column{
+				{Name: "score", Type: catalog.TypeFloat},
+				{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+				{Name: "role", Type: catalog.TypeVarChar, DefaultValue: "member"},
+				{Name: "email", Type: catalog.TypeVarChar},
+				{Name: "name", Type: catalog.TypeVarChar},
+			},
+			expected: Tuple{2.5, int64(7), "member", nil, "alice"},
+		},
+		{
+			name: "Test_type_changed",
+			columns: []catalog.Column{
+				{Name: "id", Type: catalog.TypeInt, IsPrimaryKey: true, IsNotNull: true},
+				{Name: "score", Type: catalog.TypeInt},
+			},
+			err: ErrTypeMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := &catalog.Schema{ID: 1, Name: "users", Columns: tt.columns}
+			tuple, err := DeserializeWithMapping(data, written, current)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to deserialize: %v", err)
+			}
+			if !reflect.DeepEqual(tuple, tt.expected) {
+				t.Errorf("expected tuple %v, got %v", tt.expected, tuple)
+			}
+		})
+	}
+}
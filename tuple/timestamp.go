@@ -0,0 +1,56 @@
+package tuple
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var ErrInvalidTimestamp = errors.New("tuple: invalid timestamp")
+
+// Timestamps are stored as nanoseconds since the Unix epoch, so only times
+// between the years 1678 and 2262 can be represented.
+var (
+	minTimestamp = time.Unix(0, math.MinInt64)
+	maxTimestamp = time.Unix(0, math.MaxInt64)
+)
+
+// TimestampNanos returns t as the nanoseconds since the Unix epoch it is
+// stored as, or ErrInvalidTimestamp if it is out of range.
+func TimestampNanos(t time.Time) (int64, error) {
+	if t.Before(minTimestamp) || t.After(maxTimestamp) {
+		return 0, ErrInvalidTimestamp
+	}
+	return t.UnixNano(), nil
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ParseTimestamp parses RFC 3339 timestamps as well as the common
+// "YYYY-MM-DD hh:mm:ss" and "YYYY-MM-DD" forms. Times without a zone are
+// taken to be UTC. The result is in UTC like the values read from a tuple.
+// Times that can not be stored are rejected like malformed ones.
+func ParseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		if _, err := TimestampNanos(t); err != nil {
+			return time.Time{}, err
+		}
+		return t.UTC(), nil
+	}
+	return time.Time{}, ErrInvalidTimestamp
+}
+
+// FormatTimestamp formats a timestamp as RFC 3339 in UTC with as much
+// sub-second precision as needed.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
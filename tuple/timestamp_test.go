@@ -0,0 +1,138 @@
+package tuple
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rizalta/toydb/catalog"
+)
+
+func TestSerializeTimestamp(t *testing.T) {
+	schema := &catalog.Schema{
+		ID:   1,
+		Name: "events",
+		Columns: []catalog.Column{
+			{Name: "at", Type: catalog.TypeTimestamp, IsPrimaryKey: true, IsNotNull: true},
+			{Name: "until", Type: catalog.TypeTimestamp},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		tuple Tuple
+		err   error
+	}{
+		{
+			name:  "Test_timestamps",
+			tuple: Tuple{time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC), nil},
+		},
+		{
+			name:  "Test_before_epoch",
+			tuple: Tuple{time.Date(1901, 12, 13, 20, 45, 52, 0, time.UTC), time.Unix(0, 0).UTC()},
+		},
+		{
+			name:  "Test_range_ends",
+			tuple: Tuple{time.Unix(0, math.MinInt64).UTC(), time.Unix(0, math.MaxInt64).UTC()},
+		},
+		{
+			name:  "Test_wrong_type",
+			tuple: Tuple{int64(1), nil},
+			err:   ErrTypeMismatch,
+		},
+		{
+			name:  "Test_zero_time",
+			tuple: Tuple{time.Time{}, nil},
+			err:   ErrInvalidTimestamp,
+		},
+		{
+			name:  "Test_year_3000",
+			tuple: Tuple{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)},
+			err:   ErrInvalidTimestamp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Serialize(tt.tuple, schema)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to serialize tuple: %v", err)
+			}
+
+			tuple, err := Deserialize(data, schema)
+			if err != nil {
+				t.Fatalf("failed to deserialize tuple: %v", err)
+			}
+			if !reflect.DeepEqual(tt.tuple, tuple) {
+				t.Errorf("expected tuple %v, got %v", tt.tuple, tuple)
+			}
+		})
+	}
+}
+
+func TestParseFormatTimestamp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Time
+		output   string
+		err      error
+	}{
+		{
+			input:    "2024-03-01T12:30:00.5Z",
+			expected: time.Date(2024, 3, 1, 12, 30, 0, 500000000, time.UTC),
+			output:   "2024-03-01T12:30:00.5Z",
+		},
+		{
+			input:    "2024-03-01T14:30:00+02:00",
+			expected: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+			output:   "2024-03-01T12:30:00Z",
+		},
+		{
+			input:    "2024-03-01 12:30:00",
+			expected: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+			output:   "2024-03-01T12:30:00Z",
+		},
+		{
+			input:    "2024-03-01",
+			expected: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			output:   "2024-03-01T00:00:00Z",
+		},
+		{
+			input: "yesterday",
+			err:   ErrInvalidTimestamp,
+		},
+		{
+			input: "3000-01-01",
+			err:   ErrInvalidTimestamp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ts, err := ParseTimestamp(tt.input)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse %s: %v", tt.input, err)
+			}
+			if !ts.Equal(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, ts)
+			}
+			if out := FormatTimestamp(ts); out != tt.output {
+				t.Errorf("expected %s, got %s", tt.output, out)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"time"
 
 	"github.com/rizalta/toydb/catalog"
 )
@@ -70,6 +71,17 @@ func Serialize(tuple Tuple, schema *catalog.Schema) ([]byte, error) {
 			}
 			encoded = make([]byte, 8)
 			binary.LittleEndian.PutUint64(encoded, math.Float64bits(val))
+		case catalog.TypeTimestamp:
+			val, ok := value.(time.Time)
+			if !ok {
+				return nil, ErrTypeMismatch
+			}
+			nanos, err := TimestampNanos(val)
+			if err != nil {
+				return nil, err
+			}
+			encoded = make([]byte, 8)
+			binary.LittleEndian.PutUint64(encoded, uint64(nanos))
 		default:
 			return nil, ErrTypeMismatch
 		}
@@ -171,6 +183,11 @@ func Deserialize(data []byte, schema *catalog.Schema) (Tuple, error) {
 				return nil, ErrCorruptData
 			}
 			value = math.Float64frombits(binary.LittleEndian.Uint64(valueBytes))
+		case catalog.TypeTimestamp:
+			if len(valueBytes) != 8 {
+				return nil, ErrCorruptData
+			}
+			value = time.Unix(0, int64(binary.LittleEndian.Uint64(valueBytes))).UTC()
 		default:
 			return nil, ErrCorruptData
 		}